@@ -0,0 +1,84 @@
+package client
+
+import (
+	"backend/models"
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// The AI endpoints need the user's provider key: set it with WithAPIKey.
+
+// Chat asks a one-off question about the given notes; nothing is stored
+func (c *Client) Chat(ctx context.Context, req *models.ChatRequest) (string, error) {
+	var resp struct {
+		Response string `json:"response"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/chat", nil, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.Response, nil
+}
+
+// RelevantNotes picks the notes most relevant to the current content
+func (c *Client) RelevantNotes(ctx context.Context, req *models.RelevantNotesRequest) ([]models.Note, error) {
+	var resp struct {
+		RelevantNotes []models.Note `json:"relevantNotes"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/notes/relevant", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.RelevantNotes, nil
+}
+
+// CleanUp tidies up and formats note content
+func (c *Client) CleanUp(ctx context.Context, req *models.CleanupRequest) (string, error) {
+	var resp struct {
+		CleanedContent string `json:"cleanedContent"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/notes/cleanup", nil, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.CleanedContent, nil
+}
+
+// SmartAppend merges a quick capture into the best-fitting section of a note
+func (c *Client) SmartAppend(ctx context.Context, req *models.SmartAppendRequest) (*models.SmartAppendResponse, error) {
+	var resp models.SmartAppendResponse
+	if err := c.do(ctx, http.MethodPost, "/api/notes/append-smart", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CountTokens counts the tokens of contents per model before sending them to the AI
+func (c *Client) CountTokens(ctx context.Context, req *models.CountTokensRequest) (*models.CountTokensResponse, error) {
+	var resp models.CountTokensResponse
+	if err := c.do(ctx, http.MethodPost, "/api/ai/count-tokens", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateChatSession starts a persisted chat session
+func (c *Client) CreateChatSession(ctx context.Context, title string) (*models.ChatSession, error) {
+	var session models.ChatSession
+	if err := c.do(ctx, http.MethodPost, "/api/chat/sessions", nil, models.CreateChatSessionRequest{Title: title}, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SendChatMessage asks a question in a chat session; the server adds the session's earlier turns
+func (c *Client) SendChatMessage(ctx context.Context, sessionID string, req *models.ChatSessionMessageRequest) (*models.ChatTurnResponse, error) {
+	var resp models.ChatTurnResponse
+	if err := c.do(ctx, http.MethodPost, "/api/chat/sessions/"+url.PathEscape(sessionID)+"/messages", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteChatSession deletes a chat session and its messages
+func (c *Client) DeleteChatSession(ctx context.Context, sessionID string) error {
+	return c.do(ctx, http.MethodDelete, "/api/chat/sessions/"+url.PathEscape(sessionID), nil, nil, nil)
+}
@@ -0,0 +1,233 @@
+// Package client is a typed Go client for the Jottin backend API.
+//
+// It shares its request and response types with the server (backend/models), so integrations and
+// the CLI stay in step with the API. Note content is end-to-end encrypted: the client sends and
+// receives contentEncrypted/contentIV as-is and never sees plaintext unless the caller decrypts it.
+//
+//	c := client.New("https://api.example.com", client.WithToken(sessionToken))
+//	resp, err := c.PullNotes(ctx, nil)
+package client
+
+import (
+	"backend/models"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimeout bounds requests made with the default HTTP client. AI calls can take up to a
+// couple of minutes server-side (audio transcription), so it is generous.
+const defaultTimeout = 3 * time.Minute
+
+// TokenSource returns the bearer token (a Clerk session JWT) to authenticate a request with.
+// It's called for every request, so it can refresh short-lived tokens.
+type TokenSource func(ctx context.Context) (string, error)
+
+// Client calls the Jottin backend API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      TokenSource
+	apiKey     string
+	deviceID   string
+
+	signingKeyID  string
+	signingSecret []byte
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client requests are made with
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithToken authenticates every request with a fixed bearer token
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = func(context.Context) (string, error) { return token, nil }
+	}
+}
+
+// WithTokenSource authenticates every request with a token fetched per request
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) { c.token = source }
+}
+
+// WithAPIKey sets the user's AI provider key sent (as X-API-Key) to the AI endpoints
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) { c.apiKey = apiKey }
+}
+
+// WithDeviceID identifies this device (as X-Device-ID) for sync lag diagnostics and conflict copies
+func WithDeviceID(deviceID string) Option {
+	return func(c *Client) { c.deviceID = deviceID }
+}
+
+// WithSigningKey signs every request with a registered signing key (see CreateSigningKey).
+// secret is the base64 secret returned when the key was created.
+func WithSigningKey(keyID, secret string) Option {
+	return func(c *Client) {
+		decoded, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			decoded = nil // Requests then fail server-side with "Invalid request signature"
+		}
+		c.signingKeyID, c.signingSecret = keyID, decoded
+	}
+}
+
+// New creates a Client for the API at baseURL (e.g. "https://api.example.com")
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is returned for non-2xx responses
+type APIError struct {
+	StatusCode int
+	Message    string
+	Code       string        // Machine-readable error code, e.g. models.ErrCodeRateLimited
+	RetryAfter time.Duration // From the Retry-After header, if any
+	RequestID  string        // X-Request-ID, for matching the failure with server logs
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("jottin: %d %s", e.StatusCode, e.Message)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.RequestID != "" {
+		msg += " [request " + e.RequestID + "]"
+	}
+	return msg
+}
+
+// IsStatus reports whether err is an APIError with the given HTTP status
+func IsStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// SignRequest computes the X-Signature of a request: hex(HMAC-SHA256(secret,
+// timestamp + "\n" + METHOD + "\n" + path?query + "\n" + hex(sha256(body)))).
+func SignRequest(secret []byte, timestamp int64, method, requestURI string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	canonical := strconv.FormatInt(timestamp, 10) + "\n" + method + "\n" + requestURI + "\n" + hex.EncodeToString(bodyHash[:])
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// do sends a JSON request and decodes a JSON response into out (if non-nil)
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("jottin: failed to encode request: %w", err)
+		}
+	}
+
+	requestURI := path
+	if len(query) > 0 {
+		requestURI += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+requestURI, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := c.authorize(req, body); err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("jottin: failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// authorize adds the auth, device, and signing headers to a request
+func (c *Client) authorize(req *http.Request, body []byte) error {
+	if c.token != nil {
+		token, err := c.token(req.Context())
+		if err != nil {
+			return fmt.Errorf("jottin: failed to get token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.deviceID != "" {
+		req.Header.Set("X-Device-ID", c.deviceID)
+	}
+	if c.signingKeyID != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set("X-Signature-Key-ID", c.signingKeyID)
+		req.Header.Set("X-Signature-Timestamp", strconv.FormatInt(timestamp, 10))
+		req.Header.Set("X-Signature", SignRequest(c.signingSecret, timestamp, req.Method, req.URL.RequestURI(), body))
+	}
+	return nil
+}
+
+// newAPIError builds an APIError from an error response
+func newAPIError(resp *http.Response) error {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return apiErr
+	}
+	var body models.ErrorResponse
+	if json.Unmarshal(payload, &body) == nil && body.Error != "" {
+		apiErr.Message, apiErr.Code = body.Error, body.Code
+		if body.RetryAfter > 0 && apiErr.RetryAfter == 0 {
+			apiErr.RetryAfter = time.Duration(body.RetryAfter) * time.Second
+		}
+	} else if text := strings.TrimSpace(string(payload)); text != "" {
+		apiErr.Message = text // Plain http.Error responses (e.g. 405)
+	}
+	return apiErr
+}
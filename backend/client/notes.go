@@ -0,0 +1,82 @@
+package client
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// PatchNoteMeta changes a note's plaintext metadata (pinned, archived, collections, tags, reminder)
+// without re-uploading its encrypted body
+func (c *Client) PatchNoteMeta(ctx context.Context, noteID string, req *models.PatchNoteMetaRequest) (*models.NoteMeta, error) {
+	var meta models.NoteMeta
+	if err := c.do(ctx, http.MethodPatch, "/api/notes/"+url.PathEscape(noteID)+"/meta", nil, req, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// SemanticSearch finds the notes closest in meaning to a query (uses the API key if set, otherwise
+// the user's stored Gemini key)
+func (c *Client) SemanticSearch(ctx context.Context, req *models.SemanticSearchRequest) (*models.SemanticSearchResponse, error) {
+	var resp models.SemanticSearchResponse
+	if err := c.do(ctx, http.MethodPost, "/api/notes/semantic-search", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListCollections fetches one page of collections; pass the previous page's NextCursor as cursor
+// ("" for the first page) and limit 0 for the server default
+func (c *Client) ListCollections(ctx context.Context, limit int, cursor string) (*pagination.Page[models.SyncCollection], error) {
+	var page pagination.Page[models.SyncCollection]
+	if err := c.do(ctx, http.MethodGet, "/api/collections", pageQuery(limit, cursor), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// CreateCollection creates a collection
+func (c *Client) CreateCollection(ctx context.Context, req *models.CreateCollectionRequest) (*models.SyncCollection, error) {
+	var collection models.SyncCollection
+	if err := c.do(ctx, http.MethodPost, "/api/collections", nil, req, &collection); err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// UpdateCollection changes the given fields of a collection
+func (c *Client) UpdateCollection(ctx context.Context, collectionID string, req *models.UpdateCollectionRequest) (*models.SyncCollection, error) {
+	var collection models.SyncCollection
+	if err := c.do(ctx, http.MethodPatch, "/api/collections/"+url.PathEscape(collectionID), nil, req, &collection); err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// DeleteCollection deletes a collection, handling its notes by the request's policy (orphan if nil)
+func (c *Client) DeleteCollection(ctx context.Context, collectionID string, req *models.DeleteCollectionRequest) (*models.DeleteCollectionResponse, error) {
+	if req == nil {
+		req = &models.DeleteCollectionRequest{Policy: models.CascadeOrphan}
+	}
+	var resp models.DeleteCollectionResponse
+	if err := c.do(ctx, http.MethodDelete, "/api/collections/"+url.PathEscape(collectionID), nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// pageQuery returns the ?limit=&cursor= query of a paginated list
+func pageQuery(limit int, cursor string) url.Values {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	return query
+}
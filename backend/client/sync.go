@@ -0,0 +1,117 @@
+package client
+
+import (
+	"backend/models"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PullNotes fetches the user's notes and collections, only those changed after since if it's set
+func (c *Client) PullNotes(ctx context.Context, since *time.Time) (*models.SyncResponse, error) {
+	var resp models.SyncResponse
+	if err := c.do(ctx, http.MethodGet, "/api/sync/notes", sinceQuery(since), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PullNotesPage fetches one page of the notes changed after since (all notes if it's nil), oldest
+// change first. Pass the response's NextCursor back as cursor, with the same since, until it's empty;
+// collections come only with the first page.
+func (c *Client) PullNotesPage(ctx context.Context, since *time.Time, limit int, cursor string) (*models.SyncResponse, error) {
+	query := sinceQuery(since)
+	query.Set("limit", strconv.Itoa(limit))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	var resp models.SyncResponse
+	if err := c.do(ctx, http.MethodGet, "/api/sync/notes", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PullNoteFields fetches only the given note fields (e.g. "id", "title", "updatedAt"), skipping the
+// encrypted bodies for lightweight views
+func (c *Client) PullNoteFields(ctx context.Context, since *time.Time, fields ...string) (*models.PartialSyncResponse, error) {
+	query := sinceQuery(since)
+	query.Set("fields", strings.Join(fields, ","))
+
+	var resp models.PartialSyncResponse
+	if err := c.do(ctx, http.MethodGet, "/api/sync/notes", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Push creates, updates, and deletes notes and collections. Notes with deletedAt set are
+// soft-deleted; set baseUpdatedAt on edits to have overwritten changes reported in Conflicts.
+func (c *Client) Push(ctx context.Context, req *models.SyncRequest) (*models.SyncResponse, error) {
+	var resp models.SyncResponse
+	if err := c.do(ctx, http.MethodPost, "/api/sync/push", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PushNotes creates or updates notes
+func (c *Client) PushNotes(ctx context.Context, notes ...models.SyncNote) (*models.SyncResponse, error) {
+	return c.Push(ctx, &models.SyncRequest{Notes: notes, Collections: []models.SyncCollection{}})
+}
+
+// DeleteNotes soft-deletes notes by ID
+func (c *Client) DeleteNotes(ctx context.Context, noteIDs ...string) (*models.SyncResponse, error) {
+	now := time.Now()
+	notes := make([]models.SyncNote, len(noteIDs))
+	for i, id := range noteIDs {
+		notes[i] = models.SyncNote{ID: id, DeletedAt: &now, UpdatedAt: now}
+	}
+	return c.PushNotes(ctx, notes...)
+}
+
+// Verify fetches the server's digest of the user's notes to detect divergence
+func (c *Client) Verify(ctx context.Context) (*models.SyncVerifyResponse, error) {
+	var resp models.SyncVerifyResponse
+	if err := c.do(ctx, http.MethodGet, "/api/sync/verify", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Repair sends the client's note hashes and returns only the notes it's missing or holds stale
+func (c *Client) Repair(ctx context.Context, req *models.SyncRepairRequest) (*models.SyncRepairResponse, error) {
+	var resp models.SyncRepairResponse
+	if err := c.do(ctx, http.MethodPost, "/api/sync/repair", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateSigningKey registers a request signing key for this device. Once a user has a key, every
+// sync request must be signed: pass the returned ID and secret to WithSigningKey.
+func (c *Client) CreateSigningKey(ctx context.Context, label string) (*models.SigningKeyResponse, error) {
+	var resp models.SigningKeyResponse
+	if err := c.do(ctx, http.MethodPost, "/api/sync/signing-keys", nil, models.CreateSigningKeyRequest{Label: label}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RevokeSigningKey revokes a signing key
+func (c *Client) RevokeSigningKey(ctx context.Context, keyID string) error {
+	return c.do(ctx, http.MethodDelete, "/api/sync/signing-keys/"+url.PathEscape(keyID), nil, nil, nil)
+}
+
+// sinceQuery returns the ?since= query for an optional timestamp
+func sinceQuery(since *time.Time) url.Values {
+	query := url.Values{}
+	if since != nil {
+		query.Set("since", since.Format(time.RFC3339Nano))
+	}
+	return query
+}
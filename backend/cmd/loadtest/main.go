@@ -0,0 +1,597 @@
+// Simulates users syncing notes against a running backend and reports latency percentiles and errors
+//
+// Usage:
+//
+//	loadtest [flags] <base URL>
+//
+// Each simulated user signs in with one bearer token from the --tokens file (one per line, e.g.
+// long-lived session tokens from a staging Clerk instance), seeds --notes notes in pushes of --batch,
+// and then, until --duration has passed, repeatedly edits and creates notes, pulls changes, and does
+// full paged pulls, pausing around --think between operations like a real client. Notes carry random
+// bytes in place of encrypted content. The run's notes are deleted at the end unless --cleanup=false.
+// Point it at a staging deployment: it adds real notes to the token owners' accounts and counts
+// against their rate limits.
+package main
+
+import (
+	"backend/client"
+	"backend/models"
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: loadtest [flags] <base URL>
+
+Simulates users pushing and pulling notes against the backend at the base URL and reports latency
+percentiles and error rates per operation.
+
+flags:`
+
+// Operations measured by the load test
+const (
+	opSeed     = "seed_push"  // Pushes creating the seeded notes
+	opEdit     = "push_edit"  // Pushes editing a few existing notes, with baseUpdatedAt
+	opCreate   = "push_new"   // Pushes creating a few notes
+	opPull     = "pull_delta" // Pulls of the changes since the user's last sync
+	opPagePull = "pull_paged" // One page of a full paged pull
+	opCleanup  = "cleanup"    // Pushes deleting the run's notes
+)
+
+// reportOrder is the order operations are reported in
+var reportOrder = []string{opSeed, opEdit, opCreate, opPull, opPagePull, opCleanup}
+
+// operationWeights is the mix of operations in the timed phase, as relative frequencies
+var operationWeights = []struct {
+	op     string
+	weight int
+}{
+	{opEdit, 40},
+	{opCreate, 15},
+	{opPull, 35},
+	{opPagePull, 10},
+}
+
+// Note content sizes, drawn from a log-normal distribution around the median (--note-size)
+const (
+	noteSizeSpread = 0.8 // Standard deviation of the log of the size
+	minNoteSize    = 64
+	maxNoteSize    = 256 << 10
+)
+
+// maxRetryWait caps how long a rate-limited user waits before its next operation
+const maxRetryWait = 30 * time.Second
+
+// config holds the command's flags
+type config struct {
+	baseURL  string
+	tokens   []string
+	users    int
+	duration time.Duration
+	notes    int
+	batch    int
+	pageSize int
+	think    time.Duration
+	noteSize int
+	timeout  time.Duration
+	cleanup  bool
+	json     bool
+	seed     uint64
+}
+
+func main() {
+	var cfg config
+	var tokensFile string
+	flag.StringVar(&tokensFile, "tokens", "", "file with one bearer token per line, one per simulated user (required)")
+	flag.IntVar(&cfg.users, "users", 0, "simulated users (default: one per token)")
+	flag.DurationVar(&cfg.duration, "duration", time.Minute, "length of the timed phase, after seeding")
+	flag.IntVar(&cfg.notes, "notes", 200, "notes seeded per user")
+	flag.IntVar(&cfg.batch, "batch", 50, "notes per seeding push")
+	flag.IntVar(&cfg.pageSize, "page", 50, "page size of paged pulls (at most 200)")
+	flag.DurationVar(&cfg.think, "think", 2*time.Second, "mean pause between a user's operations")
+	flag.IntVar(&cfg.noteSize, "note-size", 2048, "median note content size in bytes")
+	flag.DurationVar(&cfg.timeout, "timeout", 30*time.Second, "timeout of a single request")
+	flag.BoolVar(&cfg.cleanup, "cleanup", true, "delete the run's notes when it ends")
+	flag.BoolVar(&cfg.json, "json", false, "print the report as JSON")
+	flag.Uint64Var(&cfg.seed, "seed", 0, "random seed, for a repeatable operation mix (default: random)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || tokensFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.baseURL = flag.Arg(0)
+
+	var err error
+	if cfg.tokens, err = readTokens(tokensFile); err != nil {
+		log.Fatalf("Failed to read tokens: %v", err)
+	}
+	if cfg.users == 0 {
+		cfg.users = len(cfg.tokens)
+	}
+	switch {
+	case cfg.users > len(cfg.tokens):
+		log.Fatalf("%d users need as many tokens, but %s has %d", cfg.users, tokensFile, len(cfg.tokens))
+	case cfg.users <= 0 || cfg.duration <= 0 || cfg.notes < 0 || cfg.batch <= 0 || cfg.noteSize <= 0 || cfg.think < 0:
+		log.Fatal("users, duration, batch, and note-size must be positive, and notes and think not negative")
+	case cfg.pageSize <= 0 || cfg.pageSize > 200:
+		log.Fatal("page must be between 1 and 200")
+	}
+	if cfg.seed == 0 {
+		cfg.seed = rand.Uint64()
+	}
+
+	// Ctrl-C ends the timed phase early; the run's notes are still cleaned up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report := run(ctx, cfg)
+	if cfg.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+		return
+	}
+	printReport(report)
+}
+
+// readTokens reads the non-empty lines of a file
+func readTokens(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 64<<10)
+	for scanner.Scan() {
+		if token := strings.TrimSpace(scanner.Text()); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, scanner.Err()
+}
+
+// run seeds every user's notes, runs the timed phase, and cleans up
+func run(ctx context.Context, cfg config) report {
+	rec := newRecorder()
+	runID := strconv.FormatUint(cfg.seed, 36)
+	users := make([]*simUser, cfg.users)
+	for i := range users {
+		users[i] = &simUser{
+			cfg: cfg,
+			client: client.New(cfg.baseURL,
+				client.WithToken(cfg.tokens[i]),
+				client.WithDeviceID(fmt.Sprintf("loadtest-%s-%d", runID, i)),
+				client.WithHTTPClient(&http.Client{Timeout: cfg.timeout}),
+			),
+			rec:    rec,
+			rng:    rand.New(rand.NewPCG(cfg.seed, uint64(i))),
+			prefix: fmt.Sprintf("loadtest-%s-%d-", runID, i),
+		}
+	}
+
+	log.Printf("Seeding %d notes for each of %d users", cfg.notes, cfg.users)
+	seedStart := time.Now()
+	eachUser(users, func(u *simUser) { u.seed(ctx) })
+	seeding := time.Since(seedStart)
+
+	log.Printf("Running for %s", cfg.duration)
+	timedCtx, cancel := context.WithTimeout(ctx, cfg.duration)
+	timedStart := time.Now()
+	eachUser(users, func(u *simUser) { u.simulate(timedCtx) })
+	elapsed := time.Since(timedStart)
+	cancel()
+
+	if cfg.cleanup {
+		log.Printf("Deleting the run's notes")
+		// Cleanup runs even after Ctrl-C, so it gets its own context
+		eachUser(users, func(u *simUser) { u.deleteNotes(context.Background()) })
+	}
+
+	return rec.report(cfg, seeding, elapsed)
+}
+
+// eachUser runs fn for every user concurrently and waits for all of them
+func eachUser(users []*simUser, fn func(*simUser)) {
+	var wg sync.WaitGroup
+	for _, u := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(u)
+		}()
+	}
+	wg.Wait()
+}
+
+// simUser is one simulated user with its own device
+type simUser struct {
+	cfg    config
+	client *client.Client
+	rec    *recorder
+	rng    *rand.Rand
+	prefix string // Of the IDs of notes this user creates
+
+	notes    []models.SyncNote // Live notes created by this run, as last pushed
+	created  int
+	lastSync *time.Time
+}
+
+// seed pushes the user's initial notes, waiting out rate limits. The user keeps the notes seeded so far
+// if a push fails otherwise.
+func (u *simUser) seed(ctx context.Context) {
+	for len(u.notes) < u.cfg.notes && ctx.Err() == nil {
+		batch := make([]models.SyncNote, 0, u.cfg.batch)
+		for len(batch) < u.cfg.batch && len(u.notes)+len(batch) < u.cfg.notes {
+			batch = append(batch, u.newNote())
+		}
+
+		start := time.Now()
+		_, err := u.client.PushNotes(ctx, batch...)
+		if u.rec.record(ctx, opSeed, time.Since(start), err) {
+			u.notes = append(u.notes, batch...)
+			continue
+		}
+		wait := retryAfter(err)
+		if wait == 0 {
+			log.Printf("Error seeding notes: %v", err)
+			return
+		}
+		time.Sleep(min(wait, maxRetryWait))
+	}
+}
+
+// simulate runs random operations until ctx is done
+func (u *simUser) simulate(ctx context.Context) {
+	for {
+		wait := u.thinkTime()
+		if retryAfter := u.operate(ctx); retryAfter > wait {
+			wait = min(retryAfter, maxRetryWait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// operate runs one random operation, returning how long the server asked to wait if it was rate limited
+func (u *simUser) operate(ctx context.Context) time.Duration {
+	switch u.pickOperation() {
+	case opEdit:
+		if len(u.notes) > 0 {
+			return u.editNotes(ctx)
+		}
+		fallthrough
+	case opCreate:
+		batch := make([]models.SyncNote, 1+u.rng.IntN(3))
+		for i := range batch {
+			batch[i] = u.newNote()
+		}
+		start := time.Now()
+		_, err := u.client.PushNotes(ctx, batch...)
+		if u.rec.record(ctx, opCreate, time.Since(start), err) {
+			u.notes = append(u.notes, batch...)
+		}
+		return retryAfter(err)
+	case opPull:
+		start := time.Now()
+		resp, err := u.client.PullNotes(ctx, u.lastSync)
+		if u.rec.record(ctx, opPull, time.Since(start), err) {
+			u.lastSync = &resp.LastSync
+		}
+		return retryAfter(err)
+	case opPagePull:
+		return u.pagedPull(ctx)
+	}
+	return 0
+}
+
+// editNotes pushes new content for a few of the user's notes, based on the versions it last pushed
+func (u *simUser) editNotes(ctx context.Context) time.Duration {
+	count := min(1+u.rng.IntN(5), len(u.notes))
+	indexes := u.rng.Perm(len(u.notes))[:count]
+	batch := make([]models.SyncNote, count)
+	now := time.Now()
+	for i, index := range indexes {
+		note := u.notes[index]
+		base := note.UpdatedAt
+		note.BaseUpdatedAt = &base
+		note.ContentEncrypted, note.ContentIV = u.content()
+		note.UpdatedAt = now
+		batch[i] = note
+	}
+
+	start := time.Now()
+	_, err := u.client.PushNotes(ctx, batch...)
+	if u.rec.record(ctx, opEdit, time.Since(start), err) {
+		for i, index := range indexes {
+			batch[i].BaseUpdatedAt = nil
+			u.notes[index] = batch[i]
+		}
+	}
+	return retryAfter(err)
+}
+
+// pagedPull pulls every note a page at a time, timing each page, as a device does on first sync
+func (u *simUser) pagedPull(ctx context.Context) time.Duration {
+	cursor := ""
+	for {
+		start := time.Now()
+		resp, err := u.client.PullNotesPage(ctx, nil, u.cfg.pageSize, cursor)
+		if !u.rec.record(ctx, opPagePull, time.Since(start), err) {
+			return retryAfter(err)
+		}
+		if resp.NextCursor == "" {
+			return 0
+		}
+		cursor = resp.NextCursor
+	}
+}
+
+// deleteNotes deletes the notes this run created, in pushes of --batch
+func (u *simUser) deleteNotes(ctx context.Context) {
+	for start := 0; start < len(u.notes); start += u.cfg.batch {
+		batch := u.notes[start:min(start+u.cfg.batch, len(u.notes))]
+		ids := make([]string, len(batch))
+		for i := range batch {
+			ids[i] = batch[i].ID
+		}
+
+		for {
+			begin := time.Now()
+			_, err := u.client.DeleteNotes(ctx, ids...)
+			u.rec.record(ctx, opCleanup, time.Since(begin), err)
+			// Wait out rate limits, so cleanup doesn't leave notes behind
+			wait := retryAfter(err)
+			if wait == 0 {
+				break
+			}
+			time.Sleep(min(wait, maxRetryWait))
+		}
+	}
+}
+
+// newNote returns a new note with random content
+func (u *simUser) newNote() models.SyncNote {
+	u.created++
+	now := time.Now()
+	content, iv := u.content()
+	return models.SyncNote{
+		ID:               u.prefix + strconv.Itoa(u.created),
+		Title:            fmt.Sprintf("Load test note %d", u.created),
+		ContentEncrypted: content,
+		ContentIV:        iv,
+		Date:             models.NoteDateOf(now),
+		Tags:             []string{"loadtest"},
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+}
+
+// content returns random base64 content of a realistic size, and a random IV, in place of a note's
+// encrypted body
+func (u *simUser) content() (content, iv string) {
+	size := float64(u.cfg.noteSize) * math.Exp(noteSizeSpread*u.rng.NormFloat64())
+	data := make([]byte, int(min(max(size, minNoteSize), maxNoteSize)))
+	for i := range data {
+		data[i] = byte(u.rng.Uint32())
+	}
+	ivData := make([]byte, 12)
+	for i := range ivData {
+		ivData[i] = byte(u.rng.Uint32())
+	}
+	return base64.StdEncoding.EncodeToString(data), base64.StdEncoding.EncodeToString(ivData)
+}
+
+// pickOperation picks a timed-phase operation by operationWeights
+func (u *simUser) pickOperation() string {
+	total := 0
+	for _, w := range operationWeights {
+		total += w.weight
+	}
+	n := u.rng.IntN(total)
+	for _, w := range operationWeights {
+		if n < w.weight {
+			return w.op
+		}
+		n -= w.weight
+	}
+	return operationWeights[0].op
+}
+
+// thinkTime returns a random pause averaging --think
+func (u *simUser) thinkTime() time.Duration {
+	return time.Duration(u.rng.ExpFloat64() * float64(u.cfg.think))
+}
+
+// retryAfter returns how long a rate-limited request asked to wait, or 0
+func retryAfter(err error) time.Duration {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+		return max(apiErr.RetryAfter, time.Second)
+	}
+	return 0
+}
+
+// recorder collects the latency and outcome of every request
+type recorder struct {
+	mu  sync.Mutex
+	ops map[string]*opSamples
+}
+
+// opSamples are the recorded requests of one operation
+type opSamples struct {
+	latencies []time.Duration // Of successful requests
+	errors    map[string]int  // Failed requests by kind (HTTP status or network error)
+}
+
+func newRecorder() *recorder {
+	return &recorder{ops: make(map[string]*opSamples)}
+}
+
+// record adds a request's outcome and reports whether it succeeded. Requests cut off because the run
+// ended aren't counted.
+func (r *recorder) record(ctx context.Context, op string, latency time.Duration, err error) bool {
+	if err != nil && ctx.Err() != nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	samples := r.ops[op]
+	if samples == nil {
+		samples = &opSamples{errors: make(map[string]int)}
+		r.ops[op] = samples
+	}
+	if err != nil {
+		samples.errors[errorKind(err)]++
+		return false
+	}
+	samples.latencies = append(samples.latencies, latency)
+	return true
+}
+
+// errorKind names a failed request's error: its HTTP status (and error code), or "timeout" or "network"
+func errorKind(err error) string {
+	var apiErr *client.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code != "":
+		return strconv.Itoa(apiErr.StatusCode) + " " + apiErr.Code
+	case errors.As(err, &apiErr):
+		return strconv.Itoa(apiErr.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err):
+		return "timeout"
+	default:
+		return "network"
+	}
+}
+
+// report is the outcome of a run
+type report struct {
+	BaseURL    string         `json:"baseUrl"`
+	Users      int            `json:"users"`
+	Seed       uint64         `json:"seed"`
+	Seeding    string         `json:"seeding"` // How long seeding took
+	Duration   string         `json:"duration"`
+	Operations []operationRow `json:"operations"`
+}
+
+// operationRow summarizes one operation. Latencies are in milliseconds, over successful requests.
+type operationRow struct {
+	Operation string         `json:"operation"`
+	Requests  int            `json:"requests"`
+	Errors    int            `json:"errors"`
+	ErrorRate float64        `json:"errorRate"`
+	PerSecond float64        `json:"perSecond"` // Over the phase the operation ran in
+	P50       float64        `json:"p50Ms"`
+	P90       float64        `json:"p90Ms"`
+	P99       float64        `json:"p99Ms"`
+	Max       float64        `json:"maxMs"`
+	ErrorKind map[string]int `json:"errorKinds,omitempty"`
+}
+
+// report summarizes the recorded requests
+func (r *recorder) report(cfg config, seeding, elapsed time.Duration) report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep := report{
+		BaseURL:  cfg.baseURL,
+		Users:    cfg.users,
+		Seed:     cfg.seed,
+		Seeding:  seeding.Round(time.Millisecond).String(),
+		Duration: elapsed.Round(time.Millisecond).String(),
+	}
+	for _, op := range reportOrder {
+		samples, ok := r.ops[op]
+		if !ok {
+			continue
+		}
+		sort.Slice(samples.latencies, func(i, j int) bool { return samples.latencies[i] < samples.latencies[j] })
+
+		row := operationRow{Operation: op, Requests: len(samples.latencies), ErrorKind: samples.errors}
+		for _, count := range samples.errors {
+			row.Errors += count
+		}
+		row.Requests += row.Errors
+		row.ErrorRate = float64(row.Errors) / float64(row.Requests)
+		phase := elapsed
+		if op == opSeed {
+			phase = seeding
+		}
+		if op != opCleanup && phase > 0 {
+			row.PerSecond = float64(row.Requests) / phase.Seconds()
+		}
+		row.P50 = percentile(samples.latencies, 50)
+		row.P90 = percentile(samples.latencies, 90)
+		row.P99 = percentile(samples.latencies, 99)
+		row.Max = percentile(samples.latencies, 100)
+		rep.Operations = append(rep.Operations, row)
+	}
+	return rep
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies in milliseconds, or 0 without any
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	rank = min(max(rank, 1), len(sorted))
+	return float64(sorted[rank-1].Microseconds()) / 1000
+}
+
+// printReport writes the report as a table
+func printReport(rep report) {
+	fmt.Printf("%s: %d users, seeded in %s, ran for %s (seed %d)\n\n", rep.BaseURL, rep.Users, rep.Seeding, rep.Duration, rep.Seed)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "operation\trequests\terrors\treq/s\tp50 ms\tp90 ms\tp99 ms\tmax ms\t")
+	for _, row := range rep.Operations {
+		fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n", row.Operation, row.Requests,
+			row.ErrorRate*100, row.PerSecond, row.P50, row.P90, row.P99, row.Max)
+	}
+	if err := w.Flush(); err != nil {
+		log.Printf("Error writing report: %v", err)
+	}
+
+	for _, row := range rep.Operations {
+		if len(row.ErrorKind) == 0 {
+			continue
+		}
+		kinds := make([]string, 0, len(row.ErrorKind))
+		for kind, count := range row.ErrorKind {
+			kinds = append(kinds, fmt.Sprintf("%s x%d", kind, count))
+		}
+		sort.Strings(kinds)
+		fmt.Printf("\n%s errors: %s", row.Operation, strings.Join(kinds, ", "))
+	}
+	fmt.Println()
+}
@@ -0,0 +1,109 @@
+// Moves all of one user's data to another, after their Clerk accounts were merged
+//
+// Usage:
+//
+//	merge-users [--apply] <source user ID> <target user ID>
+//
+// Without --apply the merge is only reported. The sealer keys (ESCROW_ENCRYPTION_KEY,
+// PROVIDER_KEY_ENCRYPTION_KEY, CHAT_ENCRYPTION_KEY) must match the server's, or the source's
+// sealed secrets can't be moved.
+package main
+
+import (
+	"backend/services"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+const usage = `usage: merge-users [flags] <source user ID> <target user ID>
+
+Reports what would move from the source to the target; --apply moves it.
+
+flags:`
+
+// auditActor is recorded as the admin of merges applied from the command line
+const auditActor = "cli:merge-users"
+
+func main() {
+	apply := flag.Bool("apply", false, "apply the merge instead of only reporting it")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 || args[0] == "" || args[1] == "" || args[0] == args[1] {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sourceID, targetID := args[0], args[1]
+
+	var sealers services.UserMergeSealers
+	for _, s := range []struct {
+		env    string
+		sealer **services.Sealer
+	}{
+		{"ESCROW_ENCRYPTION_KEY", &sealers.Escrow},
+		{"PROVIDER_KEY_ENCRYPTION_KEY", &sealers.ProviderKeys},
+		{"CHAT_ENCRYPTION_KEY", &sealers.Chat},
+	} {
+		sealer, err := services.NewSealer(os.Getenv(s.env))
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", s.env, err)
+		}
+		*s.sealer = sealer
+	}
+
+	database, err := services.NewDatabase()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	if err := run(context.Background(), database, sourceID, targetID, sealers, !*apply); err != nil {
+		// Clean up before exiting
+		if closeErr := database.Close(); closeErr != nil {
+			log.Printf("Error closing database during cleanup: %v", closeErr)
+		}
+		log.Fatalf("Merge failed: %v", err)
+	}
+
+	if err := database.Close(); err != nil {
+		log.Printf("Error closing database: %v", err)
+	}
+}
+
+// run merges the users and prints the report as JSON
+func run(ctx context.Context, database *services.Database, sourceID, targetID string, sealers services.UserMergeSealers, dryRun bool) error {
+	if !dryRun {
+		if err := database.RecordAuditEvent(ctx, auditActor, "users.merge", targetID, map[string]interface{}{
+			"sourceUserId": sourceID,
+		}); err != nil {
+			return fmt.Errorf("failed to record audit event: %w", err)
+		}
+	}
+
+	result, err := database.MergeUsers(ctx, sourceID, targetID, sealers, dryRun)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(result.Report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+
+	if len(result.Report.Blockers) > 0 {
+		return errors.New("the merge was refused; see blockers")
+	}
+	if dryRun {
+		fmt.Fprintln(os.Stderr, "Dry run; nothing was changed. Run with --apply to merge.")
+	}
+	return nil
+}
@@ -1,85 +1,684 @@
 // Migration runner for Neon PostgreSQL
+//
+// Usage:
+//
+//	migrate [flags] up [N]    apply pending migrations (only the next N if given)
+//	migrate [flags] down [N]  revert the last N applied migrations (default 1)
+//	migrate status            list migrations and whether they're applied
+//	migrate [flags] plan [N]  print what up would run and check the live schema, changing nothing
 package main
 
 import (
+	"backend/migrations"
+	"backend/services"
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// migrationLockID is the advisory lock key that keeps two runners from migrating at once
+const migrationLockID = 7436921
+
+// backfillProgressEvery is how many batches pass between backfill progress lines
+const backfillProgressEvery = 10
+
+// runnerTables are the runner's own tracking tables, which no migration creates
+var runnerTables = []string{"schema_migrations", "schema_migration_backfills"}
+
+// runtimeIndexPrefix names the indexes the server's vector maintenance job builds and swaps at runtime
+// (services/vector_maintenance.go), which no migration creates
+const runtimeIndexPrefix = "idx_note_embeddings_ann"
+
+const usage = `usage: migrate [flags] <command>
+
+  up [N]     apply pending migrations (only the next N if given)
+  down [N]   revert the last N applied migrations (default 1)
+  status     list migrations and whether they're applied
+  plan [N]   print the SQL up [N] would run and check the live schema for missing tables, columns,
+             and indexes, without changing anything; fails if the schema has drifted
+
+flags:`
+
+// options are the command-line flags
+type options struct {
+	allowDestructive bool
+	batchSize        int
+	batchPause       time.Duration
+}
+
 func main() {
-	// Get DATABASE_URL from environment
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable is required")
+	var opts options
+	flag.BoolVar(&opts.allowDestructive, "allow-destructive", false, "run migrations that drop tables or columns, truncate, delete rows, or change column types")
+	flag.IntVar(&opts.batchSize, "batch-size", 1000, "rows per backfill batch")
+	flag.DurationVar(&opts.batchPause, "batch-pause", 0, "pause between backfill batches, to limit load on a live database")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 || len(args) > 2 || opts.batchSize <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command := args[0]
+	count := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		count = n
+	}
+	if command != "up" && command != "down" && command != "status" && command != "plan" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	all, err := migrations.All()
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	// Migrations hold a session advisory lock and build indexes concurrently, so on Neon they use the
+	// direct endpoint even when DATABASE_URL names the pooler
+	urls, err := services.DatabaseURLsFromEnv()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Connect to database
-	db, err := sql.Open("pgx", dbURL)
+	db, err := sql.Open("pgx", urls.Direct)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Test connection
-	if err := db.Ping(); err != nil {
+	if err := run(context.Background(), db, command, count, all, opts); err != nil {
 		// Clean up before exiting
 		if closeErr := db.Close(); closeErr != nil {
 			log.Printf("Error closing database during cleanup: %v", closeErr)
 		}
-		log.Fatalf("Failed to ping database: %v", err)
+		log.Fatalf("Migration %s failed: %v", command, err)
+	}
+
+	if err := db.Close(); err != nil {
+		log.Printf("Error closing database: %v", err)
 	}
+}
 
-	// Set up cleanup after all checks succeed
+// run executes a command on a single connection holding the migration lock
+func run(ctx context.Context, db *sql.DB, command string, count int, all []migrations.Migration, opts options) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("Error closing connection: %v", err)
+		}
+	}()
+
+	// Plans only read, so they neither wait for the lock nor create the tracking tables
+	if command == "plan" {
+		return plan(ctx, conn, all, count, opts)
+	}
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
 	defer func() {
-		if err := db.Close(); err != nil {
-			log.Printf("Error closing database: %v", err)
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			log.Printf("Error releasing migration lock: %v", err)
 		}
 	}()
 
-	fmt.Println("Connected to database successfully!")
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migration_backfills (
+			version INTEGER PRIMARY KEY,
+			batches INTEGER NOT NULL DEFAULT 0,
+			rows_affected BIGINT NOT NULL DEFAULT 0,
+			started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_migration_backfills: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		return up(ctx, conn, all, applied, count, opts)
+	case "down":
+		return down(ctx, conn, all, applied, max(count, 1), opts)
+	default:
+		backfills, err := backfillProgress(ctx, conn)
+		if err != nil {
+			return err
+		}
+		status(all, applied, backfills)
+		return nil
+	}
+}
+
+// up applies pending migrations in version order. Each runs in its own transaction unless it's marked
+// no-transaction; backfills then run in batches, and the migration is recorded once they finish.
+func up(ctx context.Context, conn *sql.Conn, all []migrations.Migration, applied map[int]time.Time, count int, opts options) error {
+	pending := pendingMigrations(all, applied, count)
+
+	// Refuse before applying anything, so a run never stops halfway at a destructive migration
+	if !opts.allowDestructive {
+		if err := checkDestructive(pending, upSQL); err != nil {
+			return err
+		}
+	}
+
+	ran := 0
+	for _, m := range pending {
+		fmt.Printf("Applying %s...\n", m.Name)
+		record := func(e execer) error {
+			_, err := e.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+			return err
+		}
+
+		var err error
+		switch {
+		case m.NoTransaction:
+			// Statements like CREATE INDEX CONCURRENTLY can't run in a transaction (even an implicit
+			// multi-statement one), so a failure can leave earlier statements applied
+			for _, statement := range migrations.SplitStatements(m.Up) {
+				if _, err = conn.ExecContext(ctx, statement); err != nil {
+					break
+				}
+			}
+		case m.Backfill == "":
+			err = inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+					return err
+				}
+				return record(tx)
+			})
+		default:
+			err = inTx(ctx, conn, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, m.Up)
+				return err
+			})
+		}
+		if err == nil && m.Backfill != "" {
+			err = backfill(ctx, conn, m, opts)
+		}
+		if err == nil && (m.NoTransaction || m.Backfill != "") {
+			err = record(conn)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.Name, err)
+		}
+		ran++
+	}
+
+	if ran == 0 {
+		fmt.Println("Database is up to date")
+	} else {
+		fmt.Printf("Applied %d migration(s)\n", ran)
+	}
+	return nil
+}
+
+// down reverts the latest count applied migrations, newest first
+func down(ctx context.Context, conn *sql.Conn, all []migrations.Migration, applied map[int]time.Time, count int, opts options) error {
+	var reverting []migrations.Migration
+	for i := len(all) - 1; i >= 0 && len(reverting) < count; i-- {
+		if _, ok := applied[all[i].Version]; ok {
+			reverting = append(reverting, all[i])
+		}
+	}
+	for _, m := range reverting {
+		if m.Down == "" {
+			return fmt.Errorf("%s has no down section and can't be reverted", m.Name)
+		}
+	}
+	if !opts.allowDestructive {
+		if err := checkDestructive(reverting, func(m migrations.Migration) string { return m.Down }); err != nil {
+			return err
+		}
+	}
 
-	// Read migration file - try multiple paths
-	var migrationSQL []byte
-	var err2 error
+	ran := 0
+	for _, m := range reverting {
+		fmt.Printf("Reverting %s...\n", m.Name)
+		unrecord := func(e execer) error {
+			_, err := e.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+			return err
+		}
 
-	// Try paths in order
-	paths := []string{
-		"migrations/001_initial_schema.sql",
-		"backend/migrations/001_initial_schema.sql",
-		filepath.Join("..", "migrations", "001_initial_schema.sql"),
+		var err error
+		if m.NoTransaction {
+			// Reverted the same way it was applied (e.g. DROP INDEX CONCURRENTLY)
+			for _, statement := range migrations.SplitStatements(m.Down) {
+				if _, err = conn.ExecContext(ctx, statement); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = unrecord(conn)
+			}
+		} else {
+			err = inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+					return err
+				}
+				return unrecord(tx)
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.Name, err)
+		}
+		ran++
 	}
 
-	for _, migrationPath := range paths {
-		migrationSQL, err2 = os.ReadFile(migrationPath)
-		if err2 == nil {
-			fmt.Printf("Found migration file at: %s\n", migrationPath)
+	fmt.Printf("Reverted %d migration(s)\n", ran)
+	return nil
+}
+
+// status prints every known migration with when it was applied, and the progress of unfinished backfills
+func status(all []migrations.Migration, applied map[int]time.Time, backfills map[int]backfillState) {
+	known := make(map[int]bool, len(all))
+	for _, m := range all {
+		known[m.Version] = true
+		if at, ok := applied[m.Version]; ok {
+			fmt.Printf("applied  %s  %s\n", at.UTC().Format(time.RFC3339), m.Name)
+		} else if progress, ok := backfills[m.Version]; ok {
+			fmt.Printf("pending  %-20s  %s (backfill interrupted after %d rows in %d batches, last at %s)\n",
+				"", m.Name, progress.rows, progress.batches, progress.updatedAt.UTC().Format(time.RFC3339))
+		} else {
+			fmt.Printf("pending  %-20s  %s\n", "", m.Name)
+		}
+	}
+	for version := range applied {
+		if !known[version] {
+			fmt.Printf("unknown  version %d is applied but has no migration file\n", version)
+		}
+	}
+}
+
+// plan prints the statements up would run for the pending migrations, in order and without running
+// them, then compares the live schema with the one the applied migrations should have left. It fails
+// if a table, column, or index is missing or an index is invalid; unexpected objects are only listed.
+func plan(ctx context.Context, conn *sql.Conn, all []migrations.Migration, count int, opts options) error {
+	// A database that was never migrated has no tracking tables yet
+	applied := map[int]time.Time{}
+	backfills := map[int]backfillState{}
+	tracked, err := tablesExist(ctx, conn, runnerTables...)
+	if err != nil {
+		return err
+	}
+	if tracked["schema_migrations"] {
+		if applied, err = appliedMigrations(ctx, conn); err != nil {
+			return err
+		}
+	}
+	if tracked["schema_migration_backfills"] {
+		if backfills, err = backfillProgress(ctx, conn); err != nil {
+			return err
+		}
+	}
+
+	pending := pendingMigrations(all, applied, count)
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations")
+	} else {
+		fmt.Printf("up would apply %d migration(s):\n", len(pending))
+	}
+	for _, m := range pending {
+		mode := "in a transaction"
+		if m.NoTransaction {
+			mode = "statement by statement, outside a transaction"
+		}
+		fmt.Printf("\n-- %s (%s)\n", m.Name, mode)
+		for _, statement := range migrations.SplitStatements(m.Up) {
+			fmt.Println(statement + ";")
+		}
+		if m.Backfill != "" {
+			note := ""
+			if progress, ok := backfills[m.Version]; ok {
+				note = fmt.Sprintf(", resuming after %d rows in %d batches", progress.rows, progress.batches)
+			}
+			fmt.Printf("-- backfill, repeated until it changes no rows%s:\n", note)
+			fmt.Println(strings.ReplaceAll(m.Backfill, migrations.BatchSizePlaceholder, strconv.Itoa(opts.batchSize)) + ";")
+		}
+	}
+	if destructive := destructiveStatements(pending, upSQL); len(destructive) > 0 && !opts.allowDestructive {
+		fmt.Printf("\nup would refuse these destructive statements without --allow-destructive:\n%s\n", strings.Join(destructive, "\n"))
+	}
+
+	var done []migrations.Migration
+	for _, m := range all {
+		if _, ok := applied[m.Version]; ok {
+			done = append(done, m)
+		}
+	}
+	live, invalid, err := liveSchema(ctx, conn)
+	if err != nil {
+		return err
+	}
+	diff := migrations.ExpectedSchema(done).Diff(live)
+
+	fmt.Println()
+	if len(diff.Missing) == 0 && len(diff.Unexpected) == 0 && len(invalid) == 0 {
+		fmt.Printf("Schema matches the %d applied migration(s)\n", len(done))
+		return nil
+	}
+	fmt.Printf("Schema differences from the %d applied migration(s):\n", len(done))
+	for _, object := range diff.Missing {
+		fmt.Printf("  missing     %s\n", object)
+	}
+	for _, object := range invalid {
+		fmt.Printf("  invalid     %s (left by a failed concurrent build; drop it and run up again)\n", object)
+	}
+	for _, object := range diff.Unexpected {
+		fmt.Printf("  unexpected  %s\n", object)
+	}
+	if len(diff.Missing) > 0 || len(invalid) > 0 {
+		return fmt.Errorf("schema has %d missing and %d invalid object(s)", len(diff.Missing), len(invalid))
+	}
+	return nil
+}
+
+// pendingMigrations returns the migrations that aren't applied, in version order, only the first count
+// if count is positive
+func pendingMigrations(all []migrations.Migration, applied map[int]time.Time, count int) []migrations.Migration {
+	var pending []migrations.Migration
+	for _, m := range all {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if count > 0 && len(pending) == count {
 			break
 		}
+		pending = append(pending, m)
 	}
+	return pending
+}
 
-	if err2 != nil {
-		// Clean up before exiting
-		if closeErr := db.Close(); closeErr != nil {
-			log.Printf("Error closing database during cleanup: %v", closeErr)
+// upSQL returns all the SQL up runs for a migration
+func upSQL(m migrations.Migration) string {
+	return m.Up + ";\n" + m.Backfill
+}
+
+// checkDestructive returns an error listing the destructive statements of the migrations
+func checkDestructive(ms []migrations.Migration, sqlOf func(migrations.Migration) string) error {
+	found := destructiveStatements(ms, sqlOf)
+	if len(found) == 0 {
+		return nil
+	}
+	return fmt.Errorf("refusing to run destructive statements without --allow-destructive:\n%s", strings.Join(found, "\n"))
+}
+
+// destructiveStatements lists the destructive statements of the migrations, one line each
+func destructiveStatements(ms []migrations.Migration, sqlOf func(migrations.Migration) string) []string {
+	var found []string
+	for _, m := range ms {
+		for _, statement := range migrations.Destructive(sqlOf(m)) {
+			found = append(found, fmt.Sprintf("  %s: %s", m.Name, firstLine(statement)))
 		}
-		//nolint:gocritic // Explicit cleanup done before exit
-		log.Fatalf("Failed to read migration file (tried: %v): %v", paths, err2)
 	}
+	return found
+}
 
-	// Execute migration
-	fmt.Println("Running migration...")
-	if _, err := db.Exec(string(migrationSQL)); err != nil {
-		// Clean up before exiting
-		if closeErr := db.Close(); closeErr != nil {
-			log.Printf("Error closing database during cleanup: %v", closeErr)
+// firstLine returns the first line of a statement, marking it as truncated if there's more
+func firstLine(statement string) string {
+	line, rest, _ := strings.Cut(statement, "\n")
+	if strings.TrimSpace(rest) != "" {
+		line += " ..."
+	}
+	return line
+}
+
+// backfillState is the recorded progress of a migration's backfill
+type backfillState struct {
+	batches   int
+	rows      int64
+	updatedAt time.Time
+}
+
+// backfill runs a migration's backfill statement until a batch changes no rows. Each batch commits with
+// its progress, so an interrupted backfill resumes where it stopped the next time up runs.
+func backfill(ctx context.Context, conn *sql.Conn, m migrations.Migration, opts options) error {
+	statement := strings.ReplaceAll(m.Backfill, migrations.BatchSizePlaceholder, strconv.Itoa(opts.batchSize))
+	if _, err := conn.ExecContext(ctx, `
+		INSERT INTO schema_migration_backfills (version) VALUES ($1) ON CONFLICT (version) DO NOTHING
+	`, m.Version); err != nil {
+		return err
+	}
+
+	for {
+		var affected int64
+		var progress backfillState
+		err := inTx(ctx, conn, func(tx *sql.Tx) error {
+			result, err := tx.ExecContext(ctx, statement)
+			if err != nil {
+				return err
+			}
+			if affected, err = result.RowsAffected(); err != nil {
+				return err
+			}
+			return tx.QueryRowContext(ctx, `
+				UPDATE schema_migration_backfills
+				SET batches = batches + 1, rows_affected = rows_affected + $2, updated_at = CURRENT_TIMESTAMP
+				WHERE version = $1
+				RETURNING batches, rows_affected
+			`, m.Version, affected).Scan(&progress.batches, &progress.rows)
+		})
+		if err != nil {
+			return fmt.Errorf("backfill: %w", err)
+		}
+
+		if affected == 0 || progress.batches%backfillProgressEvery == 0 {
+			fmt.Printf("  backfill: %d rows in %d batches\n", progress.rows, progress.batches)
+		}
+		if affected == 0 {
+			break
+		}
+		if opts.batchPause > 0 {
+			time.Sleep(opts.batchPause)
 		}
-		//nolint:gocritic // Explicit cleanup done before exit
-		log.Fatalf("Migration failed: %v", err)
 	}
 
-	fmt.Println("Migration completed successfully!")
+	_, err := conn.ExecContext(ctx, `DELETE FROM schema_migration_backfills WHERE version = $1`, m.Version)
+	return err
+}
+
+// backfillProgress returns the progress of backfills that haven't finished
+func backfillProgress(ctx context.Context, conn *sql.Conn) (map[int]backfillState, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, batches, rows_affected, updated_at FROM schema_migration_backfills`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migration_backfills: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	backfills := make(map[int]backfillState)
+	for rows.Next() {
+		var version int
+		var state backfillState
+		if err := rows.Scan(&version, &state.batches, &state.rows, &state.updatedAt); err != nil {
+			return nil, err
+		}
+		backfills[version] = state
+	}
+	return backfills, rows.Err()
+}
+
+// tablesExist reports which of the tables exist in the current schema
+func tablesExist(ctx context.Context, conn *sql.Conn, tables ...string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		var found bool
+		if err := conn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&found); err != nil {
+			return nil, fmt.Errorf("failed to look up %s: %w", table, err)
+		}
+		exists[table] = found
+	}
+	return exists, nil
+}
+
+// liveSchema reads the tables, columns, and indexes of the current schema, leaving out the runner's
+// tables, indexes backing constraints (primary keys, UNIQUE), and indexes built at runtime. It also
+// returns the invalid indexes, e.g. "index idx_notes_user_date on notes".
+func liveSchema(ctx context.Context, conn *sql.Conn) (schema migrations.Schema, invalid []string, err error) {
+	schema = migrations.Schema{Tables: map[string]map[string]bool{}, Indexes: map[string]string{}}
+	skipped := map[string]bool{}
+	for _, table := range runnerTables {
+		skipped[table] = true
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT c.table_name, c.column_name
+		FROM information_schema.columns c
+		JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = current_schema() AND t.table_type = 'BASE TABLE'
+	`)
+	if err != nil {
+		return schema, nil, fmt.Errorf("failed to read columns: %w", err)
+	}
+	err = scanRows(rows, func() error {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return err
+		}
+		if skipped[table] {
+			return nil
+		}
+		if schema.Tables[table] == nil {
+			schema.Tables[table] = map[string]bool{}
+		}
+		schema.Tables[table][column] = true
+		return nil
+	})
+	if err != nil {
+		return schema, nil, fmt.Errorf("failed to read columns: %w", err)
+	}
+
+	rows, err = conn.QueryContext(ctx, `
+		SELECT i.relname, t.relname, x.indisvalid
+		FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN pg_class t ON t.oid = x.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE n.nspname = current_schema()
+		  AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = x.indexrelid)
+	`)
+	if err != nil {
+		return schema, nil, fmt.Errorf("failed to read indexes: %w", err)
+	}
+	err = scanRows(rows, func() error {
+		var index, table string
+		var valid bool
+		if err := rows.Scan(&index, &table, &valid); err != nil {
+			return err
+		}
+		if !valid {
+			invalid = append(invalid, fmt.Sprintf("index %s on %s", index, table))
+		}
+		if !skipped[table] && !strings.HasPrefix(index, runtimeIndexPrefix) {
+			schema.Indexes[index] = table
+		}
+		return nil
+	})
+	if err != nil {
+		return schema, nil, fmt.Errorf("failed to read indexes: %w", err)
+	}
+	sort.Strings(invalid)
+	return schema, invalid, nil
+}
+
+// scanRows calls scan for each row and closes the rows
+func scanRows(rows *sql.Rows, scan func() error) error {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		if err := scan(); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// execer is satisfied by both connections and transactions
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// appliedMigrations returns the applied versions and when they were applied
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// inTx runs fn in a transaction, committing only if it succeeds
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+				log.Printf("Error rolling back migration: %v", rbErr)
+			}
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
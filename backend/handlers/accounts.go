@@ -0,0 +1,84 @@
+// Multi-session account switching for Clerk clients
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	clerkclient "github.com/clerk/clerk-sdk-go/v2/client"
+	"github.com/clerk/clerk-sdk-go/v2/session"
+)
+
+// accountHeader selects which of the client's signed-in accounts a request acts on behalf of
+const accountHeader = "X-Account-ID"
+
+// accountCacheTTL bounds how long a session's authorized accounts are trusted before re-checking Clerk
+const accountCacheTTL = time.Minute
+
+// authorizedAccounts caches, per Clerk session, the user IDs of all active sessions on the same Clerk client
+type authorizedAccounts struct {
+	mu      sync.Mutex
+	entries map[string]accountCacheEntry
+}
+
+type accountCacheEntry struct {
+	userIDs   map[string]bool
+	expiresAt time.Time
+}
+
+var sessionAccounts = &authorizedAccounts{entries: make(map[string]accountCacheEntry)}
+
+// isAuthorized reports whether accountID is signed in on the same Clerk client as the given session
+func (a *authorizedAccounts) isAuthorized(ctx context.Context, sessionID, accountID string) (bool, error) {
+	a.mu.Lock()
+	entry, ok := a.entries[sessionID]
+	a.mu.Unlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		userIDs, err := fetchClientAccounts(ctx, sessionID)
+		if err != nil {
+			return false, err
+		}
+		entry = accountCacheEntry{userIDs: userIDs, expiresAt: time.Now().Add(accountCacheTTL)}
+
+		a.mu.Lock()
+		a.evictExpired()
+		a.entries[sessionID] = entry
+		a.mu.Unlock()
+	}
+
+	return entry.userIDs[accountID], nil
+}
+
+// evictExpired drops stale cache entries (caller must hold the lock)
+func (a *authorizedAccounts) evictExpired() {
+	now := time.Now()
+	for sessionID, entry := range a.entries {
+		if now.After(entry.expiresAt) {
+			delete(a.entries, sessionID)
+		}
+	}
+}
+
+// fetchClientAccounts looks up the Clerk client owning the session and returns its active sessions' user IDs
+func fetchClientAccounts(ctx context.Context, sessionID string) (map[string]bool, error) {
+	sess, err := session.Get(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch session: %w", err)
+	}
+
+	client, err := clerkclient.Get(ctx, sess.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch client: %w", err)
+	}
+
+	userIDs := make(map[string]bool, len(client.Sessions))
+	for _, s := range client.Sessions {
+		if s.Status == "active" {
+			userIDs[s.UserID] = true
+		}
+	}
+	return userIDs, nil
+}
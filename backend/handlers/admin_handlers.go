@@ -0,0 +1,215 @@
+// HTTP handlers for admin and support endpoints
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AdminHandlers handles admin-only HTTP endpoints
+type AdminHandlers struct {
+	db      *services.Database
+	hub     *services.RealtimeHub
+	sealers services.UserMergeSealers
+}
+
+// NewAdminHandlers creates a new AdminHandlers instance
+func NewAdminHandlers(db *services.Database, hub *services.RealtimeHub, sealers services.UserMergeSealers) *AdminHandlers {
+	return &AdminHandlers{db: db, hub: hub, sealers: sealers}
+}
+
+// HandleImpersonateSync handles GET /api/admin/impersonate/{userId}/sync - read-only view of a user's sync metadata.
+// Only counts and timestamps are returned; encrypted note content and titles are never exposed.
+func (h *AdminHandlers) HandleImpersonateSync(w http.ResponseWriter, r *http.Request) {
+	adminID, err := GetSessionUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetUserID := r.PathValue("userId")
+	if targetUserID == "" {
+		respondWithError(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Every impersonation is audited; refuse access if the audit entry can't be written
+	if err := h.db.RecordAuditEvent(ctx, adminID, "impersonate.sync_metadata", targetUserID, map[string]interface{}{
+		"path":      r.URL.Path,
+		"userAgent": r.UserAgent(),
+	}); err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		respondWithError(w, "Failed to record audit event", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin %s viewed sync metadata of user %s", adminID, targetUserID)
+
+	metadata, err := h.fetchSyncMetadata(ctx, targetUserID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching sync metadata for user %s: %v", targetUserID, err)
+		respondWithError(w, "Failed to fetch sync metadata", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, metadata, http.StatusOK)
+}
+
+// HandleMergeUsers handles POST /api/admin/users/merge - move all of one user's data to another, after
+// their Clerk accounts were merged. Without "dryRun": false this only reports what would be moved.
+// Returns 409 with the report if the merge can't be done.
+func (h *AdminHandlers) HandleMergeUsers(w http.ResponseWriter, r *http.Request) {
+	adminID, err := GetSessionUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UserMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.SourceUserID == "" || req.TargetUserID == "" {
+		respondWithError(w, "sourceUserId and targetUserId are required", http.StatusBadRequest)
+		return
+	}
+	if req.SourceUserID == req.TargetUserID {
+		respondWithError(w, "sourceUserId and targetUserId must differ", http.StatusBadRequest)
+		return
+	}
+	dryRun := req.DryRun == nil || *req.DryRun
+
+	ctx := r.Context()
+
+	// Every applied merge is audited; refuse to merge if the audit entry can't be written
+	if !dryRun {
+		if err := h.db.RecordAuditEvent(ctx, adminID, "users.merge", req.TargetUserID, map[string]interface{}{
+			"sourceUserId": req.SourceUserID,
+		}); err != nil {
+			log.Printf("Error recording audit event: %v", err)
+			respondWithError(w, "Failed to record audit event", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result, err := h.db.MergeUsers(ctx, req.SourceUserID, req.TargetUserID, h.sealers, dryRun)
+	if errors.Is(err, services.ErrMergeUserNotFound) {
+		respondWithError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error merging user %s into %s: %v", req.SourceUserID, req.TargetUserID, err)
+		respondWithError(w, "Failed to merge users", http.StatusInternalServerError)
+		return
+	}
+	if len(result.Report.Blockers) > 0 {
+		respondWithJSON(w, result.Report, http.StatusConflict)
+		return
+	}
+
+	if !dryRun {
+		log.Printf("Admin %s merged user %s into %s", adminID, req.SourceUserID, req.TargetUserID)
+		h.hub.PublishChanges(req.TargetUserID, result.NoteIDs, result.CollectionIDs)
+	}
+	respondWithJSON(w, result.Report, http.StatusOK)
+}
+
+// Helper functions
+
+func (h *AdminHandlers) fetchSyncMetadata(ctx context.Context, userID string) (*models.SyncMetadata, error) {
+	metadata := &models.SyncMetadata{UserID: userID, Devices: []models.DeviceSyncState{}}
+
+	var userCreatedAt sql.NullTime
+	if err := h.db.DB.QueryRowContext(ctx, `SELECT created_at FROM users WHERE id = $1`, userID).Scan(&userCreatedAt); err != nil {
+		return nil, err
+	}
+	if userCreatedAt.Valid {
+		metadata.UserCreatedAt = &userCreatedAt.Time
+	}
+
+	var lastUpdate, lastDeletion sql.NullTime
+	query := `
+		SELECT COUNT(*) FILTER (WHERE deleted_at IS NULL),
+		       COUNT(*) FILTER (WHERE deleted_at IS NOT NULL),
+		       MAX(updated_at),
+		       MAX(deleted_at)
+		FROM notes
+		WHERE user_id = $1
+	`
+	err := h.db.DB.QueryRowContext(ctx, query, userID).Scan(
+		&metadata.NoteCount, &metadata.DeletedNoteCount, &lastUpdate, &lastDeletion,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if lastUpdate.Valid {
+		metadata.LastNoteUpdateAt = &lastUpdate.Time
+	}
+	if lastDeletion.Valid {
+		metadata.LastDeletionAt = &lastDeletion.Time
+	}
+
+	if err := h.db.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM collections WHERE user_id = $1`, userID).Scan(&metadata.CollectionCount); err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT device_id, last_pull_at, last_push_at
+		FROM sync_devices
+		WHERE user_id = $1
+		ORDER BY COALESCE(last_pull_at, last_push_at) DESC NULLS LAST
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var device models.DeviceSyncState
+		var lastPull, lastPush sql.NullTime
+		if err := rows.Scan(&device.DeviceID, &lastPull, &lastPush); err != nil {
+			continue
+		}
+		if lastPull.Valid {
+			device.LastPullAt = &lastPull.Time
+		}
+		if lastPush.Valid {
+			device.LastPushAt = &lastPush.Time
+		}
+		device.LagSeconds = deviceLag(metadata.LastNoteUpdateAt, device.LastPullAt)
+		metadata.Devices = append(metadata.Devices, device)
+	}
+
+	return metadata, rows.Err()
+}
+
+// deviceLag returns how many seconds the device's last pull trails the newest server change
+func deviceLag(lastServerChange, lastPull *time.Time) int64 {
+	if lastServerChange == nil {
+		return 0
+	}
+	if lastPull == nil {
+		return int64(time.Since(*lastServerChange).Seconds())
+	}
+	if lag := lastServerChange.Sub(*lastPull); lag > 0 {
+		return int64(lag.Seconds())
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+// Serving AI responses from the response cache
+package handlers
+
+import (
+	"backend/services"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// aiCacheName identifies the AI response cache in Cache-Status headers (RFC 9211)
+const aiCacheName = "Jottin"
+
+// cachedAIResponse looks up the response to an identical earlier request for a feature, keyed by the
+// caller's API key (or trial user) and the input the prompt is built from. It writes the cached response
+// and returns served true on a hit; otherwise it returns the key to pass to respondWithCachedJSON, ""
+// if the response can't be cached. A request with Cache-Control: no-cache skips the lookup but is still
+// stored, so clients can ask for a fresh answer.
+func (h *AIHandlers) cachedAIResponse(w http.ResponseWriter, r *http.Request, feature, userApiKey string, input interface{}) (key string, served bool) {
+	if h.aiCache == nil {
+		return "", false
+	}
+	fingerprint := aiKeyFingerprint(r, userApiKey)
+	if fingerprint == "" {
+		return "", false
+	}
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		log.Printf("Error encoding AI cache key: %v", err)
+		return "", false
+	}
+
+	model := services.DefaultGeminiModel
+	if services.MockAIEnabled() {
+		model = services.AIProviderMock
+	}
+	key = services.AICacheKey(feature, model, fingerprint, encoded)
+
+	if strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache") {
+		w.Header().Set("Cache-Status", aiCacheName+"; fwd=request")
+		return key, false
+	}
+	if body, ok := h.aiCache.Get(r.Context(), key); ok {
+		w.Header().Set("Cache-Status", aiCacheName+"; hit")
+		respondWithRawJSON(w, body)
+		return key, true
+	}
+	w.Header().Set("Cache-Status", aiCacheName+"; fwd=miss")
+	return key, false
+}
+
+// respondWithCachedJSON responds with data as JSON, caching the response under key unless it's ""
+func (h *AIHandlers) respondWithCachedJSON(w http.ResponseWriter, r *http.Request, key string, data interface{}) {
+	if key == "" {
+		respondWithJSON(w, data, http.StatusOK)
+		return
+	}
+	body, err := json.Marshal(withEmptySlices(data))
+	if err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+		respondWithError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	h.aiCache.Set(r.Context(), key, body)
+	respondWithRawJSON(w, body)
+}
+
+// aiKeyFingerprint identifies who pays for an AI request without keeping the API key: a hash of the
+// caller's key, or the trial user for trial requests on the server's key. It's "" if neither is known.
+func aiKeyFingerprint(r *http.Request, userApiKey string) string {
+	if userApiKey != "" {
+		sum := sha256.Sum256([]byte(userApiKey))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	if isTrialRequest(r) {
+		if userID, err := GetUserID(r); err == nil {
+			return "trial:" + userID
+		}
+	}
+	return ""
+}
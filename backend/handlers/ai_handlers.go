@@ -4,34 +4,54 @@ package handlers
 import (
 	"backend/models"
 	"backend/services"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+)
+
+// Token counting request limits
+const (
+	maxTokenCountModels   = 5
+	maxTokenCountContents = 100
+)
+
+// Title generation request limits
+const (
+	defaultTitleMaxLength = 60
+	maxTitleMaxLength     = 200
+	maxTitleBatchNotes    = 20
 )
 
 // AIHandlers handles AI-powered HTTP endpoints
 type AIHandlers struct {
+	db            *services.Database
+	users         services.AIUserRepository // The caller's AI region and AI-excluded notes
 	geminiService *services.GeminiService
+	aiCache       *services.AICache // Cleanup and relevant notes responses; nil when disabled
 }
 
 // NewAIHandlers creates a new AIHandlers instance
-func NewAIHandlers(geminiService *services.GeminiService) *AIHandlers {
+func NewAIHandlers(db *services.Database, geminiService *services.GeminiService, aiCache *services.AICache) *AIHandlers {
 	return &AIHandlers{
+		db:            db,
+		users:         db,
 		geminiService: geminiService,
+		aiCache:       aiCache,
 	}
 }
 
 // HandleChat handles POST /api/chat - chat with AI
 func (h *AIHandlers) HandleChat(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Get API key from header (user's key)
-	userApiKey := r.Header.Get("X-API-Key")
-	if userApiKey == "" {
+	// Get the user's API key from the header, or their stored key; trial sessions may use the server's
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" && !isTrialRequest(r) {
 		respondWithError(w, "API key required", http.StatusUnauthorized)
 		return
 	}
@@ -48,30 +68,29 @@ func (h *AIHandlers) HandleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	contextNotes, err := withoutAIExcluded(r, h.users, req.ContextNotes)
+	if err != nil {
+		log.Printf("Error filtering AI-excluded notes: %v", err)
+		respondWithError(w, "Failed to get chat response", http.StatusInternalServerError)
+		return
+	}
+
 	// Create service with user's key based on provider
 	var response string
 
 	if req.Provider == "gemini" || req.Provider == "" {
-		geminiService, err := services.NewGeminiService(userApiKey)
+		geminiService, release, err := geminiFor(r, h.users, userApiKey, h.geminiService)
 		if err != nil {
 			log.Printf("Error initializing Gemini service: %v", err)
-			respondWithError(w, "Invalid API key", http.StatusUnauthorized)
+			respondWithGeminiInitError(w, err)
 			return
 		}
-		defer geminiService.Close()
+		defer release()
 
-		response, err = geminiService.GetChatResponse(req.Prompt, req.ContextNotes)
+		response, err = geminiService.GetChatResponse(r.Context(), req.Prompt, contextNotes, nil)
 		if err != nil {
 			log.Printf("Error getting chat response: %v", err)
-
-			// Check for quota/rate limit errors
-			errMsg := err.Error()
-			if strings.Contains(errMsg, "quota") || strings.Contains(errMsg, "429") {
-				respondWithError(w, "API quota exceeded. Please check your API key's usage limits or try again later.", http.StatusTooManyRequests)
-				return
-			}
-
-			respondWithError(w, "Failed to get chat response", http.StatusInternalServerError)
+			respondWithAIError(w, err, "Failed to get chat response")
 			return
 		}
 	} else {
@@ -84,13 +103,8 @@ func (h *AIHandlers) HandleChat(w http.ResponseWriter, r *http.Request) {
 
 // HandleRelevantNotes handles POST /api/notes/relevant - find relevant notes
 func (h *AIHandlers) HandleRelevantNotes(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Get API key from header (user's key)
-	userApiKey := r.Header.Get("X-API-Key")
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
 	if userApiKey == "" {
 		respondWithError(w, "API key required", http.StatusUnauthorized)
 		return
@@ -103,49 +117,110 @@ func (h *AIHandlers) HandleRelevantNotes(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	allNotes, err := withoutAIExcluded(r, h.users, req.AllNotes)
+	if err != nil {
+		log.Printf("Error filtering AI-excluded notes: %v", err)
+		respondWithError(w, "Failed to find relevant notes", http.StatusInternalServerError)
+		return
+	}
+
 	// Create service with user's key based on provider
 	var relevantNotes []models.Note
 
 	if req.Provider == "gemini" || req.Provider == "" {
-		geminiService, err := services.NewGeminiService(userApiKey)
+		cacheKey, served := h.cachedAIResponse(w, r, "relevant-notes", userApiKey, models.RelevantNotesRequest{
+			CurrentContent: req.CurrentContent,
+			AllNotes:       allNotes,
+		})
+		if served {
+			return
+		}
+
+		geminiService, err := userGemini(r, h.users, userApiKey)
 		if err != nil {
 			log.Printf("Error initializing Gemini service: %v", err)
-			respondWithError(w, "Invalid API key", http.StatusUnauthorized)
+			respondWithGeminiInitError(w, err)
 			return
 		}
 		defer geminiService.Close()
 
-		relevantNotes, err = geminiService.FindRelevantNotes(req.CurrentContent, req.AllNotes)
+		relevantNotes, err = geminiService.FindRelevantNotes(r.Context(), req.CurrentContent, allNotes)
 		if err != nil {
 			log.Printf("Error finding relevant notes: %v", err)
-			respondWithError(w, "Failed to find relevant notes", http.StatusInternalServerError)
+			respondWithAIError(w, err, "Failed to find relevant notes")
 			return
 		}
+		h.respondWithCachedJSON(w, r, cacheKey, map[string]interface{}{"relevantNotes": relevantNotes})
 	} else {
 		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
-		return
 	}
-
-	respondWithJSON(w, map[string]interface{}{"relevantNotes": relevantNotes}, http.StatusOK)
 }
 
 // HandleCleanup handles POST /api/notes/cleanup - clean up note content
 func (h *AIHandlers) HandleCleanup(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Get the user's API key from the header, or their stored key; trial sessions may use the server's
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" && !isTrialRequest(r) {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CleanupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding cleanup request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Get API key from header (user's key)
-	userApiKey := r.Header.Get("X-API-Key")
+	if req.Content == "" {
+		respondWithError(w, "Content is required", http.StatusBadRequest)
+		return
+	}
+
+	// Create service with user's key based on provider
+	var cleanedContent string
+
+	if req.Provider == "gemini" || req.Provider == "" {
+		cacheKey, served := h.cachedAIResponse(w, r, "cleanup", userApiKey, req.Content)
+		if served {
+			return
+		}
+
+		geminiService, release, err := geminiFor(r, h.users, userApiKey, h.geminiService)
+		if err != nil {
+			log.Printf("Error initializing Gemini service: %v", err)
+			respondWithGeminiInitError(w, err)
+			return
+		}
+		defer release()
+
+		cleanedContent, err = geminiService.CleanUpNote(r.Context(), req.Content)
+		if err != nil {
+			log.Printf("Error cleaning up note: %v", err)
+			respondWithAIError(w, err, "Failed to clean up note")
+			return
+		}
+		h.respondWithCachedJSON(w, r, cacheKey, map[string]string{"cleanedContent": cleanedContent})
+	} else {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+	}
+}
+
+// maxTargetLanguageLength bounds the target language of a translation, which goes into the prompt
+const maxTargetLanguageLength = 50
+
+// HandleTranslate handles POST /api/notes/translate - translate note content, keeping its markdown
+func (h *AIHandlers) HandleTranslate(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
 	if userApiKey == "" {
 		respondWithError(w, "API key required", http.StatusUnauthorized)
 		return
 	}
 
-	var req models.CleanupRequest
+	var req models.TranslateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error decoding cleanup request: %v", err)
+		log.Printf("Error decoding translate request: %v", err)
 		respondWithError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
@@ -154,23 +229,32 @@ func (h *AIHandlers) HandleCleanup(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, "Content is required", http.StatusBadRequest)
 		return
 	}
+	req.TargetLanguage = strings.TrimSpace(req.TargetLanguage)
+	if req.TargetLanguage == "" {
+		respondWithError(w, "targetLanguage is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.TargetLanguage) > maxTargetLanguageLength || strings.ContainsAny(req.TargetLanguage, "\r\n") {
+		respondWithError(w, "Invalid targetLanguage", http.StatusBadRequest)
+		return
+	}
 
 	// Create service with user's key based on provider
-	var cleanedContent string
+	var translatedContent string
 
 	if req.Provider == "gemini" || req.Provider == "" {
-		geminiService, err := services.NewGeminiService(userApiKey)
+		geminiService, err := userGemini(r, h.users, userApiKey)
 		if err != nil {
 			log.Printf("Error initializing Gemini service: %v", err)
-			respondWithError(w, "Invalid API key", http.StatusUnauthorized)
+			respondWithGeminiInitError(w, err)
 			return
 		}
 		defer geminiService.Close()
 
-		cleanedContent, err = geminiService.CleanUpNote(req.Content)
+		translatedContent, err = geminiService.TranslateNote(r.Context(), req.Content, req.TargetLanguage)
 		if err != nil {
-			log.Printf("Error cleaning up note: %v", err)
-			respondWithError(w, "Failed to clean up note", http.StatusInternalServerError)
+			log.Printf("Error translating note: %v", err)
+			respondWithAIError(w, err, "Failed to translate note")
 			return
 		}
 	} else {
@@ -178,16 +262,396 @@ func (h *AIHandlers) HandleCleanup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJSON(w, map[string]string{"cleanedContent": cleanedContent}, http.StatusOK)
+	respondWithJSON(w, map[string]string{"translatedContent": translatedContent}, http.StatusOK)
 }
 
-// HandleValidateKey handles POST /api/validate-key - validate API key
-func (h *AIHandlers) HandleValidateKey(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// summaryLengths are the accepted summary length presets
+var summaryLengths = map[string]bool{
+	models.SummaryLengthOneLine:   true,
+	models.SummaryLengthParagraph: true,
+	models.SummaryLengthBullets:   true,
+}
+
+// HandleSummarize handles POST /api/notes/summarize - summarize note content at a length preset
+func (h *AIHandlers) HandleSummarize(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key; trial sessions may use the server's
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" && !isTrialRequest(r) {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
 		return
 	}
 
+	var req models.SummarizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding summarize request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Content == "" {
+		respondWithError(w, "Content is required", http.StatusBadRequest)
+		return
+	}
+	if req.Length == "" {
+		req.Length = models.SummaryLengthParagraph
+	}
+	if !summaryLengths[req.Length] {
+		respondWithError(w, "length must be one-line, paragraph, or bullets", http.StatusBadRequest)
+		return
+	}
+
+	// Create service with user's key based on provider
+	var summary string
+
+	if req.Provider == "gemini" || req.Provider == "" {
+		geminiService, release, err := geminiFor(r, h.users, userApiKey, h.geminiService)
+		if err != nil {
+			log.Printf("Error initializing Gemini service: %v", err)
+			respondWithGeminiInitError(w, err)
+			return
+		}
+		defer release()
+
+		summary, err = geminiService.SummarizeNote(r.Context(), req.Content, req.Length)
+		if err != nil {
+			log.Printf("Error summarizing note: %v", err)
+			respondWithAIError(w, err, "Failed to summarize note")
+			return
+		}
+	} else {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, map[string]string{"summary": summary, "length": req.Length}, http.StatusOK)
+}
+
+// HandleGenerateTitle handles POST /api/notes/title - generate a concise title for note content
+func (h *AIHandlers) HandleGenerateTitle(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.GenerateTitleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding title request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Content) == "" {
+		respondWithError(w, "Content is required", http.StatusBadRequest)
+		return
+	}
+	maxLength, ok := titleMaxLength(w, req.MaxLength)
+	if !ok {
+		return
+	}
+
+	// Create service with user's key based on provider
+	var title string
+
+	if req.Provider == "gemini" || req.Provider == "" {
+		geminiService, err := userGemini(r, h.users, userApiKey)
+		if err != nil {
+			log.Printf("Error initializing Gemini service: %v", err)
+			respondWithGeminiInitError(w, err)
+			return
+		}
+		defer geminiService.Close()
+
+		title, err = geminiService.GenerateTitle(r.Context(), req.Content, maxLength)
+		if err != nil {
+			log.Printf("Error generating title: %v", err)
+			respondWithAIError(w, err, "Failed to generate title")
+			return
+		}
+	} else {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, map[string]string{"title": title}, http.StatusOK)
+}
+
+// HandleGenerateTitles handles POST /api/notes/title/batch - generate titles for several notes in one
+// request, keyed by note ID
+func (h *AIHandlers) HandleGenerateTitles(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.GenerateTitlesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding titles request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Notes) == 0 {
+		respondWithError(w, "Notes are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Notes) > maxTitleBatchNotes {
+		respondWithError(w, fmt.Sprintf("At most %d notes per request", maxTitleBatchNotes), http.StatusBadRequest)
+		return
+	}
+	seen := make(map[string]bool, len(req.Notes))
+	for _, note := range req.Notes {
+		if note.ID == "" || seen[note.ID] {
+			respondWithError(w, "Every note needs a unique id", http.StatusBadRequest)
+			return
+		}
+		seen[note.ID] = true
+	}
+	maxLength, ok := titleMaxLength(w, req.MaxLength)
+	if !ok {
+		return
+	}
+
+	// Untitled notes without content have nothing to title
+	notes := make([]models.TitleInput, 0, len(req.Notes))
+	for _, note := range req.Notes {
+		if strings.TrimSpace(note.Content) != "" {
+			notes = append(notes, note)
+		}
+	}
+
+	resp := models.GenerateTitlesResponse{Titles: map[string]string{}}
+
+	if req.Provider == "gemini" || req.Provider == "" {
+		if len(notes) > 0 {
+			geminiService, err := userGemini(r, h.users, userApiKey)
+			if err != nil {
+				log.Printf("Error initializing Gemini service: %v", err)
+				respondWithGeminiInitError(w, err)
+				return
+			}
+			defer geminiService.Close()
+
+			resp.Titles, err = geminiService.GenerateTitles(r.Context(), notes, maxLength)
+			if err != nil {
+				log.Printf("Error generating titles: %v", err)
+				respondWithAIError(w, err, "Failed to generate titles")
+				return
+			}
+		}
+	} else {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, resp, http.StatusOK)
+}
+
+// HandleExtractActions handles POST /api/notes/actions - extract action items (tasks with optional
+// due dates and priorities) from note content
+func (h *AIHandlers) HandleExtractActions(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ExtractActionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding action items request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Content == "" {
+		respondWithError(w, "Content is required", http.StatusBadRequest)
+		return
+	}
+	if req.Today == "" {
+		req.Today = time.Now().UTC().Format(time.DateOnly)
+	} else if _, err := time.Parse(time.DateOnly, req.Today); err != nil {
+		respondWithError(w, "today must be a YYYY-MM-DD date", http.StatusBadRequest)
+		return
+	}
+
+	// Create service with user's key based on provider
+	var tasks []models.ActionItem
+
+	if req.Provider == "gemini" || req.Provider == "" {
+		geminiService, err := userGemini(r, h.users, userApiKey)
+		if err != nil {
+			log.Printf("Error initializing Gemini service: %v", err)
+			respondWithGeminiInitError(w, err)
+			return
+		}
+		defer geminiService.Close()
+
+		tasks, err = geminiService.ExtractActions(r.Context(), req.Content, req.Today)
+		if err != nil {
+			log.Printf("Error extracting action items: %v", err)
+			respondWithAIError(w, err, "Failed to extract action items")
+			return
+		}
+	} else {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, models.ExtractActionsResponse{Tasks: tasks}, http.StatusOK)
+}
+
+// HandleSmartAppend handles POST /api/notes/append-smart - merge a quick capture into an existing note
+func (h *AIHandlers) HandleSmartAppend(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SmartAppendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding smart append request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.NoteID == "" {
+		respondWithError(w, "Note ID is required", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Capture) == "" {
+		respondWithError(w, "Capture is required", http.StatusBadRequest)
+		return
+	}
+
+	kept, err := withoutAIExcluded(r, h.users, []models.Note{{ID: req.NoteID}})
+	if err != nil {
+		log.Printf("Error filtering AI-excluded notes: %v", err)
+		respondWithError(w, "Failed to append capture", http.StatusInternalServerError)
+		return
+	}
+	if len(kept) == 0 {
+		respondWithError(w, "The note is excluded from AI features", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Create service with user's key based on provider
+	var section, mergedContent string
+
+	if req.Provider == "gemini" || req.Provider == "" {
+		geminiService, err := userGemini(r, h.users, userApiKey)
+		if err != nil {
+			log.Printf("Error initializing Gemini service: %v", err)
+			respondWithGeminiInitError(w, err)
+			return
+		}
+		defer geminiService.Close()
+
+		section, mergedContent, err = geminiService.SmartAppend(r.Context(), req.NoteContent, req.Capture)
+		if err != nil {
+			log.Printf("Error appending capture to note %s: %v", req.NoteID, err)
+			respondWithAIError(w, err, "Failed to append capture")
+			return
+		}
+	} else {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, models.SmartAppendResponse{
+		NoteID:        req.NoteID,
+		Section:       section,
+		MergedContent: mergedContent,
+	}, http.StatusOK)
+}
+
+// HandleCountTokens handles POST /api/ai/count-tokens - count tokens per model so clients can warn
+// before sending an oversized request
+func (h *AIHandlers) HandleCountTokens(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CountTokensRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding count tokens request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Contents) == 0 {
+		respondWithError(w, "Contents are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Contents) > maxTokenCountContents {
+		respondWithError(w, fmt.Sprintf("At most %d contents per request", maxTokenCountContents), http.StatusBadRequest)
+		return
+	}
+	if len(req.Models) > maxTokenCountModels {
+		respondWithError(w, fmt.Sprintf("At most %d models per request", maxTokenCountModels), http.StatusBadRequest)
+		return
+	}
+
+	contents := make([]string, len(req.Contents))
+	for i, c := range req.Contents {
+		contents[i] = c.Content
+	}
+
+	var resp models.CountTokensResponse
+
+	if req.Provider == "gemini" || req.Provider == "" {
+		modelNames := req.Models
+		if len(modelNames) == 0 {
+			modelNames = []string{services.DefaultGeminiModel}
+		}
+		for _, name := range modelNames {
+			if !strings.HasPrefix(name, "gemini-") {
+				respondWithError(w, fmt.Sprintf("Unsupported model %q", name), http.StatusBadRequest)
+				return
+			}
+		}
+
+		geminiService, err := userGemini(r, h.users, userApiKey)
+		if err != nil {
+			log.Printf("Error initializing Gemini service: %v", err)
+			respondWithGeminiInitError(w, err)
+			return
+		}
+		defer geminiService.Close()
+
+		for _, name := range modelNames {
+			counts, limit, err := geminiService.CountTokens(r.Context(), name, contents)
+			if err != nil {
+				log.Printf("Error counting tokens: %v", err)
+				respondWithAIError(w, err, "Failed to count tokens")
+				return
+			}
+
+			modelCounts := models.ModelTokenCounts{Model: name, InputTokenLimit: limit, Counts: make([]models.TokenCount, len(counts))}
+			for i, n := range counts {
+				modelCounts.Counts[i] = models.TokenCount{ID: req.Contents[i].ID, Tokens: n}
+				modelCounts.Total += n
+			}
+			resp.Models = append(resp.Models, modelCounts)
+		}
+	} else {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, resp, http.StatusOK)
+}
+
+// HandleValidateKey handles POST /api/validate-key - validate API key
+func (h *AIHandlers) HandleValidateKey(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Provider string `json:"provider"`
 		ApiKey   string `json:"apiKey"`
@@ -228,14 +692,156 @@ func (h *AIHandlers) HandleValidateKey(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// withoutAIExcluded drops the notes the signed-in user excluded from AI features before they're sent to a
+// provider, and fails rather than return them unfiltered if the lookup fails. AI routes authenticate any
+// request with a session token (OptionalAuth), so only callers identified by nothing but their API key
+// get the notes back as given; clients leave excluded notes out too.
+func withoutAIExcluded(r *http.Request, users services.AIUserRepository, notes []models.Note) ([]models.Note, error) {
+	userID, err := GetUserID(r)
+	if err != nil || len(notes) == 0 {
+		return notes, nil
+	}
+
+	ids := make([]string, 0, len(notes))
+	for _, note := range notes {
+		ids = append(ids, note.ID)
+	}
+	excluded, err := users.AIExcludedNoteIDs(r.Context(), userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(excluded) == 0 {
+		return notes, nil
+	}
+
+	kept := make([]models.Note, 0, len(notes)-len(excluded))
+	for _, note := range notes {
+		if !excluded[note.ID] {
+			kept = append(kept, note)
+		}
+	}
+	return kept, nil
+}
+
+// respondWithJSON writes data as JSON, with nil slices as empty arrays
 func respondWithJSON(w http.ResponseWriter, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(data); err != nil {
+	if err := json.NewEncoder(w).Encode(withEmptySlices(data)); err != nil {
 		log.Printf("Error encoding JSON response: %v", err)
 	}
 }
 
+// respondWithRawJSON writes an already encoded JSON body with 200 OK
+func respondWithRawJSON(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
 func respondWithError(w http.ResponseWriter, message string, status int) {
 	respondWithJSON(w, models.ErrorResponse{Error: message}, status)
 }
+
+// titleMaxLength validates a title length request, responding with an error if it's out of range, and
+// returns the default for 0
+func titleMaxLength(w http.ResponseWriter, maxLength int) (int, bool) {
+	switch {
+	case maxLength == 0:
+		return defaultTitleMaxLength, true
+	case maxLength < 0 || maxLength > maxTitleMaxLength:
+		respondWithError(w, fmt.Sprintf("maxLength must be between 1 and %d", maxTitleMaxLength), http.StatusBadRequest)
+		return 0, false
+	}
+	return maxLength, true
+}
+
+// userGemini creates a Gemini service for the caller's key in their AI region (see aiRegion)
+func userGemini(r *http.Request, users services.AIUserRepository, apiKey string) (*services.GeminiService, error) {
+	region, err := aiRegion(r, users)
+	if err != nil {
+		return nil, err
+	}
+	return services.NewGeminiServiceInRegion(apiKey, region)
+}
+
+// aiRegion returns the AI region a request runs in: the one in the signed-in user's settings, which the
+// client can't override, otherwise the one it names in the X-AI-Region header. AI routes sign in any
+// request with a session token (OptionalAuth), so the header only decides for users who chose no
+// region and for callers identified by nothing but their API key.
+func aiRegion(r *http.Request, users services.AIUserRepository) (string, error) {
+	if userID, err := GetUserID(r); err == nil {
+		region, err := users.ProviderRegion(r.Context(), userID)
+		if err != nil {
+			return "", fmt.Errorf("failed to load AI region: %w", err)
+		}
+		if region != "" {
+			return region, nil
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(r.Header.Get("X-AI-Region"))), nil
+}
+
+// respondWithGeminiInitError responds to a failure to create the caller's Gemini service
+func respondWithGeminiInitError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrProviderRegionUnavailable) {
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "The selected AI region is not available",
+			Code:  models.ErrCodeRegionUnavailable,
+		}, http.StatusServiceUnavailable)
+		return
+	}
+	respondWithError(w, "Invalid API key", http.StatusUnauthorized)
+}
+
+// respondWithAIError maps an AI provider error to a response, using message for unexpected failures
+func respondWithAIError(w http.ResponseWriter, err error, message string) {
+	resp, status := aiErrorResponse(err, message)
+	if resp.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(resp.RetryAfter))
+	}
+	respondWithJSON(w, resp, status)
+}
+
+// aiErrorResponse classifies an AI provider error into an error response and HTTP status
+func aiErrorResponse(err error, message string) (models.ErrorResponse, int) {
+	var unavailable *services.ProviderUnavailableError
+	if errors.As(err, &unavailable) {
+		return models.ErrorResponse{
+			Error:      "AI provider is temporarily unavailable. Please try again shortly.",
+			Code:       models.ErrCodeProviderUnavailable,
+			RetryAfter: int(math.Ceil(unavailable.RetryAfter.Seconds())),
+		}, http.StatusServiceUnavailable
+	}
+
+	if services.IsProviderOverloaded(err) {
+		return models.ErrorResponse{
+			Error: "AI provider is temporarily unavailable. Please try again shortly.",
+			Code:  models.ErrCodeProviderUnavailable,
+		}, http.StatusServiceUnavailable
+	}
+
+	if errors.Is(err, services.ErrMockUnsupported) {
+		return models.ErrorResponse{Error: "This AI feature is not available with the mock AI provider"}, http.StatusNotImplemented
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return models.ErrorResponse{
+			Error: "AI provider took too long to respond. Please try again.",
+			Code:  models.ErrCodeProviderTimeout,
+		}, http.StatusGatewayTimeout
+	}
+
+	// Check for quota/rate limit errors
+	errMsg := err.Error()
+	if strings.Contains(errMsg, "quota") || strings.Contains(errMsg, "429") {
+		return models.ErrorResponse{
+			Error: "API quota exceeded. Please check your API key's usage limits or try again later.",
+			Code:  models.ErrCodeQuotaExceeded,
+		}, http.StatusTooManyRequests
+	}
+
+	return models.ErrorResponse{Error: message}, http.StatusInternalServerError
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"backend/services/repositorytest"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// storeExcluding returns a store in which the user opted the notes out of AI processing
+func storeExcluding(userID string, noteIDs ...string) *repositorytest.Store {
+	store := repositorytest.NewStore()
+	excluded := true
+	for _, id := range noteIDs {
+		store.AddNote(models.SyncNote{ID: id, UserID: userID, AIExcluded: &excluded})
+	}
+	return store
+}
+
+// failingStore returns a store whose every lookup fails
+func failingStore() *repositorytest.Store {
+	store := repositorytest.NewStore()
+	store.Err = errors.New("database unavailable")
+	return store
+}
+
+// useMockAI answers AI requests with the mock provider for the rest of the test
+func useMockAI(t *testing.T) {
+	t.Helper()
+	if err := services.SetAIProvider(services.AIProviderMock); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := services.SetAIProvider(services.AIProviderGemini); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// apiKeyRequest builds an AI request sent with X-API-Key by a user OptionalAuth signed in
+func apiKeyRequest(t *testing.T, path, userID string, body interface{}) *http.Request {
+	t.Helper()
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(encoded))
+	r.Header.Set("X-API-Key", "test-key")
+	ctx := context.WithValue(r.Context(), userIDKey, userID)
+	ctx = context.WithValue(ctx, sessionUserIDKey, userID)
+	return r.WithContext(ctx)
+}
+
+func TestRelevantNotesDropsAIExcludedNotes(t *testing.T) {
+	useMockAI(t)
+	h := &AIHandlers{users: storeExcluding("user_1", "secret")}
+
+	w := httptest.NewRecorder()
+	h.HandleRelevantNotes(w, apiKeyRequest(t, "/api/notes/relevant", "user_1", models.RelevantNotesRequest{
+		CurrentContent: "quarterly budget review",
+		AllNotes: []models.Note{
+			{ID: "public", Title: "Budget", Content: "quarterly budget review"},
+			{ID: "secret", Title: "Salaries", Content: "quarterly budget review"},
+		},
+	}))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		RelevantNotes []models.Note `json:"relevantNotes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.RelevantNotes) != 1 || resp.RelevantNotes[0].ID != "public" {
+		t.Errorf("relevantNotes = %+v, want only the public note", resp.RelevantNotes)
+	}
+}
+
+func TestChatDropsAIExcludedNotes(t *testing.T) {
+	useMockAI(t)
+	h := &AIHandlers{users: storeExcluding("user_1", "secret")}
+
+	w := httptest.NewRecorder()
+	h.HandleChat(w, apiKeyRequest(t, "/api/chat", "user_1", models.ChatRequest{
+		Prompt: "What's in my notes?",
+		ContextNotes: []models.Note{
+			{ID: "public", Title: "Groceries"},
+			{ID: "secret", Title: "Diary"},
+		},
+	}))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Groceries") || strings.Contains(body, "Diary") {
+		t.Errorf("response = %s, want only the public note sent to the provider", body)
+	}
+}
+
+func TestAIExclusionLookupFailureFailsClosed(t *testing.T) {
+	useMockAI(t)
+	h := &AIHandlers{users: failingStore()}
+
+	w := httptest.NewRecorder()
+	h.HandleChat(w, apiKeyRequest(t, "/api/chat", "user_1", models.ChatRequest{
+		Prompt:       "What's in my notes?",
+		ContextNotes: []models.Note{{ID: "secret", Title: "Diary"}},
+	}))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "Diary") {
+		t.Errorf("response leaked the note: %s", w.Body)
+	}
+}
+
+func TestOptionalAuthPassesThroughWithoutSessionToken(t *testing.T) {
+	var gotUser string
+	handler := OptionalAuth(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, _ = GetUserID(r)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+	r.Header.Set("X-API-Key", "test-key")
+	handler(httptest.NewRecorder(), r)
+	if gotUser != "" {
+		t.Errorf("user = %q, want none for an API-key-only request", gotUser)
+	}
+
+	handler(httptest.NewRecorder(), apiKeyRequest(t, "/api/chat", "user_1", nil))
+	if gotUser != "user_1" {
+		t.Errorf("user = %q, want the already authenticated user_1", gotUser)
+	}
+}
+
+func TestAIRegionPrefersStoredRegion(t *testing.T) {
+	users := repositorytest.NewStore()
+	region := "eu"
+	users.SetUserSettings("pinned", models.UserSettings{ConflictPolicy: models.ConflictLastWriteWins, Timezone: models.DefaultTimezone, AIRegion: &region})
+	tests := []struct {
+		name   string
+		userID string // "" for an API-key-only request
+		header string
+		want   string
+	}{
+		{name: "stored region overrides header", userID: "pinned", header: "us", want: "eu"},
+		{name: "stored region without header", userID: "pinned", want: "eu"},
+		{name: "user without a region uses header", userID: "unpinned", header: "US ", want: "us"},
+		{name: "API key only uses header", header: "us", want: "us"},
+		{name: "default endpoint", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+			if tt.userID != "" {
+				r = apiKeyRequest(t, "/api/chat", tt.userID, nil)
+			}
+			if tt.header != "" {
+				r.Header.Set("X-AI-Region", tt.header)
+			}
+			got, err := aiRegion(r, users)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("aiRegion = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAIRegionLookupFailure(t *testing.T) {
+	r := apiKeyRequest(t, "/api/chat", "user_1", nil)
+	r.Header.Set("X-AI-Region", "us")
+	if _, err := aiRegion(r, failingStore()); err == nil {
+		t.Error("aiRegion fell back to the header after failing to load the stored region")
+	}
+}
@@ -4,7 +4,10 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/clerk/clerk-sdk-go/v2"
 	clerkhttp "github.com/clerk/clerk-sdk-go/v2/http"
@@ -13,13 +16,61 @@ import (
 // contextKey is a custom type for context keys to avoid collisions
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey        contextKey = "userID"
+	sessionUserIDKey contextKey = "sessionUserID"
+	trialKey         contextKey = "trial"
+)
+
+// adminUserIDs holds the Clerk user IDs granted the admin role
+var adminUserIDs = map[string]bool{}
+
+// authorizedParties holds the origins whose session tokens are accepted; empty accepts any
+var authorizedParties = map[string]bool{}
+
+// sessionTokenLeeway absorbs clock skew between Clerk and this server when checking exp and nbf
+const sessionTokenLeeway = 5 * time.Second
+
+// SetAuthorizedParties configures which origins (the azp claim, e.g. "https://app.example.com" or
+// "chrome-extension://<id>") may present session tokens
+func SetAuthorizedParties(parties []string) {
+	authorizedParties = map[string]bool{}
+	for _, party := range parties {
+		if party = strings.TrimRight(strings.TrimSpace(party), "/"); party != "" {
+			authorizedParties[party] = true
+		}
+	}
+}
+
+// authorizedParty reports whether a token's azp claim is allowed. Like Clerk's own backend SDKs, tokens
+// without an azp (issued outside a browser) are accepted.
+func authorizedParty(azp string) bool {
+	return azp == "" || len(authorizedParties) == 0 || authorizedParties[azp]
+}
+
+// SetAdminUserIDs configures which Clerk user IDs have the admin role
+func SetAdminUserIDs(ids []string) {
+	adminUserIDs = map[string]bool{}
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			adminUserIDs[id] = true
+		}
+	}
+}
 
 // AuthMiddleware validates Clerk JWT tokens using Clerk SDK
 // It wraps Clerk's middleware and extracts user ID to our custom context
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	// Use Clerk's built-in middleware for token verification
-	clerkMiddleware := clerkhttp.WithHeaderAuthorization()
+	// Use Clerk's built-in middleware for token verification: RS256 signatures are checked against the
+	// instance's JWKS, cached per key ID (so a rotated key is fetched on first sight), along with
+	// exp/nbf and the authorized party
+	clerkMiddleware := clerkhttp.WithHeaderAuthorization(
+		clerkhttp.AuthorizedParty(authorizedParty),
+		clerkhttp.Leeway(sessionTokenLeeway),
+		clerkhttp.AuthorizationFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			respondWithError(w, "Invalid or expired token", http.StatusUnauthorized)
+		})),
+	)
 
 	// Wrap it to extract user ID and add to our custom context
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -39,8 +90,25 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 				return
 			}
 
+			// Multi-session clients may act on behalf of another account signed in on the same client
+			sessionUserID := userID
+			if accountID := r.Header.Get(accountHeader); accountID != "" && accountID != userID {
+				authorized, err := sessionAccounts.isAuthorized(r.Context(), claims.SessionID, accountID)
+				if err != nil {
+					log.Printf("Error checking authorized accounts for session %s: %v", claims.SessionID, err)
+					respondWithError(w, "Failed to verify account", http.StatusServiceUnavailable)
+					return
+				}
+				if !authorized {
+					respondWithError(w, "Account not authorized for this session", http.StatusForbidden)
+					return
+				}
+				userID = accountID
+			}
+
 			// Add user ID to our custom context
 			ctx := context.WithValue(r.Context(), userIDKey, userID)
+			ctx = context.WithValue(ctx, sessionUserIDKey, sessionUserID)
 			next(w, r.WithContext(ctx))
 		}))
 
@@ -48,6 +116,49 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// OptionalAuth authenticates requests carrying an Authorization header with AuthMiddleware and passes
+// others through unchanged, for routes that also serve callers identified only by their API key. The
+// signed-in user's settings (AI exclusions, AI region, stored keys) then apply whenever they're known.
+func OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	authenticated := AuthMiddleware(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := GetUserID(r); err == nil || r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+		authenticated(w, r)
+	}
+}
+
+// AdminMiddleware authenticates the request and rejects sessions of users without the admin role
+func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return AuthMiddleware(requireAdmin(next))
+}
+
+// requireAdmin rejects requests whose session user lacks the admin role. The role belongs to whoever
+// signed in, so acting on behalf of an admin account via X-Account-ID doesn't grant it.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionUserID, err := GetSessionUserID(r)
+		if err != nil || !adminUserIDs[sessionUserID] {
+			log.Printf("Denied admin access to %s for user %q", r.URL.Path, sessionUserID)
+			respondWithError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// GetSessionUserID returns the user who owns the session token, which differs from
+// GetUserID when the request acts on behalf of another account via X-Account-ID
+func GetSessionUserID(r *http.Request) (string, error) {
+	userID, ok := r.Context().Value(sessionUserIDKey).(string)
+	if !ok || userID == "" {
+		return "", fmt.Errorf("session user ID not found in context")
+	}
+	return userID, nil
+}
+
 // GetUserID extracts user ID from request context
 func GetUserID(r *http.Request) (string, error) {
 	userID, ok := r.Context().Value(userIDKey).(string)
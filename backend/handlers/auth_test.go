@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdminChecksSessionUser(t *testing.T) {
+	SetAdminUserIDs([]string{"admin"})
+	t.Cleanup(func() { SetAdminUserIDs(nil) })
+
+	tests := []struct {
+		name          string
+		sessionUserID string
+		accountID     string // The account acted on via X-Account-ID
+		want          int
+	}{
+		{name: "admin", sessionUserID: "admin", accountID: "admin", want: http.StatusOK},
+		{name: "admin acting for another account", sessionUserID: "admin", accountID: "alice", want: http.StatusOK},
+		{name: "user acting for an admin account", sessionUserID: "alice", accountID: "admin", want: http.StatusForbidden},
+		{name: "user", sessionUserID: "alice", accountID: "alice", want: http.StatusForbidden},
+		{name: "unauthenticated", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/api/admin/drain", nil)
+			if tt.sessionUserID != "" {
+				ctx := context.WithValue(r.Context(), userIDKey, tt.accountID)
+				ctx = context.WithValue(ctx, sessionUserIDKey, tt.sessionUserID)
+				r = r.WithContext(ctx)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+// HTTP handlers for the audio capture pipeline
+package handlers
+
+import (
+	"backend/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxAudioUploadSize is the largest audio upload accepted (recordings over the inline limit
+// go through the Gemini Files API)
+const maxAudioUploadSize = 100 << 20
+
+// maxBase64AudioSize is the largest recording accepted base64-encoded in a JSON body; larger ones must
+// be uploaded as multipart
+const maxBase64AudioSize = 20 << 20
+
+// captureTitleMaxLength is the maximum length of titles generated for captured notes
+const captureTitleMaxLength = 60
+
+// supportedAudioTypes lists the audio MIME types accepted by Gemini
+var supportedAudioTypes = map[string]bool{
+	"audio/wav":  true,
+	"audio/mp3":  true,
+	"audio/mpeg": true,
+	"audio/aiff": true,
+	"audio/aac":  true,
+	"audio/ogg":  true,
+	"audio/flac": true,
+}
+
+// audioTypeAliases maps non-canonical audio MIME types (browsers, content sniffing) to Gemini's names
+var audioTypeAliases = map[string]string{
+	"audio/wave":   "audio/wav",
+	"audio/x-wav":  "audio/wav",
+	"audio/x-aiff": "audio/aiff",
+	"audio/x-flac": "audio/flac",
+	"audio/x-aac":  "audio/aac",
+}
+
+// HandleCaptureAudio handles POST /api/capture/audio - transcribe, clean up, title, and file a voice memo.
+// Clients sending "Accept: text/event-stream" receive progress events followed by a result event.
+func (h *AIHandlers) HandleCaptureAudio(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	audio, mimeType, err := readAudioUpload(w, r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var collections []models.CollectionOption
+	if raw := r.FormValue("collections"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &collections); err != nil {
+			respondWithError(w, "Invalid collections", http.StatusBadRequest)
+			return
+		}
+	}
+
+	provider := r.FormValue("provider")
+	if provider != "gemini" && provider != "" {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	geminiService, err := userGemini(r, h.users, userApiKey)
+	if err != nil {
+		log.Printf("Error initializing Gemini service: %v", err)
+		respondWithGeminiInitError(w, err)
+		return
+	}
+	defer geminiService.Close()
+
+	// Progress is only reported when the client asked for a stream
+	var stream *sseWriter
+	if wantsEventStream(r) {
+		if s, ok := newSSEWriter(w); ok {
+			stream = s
+		}
+	}
+	progress := func(stage string) {
+		if stream == nil {
+			return
+		}
+		if err := stream.send("progress", models.CaptureProgress{Stage: stage}); err != nil {
+			log.Printf("Error sending capture progress: %v", err)
+		}
+	}
+
+	var result models.CaptureResult
+
+	progress("transcribing")
+	result.Transcript, err = geminiService.TranscribeAudio(r.Context(), audio, mimeType)
+	if err != nil {
+		log.Printf("Error transcribing audio: %v", err)
+		resp, status := aiErrorResponse(err, "Failed to transcribe audio")
+		h.respondCaptureError(w, stream, resp, status)
+		return
+	}
+	if result.Transcript == "" {
+		h.respondCaptureError(w, stream, models.ErrorResponse{Error: "No speech detected in recording"}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Later stages are best-effort: a failure degrades the result instead of losing the transcript
+	progress("cleaning")
+	result.CleanedContent, err = geminiService.CleanUpNote(r.Context(), result.Transcript)
+	if err != nil {
+		log.Printf("Error cleaning up transcript: %v", err)
+		result.CleanedContent = result.Transcript
+	}
+
+	progress("titling")
+	result.Title, err = geminiService.GenerateTitle(r.Context(), result.CleanedContent, captureTitleMaxLength)
+	if err != nil {
+		log.Printf("Error generating title: %v", err)
+	}
+
+	if len(collections) > 0 {
+		progress("categorizing")
+		result.CollectionID, err = geminiService.CategorizeNote(r.Context(), result.CleanedContent, collections)
+		if err != nil {
+			log.Printf("Error categorizing note: %v", err)
+		}
+	}
+
+	if stream != nil {
+		if err := stream.send("result", result); err != nil {
+			log.Printf("Error sending capture result: %v", err)
+		}
+		return
+	}
+	respondWithJSON(w, result, http.StatusOK)
+}
+
+// HandleTranscribe handles POST /api/transcribe - transcribe a recording, uploaded as a multipart "audio"
+// file or base64-encoded in a JSON body, and optionally clean up the transcript
+func (h *AIHandlers) HandleTranscribe(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TranscribeRequest
+	var audio []byte
+	var mimeType string
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		audio, mimeType, err = readAudioUpload(w, r)
+		req.Provider = r.FormValue("provider")
+		req.Cleanup = r.FormValue("cleanup") == "true"
+	} else {
+		audio, mimeType, err = readBase64Audio(w, r, &req)
+	}
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Provider != "gemini" && req.Provider != "" {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	geminiService, err := userGemini(r, h.users, userApiKey)
+	if err != nil {
+		log.Printf("Error initializing Gemini service: %v", err)
+		respondWithGeminiInitError(w, err)
+		return
+	}
+	defer geminiService.Close()
+
+	var resp models.TranscribeResponse
+	resp.Transcript, err = geminiService.TranscribeAudio(r.Context(), audio, mimeType)
+	if err != nil {
+		log.Printf("Error transcribing audio: %v", err)
+		respondWithAIError(w, err, "Failed to transcribe audio")
+		return
+	}
+	if resp.Transcript == "" {
+		respondWithError(w, "No speech detected in recording", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Cleanup is best-effort: a failure still returns the transcript
+	if req.Cleanup {
+		cleaned, err := geminiService.CleanUpNote(r.Context(), resp.Transcript)
+		if err != nil {
+			log.Printf("Error cleaning up transcript: %v", err)
+		} else {
+			resp.CleanedContent = cleaned
+		}
+	}
+
+	respondWithJSON(w, resp, http.StatusOK)
+}
+
+// respondCaptureError reports a pipeline failure either as an SSE error event or a JSON error
+func (h *AIHandlers) respondCaptureError(w http.ResponseWriter, stream *sseWriter, resp models.ErrorResponse, status int) {
+	if stream == nil {
+		if resp.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(resp.RetryAfter))
+		}
+		respondWithJSON(w, resp, status)
+		return
+	}
+	if err := stream.send("error", resp); err != nil {
+		log.Printf("Error sending capture error: %v", err)
+	}
+}
+
+// readAudioUpload reads the "audio" file from a multipart request and validates its size and format
+func readAudioUpload(w http.ResponseWriter, r *http.Request) (data []byte, mimeType string, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAudioUploadSize+(1<<20)) // Allow room for other form fields
+	// Uploads larger than 32MB spill to temp files instead of memory
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, "", fmt.Errorf("audio file exceeds %dMB limit", maxAudioUploadSize>>20)
+		}
+		return nil, "", errors.New("invalid multipart form")
+	}
+
+	file, header, err := r.FormFile("audio")
+	if err != nil {
+		return nil, "", errors.New("audio file is required")
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("Error closing audio upload: %v", err)
+		}
+	}()
+
+	if header.Size > maxAudioUploadSize {
+		return nil, "", fmt.Errorf("audio file exceeds %dMB limit", maxAudioUploadSize>>20)
+	}
+
+	data, err = io.ReadAll(file)
+	if err != nil {
+		return nil, "", errors.New("failed to read audio file")
+	}
+
+	mimeType, err = audioMIMEType(header.Header.Get("Content-Type"), data)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mimeType, nil
+}
+
+// readBase64Audio decodes a JSON transcribe request into req and validates its audio's size and format
+func readBase64Audio(w http.ResponseWriter, r *http.Request, req *models.TranscribeRequest) (data []byte, mimeType string, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(base64.StdEncoding.EncodedLen(maxBase64AudioSize))+(1<<10))
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, "", fmt.Errorf("base64 audio exceeds %dMB limit, upload larger files as multipart", maxBase64AudioSize>>20)
+		}
+		return nil, "", errors.New("invalid request body")
+	}
+	if req.Audio == "" {
+		return nil, "", errors.New("audio is required")
+	}
+
+	data, err = base64.StdEncoding.DecodeString(req.Audio)
+	if err != nil {
+		return nil, "", errors.New("audio must be base64-encoded")
+	}
+	if len(data) > maxBase64AudioSize {
+		return nil, "", fmt.Errorf("base64 audio exceeds %dMB limit, upload larger files as multipart", maxBase64AudioSize>>20)
+	}
+
+	mimeType, err = audioMIMEType(req.MimeType, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mimeType, nil
+}
+
+// audioMIMEType returns Gemini's name for the format of a recording, from its declared content type or,
+// failing that, its content, or an error if the format isn't supported
+func audioMIMEType(declared string, data []byte) (string, error) {
+	mimeType := strings.ToLower(strings.TrimSpace(strings.Split(declared, ";")[0]))
+	if !supportedAudioTypes[mimeType] {
+		mimeType = strings.Split(http.DetectContentType(data), ";")[0]
+	}
+	if alias, ok := audioTypeAliases[mimeType]; ok {
+		mimeType = alias
+	}
+	if !supportedAudioTypes[mimeType] {
+		return "", fmt.Errorf("unsupported audio format %q", mimeType)
+	}
+	return mimeType, nil
+}
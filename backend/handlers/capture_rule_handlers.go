@@ -0,0 +1,362 @@
+// HTTP handlers for domain-scoped capture rules
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxCaptureRules caps the rules a user can have
+const maxCaptureRules = 200
+
+// captureRuleColumns are the columns scanned by queryCaptureRules. Rules whose collection was deleted
+// report no collection.
+const captureRuleColumns = `r.id, r.domain, CASE WHEN c.deleted_at IS NULL THEN r.collection_id END, to_json(r.tags),
+	r.cleanup_on_capture, r.created_at, r.updated_at`
+
+// captureRuleFrom joins rules with their collection for captureRuleColumns
+const captureRuleFrom = `capture_rules r LEFT JOIN collections c ON c.id = r.collection_id`
+
+// domainPattern matches lowercase host names: dot-separated labels of letters, digits, and inner hyphens
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// errCaptureRuleNotFound is returned when a rule doesn't exist or belongs to another user
+var errCaptureRuleNotFound = errors.New("capture rule not found")
+
+// CaptureRuleHandlers handles capture rule HTTP endpoints
+type CaptureRuleHandlers struct {
+	db *services.Database
+}
+
+// NewCaptureRuleHandlers creates a new CaptureRuleHandlers instance
+func NewCaptureRuleHandlers(db *services.Database) *CaptureRuleHandlers {
+	return &CaptureRuleHandlers{db: db}
+}
+
+// HandleListCaptureRules handles GET /api/capture/rules - list the user's rules by domain
+func (h *CaptureRuleHandlers) HandleListCaptureRules(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	rules, err := h.listRules(ctx, userID)
+	if err != nil {
+		log.Printf("Error listing capture rules: %v", err)
+		respondWithError(w, "Failed to list capture rules", http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, map[string]interface{}{"rules": rules}, http.StatusOK)
+}
+
+// HandleCreateCaptureRule handles POST /api/capture/rules - add a rule
+func (h *CaptureRuleHandlers) HandleCreateCaptureRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var req models.CaptureRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Domain == nil {
+		respondWithError(w, "domain is required", http.StatusBadRequest)
+		return
+	}
+	if !h.validateRule(w, r, userID, &req) {
+		return
+	}
+
+	var count int
+	if err := h.db.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM capture_rules WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		log.Printf("Error counting capture rules: %v", err)
+		respondWithError(w, "Failed to create capture rule", http.StatusInternalServerError)
+		return
+	}
+	if count >= maxCaptureRules {
+		respondWithError(w, fmt.Sprintf("You can have at most %d capture rules", maxCaptureRules), http.StatusUnprocessableEntity)
+		return
+	}
+
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		log.Printf("Error generating capture rule ID: %v", err)
+		respondWithError(w, "Failed to create capture rule", http.StatusInternalServerError)
+		return
+	}
+	ruleID := "rule_" + hex.EncodeToString(idBytes)
+
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+	cleanup := req.CleanupOnCapture != nil && *req.CleanupOnCapture
+	_, err = h.db.DB.ExecContext(ctx, `
+		INSERT INTO capture_rules (id, user_id, domain, collection_id, tags, cleanup_on_capture)
+		VALUES ($1, $2, $3, NULLIF($4, ''), COALESCE($5, '{}'), $6)
+	`, ruleID, userID, *req.Domain, req.CollectionID, req.Tags, cleanup)
+	if _, ok := uniqueViolation(err); ok {
+		respondWithError(w, "A capture rule for this domain already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating capture rule: %v", err)
+		respondWithError(w, "Failed to create capture rule", http.StatusInternalServerError)
+		return
+	}
+
+	h.respondWithRule(w, r, userID, ruleID, http.StatusCreated)
+}
+
+// HandleDeleteCaptureRule handles DELETE /api/capture/rules/{id} - remove a rule
+func (h *CaptureRuleHandlers) HandleDeleteCaptureRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	ruleID := r.PathValue("id")
+	result, err := h.db.DB.ExecContext(ctx, `DELETE FROM capture_rules WHERE id = $1 AND user_id = $2`, ruleID, userID)
+	var deleted int64
+	if err == nil {
+		deleted, err = result.RowsAffected()
+	}
+	if err != nil {
+		log.Printf("Error deleting capture rule %s: %v", ruleID, err)
+		respondWithError(w, "Failed to delete capture rule", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, "Capture rule not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleUpdateCaptureRule handles PATCH /api/capture/rules/{id} - change a rule
+func (h *CaptureRuleHandlers) HandleUpdateCaptureRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	ruleID := r.PathValue("id")
+	var req models.CaptureRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.validateRule(w, r, userID, &req) {
+		return
+	}
+
+	result, err := h.db.DB.ExecContext(ctx, `
+		UPDATE capture_rules SET
+			domain = COALESCE($3, domain),
+			collection_id = CASE WHEN $4::text IS NULL THEN collection_id ELSE NULLIF($4, '') END,
+			tags = COALESCE($5, tags),
+			cleanup_on_capture = COALESCE($6, cleanup_on_capture),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2
+	`, ruleID, userID, req.Domain, req.CollectionID, req.Tags, req.CleanupOnCapture)
+	if _, ok := uniqueViolation(err); ok {
+		respondWithError(w, "A capture rule for this domain already exists", http.StatusConflict)
+		return
+	}
+	var updated int64
+	if err == nil {
+		updated, err = result.RowsAffected()
+	}
+	if err != nil {
+		log.Printf("Error updating capture rule %s: %v", ruleID, err)
+		respondWithError(w, "Failed to update capture rule", http.StatusInternalServerError)
+		return
+	}
+	if updated == 0 {
+		respondWithError(w, "Capture rule not found", http.StatusNotFound)
+		return
+	}
+
+	h.respondWithRule(w, r, userID, ruleID, http.StatusOK)
+}
+
+// HandleMatchCaptureRule handles GET /api/capture/rules/match?url= - the rule for a page being captured:
+// the one for its host or, failing that, for the closest parent domain. Returns {rule: null} if none applies.
+func (h *CaptureRuleHandlers) HandleMatchCaptureRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	page, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil || (page.Scheme != "http" && page.Scheme != "https") || page.Hostname() == "" {
+		respondWithError(w, "url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := matchCaptureRule(r.Context(), h.db, userID, page.Hostname())
+	if err != nil {
+		log.Printf("Error matching capture rules: %v", err)
+		respondWithError(w, "Failed to match capture rules", http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, models.CaptureRuleMatch{Rule: rule}, http.StatusOK)
+}
+
+// Helper functions
+
+// validateRule normalizes a rule request's domain and tags and checks its collection, responding with
+// the error if it's invalid
+func (h *CaptureRuleHandlers) validateRule(w http.ResponseWriter, r *http.Request, userID string, req *models.CaptureRuleRequest) bool {
+	if req.Domain != nil {
+		domain, err := normalizeCaptureDomain(*req.Domain)
+		if err != nil {
+			respondWithError(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		req.Domain = &domain
+	}
+
+	if req.Tags != nil {
+		tags, err := normalizeTags(req.Tags)
+		if err != nil {
+			respondWithError(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		req.Tags = tags
+	}
+
+	if req.CollectionID != nil && *req.CollectionID != "" {
+		_, err := services.CollectionAncestors(r.Context(), h.db.DB, userID, *req.CollectionID)
+		if errors.Is(err, services.ErrCollectionNotFound) {
+			respondWithError(w, "Unknown collection", http.StatusBadRequest)
+			return false
+		}
+		if err != nil {
+			log.Printf("Error checking collection %s: %v", *req.CollectionID, err)
+			respondWithError(w, "Failed to save capture rule", http.StatusInternalServerError)
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeCaptureDomain returns the lowercase host name of a domain, which may also be given as a URL
+// or with a leading "*." or "."
+func normalizeCaptureDomain(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if strings.Contains(domain, "://") {
+		if page, err := url.Parse(domain); err == nil {
+			domain = page.Hostname()
+		}
+	}
+	domain = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(domain, "*"), "."), ".")
+	if len(domain) > 253 || !domainPattern.MatchString(domain) {
+		return "", errors.New("domain must be a host name such as github.com")
+	}
+	return domain, nil
+}
+
+// matchCaptureRule returns the user's rule for a host or, failing that, for its closest parent domain,
+// or nil if none applies
+func matchCaptureRule(ctx context.Context, db *services.Database, userID, host string) (*models.CaptureRule, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	// The host itself and each parent domain; IP addresses only match themselves
+	domains := []string{host}
+	if net.ParseIP(host) == nil {
+		for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+			host = host[i+1:]
+			domains = append(domains, host)
+		}
+	}
+
+	rules, err := queryCaptureRules(ctx, db, `
+		SELECT `+captureRuleColumns+` FROM `+captureRuleFrom+`
+		WHERE r.user_id = $1 AND r.domain = ANY($2)
+		ORDER BY length(r.domain) DESC
+		LIMIT 1
+	`, userID, domains)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	return &rules[0], nil
+}
+
+// respondWithRule responds with one of the user's rules
+func (h *CaptureRuleHandlers) respondWithRule(w http.ResponseWriter, r *http.Request, userID, ruleID string, status int) {
+	rules, err := queryCaptureRules(r.Context(), h.db, `
+		SELECT `+captureRuleColumns+` FROM `+captureRuleFrom+` WHERE r.id = $1 AND r.user_id = $2
+	`, ruleID, userID)
+	if err == nil && len(rules) == 0 {
+		err = errCaptureRuleNotFound
+	}
+	if err != nil {
+		log.Printf("Error fetching capture rule %s: %v", ruleID, err)
+		respondWithError(w, "Failed to fetch capture rule", http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, rules[0], status)
+}
+
+// listRules returns all of the user's rules, ordered by domain
+func (h *CaptureRuleHandlers) listRules(ctx context.Context, userID string) ([]models.CaptureRule, error) {
+	return queryCaptureRules(ctx, h.db, `
+		SELECT `+captureRuleColumns+` FROM `+captureRuleFrom+` WHERE r.user_id = $1 ORDER BY r.domain
+	`, userID)
+}
+
+// queryCaptureRules runs a query selecting captureRuleColumns
+func queryCaptureRules(ctx context.Context, db *services.Database, query string, args ...interface{}) ([]models.CaptureRule, error) {
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	rules := []models.CaptureRule{}
+	for rows.Next() {
+		var rule models.CaptureRule
+		var collectionID sql.NullString
+		var tags []byte
+		if err := rows.Scan(&rule.ID, &rule.Domain, &collectionID, &tags, &rule.CleanupOnCapture,
+			&rule.CreatedAt, &rule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(tags, &rule.Tags); err != nil {
+			return nil, err
+		}
+		if collectionID.Valid {
+			rule.CollectionID = &collectionID.String
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
@@ -0,0 +1,462 @@
+// HTTP handlers for persisted multi-turn chat sessions
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxChatTitleLength bounds session titles (in characters)
+	maxChatTitleLength = 200
+	// chatHistoryLoadLimit is how many earlier messages are loaded for the prompt
+	chatHistoryLoadLimit = 20
+)
+
+// errChatSessionNotFound is returned when a session doesn't exist or belongs to another user
+var errChatSessionNotFound = errors.New("chat session not found")
+
+// ChatHandlers handles chat session HTTP endpoints
+type ChatHandlers struct {
+	db     *services.Database
+	sealer *services.Sealer
+	gemini *services.GeminiService // Shared service for trial sessions without their own key
+}
+
+// NewChatHandlers creates a new ChatHandlers instance. A nil sealer disables chat sessions.
+func NewChatHandlers(db *services.Database, sealer *services.Sealer, gemini *services.GeminiService) *ChatHandlers {
+	return &ChatHandlers{db: db, sealer: sealer, gemini: gemini}
+}
+
+// HandleListChatSessions handles GET /api/chat/sessions - list the user's sessions, most recently active
+// first, paginated
+func (h *ChatHandlers) HandleListChatSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.sealer == nil {
+		respondWithError(w, "Chat sessions are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := r.Context()
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := h.listSessions(ctx, userID, params)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing chat sessions: %v", err)
+		respondWithError(w, "Failed to list chat sessions", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(sessions, params.Limit, func(s models.ChatSession) pagination.Cursor {
+		return pagination.Cursor{SortValue: s.UpdatedAt, ID: s.ID}
+	}), http.StatusOK)
+}
+
+// HandleCreateChatSession handles POST /api/chat/sessions - start a new session
+func (h *ChatHandlers) HandleCreateChatSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.sealer == nil {
+		respondWithError(w, "Chat sessions are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := r.Context()
+	var req models.CreateChatSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Title) > maxChatTitleLength {
+		respondWithError(w, "Title can be at most 200 characters", http.StatusBadRequest)
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		log.Printf("Error generating chat session ID: %v", err)
+		respondWithError(w, "Failed to create chat session", http.StatusInternalServerError)
+		return
+	}
+	session := models.ChatSession{ID: "chat_" + hex.EncodeToString(idBytes), Title: req.Title}
+
+	var titleSealed []byte
+	if req.Title != "" {
+		if titleSealed, err = h.sealer.Seal(userID, []byte(req.Title)); err != nil {
+			log.Printf("Error sealing chat title: %v", err)
+			respondWithError(w, "Failed to create chat session", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+	err = h.db.DB.QueryRowContext(ctx, `
+		INSERT INTO chat_sessions (id, user_id, title_sealed)
+		VALUES ($1, $2, $3)
+		RETURNING created_at, updated_at
+	`, session.ID, userID, titleSealed).Scan(&session.CreatedAt, &session.UpdatedAt)
+	if err != nil {
+		log.Printf("Error creating chat session: %v", err)
+		respondWithError(w, "Failed to create chat session", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, session, http.StatusCreated)
+}
+
+// HandleDeleteChatSession handles DELETE /api/chat/sessions/{id} - delete a session and its messages
+func (h *ChatHandlers) HandleDeleteChatSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := h.db.DB.ExecContext(r.Context(), `DELETE FROM chat_sessions WHERE id = $1 AND user_id = $2`,
+		r.PathValue("id"), userID)
+	if err != nil {
+		log.Printf("Error deleting chat session: %v", err)
+		respondWithError(w, "Failed to delete chat session", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondWithError(w, "Chat session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListChatMessages handles GET /api/chat/sessions/{id}/messages - the session's history, oldest
+// first (paginated)
+func (h *ChatHandlers) HandleListChatMessages(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.sealer == nil {
+		respondWithError(w, "Chat sessions are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	sessionID := r.PathValue("id")
+	if err := h.checkSession(ctx, userID, sessionID); err != nil {
+		if errors.Is(err, errChatSessionNotFound) {
+			respondWithError(w, "Chat session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching chat session: %v", err)
+		respondWithError(w, "Failed to list chat messages", http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := h.listMessages(ctx, userID, sessionID, params)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing chat messages: %v", err)
+		respondWithError(w, "Failed to list chat messages", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(messages, params.Limit, func(m models.ChatMessage) pagination.Cursor {
+		return pagination.Cursor{SortValue: m.CreatedAt, ID: m.ID}
+	}), http.StatusOK)
+}
+
+// HandleSendChatMessage handles POST /api/chat/sessions/{id}/messages - ask the AI a question in the
+// context of the session's earlier turns. The question and the reply are both stored.
+func (h *ChatHandlers) HandleSendChatMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.sealer == nil {
+		respondWithError(w, "Chat sessions are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Get the user's API key from the header, or their stored key; trial sessions may use the server's
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" && !isTrialRequest(r) {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ChatSessionMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding chat message request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Prompt == "" {
+		respondWithError(w, "Prompt is required", http.StatusBadRequest)
+		return
+	}
+	if req.Provider != "gemini" && req.Provider != "" {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	sessionID := r.PathValue("id")
+	history, err := h.recentMessages(ctx, userID, sessionID)
+	if err != nil {
+		if errors.Is(err, errChatSessionNotFound) {
+			respondWithError(w, "Chat session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error loading chat history: %v", err)
+		respondWithError(w, "Failed to get chat response", http.StatusInternalServerError)
+		return
+	}
+
+	contextNotes, err := withoutAIExcluded(r, h.db, req.ContextNotes)
+	if err != nil {
+		log.Printf("Error filtering AI-excluded notes: %v", err)
+		respondWithError(w, "Failed to get chat response", http.StatusInternalServerError)
+		return
+	}
+
+	geminiService, release, err := geminiFor(r, h.db, userApiKey, h.gemini)
+	if err != nil {
+		log.Printf("Error initializing Gemini service: %v", err)
+		respondWithGeminiInitError(w, err)
+		return
+	}
+	defer release()
+
+	response, err := geminiService.GetChatResponse(ctx, req.Prompt, contextNotes, history)
+	if err != nil {
+		log.Printf("Error getting chat response: %v", err)
+		respondWithAIError(w, err, "Failed to get chat response")
+		return
+	}
+
+	turn, err := h.storeTurn(ctx, userID, sessionID, req.Prompt, response)
+	if err != nil {
+		log.Printf("Error storing chat turn: %v", err)
+		respondWithError(w, "Failed to save chat messages", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, turn, http.StatusOK)
+}
+
+// Helper functions
+
+// checkSession returns errChatSessionNotFound unless the session exists and belongs to the user
+func (h *ChatHandlers) checkSession(ctx context.Context, userID, sessionID string) error {
+	var exists bool
+	err := h.db.DB.QueryRowContext(ctx, `SELECT TRUE FROM chat_sessions WHERE id = $1 AND user_id = $2`,
+		sessionID, userID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errChatSessionNotFound
+	}
+	return err
+}
+
+// listSessions fetches one page (plus one extra row to detect more) of the user's sessions
+func (h *ChatHandlers) listSessions(ctx context.Context, userID string, params pagination.Params) ([]models.ChatSession, error) {
+	var cursorTime *time.Time
+	var cursorID string
+	if params.Cursor != nil {
+		cursorTime, cursorID = &params.Cursor.SortValue, params.Cursor.ID
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT id, title_sealed, created_at, updated_at
+		FROM chat_sessions
+		WHERE user_id = $1
+		  AND ($3::timestamptz IS NULL OR (updated_at, id) < ($3, $4))
+		ORDER BY updated_at DESC, id DESC
+		LIMIT $2
+	`, userID, params.Limit+1, cursorTime, cursorID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	sessions := []models.ChatSession{}
+	for rows.Next() {
+		var session models.ChatSession
+		var titleSealed []byte
+		if err := rows.Scan(&session.ID, &titleSealed, &session.CreatedAt, &session.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if titleSealed != nil {
+			title, err := h.sealer.Open(userID, titleSealed)
+			if err != nil {
+				return nil, err
+			}
+			session.Title = string(title)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// listMessages fetches one page (plus one extra row to detect more) of a session's messages
+func (h *ChatHandlers) listMessages(ctx context.Context, userID, sessionID string, params pagination.Params) ([]models.ChatMessage, error) {
+	var cursorTime *time.Time
+	var cursorID int64
+	if params.Cursor != nil {
+		id, err := strconv.ParseInt(params.Cursor.ID, 10, 64)
+		if err != nil {
+			return nil, pagination.ErrInvalidCursor
+		}
+		cursorTime, cursorID = &params.Cursor.SortValue, id
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT id::text, role, content_sealed, created_at
+		FROM chat_messages
+		WHERE session_id = $1
+		  AND ($3::timestamptz IS NULL OR (created_at, id) > ($3, $4::bigint))
+		ORDER BY created_at, id
+		LIMIT $2
+	`, sessionID, params.Limit+1, cursorTime, cursorID)
+	if err != nil {
+		return nil, err
+	}
+	return h.scanMessages(userID, rows)
+}
+
+// recentMessages returns the session's latest messages, oldest first, or errChatSessionNotFound
+func (h *ChatHandlers) recentMessages(ctx context.Context, userID, sessionID string) ([]models.ChatMessage, error) {
+	if err := h.checkSession(ctx, userID, sessionID); err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT id, role, content_sealed, created_at FROM (
+			SELECT id::text, role, content_sealed, created_at, id AS seq
+			FROM chat_messages
+			WHERE session_id = $1
+			ORDER BY created_at DESC, seq DESC
+			LIMIT $2
+		) recent
+		ORDER BY created_at, seq
+	`, sessionID, chatHistoryLoadLimit)
+	if err != nil {
+		return nil, err
+	}
+	return h.scanMessages(userID, rows)
+}
+
+// scanMessages reads and unseals message rows, closing them
+func (h *ChatHandlers) scanMessages(userID string, rows *sql.Rows) ([]models.ChatMessage, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	messages := []models.ChatMessage{}
+	for rows.Next() {
+		var message models.ChatMessage
+		var contentSealed []byte
+		if err := rows.Scan(&message.ID, &message.Role, &contentSealed, &message.CreatedAt); err != nil {
+			return nil, err
+		}
+		content, err := h.sealer.Open(userID, contentSealed)
+		if err != nil {
+			return nil, err
+		}
+		message.Content = string(content)
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// storeTurn saves a question and its reply and marks the session active
+func (h *ChatHandlers) storeTurn(ctx context.Context, userID, sessionID, prompt, response string) (turn *models.ChatTurnResponse, err error) {
+	turn = &models.ChatTurnResponse{
+		Message: models.ChatMessage{Role: models.ChatRoleUser, Content: prompt},
+		Reply:   models.ChatMessage{Role: models.ChatRoleAssistant, Content: response},
+	}
+
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back chat turn: %v", rbErr)
+			}
+		}
+	}()
+
+	for _, message := range []*models.ChatMessage{&turn.Message, &turn.Reply} {
+		var sealed []byte
+		if sealed, err = h.sealer.Seal(userID, []byte(message.Content)); err != nil {
+			return nil, err
+		}
+		// clock_timestamp() so the reply sorts after the question within one transaction
+		if err = tx.QueryRowContext(ctx, `
+			INSERT INTO chat_messages (session_id, role, content_sealed, created_at)
+			VALUES ($1, $2, $3, clock_timestamp())
+			RETURNING id::text, created_at
+		`, sessionID, message.Role, sealed).Scan(&message.ID, &message.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE chat_sessions SET updated_at = $3 WHERE id = $1 AND user_id = $2`,
+		sessionID, userID, turn.Reply.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return turn, nil
+}
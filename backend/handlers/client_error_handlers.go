@@ -0,0 +1,260 @@
+// HTTP handlers for client (extension/web app) error reports
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Client error report limits
+const (
+	maxClientErrorBodySize    = 64 << 10
+	maxClientErrorMessage     = 1000
+	maxClientErrorStack       = 16 << 10
+	maxClientErrorField       = 50 // App version and platform
+	maxClientErrorURL         = 2048
+	maxClientErrorBreadcrumbs = 50
+	maxBreadcrumbMessage      = 500
+	maxReportRequestIDs       = 20
+	maxStoredRequestIDs       = 100 // Per stored error, across repeats
+)
+
+// clientErrorRateGroup is the RATE_LIMITS group applied per user
+const clientErrorRateGroup = "client_errors"
+
+// defaultClientErrorRateLimit applies when RATE_LIMITS has no client_errors entry
+var defaultClientErrorRateLimit = services.RateLimit{Requests: 30, Window: time.Minute}
+
+// ClientErrorHandlers handles client error report HTTP endpoints
+type ClientErrorHandlers struct {
+	db      *services.Database
+	limiter *services.RateLimiter
+}
+
+// NewClientErrorHandlers creates a new ClientErrorHandlers instance
+func NewClientErrorHandlers(db *services.Database) *ClientErrorHandlers {
+	return &ClientErrorHandlers{
+		db:      db,
+		limiter: services.NewRateLimiter(clientErrorRateGroup, defaultClientErrorRateLimit),
+	}
+}
+
+// HandleReportError handles POST /api/client-errors - store a structured error report. Repeats of an
+// error the user already reported are counted; new errors are kept at CLIENT_ERROR_SAMPLE_RATE.
+func (h *ClientErrorHandlers) HandleReportError(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// A crash loop shouldn't turn into a write storm
+	if retryAfter, ok := h.limiter.Allow(userID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many error reports",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return
+	}
+
+	var report models.ClientErrorReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxClientErrorBodySize)).Decode(&report); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(report.Message) == "" {
+		respondWithError(w, "Message is required", http.StatusBadRequest)
+		return
+	}
+	sanitizeClientErrorReport(&report)
+
+	ctx := r.Context()
+	accepted, err := h.recordClientError(ctx, userID, &report)
+	if err != nil {
+		log.Printf("Error recording client error: %v", err)
+		respondWithError(w, "Failed to record error report", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.ClientErrorReportResponse{Accepted: accepted}, http.StatusAccepted)
+}
+
+// HandleListClientErrors handles GET /api/admin/client-errors?requestId=&limit=&cursor= - stored error
+// reports, oldest first, optionally only those mentioning a backend request ID
+func (h *ClientErrorHandlers) HandleListClientErrors(w http.ResponseWriter, r *http.Request) {
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientErrors, err := h.listClientErrors(r.Context(), r.URL.Query().Get("requestId"), params)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing client errors: %v", err)
+		respondWithError(w, "Failed to list client errors", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(clientErrors, params.Limit, func(e models.ClientError) pagination.Cursor {
+		return pagination.Cursor{SortValue: e.FirstSeenAt, ID: e.ID}
+	}), http.StatusOK)
+}
+
+// Helper functions
+
+// sanitizeClientErrorReport truncates oversized fields, drops malformed request IDs, and redacts
+// secrets that stacks and URLs tend to carry (tokens in query strings, API keys)
+func sanitizeClientErrorReport(report *models.ClientErrorReport) {
+	report.Message = services.Redact(truncateRunes(strings.TrimSpace(report.Message), maxClientErrorMessage))
+	report.Stack = services.Redact(truncateRunes(report.Stack, maxClientErrorStack))
+	report.AppVersion = truncateRunes(report.AppVersion, maxClientErrorField)
+	report.Platform = truncateRunes(report.Platform, maxClientErrorField)
+	report.URL = services.Redact(truncateRunes(report.URL, maxClientErrorURL))
+
+	// Keep the breadcrumbs closest to the error
+	if len(report.Breadcrumbs) > maxClientErrorBreadcrumbs {
+		report.Breadcrumbs = report.Breadcrumbs[len(report.Breadcrumbs)-maxClientErrorBreadcrumbs:]
+	}
+	for i := range report.Breadcrumbs {
+		report.Breadcrumbs[i].Category = truncateRunes(report.Breadcrumbs[i].Category, maxClientErrorField)
+		report.Breadcrumbs[i].Message = services.Redact(truncateRunes(report.Breadcrumbs[i].Message, maxBreadcrumbMessage))
+	}
+	if report.Breadcrumbs == nil {
+		report.Breadcrumbs = []models.Breadcrumb{}
+	}
+
+	requestIDs := make([]string, 0, len(report.RequestIDs))
+	for _, id := range report.RequestIDs {
+		if requestIDPattern.MatchString(id) && len(requestIDs) < maxReportRequestIDs {
+			requestIDs = append(requestIDs, id)
+		}
+	}
+	report.RequestIDs = requestIDs
+}
+
+// truncateRunes shortens s to at most n runes
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// recordClientError folds a report into the matching stored error, or stores it as a new error if
+// it's sampled in. Returns whether the report was kept.
+func (h *ClientErrorHandlers) recordClientError(ctx context.Context, userID string, report *models.ClientErrorReport) (bool, error) {
+	sum := sha256.Sum256([]byte(report.Message + "\n" + report.Stack))
+	fingerprint := hex.EncodeToString(sum[:])
+
+	breadcrumbs, err := json.Marshal(report.Breadcrumbs)
+	if err != nil {
+		return false, err
+	}
+
+	// Repeats update the latest context and accumulate request IDs (keeping the newest)
+	const update = `
+		occurrences = client_errors.occurrences + 1,
+		last_seen_at = CURRENT_TIMESTAMP,
+		app_version = $3,
+		platform = $4,
+		url = $5,
+		breadcrumbs = $6,
+		request_ids = (client_errors.request_ids || $7::text[])[
+			GREATEST(1, cardinality(client_errors.request_ids) + cardinality($7::text[]) - $8 + 1):]
+	`
+	args := []interface{}{userID, fingerprint, report.AppVersion, report.Platform, report.URL, breadcrumbs, report.RequestIDs, maxStoredRequestIDs}
+	result, err := h.db.DB.ExecContext(ctx, `
+		UPDATE client_errors SET `+update+`
+		WHERE user_id = $1 AND fingerprint = $2
+	`, args...)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		return true, nil
+	}
+
+	if rand.Float64() >= services.Config.ClientErrorSampleRate() {
+		return false, nil
+	}
+
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+	_, err = h.db.DB.ExecContext(ctx, `
+		INSERT INTO client_errors (user_id, fingerprint, message, stack, app_version, platform, url, breadcrumbs, request_ids)
+		VALUES ($1, $2, $9, NULLIF($10, ''), $3, $4, $5, $6, $7::text[])
+		ON CONFLICT (user_id, fingerprint) DO UPDATE SET `+update,
+		append(args, report.Message, report.Stack)...)
+	return err == nil, err
+}
+
+// listClientErrors fetches one page (plus one extra row to detect more) of stored errors
+func (h *ClientErrorHandlers) listClientErrors(ctx context.Context, requestID string, params pagination.Params) ([]models.ClientError, error) {
+	query := `
+		SELECT id::text, user_id, fingerprint, message, COALESCE(stack, ''), COALESCE(app_version, ''),
+		       COALESCE(platform, ''), COALESCE(url, ''), breadcrumbs, to_json(request_ids), occurrences,
+		       first_seen_at, last_seen_at
+		FROM client_errors
+		WHERE ($1 = '' OR $1 = ANY(request_ids))
+		  AND ($3::timestamptz IS NULL OR (first_seen_at, id) > ($3, $4::bigint))
+		ORDER BY first_seen_at, id
+		LIMIT $2
+	`
+	var cursorTime *time.Time
+	var cursorID int64
+	if params.Cursor != nil {
+		id, err := strconv.ParseInt(params.Cursor.ID, 10, 64)
+		if err != nil {
+			return nil, pagination.ErrInvalidCursor
+		}
+		cursorTime, cursorID = &params.Cursor.SortValue, id
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, query, requestID, params.Limit+1, cursorTime, cursorID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	clientErrors := []models.ClientError{}
+	for rows.Next() {
+		var e models.ClientError
+		var breadcrumbs, requestIDs []byte
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Fingerprint, &e.Message, &e.Stack, &e.AppVersion, &e.Platform, &e.URL,
+			&breadcrumbs, &requestIDs, &e.Occurrences, &e.FirstSeenAt, &e.LastSeenAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(breadcrumbs, &e.Breadcrumbs); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(requestIDs, &e.RequestIDs); err != nil {
+			return nil, err
+		}
+		clientErrors = append(clientErrors, e)
+	}
+	return clientErrors, rows.Err()
+}
@@ -0,0 +1,146 @@
+// HTTP handler for clipping web pages into notes
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxClipURLLength bounds the URL of a page to clip
+const maxClipURLLength = 2048
+
+// HandleClip handles POST /api/clip - fetch a web page, extract its main content as markdown, and
+// optionally clean it up with AI. The page's domain and capture rule come back with it, so clients can
+// fill in the note's domain and apply the rule. Only cleanup needs an API key.
+func (h *AIHandlers) HandleClip(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ClipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding clip request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	pageURL, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || len(req.URL) > maxClipURLLength || (pageURL.Scheme != "http" && pageURL.Scheme != "https") || pageURL.Hostname() == "" {
+		respondWithError(w, "url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+	pageURL.Fragment = ""
+
+	if req.Provider != "gemini" && req.Provider != "" {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	// An explicit cleanup needs a key up front, before the page is fetched
+	userApiKey := aiAPIKey(r)
+	canCleanUp := userApiKey != "" || isTrialRequest(r)
+	if req.Cleanup != nil && *req.Cleanup && !canCleanUp {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	page, err := services.ClipPage(r.Context(), pageURL)
+	if err != nil {
+		log.Printf("Error clipping %s: %v", pageURL.Redacted(), err)
+		respondWithClipError(w, err)
+		return
+	}
+
+	finalURL, err := url.Parse(page.URL)
+	if err != nil {
+		finalURL = pageURL
+	}
+	resp := models.ClipResponse{
+		URL:      page.URL,
+		Title:    page.Title,
+		Markdown: page.Content,
+		Domain:   clipDomain(finalURL),
+	}
+
+	resp.Rule, err = matchCaptureRule(r.Context(), h.db, userID, finalURL.Hostname())
+	if err != nil {
+		log.Printf("Error matching capture rules: %v", err)
+	}
+
+	// Without an explicit choice the rule decides; a rule can't require cleanup from a caller without a key
+	cleanup := resp.Rule != nil && resp.Rule.CleanupOnCapture && canCleanUp
+	if req.Cleanup != nil {
+		cleanup = *req.Cleanup
+	}
+	if cleanup {
+		resp.Markdown, resp.Cleaned = h.cleanUpClip(r, userApiKey, page.Content)
+	}
+
+	respondWithJSON(w, resp, http.StatusOK)
+}
+
+// Helper functions
+
+// cleanUpClip runs AI cleanup on a clipped page's markdown. Cleanup is best-effort: on failure the
+// markdown comes back unchanged, with cleaned false.
+func (h *AIHandlers) cleanUpClip(r *http.Request, userApiKey, markdown string) (string, bool) {
+	geminiService, release, err := geminiFor(r, h.users, userApiKey, h.geminiService)
+	if err != nil {
+		log.Printf("Error initializing Gemini service: %v", err)
+		return markdown, false
+	}
+	defer release()
+
+	cleaned, err := geminiService.CleanUpNote(r.Context(), markdown)
+	if err != nil {
+		log.Printf("Error cleaning up clipped page: %v", err)
+		return markdown, false
+	}
+	if cleaned == "" {
+		return markdown, false
+	}
+	return cleaned, true
+}
+
+// clipDomain returns the domain recorded on a clipped note: the page's lowercase host name, without
+// a leading "www."
+func clipDomain(pageURL *url.URL) string {
+	host := strings.TrimSuffix(strings.ToLower(pageURL.Hostname()), ".")
+	return strings.TrimPrefix(host, "www.")
+}
+
+// respondWithClipError responds with the error that stopped a page from being clipped
+func respondWithClipError(w http.ResponseWriter, err error) {
+	var fetchErr *services.ClipFetchError
+	switch {
+	case errors.Is(err, services.ErrClipBlockedAddress):
+		respondWithError(w, "URL points to a private or reserved address", http.StatusBadRequest)
+	case errors.Is(err, services.ErrClipUnsupportedType):
+		respondWithError(w, "Page is not HTML or plain text", http.StatusUnprocessableEntity)
+	case errors.Is(err, services.ErrClipTooLarge):
+		respondWithError(w, "Page is too large to clip", http.StatusUnprocessableEntity)
+	case errors.Is(err, services.ErrClipNoContent):
+		respondWithError(w, "No readable content found on page", http.StatusUnprocessableEntity)
+	case errors.As(err, &fetchErr):
+		respondWithError(w, "Failed to fetch page: "+fetchErr.Error(), http.StatusBadGateway)
+	case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+		respondWithError(w, "Timed out fetching page", http.StatusGatewayTimeout)
+	default:
+		respondWithError(w, "Failed to fetch page", http.StatusBadGateway)
+	}
+}
+
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	var timeoutErr interface{ Timeout() bool }
+	return errors.As(err, &timeoutErr) && timeoutErr.Timeout()
+}
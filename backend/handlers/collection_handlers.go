@@ -0,0 +1,585 @@
+// HTTP handlers for collection management endpoints
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Collection appearance limits
+const (
+	maxCollectionDescriptionLength = 1000
+	maxCollectionCoverLength       = 2048
+)
+
+// collectionColorPattern accepts "#RRGGBB" colors
+var collectionColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// collectionNameIndex enforces unique names among a user's live collections (see 003_collection_soft_delete.sql)
+const collectionNameIndex = "idx_collections_user_id_name_active"
+
+// CollectionHandlers handles collection HTTP endpoints
+type CollectionHandlers struct {
+	db  *services.Database
+	hub *services.RealtimeHub
+}
+
+// NewCollectionHandlers creates a new CollectionHandlers instance
+func NewCollectionHandlers(db *services.Database, hub *services.RealtimeHub) *CollectionHandlers {
+	return &CollectionHandlers{db: db, hub: hub}
+}
+
+// HandleListCollections handles GET /api/collections?parentId=&recursive=&limit=&cursor= - list live
+// collections, oldest first. parentId lists a collection's children ("" for top-level collections), and
+// recursive=true includes all of its descendants.
+func (h *CollectionHandlers) HandleListCollections(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	var parentID *string
+	if query.Has("parentId") {
+		id := query.Get("parentId")
+		parentID = &id
+	}
+	recursive := false
+	if value := query.Get("recursive"); value != "" {
+		if recursive, err = strconv.ParseBool(value); err != nil {
+			respondWithError(w, "Invalid recursive flag", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	if parentID != nil && *parentID != "" {
+		if _, err := services.CollectionAncestors(ctx, h.db.DB, userID, *parentID); err != nil {
+			if errors.Is(err, services.ErrCollectionNotFound) {
+				respondWithError(w, "Collection not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error fetching collection %s: %v", *parentID, err)
+			respondWithError(w, "Failed to list collections", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	collections, err := h.listCollections(ctx, userID, parentID, recursive, params)
+	if err != nil {
+		log.Printf("Error listing collections: %v", err)
+		respondWithError(w, "Failed to list collections", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(collections, params.Limit, func(c models.SyncCollection) pagination.Cursor {
+		return pagination.Cursor{SortValue: c.CreatedAt, ID: c.ID}
+	}), http.StatusOK)
+}
+
+// HandleCreateCollection handles POST /api/collections - create a collection
+func (h *CollectionHandlers) HandleCreateCollection(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateCollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding create collection request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondWithError(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if err := validateCollectionAppearance(req.Color, req.Description, req.Cover); err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ParentID != nil && *req.ParentID == "" {
+		req.ParentID = nil
+	}
+	if req.ID == "" {
+		idBytes := make([]byte, 16)
+		if _, err := rand.Read(idBytes); err != nil {
+			log.Printf("Error generating collection ID: %v", err)
+			respondWithError(w, "Failed to create collection", http.StatusInternalServerError)
+			return
+		}
+		req.ID = "col_" + hex.EncodeToString(idBytes)
+	}
+
+	ctx := r.Context()
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+	if req.ParentID != nil {
+		if !h.checkParent(w, r, userID, req.ID, *req.ParentID) {
+			return
+		}
+	}
+
+	collection, err := services.ScanCollection(h.db.DB.QueryRowContext(ctx, `
+		INSERT INTO collections (id, user_id, name, icon, color, description, sort_index, cover, parent_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), COALESCE($7, 0), NULLIF($8, ''), $9, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+services.CollectionColumns,
+		req.ID, userID, req.Name, req.Icon, req.Color, req.Description, req.SortIndex, req.Cover, req.ParentID,
+	))
+	if constraint, ok := uniqueViolation(err); ok {
+		message := "Collection already exists"
+		if constraint == collectionNameIndex {
+			message = "A collection with this name already exists"
+		}
+		respondWithError(w, message, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating collection: %v", err)
+		respondWithError(w, "Failed to create collection", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.PublishChanges(userID, nil, []string{collection.ID})
+	respondWithJSON(w, collection, http.StatusCreated)
+}
+
+// HandleUpdateCollection handles PATCH /api/collections/{id} - update a collection's name, appearance, or parent
+func (h *CollectionHandlers) HandleUpdateCollection(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateCollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding update collection request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			respondWithError(w, "Name can't be empty", http.StatusBadRequest)
+			return
+		}
+		req.Name = &name
+	}
+	if err := validateCollectionAppearance(req.Color, req.Description, req.Cover); err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ParentID != nil && *req.ParentID != "" {
+		if !h.checkParent(w, r, userID, r.PathValue("id"), *req.ParentID) {
+			return
+		}
+	}
+
+	collection, err := services.ScanCollection(h.db.DB.QueryRowContext(r.Context(), `
+		UPDATE collections SET
+			name = COALESCE($3, name),
+			icon = COALESCE($4, icon),
+			color = CASE WHEN $5::text IS NULL THEN color ELSE NULLIF($5, '') END,
+			description = CASE WHEN $6::text IS NULL THEN description ELSE NULLIF($6, '') END,
+			sort_index = COALESCE($7, sort_index),
+			cover = CASE WHEN $8::text IS NULL THEN cover ELSE NULLIF($8, '') END,
+			parent_id = CASE WHEN $9::text IS NULL THEN parent_id ELSE NULLIF($9, '') END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		RETURNING `+services.CollectionColumns,
+		r.PathValue("id"), userID, req.Name, req.Icon, req.Color, req.Description, req.SortIndex, req.Cover, req.ParentID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if _, ok := uniqueViolation(err); ok {
+		respondWithError(w, "A collection with this name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating collection: %v", err)
+		respondWithError(w, "Failed to update collection", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.PublishChanges(userID, nil, []string{collection.ID})
+	respondWithJSON(w, collection, http.StatusOK)
+}
+
+// HandleGetCollection handles GET /api/collections/{id} - fetch a live collection
+func (h *CollectionHandlers) HandleGetCollection(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collection, err := services.ScanCollection(h.db.DB.QueryRowContext(r.Context(), `
+		SELECT `+services.CollectionColumns+` FROM collections WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+	`, r.PathValue("id"), userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching collection: %v", err)
+		respondWithError(w, "Failed to fetch collection", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, collection, http.StatusOK)
+}
+
+// HandleDeleteCollection handles DELETE /api/collections/{id} - soft-delete a collection,
+// applying the requested cascade policy to its member notes in a single transaction
+func (h *CollectionHandlers) HandleDeleteCollection(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	collectionID := r.PathValue("id")
+
+	// Body is optional; an empty body means the default orphan policy
+	var req models.DeleteCollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding delete collection request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Policy == "" {
+		req.Policy = models.CascadeOrphan
+	}
+
+	switch req.Policy {
+	case models.CascadeOrphan, models.CascadeDelete:
+	case models.CascadeMove:
+		if req.TargetCollectionID == "" {
+			respondWithError(w, "Target collection ID is required for the move policy", http.StatusBadRequest)
+			return
+		}
+		if req.TargetCollectionID == collectionID {
+			respondWithError(w, "Target collection must differ from the deleted collection", http.StatusBadRequest)
+			return
+		}
+	default:
+		respondWithError(w, "Invalid policy (expected orphan, move, or delete)", http.StatusBadRequest)
+		return
+	}
+
+	// Cascade deletes are destructive: keep concurrent pushes away from the member notes
+	if req.Policy == models.CascadeDelete {
+		noteIDs, err := collectionNoteIDs(r.Context(), h.db.DB, collectionID)
+		if err != nil {
+			log.Printf("Error fetching notes of collection %s: %v", collectionID, err)
+			respondWithError(w, "Failed to delete collection", http.StatusInternalServerError)
+			return
+		}
+		lock := lockNotes(w, r, h.db, userID, noteIDs, models.LockDelete)
+		if lock == nil {
+			return
+		}
+		defer lock.Release()
+	}
+
+	results, reparented, err := h.deleteCollection(r.Context(), userID, collectionID, r.Header.Get("X-Device-ID"), &req)
+	if errors.Is(err, services.ErrCollectionNotFound) {
+		respondWithError(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if _, ok := uniqueViolation(err); ok {
+		respondWithError(w, "A subcollection has the same name as a collection it would move next to; rename it first", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting collection %s: %v", collectionID, err)
+		respondWithError(w, "Failed to delete collection", http.StatusInternalServerError)
+		return
+	}
+
+	noteIDs := make([]string, 0, len(results))
+	for _, result := range results {
+		noteIDs = append(noteIDs, result.NoteID)
+	}
+	h.hub.PublishChanges(userID, noteIDs, append([]string{collectionID}, reparented...))
+
+	respondWithJSON(w, models.DeleteCollectionResponse{
+		CollectionID: collectionID,
+		Policy:       req.Policy,
+		Notes:        results,
+		Reparented:   reparented,
+	}, http.StatusOK)
+}
+
+// Helper functions
+
+func (h *CollectionHandlers) deleteCollection(ctx context.Context, userID, collectionID, deviceID string, req *models.DeleteCollectionRequest) (results []models.NoteCascadeResult, reparented []string, err error) {
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back collection delete: %v", rbErr)
+			}
+		}
+	}()
+
+	// Lock the collection (and the move target) so concurrent pushes can't re-link notes mid-delete
+	if err = lockActiveCollection(ctx, tx, userID, collectionID); err != nil {
+		return nil, nil, err
+	}
+	if req.Policy == models.CascadeMove {
+		if err = lockActiveCollection(ctx, tx, userID, req.TargetCollectionID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	noteIDs, err := collectionNoteIDs(ctx, tx, collectionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status := "orphaned"
+	switch req.Policy {
+	case models.CascadeMove:
+		status = "moved"
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO note_collections (note_id, collection_id)
+			SELECT note_id, $2 FROM note_collections WHERE collection_id = $1
+			ON CONFLICT DO NOTHING
+		`, collectionID, req.TargetCollectionID)
+	case models.CascadeDelete:
+		status = "deleted"
+		_, err = tx.ExecContext(ctx, `
+			WITH deleted AS (
+				UPDATE notes SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+				WHERE user_id = $1 AND id = ANY($2) AND deleted_at IS NULL
+				RETURNING id
+			)
+			INSERT INTO note_events (note_id, user_id, type, device_id)
+			SELECT id, $1, $3, NULLIF($4, '') FROM deleted
+		`, userID, noteIDs, models.NoteEventDeleted, deviceID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM note_collections WHERE collection_id = $1`, collectionID); err != nil {
+		return nil, nil, err
+	}
+
+	// Bump surviving notes so other devices pull their new collection membership
+	if req.Policy != models.CascadeDelete {
+		if _, err = tx.ExecContext(ctx, `UPDATE notes SET updated_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id = ANY($2)`, userID, noteIDs); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Subcollections move up to the deleted collection's parent
+	if reparented, err = reparentChildren(ctx, tx, collectionID); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE collections SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, collectionID); err != nil {
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	results = make([]models.NoteCascadeResult, 0, len(noteIDs))
+	for _, noteID := range noteIDs {
+		results = append(results, models.NoteCascadeResult{NoteID: noteID, Status: status})
+	}
+	return results, reparented, nil
+}
+
+// listCollections fetches one page (plus one extra row to detect more) of live collections, optionally only
+// the children (or, recursively, all descendants) of parentID ("" for top-level collections)
+func (h *CollectionHandlers) listCollections(ctx context.Context, userID string, parentID *string, recursive bool, params pagination.Params) ([]models.SyncCollection, error) {
+	conditions := "user_id = $1 AND deleted_at IS NULL"
+	args := []interface{}{userID, params.Limit + 1}
+	switch {
+	case parentID == nil || (*parentID == "" && recursive):
+	case *parentID == "":
+		conditions += " AND parent_id IS NULL"
+	case recursive:
+		args = append(args, *parentID)
+		conditions += fmt.Sprintf(` AND id IN (
+			WITH RECURSIVE descendants AS (
+				SELECT id FROM collections WHERE user_id = $1 AND parent_id = $%d
+				UNION
+				SELECT c.id FROM collections c JOIN descendants d ON c.parent_id = d.id
+			)
+			SELECT id FROM descendants
+		)`, len(args))
+	default:
+		args = append(args, *parentID)
+		conditions += fmt.Sprintf(" AND parent_id = $%d", len(args))
+	}
+	if params.Cursor != nil {
+		args = append(args, params.Cursor.SortValue, params.Cursor.ID)
+		conditions += fmt.Sprintf(" AND (created_at, id) > ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	query := `
+		SELECT ` + services.CollectionColumns + `
+		FROM collections
+		WHERE ` + conditions + `
+		ORDER BY created_at, id
+		LIMIT $2
+	`
+	rows, err := h.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var collections []models.SyncCollection
+	for rows.Next() {
+		coll, err := services.ScanCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, coll)
+	}
+	return collections, rows.Err()
+}
+
+// validateCollectionAppearance checks the optional appearance fields of a collection write ("" clears a field)
+func validateCollectionAppearance(color, description, cover *string) error {
+	if color != nil && *color != "" && !collectionColorPattern.MatchString(*color) {
+		return errors.New("color must be a #RRGGBB hex color")
+	}
+	if description != nil && utf8.RuneCountInString(*description) > maxCollectionDescriptionLength {
+		return fmt.Errorf("description exceeds %d characters", maxCollectionDescriptionLength)
+	}
+	if cover != nil && len(*cover) > maxCollectionCoverLength {
+		return fmt.Errorf("cover exceeds %d characters", maxCollectionCoverLength)
+	}
+	return nil
+}
+
+// uniqueViolation reports whether err is a unique constraint violation, and of which constraint
+func uniqueViolation(err error) (constraint string, ok bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return pgErr.ConstraintName, true
+	}
+	return "", false
+}
+
+// lockActiveCollection locks a live collection owned by the user, returning services.ErrCollectionNotFound otherwise
+func lockActiveCollection(ctx context.Context, tx *sql.Tx, userID, collectionID string) error {
+	var id string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM collections WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE
+	`, collectionID, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return services.ErrCollectionNotFound
+	}
+	return err
+}
+
+// reparentChildren moves a collection's live children up to its parent and returns their IDs
+func reparentChildren(ctx context.Context, q queryer, collectionID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		UPDATE collections SET
+			parent_id = (SELECT parent_id FROM collections WHERE id = $1),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE parent_id = $1 AND deleted_at IS NULL
+		RETURNING id
+	`, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+// checkParent validates a requested parent collection, responding with the error if it's invalid
+func (h *CollectionHandlers) checkParent(w http.ResponseWriter, r *http.Request, userID, collectionID, parentID string) bool {
+	err := services.ValidateCollectionParent(r.Context(), h.db.DB, userID, collectionID, parentID)
+	switch {
+	case errors.Is(err, services.ErrCollectionNotFound):
+		respondWithError(w, "Unknown parent collection", http.StatusBadRequest)
+		return false
+	case errors.Is(err, services.ErrCollectionCycle):
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return false
+	case err != nil:
+		log.Printf("Error checking parent collection %s: %v", parentID, err)
+		respondWithError(w, "Failed to save collection", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// collectionNoteIDs returns the IDs of notes linked to a collection
+func collectionNoteIDs(ctx context.Context, q queryer, collectionID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT note_id FROM note_collections WHERE collection_id = $1 ORDER BY note_id`, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+// scanIDs reads single-column ID rows and closes them
+func scanIDs(rows *sql.Rows) ([]string, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,282 @@
+// HTTP handlers for AI overviews of collections
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Collection overview limits
+const (
+	// maxOverviewSources caps the notes an overview is generated from, the most representative first
+	maxOverviewSources = 150
+	// overviewStaleFraction is the share of a collection's notes that must have been added or removed
+	// since its overview was generated for it to be regenerated
+	overviewStaleFraction = 0.2
+)
+
+// collectionOverviewRateGroup is the RATE_LIMITS group applied per user to generating overviews
+const collectionOverviewRateGroup = "collection_overview"
+
+// defaultCollectionOverviewRateLimit applies when RATE_LIMITS has no collection_overview entry
+var defaultCollectionOverviewRateLimit = services.RateLimit{Requests: 20, Window: time.Hour}
+
+// CollectionOverviewHandlers handles collection overview HTTP endpoints
+type CollectionOverviewHandlers struct {
+	db         *services.Database
+	embeddings *services.EmbeddingIndexer
+	limiter    *services.RateLimiter
+}
+
+// NewCollectionOverviewHandlers creates a new CollectionOverviewHandlers instance
+func NewCollectionOverviewHandlers(db *services.Database, embeddings *services.EmbeddingIndexer) *CollectionOverviewHandlers {
+	return &CollectionOverviewHandlers{
+		db:         db,
+		embeddings: embeddings,
+		limiter:    services.NewRateLimiter(collectionOverviewRateGroup, defaultCollectionOverviewRateLimit),
+	}
+}
+
+// HandleCollectionOverview handles GET /api/collections/{id}/overview?refresh= - an AI overview of what a
+// collection contains (description, themes, notable notes, gaps) from its notes' titles, tags, and
+// embeddings. The overview is cached, and only regenerated once the collection is renamed, notes amounting
+// to a fifth of it have been added or removed, or refresh=true is given. Generating uses the X-API-Key
+// header if given, otherwise the user's stored Gemini key.
+func (h *CollectionOverviewHandlers) HandleCollectionOverview(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	refresh := false
+	if value := r.URL.Query().Get("refresh"); value != "" {
+		if refresh, err = strconv.ParseBool(value); err != nil {
+			respondWithError(w, "Invalid refresh parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	collectionID := r.PathValue("id")
+
+	var name string
+	err = h.db.DB.QueryRowContext(ctx, `
+		SELECT name FROM collections WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+	`, collectionID, userID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching collection %s: %v", collectionID, err)
+		respondWithError(w, "Failed to fetch collection overview", http.StatusInternalServerError)
+		return
+	}
+
+	sources, err := h.overviewSources(ctx, userID, collectionID)
+	if err != nil {
+		log.Printf("Error fetching notes of collection %s: %v", collectionID, err)
+		respondWithError(w, "Failed to fetch collection overview", http.StatusInternalServerError)
+		return
+	}
+	noteIDs := make([]string, 0, len(sources))
+	for _, source := range sources {
+		noteIDs = append(noteIDs, source.ID)
+	}
+
+	if !refresh {
+		cached, cachedName, cachedNoteIDs, err := h.cachedOverview(ctx, userID, collectionID)
+		if err != nil {
+			log.Printf("Error fetching cached overview of collection %s: %v", collectionID, err)
+		}
+		if cached != nil && !overviewStale(cachedName, cachedNoteIDs, name, noteIDs) {
+			respondWithJSON(w, cached, http.StatusOK)
+			return
+		}
+	}
+
+	if len(sources) == 0 {
+		respondWithError(w, "The collection has no notes to summarize", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if retryAfter, ok := h.limiter.Allow(userID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many collection overviews",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return
+	}
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		apiKey, err = h.embeddings.APIKey(ctx, userID)
+		if errors.Is(err, services.ErrNoStoredProviderKey) {
+			respondWithError(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading stored provider key: %v", err)
+			respondWithError(w, "Failed to generate collection overview", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	geminiService, err := services.NewUserGeminiService(ctx, h.db, userID, apiKey)
+	if err != nil {
+		log.Printf("Error initializing Gemini service: %v", err)
+		respondWithGeminiInitError(w, err)
+		return
+	}
+	defer geminiService.Close()
+
+	prompted := sources
+	if len(prompted) > maxOverviewSources {
+		prompted = prompted[:maxOverviewSources]
+	}
+	overview, err := geminiService.CollectionOverview(ctx, name, prompted)
+	if err != nil {
+		log.Printf("Error generating overview of collection %s: %v", collectionID, err)
+		respondWithAIError(w, err, "Failed to generate collection overview")
+		return
+	}
+	overview.CollectionID = collectionID
+	overview.NoteCount = len(sources)
+	overview.GeneratedAt = time.Now()
+
+	// A failed save only costs a regeneration next time
+	if err := h.saveOverview(ctx, userID, name, noteIDs, overview); err != nil {
+		log.Printf("Error caching overview of collection %s: %v", collectionID, err)
+	}
+
+	respondWithJSON(w, overview, http.StatusOK)
+}
+
+// Helper functions
+
+// overviewSources returns the live notes of a collection that aren't excluded from AI features, those
+// nearest the centroid of the collection's embeddings (the most representative) first, then notes without
+// an embedding, most recently updated first
+func (h *CollectionOverviewHandlers) overviewSources(ctx context.Context, userID, collectionID string) ([]models.CollectionOverviewSource, error) {
+	rows, err := h.db.DB.QueryContext(ctx, `
+		WITH members AS (
+			SELECT n.id, n.title, n.tags, n.updated_at, e.embedding
+			FROM notes n
+			JOIN note_collections nc ON nc.note_id = n.id AND nc.collection_id = $2
+			LEFT JOIN note_embeddings e ON e.note_id = n.id AND e.model = $3
+			WHERE n.user_id = $1 AND n.deleted_at IS NULL AND NOT n.ai_excluded
+		),
+		centroid AS (
+			SELECT AVG(embedding) AS embedding FROM members
+		)
+		SELECT m.id, m.title, to_json(m.tags)
+		FROM members m CROSS JOIN centroid c
+		ORDER BY m.embedding <=> c.embedding NULLS LAST, m.updated_at DESC, m.id
+	`, userID, collectionID, services.EmbeddingModel)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var sources []models.CollectionOverviewSource
+	for rows.Next() {
+		var source models.CollectionOverviewSource
+		var tags []byte
+		if err := rows.Scan(&source.ID, &source.Title, &tags); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(tags, &source.Tags); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
+
+// cachedOverview returns a collection's cached overview with the collection name and note IDs it was
+// generated from, or a nil overview if there's none
+func (h *CollectionOverviewHandlers) cachedOverview(ctx context.Context, userID, collectionID string) (*models.CollectionOverview, string, []string, error) {
+	var overviewJSON, noteIDsJSON []byte
+	var name string
+	err := h.db.DB.QueryRowContext(ctx, `
+		SELECT overview, collection_name, to_json(note_ids)
+		FROM collection_overviews
+		WHERE collection_id = $1 AND user_id = $2
+	`, collectionID, userID).Scan(&overviewJSON, &name, &noteIDsJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", nil, nil
+	}
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var overview models.CollectionOverview
+	if err := json.Unmarshal(overviewJSON, &overview); err != nil {
+		return nil, "", nil, err
+	}
+	var noteIDs []string
+	if err := json.Unmarshal(noteIDsJSON, &noteIDs); err != nil {
+		return nil, "", nil, err
+	}
+	return &overview, name, noteIDs, nil
+}
+
+// saveOverview caches a collection's overview, replacing any earlier one
+func (h *CollectionOverviewHandlers) saveOverview(ctx context.Context, userID, name string, noteIDs []string, overview *models.CollectionOverview) error {
+	overviewJSON, err := json.Marshal(overview)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.DB.ExecContext(ctx, `
+		INSERT INTO collection_overviews (collection_id, user_id, overview, collection_name, note_ids, model, generated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (collection_id) DO UPDATE SET
+			overview = EXCLUDED.overview,
+			collection_name = EXCLUDED.collection_name,
+			note_ids = EXCLUDED.note_ids,
+			model = EXCLUDED.model,
+			generated_at = EXCLUDED.generated_at
+	`, overview.CollectionID, userID, overviewJSON, name, noteIDs, services.DefaultGeminiModel, overview.GeneratedAt)
+	return err
+}
+
+// overviewStale reports whether a collection changed materially since its overview was generated from
+// the given name and notes: it was renamed, or the notes added and removed since amount to at least
+// overviewStaleFraction of the larger of the two note sets
+func overviewStale(generatedName string, generatedNoteIDs []string, name string, noteIDs []string) bool {
+	if generatedName != name {
+		return true
+	}
+
+	generated := make(map[string]bool, len(generatedNoteIDs))
+	for _, id := range generatedNoteIDs {
+		generated[id] = true
+	}
+	changed := 0
+	for _, id := range noteIDs {
+		if generated[id] {
+			delete(generated, id)
+		} else {
+			changed++ // Added
+		}
+	}
+	changed += len(generated) // Removed
+
+	size := max(len(generatedNoteIDs), len(noteIDs))
+	return size > 0 && float64(changed) >= overviewStaleFraction*float64(size)
+}
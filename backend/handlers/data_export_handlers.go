@@ -0,0 +1,118 @@
+// HTTP handlers for exporting all of a user's data
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"database/sql"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// dataExportPageSize is how many notes are read from the database at a time while streaming an export
+const dataExportPageSize = pagination.MaxLimit
+
+// dataExportNoteFields reads everything but collection IDs, which come from one membership query rather
+// than one per note
+var dataExportNoteFields = services.NoteFieldMask{"contentEncrypted": true, "contentIV": true, "sourceEncrypted": true}
+
+// DataExportHandlers handles full data export HTTP endpoints
+type DataExportHandlers struct {
+	db          *services.Database
+	notes       services.NoteRepository
+	collections services.CollectionRepository
+}
+
+// NewDataExportHandlers creates a new DataExportHandlers instance
+func NewDataExportHandlers(db *services.Database, notes services.NoteRepository, collections services.CollectionRepository) *DataExportHandlers {
+	return &DataExportHandlers{db: db, notes: notes, collections: collections}
+}
+
+// HandleDataExport handles GET /api/export - download all of the user's live notes and collections as
+// a ZIP archive: a folder per collection, a JSON file per note, and manifest.json. Note bodies are
+// end-to-end encrypted and stay that way; the manifest carries the encryption metadata needed to
+// decrypt them with the user's passphrase. The archive is streamed, so a failure partway through
+// leaves it truncated rather than returning an error status.
+func (h *DataExportHandlers) HandleDataExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	manifest := models.DataExportManifest{
+		Version:    models.DataExportVersion,
+		ExportedAt: time.Now().UTC(),
+		UserID:     userID,
+	}
+	metadata, err := scanEncryptionMetadata(h.db.DB.QueryRowContext(ctx, `
+		SELECT `+encryptionMetadataColumns+` FROM encryption_metadata WHERE user_id = $1
+	`, userID))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error fetching encryption metadata for export: %v", err)
+		respondWithError(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		manifest.Encryption = &metadata
+	}
+
+	collections, err := h.collections.SyncCollections(ctx, userID, nil)
+	if err != nil {
+		log.Printf("Error fetching collections for export: %v", err)
+		respondWithError(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+	_, memberships, err := h.notes.NoteDigestEntries(ctx, userID)
+	if err != nil {
+		log.Printf("Error fetching note collections for export: %v", err)
+		respondWithError(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": "jottin-export-" + manifest.ExportedAt.Format("2006-01-02") + ".zip",
+	}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+
+	archive, err := services.NewDataExportArchive(w, manifest, collections)
+	if err != nil {
+		log.Printf("Error writing data export: %v", err)
+		return
+	}
+
+	page := pagination.Params{Limit: dataExportPageSize}
+	for {
+		notes, err := h.notes.SyncNotes(ctx, userID, nil, true, dataExportNoteFields, &page)
+		if err != nil {
+			log.Printf("Error fetching notes for export: %v", err)
+			return
+		}
+		hasMore := len(notes) > page.Limit
+		if hasMore {
+			notes = notes[:page.Limit]
+		}
+		for _, note := range notes {
+			if err := archive.AddNote(note, memberships[note.ID]); err != nil {
+				log.Printf("Error writing data export: %v", err)
+				return
+			}
+		}
+		if !hasMore {
+			break
+		}
+		last := notes[len(notes)-1]
+		page.Cursor = &pagination.Cursor{SortValue: last.UpdatedAt, ID: last.ID}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Printf("Error writing data export: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Encoding nil slices in responses as empty JSON arrays
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
+// jsonMarshalerType is the interface of types that encode themselves, which are left alone
+var jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+
+// sliceHolders caches whether values of a type can contain a slice (reflect.Type -> bool)
+var sliceHolders sync.Map
+
+// withEmptySlices returns a copy of a response value in which nil slices are empty, so lists always
+// encode as [] rather than null and strict clients don't need to special-case them. Values without
+// slices are returned as they are; []byte (base64) and types with their own MarshalJSON are kept.
+func withEmptySlices(data interface{}) interface{} {
+	v := reflect.ValueOf(data)
+	if !v.IsValid() || !holdsSlices(v.Type()) {
+		return data
+	}
+	return emptySlices(v).Interface()
+}
+
+// holdsSlices reports whether values of t can contain a slice that withEmptySlices replaces
+func holdsSlices(t reflect.Type) bool {
+	if holds, ok := sliceHolders.Load(t); ok {
+		return holds.(bool)
+	}
+	holds := holdsSlicesOf(t, make(map[reflect.Type]bool))
+	sliceHolders.Store(t, holds)
+	return holds
+}
+
+// holdsSlicesOf is holdsSlices without the cache, skipping types already being checked (recursive types)
+func holdsSlicesOf(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] || selfMarshaling(t) {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Uint8
+	case reflect.Interface:
+		return true
+	case reflect.Array, reflect.Map, reflect.Pointer:
+		return holdsSlicesOf(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if field := t.Field(i); field.IsExported() && holdsSlicesOf(field.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selfMarshaling reports whether t (or a pointer to it) has its own MarshalJSON
+func selfMarshaling(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType)
+}
+
+// emptySlices copies v with its nil slices replaced by empty ones
+func emptySlices(v reflect.Value) reflect.Value {
+	t := v.Type()
+	if !holdsSlices(t) {
+		return v
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return reflect.MakeSlice(t, 0, 0)
+		}
+		if !holdsSlices(t.Elem()) {
+			return v
+		}
+		out := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(emptySlices(v.Index(i)))
+		}
+		return out
+
+	case reflect.Array:
+		out := reflect.New(t).Elem()
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(emptySlices(v.Index(i)))
+		}
+		return out
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(t, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			out.SetMapIndex(iter.Key(), emptySlices(iter.Value()))
+		}
+		return out
+
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(t.Elem())
+		out.Elem().Set(emptySlices(v.Elem()))
+		return out
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(t).Elem()
+		out.Set(emptySlices(v.Elem()))
+		return out
+
+	case reflect.Struct:
+		// Copying the whole struct first keeps unexported fields, which can't be set one by one
+		out := reflect.New(t).Elem()
+		out.Set(v)
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				out.Field(i).Set(emptySlices(v.Field(i)))
+			}
+		}
+		return out
+	}
+	return v
+}
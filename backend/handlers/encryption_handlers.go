@@ -0,0 +1,259 @@
+// HTTP handlers for E2E encryption metadata endpoints
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// KDF parameter floors so a buggy client can't store trivially brute-forceable metadata
+const (
+	minPBKDF2Iterations = 100000
+	minArgon2Iterations = 2
+	minArgon2MemoryKiB  = 19 * 1024
+	minSaltSize         = 16
+)
+
+// errEncryptionKeyDowngrade is returned when a write would roll back the key version
+var errEncryptionKeyDowngrade = errors.New("key version can't decrease")
+
+// EncryptionHandlers handles encryption metadata HTTP endpoints
+type EncryptionHandlers struct {
+	db *services.Database
+}
+
+// NewEncryptionHandlers creates a new EncryptionHandlers instance
+func NewEncryptionHandlers(db *services.Database) *EncryptionHandlers {
+	return &EncryptionHandlers{db: db}
+}
+
+// HandleGetEncryptionMetadata handles GET /api/encryption/metadata - fetch the user's key-derivation metadata
+func (h *EncryptionHandlers) HandleGetEncryptionMetadata(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	metadata, err := scanEncryptionMetadata(h.db.DB.QueryRowContext(ctx, `
+		SELECT `+encryptionMetadataColumns+` FROM encryption_metadata WHERE user_id = $1
+	`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Encryption is not set up", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching encryption metadata: %v", err)
+		respondWithError(w, "Failed to fetch encryption metadata", http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, metadata, http.StatusOK)
+}
+
+// HandlePutEncryptionMetadata handles PUT /api/encryption/metadata - store the user's key-derivation
+// metadata. Writes carry the version the client last saw; a stale version returns 409.
+func (h *EncryptionHandlers) HandlePutEncryptionMetadata(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var req models.PutEncryptionMetadataRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding encryption metadata request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	fields, err := decodeEncryptionMetadata(&req)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+
+	metadata, err := h.writeEncryptionMetadata(ctx, userID, &req, fields)
+	var conflict *encryptionConflictError
+	switch {
+	case errors.As(err, &conflict):
+		respondWithJSON(w, models.EncryptionMetadataConflictResponse{
+			Error:   "Encryption metadata was modified by another device",
+			Current: conflict.current,
+		}, http.StatusConflict)
+		return
+	case errors.Is(err, errEncryptionKeyDowngrade):
+		respondWithError(w, "Key version can't decrease", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Error writing encryption metadata: %v", err)
+		respondWithError(w, "Failed to save encryption metadata", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, metadata, http.StatusOK)
+}
+
+// Helper functions
+
+// encryptionMetadataColumns are the columns read by scanEncryptionMetadata
+const encryptionMetadataColumns = `key_version, kdf_params, salt, wrapped_key, wrapped_key_iv,
+	recovery_wrapped_key, recovery_wrapped_key_iv, version, updated_at`
+
+// encryptionConflictError carries the current server metadata when a write used a stale base version
+type encryptionConflictError struct {
+	current models.EncryptionMetadata
+}
+
+func (e *encryptionConflictError) Error() string {
+	return "encryption metadata version conflict"
+}
+
+// encryptionMetadataFields are the decoded binary fields of a write
+type encryptionMetadataFields struct {
+	kdfParams            []byte
+	salt                 []byte
+	wrappedKey           []byte
+	wrappedKeyIV         []byte
+	recoveryWrappedKey   []byte
+	recoveryWrappedKeyIV []byte
+}
+
+// decodeEncryptionMetadata validates a write and decodes its base64 fields
+func decodeEncryptionMetadata(req *models.PutEncryptionMetadataRequest) (*encryptionMetadataFields, error) {
+	if req.KeyVersion < 1 {
+		return nil, errors.New("keyVersion must be at least 1")
+	}
+
+	switch req.KDF.Algorithm {
+	case models.KDFPBKDF2SHA256:
+		if req.KDF.Iterations < minPBKDF2Iterations {
+			return nil, fmt.Errorf("pbkdf2-sha256 needs at least %d iterations", minPBKDF2Iterations)
+		}
+	case models.KDFArgon2id:
+		if req.KDF.Iterations < minArgon2Iterations || req.KDF.MemoryKiB < minArgon2MemoryKiB || req.KDF.Parallelism < 1 {
+			return nil, fmt.Errorf("argon2id needs at least %d iterations, %d KiB of memory, and parallelism 1", minArgon2Iterations, minArgon2MemoryKiB)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported KDF algorithm %q", req.KDF.Algorithm)
+	}
+
+	fields := &encryptionMetadataFields{}
+	var err error
+	if fields.kdfParams, err = json.Marshal(req.KDF); err != nil {
+		return nil, err
+	}
+	if fields.salt, err = base64.StdEncoding.DecodeString(req.Salt); err != nil || len(fields.salt) < minSaltSize {
+		return nil, fmt.Errorf("salt must be at least %d base64 encoded bytes", minSaltSize)
+	}
+	if fields.wrappedKey, err = base64.StdEncoding.DecodeString(req.WrappedKey); err != nil || len(fields.wrappedKey) == 0 {
+		return nil, errors.New("invalid wrapped key")
+	}
+	if fields.wrappedKeyIV, err = base64.StdEncoding.DecodeString(req.WrappedKeyIV); err != nil || len(fields.wrappedKeyIV) == 0 {
+		return nil, errors.New("invalid wrapped key IV")
+	}
+	if (req.RecoveryWrappedKey == "") != (req.RecoveryWrappedKeyIV == "") {
+		return nil, errors.New("recovery wrapped key and IV must be set together")
+	}
+	if req.RecoveryWrappedKey != "" {
+		if fields.recoveryWrappedKey, err = base64.StdEncoding.DecodeString(req.RecoveryWrappedKey); err != nil {
+			return nil, errors.New("invalid recovery wrapped key")
+		}
+		if fields.recoveryWrappedKeyIV, err = base64.StdEncoding.DecodeString(req.RecoveryWrappedKeyIV); err != nil {
+			return nil, errors.New("invalid recovery wrapped key IV")
+		}
+	}
+	return fields, nil
+}
+
+// writeEncryptionMetadata upserts the user's metadata if baseVersion matches the stored version
+func (h *EncryptionHandlers) writeEncryptionMetadata(ctx context.Context, userID string, req *models.PutEncryptionMetadataRequest, fields *encryptionMetadataFields) (metadata models.EncryptionMetadata, err error) {
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return metadata, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back encryption metadata write: %v", rbErr)
+			}
+		}
+	}()
+
+	current, err := scanEncryptionMetadata(tx.QueryRowContext(ctx, `
+		SELECT `+encryptionMetadataColumns+` FROM encryption_metadata WHERE user_id = $1 FOR UPDATE
+	`, userID))
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return metadata, err
+	}
+
+	// Creating requires that nothing exists yet; updating must be based on the current version
+	switch {
+	case exists && (req.BaseVersion == nil || *req.BaseVersion != current.Version):
+		return metadata, &encryptionConflictError{current: current}
+	case !exists && req.BaseVersion != nil:
+		return metadata, &encryptionConflictError{}
+	case exists && req.KeyVersion < current.KeyVersion:
+		return metadata, errEncryptionKeyDowngrade
+	}
+
+	metadata, err = scanEncryptionMetadata(tx.QueryRowContext(ctx, `
+		INSERT INTO encryption_metadata (user_id, key_version, kdf_algorithm, kdf_params, salt, wrapped_key, wrapped_key_iv,
+		                                 recovery_wrapped_key, recovery_wrapped_key_iv, version, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET
+			key_version = EXCLUDED.key_version,
+			kdf_algorithm = EXCLUDED.kdf_algorithm,
+			kdf_params = EXCLUDED.kdf_params,
+			salt = EXCLUDED.salt,
+			wrapped_key = EXCLUDED.wrapped_key,
+			wrapped_key_iv = EXCLUDED.wrapped_key_iv,
+			recovery_wrapped_key = EXCLUDED.recovery_wrapped_key,
+			recovery_wrapped_key_iv = EXCLUDED.recovery_wrapped_key_iv,
+			version = encryption_metadata.version + 1,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING `+encryptionMetadataColumns,
+		userID, req.KeyVersion, req.KDF.Algorithm, fields.kdfParams, fields.salt, fields.wrappedKey, fields.wrappedKeyIV,
+		fields.recoveryWrappedKey, fields.recoveryWrappedKeyIV,
+	))
+	if err != nil {
+		return metadata, err
+	}
+
+	err = tx.Commit()
+	return metadata, err
+}
+
+func scanEncryptionMetadata(row rowScanner) (models.EncryptionMetadata, error) {
+	var metadata models.EncryptionMetadata
+	var kdfParams, salt, wrappedKey, wrappedKeyIV, recoveryWrappedKey, recoveryWrappedKeyIV []byte
+	if err := row.Scan(&metadata.KeyVersion, &kdfParams, &salt, &wrappedKey, &wrappedKeyIV,
+		&recoveryWrappedKey, &recoveryWrappedKeyIV, &metadata.Version, &metadata.UpdatedAt); err != nil {
+		return metadata, err
+	}
+	if err := json.Unmarshal(kdfParams, &metadata.KDF); err != nil {
+		return metadata, fmt.Errorf("failed to decode KDF params: %w", err)
+	}
+	metadata.Salt = base64.StdEncoding.EncodeToString(salt)
+	metadata.WrappedKey = base64.StdEncoding.EncodeToString(wrappedKey)
+	metadata.WrappedKeyIV = base64.StdEncoding.EncodeToString(wrappedKeyIV)
+	if recoveryWrappedKey != nil {
+		metadata.RecoveryWrappedKey = base64.StdEncoding.EncodeToString(recoveryWrappedKey)
+		metadata.RecoveryWrappedKeyIV = base64.StdEncoding.EncodeToString(recoveryWrappedKeyIV)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,424 @@
+// HTTP handlers for opt-in recovery key escrow
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clerk/clerk-sdk-go/v2/user"
+)
+
+const (
+	// escrowRecoveryTTL is how long an emailed recovery code stays valid
+	escrowRecoveryTTL = 15 * time.Minute
+	// escrowRecoveryCooldown is the minimum time between recovery emails
+	escrowRecoveryCooldown = time.Minute
+	// maxEscrowRecoveryAttempts is how many wrong codes a recovery tolerates before it's burned
+	maxEscrowRecoveryAttempts = 5
+	// minEscrowSecretSize is the smallest escrow secret accepted (256 bits)
+	minEscrowSecretSize = 32
+)
+
+// Errors returned by the escrow recovery flow
+var (
+	errEscrowRecoveryInvalid = errors.New("invalid or expired recovery")
+	errEscrowRecoveryCode    = errors.New("incorrect recovery code")
+)
+
+// EscrowHandlers handles recovery key escrow HTTP endpoints
+type EscrowHandlers struct {
+	db     *services.Database
+	sealer *services.Sealer
+	mailer *services.Mailer
+}
+
+// NewEscrowHandlers creates a new EscrowHandlers instance. A nil sealer disables escrow.
+func NewEscrowHandlers(db *services.Database, sealer *services.Sealer, mailer *services.Mailer) *EscrowHandlers {
+	return &EscrowHandlers{db: db, sealer: sealer, mailer: mailer}
+}
+
+// HandleGetKeyEscrow handles GET /api/encryption/escrow - check whether recovery escrow is on
+func (h *EscrowHandlers) HandleGetKeyEscrow(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	status := models.KeyEscrowStatus{}
+	var updatedAt time.Time
+	err = h.db.DB.QueryRowContext(ctx, `SELECT key_version, updated_at FROM key_escrow WHERE user_id = $1`, userID).
+		Scan(&status.KeyVersion, &updatedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error fetching key escrow: %v", err)
+		respondWithError(w, "Failed to fetch key escrow", http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		status.Enabled = true
+		status.UpdatedAt = &updatedAt
+	}
+	respondWithJSON(w, status, http.StatusOK)
+}
+
+// HandlePutKeyEscrow handles PUT /api/encryption/escrow - opt into recovery escrow. This stores a second
+// wrapping of the master key whose secret the server can release after email verification, trading
+// strict end-to-end secrecy for recoverability.
+func (h *EscrowHandlers) HandlePutKeyEscrow(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.sealer == nil {
+		respondWithError(w, "Key escrow is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req models.PutKeyEscrowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding key escrow request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	wrappedKey, wrappedKeyIV, secret, err := decodeKeyEscrow(&req)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sealed, err := h.sealer.Seal(userID, secret)
+	if err != nil {
+		log.Printf("Error sealing escrow secret: %v", err)
+		respondWithError(w, "Failed to save key escrow", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+
+	var updatedAt time.Time
+	err = h.db.DB.QueryRowContext(ctx, `
+		INSERT INTO key_escrow (user_id, key_version, wrapped_key, wrapped_key_iv, sealed_secret, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET
+			key_version = EXCLUDED.key_version,
+			wrapped_key = EXCLUDED.wrapped_key,
+			wrapped_key_iv = EXCLUDED.wrapped_key_iv,
+			sealed_secret = EXCLUDED.sealed_secret,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at
+	`, userID, req.KeyVersion, wrappedKey, wrappedKeyIV, sealed).Scan(&updatedAt)
+	if err != nil {
+		log.Printf("Error storing key escrow: %v", err)
+		respondWithError(w, "Failed to save key escrow", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.KeyEscrowStatus{Enabled: true, KeyVersion: req.KeyVersion, UpdatedAt: &updatedAt}, http.StatusOK)
+}
+
+// HandleDeleteKeyEscrow handles DELETE /api/encryption/escrow - opt out of recovery escrow
+func (h *EscrowHandlers) HandleDeleteKeyEscrow(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := h.db.DB.ExecContext(ctx, `DELETE FROM key_escrow WHERE user_id = $1`, userID); err != nil {
+		log.Printf("Error deleting key escrow: %v", err)
+		respondWithError(w, "Failed to delete key escrow", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.db.DB.ExecContext(ctx, `DELETE FROM escrow_recovery_requests WHERE user_id = $1`, userID); err != nil {
+		log.Printf("Error deleting escrow recovery requests: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleStartRecovery handles POST /api/encryption/escrow/recovery - email a one-time code to the
+// account's primary address. Starting a new recovery invalidates any pending one.
+func (h *EscrowHandlers) HandleStartRecovery(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.sealer == nil || !h.mailer.Configured() {
+		respondWithError(w, "Key escrow is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.db.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM key_escrow WHERE user_id = $1)`, userID).Scan(&exists); err != nil {
+		log.Printf("Error checking key escrow: %v", err)
+		respondWithError(w, "Failed to start recovery", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		respondWithError(w, "Key escrow is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var recent bool
+	err = h.db.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM escrow_recovery_requests WHERE user_id = $1 AND created_at > $2)
+	`, userID, time.Now().Add(-escrowRecoveryCooldown)).Scan(&recent)
+	if err != nil {
+		log.Printf("Error checking escrow recovery cooldown: %v", err)
+		respondWithError(w, "Failed to start recovery", http.StatusInternalServerError)
+		return
+	}
+	if recent {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(escrowRecoveryCooldown.Seconds())))
+		respondWithError(w, "A recovery code was sent recently", http.StatusTooManyRequests)
+		return
+	}
+
+	email, err := primaryEmail(ctx, userID)
+	if err != nil {
+		log.Printf("Error fetching primary email: %v", err)
+		respondWithError(w, "No verified email address on the account", http.StatusUnprocessableEntity)
+		return
+	}
+
+	recoveryID, code, err := newEscrowRecoveryCode()
+	if err != nil {
+		log.Printf("Error generating recovery code: %v", err)
+		respondWithError(w, "Failed to start recovery", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(escrowRecoveryTTL)
+
+	if _, err := h.db.DB.ExecContext(ctx, `DELETE FROM escrow_recovery_requests WHERE user_id = $1`, userID); err != nil {
+		log.Printf("Error clearing escrow recovery requests: %v", err)
+		respondWithError(w, "Failed to start recovery", http.StatusInternalServerError)
+		return
+	}
+	_, err = h.db.DB.ExecContext(ctx, `
+		INSERT INTO escrow_recovery_requests (id, user_id, code_hash, expires_at) VALUES ($1, $2, $3, $4)
+	`, recoveryID, userID, hashRecoveryCode(recoveryID, code), expiresAt)
+	if err != nil {
+		log.Printf("Error storing escrow recovery request: %v", err)
+		respondWithError(w, "Failed to start recovery", http.StatusInternalServerError)
+		return
+	}
+
+	body := fmt.Sprintf("Your Jottin recovery code is %s\n\nIt expires in %d minutes. If you didn't request it, someone may be trying to access your notes; you can turn off key escrow in settings.\n",
+		code, int(escrowRecoveryTTL.Minutes()))
+	if err := h.mailer.Send(email, "Your Jottin recovery code", body); err != nil {
+		log.Printf("Error sending recovery email: %v", err)
+		respondWithError(w, "Failed to send recovery email", http.StatusBadGateway)
+		return
+	}
+
+	respondWithJSON(w, models.StartEscrowRecoveryResponse{
+		RecoveryID: recoveryID,
+		Email:      maskEmail(email),
+		ExpiresAt:  expiresAt,
+	}, http.StatusCreated)
+}
+
+// HandleVerifyRecovery handles POST /api/encryption/escrow/recovery/verify - exchange an emailed code
+// for the escrowed key and secret. Each recovery releases the key once.
+func (h *EscrowHandlers) HandleVerifyRecovery(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.sealer == nil {
+		respondWithError(w, "Key escrow is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req models.VerifyEscrowRecoveryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding recovery verification: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RecoveryID == "" || req.Code == "" {
+		respondWithError(w, "recoveryId and code are required", http.StatusBadRequest)
+		return
+	}
+
+	recovery, err := h.verifyRecovery(r.Context(), userID, &req)
+	switch {
+	case errors.Is(err, errEscrowRecoveryInvalid):
+		respondWithError(w, "Recovery is invalid or expired; start a new one", http.StatusGone)
+		return
+	case errors.Is(err, errEscrowRecoveryCode):
+		respondWithError(w, "Incorrect recovery code", http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.Printf("Error verifying escrow recovery: %v", err)
+		respondWithError(w, "Failed to verify recovery", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, recovery, http.StatusOK)
+}
+
+// Helper functions
+
+// decodeKeyEscrow validates an escrow write and decodes its base64 fields
+func decodeKeyEscrow(req *models.PutKeyEscrowRequest) (wrappedKey, wrappedKeyIV, secret []byte, err error) {
+	if req.KeyVersion < 1 {
+		return nil, nil, nil, errors.New("keyVersion must be at least 1")
+	}
+	if wrappedKey, err = base64.StdEncoding.DecodeString(req.WrappedKey); err != nil || len(wrappedKey) == 0 {
+		return nil, nil, nil, errors.New("invalid wrapped key")
+	}
+	if wrappedKeyIV, err = base64.StdEncoding.DecodeString(req.WrappedKeyIV); err != nil || len(wrappedKeyIV) == 0 {
+		return nil, nil, nil, errors.New("invalid wrapped key IV")
+	}
+	if secret, err = base64.StdEncoding.DecodeString(req.EscrowSecret); err != nil || len(secret) < minEscrowSecretSize {
+		return nil, nil, nil, fmt.Errorf("escrowSecret must be at least %d base64 encoded bytes", minEscrowSecretSize)
+	}
+	return wrappedKey, wrappedKeyIV, secret, nil
+}
+
+// verifyRecovery checks a recovery code, counting failed attempts, and on success consumes the
+// recovery and returns the escrowed key with its unsealed secret
+func (h *EscrowHandlers) verifyRecovery(ctx context.Context, userID string, req *models.VerifyEscrowRecoveryRequest) (recovery models.EscrowRecoveryResponse, err error) {
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return recovery, err
+	}
+	defer func() {
+		if err != nil && !errors.Is(err, errEscrowRecoveryCode) {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back escrow recovery: %v", rbErr)
+			}
+		}
+	}()
+
+	var codeHash []byte
+	var attempts int
+	var expiresAt time.Time
+	var consumedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT code_hash, attempts, expires_at, consumed_at FROM escrow_recovery_requests
+		WHERE id = $1 AND user_id = $2 FOR UPDATE
+	`, req.RecoveryID, userID).Scan(&codeHash, &attempts, &expiresAt, &consumedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return recovery, errEscrowRecoveryInvalid
+	}
+	if err != nil {
+		return recovery, err
+	}
+	if consumedAt.Valid || time.Now().After(expiresAt) || attempts >= maxEscrowRecoveryAttempts {
+		return recovery, errEscrowRecoveryInvalid
+	}
+
+	if subtle.ConstantTimeCompare(codeHash, hashRecoveryCode(req.RecoveryID, strings.TrimSpace(req.Code))) != 1 {
+		// Record the failed attempt even though the request fails
+		if _, err = tx.ExecContext(ctx, `UPDATE escrow_recovery_requests SET attempts = attempts + 1 WHERE id = $1`, req.RecoveryID); err != nil {
+			return recovery, err
+		}
+		if err = tx.Commit(); err != nil {
+			return recovery, err
+		}
+		return recovery, errEscrowRecoveryCode
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE escrow_recovery_requests SET consumed_at = CURRENT_TIMESTAMP WHERE id = $1`, req.RecoveryID); err != nil {
+		return recovery, err
+	}
+
+	var wrappedKey, wrappedKeyIV, sealed []byte
+	err = tx.QueryRowContext(ctx, `
+		SELECT key_version, wrapped_key, wrapped_key_iv, sealed_secret FROM key_escrow WHERE user_id = $1
+	`, userID).Scan(&recovery.KeyVersion, &wrappedKey, &wrappedKeyIV, &sealed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return recovery, errEscrowRecoveryInvalid
+	}
+	if err != nil {
+		return recovery, err
+	}
+	secret, err := h.sealer.Open(userID, sealed)
+	if err != nil {
+		return recovery, err
+	}
+
+	recovery.WrappedKey = base64.StdEncoding.EncodeToString(wrappedKey)
+	recovery.WrappedKeyIV = base64.StdEncoding.EncodeToString(wrappedKeyIV)
+	recovery.EscrowSecret = base64.StdEncoding.EncodeToString(secret)
+
+	err = tx.Commit()
+	return recovery, err
+}
+
+// newEscrowRecoveryCode generates a recovery ID and a 6-digit code
+func newEscrowRecoveryCode() (recoveryID, code string, err error) {
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", err
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", "", err
+	}
+	return "rec_" + hex.EncodeToString(idBytes), fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashRecoveryCode hashes a code bound to its recovery so codes are never stored in plaintext
+func hashRecoveryCode(recoveryID, code string) []byte {
+	sum := sha256.Sum256([]byte(recoveryID + ":" + code))
+	return sum[:]
+}
+
+// primaryEmail returns the user's verified primary email address from Clerk
+func primaryEmail(ctx context.Context, userID string) (string, error) {
+	u, err := user.Get(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch user: %w", err)
+	}
+	if u.PrimaryEmailAddressID == nil {
+		return "", errors.New("user has no primary email address")
+	}
+	for _, address := range u.EmailAddresses {
+		if address.ID != *u.PrimaryEmailAddressID {
+			continue
+		}
+		if address.Verification == nil || address.Verification.Status != "verified" {
+			return "", errors.New("primary email address is not verified")
+		}
+		return address.EmailAddress, nil
+	}
+	return "", errors.New("primary email address not found")
+}
+
+// maskEmail hides most of the local part, e.g. "jane@example.com" -> "j***@example.com"
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	return local[:1] + "***@" + domain
+}
@@ -0,0 +1,308 @@
+// HTTP handlers for hybrid search, fusing keyword and semantic rankings for the main search UI
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hybrid search limits
+const (
+	defaultHybridSearchLimit = 20
+	maxHybridSearchLimit     = 50
+	// hybridSearchCandidates is how many notes each ranking contributes before fusion
+	hybridSearchCandidates = 100
+	// hybridRRFConstant is the k of reciprocal-rank fusion (score = sum of 1/(k+rank)); the usual 60
+	// keeps a single top rank in one list from outweighing good ranks in both
+	hybridRRFConstant = 60
+)
+
+// hybridSearchRateGroup is the RATE_LIMITS group applied per user (most searches embed the query)
+const hybridSearchRateGroup = "hybrid_search"
+
+// defaultHybridSearchRateLimit applies when RATE_LIMITS has no hybrid_search entry
+var defaultHybridSearchRateLimit = services.RateLimit{Requests: 60, Window: time.Minute}
+
+// hybridSearchFilter narrows (or, with its scope, widens) both rankings. Its conditions use placeholders
+// $3 to $8 in both queries.
+type hybridSearchFilter struct {
+	collectionID string
+	tag          string
+	since        *time.Time
+	until        *time.Time
+	scope        searchScope
+}
+
+// args returns the filter's placeholder values, $3 to $8
+func (f hybridSearchFilter) args() []interface{} {
+	return []interface{}{f.collectionID, f.tag, f.since, f.until, f.scope.trashed, f.scope.archived}
+}
+
+// hybridFilterConditions applies a hybridSearchFilter to notes n
+const hybridFilterConditions = `
+		  AND ($3 = '' OR EXISTS (SELECT 1 FROM note_collections nc WHERE nc.note_id = n.id AND nc.collection_id = $3))
+		  AND ($4 = '' OR EXISTS (SELECT 1 FROM unnest(n.tags) t WHERE lower(t) = lower($4)))
+		  AND ($5::timestamptz IS NULL OR n.updated_at >= $5)
+		  AND ($6::timestamptz IS NULL OR n.updated_at < $6)
+		  AND (n.deleted_at IS NULL OR $7) AND (NOT n.is_archived OR $8)`
+
+// HybridSearchHandlers handles hybrid search HTTP endpoints
+type HybridSearchHandlers struct {
+	db         *services.Database
+	embeddings *services.EmbeddingIndexer
+	limiter    *services.RateLimiter
+}
+
+// NewHybridSearchHandlers creates a new HybridSearchHandlers instance
+func NewHybridSearchHandlers(db *services.Database, embeddings *services.EmbeddingIndexer) *HybridSearchHandlers {
+	return &HybridSearchHandlers{
+		db:         db,
+		embeddings: embeddings,
+		limiter:    services.NewRateLimiter(hybridSearchRateGroup, defaultHybridSearchRateLimit),
+	}
+}
+
+// HandleHybridSearch handles GET /api/search/hybrid?q=&limit=&collectionId=&tag=&since=&until=&record=
+// &includeTrashed=&includeArchived= - the user's live notes (and optionally trashed or archived ones) ranked by reciprocal-rank fusion of full-text matching on their plaintext fields
+// (title, domain, tags) and embedding similarity to the query. The query is embedded with the X-API-Key
+// header if given, otherwise with the user's stored Gemini key; without either, or if the provider
+// fails, only the keyword ranking is used. The query is added to the user's recent searches unless
+// record=false (for searches run as the user types).
+func (h *HybridSearchHandlers) HandleHybridSearch(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	query := strings.TrimSpace(params.Get("q"))
+	if query == "" {
+		respondWithError(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	if len(query) > maxSemanticQueryLength {
+		respondWithError(w, "Query is too long", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultHybridSearchLimit
+	if value := params.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			respondWithError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxHybridSearchLimit)
+	}
+
+	filter := hybridSearchFilter{
+		collectionID: params.Get("collectionId"),
+		tag:          strings.TrimSpace(params.Get("tag")), // Tags match case-insensitively
+		scope:        parseSearchScope(r),
+	}
+	if value := params.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithError(w, "Invalid since timestamp", http.StatusBadRequest)
+			return
+		}
+		filter.since = &since
+	}
+	if value := params.Get("until"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithError(w, "Invalid until timestamp", http.StatusBadRequest)
+			return
+		}
+		filter.until = &until
+	}
+
+	if retryAfter, ok := h.limiter.Allow(userID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many searches",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return
+	}
+
+	ctx := r.Context()
+	keyword, err := h.keywordMatches(ctx, userID, query, filter)
+	if err != nil {
+		log.Printf("Error running keyword search: %v", err)
+		respondWithError(w, "Failed to search notes", http.StatusInternalServerError)
+		return
+	}
+
+	var semantic []models.HybridSearchResult
+	embedding, ok := h.embedQuery(r, userID, query)
+	if ok {
+		semantic, err = h.semanticMatches(ctx, userID, embedding, filter)
+		if err != nil {
+			log.Printf("Error running semantic search: %v", err)
+			respondWithError(w, "Failed to search notes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if params.Get("record") != "false" {
+		if err := h.db.RecordSearch(ctx, userID, query); err != nil {
+			log.Printf("Error recording search: %v", err)
+		}
+	}
+
+	respondWithJSON(w, models.HybridSearchResponse{
+		Results:  fuseRankings(keyword, semantic, limit),
+		Semantic: ok,
+	}, http.StatusOK)
+}
+
+// Helper functions
+
+// embedQuery embeds the search query with the request's or the user's stored key, reporting false
+// (after logging why) if the semantic ranking has to be skipped
+func (h *HybridSearchHandlers) embedQuery(r *http.Request, userID, query string) ([]float32, bool) {
+	ctx := r.Context()
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		var err error
+		apiKey, err = h.embeddings.APIKey(ctx, userID)
+		if errors.Is(err, services.ErrNoStoredProviderKey) {
+			return nil, false
+		}
+		if err != nil {
+			log.Printf("Error loading stored provider key: %v", err)
+			return nil, false
+		}
+	}
+
+	geminiService, err := services.NewUserGeminiService(ctx, h.db, userID, apiKey)
+	if err != nil {
+		log.Printf("Error initializing Gemini service: %v", err)
+		return nil, false
+	}
+	defer geminiService.Close()
+
+	embedding, err := geminiService.EmbedQuery(ctx, query)
+	if err != nil {
+		log.Printf("Error embedding search query, falling back to keyword search: %v", err)
+		return nil, false
+	}
+	return embedding, true
+}
+
+// keywordMatches ranks the user's notes in scope by full-text match of the query against their title,
+// domain, and tags, plus trigram similarity of the title so small typos still match
+func (h *HybridSearchHandlers) keywordMatches(ctx context.Context, userID, query string, filter hybridSearchFilter) ([]models.HybridSearchResult, error) {
+	args := append([]interface{}{userID, query}, filter.args()...)
+	return h.rank(ctx, `
+		SELECT n.id, n.title, n.domain, to_json(n.tags), n.is_archived, n.deleted_at, n.updated_at
+		FROM notes n
+		CROSS JOIN websearch_to_tsquery('simple', $2) q
+		CROSS JOIN LATERAL (
+			SELECT to_tsvector('simple', n.title || ' ' || COALESCE(n.domain, '') || ' ' || array_to_string(n.tags, ' ')) AS doc
+		) d
+		WHERE n.user_id = $1
+		  AND (d.doc @@ q OR n.title % $2)`+hybridFilterConditions+`
+		ORDER BY ts_rank_cd(d.doc, q) + similarity(n.title, $2) DESC, n.updated_at DESC, n.id
+		LIMIT $9
+	`, append(args, hybridSearchCandidates)...)
+}
+
+// semanticMatches ranks the user's indexed notes in scope by cosine similarity to the query embedding
+func (h *HybridSearchHandlers) semanticMatches(ctx context.Context, userID string, embedding []float32, filter hybridSearchFilter) ([]models.HybridSearchResult, error) {
+	args := append([]interface{}{userID, services.FormatVector(embedding)}, filter.args()...)
+	return h.rank(ctx, `
+		SELECT n.id, n.title, n.domain, to_json(n.tags), n.is_archived, n.deleted_at, n.updated_at
+		FROM note_embeddings e
+		JOIN notes n ON n.id = e.note_id
+		WHERE e.user_id = $1 AND e.model = $10 AND NOT n.ai_excluded`+hybridFilterConditions+`
+		ORDER BY e.embedding <=> $2::vector
+		LIMIT $9
+	`, append(args, hybridSearchCandidates, services.EmbeddingModel)...)
+}
+
+// rank runs a ranking query and returns its notes in order
+func (h *HybridSearchHandlers) rank(ctx context.Context, query string, args ...interface{}) ([]models.HybridSearchResult, error) {
+	rows, err := h.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []models.HybridSearchResult
+	for rows.Next() {
+		var result models.HybridSearchResult
+		var domain sql.NullString
+		var tags []byte
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&result.NoteID, &result.Title, &domain, &tags, &result.Archived, &deletedAt, &result.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result.Domain = domain.String
+		if deletedAt.Valid {
+			result.DeletedAt = &deletedAt.Time
+		}
+		if err := json.Unmarshal(tags, &result.Tags); err != nil {
+			log.Printf("Error decoding tags of note %s: %v", result.NoteID, err)
+		}
+		if result.Tags == nil {
+			result.Tags = []string{}
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// fuseRankings merges the keyword and semantic rankings by reciprocal-rank fusion and returns the
+// best limit notes, ties broken by recency
+func fuseRankings(keyword, semantic []models.HybridSearchResult, limit int) []models.HybridSearchResult {
+	fused := make(map[string]*models.HybridSearchResult)
+	var order []string
+	add := func(ranking []models.HybridSearchResult, setRank func(*models.HybridSearchResult, int)) {
+		for i := range ranking {
+			result, ok := fused[ranking[i].NoteID]
+			if !ok {
+				copied := ranking[i]
+				result = &copied
+				fused[result.NoteID] = result
+				order = append(order, result.NoteID)
+			}
+			setRank(result, i+1)
+			result.Score += 1 / float64(hybridRRFConstant+i+1)
+		}
+	}
+	add(keyword, func(r *models.HybridSearchResult, rank int) { r.KeywordRank = rank })
+	add(semantic, func(r *models.HybridSearchResult, rank int) { r.SemanticRank = rank })
+
+	results := make([]models.HybridSearchResult, 0, len(order))
+	for _, id := range order {
+		results = append(results, *fused[id])
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].UpdatedAt.After(results[j].UpdatedAt)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
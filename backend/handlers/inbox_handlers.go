@@ -0,0 +1,225 @@
+// HTTP handlers for the public capture inbox (email forwarding, web clipper)
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxInboxCaptureSize is the largest capture body accepted
+const maxInboxCaptureSize = 1 << 20
+
+// noncePattern restricts nonces to 16-64 URL-safe characters
+var noncePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+// InboxHandlers handles capture inbox HTTP endpoints
+type InboxHandlers struct {
+	db    *services.Database
+	guard *services.PublicTokenGuard
+}
+
+// NewInboxHandlers creates a new InboxHandlers instance
+func NewInboxHandlers(db *services.Database, guard *services.PublicTokenGuard) *InboxHandlers {
+	return &InboxHandlers{db: db, guard: guard}
+}
+
+// HandleCreateToken handles POST /api/capture/inbox-tokens - create a capture inbox token
+func (h *InboxHandlers) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateInboxTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding inbox token request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	secret := make([]byte, 32)
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Error generating inbox secret: %v", err)
+		respondWithError(w, "Failed to create inbox token", http.StatusInternalServerError)
+		return
+	}
+	if _, err := rand.Read(idBytes); err != nil {
+		log.Printf("Error generating inbox token ID: %v", err)
+		respondWithError(w, "Failed to create inbox token", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+
+	token := models.InboxTokenResponse{
+		ID:        "inb_" + hex.EncodeToString(idBytes),
+		Secret:    base64.StdEncoding.EncodeToString(secret),
+		Label:     req.Label,
+		CreatedAt: time.Now(),
+	}
+	query := `INSERT INTO capture_inbox_tokens (id, user_id, secret, label, created_at) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := h.db.DB.ExecContext(ctx, query, token.ID, userID, secret, req.Label, token.CreatedAt); err != nil {
+		log.Printf("Error storing inbox token: %v", err)
+		respondWithError(w, "Failed to create inbox token", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, token, http.StatusCreated)
+}
+
+// HandleRevokeToken handles DELETE /api/capture/inbox-tokens/{id} - revoke a capture inbox token
+func (h *InboxHandlers) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := `UPDATE capture_inbox_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := h.db.DB.ExecContext(r.Context(), query, r.PathValue("id"), userID)
+	if err != nil {
+		log.Printf("Error revoking inbox token: %v", err)
+		respondWithError(w, "Failed to revoke inbox token", http.StatusInternalServerError)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		respondWithError(w, "Inbox token not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleInboxCapture handles POST /api/capture/inbox/{tokenId}?expires=&nonce=&sig= - accept content into
+// the token owner's staged notes inbox. No session is needed; instead sig must be
+// hex(HMAC-SHA256(secret, "expires\nnonce\nhex(sha256(body))")), expires must be within
+// PublicTokenMaxTTL, and each nonce is accepted once.
+func (h *InboxHandlers) HandleInboxCapture(w http.ResponseWriter, r *http.Request) {
+	tokenID := r.PathValue("tokenId")
+	query := r.URL.Query()
+	nonce := query.Get("nonce")
+	expiresUnix, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || !noncePattern.MatchString(nonce) {
+		respondWithError(w, "expires and nonce are required", http.StatusBadRequest)
+		return
+	}
+	signature, err := hex.DecodeString(query.Get("sig"))
+	if err != nil || len(signature) == 0 {
+		respondWithError(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	// Rate limit before any database work so scraping is cheap to turn away
+	if retryAfter, ok := h.guard.Allow(tokenID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many requests for this token",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInboxCaptureSize))
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Capture exceeds %dKB limit", maxInboxCaptureSize>>10), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx := r.Context()
+	userID, secret, err := h.inboxToken(r, tokenID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading inbox token: %v", err)
+		respondWithError(w, "Failed to accept capture", http.StatusInternalServerError)
+		return
+	}
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(expiresUnix, 10) + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		respondWithError(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	expires := time.Unix(expiresUnix, 0)
+	if err := h.guard.CheckExpiry(expires); err != nil {
+		respondWithJSON(w, models.ErrorResponse{Error: "Request expired", Code: models.ErrCodeTokenExpired}, http.StatusUnauthorized)
+		return
+	}
+	if err := h.guard.ConsumeNonce(ctx, tokenID, nonce, expires); err != nil {
+		if errors.Is(err, services.ErrTokenReplayed) {
+			respondWithJSON(w, models.ErrorResponse{Error: "Request already used", Code: models.ErrCodeTokenReplayed}, http.StatusConflict)
+			return
+		}
+		log.Printf("Error recording inbox nonce: %v", err)
+		respondWithError(w, "Failed to accept capture", http.StatusInternalServerError)
+		return
+	}
+
+	var req models.InboxCaptureRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		respondWithError(w, "Content is required", http.StatusBadRequest)
+		return
+	}
+	if req.Source != models.StagedSourceEmail && req.Source != models.StagedSourceClipper {
+		respondWithError(w, "source must be email or clipper", http.StatusBadRequest)
+		return
+	}
+
+	// Captures are flagged rather than dropped: clipping a page again is usually deliberate
+	ids, duplicates, err := h.db.StageNotes(ctx, userID, req.Source, req.SourceURL, []models.ImportedNote{{
+		Title:   req.Title,
+		Content: req.Content,
+	}}, models.DuplicatesFlag)
+	if err != nil {
+		log.Printf("Error staging inbox capture: %v", err)
+		respondWithError(w, "Failed to accept capture", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.db.DB.ExecContext(ctx, `UPDATE capture_inbox_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, tokenID); err != nil {
+		log.Printf("Error updating inbox token last use: %v", err)
+	}
+
+	respondWithJSON(w, models.InboxCaptureResponse{StagedNoteID: ids[0], Duplicate: len(duplicates) > 0}, http.StatusCreated)
+}
+
+// Helper functions
+
+// inboxToken returns the owner and secret of an active inbox token, or sql.ErrNoRows
+func (h *InboxHandlers) inboxToken(r *http.Request, tokenID string) (userID string, secret []byte, err error) {
+	err = h.db.DB.QueryRowContext(r.Context(), `
+		SELECT user_id, secret FROM capture_inbox_tokens WHERE id = $1 AND revoked_at IS NULL
+	`, tokenID).Scan(&userID, &secret)
+	return userID, secret, err
+}
@@ -0,0 +1,128 @@
+// HTTP handlers for background jobs and imports
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxImportUploadSize is the largest import archive accepted
+const maxImportUploadSize = 50 << 20
+
+// JobHandlers handles background job HTTP endpoints
+type JobHandlers struct {
+	queue *services.JobQueue
+}
+
+// NewJobHandlers creates a new JobHandlers instance
+func NewJobHandlers(queue *services.JobQueue) *JobHandlers {
+	return &JobHandlers{queue: queue}
+}
+
+// HandleCreateImport handles POST /api/import - enqueue an import job for an uploaded file
+func (h *JobHandlers) HandleCreateImport(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, filename, err := readImportUpload(w, r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := strings.ToLower(r.FormValue("format"))
+	if format == "" {
+		format = models.ImportFormatMarkdown
+	}
+	switch format {
+	case models.ImportFormatMarkdown, models.ImportFormatENEX, models.ImportFormatNotion:
+	default:
+		respondWithError(w, fmt.Sprintf("Unsupported import format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	onDuplicate := strings.ToLower(r.FormValue("onDuplicate"))
+	if onDuplicate == "" {
+		onDuplicate = models.DuplicatesSkip
+	}
+	if onDuplicate != models.DuplicatesSkip && onDuplicate != models.DuplicatesFlag {
+		respondWithError(w, "onDuplicate must be skip or flag", http.StatusBadRequest)
+		return
+	}
+
+	params := models.ImportParams{Format: format, Filename: filename, OnDuplicate: onDuplicate}
+	jobID, err := h.queue.Enqueue(r.Context(), userID, models.JobTypeImport, data, params)
+	if err != nil {
+		log.Printf("Error enqueueing import job: %v", err)
+		respondWithError(w, "Failed to start import", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/jobs/"+jobID)
+	respondWithJSON(w, models.JobCreatedResponse{JobID: jobID, Status: models.JobQueued}, http.StatusAccepted)
+}
+
+// HandleGetJob handles GET /api/jobs/{id} - report a job's progress, partial errors, and result
+func (h *JobHandlers) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	job, err := h.queue.GetJob(r.Context(), userID, r.PathValue("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching job: %v", err)
+		respondWithError(w, "Failed to fetch job", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, job, http.StatusOK)
+}
+
+// Helper functions
+
+func readImportUpload(w http.ResponseWriter, r *http.Request) (data []byte, filename string, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadSize+(1<<20)) // Allow room for other form fields
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, "", fmt.Errorf("import file exceeds %dMB limit", maxImportUploadSize>>20)
+		}
+		return nil, "", errors.New("invalid multipart form")
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, "", errors.New("import file is required")
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("Error closing import upload: %v", err)
+		}
+	}()
+
+	if header.Size > maxImportUploadSize {
+		return nil, "", fmt.Errorf("import file exceeds %dMB limit", maxImportUploadSize>>20)
+	}
+
+	data, err = io.ReadAll(file)
+	if err != nil {
+		return nil, "", errors.New("failed to read import file")
+	}
+	return data, header.Filename, nil
+}
@@ -0,0 +1,318 @@
+// HTTP handlers for the REST notes API
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NoteAPIHandlers serves single-note reads and writes for integrations that don't run the sync protocol.
+// Writes go through the sync push path, so both APIs apply the same validation, ownership, conflict,
+// limit, timeline, and realtime rules.
+type NoteAPIHandlers struct {
+	db             *services.Database
+	sync           *SyncHandlers
+	trashRetention time.Duration // How long deleted notes are kept; zero keeps them
+}
+
+// NewNoteAPIHandlers creates a new NoteAPIHandlers instance
+func NewNoteAPIHandlers(db *services.Database, sync *SyncHandlers, trashRetention time.Duration) *NoteAPIHandlers {
+	return &NoteAPIHandlers{db: db, sync: sync, trashRetention: trashRetention}
+}
+
+// HandleListNotes handles GET /api/notes, responding with one page of the user's live notes matching
+// the query's filters (collectionId, tag, pinned, archived, snoozed, sourceUrlHash), most recently
+// updated first
+func (h *NoteAPIHandlers) HandleListNotes(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := services.NoteFilter{
+		CollectionID: query.Get("collectionId"),
+		Tag:          strings.TrimSpace(query.Get("tag")), // Tags match case-insensitively
+	}
+	if hashes := query["sourceUrlHash"]; len(hashes) > 0 {
+		// Notes captured on a page, so revisiting it can surface them. Clients may send several hashes
+		// (e.g. with and without the query string) to match loosely.
+		for _, hash := range hashes {
+			if !sourceURLHashPattern.MatchString(hash) {
+				respondWithError(w, "sourceUrlHash must be a lowercase hex HMAC-SHA256", http.StatusBadRequest)
+				return
+			}
+		}
+		filter.SourceURLHashes = hashes
+	}
+	for _, flag := range []struct {
+		param string
+		value **bool
+	}{{"pinned", &filter.Pinned}, {"archived", &filter.Archived}, {"snoozed", &filter.Snoozed}} {
+		value := query.Get(flag.param)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			respondWithError(w, "Invalid "+flag.param+" filter", http.StatusBadRequest)
+			return
+		}
+		*flag.value = &parsed
+	}
+
+	notes, err := h.sync.notes.ListNotes(r.Context(), userID, filter, params)
+	if err != nil {
+		log.Printf("Error listing notes: %v", err)
+		respondWithError(w, "Failed to list notes", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(notes, params.Limit, func(n models.SyncNote) pagination.Cursor {
+		return pagination.Cursor{SortValue: n.UpdatedAt, ID: n.ID}
+	}), http.StatusOK)
+}
+
+// HandleCreateNote handles POST /api/notes, creating a note from the request body and generating its
+// ID if none is given
+func (h *NoteAPIHandlers) HandleCreateNote(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SyncNote
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding note: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.sync.users.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+
+	if req.ID == "" {
+		id, err := services.NewNoteID()
+		if err != nil {
+			log.Printf("Error generating note ID: %v", err)
+			respondWithError(w, "Failed to create note", http.StatusInternalServerError)
+			return
+		}
+		req.ID = id
+	} else {
+		// Creating never overwrites: live notes are changed with PUT, and deleted notes' IDs aren't reused
+		var exists bool
+		if err := h.db.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM notes WHERE id = $1)`, req.ID).Scan(&exists); err != nil {
+			log.Printf("Error checking note %s: %v", req.ID, err)
+			respondWithError(w, "Failed to create note", http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			respondWithError(w, "A note with this ID already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	now := time.Now()
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = now
+	}
+	if req.UpdatedAt.IsZero() {
+		req.UpdatedAt = now
+	}
+	req.DeletedAt, req.BaseUpdatedAt = nil, nil
+
+	if h.noteLimitAllows(w, r, userID, "Failed to create note") && h.writeNote(w, r, userID, &req) {
+		h.respondWithNote(w, r, userID, req.ID, http.StatusCreated)
+	}
+}
+
+// HandleGetNote handles GET /api/notes/{id}, responding with a live note
+func (h *NoteAPIHandlers) HandleGetNote(w http.ResponseWriter, r *http.Request) {
+	if _, note, ok := h.requestedNote(w, r); ok {
+		respondWithJSON(w, note, http.StatusOK)
+	}
+}
+
+// HandlePutNote handles PUT /api/notes/{id}, replacing a live note; with baseUpdatedAt, it fails with
+// 409 if the note changed since
+func (h *NoteAPIHandlers) HandlePutNote(w http.ResponseWriter, r *http.Request) {
+	userID, note, ok := h.requestedNote(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.SyncNote
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding note: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ID != "" && req.ID != note.ID {
+		respondWithError(w, "Note ID doesn't match the URL", http.StatusBadRequest)
+		return
+	}
+	req.ID, req.CreatedAt, req.DeletedAt = note.ID, note.CreatedAt, nil
+	if req.UpdatedAt.IsZero() {
+		req.UpdatedAt = time.Now()
+	}
+	if h.writeNote(w, r, userID, &req) {
+		h.respondWithNote(w, r, userID, note.ID, http.StatusOK)
+	}
+}
+
+// HandleDeleteNote handles DELETE /api/notes/{id}, deleting a live note
+func (h *NoteAPIHandlers) HandleDeleteNote(w http.ResponseWriter, r *http.Request) {
+	userID, note, ok := h.requestedNote(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if h.writeNote(w, r, userID, &models.SyncNote{ID: note.ID, DeletedAt: &now}) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Helper functions
+
+// noteLimitAllows checks that the user can have another live note. If not (or the check fails) it
+// responds with 422 (or 500 with failure) and returns false.
+func (h *NoteAPIHandlers) noteLimitAllows(w http.ResponseWriter, r *http.Request, userID, failure string) bool {
+	limits := services.Config.SyncLimits()
+	var count int
+	if err := h.db.DB.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM notes WHERE user_id = $1 AND deleted_at IS NULL`, userID).Scan(&count); err != nil {
+		log.Printf("Error counting notes: %v", err)
+		respondWithError(w, failure, http.StatusInternalServerError)
+		return false
+	}
+	if count >= limits.MaxNotesPerUser {
+		respondWithJSON(w, models.LimitExceededResponse{
+			Error: fmt.Sprintf("You can have at most %d notes; delete some before adding more", limits.MaxNotesPerUser),
+			Code:  models.ErrCodeLimitExceeded,
+			Limit: models.LimitNoteCount,
+			Max:   limits.MaxNotesPerUser,
+		}, http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// writeNote applies a one-note push and, on failure, responds with the reason. Conflicts are always
+// rejected rather than resolved by the user's sync policy, so a stale PUT never silently overwrites.
+func (h *NoteAPIHandlers) writeNote(w http.ResponseWriter, r *http.Request, userID string, note *models.SyncNote) bool {
+	ctx := r.Context()
+	req := &models.SyncRequest{Notes: []models.SyncNote{*note}}
+
+	locks, err := h.sync.notes.ActiveNoteLocks(ctx, userID, []string{note.ID})
+	if err != nil {
+		log.Printf("Error checking note locks: %v", err)
+		respondWithError(w, "Failed to save note", http.StatusInternalServerError)
+		return false
+	}
+	if len(locks) > 0 {
+		respondNoteLocked(w, locks)
+		return false
+	}
+
+	if results, ok := validateSyncPush(req, services.Config.SyncLimits().MaxNoteBytes); !ok {
+		respondWithJSON(w, models.SyncPushRejectedResponse{
+			Error:   results[0].Error,
+			Code:    models.ErrCodeSyncPushRejected,
+			Results: results,
+		}, http.StatusUnprocessableEntity)
+		return false
+	}
+	normalizeSyncPush(req, userLocation(ctx, h.db, userID))
+
+	applied, err := h.sync.notes.ApplyPush(ctx, userID, r.Header.Get("X-Device-ID"), models.ConflictReject, req)
+	switch {
+	case errors.Is(err, services.ErrPushForbidden):
+		respondWithError(w, "The note belongs to another account", http.StatusForbidden)
+		return false
+	case errors.Is(err, services.ErrPushConflicts):
+		respondWithJSON(w, models.SyncPushRejectedResponse{
+			Error:     "The note changed on the server since baseUpdatedAt",
+			Code:      models.ErrCodeSyncPushRejected,
+			Results:   applied.Results,
+			Conflicts: applied.Conflicts,
+		}, http.StatusConflict)
+		return false
+	case err != nil:
+		log.Printf("Error saving note %s: %v", note.ID, err)
+		respondWithError(w, "Failed to save note", http.StatusInternalServerError)
+		return false
+	}
+
+	for _, embed := range applied.Embed {
+		h.sync.embeddings.Enqueue(userID, embed.ID, embed.Title, *embed.EmbeddingText)
+	}
+	h.sync.hub.PublishChanges(userID, applied.NoteIDs, applied.CollectionIDs)
+	return true
+}
+
+// respondWithNote responds with the note as stored
+func (h *NoteAPIHandlers) respondWithNote(w http.ResponseWriter, r *http.Request, userID, noteID string, status int) {
+	note, err := h.liveNote(r.Context(), userID, noteID)
+	if err != nil {
+		log.Printf("Error fetching note %s after saving: %v", noteID, err)
+		respondWithError(w, "Note saved, but it couldn't be fetched", http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, note, status)
+}
+
+// requestedNote returns the signed-in user and their live note named by the {id} path value. If
+// there's no user or note (or the lookup fails) it responds with the error and returns false.
+func (h *NoteAPIHandlers) requestedNote(w http.ResponseWriter, r *http.Request) (string, models.SyncNote, bool) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return "", models.SyncNote{}, false
+	}
+
+	noteID := r.PathValue("id")
+	note, err := h.liveNote(r.Context(), userID, noteID)
+	if errors.Is(err, errNoteNotFound) {
+		respondWithError(w, "Note not found", http.StatusNotFound)
+		return "", models.SyncNote{}, false
+	}
+	if err != nil {
+		log.Printf("Error fetching note %s: %v", noteID, err)
+		respondWithError(w, "Failed to fetch note", http.StatusInternalServerError)
+		return "", models.SyncNote{}, false
+	}
+	return userID, note, true
+}
+
+// liveNote returns the user's note, or errNoteNotFound if it doesn't exist or is deleted
+func (h *NoteAPIHandlers) liveNote(ctx context.Context, userID, noteID string) (models.SyncNote, error) {
+	notes, err := h.sync.notes.NotesByIDs(ctx, userID, []string{noteID})
+	if err != nil {
+		return models.SyncNote{}, err
+	}
+	if len(notes) == 0 || notes[0].DeletedAt != nil {
+		return models.SyncNote{}, errNoteNotFound
+	}
+	return notes[0], nil
+}
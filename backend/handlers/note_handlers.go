@@ -0,0 +1,541 @@
+// HTTP handlers for note metadata, timeline, and export endpoints
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Tag limits
+const (
+	maxNoteTags      = 50
+	maxNoteTagLength = 64
+)
+
+// maxNoteLinks caps the notes a note can link to
+const maxNoteLinks = 500
+
+// maxExportMarkdownSize caps the decrypted markdown sent to be rendered
+const maxExportMarkdownSize = 1 << 20
+
+// exportContentTypes are the response types of the supported export formats
+var exportContentTypes = map[string]string{
+	services.ExportFormatHTML: "text/html; charset=utf-8",
+	services.ExportFormatPDF:  "application/pdf",
+}
+
+// errNoteNotFound is returned when a note doesn't exist, is deleted, or belongs to another user
+var errNoteNotFound = errors.New("note not found")
+
+// NoteHandlers handles note metadata, timeline, and export HTTP endpoints
+type NoteHandlers struct {
+	db  *services.Database
+	hub *services.RealtimeHub
+}
+
+// NewNoteHandlers creates a new NoteHandlers instance
+func NewNoteHandlers(db *services.Database, hub *services.RealtimeHub) *NoteHandlers {
+	return &NoteHandlers{db: db, hub: hub}
+}
+
+// HandlePatchNoteMeta handles PATCH /api/notes/{id}/meta - change pinned, archived, AI exclusion,
+// collections, tags, links, reminder, or snooze without re-uploading the encrypted body. Only the given fields are written, so toggles from
+// different devices don't conflict with each other or with content edits.
+func (h *NoteHandlers) HandlePatchNoteMeta(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.PatchNoteMetaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding note meta request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var tags []string
+	if req.Tags != nil {
+		if tags, err = normalizeTags(*req.Tags); err != nil {
+			respondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	noteID := r.PathValue("id")
+	ctx := r.Context()
+
+	if req.LinkedNoteIDs != nil {
+		links := make([]string, 0, len(*req.LinkedNoteIDs))
+		for _, id := range uniqueStrings(*req.LinkedNoteIDs) {
+			if id != "" && id != noteID {
+				links = append(links, id)
+			}
+		}
+		if len(links) > maxNoteLinks {
+			respondWithError(w, fmt.Sprintf("a note can link to at most %d notes", maxNoteLinks), http.StatusBadRequest)
+			return
+		}
+		req.LinkedNoteIDs = &links
+	}
+
+	var reminderAt *time.Time
+	if req.ReminderAt != nil && *req.ReminderAt != "" {
+		parsed, err := parseReminderAt(*req.ReminderAt, func() *time.Location { return userLocation(ctx, h.db, userID) })
+		if err != nil {
+			respondWithError(w, "Invalid reminderAt timestamp", http.StatusBadRequest)
+			return
+		}
+		reminderAt = &parsed
+	}
+
+	var snoozedUntil *time.Time
+	if req.SnoozedUntil != nil && *req.SnoozedUntil != "" {
+		parsed, err := parseSnoozedUntil(*req.SnoozedUntil, func() *time.Location { return userLocation(ctx, h.db, userID) })
+		if err != nil {
+			respondWithError(w, "Invalid snoozedUntil timestamp", http.StatusBadRequest)
+			return
+		}
+		if !parsed.After(time.Now()) {
+			respondWithError(w, "snoozedUntil must be in the future", http.StatusBadRequest)
+			return
+		}
+		snoozedUntil = &parsed
+	}
+
+	// Don't interleave with destructive operations holding note locks
+	locks, err := h.db.ActiveNoteLocks(ctx, userID, []string{noteID})
+	if err != nil {
+		log.Printf("Error checking note locks: %v", err)
+		respondWithError(w, "Failed to update note", http.StatusInternalServerError)
+		return
+	}
+	if len(locks) > 0 {
+		respondNoteLocked(w, locks)
+		return
+	}
+
+	meta, err := h.patchNoteMeta(ctx, userID, noteID, r.Header.Get("X-Device-ID"), &req, tags, reminderAt, snoozedUntil)
+	switch {
+	case errors.Is(err, errNoteNotFound):
+		respondWithError(w, "Note not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrCollectionNotFound):
+		respondWithError(w, "Unknown collection", http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error updating note meta for %s: %v", noteID, err)
+		respondWithError(w, "Failed to update note", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.PublishChanges(userID, []string{noteID}, nil)
+	respondWithJSON(w, meta, http.StatusOK)
+}
+
+// HandleNoteTimeline handles GET /api/notes/{id}/timeline?limit=&cursor= - the note's activity (edits,
+// deletes, metadata changes, and sync conflicts, with the device that caused them), newest first.
+// Deleted notes keep their timeline.
+func (h *NoteHandlers) HandleNoteTimeline(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.listNoteEvents(r.Context(), userID, r.PathValue("id"), params)
+	switch {
+	case errors.Is(err, errNoteNotFound):
+		respondWithError(w, "Note not found", http.StatusNotFound)
+		return
+	case errors.Is(err, pagination.ErrInvalidCursor):
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error listing note events: %v", err)
+		respondWithError(w, "Failed to fetch note timeline", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(events, params.Limit, func(e models.NoteEvent) pagination.Cursor {
+		return pagination.Cursor{SortValue: e.CreatedAt, ID: e.ID}
+	}), http.StatusOK)
+}
+
+// HandleNoteGraph handles GET /api/notes/graph - metrics over the links between the user's live notes
+// (as reported through linkedNoteIds): notes without links, the most linked notes, clusters of linked
+// notes, and links to suggest for unlinked notes from the tags they share
+func (h *NoteHandlers) HandleNoteGraph(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	metrics, err := h.db.NoteGraph(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error computing notes graph: %v", err)
+		respondWithError(w, "Failed to compute notes graph", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, metrics, http.StatusOK)
+}
+
+// HandleExportNote handles POST /api/notes/{id}/export?format=pdf|html - render the note as a styled
+// document to share outside Jottin. Note bodies are end-to-end encrypted, so the client sends the
+// decrypted markdown; it's rendered under the note's title and not stored. It's a POST rather than a
+// GET so the plaintext travels in the body and never in a URL.
+func (h *NoteHandlers) HandleExportNote(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		respondWithError(w, "format must be pdf or html", http.StatusBadRequest)
+		return
+	}
+
+	var req models.ExportNoteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxExportMarkdownSize)).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, fmt.Sprintf("Note exceeds %dMB limit", maxExportMarkdownSize>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var title string
+	err = h.db.DB.QueryRowContext(r.Context(), `
+		SELECT title FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+	`, r.PathValue("id"), userID).Scan(&title)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching note for export: %v", err)
+		respondWithError(w, "Failed to export note", http.StatusInternalServerError)
+		return
+	}
+
+	var document []byte
+	if format == services.ExportFormatPDF {
+		document, err = services.RenderNotePDF(title, req.Markdown)
+	} else {
+		document, err = services.RenderNoteHTML(title, req.Markdown)
+	}
+	if err != nil {
+		log.Printf("Error rendering note export: %v", err)
+		respondWithError(w, "Failed to export note", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": services.ExportFilename(title, "note") + "." + format,
+	}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(document); err != nil {
+		log.Printf("Error writing note export: %v", err)
+	}
+}
+
+// Helper functions
+
+// listNoteEvents fetches one page (plus one extra row to detect more) of a note's timeline, newest
+// first, or errNoteNotFound if the user has no such note
+func (h *NoteHandlers) listNoteEvents(ctx context.Context, userID, noteID string, params pagination.Params) ([]models.NoteEvent, error) {
+	var cursorTime *time.Time
+	var cursorID int64
+	if params.Cursor != nil {
+		id, err := strconv.ParseInt(params.Cursor.ID, 10, 64)
+		if err != nil {
+			return nil, pagination.ErrInvalidCursor
+		}
+		cursorTime, cursorID = &params.Cursor.SortValue, id
+	}
+
+	var exists bool
+	if err := h.db.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM notes WHERE id = $1 AND user_id = $2)
+	`, noteID, userID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNoteNotFound
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT id::text, type, COALESCE(device_id, ''), COALESCE(related_note_id, ''), created_at
+		FROM note_events
+		WHERE note_id = $1 AND user_id = $2
+		  AND ($4::timestamptz IS NULL OR (created_at, id) < ($4, $5::bigint))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, noteID, userID, params.Limit+1, cursorTime, cursorID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var events []models.NoteEvent
+	for rows.Next() {
+		var event models.NoteEvent
+		if err := rows.Scan(&event.ID, &event.Type, &event.DeviceID, &event.RelatedNoteID, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// patchNoteMeta applies a metadata patch in one transaction and returns the resulting metadata
+func (h *NoteHandlers) patchNoteMeta(ctx context.Context, userID, noteID, deviceID string, req *models.PatchNoteMetaRequest, tags []string, reminderAt, snoozedUntil *time.Time) (meta models.NoteMeta, err error) {
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return meta, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back note meta update: %v", rbErr)
+			}
+		}
+	}()
+
+	// Bumping updated_at makes other devices pull the change
+	var tagsJSON []byte
+	var reminder, snooze sql.NullTime
+	meta.ID = noteID
+	// Ending a snooze also drops its notification
+	err = tx.QueryRowContext(ctx, `
+		UPDATE notes SET
+			is_pinned = COALESCE($3, is_pinned),
+			is_archived = COALESCE($4, is_archived),
+			tags = COALESCE($5, tags),
+			reminder_at = CASE WHEN $6 THEN $7 ELSE reminder_at END,
+			ai_excluded = COALESCE($8, ai_excluded),
+			snoozed_until = CASE WHEN $9 THEN $10 ELSE snoozed_until END,
+			snooze_notify = CASE WHEN $9 AND $10::timestamptz IS NULL THEN FALSE ELSE COALESCE($11, snooze_notify) END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		RETURNING is_pinned, is_archived, to_json(tags), reminder_at, ai_excluded, snoozed_until, snooze_notify, updated_at
+	`, noteID, userID, req.IsPinned, req.IsArchived, tags, req.ReminderAt != nil, reminderAt, req.AIExcluded,
+		req.SnoozedUntil != nil, snoozedUntil, req.SnoozeNotify,
+	).Scan(&meta.IsPinned, &meta.IsArchived, &tagsJSON, &reminder, &meta.AIExcluded, &snooze, &meta.SnoozeNotify, &meta.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return meta, errNoteNotFound
+	}
+	if err != nil {
+		return meta, err
+	}
+	if err = json.Unmarshal(tagsJSON, &meta.Tags); err != nil {
+		return meta, err
+	}
+	if reminder.Valid {
+		meta.ReminderAt = &reminder.Time
+	}
+	if snooze.Valid {
+		meta.SnoozedUntil = &snooze.Time
+	}
+	if meta.AIExcluded {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM note_embeddings WHERE note_id = $1`, noteID); err != nil {
+			return meta, err
+		}
+	}
+
+	if req.CollectionIDs != nil {
+		var live int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM collections WHERE user_id = $1 AND id = ANY($2) AND deleted_at IS NULL
+		`, userID, *req.CollectionIDs).Scan(&live)
+		if err != nil {
+			return meta, err
+		}
+		if live != len(uniqueStrings(*req.CollectionIDs)) {
+			return meta, services.ErrCollectionNotFound
+		}
+
+		if _, err = tx.ExecContext(ctx, `DELETE FROM note_collections WHERE note_id = $1`, noteID); err != nil {
+			return meta, err
+		}
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO note_collections (note_id, collection_id)
+			SELECT $1, unnest($2::text[])
+			ON CONFLICT DO NOTHING
+		`, noteID, *req.CollectionIDs); err != nil {
+			return meta, err
+		}
+	}
+
+	// Links to notes that don't exist (yet) are kept; the graph only counts links between live notes
+	if req.LinkedNoteIDs != nil {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM note_links WHERE source_id = $1`, noteID); err != nil {
+			return meta, err
+		}
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO note_links (user_id, source_id, target_id)
+			SELECT $1, $2, unnest($3::text[])
+			ON CONFLICT DO NOTHING
+		`, userID, noteID, *req.LinkedNoteIDs); err != nil {
+			return meta, err
+		}
+	}
+
+	if meta.CollectionIDs, err = collectionIDsOfNote(ctx, tx, noteID); err != nil {
+		return meta, err
+	}
+	if meta.LinkedNoteIDs, err = linkedNoteIDs(ctx, tx, noteID); err != nil {
+		return meta, err
+	}
+	if err = services.RecordNoteEvent(ctx, tx, userID, noteID, models.NoteEventMetaUpdated, deviceID, ""); err != nil {
+		return meta, err
+	}
+
+	err = tx.Commit()
+	return meta, err
+}
+
+// collectionIDsOfNote returns the IDs of the collections a note belongs to
+func collectionIDsOfNote(ctx context.Context, q queryer, noteID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT collection_id FROM note_collections WHERE note_id = $1 ORDER BY collection_id`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	collectionIDs := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		collectionIDs = append(collectionIDs, id)
+	}
+	return collectionIDs, rows.Err()
+}
+
+// linkedNoteIDs returns the IDs of the notes a note links to
+func linkedNoteIDs(ctx context.Context, q queryer, noteID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT target_id FROM note_links WHERE source_id = $1 ORDER BY target_id`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	noteIDs := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		noteIDs = append(noteIDs, id)
+	}
+	return noteIDs, rows.Err()
+}
+
+// reminderLocalLayouts are the wall-clock forms of reminderAt, without an offset
+var reminderLocalLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04"}
+
+// parseReminderAt parses an RFC 3339 timestamp, or a wall-clock time without an offset in the user's
+// time zone (looked up only when needed), so "9:00 tomorrow" fires at 9:00 where the user is
+func parseReminderAt(value string, location func() *time.Location) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return parsed, nil
+	}
+	for _, layout := range reminderLocalLayouts {
+		if len(value) == len(layout) {
+			if local, localErr := time.ParseInLocation(layout, value, location()); localErr == nil {
+				return local, nil
+			}
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseSnoozedUntil parses snoozedUntil like reminderAt, or a date (YYYY-MM-DD), which snoozes until
+// the start of that day in the user's time zone
+func parseSnoozedUntil(value string, location func() *time.Location) (time.Time, error) {
+	if len(value) == len(time.DateOnly) {
+		return time.ParseInLocation(time.DateOnly, value, location())
+	}
+	return parseReminderAt(value, location)
+}
+
+// normalizeTags trims and de-duplicates tags (case-insensitively, keeping the first spelling)
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxNoteTagLength {
+			return nil, fmt.Errorf("tags can be at most %d characters", maxNoteTagLength)
+		}
+		key := strings.ToLower(tag)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxNoteTags {
+		return nil, fmt.Errorf("a note can have at most %d tags", maxNoteTags)
+	}
+	return normalized, nil
+}
+
+// uniqueStrings returns values without duplicates, in their original order
+func uniqueStrings(values []string) []string {
+	unique := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,45 @@
+// Helpers for guarding destructive note operations with advisory locks
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// lockNotes acquires note locks for a destructive operation, responding with 409 (and the lock holders)
+// or 500 and returning nil if they can't be taken. Callers must Release the returned handle.
+func lockNotes(w http.ResponseWriter, r *http.Request, db *services.Database, userID string, noteIDs []string, operation string) *services.NoteLockHandle {
+	holder, err := GetSessionUserID(r)
+	if err != nil {
+		holder = userID
+	}
+
+	handle, err := db.AcquireNoteLocks(r.Context(), userID, noteIDs, models.NoteLock{
+		Operation:    operation,
+		HolderUserID: holder,
+		DeviceID:     r.Header.Get("X-Device-ID"),
+	})
+	if err != nil {
+		var lockedErr *services.NoteLockedError
+		if errors.As(err, &lockedErr) {
+			respondNoteLocked(w, lockedErr.Locks)
+			return nil
+		}
+		log.Printf("Error acquiring note locks: %v", err)
+		respondWithError(w, "Failed to lock notes", http.StatusInternalServerError)
+		return nil
+	}
+	return handle
+}
+
+// respondNoteLocked responds with 409 and the current lock holders
+func respondNoteLocked(w http.ResponseWriter, locks []models.NoteLock) {
+	respondWithJSON(w, models.NoteLockedResponse{
+		Error: "Note is locked by another operation, retry shortly",
+		Code:  models.ErrCodeNoteLocked,
+		Locks: locks,
+	}, http.StatusConflict)
+}
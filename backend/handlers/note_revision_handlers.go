@@ -0,0 +1,188 @@
+// HTTP handlers for note version history
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// errRevisionNotFound is returned when a revision doesn't exist or belongs to another note
+var errRevisionNotFound = errors.New("revision not found")
+
+// HandleNoteRevisions handles GET /api/notes/{id}/revisions?limit=&cursor= - earlier versions of the note,
+// most recently replaced first. Deleted notes keep their revisions.
+func (h *NoteAPIHandlers) HandleNoteRevisions(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := h.listNoteRevisions(r.Context(), userID, r.PathValue("id"), params)
+	switch {
+	case errors.Is(err, errNoteNotFound):
+		respondWithError(w, "Note not found", http.StatusNotFound)
+		return
+	case errors.Is(err, pagination.ErrInvalidCursor):
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error listing note revisions: %v", err)
+		respondWithError(w, "Failed to fetch note revisions", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(revisions, params.Limit, func(rev models.NoteRevision) pagination.Cursor {
+		return pagination.Cursor{SortValue: rev.ReplacedAt, ID: rev.ID}
+	}), http.StatusOK)
+}
+
+// HandleRestoreNote handles POST /api/notes/{id}/restore - with a revision ID, replace a live note's title
+// and content with one of its revisions. The current version is kept as a revision first, so a restore
+// can be undone. Without one (or a body), restore a deleted note from the trash.
+func (h *NoteAPIHandlers) HandleRestoreNote(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.RestoreNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	noteID := r.PathValue("id")
+	if req.RevisionID == "" {
+		h.restoreFromTrash(w, r, userID, noteID)
+		return
+	}
+	note, err := h.liveNote(ctx, userID, noteID)
+	if errors.Is(err, errNoteNotFound) {
+		respondWithError(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching note %s: %v", noteID, err)
+		respondWithError(w, "Failed to restore note", http.StatusInternalServerError)
+		return
+	}
+
+	title, content, iv, err := h.noteRevision(ctx, userID, noteID, req.RevisionID)
+	if errors.Is(err, errRevisionNotFound) {
+		respondWithError(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching revision %s of note %s: %v", req.RevisionID, noteID, err)
+		respondWithError(w, "Failed to restore note", http.StatusInternalServerError)
+		return
+	}
+
+	// The save below only takes a revision if none was taken recently, which would lose the current version
+	if err := services.SaveNoteRevisions(ctx, h.db.DB, userID, []string{noteID}, []string{title}, [][]byte{content}, true); err != nil {
+		log.Printf("Error saving revision of note %s: %v", noteID, err)
+		respondWithError(w, "Failed to restore note", http.StatusInternalServerError)
+		return
+	}
+
+	baseUpdatedAt := note.UpdatedAt
+	note.Title = title
+	note.ContentEncrypted = base64.StdEncoding.EncodeToString(content)
+	note.ContentIV = base64.StdEncoding.EncodeToString(iv)
+	note.BaseUpdatedAt, note.UpdatedAt, note.EmbeddingText = &baseUpdatedAt, time.Now(), nil
+	if h.writeNote(w, r, userID, &note) {
+		h.respondWithNote(w, r, userID, noteID, http.StatusOK)
+	}
+}
+
+// Helper functions
+
+// noteRevision returns a revision's title, encrypted content, and IV, or errRevisionNotFound
+func (h *NoteAPIHandlers) noteRevision(ctx context.Context, userID, noteID, revisionID string) (title string, content, iv []byte, err error) {
+	id, err := strconv.ParseInt(revisionID, 10, 64)
+	if err != nil {
+		return "", nil, nil, errRevisionNotFound
+	}
+	err = h.db.DB.QueryRowContext(ctx, `
+		SELECT title, content_encrypted, content_iv FROM note_revisions
+		WHERE id = $1 AND note_id = $2 AND user_id = $3
+	`, id, noteID, userID).Scan(&title, &content, &iv)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, nil, errRevisionNotFound
+	}
+	return title, content, iv, err
+}
+
+// listNoteRevisions fetches one page (plus one extra row to detect more) of a note's revisions, most
+// recently replaced first, or errNoteNotFound if the user has no such note
+func (h *NoteAPIHandlers) listNoteRevisions(ctx context.Context, userID, noteID string, params pagination.Params) ([]models.NoteRevision, error) {
+	var cursorTime *time.Time
+	var cursorID int64
+	if params.Cursor != nil {
+		id, err := strconv.ParseInt(params.Cursor.ID, 10, 64)
+		if err != nil {
+			return nil, pagination.ErrInvalidCursor
+		}
+		cursorTime, cursorID = &params.Cursor.SortValue, id
+	}
+
+	var exists bool
+	if err := h.db.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM notes WHERE id = $1 AND user_id = $2)
+	`, noteID, userID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNoteNotFound
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT id::text, title, content_encrypted, content_iv, COALESCE(device_id, ''), saved_at, created_at
+		FROM note_revisions
+		WHERE note_id = $1 AND user_id = $2
+		  AND ($4::timestamptz IS NULL OR (created_at, id) < ($4, $5::bigint))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, noteID, userID, params.Limit+1, cursorTime, cursorID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var revisions []models.NoteRevision
+	for rows.Next() {
+		var rev models.NoteRevision
+		var content, iv []byte
+		if err := rows.Scan(&rev.ID, &rev.Title, &content, &iv, &rev.DeviceID, &rev.SavedAt, &rev.ReplacedAt); err != nil {
+			return nil, err
+		}
+		rev.ContentEncrypted = base64.StdEncoding.EncodeToString(content)
+		rev.ContentIV = base64.StdEncoding.EncodeToString(iv)
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
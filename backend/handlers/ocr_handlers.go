@@ -0,0 +1,159 @@
+// HTTP handler for turning photos into notes
+package handlers
+
+import (
+	"backend/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxImageSize is the largest image accepted, uploaded or base64-encoded (phone photos are well under it)
+const maxImageSize = 20 << 20
+
+// supportedImageTypes lists the image MIME types accepted by Gemini
+var supportedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+	"image/heic": true,
+	"image/heif": true,
+}
+
+// HandleOCR handles POST /api/ocr - extract the text of a photo as markdown, uploaded as a multipart
+// "image" file or base64-encoded in a JSON body
+func (h *AIHandlers) HandleOCR(w http.ResponseWriter, r *http.Request) {
+	// Get the user's API key from the header, or their stored key
+	userApiKey := aiAPIKey(r)
+	if userApiKey == "" {
+		respondWithError(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.OCRRequest
+	var image []byte
+	var mimeType string
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		image, mimeType, err = readImageUpload(w, r)
+		req.Provider = r.FormValue("provider")
+	} else {
+		image, mimeType, err = readBase64Image(w, r, &req)
+	}
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Provider != "gemini" && req.Provider != "" {
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return
+	}
+
+	geminiService, err := userGemini(r, h.users, userApiKey)
+	if err != nil {
+		log.Printf("Error initializing Gemini service: %v", err)
+		respondWithGeminiInitError(w, err)
+		return
+	}
+	defer geminiService.Close()
+
+	content, err := geminiService.ExtractImageText(r.Context(), image, mimeType)
+	if err != nil {
+		log.Printf("Error extracting image text: %v", err)
+		respondWithAIError(w, err, "Failed to extract text from image")
+		return
+	}
+	if content == "" {
+		respondWithError(w, "No legible text found in image", http.StatusUnprocessableEntity)
+		return
+	}
+
+	respondWithJSON(w, models.OCRResponse{Content: content}, http.StatusOK)
+}
+
+// Helper functions
+
+// readImageUpload reads the "image" file from a multipart request and validates its size and format
+func readImageUpload(w http.ResponseWriter, r *http.Request) (data []byte, mimeType string, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageSize+(1<<20)) // Allow room for other form fields
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, "", fmt.Errorf("image exceeds %dMB limit", maxImageSize>>20)
+		}
+		return nil, "", errors.New("invalid multipart form")
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		return nil, "", errors.New("image file is required")
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("Error closing image upload: %v", err)
+		}
+	}()
+
+	if header.Size > maxImageSize {
+		return nil, "", fmt.Errorf("image exceeds %dMB limit", maxImageSize>>20)
+	}
+
+	data, err = io.ReadAll(file)
+	if err != nil {
+		return nil, "", errors.New("failed to read image file")
+	}
+
+	mimeType, err = imageMIMEType(header.Header.Get("Content-Type"), data)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mimeType, nil
+}
+
+// readBase64Image decodes a JSON OCR request into req and validates its image's size and format
+func readBase64Image(w http.ResponseWriter, r *http.Request, req *models.OCRRequest) (data []byte, mimeType string, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(base64.StdEncoding.EncodedLen(maxImageSize))+(1<<10))
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, "", fmt.Errorf("image exceeds %dMB limit", maxImageSize>>20)
+		}
+		return nil, "", errors.New("invalid request body")
+	}
+	if req.Image == "" {
+		return nil, "", errors.New("image is required")
+	}
+
+	data, err = base64.StdEncoding.DecodeString(req.Image)
+	if err != nil {
+		return nil, "", errors.New("image must be base64-encoded")
+	}
+	if len(data) > maxImageSize {
+		return nil, "", fmt.Errorf("image exceeds %dMB limit", maxImageSize>>20)
+	}
+
+	mimeType, err = imageMIMEType(req.MimeType, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mimeType, nil
+}
+
+// imageMIMEType returns the format of an image from its declared content type or, failing that, its
+// content, or an error if the format isn't supported. HEIC and HEIF photos must be declared.
+func imageMIMEType(declared string, data []byte) (string, error) {
+	mimeType := strings.ToLower(strings.TrimSpace(strings.Split(declared, ";")[0]))
+	if !supportedImageTypes[mimeType] {
+		mimeType = strings.Split(http.DetectContentType(data), ";")[0]
+	}
+	if !supportedImageTypes[mimeType] {
+		return "", fmt.Errorf("unsupported image format %q", mimeType)
+	}
+	return mimeType, nil
+}
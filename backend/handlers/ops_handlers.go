@@ -0,0 +1,298 @@
+// HTTP handlers for operational runbook endpoints (drain, config reload, health)
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Drain defaults
+const (
+	defaultDrainTimeout = 30 * time.Second
+	maxDrainTimeout     = 5 * time.Minute
+	drainRetryAfter     = "30" // Seconds clients should wait before retrying on another instance
+)
+
+// Health history limits
+const (
+	defaultHealthHistoryWindow = 24 * time.Hour
+	maxHealthHistoryChecks     = 10000
+)
+
+// OpsHandlers handles operational endpoints and tracks in-flight sync requests for draining
+type OpsHandlers struct {
+	db  *services.Database
+	slo *services.SLOMonitor
+
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+}
+
+// NewOpsHandlers creates a new OpsHandlers instance
+func NewOpsHandlers(db *services.Database, slo *services.SLOMonitor) *OpsHandlers {
+	return &OpsHandlers{db: db, slo: slo}
+}
+
+// TrackSync rejects new sync requests while draining and counts in-flight ones
+func (h *OpsHandlers) TrackSync(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		if h.draining {
+			h.mu.Unlock()
+			services.Debugf("Rejected %s %s while draining", r.Method, r.URL.Path)
+			w.Header().Set("Retry-After", drainRetryAfter)
+			respondWithError(w, "Server is draining, retry shortly", http.StatusServiceUnavailable)
+			return
+		}
+		h.inFlight++
+		h.mu.Unlock()
+
+		defer func() {
+			h.mu.Lock()
+			h.inFlight--
+			h.mu.Unlock()
+		}()
+		next(w, r)
+	}
+}
+
+// HandleHealth handles GET /health - reports 503 while draining so load balancers stop routing here
+func (h *OpsHandlers) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	draining := h.draining
+	h.mu.Unlock()
+
+	status, body := http.StatusOK, "OK"
+	if draining {
+		status, body = http.StatusServiceUnavailable, "DRAINING"
+	}
+
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(body)); err != nil {
+		log.Printf("Error writing health check response: %v", err)
+	}
+}
+
+// HandleDrain handles POST /api/admin/drain?timeout=30s - stop accepting new syncs and wait for in-flight ones
+func (h *OpsHandlers) HandleDrain(w http.ResponseWriter, r *http.Request) {
+	timeout := defaultDrainTimeout
+	if param := r.URL.Query().Get("timeout"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed <= 0 || parsed > maxDrainTimeout {
+			respondWithError(w, "Invalid timeout (max 5m)", http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+
+	if !h.audit(w, r, "ops.drain", map[string]interface{}{"timeout": timeout.String()}) {
+		return
+	}
+
+	h.mu.Lock()
+	h.draining = true
+	h.mu.Unlock()
+	log.Printf("Draining instance (timeout %s)", timeout)
+
+	// Wait for in-flight syncs to finish, polling so the admin request can report progress on timeout
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	remaining := h.inFlightCount()
+	for remaining > 0 && time.Now().Before(deadline) {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			remaining = h.inFlightCount()
+		}
+	}
+
+	if remaining > 0 {
+		services.Warnf("Drain timed out with %d sync requests still in flight", remaining)
+	}
+
+	respondWithJSON(w, map[string]interface{}{
+		"draining": true,
+		"drained":  remaining == 0,
+		"inFlight": remaining,
+	}, http.StatusOK)
+}
+
+// HandleResume handles POST /api/admin/resume - accept syncs again after a drain
+func (h *OpsHandlers) HandleResume(w http.ResponseWriter, r *http.Request) {
+	if !h.audit(w, r, "ops.resume", nil) {
+		return
+	}
+
+	h.mu.Lock()
+	h.draining = false
+	h.mu.Unlock()
+	log.Printf("Instance resumed accepting syncs")
+
+	respondWithJSON(w, map[string]interface{}{"draining": false}, http.StatusOK)
+}
+
+// HandleGetConfig handles GET /api/admin/config - current runtime settings
+func (h *OpsHandlers) HandleGetConfig(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, services.Config.Snapshot(), http.StatusOK)
+}
+
+// HandleReloadConfig handles POST /api/admin/config - reload the runtime settings
+func (h *OpsHandlers) HandleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if !h.audit(w, r, "ops.config_reload", nil) {
+		return
+	}
+
+	settings, err := services.Config.Reload()
+	if err != nil {
+		log.Printf("Error reloading config: %v", err)
+		respondWithError(w, "Failed to reload config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Config reloaded (log level %s, %d rate limits, %d feature flags, %d SLO targets)",
+		settings.LogLevel, len(settings.RateLimits), len(settings.FeatureFlags), len(settings.SLOTargets))
+
+	respondWithJSON(w, services.Config.Snapshot(), http.StatusOK)
+}
+
+// HandleSLO handles GET /api/admin/slo - per-route success rate and p95 latency against SLO targets
+func (h *OpsHandlers) HandleSLO(w http.ResponseWriter, r *http.Request) {
+	statuses := h.slo.Statuses()
+	if statuses == nil {
+		statuses = []services.SLOStatus{}
+	}
+	respondWithJSON(w, map[string]interface{}{"routes": statuses}, http.StatusOK)
+}
+
+// HandleHealthHistory handles GET /api/admin/health/history?since=&until=&dependency= - recorded dependency
+// checks (newest first) and the incidents they form, for correlating sync failure reports with outages
+func (h *OpsHandlers) HandleHealthHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	until := time.Now()
+	since := until.Add(-defaultHealthHistoryWindow)
+	if param := query.Get("until"); param != "" {
+		parsed, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			respondWithError(w, "Invalid until timestamp", http.StatusBadRequest)
+			return
+		}
+		until = parsed
+		since = until.Add(-defaultHealthHistoryWindow)
+	}
+	if param := query.Get("since"); param != "" {
+		parsed, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			respondWithError(w, "Invalid since timestamp", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	if !since.Before(until) {
+		respondWithError(w, "since must be before until", http.StatusBadRequest)
+		return
+	}
+
+	checks, err := h.fetchHealthChecks(r.Context(), since, until, query.Get("dependency"))
+	if err != nil {
+		log.Printf("Error fetching health history: %v", err)
+		respondWithError(w, "Failed to fetch health history", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.HealthHistoryResponse{
+		Checks:    checks,
+		Incidents: healthIncidents(checks),
+	}, http.StatusOK)
+}
+
+// Helper functions
+
+func (h *OpsHandlers) inFlightCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.inFlight
+}
+
+// audit records an admin action under the signed-in admin, responding with an error and returning false if it can't be recorded
+func (h *OpsHandlers) audit(w http.ResponseWriter, r *http.Request, action string, details map[string]interface{}) bool {
+	adminID, err := GetSessionUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if err := h.db.RecordAuditEvent(r.Context(), adminID, action, "", details); err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		respondWithError(w, "Failed to record audit event", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// fetchHealthChecks returns recorded checks in [since, until), newest first
+func (h *OpsHandlers) fetchHealthChecks(ctx context.Context, since, until time.Time, dependency string) ([]models.HealthCheckResult, error) {
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT dependency, status, latency_ms, COALESCE(error, ''), checked_at
+		FROM health_checks
+		WHERE checked_at >= $1 AND checked_at < $2 AND ($3 = '' OR dependency = $3)
+		ORDER BY checked_at DESC
+		LIMIT $4
+	`, since, until, dependency, maxHealthHistoryChecks)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	checks := []models.HealthCheckResult{}
+	for rows.Next() {
+		var check models.HealthCheckResult
+		if err := rows.Scan(&check.Dependency, &check.Status, &check.LatencyMs, &check.Error, &check.CheckedAt); err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, rows.Err()
+}
+
+// healthIncidents groups consecutive failed checks of each dependency into incidents, newest first
+func healthIncidents(checks []models.HealthCheckResult) []models.HealthIncident {
+	incidents := []models.HealthIncident{}
+	open := map[string]int{} // Dependency -> index of its incident still being extended
+
+	// Walk oldest to newest so each incident starts at its first failure
+	for i := len(checks) - 1; i >= 0; i-- {
+		check := checks[i]
+		idx, ongoing := open[check.Dependency]
+		if check.Status == models.HealthOK {
+			if ongoing {
+				end := check.CheckedAt
+				incidents[idx].End = &end
+				delete(open, check.Dependency)
+			}
+			continue
+		}
+		if !ongoing {
+			incidents = append(incidents, models.HealthIncident{Dependency: check.Dependency, Start: check.CheckedAt})
+			idx = len(incidents) - 1
+			open[check.Dependency] = idx
+		}
+		incidents[idx].Checks++
+		incidents[idx].LastError = check.Error
+	}
+
+	for i, j := 0, len(incidents)-1; i < j; i, j = i+1, j-1 {
+		incidents[i], incidents[j] = incidents[j], incidents[i]
+	}
+	return incidents
+}
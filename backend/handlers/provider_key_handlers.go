@@ -0,0 +1,512 @@
+// HTTP handlers for server-side storage and rotation of AI provider keys
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxProviderKeyLength bounds submitted API keys
+	maxProviderKeyLength = 512
+	// providerKeyHintLength is how many trailing characters of a key are kept for display and auditing
+	providerKeyHintLength = 4
+	// maskedKeyLength is the length of a masked key, in characters
+	maskedKeyLength = 12
+)
+
+// defaultAIKeyProvider is the provider whose stored key AI requests fall back to, and the default for
+// the /api/settings/ai-key endpoints
+const defaultAIKeyProvider = "gemini"
+
+// storedAPIKeyKey holds the stored key WithStoredKey loaded for a request
+const storedAPIKeyKey contextKey = "storedAPIKey"
+
+// errProviderKeyNotFound is returned when the user has no key stored for a provider
+var errProviderKeyNotFound = errors.New("provider key not found")
+
+// providerKeyRateGroup is the RATE_LIMITS group applied per user to key changes (each calls the provider)
+const providerKeyRateGroup = "provider_keys"
+
+// defaultProviderKeyRateLimit applies when RATE_LIMITS has no provider_keys entry
+var defaultProviderKeyRateLimit = services.RateLimit{Requests: 10, Window: time.Minute}
+
+// ProviderKeyHandlers handles stored provider key HTTP endpoints
+type ProviderKeyHandlers struct {
+	db      *services.Database
+	sealer  *services.Sealer
+	limiter *services.RateLimiter
+}
+
+// NewProviderKeyHandlers creates a new ProviderKeyHandlers instance. A nil sealer disables key storage.
+func NewProviderKeyHandlers(db *services.Database, sealer *services.Sealer) *ProviderKeyHandlers {
+	return &ProviderKeyHandlers{
+		db:      db,
+		sealer:  sealer,
+		limiter: services.NewRateLimiter(providerKeyRateGroup, defaultProviderKeyRateLimit),
+	}
+}
+
+// HandleListProviderKeys handles GET /api/provider-keys - the user's stored keys (hints only, never the keys)
+func (h *ProviderKeyHandlers) HandleListProviderKeys(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := h.listProviderKeys(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error listing provider keys: %v", err)
+		respondWithError(w, "Failed to list provider keys", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, keys, http.StatusOK)
+}
+
+// HandlePutProviderKey handles PUT /api/provider-keys/{provider} - store a key, or rotate the stored one.
+// The new key is checked with the provider first; the stored key is only replaced if it's accepted.
+func (h *ProviderKeyHandlers) HandlePutProviderKey(w http.ResponseWriter, r *http.Request) {
+	if key, ok := h.putProviderKey(w, r, r.PathValue("provider")); ok {
+		respondWithJSON(w, key, http.StatusOK)
+	}
+}
+
+// HandleDeleteProviderKey handles DELETE /api/provider-keys/{provider} - remove a stored key
+func (h *ProviderKeyHandlers) HandleDeleteProviderKey(w http.ResponseWriter, r *http.Request) {
+	if h.removeProviderKey(w, r, r.PathValue("provider")) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleGetAIKey handles GET /api/settings/ai-key?provider= - the user's stored AI key, masked
+func (h *ProviderKeyHandlers) HandleGetAIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key, err := h.getProviderKey(r.Context(), userID, aiKeyProvider(r))
+	if errors.Is(err, errProviderKeyNotFound) {
+		respondWithError(w, "No API key stored", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting provider key: %v", err)
+		respondWithError(w, "Failed to get API key", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, maskedAIKey(key), http.StatusOK)
+}
+
+// HandlePutAIKey handles PUT /api/settings/ai-key?provider= - store or rotate the key AI requests
+// without X-API-Key run on, like PUT /api/provider-keys/{provider}
+func (h *ProviderKeyHandlers) HandlePutAIKey(w http.ResponseWriter, r *http.Request) {
+	if key, ok := h.putProviderKey(w, r, aiKeyProvider(r)); ok {
+		respondWithJSON(w, maskedAIKey(key), http.StatusOK)
+	}
+}
+
+// HandleDeleteAIKey handles DELETE /api/settings/ai-key?provider= - remove the stored AI key
+func (h *ProviderKeyHandlers) HandleDeleteAIKey(w http.ResponseWriter, r *http.Request) {
+	if h.removeProviderKey(w, r, aiKeyProvider(r)) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// WithStoredKey lets AI routes run on the caller's stored Gemini key. A request without X-API-Key
+// from a signed-in user (see OptionalAuth) gets their stored key in its context, where aiAPIKey finds
+// it. Requests with X-API-Key, trial requests, and anonymous requests are passed through unchanged.
+func (h *ProviderKeyHandlers) WithStoredKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := GetUserID(r)
+		if err != nil || isTrialRequest(r) || h.sealer == nil || r.Header.Get("X-API-Key") != "" {
+			next(w, r)
+			return
+		}
+
+		apiKey, err := services.StoredProviderKey(r.Context(), h.db, h.sealer, userID, defaultAIKeyProvider)
+		switch {
+		case errors.Is(err, services.ErrNoStoredProviderKey):
+			next(w, r)
+		case err != nil:
+			log.Printf("Error loading stored provider key: %v", err)
+			respondWithError(w, "Failed to load the stored API key", http.StatusInternalServerError)
+		default:
+			next(w, r.WithContext(context.WithValue(r.Context(), storedAPIKeyKey, apiKey)))
+		}
+	}
+}
+
+// HandleListProviderKeyEvents handles GET /api/provider-keys/events?limit=&cursor= - the user's key
+// audit trail (stores, rotations, deletions, and rejected keys), oldest first
+func (h *ProviderKeyHandlers) HandleListProviderKeyEvents(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.listKeyEvents(r.Context(), userID, params)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing provider key events: %v", err)
+		respondWithError(w, "Failed to list provider key events", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(events, params.Limit, func(e models.ProviderKeyEvent) pagination.Cursor {
+		return pagination.Cursor{SortValue: e.CreatedAt, ID: e.ID}
+	}), http.StatusOK)
+}
+
+// Helper functions
+
+// putProviderKey validates and stores the user's key for a provider, responding with an error unless
+// it succeeds
+func (h *ProviderKeyHandlers) putProviderKey(w http.ResponseWriter, r *http.Request, provider string) (models.StoredProviderKey, bool) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return models.StoredProviderKey{}, false
+	}
+
+	if h.sealer == nil {
+		respondWithError(w, "Provider key storage is not configured", http.StatusServiceUnavailable)
+		return models.StoredProviderKey{}, false
+	}
+
+	if retryAfter, ok := h.limiter.Allow(userID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many key changes",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return models.StoredProviderKey{}, false
+	}
+
+	var req models.PutProviderKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return models.StoredProviderKey{}, false
+	}
+	req.APIKey = strings.TrimSpace(req.APIKey)
+	if req.APIKey == "" {
+		respondWithError(w, "API key is required", http.StatusBadRequest)
+		return models.StoredProviderKey{}, false
+	}
+	if len(req.APIKey) > maxProviderKeyLength {
+		respondWithError(w, "API key is too long", http.StatusBadRequest)
+		return models.StoredProviderKey{}, false
+	}
+
+	ctx := r.Context()
+	requestID := w.Header().Get(requestIDHeader)
+
+	err = services.ValidateProviderKey(ctx, provider, req.APIKey)
+	switch {
+	case errors.Is(err, services.ErrUnsupportedProvider):
+		respondWithError(w, "Unsupported provider", http.StatusBadRequest)
+		return models.StoredProviderKey{}, false
+	case errors.Is(err, services.ErrProviderKeyRejected):
+		if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+			log.Printf("Error ensuring user: %v", err)
+		}
+		if err := recordProviderKeyEvent(ctx, h.db.DB, userID, provider, models.ProviderKeyRejected, "", keyHint(req.APIKey), requestID); err != nil {
+			log.Printf("Error recording provider key event: %v", err)
+		}
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "The provider rejected this API key; the stored key was not changed",
+			Code:  models.ErrCodeProviderKeyRejected,
+		}, http.StatusUnprocessableEntity)
+		return models.StoredProviderKey{}, false
+	case err != nil:
+		log.Printf("Error validating %s key: %v", provider, err)
+		respondWithError(w, "Couldn't validate the API key with the provider. Please try again.", http.StatusBadGateway)
+		return models.StoredProviderKey{}, false
+	}
+
+	sealed, err := h.sealer.Seal(userID, []byte(req.APIKey))
+	if err != nil {
+		log.Printf("Error sealing provider key: %v", err)
+		respondWithError(w, "Failed to store API key", http.StatusInternalServerError)
+		return models.StoredProviderKey{}, false
+	}
+
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+	key, err := h.storeProviderKey(ctx, userID, provider, sealed, keyHint(req.APIKey), requestID)
+	if err != nil {
+		log.Printf("Error storing provider key: %v", err)
+		respondWithError(w, "Failed to store API key", http.StatusInternalServerError)
+		return models.StoredProviderKey{}, false
+	}
+
+	return key, true
+}
+
+// removeProviderKey deletes the user's key for a provider, responding with an error unless it succeeds
+func (h *ProviderKeyHandlers) removeProviderKey(w http.ResponseWriter, r *http.Request, provider string) bool {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	err = h.deleteProviderKey(r.Context(), userID, provider, w.Header().Get(requestIDHeader))
+	if errors.Is(err, errProviderKeyNotFound) {
+		respondWithError(w, "Provider key not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("Error deleting provider key: %v", err)
+		respondWithError(w, "Failed to delete API key", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// aiAPIKey returns the key an AI request runs on: its X-API-Key header, otherwise the stored key
+// WithStoredKey loaded, otherwise ""
+func aiAPIKey(r *http.Request) string {
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		return apiKey
+	}
+	apiKey, _ := r.Context().Value(storedAPIKeyKey).(string)
+	return apiKey
+}
+
+// aiKeyProvider returns the provider named by an /api/settings/ai-key request, defaulting to Gemini
+func aiKeyProvider(r *http.Request) string {
+	if provider := r.URL.Query().Get("provider"); provider != "" {
+		return provider
+	}
+	return defaultAIKeyProvider
+}
+
+// maskedAIKey describes a stored key as the settings endpoints show it
+func maskedAIKey(key models.StoredProviderKey) models.AIKeySetting {
+	return models.AIKeySetting{
+		StoredProviderKey: key,
+		MaskedKey:         strings.Repeat("•", maskedKeyLength-len(key.KeyHint)) + key.KeyHint,
+	}
+}
+
+// keyHint returns the last characters of a key, enough to tell keys apart without revealing them
+func keyHint(apiKey string) string {
+	if len(apiKey) <= providerKeyHintLength {
+		return ""
+	}
+	return apiKey[len(apiKey)-providerKeyHintLength:]
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordProviderKeyEvent appends an entry to the provider key audit trail
+func recordProviderKeyEvent(ctx context.Context, e execer, userID, provider, action, oldHint, newHint, requestID string) error {
+	_, err := e.ExecContext(ctx, `
+		INSERT INTO provider_key_events (user_id, provider, action, old_key_hint, new_key_hint, request_id)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''))
+	`, userID, provider, action, oldHint, newHint, requestID)
+	return err
+}
+
+// storeProviderKey saves or replaces the sealed key and records the change in one transaction
+func (h *ProviderKeyHandlers) storeProviderKey(ctx context.Context, userID, provider string, sealed []byte, hint, requestID string) (key models.StoredProviderKey, err error) {
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return key, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back provider key update: %v", rbErr)
+			}
+		}
+	}()
+
+	action := models.ProviderKeyStored
+	var oldHint string
+	err = tx.QueryRowContext(ctx, `
+		SELECT key_hint FROM provider_keys WHERE user_id = $1 AND provider = $2 FOR UPDATE
+	`, userID, provider).Scan(&oldHint)
+	switch {
+	case err == nil:
+		action = models.ProviderKeyRotated
+	case !errors.Is(err, sql.ErrNoRows):
+		return key, err
+	}
+
+	var rotatedAt sql.NullTime
+	key.Provider = provider
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO provider_keys (user_id, provider, sealed_key, key_hint)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, provider) DO UPDATE SET
+			sealed_key = EXCLUDED.sealed_key,
+			key_hint = EXCLUDED.key_hint,
+			rotated_at = CURRENT_TIMESTAMP
+		RETURNING key_hint, created_at, rotated_at
+	`, userID, provider, sealed, hint).Scan(&key.KeyHint, &key.CreatedAt, &rotatedAt)
+	if err != nil {
+		return key, err
+	}
+	if rotatedAt.Valid {
+		key.RotatedAt = &rotatedAt.Time
+	}
+
+	if err = recordProviderKeyEvent(ctx, tx, userID, provider, action, oldHint, hint, requestID); err != nil {
+		return key, err
+	}
+
+	err = tx.Commit()
+	return key, err
+}
+
+// deleteProviderKey removes a stored key and records the deletion in one transaction
+func (h *ProviderKeyHandlers) deleteProviderKey(ctx context.Context, userID, provider, requestID string) (err error) {
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back provider key deletion: %v", rbErr)
+			}
+		}
+	}()
+
+	var oldHint string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM provider_keys WHERE user_id = $1 AND provider = $2 RETURNING key_hint
+	`, userID, provider).Scan(&oldHint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errProviderKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = recordProviderKeyEvent(ctx, tx, userID, provider, models.ProviderKeyDeleted, oldHint, "", requestID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getProviderKey returns the user's stored key for a provider, or errProviderKeyNotFound
+func (h *ProviderKeyHandlers) getProviderKey(ctx context.Context, userID, provider string) (models.StoredProviderKey, error) {
+	key := models.StoredProviderKey{Provider: provider}
+	var rotatedAt sql.NullTime
+	err := h.db.DB.QueryRowContext(ctx, `
+		SELECT key_hint, created_at, rotated_at FROM provider_keys WHERE user_id = $1 AND provider = $2
+	`, userID, provider).Scan(&key.KeyHint, &key.CreatedAt, &rotatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, errProviderKeyNotFound
+	}
+	if err != nil {
+		return key, err
+	}
+	if rotatedAt.Valid {
+		key.RotatedAt = &rotatedAt.Time
+	}
+	return key, nil
+}
+
+// listProviderKeys returns the user's stored keys, by provider
+func (h *ProviderKeyHandlers) listProviderKeys(ctx context.Context, userID string) ([]models.StoredProviderKey, error) {
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT provider, key_hint, created_at, rotated_at FROM provider_keys WHERE user_id = $1 ORDER BY provider
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	keys := []models.StoredProviderKey{}
+	for rows.Next() {
+		var key models.StoredProviderKey
+		var rotatedAt sql.NullTime
+		if err := rows.Scan(&key.Provider, &key.KeyHint, &key.CreatedAt, &rotatedAt); err != nil {
+			return nil, err
+		}
+		if rotatedAt.Valid {
+			key.RotatedAt = &rotatedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// listKeyEvents fetches one page (plus one extra row to detect more) of the user's key audit trail
+func (h *ProviderKeyHandlers) listKeyEvents(ctx context.Context, userID string, params pagination.Params) ([]models.ProviderKeyEvent, error) {
+	var cursorTime *time.Time
+	var cursorID int64
+	if params.Cursor != nil {
+		id, err := strconv.ParseInt(params.Cursor.ID, 10, 64)
+		if err != nil {
+			return nil, pagination.ErrInvalidCursor
+		}
+		cursorTime, cursorID = &params.Cursor.SortValue, id
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT id::text, provider, action, COALESCE(old_key_hint, ''), COALESCE(new_key_hint, ''),
+		       COALESCE(request_id, ''), created_at
+		FROM provider_key_events
+		WHERE user_id = $1
+		  AND ($3::timestamptz IS NULL OR (created_at, id) > ($3, $4::bigint))
+		ORDER BY created_at, id
+		LIMIT $2
+	`, userID, params.Limit+1, cursorTime, cursorID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	events := []models.ProviderKeyEvent{}
+	for rows.Next() {
+		var e models.ProviderKeyEvent
+		if err := rows.Scan(&e.ID, &e.Provider, &e.Action, &e.OldKeyHint, &e.NewKeyHint, &e.RequestID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,163 @@
+// HTTP handlers for the browser extension's quick search over note titles, domains, and tags
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Quick search limits
+const (
+	defaultQuickSearchLimit = 8
+	maxQuickSearchLimit     = 20
+	maxQuickSearchLength    = 200
+)
+
+// quickSearchTimeout bounds the search query. The omnibox shows suggestions as the user types, so a
+// slow answer is useless and the query is cancelled instead.
+const quickSearchTimeout = 50 * time.Millisecond
+
+// QuickSearchHandlers handles quick search HTTP endpoints
+type QuickSearchHandlers struct {
+	db *services.Database
+}
+
+// NewQuickSearchHandlers creates a new QuickSearchHandlers instance
+func NewQuickSearchHandlers(db *services.Database) *QuickSearchHandlers {
+	return &QuickSearchHandlers{db: db}
+}
+
+// HandleQuickSearch handles GET /api/quicksearch?q=<text>&limit=<k>&includeTrashed=&includeArchived= - the
+// user's live notes whose title or domain contains (or, for titles, loosely resembles) the text, or with
+// a tag starting with it, and optionally their trashed or archived ones. Only plaintext fields are
+// searched and no AI is involved, so it's cheap enough to call on every keystroke.
+func (h *QuickSearchHandlers) HandleQuickSearch(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		respondWithError(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	if len(query) > maxQuickSearchLength {
+		respondWithError(w, "Query is too long", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultQuickSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			respondWithError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxQuickSearchLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), quickSearchTimeout)
+	defer cancel()
+
+	results, err := h.search(ctx, userID, query, limit, parseSearchScope(r))
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Quick search for user %s exceeded %v", userID, quickSearchTimeout)
+		respondWithError(w, "Search timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Error running quick search: %v", err)
+		respondWithError(w, "Failed to search notes", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.QuickSearchResponse{Results: results}, http.StatusOK)
+}
+
+// Helper functions
+
+// search returns the user's best matching notes in scope. Title and domain matches use the trigram
+// indexes; a note's score is the similarity of its best matching field, with exact tag matches ranked first.
+func (h *QuickSearchHandlers) search(ctx context.Context, userID, query string, limit int, scope searchScope) ([]models.QuickSearchResult, error) {
+	escaped := escapeLikePattern(query)
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT n.id, n.title, n.domain, to_json(n.tags), n.is_archived, n.deleted_at, n.updated_at,
+		       GREATEST(
+		           similarity(n.title, $2),
+		           COALESCE(similarity(n.domain, $2), 0),
+		           COALESCE((SELECT MAX(CASE WHEN lower(t) = lower($2) THEN 1 ELSE similarity(t, $2) END)
+		                     FROM unnest(n.tags) t WHERE t ILIKE $3), 0)
+		       ) AS score
+		FROM notes n
+		WHERE n.user_id = $1 AND (n.deleted_at IS NULL OR $6) AND (NOT n.is_archived OR $7)
+		  AND (n.title ILIKE $4 OR n.title % $2 OR n.domain ILIKE $4
+		       OR EXISTS (SELECT 1 FROM unnest(n.tags) t WHERE t ILIKE $3))
+		ORDER BY score DESC, n.updated_at DESC, n.id
+		LIMIT $5
+	`, userID, query, escaped+"%", "%"+escaped+"%", limit, scope.trashed, scope.archived)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	results := []models.QuickSearchResult{}
+	for rows.Next() {
+		var result models.QuickSearchResult
+		var domain sql.NullString
+		var tags []byte
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&result.NoteID, &result.Title, &domain, &tags, &result.Archived, &deletedAt, &result.UpdatedAt, &result.Score); err != nil {
+			return nil, err
+		}
+		result.Domain = domain.String
+		if deletedAt.Valid {
+			result.DeletedAt = &deletedAt.Time
+		}
+		if err := json.Unmarshal(tags, &result.Tags); err != nil {
+			log.Printf("Error decoding tags of note %s: %v", result.NoteID, err)
+		}
+		if result.Tags == nil {
+			result.Tags = []string{}
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// searchScope widens a search beyond live notes, so users can find notes they archived or deleted by
+// mistake before the trash is purged
+type searchScope struct {
+	trashed  bool
+	archived bool
+}
+
+// parseSearchScope reads the includeTrashed and includeArchived query parameters (default false)
+func parseSearchScope(r *http.Request) searchScope {
+	return searchScope{
+		trashed:  r.URL.Query().Get("includeTrashed") == "true",
+		archived: r.URL.Query().Get("includeArchived") == "true",
+	}
+}
+
+// likeEscaper escapes the LIKE wildcards (and the escape character itself) so user input matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLikePattern returns s with its LIKE wildcards escaped
+func escapeLikePattern(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,68 @@
+// Rate limiting middleware for route groups
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RATE_LIMITS groups applied by RateLimitMiddleware
+const (
+	AIRateGroup   = "ai"
+	SyncRateGroup = "sync"
+)
+
+// Default limits for when RATE_LIMITS has no entry for the group
+var (
+	DefaultAIRateLimit   = services.RateLimit{Requests: 30, Window: time.Minute}
+	DefaultSyncRateLimit = services.RateLimit{Requests: 120, Window: time.Minute}
+)
+
+// RateLimitMiddleware limits each caller of the wrapped routes to the RATE_LIMITS group's limit, or
+// fallback if the group isn't configured. Routes sharing a middleware share the callers' budgets.
+// Callers are identified by user ID when the request is authenticated (so it must run after
+// AuthMiddleware), otherwise by X-API-Key, otherwise by IP. Every response carries the caller's
+// X-RateLimit-Limit, X-RateLimit-Remaining, and X-RateLimit-Reset (seconds until a request frees up),
+// so clients can slow down before they're rejected; rejected requests get 429 with Retry-After.
+func RateLimitMiddleware(group string, fallback services.RateLimit) func(http.HandlerFunc) http.HandlerFunc {
+	limiter := services.NewRateLimiter(group, fallback)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			status := limiter.Take(rateLimitKey(r))
+			reset := strconv.Itoa(int(math.Ceil(status.Reset.Seconds())))
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+			w.Header().Set("X-RateLimit-Reset", reset)
+			if !status.Allowed {
+				w.Header().Set("Retry-After", reset)
+				respondWithJSON(w, models.ErrorResponse{
+					Error: "Too many requests",
+					Code:  models.ErrCodeRateLimited,
+				}, http.StatusTooManyRequests)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// Helper functions
+
+// rateLimitKey identifies the caller a request counts against. API keys are hashed so the limiter
+// doesn't keep them in memory.
+func rateLimitKey(r *http.Request) string {
+	if userID, err := GetUserID(r); err == nil {
+		return "user:" + userID
+	}
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:16])
+	}
+	return "ip:" + remoteHost(r)
+}
@@ -0,0 +1,250 @@
+// WebSocket and Server-Sent Events handler for realtime presence and change notifications
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection tuning
+const (
+	realtimeWriteWait  = 10 * time.Second
+	realtimePongWait   = 60 * time.Second
+	realtimePingPeriod = realtimePongWait * 9 / 10
+	realtimeMaxMessage = 4 << 10
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true // Same policy as the CORS config (all origins, token-authenticated)
+	},
+}
+
+// RealtimeHandlers handles the realtime WebSocket endpoint
+type RealtimeHandlers struct {
+	db  *services.Database
+	hub *services.RealtimeHub
+}
+
+// NewRealtimeHandlers creates a new RealtimeHandlers instance
+func NewRealtimeHandlers(db *services.Database, hub *services.RealtimeHub) *RealtimeHandlers {
+	return &RealtimeHandlers{db: db, hub: hub}
+}
+
+// TokenFromQuery lets WebSocket clients, which can't set headers, pass the session token as ?token=
+func TokenFromQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
+
+// HandleConnect handles GET /api/realtime - upgrade to a WebSocket carrying presence messages and
+// batched change notifications. Clients send {"type":"presence","noteId":"...","state":"viewing|editing|left"}
+// and must refresh their presence before it expires (services.PresenceTTL). Clients that send
+// Accept: text/event-stream get the server messages as Server-Sent Events instead.
+func (h *RealtimeHandlers) HandleConnect(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if wantsEventStream(r) {
+		h.streamEvents(w, r, userID)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading realtime connection: %v", err)
+		return // Upgrade already wrote an error response
+	}
+
+	deviceID := r.URL.Query().Get("deviceId")
+	client := h.hub.Register(userID, deviceID)
+
+	go h.writePump(conn, client)
+	h.readPump(conn, client)
+}
+
+// readPump processes client messages until the connection closes
+func (h *RealtimeHandlers) readPump(conn *websocket.Conn, client *services.RealtimeClient) {
+	defer func() {
+		h.hub.Unregister(client)
+		if err := conn.Close(); err != nil {
+			log.Printf("Error closing realtime connection: %v", err)
+		}
+	}()
+
+	conn.SetReadLimit(realtimeMaxMessage)
+	if err := conn.SetReadDeadline(time.Now().Add(realtimePongWait)); err != nil {
+		return
+	}
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(realtimePongWait))
+	})
+
+	for {
+		var msg models.RealtimeMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Error reading realtime message: %v", err)
+			}
+			return
+		}
+
+		switch msg.Type {
+		case models.RealtimePresence:
+			h.handlePresence(client, &msg)
+		default:
+			h.sendError(client, "Unknown message type")
+		}
+	}
+}
+
+// writePump delivers queued messages and keepalive pings until the send channel closes or the client
+// stalls. A write that blocks for realtimeWriteWait also ends the connection.
+func (h *RealtimeHandlers) writePump(conn *websocket.Conn, client *services.RealtimeClient) {
+	ticker := time.NewTicker(realtimePingPeriod)
+	defer func() {
+		ticker.Stop()
+		if err := conn.Close(); err != nil {
+			log.Printf("Error closing realtime connection: %v", err)
+		}
+	}()
+
+	for {
+		select {
+		case payload, ok := <-client.Send:
+			if err := conn.SetWriteDeadline(time.Now().Add(realtimeWriteWait)); err != nil {
+				return
+			}
+			if client.Stalled() {
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow")
+				if err := conn.WriteMessage(websocket.CloseMessage, closeMsg); err != nil {
+					log.Printf("Error writing realtime close: %v", err)
+				}
+				return
+			}
+			if !ok {
+				if err := conn.WriteMessage(websocket.CloseMessage, []byte{}); err != nil {
+					log.Printf("Error writing realtime close: %v", err)
+				}
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.SetWriteDeadline(time.Now().Add(realtimeWriteWait)); err != nil {
+				return
+			}
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamEvents delivers the client's queued messages as Server-Sent Events (named by message type)
+// until the request ends, with keepalive comments so proxies don't time out an idle stream
+func (h *RealtimeHandlers) streamEvents(w http.ResponseWriter, r *http.Request, userID string) {
+	sse, ok := newSSEWriter(w)
+	if !ok {
+		respondWithError(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client := h.hub.Register(userID, r.URL.Query().Get("deviceId"))
+	defer h.hub.Unregister(client)
+
+	ticker := time.NewTicker(realtimePingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case payload, ok := <-client.Send:
+			if !ok || client.Stalled() {
+				return
+			}
+			var msg struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				log.Printf("Error decoding realtime message: %v", err)
+				continue
+			}
+			if err := sse.send(msg.Type, json.RawMessage(payload)); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+				return
+			}
+			sse.flusher.Flush()
+		}
+	}
+}
+
+func (h *RealtimeHandlers) handlePresence(client *services.RealtimeClient, msg *models.RealtimeMessage) {
+	switch msg.State {
+	case models.PresenceViewing, models.PresenceEditing, models.PresenceLeft:
+	default:
+		h.sendError(client, "Invalid presence state")
+		return
+	}
+	if msg.NoteID == "" {
+		h.sendError(client, "Note ID is required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	allowed, err := h.canAccessNote(ctx, client.UserID, msg.NoteID)
+	if err != nil {
+		log.Printf("Error checking note access: %v", err)
+		h.sendError(client, "Failed to update presence")
+		return
+	}
+	if !allowed {
+		h.sendError(client, "Note not found")
+		return
+	}
+
+	h.hub.SetPresence(client, msg.NoteID, msg.State)
+}
+
+// canAccessNote reports whether the user may see presence on a note (currently: owns it)
+func (h *RealtimeHandlers) canAccessNote(ctx context.Context, userID, noteID string) (bool, error) {
+	var id string
+	err := h.db.DB.QueryRowContext(ctx, `SELECT id FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`, noteID, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *RealtimeHandlers) sendError(client *services.RealtimeClient, message string) {
+	payload, err := json.Marshal(models.RealtimeMessage{Type: models.RealtimeError, Error: message})
+	if err != nil {
+		return
+	}
+	h.hub.Send(client, payload)
+}
@@ -0,0 +1,24 @@
+// Region hints for multi-region deployments
+package handlers
+
+import (
+	"backend/services"
+	"net/http"
+)
+
+// regionHeader names the region that served a response
+const regionHeader = "X-Region"
+
+// RegionMiddleware adds the replica's region to every response. Clients send it back as
+// X-Preferred-Region so a load balancer can keep them on the same region, whose pull cache
+// (and realtime connections) they're already using.
+func RegionMiddleware(next http.Handler) http.Handler {
+	region := services.Region()
+	if region == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(regionHeader, region)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+// Request logging middleware
+package handlers
+
+import (
+	"backend/services"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// requestIDHeader carries the ID that ties a request's logs to client error reports
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern restricts client-supplied request IDs to 8-64 URL-safe characters
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+// RequestLogMiddleware assigns each request an ID (echoed in X-Request-ID) and logs it at debug level.
+// Only the request ID, method, redacted URL, status, and latency are logged: never headers
+// (API keys, JWTs) or bodies (note content and titles).
+func RequestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// Keep a well-formed client ID so a retried request can be traced end to end
+		requestID := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// The URL can carry secrets too (e.g. the realtime ?token=)
+		services.Debugf("[%s] %s %s %d %s", requestID, r.Method, services.Redact(r.URL.RequestURI()), status, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// newRequestID returns a random 16-byte hex request ID
+func newRequestID() string {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(idBytes)
+}
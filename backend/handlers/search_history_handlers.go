@@ -0,0 +1,113 @@
+// HTTP handlers for the search box's recent searches and suggestions
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Search history and suggestion limits
+const (
+	maxRecentSearches           = 50
+	defaultSearchSuggestLimit   = 8
+	maxSearchSuggestLimit       = 20
+	maxSearchSuggestQueryLength = 200
+)
+
+// SearchHistoryHandlers handles search history HTTP endpoints
+type SearchHistoryHandlers struct {
+	db *services.Database
+}
+
+// NewSearchHistoryHandlers creates a new SearchHistoryHandlers instance
+func NewSearchHistoryHandlers(db *services.Database) *SearchHistoryHandlers {
+	return &SearchHistoryHandlers{db: db}
+}
+
+// HandleRecentSearches handles GET /api/search/recent - the user's recent hybrid searches, most recent first
+func (h *SearchHistoryHandlers) HandleRecentSearches(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	searches, err := h.db.RecentSearches(r.Context(), userID, maxRecentSearches)
+	if err != nil {
+		log.Printf("Error fetching search history: %v", err)
+		respondWithError(w, "Failed to fetch recent searches", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.RecentSearchesResponse{Searches: searches}, http.StatusOK)
+}
+
+// HandleClearRecentSearches handles DELETE /api/search/recent - clear the user's recent searches
+func (h *SearchHistoryHandlers) HandleClearRecentSearches(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.db.ClearSearches(r.Context(), userID); err != nil {
+		log.Printf("Error clearing search history: %v", err)
+		respondWithError(w, "Failed to clear recent searches", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleSearchSuggestions handles GET /api/search/suggest?q=<prefix>&limit=<k> - completions for the
+// search box: recent searches, then tags, then note titles starting with the prefix. Like quick search
+// it's cheap enough to call on every keystroke, and gives up after the same short timeout.
+func (h *SearchHistoryHandlers) HandleSearchSuggestions(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	prefix := strings.TrimSpace(r.URL.Query().Get("q"))
+	if prefix == "" {
+		respondWithError(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	if len(prefix) > maxSearchSuggestQueryLength {
+		respondWithError(w, "Query is too long", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchSuggestLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			respondWithError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxSearchSuggestLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), quickSearchTimeout)
+	defer cancel()
+
+	suggestions, err := h.db.SearchSuggestions(ctx, userID, escapeLikePattern(prefix)+"%", limit)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Search suggestions for user %s exceeded %v", userID, quickSearchTimeout)
+		respondWithError(w, "Suggestions timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching search suggestions: %v", err)
+		respondWithError(w, "Failed to fetch suggestions", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.SearchSuggestionsResponse{Suggestions: suggestions}, http.StatusOK)
+}
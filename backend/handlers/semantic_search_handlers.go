@@ -0,0 +1,192 @@
+// HTTP handlers for semantic note search over stored embeddings
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Semantic search limits
+const (
+	defaultSemanticSearchLimit = 10
+	maxSemanticSearchLimit     = 50
+	maxSemanticQueryLength     = 2000
+	maxStaleEmbeddings         = 500
+)
+
+// semanticSearchRateGroup is the RATE_LIMITS group applied per user (each search embeds the query)
+const semanticSearchRateGroup = "semantic_search"
+
+// defaultSemanticSearchRateLimit applies when RATE_LIMITS has no semantic_search entry
+var defaultSemanticSearchRateLimit = services.RateLimit{Requests: 30, Window: time.Minute}
+
+// SemanticSearchHandlers handles semantic search HTTP endpoints
+type SemanticSearchHandlers struct {
+	db         *services.Database
+	embeddings *services.EmbeddingIndexer
+	limiter    *services.RateLimiter
+}
+
+// NewSemanticSearchHandlers creates a new SemanticSearchHandlers instance
+func NewSemanticSearchHandlers(db *services.Database, embeddings *services.EmbeddingIndexer) *SemanticSearchHandlers {
+	return &SemanticSearchHandlers{
+		db:         db,
+		embeddings: embeddings,
+		limiter:    services.NewRateLimiter(semanticSearchRateGroup, defaultSemanticSearchRateLimit),
+	}
+}
+
+// HandleSemanticSearch handles POST /api/notes/semantic-search - the user's notes closest in meaning to
+// the query, by cosine similarity of their embeddings. Trashed and archived notes are only searched
+// when the request includes them. The query is embedded with the X-API-Key header
+// if given, otherwise with the user's stored Gemini key.
+func (h *SemanticSearchHandlers) HandleSemanticSearch(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SemanticSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query == "" {
+		respondWithError(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Query) > maxSemanticQueryLength {
+		respondWithError(w, "Query is too long", http.StatusBadRequest)
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultSemanticSearchLimit
+	}
+	if req.Limit > maxSemanticSearchLimit {
+		req.Limit = maxSemanticSearchLimit
+	}
+
+	if retryAfter, ok := h.limiter.Allow(userID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many searches",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return
+	}
+
+	ctx := r.Context()
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		apiKey, err = h.embeddings.APIKey(ctx, userID)
+		if errors.Is(err, services.ErrNoStoredProviderKey) {
+			respondWithError(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading stored provider key: %v", err)
+			respondWithError(w, "Failed to search notes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	geminiService, err := services.NewUserGeminiService(ctx, h.db, userID, apiKey)
+	if err != nil {
+		log.Printf("Error initializing Gemini service: %v", err)
+		respondWithGeminiInitError(w, err)
+		return
+	}
+	defer geminiService.Close()
+
+	embedding, err := geminiService.EmbedQuery(r.Context(), req.Query)
+	if err != nil {
+		log.Printf("Error embedding search query: %v", err)
+		respondWithAIError(w, err, "Failed to search notes")
+		return
+	}
+
+	scope := searchScope{trashed: req.IncludeTrashed, archived: req.IncludeArchived}
+	results, err := h.search(ctx, userID, embedding, req.CollectionID, req.Limit, scope)
+	if err != nil {
+		log.Printf("Error searching note embeddings: %v", err)
+		respondWithError(w, "Failed to search notes", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.SemanticSearchResponse{Results: results}, http.StatusOK)
+}
+
+// HandleStaleEmbeddings handles GET /api/notes/semantic-search/stale - notes the nightly maintenance
+// found edited since they were embedded (without new embeddingText), up to 500 at a time. A note
+// leaves the list once a push re-indexes it.
+func (h *SemanticSearchHandlers) HandleStaleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	noteIDs, err := h.db.StaleEmbeddingNoteIDs(r.Context(), userID, maxStaleEmbeddings)
+	if err != nil {
+		log.Printf("Error listing stale embeddings: %v", err)
+		respondWithError(w, "Failed to list stale notes", http.StatusInternalServerError)
+		return
+	}
+	if noteIDs == nil {
+		noteIDs = []string{}
+	}
+
+	respondWithJSON(w, models.StaleEmbeddingsResponse{NoteIDs: noteIDs}, http.StatusOK)
+}
+
+// Helper functions
+
+// search returns the user's notes in scope nearest to the embedding, optionally within one collection
+func (h *SemanticSearchHandlers) search(ctx context.Context, userID string, embedding []float32, collectionID string, limit int, scope searchScope) ([]models.SemanticSearchResult, error) {
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT n.id, n.title, 1 - (e.embedding <=> $2::vector) AS score, n.is_archived, n.deleted_at, n.updated_at
+		FROM note_embeddings e
+		JOIN notes n ON n.id = e.note_id
+		WHERE e.user_id = $1 AND e.model = $3 AND NOT n.ai_excluded
+		  AND (n.deleted_at IS NULL OR $6) AND (NOT n.is_archived OR $7)
+		  AND ($4 = '' OR EXISTS (
+			SELECT 1 FROM note_collections nc WHERE nc.note_id = n.id AND nc.collection_id = $4
+		  ))
+		ORDER BY e.embedding <=> $2::vector
+		LIMIT $5
+	`, userID, services.FormatVector(embedding), services.EmbeddingModel, collectionID, limit, scope.trashed, scope.archived)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	results := []models.SemanticSearchResult{}
+	for rows.Next() {
+		var result models.SemanticSearchResult
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&result.NoteID, &result.Title, &result.Score, &result.Archived, &deletedAt, &result.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if deletedAt.Valid {
+			result.DeletedAt = &deletedAt.Time
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,300 @@
+// HTTP handlers for user and client settings endpoints
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Client settings limits
+const (
+	maxClientSettingSize  = 64 << 10 // Encrypted value size per key
+	maxClientSettingsKeys = 200
+)
+
+// clientSettingKeyPattern restricts setting keys to short, path-safe identifiers
+var clientSettingKeyPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+
+// SettingsHandlers handles settings HTTP endpoints
+type SettingsHandlers struct {
+	db *services.Database
+}
+
+// NewSettingsHandlers creates a new SettingsHandlers instance
+func NewSettingsHandlers(db *services.Database) *SettingsHandlers {
+	return &SettingsHandlers{db: db}
+}
+
+// HandleListClientSettings handles GET /api/client-settings?since=<timestamp> - fetch encrypted settings
+func (h *SettingsHandlers) HandleListClientSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var since *time.Time
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceParam)
+		if err == nil {
+			since = &parsed
+		}
+	}
+
+	settings, err := h.fetchClientSettings(r.Context(), userID, since)
+	if err != nil {
+		log.Printf("Error fetching client settings: %v", err)
+		respondWithError(w, "Failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.ClientSettingsResponse{Settings: settings, LastSync: time.Now()}, http.StatusOK)
+}
+
+// HandlePutClientSetting handles PUT /api/client-settings/{key} - write a setting. Writes carry the
+// version the client last saw; a stale version returns 409 with the current value.
+func (h *SettingsHandlers) HandlePutClientSetting(w http.ResponseWriter, r *http.Request) {
+	userID, key, ok := requestedSettingKey(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.PutClientSettingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding client setting request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	value, err := base64.StdEncoding.DecodeString(req.ValueEncrypted)
+	if err != nil || len(value) == 0 {
+		respondWithError(w, "Invalid encrypted value", http.StatusBadRequest)
+		return
+	}
+	iv, err := base64.StdEncoding.DecodeString(req.ValueIV)
+	if err != nil || len(iv) == 0 {
+		respondWithError(w, "Invalid IV", http.StatusBadRequest)
+		return
+	}
+	if len(value) > maxClientSettingSize {
+		respondWithError(w, "Setting value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	h.saveClientSetting(w, r, userID, key, value, iv, req.BaseVersion)
+}
+
+// HandleDeleteClientSetting handles DELETE /api/client-settings/{key}?baseVersion= - remove a setting.
+// With baseVersion, a stale version returns 409 with the current value.
+func (h *SettingsHandlers) HandleDeleteClientSetting(w http.ResponseWriter, r *http.Request) {
+	userID, key, ok := requestedSettingKey(w, r)
+	if !ok {
+		return
+	}
+
+	var baseVersion *int64
+	if version, err := strconv.ParseInt(r.URL.Query().Get("baseVersion"), 10, 64); err == nil {
+		baseVersion = &version
+	}
+
+	h.saveClientSetting(w, r, userID, key, nil, nil, baseVersion)
+}
+
+// Helper functions
+
+var errTooManySettings = errors.New("too many client settings")
+
+// requestedSettingKey returns the signed-in user and the {key} path value. If there's no user or the
+// key is invalid it responds with the error and returns false.
+func requestedSettingKey(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	key := r.PathValue("key")
+	if !clientSettingKeyPattern.MatchString(key) {
+		respondWithError(w, "Invalid setting key", http.StatusBadRequest)
+		return "", "", false
+	}
+	return userID, key, true
+}
+
+// saveClientSetting writes a setting (or deletes it, for a nil value) and responds with the result
+func (h *SettingsHandlers) saveClientSetting(w http.ResponseWriter, r *http.Request, userID, key string, value, iv []byte, baseVersion *int64) {
+	ctx := r.Context()
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+
+	setting, err := h.writeClientSetting(ctx, userID, key, value, iv, baseVersion)
+	var conflict *settingConflictError
+	switch {
+	case errors.As(err, &conflict):
+		respondWithJSON(w, models.ClientSettingConflictResponse{
+			Error:   "Setting was modified by another device",
+			Current: conflict.current,
+		}, http.StatusConflict)
+		return
+	case errors.Is(err, errTooManySettings):
+		respondWithError(w, "Too many settings", http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		log.Printf("Error writing client setting %s: %v", key, err)
+		respondWithError(w, "Failed to save setting", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, setting, http.StatusOK)
+}
+
+// settingConflictError carries the current server value when a write used a stale base version
+type settingConflictError struct {
+	current models.ClientSetting
+}
+
+func (e *settingConflictError) Error() string {
+	return "client setting version conflict"
+}
+
+func (h *SettingsHandlers) fetchClientSettings(ctx context.Context, userID string, since *time.Time) ([]models.ClientSetting, error) {
+	query := `
+		SELECT key, value_encrypted, value_iv, version, updated_at, deleted_at
+		FROM client_settings
+		WHERE user_id = $1 AND deleted_at IS NULL
+		ORDER BY key
+	`
+	args := []interface{}{userID}
+	if since != nil {
+		query = `
+			SELECT key, value_encrypted, value_iv, version, updated_at, deleted_at
+			FROM client_settings
+			WHERE user_id = $1 AND updated_at >= $2
+			ORDER BY key
+		`
+		args = append(args, *since)
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	settings := []models.ClientSetting{}
+	for rows.Next() {
+		setting, err := scanClientSetting(rows)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, setting)
+	}
+	return settings, rows.Err()
+}
+
+// writeClientSetting upserts (or, with a nil value, soft-deletes) a setting if baseVersion matches
+func (h *SettingsHandlers) writeClientSetting(ctx context.Context, userID, key string, value, iv []byte, baseVersion *int64) (setting models.ClientSetting, err error) {
+	tx, err := h.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return setting, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back client setting write: %v", rbErr)
+			}
+		}
+	}()
+
+	current, err := scanClientSetting(tx.QueryRowContext(ctx, `
+		SELECT key, value_encrypted, value_iv, version, updated_at, deleted_at
+		FROM client_settings WHERE user_id = $1 AND key = $2 FOR UPDATE
+	`, userID, key))
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return setting, err
+	}
+
+	// A write must be based on the current version; creating requires that no live value exists
+	switch {
+	case exists && current.DeletedAt == nil && (baseVersion == nil || *baseVersion != current.Version):
+		return setting, &settingConflictError{current: current}
+	case exists && current.DeletedAt != nil && baseVersion != nil && *baseVersion != current.Version:
+		return setting, &settingConflictError{current: current}
+	case !exists && value == nil:
+		return setting, &settingConflictError{current: models.ClientSetting{Key: key}}
+	}
+
+	if !exists {
+		var count int
+		if err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM client_settings WHERE user_id = $1 AND deleted_at IS NULL`, userID).Scan(&count); err != nil {
+			return setting, err
+		}
+		if count >= maxClientSettingsKeys {
+			return setting, errTooManySettings
+		}
+	}
+
+	if value == nil {
+		setting, err = scanClientSetting(tx.QueryRowContext(ctx, `
+			UPDATE client_settings
+			SET value_encrypted = '', value_iv = '', version = version + 1,
+			    updated_at = CURRENT_TIMESTAMP, deleted_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND key = $2
+			RETURNING key, value_encrypted, value_iv, version, updated_at, deleted_at
+		`, userID, key))
+	} else {
+		setting, err = scanClientSetting(tx.QueryRowContext(ctx, `
+			INSERT INTO client_settings (user_id, key, value_encrypted, value_iv, version, updated_at)
+			VALUES ($1, $2, $3, $4, 1, CURRENT_TIMESTAMP)
+			ON CONFLICT (user_id, key) DO UPDATE SET
+				value_encrypted = EXCLUDED.value_encrypted,
+				value_iv = EXCLUDED.value_iv,
+				version = client_settings.version + 1,
+				updated_at = CURRENT_TIMESTAMP,
+				deleted_at = NULL
+			RETURNING key, value_encrypted, value_iv, version, updated_at, deleted_at
+		`, userID, key, value, iv))
+	}
+	if err != nil {
+		return setting, err
+	}
+
+	err = tx.Commit()
+	return setting, err
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClientSetting(row rowScanner) (models.ClientSetting, error) {
+	var setting models.ClientSetting
+	var value, iv []byte
+	var deletedAt sql.NullTime
+	if err := row.Scan(&setting.Key, &value, &iv, &setting.Version, &setting.UpdatedAt, &deletedAt); err != nil {
+		return setting, err
+	}
+	setting.ValueEncrypted = base64.StdEncoding.EncodeToString(value)
+	setting.ValueIV = base64.StdEncoding.EncodeToString(iv)
+	if deletedAt.Valid {
+		setting.DeletedAt = &deletedAt.Time
+	}
+	return setting, nil
+}
@@ -0,0 +1,459 @@
+// HTTP handlers for public share links to notes
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Share link limits
+const (
+	maxShareBodySize      = 5 << 20
+	maxSharePasswordRunes = 256
+	maxShareViews         = 1000000
+	// maxShareAnalyticsDays bounds the range of a share link's analytics
+	maxShareAnalyticsDays = 366
+	// maxShareReferrers is how many referring sites analytics list
+	maxShareReferrers = 20
+	// maxShareReferrerHost is the longest referring host stored (a DNS name's limit)
+	maxShareReferrerHost = 253
+	// shareReferrerHeader carries the page that referred a viewer (the web client's document.referrer),
+	// since the request itself comes from the web client
+	shareReferrerHeader = "X-Share-Referrer"
+)
+
+// shareTokenPattern matches the tokens share links are created with (32 random bytes, base64url)
+var shareTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// ShareHandlers handles note sharing HTTP endpoints
+type ShareHandlers struct {
+	shares services.ShareRepository
+	guard  *services.PublicTokenGuard
+}
+
+// NewShareHandlers creates a new ShareHandlers instance
+func NewShareHandlers(shares services.ShareRepository, guard *services.PublicTokenGuard) *ShareHandlers {
+	return &ShareHandlers{shares: shares, guard: guard}
+}
+
+// HandleListShareLinks handles GET /api/notes/{id}/share, listing the note's live links
+func (h *ShareHandlers) HandleListShareLinks(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	noteID := r.PathValue("id")
+	if err := h.checkNote(ctx, userID, noteID); err != nil {
+		if errors.Is(err, errNoteNotFound) {
+			respondWithError(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching note %s: %v", noteID, err)
+		respondWithError(w, "Failed to fetch share links", http.StatusInternalServerError)
+		return
+	}
+
+	links, err := h.shares.ShareLinks(ctx, userID, noteID)
+	if err != nil {
+		log.Printf("Error fetching share links of note %s: %v", noteID, err)
+		respondWithError(w, "Failed to fetch share links", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, links, http.StatusOK)
+}
+
+// HandleCreateShareLink handles POST /api/notes/{id}/share, creating a link to the note and returning
+// its token (shown only this once)
+func (h *ShareHandlers) HandleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateShareLinkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxShareBodySize)).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, fmt.Sprintf("Shared note exceeds %dMB limit", maxShareBodySize>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	content, err := base64.StdEncoding.DecodeString(req.ContentEncrypted)
+	if err != nil || len(content) == 0 {
+		respondWithError(w, "contentEncrypted must be non-empty base64", http.StatusBadRequest)
+		return
+	}
+	iv, err := base64.StdEncoding.DecodeString(req.ContentIV)
+	if err != nil || len(iv) == 0 {
+		respondWithError(w, "contentIV must be non-empty base64", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		respondWithError(w, "expiresAt must be in the future", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Password) > maxSharePasswordRunes {
+		respondWithError(w, fmt.Sprintf("password can be at most %d characters", maxSharePasswordRunes), http.StatusBadRequest)
+		return
+	}
+	if req.MaxViews != nil && (*req.MaxViews < 1 || *req.MaxViews > maxShareViews) {
+		respondWithError(w, fmt.Sprintf("maxViews must be between 1 and %d", maxShareViews), http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash *string
+	if req.Password != "" {
+		hash, err := services.HashPassword(req.Password)
+		if err != nil {
+			log.Printf("Error hashing share password: %v", err)
+			respondWithError(w, "Failed to create share link", http.StatusInternalServerError)
+			return
+		}
+		passwordHash = &hash
+	}
+
+	tokenBytes := make([]byte, 32)
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		log.Printf("Error generating share token: %v", err)
+		respondWithError(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	if _, err := rand.Read(idBytes); err != nil {
+		log.Printf("Error generating share link ID: %v", err)
+		respondWithError(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	noteID := r.PathValue("id")
+	link, err := h.shares.CreateShareLink(r.Context(), services.NewShareLink{
+		ID:           "shr_" + hex.EncodeToString(idBytes),
+		UserID:       userID,
+		NoteID:       noteID,
+		TokenHash:    hashShareToken(token),
+		Content:      content,
+		IV:           iv,
+		PasswordHash: passwordHash,
+		ExpiresAt:    req.ExpiresAt,
+		MaxViews:     req.MaxViews,
+		DeviceID:     r.Header.Get("X-Device-ID"),
+	})
+	if errors.Is(err, services.ErrShareNoteNotFound) {
+		respondWithError(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating share link for note %s: %v", noteID, err)
+		respondWithError(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+
+	link.Token = token
+	respondWithJSON(w, link, http.StatusCreated)
+}
+
+// HandleRevokeShareLinks handles DELETE /api/notes/{id}/share, revoking all of the note's links
+func (h *ShareHandlers) HandleRevokeShareLinks(w http.ResponseWriter, r *http.Request) {
+	h.revokeShareLinks(w, r, "")
+}
+
+// HandleRevokeShareLink handles DELETE /api/notes/{id}/share/{shareId}, revoking one link
+func (h *ShareHandlers) HandleRevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	h.revokeShareLinks(w, r, r.PathValue("shareId"))
+}
+
+// HandleShareLinkAnalytics handles GET /api/notes/{id}/share/{shareId}/analytics?since=&until= - the
+// link's views per UTC day and by referring site for the days in [since, until] (default the last 30
+// days). Revoked links are included, so their history stays available.
+func (h *ShareHandlers) HandleShareLinkAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	until := time.Now().UTC().Truncate(24 * time.Hour)
+	if param := query.Get("until"); param != "" {
+		parsed, err := time.Parse(time.DateOnly, param)
+		if err != nil {
+			respondWithError(w, "Invalid until date (expected YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		until = parsed
+	}
+	since := until.AddDate(0, 0, -29)
+	if param := query.Get("since"); param != "" {
+		parsed, err := time.Parse(time.DateOnly, param)
+		if err != nil {
+			respondWithError(w, "Invalid since date (expected YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	if since.After(until) {
+		respondWithError(w, "since must not be after until", http.StatusBadRequest)
+		return
+	}
+	if until.Sub(since) >= maxShareAnalyticsDays*24*time.Hour {
+		respondWithError(w, fmt.Sprintf("Analytics range can be at most %d days", maxShareAnalyticsDays), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	noteID, shareID := r.PathValue("id"), r.PathValue("shareId")
+	link, err := h.shares.ShareLink(ctx, userID, noteID, shareID)
+	if errors.Is(err, services.ErrShareLinkNotFound) {
+		respondWithError(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching share link %s: %v", shareID, err)
+		respondWithError(w, "Failed to fetch share link analytics", http.StatusInternalServerError)
+		return
+	}
+
+	analytics, err := h.fetchShareAnalytics(ctx, link, since, until)
+	if err != nil {
+		log.Printf("Error fetching analytics of share link %s: %v", shareID, err)
+		respondWithError(w, "Failed to fetch share link analytics", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, analytics, http.StatusOK)
+}
+
+// HandleSharedNote handles GET /share/{token} - serve a shared note to anyone with the link (public).
+// Password-protected links need the password in the X-Share-Password header, and links with a view limit
+// stop working once it's reached. The content is the copy encrypted when the link was created; the web
+// client decrypts it with the key in the URL fragment.
+func (h *ShareHandlers) HandleSharedNote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	token := r.PathValue("token")
+	if !shareTokenPattern.MatchString(token) {
+		respondWithError(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	tokenHash := hashShareToken(token)
+
+	// Rate limit before any database work, which also bounds password guessing
+	if retryAfter, ok := h.guard.Allow(tokenHash); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many requests for this link",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return
+	}
+
+	link, err := h.shares.SharedNoteByToken(r.Context(), tokenHash)
+	if errors.Is(err, services.ErrShareLinkNotFound) {
+		respondWithError(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching share link: %v", err)
+		respondWithError(w, "Failed to fetch shared note", http.StatusInternalServerError)
+		return
+	}
+	if link.Revoked || link.NoteDeleted {
+		respondWithError(w, "This link has been revoked", http.StatusGone)
+		return
+	}
+	if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+		respondWithError(w, "This link has expired", http.StatusGone)
+		return
+	}
+
+	if link.LimitReached {
+		respondWithViewLimitReached(w)
+		return
+	}
+	if link.PasswordHash != "" && !checkSharePassword(w, r, link.ID, link.PasswordHash) {
+		return
+	}
+
+	// Views are only counted once the password is checked, so wrong guesses don't use up a limit
+	if !h.recordShareView(w, r, link.ID, link.Limited) {
+		return
+	}
+
+	shared := link.Note
+	shared.ContentEncrypted = base64.StdEncoding.EncodeToString(link.Content)
+	shared.ContentIV = base64.StdEncoding.EncodeToString(link.IV)
+	shared.ExpiresAt = link.ExpiresAt
+	respondWithJSON(w, shared, http.StatusOK)
+}
+
+// Helper functions
+
+// recordShareView counts a view of the link. If the view limit was reached meanwhile, or the view of a
+// limited link can't be counted, it responds with the error and returns false; views of unlimited links
+// are served even if counting fails.
+func (h *ShareHandlers) recordShareView(w http.ResponseWriter, r *http.Request, shareID string, limited bool) bool {
+	counted, err := h.shares.CountShareView(r.Context(), shareID, shareReferrerHost(r.Header.Get(shareReferrerHeader)))
+	if err != nil {
+		log.Printf("Error counting view of share link %s: %v", shareID, err)
+		if limited {
+			respondWithError(w, "Failed to fetch shared note", http.StatusInternalServerError)
+			return false
+		}
+		return true
+	}
+	if !counted {
+		respondWithViewLimitReached(w) // Another viewer took the last view meanwhile
+		return false
+	}
+	return true
+}
+
+// fetchShareAnalytics returns a link's views per day (every day in [since, until], oldest first) and by
+// referring host (most views first) over that range
+func (h *ShareHandlers) fetchShareAnalytics(ctx context.Context, link models.ShareLink, since, until time.Time) (models.ShareLinkAnalytics, error) {
+	analytics := models.ShareLinkAnalytics{
+		ShareLink: link,
+		Since:     since.Format(time.DateOnly),
+		Until:     until.Format(time.DateOnly),
+		Daily:     []models.ShareLinkDayViews{},
+		Referrers: []models.ShareLinkReferrers{},
+	}
+	end := until.AddDate(0, 0, 1)
+
+	viewsByDay, err := h.shares.ShareViewsByDay(ctx, link.ID, since, end)
+	if err != nil {
+		return analytics, err
+	}
+	for day := since; day.Before(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		analytics.Daily = append(analytics.Daily, models.ShareLinkDayViews{Date: date, Views: viewsByDay[date]})
+	}
+
+	referrers, err := h.shares.ShareReferrers(ctx, link.ID, since, end, maxShareReferrers)
+	if err != nil {
+		return analytics, err
+	}
+	analytics.Referrers = referrers
+	return analytics, nil
+}
+
+// revokeShareLinks revokes one of a note's links, or all of them if shareID is empty
+func (h *ShareHandlers) revokeShareLinks(w http.ResponseWriter, r *http.Request, shareID string) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	noteID := r.PathValue("id")
+	if err := h.checkNote(ctx, userID, noteID); err != nil {
+		if errors.Is(err, errNoteNotFound) {
+			respondWithError(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching note %s: %v", noteID, err)
+		respondWithError(w, "Failed to revoke share link", http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := h.shares.RevokeShareLinks(ctx, userID, noteID, shareID, r.Header.Get("X-Device-ID"))
+	if err != nil {
+		log.Printf("Error revoking share links of note %s: %v", noteID, err)
+		respondWithError(w, "Failed to revoke share link", http.StatusInternalServerError)
+		return
+	}
+	if revoked == 0 && shareID != "" {
+		respondWithError(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkNote returns errNoteNotFound unless the note exists for the user (deleted notes included, so
+// their links can still be listed and revoked)
+func (h *ShareHandlers) checkNote(ctx context.Context, userID, noteID string) error {
+	exists, err := h.shares.HasNote(ctx, userID, noteID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errNoteNotFound
+	}
+	return nil
+}
+
+// checkSharePassword checks the password sent for a protected link. If it's missing or wrong (or can't
+// be checked) it responds with the error and returns false.
+func checkSharePassword(w http.ResponseWriter, r *http.Request, shareID, passwordHash string) bool {
+	password := r.Header.Get("X-Share-Password")
+	if password == "" {
+		respondWithJSON(w, models.ErrorResponse{Error: "Password required", Code: models.ErrCodePasswordRequired}, http.StatusUnauthorized)
+		return false
+	}
+	ok, err := services.CheckPassword(password, passwordHash)
+	if err != nil {
+		log.Printf("Error checking password of share link %s: %v", shareID, err)
+		respondWithError(w, "Failed to fetch shared note", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		respondWithJSON(w, models.ErrorResponse{Error: "Incorrect password", Code: models.ErrCodePasswordInvalid}, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// respondWithViewLimitReached responds that a link can't be viewed any more times
+func respondWithViewLimitReached(w http.ResponseWriter) {
+	respondWithJSON(w, models.ErrorResponse{Error: "This link has reached its view limit", Code: models.ErrCodeViewLimitReached}, http.StatusGone)
+}
+
+// shareReferrerHost returns the lowercase host of an http(s) referrer URL, or "" for anything else (a
+// direct visit)
+func shareReferrerHost(referrer string) string {
+	parsed, err := url.Parse(strings.TrimSpace(referrer))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return ""
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if len(host) > maxShareReferrerHost {
+		return ""
+	}
+	return host
+}
+
+// hashShareToken returns the form a share token is stored and looked up in
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
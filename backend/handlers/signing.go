@@ -0,0 +1,239 @@
+// Optional HMAC request signing for sync endpoints
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request signing headers
+const (
+	signatureKeyHeader       = "X-Signature-Key-ID"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+	signatureHeader          = "X-Signature"
+)
+
+// signatureWindow is how far a signed request's timestamp may drift from server time
+const signatureWindow = 5 * time.Minute
+
+// SigningHandlers manages signing keys and verifies signed sync requests
+type SigningHandlers struct {
+	db *services.Database
+
+	mu   sync.Mutex
+	seen map[string]time.Time // Signatures accepted within the replay window
+}
+
+// NewSigningHandlers creates a new SigningHandlers instance
+func NewSigningHandlers(db *services.Database) *SigningHandlers {
+	return &SigningHandlers{db: db, seen: make(map[string]time.Time)}
+}
+
+// HandleCreateKey handles POST /api/sync/signing-keys - register a signing key for this device.
+// Once a user has an active key, every request to a signed route must carry a valid signature, including
+// registering another key, so a stolen session token can't enroll a key of its own.
+func (h *SigningHandlers) HandleCreateKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateSigningKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding signing key request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	secret := make([]byte, 32)
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Error generating signing secret: %v", err)
+		respondWithError(w, "Failed to create signing key", http.StatusInternalServerError)
+		return
+	}
+	if _, err := rand.Read(idBytes); err != nil {
+		log.Printf("Error generating signing key ID: %v", err)
+		respondWithError(w, "Failed to create signing key", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+
+	key := models.SigningKeyResponse{
+		ID:        "sk_" + hex.EncodeToString(idBytes),
+		Secret:    base64.StdEncoding.EncodeToString(secret),
+		Label:     req.Label,
+		CreatedAt: time.Now(),
+	}
+	query := `INSERT INTO signing_keys (id, user_id, secret, label, created_at) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := h.db.DB.ExecContext(ctx, query, key.ID, userID, secret, req.Label, key.CreatedAt); err != nil {
+		log.Printf("Error storing signing key: %v", err)
+		respondWithError(w, "Failed to create signing key", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, key, http.StatusCreated)
+}
+
+// HandleRevokeKey handles DELETE /api/sync/signing-keys/{id} - revoke a signing key
+func (h *SigningHandlers) HandleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := `UPDATE signing_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := h.db.DB.ExecContext(r.Context(), query, r.PathValue("id"), userID)
+	if err != nil {
+		log.Printf("Error revoking signing key: %v", err)
+		respondWithError(w, "Failed to revoke signing key", http.StatusInternalServerError)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		respondWithError(w, "Signing key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifySignature enforces request signing for users who registered a signing key.
+// Must run inside AuthMiddleware. The signature is hex(HMAC-SHA256(secret, canonical request)) where
+// the canonical request is "timestamp\nMETHOD\npath?query\nhex(sha256(body))".
+func (h *SigningHandlers) VerifySignature(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := GetUserID(r)
+		if err != nil {
+			respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		keys, err := h.activeKeys(ctx, userID)
+		if err != nil {
+			log.Printf("Error fetching signing keys: %v", err)
+			respondWithError(w, "Failed to verify request signature", http.StatusInternalServerError)
+			return
+		}
+		if len(keys) == 0 {
+			next(w, r) // Signing not enabled for this user
+			return
+		}
+
+		keyID := r.Header.Get(signatureKeyHeader)
+		secret, ok := keys[keyID]
+		if !ok {
+			respondWithError(w, "Request signature required", http.StatusUnauthorized)
+			return
+		}
+
+		timestamp, err := strconv.ParseInt(r.Header.Get(signatureTimestampHeader), 10, 64)
+		if err != nil {
+			respondWithError(w, "Invalid signature timestamp", http.StatusUnauthorized)
+			return
+		}
+		if drift := time.Since(time.Unix(timestamp, 0)); drift > signatureWindow || drift < -signatureWindow {
+			respondWithError(w, "Signature timestamp outside allowed window", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			respondWithError(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		signature := r.Header.Get(signatureHeader)
+		if !hmac.Equal([]byte(signature), []byte(signRequest(secret, timestamp, r, body))) {
+			respondWithError(w, "Invalid request signature", http.StatusUnauthorized)
+			return
+		}
+		if !h.markSeen(signature) {
+			respondWithError(w, "Request replay detected", http.StatusUnauthorized)
+			return
+		}
+
+		if _, err := h.db.DB.ExecContext(ctx, `UPDATE signing_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, keyID); err != nil {
+			log.Printf("Error updating signing key usage: %v", err)
+		}
+
+		next(w, r)
+	}
+}
+
+// Helper functions
+
+// signRequest computes the expected hex signature of a request
+func signRequest(secret []byte, timestamp int64, r *http.Request, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	canonical := strconv.FormatInt(timestamp, 10) + "\n" + r.Method + "\n" + r.URL.RequestURI() + "\n" + hex.EncodeToString(bodyHash[:])
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// markSeen records a signature, returning false if it was already used within the replay window
+func (h *SigningHandlers) markSeen(signature string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for sig, expiresAt := range h.seen {
+		if now.After(expiresAt) {
+			delete(h.seen, sig)
+		}
+	}
+
+	if _, ok := h.seen[signature]; ok {
+		return false
+	}
+	// A timestamp can be up to one window in the future, so keep entries for two windows
+	h.seen[signature] = now.Add(2 * signatureWindow)
+	return true
+}
+
+func (h *SigningHandlers) activeKeys(ctx context.Context, userID string) (map[string][]byte, error) {
+	rows, err := h.db.DB.QueryContext(ctx, `SELECT id, secret FROM signing_keys WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	keys := make(map[string][]byte)
+	for rows.Next() {
+		var id string
+		var secret []byte
+		if err := rows.Scan(&id, &secret); err != nil {
+			return nil, err
+		}
+		keys[id] = secret
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,243 @@
+// HTTP handlers for the staged notes inbox
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// errStagedNoteClaimed is returned when another device holds a live claim on a staged note
+var errStagedNoteClaimed = errors.New("staged note is claimed by another device")
+
+// stagedNoteColumns are the columns read by scanStagedNote
+const stagedNoteColumns = `id, source, COALESCE(source_ref, ''), title, content, COALESCE(collection_name, ''),
+	is_duplicate, original_date, COALESCE(claimed_by_device, ''), claimed_until, created_at, expires_at, to_json(tags)`
+
+// StagedNoteHandlers handles staged notes HTTP endpoints
+type StagedNoteHandlers struct {
+	db *services.Database
+}
+
+// NewStagedNoteHandlers creates a new StagedNoteHandlers instance
+func NewStagedNoteHandlers(db *services.Database) *StagedNoteHandlers {
+	return &StagedNoteHandlers{db: db}
+}
+
+// HandleListStagedNotes handles GET /api/staged-notes?limit=&cursor= - list staged notes, oldest first
+func (h *StagedNoteHandlers) HandleListStagedNotes(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notes, err := h.listStagedNotes(r.Context(), userID, params)
+	if err != nil {
+		log.Printf("Error listing staged notes: %v", err)
+		respondWithError(w, "Failed to list staged notes", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(notes, params.Limit, func(n models.StagedNote) pagination.Cursor {
+		return pagination.Cursor{SortValue: n.CreatedAt, ID: n.ID}
+	}), http.StatusOK)
+}
+
+// HandleClaimStagedNote handles POST /api/staged-notes/{id}/claim - claim a staged note for encryption.
+// The claim keeps other devices from importing the same item twice and expires after StagedClaimTTL.
+func (h *StagedNoteHandlers) HandleClaimStagedNote(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		log.Printf("Error generating claim token: %v", err)
+		respondWithError(w, "Failed to claim staged note", http.StatusInternalServerError)
+		return
+	}
+	claimToken := hex.EncodeToString(tokenBytes)
+
+	note, err := h.claimStagedNote(r.Context(), userID, r.PathValue("id"), r.Header.Get("X-Device-ID"), claimToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Staged note not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errStagedNoteClaimed) {
+		respondWithError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error claiming staged note: %v", err)
+		respondWithError(w, "Failed to claim staged note", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.ClaimStagedNoteResponse{StagedNote: note, ClaimToken: claimToken}, http.StatusOK)
+}
+
+// HandleDeleteStagedNote handles DELETE /api/staged-notes/{id}?claimToken= - remove the staging copy
+// once the encrypted note has been pushed, or discard an unclaimed item
+func (h *StagedNoteHandlers) HandleDeleteStagedNote(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err = h.deleteStagedNote(r.Context(), userID, r.PathValue("id"), r.URL.Query().Get("claimToken"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, "Staged note not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errStagedNoteClaimed) {
+		respondWithError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting staged note: %v", err)
+		respondWithError(w, "Failed to delete staged note", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Helper functions
+
+func (h *StagedNoteHandlers) listStagedNotes(ctx context.Context, userID string, params pagination.Params) ([]models.StagedNote, error) {
+	// Expired plaintext is dropped rather than shown
+	if _, err := h.db.DB.ExecContext(ctx, `DELETE FROM staged_notes WHERE user_id = $1 AND expires_at < CURRENT_TIMESTAMP`, userID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + stagedNoteColumns + `
+		FROM staged_notes
+		WHERE user_id = $1
+		ORDER BY created_at, id
+		LIMIT $2
+	`
+	args := []interface{}{userID, params.Limit + 1}
+	if params.Cursor != nil {
+		query = `
+			SELECT ` + stagedNoteColumns + `
+			FROM staged_notes
+			WHERE user_id = $1 AND (created_at, id) > ($3, $4)
+			ORDER BY created_at, id
+			LIMIT $2
+		`
+		args = append(args, params.Cursor.SortValue, params.Cursor.ID)
+	}
+
+	rows, err := h.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var notes []models.StagedNote
+	for rows.Next() {
+		note, err := scanStagedNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// claimStagedNote takes the claim on a staged note unless another device holds a live one.
+// The same device may re-claim to extend its claim.
+func (h *StagedNoteHandlers) claimStagedNote(ctx context.Context, userID, id, deviceID, claimToken string) (models.StagedNote, error) {
+	query := `
+		UPDATE staged_notes
+		SET claim_token = $3, claimed_by_device = $4, claimed_until = CURRENT_TIMESTAMP + $5 * INTERVAL '1 second'
+		WHERE id = $1 AND user_id = $2 AND expires_at > CURRENT_TIMESTAMP
+		  AND (claimed_until IS NULL OR claimed_until < CURRENT_TIMESTAMP OR ($4 <> '' AND claimed_by_device = $4))
+		RETURNING ` + stagedNoteColumns
+
+	note, err := scanStagedNote(h.db.DB.QueryRowContext(ctx, query, id, userID, claimToken, deviceID, int(services.StagedClaimTTL.Seconds())))
+	if errors.Is(err, sql.ErrNoRows) {
+		return note, h.stagedNoteMissOrClaimed(ctx, userID, id)
+	}
+	return note, err
+}
+
+// deleteStagedNote deletes a staged note that is unclaimed or claimed with claimToken
+func (h *StagedNoteHandlers) deleteStagedNote(ctx context.Context, userID, id, claimToken string) error {
+	query := `
+		DELETE FROM staged_notes
+		WHERE id = $1 AND user_id = $2
+		  AND (claimed_until IS NULL OR claimed_until < CURRENT_TIMESTAMP OR claim_token = $3)
+	`
+	result, err := h.db.DB.ExecContext(ctx, query, id, userID, claimToken)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return h.stagedNoteMissOrClaimed(ctx, userID, id)
+	}
+	return nil
+}
+
+// stagedNoteMissOrClaimed explains why a conditional update matched nothing:
+// sql.ErrNoRows if the note doesn't exist (or expired), errStagedNoteClaimed otherwise
+func (h *StagedNoteHandlers) stagedNoteMissOrClaimed(ctx context.Context, userID, id string) error {
+	var exists bool
+	err := h.db.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM staged_notes WHERE id = $1 AND user_id = $2 AND expires_at > CURRENT_TIMESTAMP)
+	`, id, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return errStagedNoteClaimed
+}
+
+func scanStagedNote(row rowScanner) (models.StagedNote, error) {
+	var note models.StagedNote
+	var originalDate, claimedUntil sql.NullTime
+	var tags []byte
+	if err := row.Scan(&note.ID, &note.Source, &note.SourceRef, &note.Title, &note.Content, &note.CollectionName,
+		&note.Duplicate, &originalDate, &note.ClaimedBy, &claimedUntil, &note.CreatedAt, &note.ExpiresAt, &tags); err != nil {
+		return note, err
+	}
+	if err := json.Unmarshal(tags, &note.Tags); err != nil {
+		return note, err
+	}
+	if originalDate.Valid {
+		note.OriginalDate = &originalDate.Time
+	}
+	if claimedUntil.Valid {
+		note.ClaimedUntil = &claimedUntil.Time
+	}
+	return note, nil
+}
@@ -0,0 +1,88 @@
+// HTTP handlers for usage statistics endpoints
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+)
+
+// StatsHandlers handles statistics HTTP endpoints
+type StatsHandlers struct {
+	db *services.Database
+}
+
+// NewStatsHandlers creates a new StatsHandlers instance
+func NewStatsHandlers(db *services.Database) *StatsHandlers {
+	return &StatsHandlers{db: db}
+}
+
+// HandleLanguageStats handles GET /api/stats/languages - per-language note counts for the user's live notes
+func (h *StatsHandlers) HandleLanguageStats(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	stats, err := h.fetchLanguageStats(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error fetching language stats: %v", err)
+		respondWithError(w, "Failed to fetch language stats", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, stats, http.StatusOK)
+}
+
+// Helper functions
+
+func (h *StatsHandlers) fetchLanguageStats(ctx context.Context, userID string) (*models.LanguageStatsResponse, error) {
+	query := `
+		SELECT language, COUNT(*), MAX(updated_at)
+		FROM notes
+		WHERE user_id = $1 AND deleted_at IS NULL
+		GROUP BY language
+		ORDER BY COUNT(*) DESC, language
+	`
+	rows, err := h.db.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	stats := &models.LanguageStatsResponse{Languages: []models.LanguageStat{}}
+	known := 0
+	for rows.Next() {
+		var language sql.NullString
+		var stat models.LanguageStat
+		if err := rows.Scan(&language, &stat.NoteCount, &stat.LastUsedAt); err != nil {
+			return nil, err
+		}
+		if !language.Valid {
+			stats.UnknownCount = stat.NoteCount
+			continue
+		}
+		stat.Language = language.String
+		known += stat.NoteCount
+		stats.Languages = append(stats.Languages, stat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range stats.Languages {
+		stats.Languages[i].Share = float64(stats.Languages[i].NoteCount) / float64(known)
+	}
+	if len(stats.Languages) > 0 {
+		stats.PrimaryLanguage = stats.Languages[0].Language
+	}
+	return stats, nil
+}
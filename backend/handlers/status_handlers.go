@@ -0,0 +1,141 @@
+// HTTP handlers for the public status page
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// statusCacheTTL is how long a computed status is served before it's recomputed
+	statusCacheTTL = 15 * time.Second
+	// statusOutageFailures is how many consecutive failed checks turn "degraded" into "outage"
+	statusOutageFailures = 3
+)
+
+// statusRateGroup is the RATE_LIMITS group applied per client address
+const statusRateGroup = "status"
+
+// defaultStatusRateLimit applies when RATE_LIMITS has no status entry
+var defaultStatusRateLimit = services.RateLimit{Requests: 60, Window: time.Minute}
+
+// statusComponents maps checked dependencies to the coarse components shown publicly
+var statusComponents = map[string]string{
+	"database": "database",
+	"gemini":   "ai",
+}
+
+// statusComponentOrder is the order components are listed in
+var statusComponentOrder = []string{"api", "database", "ai"}
+
+// statusSeverity ranks component states so the worst one wins
+var statusSeverity = map[string]int{
+	models.StatusOperational: 0,
+	models.StatusDegraded:    1,
+	models.StatusOutage:      2,
+}
+
+// StatusHandlers handles the public status endpoint
+type StatusHandlers struct {
+	health  *services.HealthMonitor
+	slo     *services.SLOMonitor
+	limiter *services.RateLimiter
+
+	mu       sync.Mutex
+	cached   models.StatusResponse
+	cachedAt time.Time
+}
+
+// NewStatusHandlers creates a new StatusHandlers instance
+func NewStatusHandlers(health *services.HealthMonitor, slo *services.SLOMonitor) *StatusHandlers {
+	return &StatusHandlers{
+		health:  health,
+		slo:     slo,
+		limiter: services.NewRateLimiter(statusRateGroup, defaultStatusRateLimit),
+	}
+}
+
+// HandleStatus handles GET /status - coarse health of the API, database, and AI providers for an
+// incident banner. Public, so it only reveals per-component states, never check errors or latencies.
+func (h *StatusHandlers) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if retryAfter, ok := h.limiter.Allow(remoteHost(r)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many status requests",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(statusCacheTTL.Seconds())))
+	respondWithJSON(w, h.currentStatus(), http.StatusOK)
+}
+
+// Helper functions
+
+// currentStatus returns the cached status, recomputing it once it's older than statusCacheTTL
+func (h *StatusHandlers) currentStatus() models.StatusResponse {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if time.Since(h.cachedAt) < statusCacheTTL {
+		return h.cached
+	}
+
+	states := map[string]string{"api": models.StatusOperational}
+	for _, status := range h.slo.Statuses() {
+		if status.Violating {
+			states["api"] = models.StatusDegraded
+			break
+		}
+	}
+	for _, dependency := range h.health.Current() {
+		component, ok := statusComponents[dependency.Result.Dependency]
+		if !ok {
+			continue
+		}
+		state := models.StatusOperational
+		if dependency.ConsecutiveFailures >= statusOutageFailures {
+			state = models.StatusOutage
+		} else if dependency.ConsecutiveFailures > 0 {
+			state = models.StatusDegraded
+		}
+		if statusSeverity[state] >= statusSeverity[states[component]] {
+			states[component] = state
+		}
+	}
+
+	response := models.StatusResponse{
+		Status:     models.StatusOperational,
+		Components: make([]models.ComponentStatus, 0, len(statusComponentOrder)),
+		UpdatedAt:  time.Now(),
+	}
+	for _, name := range statusComponentOrder {
+		state, ok := states[name]
+		if !ok {
+			continue // Not checked yet
+		}
+		response.Components = append(response.Components, models.ComponentStatus{Name: name, Status: state})
+		if statusSeverity[state] > statusSeverity[response.Status] {
+			response.Status = state
+		}
+	}
+
+	h.cached, h.cachedAt = response, response.UpdatedAt
+	return response
+}
+
+// remoteHost returns the address of the connecting client, without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
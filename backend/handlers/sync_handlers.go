@@ -3,33 +3,51 @@ package handlers
 
 import (
 	"backend/models"
+	"backend/pagination"
 	"backend/services"
-	"context"
-	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // SyncHandlers handles cloud sync HTTP endpoints
 type SyncHandlers struct {
-	db *services.Database
+	notes       services.NoteRepository
+	collections services.CollectionRepository
+	users       services.UserRepository
+	hub         *services.RealtimeHub
+	embeddings  *services.EmbeddingIndexer
+	pullCache   *services.PullCache // Nil when pull caching is disabled
+
+	trashRetention time.Duration // How long deleted notes are kept; zero keeps them
 }
 
 // NewSyncHandlers creates a new SyncHandlers instance
-func NewSyncHandlers(db *services.Database) *SyncHandlers {
-	return &SyncHandlers{db: db}
+func NewSyncHandlers(notes services.NoteRepository, collections services.CollectionRepository, users services.UserRepository, hub *services.RealtimeHub, embeddings *services.EmbeddingIndexer, pullCache *services.PullCache, trashRetention time.Duration) *SyncHandlers {
+	return &SyncHandlers{
+		notes:          notes,
+		collections:    collections,
+		users:          users,
+		hub:            hub,
+		embeddings:     embeddings,
+		pullCache:      pullCache,
+		trashRetention: trashRetention,
+	}
 }
 
-// HandleSyncNotes handles GET /api/sync/notes - fetch notes since last sync
+// HandleSyncNotes handles GET /api/sync/notes?since=&fields=&limit=&cursor=&includeSnoozed= - fetch notes since
+// last sync, optionally only the listed note fields (e.g. fields=id,title,updatedAt for a quick switcher that
+// needs no bodies). With limit or cursor, notes come a page at a time, oldest change first. Full pulls leave
+// out snoozed notes unless includeSnoozed=true. A since older than the trash retention period gets a full
+// resync instead (see fullResync).
 func (h *SyncHandlers) HandleSyncNotes(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	userID, err := GetUserID(r)
 	if err != nil {
 		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
@@ -46,44 +64,111 @@ func (h *SyncHandlers) HandleSyncNotes(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Purged notes leave no deletion behind, so a device that last synced before the oldest purge could
+	// still hold notes deleted since. It gets every note instead, flagged so it drops the ones it has
+	// that aren't in the response.
+	fullResync := since != nil && h.trashRetention > 0 && since.Before(time.Now().Add(-h.trashRetention))
+	if fullResync {
+		since = nil
+	}
+
+	mask, err := parseNoteFieldMask(r.URL.Query().Get("fields"))
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Paging is opt-in so clients that expect every change in one response keep working
+	var page *pagination.Params
+	if r.URL.Query().Has("limit") || r.URL.Query().Has("cursor") {
+		params, err := pagination.ParseParams(r)
+		if err != nil {
+			respondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page = &params
+	}
+
 	ctx := r.Context()
 
 	// Ensure user exists
 	email := r.URL.Query().Get("email") // Optional email from frontend
-	if err := h.db.EnsureUser(ctx, userID, email); err != nil {
+	if err := h.users.EnsureUser(ctx, userID, email); err != nil {
 		log.Printf("Error ensuring user: %v", err)
 	}
+	h.touchDevice(r, userID, services.DevicePull)
+
+	// Repeated pulls can be served from the replica's cache (when PULL_CACHE_TTL is set)
+	cacheKey := r.URL.Query().Encode()
+	if body, ok := h.pullCache.Get(userID, cacheKey); ok {
+		w.Header().Set("X-Cache", "HIT")
+		respondWithRawJSON(w, body)
+		return
+	}
+	generation := h.pullCache.Generation(userID)
 
-	// Fetch notes
-	notes, err := h.fetchNotes(ctx, userID, since)
+	// Fetch notes. A full resync includes snoozed notes, or devices would drop them.
+	includeSnoozed := r.URL.Query().Get("includeSnoozed") == "true" || fullResync
+	notes, err := h.notes.SyncNotes(ctx, userID, since, includeSnoozed, mask, page)
 	if err != nil {
 		log.Printf("Error fetching notes: %v", err)
 		respondWithError(w, "Failed to fetch notes", http.StatusInternalServerError)
 		return
 	}
+	var nextCursor string
+	if page != nil {
+		notesPage := pagination.NewPage(notes, page.Limit, func(n models.SyncNote) pagination.Cursor {
+			return pagination.Cursor{SortValue: n.UpdatedAt, ID: n.ID}
+		})
+		notes, nextCursor = notesPage.Items, notesPage.NextCursor
+	}
 
-	// Fetch collections
-	collections, err := h.fetchCollections(ctx, userID, since)
-	if err != nil {
-		log.Printf("Error fetching collections: %v", err)
-		respondWithError(w, "Failed to fetch collections", http.StatusInternalServerError)
-		return
+	// Fetch collections (there are few, so a paged pull sends them all with its first page)
+	var collections []models.SyncCollection
+	if page == nil || page.Cursor == nil {
+		collections, err = h.collections.SyncCollections(ctx, userID, since)
+		if err != nil {
+			log.Printf("Error fetching collections: %v", err)
+			respondWithError(w, "Failed to fetch collections", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	respondWithJSON(w, models.SyncResponse{
+	var response interface{} = models.SyncResponse{
 		Notes:       notes,
 		Collections: collections,
 		LastSync:    time.Now(),
-	}, http.StatusOK)
-}
+		NextCursor:  nextCursor,
+		FullResync:  fullResync,
+	}
+	if mask != nil {
+		partialNotes, err := mask.Apply(notes)
+		if err != nil {
+			log.Printf("Error applying note field mask: %v", err)
+			respondWithError(w, "Failed to fetch notes", http.StatusInternalServerError)
+			return
+		}
+		response = models.PartialSyncResponse{
+			Notes:       partialNotes,
+			Collections: collections,
+			LastSync:    time.Now(),
+			NextCursor:  nextCursor,
+			FullResync:  fullResync,
+		}
+	}
 
-// HandleSyncPush handles POST /api/sync/push - push local changes to server
-func (h *SyncHandlers) HandleSyncPush(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	body, err := json.Marshal(withEmptySlices(response))
+	if err != nil {
+		log.Printf("Error encoding sync response: %v", err)
+		respondWithError(w, "Failed to fetch notes", http.StatusInternalServerError)
 		return
 	}
+	h.pullCache.Set(userID, cacheKey, generation, body)
+	respondWithRawJSON(w, body)
+}
 
+// HandleSyncPush handles POST /api/sync/push - push local changes to server
+func (h *SyncHandlers) HandleSyncPush(w http.ResponseWriter, r *http.Request) {
 	userID, err := GetUserID(r)
 	if err != nil {
 		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
@@ -97,44 +182,128 @@ func (h *SyncHandlers) HandleSyncPush(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	limits := services.Config.SyncLimits()
+	if len(req.Collections)+len(req.Notes) > limits.MaxPushItems {
+		respondWithJSON(w, models.LimitExceededResponse{
+			Error: fmt.Sprintf("A push can contain at most %d notes and collections; split it into smaller batches", limits.MaxPushItems),
+			Code:  models.ErrCodeLimitExceeded,
+			Limit: models.LimitPushItems,
+			Max:   limits.MaxPushItems,
+		}, http.StatusRequestEntityTooLarge)
+		return
+	}
+
 	ctx := r.Context()
 
 	// Ensure user exists
-	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+	if err := h.users.EnsureUser(ctx, userID, ""); err != nil {
 		log.Printf("Error ensuring user: %v", err)
 	}
-
-	// Process collections first
-	for i := range req.Collections {
-		coll := &req.Collections[i]
-		if err := h.upsertCollection(ctx, userID, coll); err != nil {
-			log.Printf("Error upserting collection %s: %v", coll.ID, err)
+	h.touchDevice(r, userID, services.DevicePush)
+
+	// Don't interleave with destructive operations (merge, restore, purge) holding note locks. ApplyPush
+	// checks again inside its transaction; this is the fast path that skips the work before it.
+	if len(req.Notes) > 0 {
+		noteIDs := make([]string, 0, len(req.Notes))
+		for i := range req.Notes {
+			noteIDs = append(noteIDs, req.Notes[i].ID)
+		}
+		locks, err := h.notes.ActiveNoteLocks(ctx, userID, noteIDs)
+		if err != nil {
+			log.Printf("Error checking note locks: %v", err)
+			respondWithError(w, "Failed to sync notes", http.StatusInternalServerError)
+			return
+		}
+		if len(locks) > 0 {
+			respondNoteLocked(w, locks)
+			return
 		}
 	}
 
-	// Process notes
-	for i := range req.Notes {
-		note := &req.Notes[i]
-		if note.DeletedAt != nil {
-			// Soft delete
-			if err := h.deleteNote(ctx, userID, note.ID); err != nil {
-				log.Printf("Error deleting note %s: %v", note.ID, err)
-			}
-		} else {
-			// Upsert note
-			if err := h.upsertNote(ctx, userID, note); err != nil {
-				log.Printf("Error upserting note %s: %v", note.ID, err)
-			}
-		}
+	// Stale pushes are always reported; keep_both also keeps the overwritten version
+	settings, err := h.users.UserSettings(ctx, userID)
+	if err != nil {
+		log.Printf("Error fetching user settings: %v", err)
+	}
+	deviceID := r.Header.Get("X-Device-ID")
+
+	// Report every malformed item before applying anything
+	if results, ok := validateSyncPush(&req, limits.MaxNoteBytes); !ok {
+		respondWithJSON(w, models.SyncPushRejectedResponse{
+			Error:   "Push contains invalid items; nothing was applied",
+			Code:    models.ErrCodeSyncPushRejected,
+			Results: results,
+		}, http.StatusUnprocessableEntity)
+		return
+	}
+	normalizeSyncPush(&req, timezoneLocation(settings.Timezone))
+
+	// Pushes that don't add notes (edits, deletes) still go through for users over the limit
+	current, after, err := h.notes.NoteCountAfterPush(ctx, userID, &req)
+	if err != nil {
+		log.Printf("Error counting notes: %v", err)
+		respondWithError(w, "Failed to sync changes", http.StatusInternalServerError)
+		return
+	}
+	if after > limits.MaxNotesPerUser && after > current {
+		respondWithJSON(w, models.LimitExceededResponse{
+			Error: fmt.Sprintf("You can have at most %d notes; delete some before adding more", limits.MaxNotesPerUser),
+			Code:  models.ErrCodeLimitExceeded,
+			Limit: models.LimitNoteCount,
+			Max:   limits.MaxNotesPerUser,
+		}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	applied, err := h.notes.ApplyPush(ctx, userID, deviceID, settings.ConflictPolicy, &req)
+	var lockedErr *services.NoteLockedError
+	if errors.As(err, &lockedErr) {
+		respondNoteLocked(w, lockedErr.Locks)
+		return
+	}
+	if errors.Is(err, services.ErrPushForbidden) {
+		respondWithJSON(w, models.SyncPushRejectedResponse{
+			Error:     "Push contains items that belong to another account; nothing was applied",
+			Code:      models.ErrCodeSyncPushRejected,
+			Results:   applied.Results,
+			Conflicts: applied.Conflicts,
+		}, http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, services.ErrPushConflicts) {
+		respondWithJSON(w, models.SyncPushRejectedResponse{
+			Error:     "Notes changed on the server since they were edited; merge the conflicts and push again",
+			Code:      models.ErrCodeSyncPushRejected,
+			Results:   applied.Results,
+			Conflicts: applied.Conflicts,
+		}, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error applying sync push: %v", err)
+		respondWithJSON(w, models.SyncPushRejectedResponse{
+			Error:   "Failed to sync changes; nothing was applied",
+			Code:    models.ErrCodeSyncPushRejected,
+			Results: applied.Results,
+		}, http.StatusInternalServerError)
+		return
+	}
+
+	// Only committed notes are indexed
+	for _, note := range applied.Embed {
+		h.embeddings.Enqueue(userID, note.ID, note.Title, *note.EmbeddingText)
 	}
 
+	// Tell the user's other connected devices to pull
+	h.hub.PublishChanges(userID, applied.NoteIDs, applied.CollectionIDs)
+
 	// Fetch updated notes and collections
-	notes, err := h.fetchNotes(ctx, userID, nil)
+	notes, err := h.notes.SyncNotes(ctx, userID, nil, true, nil, nil)
 	if err != nil {
 		log.Printf("Error fetching notes after sync: %v", err)
 		notes = []models.SyncNote{} // Return empty slice on error
 	}
-	collections, err := h.fetchCollections(ctx, userID, nil)
+	collections, err := h.collections.SyncCollections(ctx, userID, nil)
 	if err != nil {
 		log.Printf("Error fetching collections after sync: %v", err)
 		collections = []models.SyncCollection{} // Return empty slice on error
@@ -143,224 +312,359 @@ func (h *SyncHandlers) HandleSyncPush(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, models.SyncResponse{
 		Notes:       notes,
 		Collections: collections,
+		Conflicts:   applied.Conflicts,
+		Results:     applied.Results,
 		LastSync:    time.Now(),
 	}, http.StatusOK)
 }
 
-// Helper functions
+// HandleSyncVerify handles GET /api/sync/verify - digest of the user's notes for divergence detection
+func (h *SyncHandlers) HandleSyncVerify(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
 
-func (h *SyncHandlers) fetchNotes(ctx context.Context, userID string, since *time.Time) ([]models.SyncNote, error) {
-	var rows *sql.Rows
-	var err error
-
-	if since != nil {
-		query := `
-			SELECT n.id, n.user_id, n.title, n.content_encrypted, n.content_iv, 
-			       n.domain, n.date, n.is_pinned, n.created_at, n.updated_at, n.deleted_at
-			FROM notes n
-			WHERE n.user_id = $1 AND n.updated_at >= $2 AND (n.deleted_at IS NULL OR n.deleted_at >= $2)
-			ORDER BY n.updated_at DESC
-		`
-		rows, err = h.db.DB.QueryContext(ctx, query, userID, *since)
-	} else {
-		query := `
-			SELECT n.id, n.user_id, n.title, n.content_encrypted, n.content_iv, 
-			       n.domain, n.date, n.is_pinned, n.created_at, n.updated_at, n.deleted_at
-			FROM notes n
-			WHERE n.user_id = $1 AND n.deleted_at IS NULL
-			ORDER BY n.updated_at DESC
-		`
-		rows, err = h.db.DB.QueryContext(ctx, query, userID)
+	entries, memberships, err := h.notes.NoteDigestEntries(ctx, userID)
+	if err != nil {
+		log.Printf("Error fetching note digest entries: %v", err)
+		respondWithError(w, "Failed to verify notes", http.StatusInternalServerError)
+		return
 	}
 
+	collections, err := h.collections.SyncCollections(ctx, userID, nil)
 	if err != nil {
-		return nil, err
+		log.Printf("Error fetching collections: %v", err)
+		respondWithError(w, "Failed to verify notes", http.StatusInternalServerError)
+		return
 	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing rows: %v", err)
-		}
-	}()
-
-	var notes []models.SyncNote
-	for rows.Next() {
-		var note models.SyncNote
-		var domain sql.NullString
-		var deletedAt sql.NullTime
-		var contentEncryptedBytes []byte
-		var contentIVBytes []byte
-
-		err := rows.Scan(
-			&note.ID, &note.UserID, &note.Title, &contentEncryptedBytes, &contentIVBytes,
-			&domain, &note.Date, &note.IsPinned, &note.CreatedAt, &note.UpdatedAt, &deletedAt,
-		)
-		if err != nil {
-			continue
-		}
 
-		// Convert bytes to base64 strings for JSON response
-		note.ContentEncrypted = base64.StdEncoding.EncodeToString(contentEncryptedBytes)
-		note.ContentIV = base64.StdEncoding.EncodeToString(contentIVBytes)
+	root, counts, hashes := services.BucketDigests(entries)
+	response := models.SyncVerifyResponse{
+		RootHash:    root,
+		NoteCount:   len(entries),
+		Buckets:     make([]models.SyncBucketDigest, services.DigestBucketCount),
+		Collections: make([]models.SyncCollectionDigest, 0, len(collections)),
+		GeneratedAt: time.Now(),
+	}
+	for i := range hashes {
+		response.Buckets[i] = models.SyncBucketDigest{
+			Prefix: strconv.FormatInt(int64(i), 16),
+			Count:  counts[i],
+			Hash:   hashes[i],
+		}
+	}
 
-		if domain.Valid {
-			note.Domain = &domain.String
+	// Per-collection digests over the leaf hashes of member notes
+	leavesByCollection := make(map[string][]string)
+	for _, entry := range entries {
+		collIDs := memberships[entry.ID]
+		if len(collIDs) == 0 {
+			response.UncategorizedCount++
 		}
-		if deletedAt.Valid {
-			note.DeletedAt = &deletedAt.Time
+		for _, collID := range collIDs {
+			leavesByCollection[collID] = append(leavesByCollection[collID], services.NoteLeafHash(entry.ID, entry.UpdatedAt))
 		}
+	}
+	for i := range collections {
+		leaves := leavesByCollection[collections[i].ID]
+		response.Collections = append(response.Collections, models.SyncCollectionDigest{
+			CollectionID: collections[i].ID,
+			NoteCount:    len(leaves),
+			Hash:         services.DigestLeaves(leaves),
+		})
+	}
 
-		// Fetch collection IDs for this note
-		collectionIDs, err := h.fetchNoteCollections(ctx, note.ID)
-		if err != nil {
-			log.Printf("Error fetching collections for note %s: %v", note.ID, err)
-			collectionIDs = []string{} // Use empty slice on error
-		}
-		note.CollectionIDs = collectionIDs
+	respondWithJSON(w, response, http.StatusOK)
+}
 
-		notes = append(notes, note)
+// HandleSyncRepair handles POST /api/sync/repair - return only the notes the client is missing or has stale
+func (h *SyncHandlers) HandleSyncRepair(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	return notes, nil
-}
+	var req models.SyncRepairRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding repair request: %v", err)
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-func (h *SyncHandlers) fetchCollections(ctx context.Context, userID string, since *time.Time) ([]models.SyncCollection, error) {
-	var rows *sql.Rows
-	var err error
-
-	if since != nil {
-		query := `
-			SELECT id, user_id, name, icon, created_at, updated_at
-			FROM collections
-			WHERE user_id = $1 AND updated_at >= $2
-			ORDER BY updated_at DESC
-		`
-		rows, err = h.db.DB.QueryContext(ctx, query, userID, *since)
-	} else {
-		query := `
-			SELECT id, user_id, name, icon, created_at, updated_at
-			FROM collections
-			WHERE user_id = $1
-			ORDER BY updated_at DESC
-		`
-		rows, err = h.db.DB.QueryContext(ctx, query, userID)
+	// Optional bucket filter, so clients only send notes from buckets whose digest mismatched
+	buckets := make(map[string]bool, len(req.Buckets))
+	for _, prefix := range req.Buckets {
+		buckets[strings.ToLower(prefix)] = true
+	}
+	inScope := func(noteID string) bool {
+		return len(buckets) == 0 || buckets[strconv.FormatInt(int64(services.NoteBucket(noteID)), 16)]
 	}
 
+	ctx := r.Context()
+
+	entries, _, err := h.notes.NoteDigestEntries(ctx, userID)
 	if err != nil {
-		return nil, err
+		log.Printf("Error fetching note digest entries: %v", err)
+		respondWithError(w, "Failed to repair notes", http.StatusInternalServerError)
+		return
 	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing rows: %v", err)
-		}
-	}()
 
-	var collections []models.SyncCollection
-	for rows.Next() {
-		var coll models.SyncCollection
-		err := rows.Scan(
-			&coll.ID, &coll.UserID, &coll.Name, &coll.Icon, &coll.CreatedAt, &coll.UpdatedAt,
-		)
-		if err != nil {
+	clientHashes := make(map[string]string, len(req.Notes))
+	for _, entry := range req.Notes {
+		clientHashes[entry.ID] = entry.Hash
+	}
+
+	// Server notes the client lacks or holds a different version of
+	serverNoteIDs := make(map[string]bool, len(entries))
+	var staleIDs []string
+	for _, entry := range entries {
+		if !inScope(entry.ID) {
 			continue
 		}
-		collections = append(collections, coll)
+		serverNoteIDs[entry.ID] = true
+		if clientHashes[entry.ID] != services.NoteLeafHash(entry.ID, entry.UpdatedAt) {
+			staleIDs = append(staleIDs, entry.ID)
+		}
 	}
 
-	return collections, nil
-}
+	// Client notes the server has no active copy of: either deleted on the server or never pushed
+	var clientOnlyIDs []string
+	for _, entry := range req.Notes {
+		if inScope(entry.ID) && !serverNoteIDs[entry.ID] {
+			clientOnlyIDs = append(clientOnlyIDs, entry.ID)
+		}
+	}
 
-func (h *SyncHandlers) fetchNoteCollections(ctx context.Context, noteID string) ([]string, error) {
-	query := `SELECT collection_id FROM note_collections WHERE note_id = $1`
-	rows, err := h.db.DB.QueryContext(ctx, query, noteID)
-	if err != nil {
-		return nil, err
+	response := models.SyncRepairResponse{
+		Notes:          []models.SyncNote{},
+		UnknownNoteIDs: []string{},
+		LastSync:       time.Now(),
 	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing rows: %v", err)
+
+	if len(staleIDs) > 0 {
+		notes, err := h.notes.NotesByIDs(ctx, userID, staleIDs)
+		if err != nil {
+			log.Printf("Error fetching notes for repair: %v", err)
+			respondWithError(w, "Failed to repair notes", http.StatusInternalServerError)
+			return
 		}
-	}()
+		response.Notes = append(response.Notes, notes...)
+	}
 
-	var collectionIDs []string
-	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err == nil {
-			collectionIDs = append(collectionIDs, id)
+	if len(clientOnlyIDs) > 0 {
+		tombstones, err := h.notes.NotesByIDs(ctx, userID, clientOnlyIDs)
+		if err != nil {
+			log.Printf("Error fetching deleted notes for repair: %v", err)
+			respondWithError(w, "Failed to repair notes", http.StatusInternalServerError)
+			return
+		}
+		known := make(map[string]bool, len(tombstones))
+		for i := range tombstones {
+			known[tombstones[i].ID] = true
+		}
+		response.Notes = append(response.Notes, tombstones...)
+		for _, id := range clientOnlyIDs {
+			if !known[id] {
+				response.UnknownNoteIDs = append(response.UnknownNoteIDs, id)
+			}
 		}
 	}
-	return collectionIDs, nil
+
+	respondWithJSON(w, response, http.StatusOK)
 }
 
-func (h *SyncHandlers) upsertCollection(ctx context.Context, userID string, coll *models.SyncCollection) error {
-	query := `
-		INSERT INTO collections (id, user_id, name, icon, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT (id) DO UPDATE SET
-			name = EXCLUDED.name,
-			icon = EXCLUDED.icon,
-			updated_at = EXCLUDED.updated_at
-	`
-	_, err := h.db.DB.ExecContext(ctx, query, coll.ID, userID, coll.Name, coll.Icon, coll.CreatedAt, coll.UpdatedAt)
-	return err
+// Helper functions
+
+// touchDevice records the sync time for the device identified by the X-Device-ID header (optional)
+func (h *SyncHandlers) touchDevice(r *http.Request, userID, operation string) {
+	deviceID := r.Header.Get("X-Device-ID")
+	if deviceID == "" {
+		return
+	}
+	if err := h.users.TouchDevice(r.Context(), userID, deviceID, operation); err != nil {
+		log.Printf("Error recording device %s sync: %v", deviceID, err)
+	}
 }
 
-func (h *SyncHandlers) upsertNote(ctx context.Context, userID string, note *models.SyncNote) error {
-	// ContentEncrypted and ContentIV are base64 strings from frontend
-	// Decode them to []byte for database storage
-	contentEncrypted, err := base64.StdEncoding.DecodeString(note.ContentEncrypted)
-	if err != nil {
-		return err
+// validateSyncPush checks every pushed item without touching the database. It returns one result per item
+// (collections first) and false if any item is invalid, in which case the valid ones are rolled_back.
+func validateSyncPush(req *models.SyncRequest, maxNoteBytes int) ([]models.SyncItemResult, bool) {
+	results := make([]models.SyncItemResult, 0, len(req.Collections)+len(req.Notes))
+	valid := true
+	check := func(itemType, id string, err error) {
+		result := models.SyncItemResult{Type: itemType, ID: id, Status: models.SyncItemAccepted}
+		if err != nil {
+			result.Status, result.Reason, result.Error = models.SyncItemRejected, models.SyncRejectInvalid, err.Error()
+			if errors.Is(err, errNoteTooLarge) {
+				result.Reason = models.SyncRejectTooLarge
+			}
+			valid = false
+		}
+		results = append(results, result)
 	}
-	contentIV, err := base64.StdEncoding.DecodeString(note.ContentIV)
-	if err != nil {
-		return err
-	}
-
-	// Upsert note
-	query := `
-		INSERT INTO notes (id, user_id, title, content_encrypted, content_iv, domain, date, is_pinned, created_at, updated_at, deleted_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULL)
-		ON CONFLICT (id) DO UPDATE SET
-			title = EXCLUDED.title,
-			content_encrypted = EXCLUDED.content_encrypted,
-			content_iv = EXCLUDED.content_iv,
-			domain = EXCLUDED.domain,
-			date = EXCLUDED.date,
-			is_pinned = EXCLUDED.is_pinned,
-			updated_at = EXCLUDED.updated_at,
-			deleted_at = NULL
-	`
-	_, err = h.db.DB.ExecContext(ctx, query,
-		note.ID, userID, note.Title, contentEncrypted, contentIV, note.Domain, note.Date, note.IsPinned,
-		note.CreatedAt, note.UpdatedAt,
-	)
-	if err != nil {
-		return err
+
+	for i := range req.Collections {
+		coll := &req.Collections[i]
+		var err error
+		if strings.TrimSpace(coll.ID) == "" {
+			err = errors.New("id is required")
+		}
+		check("collection", coll.ID, err)
+	}
+	for i := range req.Notes {
+		note := &req.Notes[i]
+		var err error
+		if strings.TrimSpace(note.ID) == "" {
+			err = errors.New("id is required")
+		} else if note.DeletedAt == nil {
+			if content, decodeErr := base64.StdEncoding.DecodeString(note.ContentEncrypted); decodeErr != nil {
+				err = errors.New("contentEncrypted is not valid base64")
+			} else if len(content) > maxNoteBytes {
+				err = fmt.Errorf("%w: contentEncrypted is %d bytes, the limit is %d", errNoteTooLarge, len(content), maxNoteBytes)
+			} else if _, decodeErr := base64.StdEncoding.DecodeString(note.ContentIV); decodeErr != nil {
+				err = errors.New("contentIV is not valid base64")
+			} else {
+				err = validateNoteSource(note)
+			}
+		}
+		check("note", note.ID, err)
 	}
 
-	// Update note collections
-	// Delete existing associations
-	_, err = h.db.DB.ExecContext(ctx, `DELETE FROM note_collections WHERE note_id = $1`, note.ID)
-	if err != nil {
-		return err
+	if !valid {
+		services.MarkRolledBack(results)
 	}
+	return results, valid
+}
 
-	// Insert new associations
-	if len(note.CollectionIDs) > 0 {
-		query = `INSERT INTO note_collections (note_id, collection_id) VALUES ($1, $2)`
-		for _, collID := range note.CollectionIDs {
-			_, err = h.db.DB.ExecContext(ctx, query, note.ID, collID)
+// normalizeSyncPush canonicalizes the language tags, tags, and dates of pushed notes. Invalid languages, tag
+// lists, and collection appearance fields are dropped (keeping the stored values) rather than failing the
+// item. Dates sent as UTC timestamps are resolved to their day in loc, the user's time zone, and notes
+// without a date get the day they were created there.
+func normalizeSyncPush(req *models.SyncRequest, loc *time.Location) {
+	for i := range req.Collections {
+		coll := &req.Collections[i]
+		if err := validateCollectionAppearance(coll.Color, coll.Description, coll.Cover); err != nil {
+			log.Printf("Ignoring invalid appearance for collection %s: %v", coll.ID, err)
+			coll.Color, coll.Description, coll.Cover = nil, nil, nil
+		}
+	}
+	for i := range req.Notes {
+		note := &req.Notes[i]
+		if note.DeletedAt != nil {
+			continue
+		}
+		language := normalizeLanguageTag(note.Language)
+		if note.Language != nil && language == nil {
+			log.Printf("Ignoring invalid language tag for note %s", note.ID)
+		}
+		note.Language = language
+		if note.Tags != nil {
+			tags, err := normalizeTags(note.Tags)
 			if err != nil {
-				log.Printf("Error associating note %s with collection %s: %v", note.ID, collID, err)
+				log.Printf("Ignoring invalid tags for note %s: %v", note.ID, err)
+			}
+			note.Tags = tags
+		}
+		note.Date = note.Date.In(loc)
+		if note.Date.IsZero() {
+			created := note.CreatedAt
+			if created.IsZero() {
+				created = time.Now()
 			}
+			note.Date = models.NoteDateOf(created.In(loc))
 		}
 	}
+}
+
+// errNoteTooLarge is reported for notes over the encrypted content size limit
+var errNoteTooLarge = errors.New("note too large")
+
+// maxNoteSourceBytes caps a note's encrypted source page (a URL and a title)
+const maxNoteSourceBytes = 8 << 10
 
+// sourceURLHashPattern matches a hex HMAC-SHA256
+var sourceURLHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// validateNoteSource checks a pushed note's source page fields. Unlike language and tags, they're
+// opaque to the server and can't be repaired, so a malformed source fails the note.
+func validateNoteSource(note *models.SyncNote) error {
+	if note.SourceEncrypted == nil || *note.SourceEncrypted == "" {
+		return nil
+	}
+	source, err := base64.StdEncoding.DecodeString(*note.SourceEncrypted)
+	if err != nil {
+		return errors.New("sourceEncrypted is not valid base64")
+	}
+	if len(source) > maxNoteSourceBytes {
+		return fmt.Errorf("sourceEncrypted is %d bytes, the limit is %d", len(source), maxNoteSourceBytes)
+	}
+	if note.SourceIV == nil {
+		return errors.New("sourceIV is required with sourceEncrypted")
+	}
+	if _, err := base64.StdEncoding.DecodeString(*note.SourceIV); err != nil {
+		return errors.New("sourceIV is not valid base64")
+	}
+	if note.SourceURLHash != nil && *note.SourceURLHash != "" && !sourceURLHashPattern.MatchString(*note.SourceURLHash) {
+		return errors.New("sourceUrlHash must be a lowercase hex HMAC-SHA256")
+	}
 	return nil
 }
 
-func (h *SyncHandlers) deleteNote(ctx context.Context, userID, noteID string) error {
-	query := `UPDATE notes SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2`
-	_, err := h.db.DB.ExecContext(ctx, query, noteID, userID)
-	return err
+// languageTagPattern loosely matches BCP 47 tags: a 2-3 letter language plus optional subtags
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// normalizeLanguageTag canonicalizes the case of a BCP 47 tag ("EN-us" -> "en-US"), returning nil if it's invalid
+func normalizeLanguageTag(tag *string) *string {
+	if tag == nil || len(*tag) > 35 || !languageTagPattern.MatchString(*tag) {
+		return nil
+	}
+
+	subtags := strings.Split(*tag, "-")
+	subtags[0] = strings.ToLower(subtags[0])
+	for i := 1; i < len(subtags); i++ {
+		switch len(subtags[i]) {
+		case 2: // Region
+			subtags[i] = strings.ToUpper(subtags[i])
+		case 4: // Script
+			subtags[i] = strings.ToUpper(subtags[i][:1]) + strings.ToLower(subtags[i][1:])
+		default:
+			subtags[i] = strings.ToLower(subtags[i])
+		}
+	}
+
+	normalized := strings.Join(subtags, "-")
+	return &normalized
+}
+
+// syncNoteFields are the note JSON fields a sync pull's field mask can select
+var syncNoteFields = map[string]bool{
+	"id": true, "userId": true, "title": true, "contentEncrypted": true, "contentIV": true, "domain": true,
+	"language": true, "date": true, "isPinned": true, "isArchived": true, "tags": true, "reminderAt": true,
+	"collectionIds": true, "conflictOf": true, "createdAt": true, "updatedAt": true, "deletedAt": true,
+	"sourceEncrypted": true, "sourceIV": true, "sourceUrlHash": true, "aiExcluded": true, "snoozedUntil": true,
+}
+
+// parseNoteFieldMask parses a comma-separated field list. The ID is always included, and an empty
+// list returns a nil (full) mask.
+func parseNoteFieldMask(param string) (services.NoteFieldMask, error) {
+	if strings.TrimSpace(param) == "" {
+		return nil, nil
+	}
+	mask := services.NoteFieldMask{"id": true}
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !syncNoteFields[field] {
+			return nil, fmt.Errorf("unknown note field %q", field)
+		}
+		mask[field] = true
+	}
+	return mask, nil
 }
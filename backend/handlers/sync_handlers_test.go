@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"backend/services/repositorytest"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestSyncHandlers creates sync handlers on an in-memory store
+func newTestSyncHandlers(t *testing.T) (*SyncHandlers, *repositorytest.Store) {
+	t.Helper()
+	store := repositorytest.NewStore()
+	hub := services.NewRealtimeHub()
+	t.Cleanup(hub.Close)
+	return NewSyncHandlers(store, store, store, hub, services.NewEmbeddingIndexer(nil, nil), nil, 30*24*time.Hour), store
+}
+
+// syncRequest builds a request made by a signed-in user
+func syncRequest(t *testing.T, method, path, userID string, body interface{}) *http.Request {
+	t.Helper()
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	r.Header.Set("X-Device-ID", "laptop")
+	ctx := context.WithValue(r.Context(), userIDKey, userID)
+	ctx = context.WithValue(ctx, sessionUserIDKey, userID)
+	return r.WithContext(ctx)
+}
+
+// pushedNote returns a valid note to push
+func pushedNote(id, title string) models.SyncNote {
+	return models.SyncNote{ID: id, Title: title, ContentEncrypted: "Y2lwaGVy", ContentIV: "aXY=", Date: models.NoteDateOf(time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC))}
+}
+
+func decodeSyncResponse(t *testing.T, w *httptest.ResponseRecorder) models.SyncResponse {
+	t.Helper()
+	var resp models.SyncResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestSyncNotesReturnsOnlyTheUsersLiveNotes(t *testing.T) {
+	h, store := newTestSyncHandlers(t)
+	now := time.Now()
+	store.AddNote(models.SyncNote{ID: "mine", UserID: "alice", Title: "Mine", UpdatedAt: now})
+	store.AddNote(models.SyncNote{ID: "trashed", UserID: "alice", Title: "Trashed", UpdatedAt: now, DeletedAt: &now})
+	store.AddNote(models.SyncNote{ID: "theirs", UserID: "bob", Title: "Theirs", UpdatedAt: now})
+	store.AddCollection(models.SyncCollection{ID: "work", UserID: "alice", Name: "Work", UpdatedAt: now})
+
+	w := httptest.NewRecorder()
+	h.HandleSyncNotes(w, syncRequest(t, http.MethodGet, "/api/sync/notes", "alice", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	resp := decodeSyncResponse(t, w)
+	if len(resp.Notes) != 1 || resp.Notes[0].ID != "mine" {
+		t.Errorf("notes = %+v, want only alice's live note", resp.Notes)
+	}
+	if len(resp.Collections) != 1 || resp.Collections[0].ID != "work" {
+		t.Errorf("collections = %+v, want alice's collection", resp.Collections)
+	}
+	if !store.UserExists("alice") {
+		t.Error("pull didn't ensure the user exists")
+	}
+	if touches := store.Touches(); len(touches) != 1 || touches[0] != "alice laptop pull" {
+		t.Errorf("device touches = %v, want one pull from the laptop", touches)
+	}
+}
+
+func TestSyncNotesSinceBeforeTrashRetentionIsAFullResync(t *testing.T) {
+	h, store := newTestSyncHandlers(t)
+	now := time.Now()
+	snoozed := now.Add(time.Hour)
+	store.AddNote(models.SyncNote{ID: "old", UserID: "alice", Title: "Unchanged for months", UpdatedAt: now.AddDate(0, -3, 0)})
+	store.AddNote(models.SyncNote{ID: "snoozed", UserID: "alice", Title: "Snoozed", UpdatedAt: now, SnoozedUntil: &snoozed})
+
+	since := now.AddDate(0, -2, 0).UTC().Format(time.RFC3339)
+	w := httptest.NewRecorder()
+	h.HandleSyncNotes(w, syncRequest(t, http.MethodGet, "/api/sync/notes?since="+since, "alice", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	resp := decodeSyncResponse(t, w)
+	if !resp.FullResync {
+		t.Error("fullResync not set for a since older than the trash retention")
+	}
+	if len(resp.Notes) != 2 {
+		t.Errorf("notes = %+v, want every live note, snoozed ones included", resp.Notes)
+	}
+
+	w = httptest.NewRecorder()
+	recent := now.Add(-time.Minute).UTC().Format(time.RFC3339)
+	h.HandleSyncNotes(w, syncRequest(t, http.MethodGet, "/api/sync/notes?since="+recent, "alice", nil))
+	if resp := decodeSyncResponse(t, w); resp.FullResync {
+		t.Error("fullResync set for a recent since")
+	}
+}
+
+func TestSyncPushStoresNotes(t *testing.T) {
+	h, store := newTestSyncHandlers(t)
+
+	w := httptest.NewRecorder()
+	h.HandleSyncPush(w, syncRequest(t, http.MethodPost, "/api/sync/push", "alice", models.SyncRequest{
+		Notes: []models.SyncNote{pushedNote("n1", "Groceries")},
+	}))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	resp := decodeSyncResponse(t, w)
+	if len(resp.Results) != 1 || resp.Results[0].Status != models.SyncItemAccepted {
+		t.Errorf("results = %+v, want the note accepted", resp.Results)
+	}
+	if len(resp.Notes) != 1 || resp.Notes[0].Title != "Groceries" {
+		t.Errorf("notes = %+v, want the pushed note", resp.Notes)
+	}
+	if note, ok := store.Note("n1"); !ok || note.UserID != "alice" {
+		t.Errorf("stored note = %+v, want it owned by alice", note)
+	}
+}
+
+func TestSyncPushIsAllOrNothing(t *testing.T) {
+	h, store := newTestSyncHandlers(t)
+	store.AddNote(models.SyncNote{ID: "theirs", UserID: "bob", Title: "Bob's", UpdatedAt: time.Now()})
+
+	w := httptest.NewRecorder()
+	h.HandleSyncPush(w, syncRequest(t, http.MethodPost, "/api/sync/push", "alice", models.SyncRequest{
+		Notes: []models.SyncNote{pushedNote("n1", "Groceries"), pushedNote("theirs", "Hijacked")},
+	}))
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %s", w.Code, w.Body)
+	}
+	var resp models.SyncPushRejectedResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{models.SyncRejectRolledBack, models.SyncRejectForbidden}
+	for i, result := range resp.Results {
+		if result.Reason != want[i] {
+			t.Errorf("result %d reason = %q, want %q", i, result.Reason, want[i])
+		}
+	}
+	if _, ok := store.Note("n1"); ok {
+		t.Error("the valid note was stored although the push was rejected")
+	}
+	if note, _ := store.Note("theirs"); note.Title != "Bob's" {
+		t.Errorf("bob's note was changed to %q", note.Title)
+	}
+}
+
+func TestSyncPushRejectsStaleNotesUnderRejectPolicy(t *testing.T) {
+	h, store := newTestSyncHandlers(t)
+	base := time.Now().Add(-time.Hour)
+	store.AddNote(models.SyncNote{ID: "n1", UserID: "alice", Title: "Edited elsewhere", UpdatedAt: time.Now()})
+	store.SetUserSettings("alice", models.UserSettings{ConflictPolicy: models.ConflictReject, Timezone: models.DefaultTimezone})
+
+	note := pushedNote("n1", "Stale edit")
+	note.BaseUpdatedAt = &base
+	w := httptest.NewRecorder()
+	h.HandleSyncPush(w, syncRequest(t, http.MethodPost, "/api/sync/push", "alice", models.SyncRequest{
+		Notes: []models.SyncNote{note},
+	}))
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	if stored, _ := store.Note("n1"); stored.Title != "Edited elsewhere" {
+		t.Errorf("stored title = %q, want the server's version kept", stored.Title)
+	}
+}
+
+func TestSyncNoteDateSurvivesPullPushRoundTrip(t *testing.T) {
+	for _, zone := range []string{"America/Los_Angeles", "Asia/Tokyo"} {
+		t.Run(zone, func(t *testing.T) {
+			h, store := newTestSyncHandlers(t)
+			store.SetUserSettings("alice", models.UserSettings{ConflictPolicy: models.ConflictLastWriteWins, Timezone: zone})
+			note := pushedNote("n1", "Groceries")
+			note.UserID = "alice"
+			note.UpdatedAt = time.Now().Add(-time.Hour)
+			store.AddNote(note)
+
+			w := httptest.NewRecorder()
+			h.HandleSyncNotes(w, syncRequest(t, http.MethodGet, "/api/sync/notes", "alice", nil))
+			pulled := decodeSyncResponse(t, w)
+			if len(pulled.Notes) != 1 {
+				t.Fatalf("pulled %d notes, want 1", len(pulled.Notes))
+			}
+
+			// Older clients keep the pulled day as a timestamp and send it back as one
+			var echoed map[string]interface{}
+			encoded, err := json.Marshal(pulled.Notes[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(encoded, &echoed); err != nil {
+				t.Fatal(err)
+			}
+			echoed["date"] = echoed["date"].(string) + "T00:00:00.000Z"
+			echoed["updatedAt"] = time.Now().Format(time.RFC3339Nano)
+
+			w = httptest.NewRecorder()
+			h.HandleSyncPush(w, syncRequest(t, http.MethodPost, "/api/sync/push", "alice", map[string]interface{}{
+				"notes": []interface{}{echoed},
+			}))
+			if w.Code != http.StatusOK {
+				t.Fatalf("push status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if stored, _ := store.Note("n1"); stored.Date.String() != "2026-10-15" {
+				t.Errorf("stored date = %s, want 2026-10-15", stored.Date)
+			}
+		})
+	}
+}
+
+func TestSyncPushWaitsForNoteLocks(t *testing.T) {
+	h, store := newTestSyncHandlers(t)
+	store.AddLock("alice", models.NoteLock{NoteID: "n1", Operation: "merge", HolderUserID: "alice", ExpiresAt: time.Now().Add(time.Minute)})
+
+	w := httptest.NewRecorder()
+	h.HandleSyncPush(w, syncRequest(t, http.MethodPost, "/api/sync/push", "alice", models.SyncRequest{
+		Notes: []models.SyncNote{pushedNote("n1", "Groceries")},
+	}))
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	if _, ok := store.Note("n1"); ok {
+		t.Error("a locked note was written")
+	}
+}
+
+func TestSyncPushRechecksNoteLocksWhenApplying(t *testing.T) {
+	h, store := newTestSyncHandlers(t)
+	store.BeforePush = func() {
+		store.AddLock("alice", models.NoteLock{NoteID: "n1", Operation: "purge", HolderUserID: "alice", ExpiresAt: time.Now().Add(time.Minute)})
+	}
+
+	w := httptest.NewRecorder()
+	h.HandleSyncPush(w, syncRequest(t, http.MethodPost, "/api/sync/push", "alice", models.SyncRequest{
+		Notes: []models.SyncNote{pushedNote("n1", "Groceries")},
+	}))
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	var resp models.NoteLockedResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Locks) != 1 || resp.Locks[0].Operation != "purge" {
+		t.Errorf("locks = %+v, want the purge lock taken during the push", resp.Locks)
+	}
+	if _, ok := store.Note("n1"); ok {
+		t.Error("a note locked during the push was written")
+	}
+}
+
+func TestSyncNotesDatabaseFailure(t *testing.T) {
+	h, store := newTestSyncHandlers(t)
+	store.Err = errors.New("database unavailable")
+
+	w := httptest.NewRecorder()
+	h.HandleSyncNotes(w, syncRequest(t, http.MethodGet, "/api/sync/notes", "alice", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+}
@@ -0,0 +1,307 @@
+// HTTP handlers for opt-in feature usage telemetry
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Telemetry limits
+const (
+	maxTelemetryEvents     = 50
+	maxFeatureUsesPerEvent = 1000
+	// maxTelemetryReportDays bounds the admin usage report
+	maxTelemetryReportDays = 366
+	// telemetryDayLayout formats the UTC day usage is aggregated under
+	telemetryDayLayout = "2006-01-02"
+)
+
+// telemetryRateGroup is the RATE_LIMITS group applied per user
+const telemetryRateGroup = "telemetry"
+
+// defaultTelemetryRateLimit applies when RATE_LIMITS has no telemetry entry
+var defaultTelemetryRateLimit = services.RateLimit{Requests: 30, Window: time.Minute}
+
+// telemetryFeatures are the features clients may report; anything else is ignored so newer clients
+// can report features this server doesn't know yet
+var telemetryFeatures = map[string]bool{
+	models.FeatureAICleanup:     true,
+	models.FeatureChat:          true,
+	models.FeatureShareLinks:    true,
+	models.FeatureSmartAppend:   true,
+	models.FeatureAudioCapture:  true,
+	models.FeatureRelevantNotes: true,
+	models.FeatureImport:        true,
+	models.FeatureWebClipper:    true,
+}
+
+// TelemetryHandlers handles feature usage telemetry HTTP endpoints
+type TelemetryHandlers struct {
+	db      *services.Database
+	secret  []byte
+	limiter *services.RateLimiter
+}
+
+// NewTelemetryHandlers creates a new TelemetryHandlers instance. An empty secret disables recording.
+func NewTelemetryHandlers(db *services.Database, secret string) *TelemetryHandlers {
+	return &TelemetryHandlers{
+		db:      db,
+		secret:  []byte(secret),
+		limiter: services.NewRateLimiter(telemetryRateGroup, defaultTelemetryRateLimit),
+	}
+}
+
+// HandleGetTelemetryConsent handles GET /api/telemetry/consent - check whether the user has opted into
+// usage telemetry. Telemetry is off until the user opts in.
+func (h *TelemetryHandlers) HandleGetTelemetryConsent(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	consent, err := h.fetchConsent(ctx, userID)
+	if err != nil {
+		log.Printf("Error fetching telemetry consent: %v", err)
+		respondWithError(w, "Failed to fetch telemetry consent", http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, consent, http.StatusOK)
+}
+
+// HandlePutTelemetryConsent handles PUT /api/telemetry/consent - opt into or out of usage telemetry
+func (h *TelemetryHandlers) HandlePutTelemetryConsent(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var req models.TelemetryConsent
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+	consent := models.TelemetryConsent{OptedIn: req.OptedIn}
+	err = h.db.DB.QueryRowContext(ctx, `
+		UPDATE users SET telemetry_opt_in = $2, telemetry_updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING telemetry_updated_at
+	`, userID, req.OptedIn).Scan(&consent.UpdatedAt)
+	if err != nil {
+		log.Printf("Error updating telemetry consent: %v", err)
+		respondWithError(w, "Failed to update telemetry consent", http.StatusInternalServerError)
+		return
+	}
+
+	// Earlier days can't be traced back to the user, but today's counts still can
+	if !req.OptedIn && len(h.secret) > 0 {
+		day := time.Now().UTC().Format(telemetryDayLayout)
+		if _, err := h.db.DB.ExecContext(ctx, `DELETE FROM feature_usage_daily WHERE day = $1 AND subject = $2`,
+			day, h.subject(day, userID)); err != nil {
+			log.Printf("Error deleting today's telemetry: %v", err)
+		}
+	}
+
+	respondWithJSON(w, consent, http.StatusOK)
+}
+
+// HandleRecordUsage handles POST /api/telemetry/events - add feature use counts to today's totals.
+// Nothing is recorded unless the user has opted in; usage is stored under a per-day pseudonym, never
+// the user ID.
+func (h *TelemetryHandlers) HandleRecordUsage(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if len(h.secret) == 0 {
+		respondWithError(w, "Telemetry is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	if retryAfter, ok := h.limiter.Allow(userID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many telemetry reports",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return
+	}
+
+	var req models.TelemetryEventsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Events) > maxTelemetryEvents {
+		respondWithError(w, "Too many events", http.StatusBadRequest)
+		return
+	}
+
+	// Fold the batch into one count per known feature
+	uses := make(map[string]int, len(req.Events))
+	for _, event := range req.Events {
+		if event.Count < 1 || event.Count > maxFeatureUsesPerEvent {
+			respondWithError(w, "Event counts must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		if telemetryFeatures[event.Feature] {
+			uses[event.Feature] += event.Count
+		}
+	}
+
+	ctx := r.Context()
+	consent, err := h.fetchConsent(ctx, userID)
+	if err != nil {
+		log.Printf("Error fetching telemetry consent: %v", err)
+		respondWithError(w, "Failed to record usage", http.StatusInternalServerError)
+		return
+	}
+	if !consent.OptedIn {
+		respondWithJSON(w, models.TelemetryEventsResponse{Recorded: false}, http.StatusOK)
+		return
+	}
+
+	if err := h.recordUsage(ctx, userID, uses); err != nil {
+		log.Printf("Error recording feature usage: %v", err)
+		respondWithError(w, "Failed to record usage", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.TelemetryEventsResponse{Recorded: true}, http.StatusOK)
+}
+
+// HandleUsageReport handles GET /api/admin/telemetry/usage?since=&until= - daily distinct users and
+// total uses per feature for the UTC days in [since, until] (default the last 30 days)
+func (h *TelemetryHandlers) HandleUsageReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	until := time.Now().UTC().Truncate(24 * time.Hour)
+	if param := query.Get("until"); param != "" {
+		parsed, err := time.Parse(telemetryDayLayout, param)
+		if err != nil {
+			respondWithError(w, "Invalid until date (expected YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		until = parsed
+	}
+	since := until.AddDate(0, 0, -29)
+	if param := query.Get("since"); param != "" {
+		parsed, err := time.Parse(telemetryDayLayout, param)
+		if err != nil {
+			respondWithError(w, "Invalid since date (expected YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	if since.After(until) {
+		respondWithError(w, "since must not be after until", http.StatusBadRequest)
+		return
+	}
+	if until.Sub(since) >= maxTelemetryReportDays*24*time.Hour {
+		respondWithError(w, "Report range can be at most 366 days", http.StatusBadRequest)
+		return
+	}
+
+	usage, err := h.fetchUsage(r.Context(), since, until)
+	if err != nil {
+		log.Printf("Error fetching feature usage: %v", err)
+		respondWithError(w, "Failed to fetch feature usage", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, usage, http.StatusOK)
+}
+
+// Helper functions
+
+// subject is the pseudonym a user's usage is stored under on a given day. Keying it by day lets
+// distinct users be counted per day without linking a user's activity across days.
+func (h *TelemetryHandlers) subject(day, userID string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(day + ":" + userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fetchConsent returns the user's telemetry consent (not opted in if the user has no row yet)
+func (h *TelemetryHandlers) fetchConsent(ctx context.Context, userID string) (models.TelemetryConsent, error) {
+	var consent models.TelemetryConsent
+	var updatedAt sql.NullTime
+	err := h.db.DB.QueryRowContext(ctx, `SELECT telemetry_opt_in, telemetry_updated_at FROM users WHERE id = $1`, userID).
+		Scan(&consent.OptedIn, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return consent, nil
+	}
+	if err != nil {
+		return consent, err
+	}
+	if updatedAt.Valid {
+		consent.UpdatedAt = &updatedAt.Time
+	}
+	return consent, nil
+}
+
+// recordUsage adds use counts to today's per-feature totals for the user's pseudonym
+func (h *TelemetryHandlers) recordUsage(ctx context.Context, userID string, uses map[string]int) error {
+	day := time.Now().UTC().Format(telemetryDayLayout)
+	subject := h.subject(day, userID)
+	for feature, count := range uses {
+		_, err := h.db.DB.ExecContext(ctx, `
+			INSERT INTO feature_usage_daily (day, feature, subject, uses)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (day, feature, subject) DO UPDATE SET uses = feature_usage_daily.uses + EXCLUDED.uses
+		`, day, feature, subject, count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchUsage aggregates daily usage per feature, newest day first
+func (h *TelemetryHandlers) fetchUsage(ctx context.Context, since, until time.Time) ([]models.FeatureUsageDay, error) {
+	rows, err := h.db.DB.QueryContext(ctx, `
+		SELECT to_char(day, 'YYYY-MM-DD'), feature, COUNT(*), SUM(uses)
+		FROM feature_usage_daily
+		WHERE day BETWEEN $1 AND $2
+		GROUP BY day, feature
+		ORDER BY day DESC, feature
+	`, since.Format(telemetryDayLayout), until.Format(telemetryDayLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	usage := []models.FeatureUsageDay{}
+	for rows.Next() {
+		var u models.FeatureUsageDay
+		if err := rows.Scan(&u.Day, &u.Feature, &u.Users, &u.Uses); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
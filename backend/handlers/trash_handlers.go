@@ -0,0 +1,156 @@
+// HTTP handlers for the trash of soft-deleted notes
+package handlers
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// errNoteNotDeleted is returned when a trash operation targets a live note
+var errNoteNotDeleted = errors.New("note is not deleted")
+
+// HandleTrash handles GET /api/notes/trash?limit=&cursor= - the user's deleted notes, most recently
+// deleted first, with when each is purged
+func (h *NoteAPIHandlers) HandleTrash(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := pagination.ParseParams(r)
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notes, err := h.listTrash(r.Context(), userID, params)
+	if err != nil {
+		log.Printf("Error listing trash: %v", err)
+		respondWithError(w, "Failed to list deleted notes", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, pagination.NewPage(notes, params.Limit, func(n models.TrashedNote) pagination.Cursor {
+		return pagination.Cursor{SortValue: *n.DeletedAt, ID: n.ID}
+	}), http.StatusOK)
+}
+
+// HandlePurgeNote handles POST /api/notes/{id}/purge - permanently delete a note from the trash, with
+// its revisions and timeline. Devices that haven't pulled the deletion yet keep their copy.
+func (h *NoteAPIHandlers) HandlePurgeNote(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	noteID := r.PathValue("id")
+	if _, err := h.trashedNote(ctx, userID, noteID); err != nil {
+		h.respondTrashError(w, noteID, err, "Failed to purge note")
+		return
+	}
+
+	lock := lockNotes(w, r, h.db, userID, []string{noteID}, models.LockPurge)
+	if lock == nil {
+		return
+	}
+	defer lock.Release()
+
+	var purged int64
+	result, err := h.db.DB.ExecContext(ctx, `
+		DELETE FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+	`, noteID, userID)
+	if err == nil {
+		purged, err = result.RowsAffected()
+	}
+	if err != nil {
+		log.Printf("Error purging note %s: %v", noteID, err)
+		respondWithError(w, "Failed to purge note", http.StatusInternalServerError)
+		return
+	}
+	if purged == 0 {
+		// Restored since it was checked
+		h.respondTrashError(w, noteID, errNoteNotDeleted, "Failed to purge note")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Helper functions
+
+// restoreFromTrash saves a deleted note again as it was when deleted and responds with it
+func (h *NoteAPIHandlers) restoreFromTrash(w http.ResponseWriter, r *http.Request, userID, noteID string) {
+	note, err := h.trashedNote(r.Context(), userID, noteID)
+	if err != nil {
+		h.respondTrashError(w, noteID, err, "Failed to restore note")
+		return
+	}
+	if !h.noteLimitAllows(w, r, userID, "Failed to restore note") {
+		return
+	}
+
+	// A note changed (or restored) since it was read fails as a conflict instead of being overwritten
+	baseUpdatedAt := note.UpdatedAt
+	note.DeletedAt, note.EmbeddingText = nil, nil
+	note.BaseUpdatedAt, note.UpdatedAt = &baseUpdatedAt, time.Now()
+	if h.writeNote(w, r, userID, &note) {
+		h.respondWithNote(w, r, userID, noteID, http.StatusOK)
+	}
+}
+
+// trashedNote returns the user's deleted note, errNoteNotFound if there's no such note, or
+// errNoteNotDeleted if it's live
+func (h *NoteAPIHandlers) trashedNote(ctx context.Context, userID, noteID string) (models.SyncNote, error) {
+	notes, err := h.sync.notes.NotesByIDs(ctx, userID, []string{noteID})
+	if err != nil {
+		return models.SyncNote{}, err
+	}
+	if len(notes) == 0 {
+		return models.SyncNote{}, errNoteNotFound
+	}
+	if notes[0].DeletedAt == nil {
+		return models.SyncNote{}, errNoteNotDeleted
+	}
+	return notes[0], nil
+}
+
+// respondTrashError responds to an error from trashedNote
+func (h *NoteAPIHandlers) respondTrashError(w http.ResponseWriter, noteID string, err error, failure string) {
+	switch {
+	case errors.Is(err, errNoteNotFound):
+		respondWithError(w, "Note not found", http.StatusNotFound)
+	case errors.Is(err, errNoteNotDeleted):
+		respondWithError(w, "Note is not in the trash", http.StatusConflict)
+	default:
+		log.Printf("Error fetching note %s: %v", noteID, err)
+		respondWithError(w, failure, http.StatusInternalServerError)
+	}
+}
+
+// listTrash fetches one page (plus one extra note to detect more) of the user's deleted notes, most
+// recently deleted first
+func (h *NoteAPIHandlers) listTrash(ctx context.Context, userID string, params pagination.Params) ([]models.TrashedNote, error) {
+	notes, err := h.sync.notes.DeletedNotes(ctx, userID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := make([]models.TrashedNote, 0, len(notes))
+	for _, note := range notes {
+		item := models.TrashedNote{SyncNote: note}
+		if h.trashRetention > 0 {
+			purgeAt := note.DeletedAt.Add(h.trashRetention)
+			item.PurgeAt = &purgeAt
+		}
+		trashed = append(trashed, item)
+	}
+	return trashed, nil
+}
@@ -0,0 +1,207 @@
+// HTTP handlers and middleware for the anonymous trial mode
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// trialTokenHeader carries a trial session's bearer token
+const trialTokenHeader = "X-Trial-Token"
+
+// Rate limits for trials: TrialCreateRateGroup applies per IP to starting trials, trialRateGroup per
+// trial user to every request it makes (they run on the server's shared Gemini key)
+const (
+	TrialCreateRateGroup = "trial_create"
+	trialRateGroup       = "trial"
+)
+
+// DefaultTrialCreateRateLimit applies when RATE_LIMITS has no trial_create entry
+var DefaultTrialCreateRateLimit = services.RateLimit{Requests: 5, Window: time.Hour}
+
+// defaultTrialRateLimit applies when RATE_LIMITS has no trial entry
+var defaultTrialRateLimit = services.RateLimit{Requests: 30, Window: time.Hour}
+
+// TrialHandlers handles trial session HTTP endpoints
+type TrialHandlers struct {
+	db      *services.Database
+	hub     *services.RealtimeHub
+	trials  *services.TrialUsers
+	sealers services.UserMergeSealers
+	limiter *services.RateLimiter
+}
+
+// NewTrialHandlers creates a new TrialHandlers instance. The sealers move a trial's sealed data on upgrade.
+func NewTrialHandlers(db *services.Database, hub *services.RealtimeHub, trials *services.TrialUsers, sealers services.UserMergeSealers) *TrialHandlers {
+	return &TrialHandlers{
+		db:      db,
+		hub:     hub,
+		trials:  trials,
+		sealers: sealers,
+		limiter: services.NewRateLimiter(trialRateGroup, defaultTrialRateLimit),
+	}
+}
+
+// HandleCreateTrial handles POST /api/trial - start an anonymous trial session. Available while the
+// anonymous_trials feature flag is on.
+func (h *TrialHandlers) HandleCreateTrial(w http.ResponseWriter, r *http.Request) {
+	if !services.Config.FeatureEnabled(services.TrialFeatureFlag) {
+		respondWithError(w, "Trials are not available", http.StatusNotFound)
+		return
+	}
+
+	userID, token, expiresAt, err := h.trials.Create(r.Context())
+	if err != nil {
+		log.Printf("Error creating trial user: %v", err)
+		respondWithError(w, "Failed to start trial", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, models.TrialSession{UserID: userID, Token: token, ExpiresAt: expiresAt}, http.StatusCreated)
+}
+
+// HandleUpgradeTrial handles POST /api/trial/upgrade - move everything the trial session in
+// X-Trial-Token created to the signed-in account, then end the trial
+func (h *TrialHandlers) HandleUpgradeTrial(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	token := r.Header.Get(trialTokenHeader)
+	if token == "" {
+		respondWithError(w, "X-Trial-Token header is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	trialID, _, err := h.trials.Authenticate(ctx, token)
+	if errors.Is(err, services.ErrTrialNotFound) {
+		respondWithError(w, "Trial session not found or expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error authenticating trial token: %v", err)
+		respondWithError(w, "Failed to upgrade trial", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user %s: %v", userID, err)
+		respondWithError(w, "Failed to upgrade trial", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := h.db.MergeUsers(ctx, trialID, userID, h.sealers, false)
+	if errors.Is(err, services.ErrMergeUserNotFound) {
+		// Purged since it was authenticated
+		respondWithError(w, "Trial session not found or expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error merging trial user %s into %s: %v", trialID, userID, err)
+		respondWithError(w, "Failed to upgrade trial", http.StatusInternalServerError)
+		return
+	}
+	if len(result.Report.Blockers) > 0 {
+		respondWithJSON(w, result.Report, http.StatusConflict)
+		return
+	}
+
+	// The trial's data is gone, so failing to delete it only leaves an empty user for the purge
+	if err := h.trials.Delete(ctx, trialID); err != nil {
+		log.Printf("Error deleting upgraded trial user %s: %v", trialID, err)
+	}
+
+	h.hub.PublishChanges(userID, result.NoteIDs, result.CollectionIDs)
+	respondWithJSON(w, result.Report, http.StatusOK)
+}
+
+// AllowTrial authenticates requests carrying X-Trial-Token as their trial user and passes other
+// requests through unchanged, for routes that don't otherwise require authentication
+func (h *TrialHandlers) AllowTrial(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(trialTokenHeader) == "" {
+			next(w, r)
+			return
+		}
+		h.authenticateTrial(w, r, next)
+	}
+}
+
+// AuthOrTrial authenticates requests carrying X-Trial-Token as their trial user and all others with
+// AuthMiddleware
+func (h *TrialHandlers) AuthOrTrial(next http.HandlerFunc) http.HandlerFunc {
+	authenticated := AuthMiddleware(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(trialTokenHeader) == "" {
+			authenticated(w, r)
+			return
+		}
+		h.authenticateTrial(w, r, next)
+	}
+}
+
+// Helper functions
+
+// authenticateTrial resolves the request's trial token to its user, rate limits it, and calls next
+// with the trial user in the context
+func (h *TrialHandlers) authenticateTrial(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if !services.Config.FeatureEnabled(services.TrialFeatureFlag) {
+		respondWithError(w, "Trials are not available", http.StatusUnauthorized)
+		return
+	}
+
+	userID, _, err := h.trials.Authenticate(r.Context(), r.Header.Get(trialTokenHeader))
+	if errors.Is(err, services.ErrTrialNotFound) {
+		respondWithError(w, "Invalid or expired trial token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error authenticating trial token: %v", err)
+		respondWithError(w, "Failed to verify trial token", http.StatusServiceUnavailable)
+		return
+	}
+
+	if retryAfter, ok := h.limiter.Allow(userID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithJSON(w, models.ErrorResponse{
+			Error: "Too many requests",
+			Code:  models.ErrCodeRateLimited,
+		}, http.StatusTooManyRequests)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userIDKey, userID)
+	ctx = context.WithValue(ctx, sessionUserIDKey, userID)
+	ctx = context.WithValue(ctx, trialKey, true)
+	next(w, r.WithContext(ctx))
+}
+
+// isTrialRequest reports whether the request was authenticated as a trial user
+func isTrialRequest(r *http.Request) bool {
+	trial, _ := r.Context().Value(trialKey).(bool)
+	return trial
+}
+
+// geminiFor returns the Gemini service a request runs on: one for the caller's own key in their AI
+// region or, for trial requests without one, the server's shared service. release must be called when
+// done with it.
+func geminiFor(r *http.Request, users services.AIUserRepository, apiKey string, shared *services.GeminiService) (gemini *services.GeminiService, release func(), err error) {
+	if apiKey == "" && isTrialRequest(r) && shared != nil {
+		return shared, func() {}, nil
+	}
+	gemini, err = userGemini(r, users, apiKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gemini, gemini.Close, nil
+}
@@ -0,0 +1,148 @@
+// HTTP handlers for server-side user settings
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// conflictPolicies are the accepted sync conflict policies
+var conflictPolicies = map[string]bool{
+	models.ConflictLastWriteWins: true,
+	models.ConflictKeepBoth:      true,
+	models.ConflictReject:        true,
+}
+
+// UserSettingsHandlers handles user settings HTTP endpoints
+type UserSettingsHandlers struct {
+	db *services.Database
+}
+
+// NewUserSettingsHandlers creates a new UserSettingsHandlers instance
+func NewUserSettingsHandlers(db *services.Database) *UserSettingsHandlers {
+	return &UserSettingsHandlers{db: db}
+}
+
+// HandleGetUserSettings handles GET /api/user/settings - the settings the server acts on for the user.
+// These are plaintext, unlike /api/client-settings.
+func (h *UserSettingsHandlers) HandleGetUserSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	settings, err := h.db.UserSettings(ctx, userID)
+	if err != nil {
+		log.Printf("Error fetching user settings: %v", err)
+		respondWithError(w, "Failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, settings, http.StatusOK)
+}
+
+// HandleUpdateUserSettings handles PATCH /api/user/settings - change the settings the server acts on for
+// the user
+func (h *UserSettingsHandlers) HandleUpdateUserSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var req models.UpdateUserSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ConflictPolicy != nil && !conflictPolicies[*req.ConflictPolicy] {
+		respondWithError(w, "conflictPolicy must be last_write_wins, keep_both, or reject", http.StatusBadRequest)
+		return
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+			respondWithError(w, "timezone must be an IANA time zone name such as Europe/Lisbon", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Locale != nil && *req.Locale != "" && normalizeLanguageTag(req.Locale) == nil {
+		respondWithError(w, "locale must be a BCP 47 language tag such as pt-BR", http.StatusBadRequest)
+		return
+	}
+	if req.AIRegion != nil {
+		region := strings.ToLower(strings.TrimSpace(*req.AIRegion))
+		if region != "" && !services.IsProviderRegion(region) {
+			respondWithError(w, fmt.Sprintf("aiRegion must be one of the available regions (%s)",
+				strings.Join(services.ProviderRegions(), ", ")), http.StatusBadRequest)
+			return
+		}
+		req.AIRegion = &region
+	}
+
+	if err := h.db.EnsureUser(ctx, userID, ""); err != nil {
+		log.Printf("Error ensuring user: %v", err)
+	}
+	var settings models.UserSettings
+	var locale, aiRegion sql.NullString
+	err = h.db.DB.QueryRowContext(ctx, `
+		UPDATE users SET
+			conflict_policy = COALESCE($2, conflict_policy),
+			timezone = COALESCE($3, timezone),
+			locale = CASE WHEN $4::text IS NULL THEN locale ELSE NULLIF($4, '') END,
+			ai_region = CASE WHEN $5::text IS NULL THEN ai_region ELSE NULLIF($5, '') END
+		WHERE id = $1
+		RETURNING conflict_policy, timezone, locale, ai_region
+	`, userID, req.ConflictPolicy, req.Timezone, req.Locale, req.AIRegion).
+		Scan(&settings.ConflictPolicy, &settings.Timezone, &locale, &aiRegion)
+	if err != nil {
+		log.Printf("Error updating user settings: %v", err)
+		respondWithError(w, "Failed to update settings", http.StatusInternalServerError)
+		return
+	}
+	if locale.Valid {
+		settings.Locale = &locale.String
+	}
+	if aiRegion.Valid {
+		settings.AIRegion = &aiRegion.String
+	}
+
+	respondWithJSON(w, settings, http.StatusOK)
+}
+
+// HandleAIRegions handles GET /api/user/settings/ai-regions - the AI provider regions available as the
+// aiRegion setting
+func (h *UserSettingsHandlers) HandleAIRegions(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, map[string]interface{}{"regions": services.ProviderRegions()}, http.StatusOK)
+}
+
+// Helper functions
+
+// userLocation returns the user's time zone, falling back to UTC if it can't be loaded
+func userLocation(ctx context.Context, db *services.Database, userID string) *time.Location {
+	settings, err := db.UserSettings(ctx, userID)
+	if err != nil {
+		log.Printf("Error fetching user settings: %v", err)
+		return time.UTC
+	}
+	return timezoneLocation(settings.Timezone)
+}
+
+// timezoneLocation loads a user's timezone setting, falling back to UTC if it can't be loaded
+func timezoneLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Error loading time zone %q: %v", name, err)
+		return time.UTC
+	}
+	return loc
+}
@@ -3,10 +3,15 @@ package main
 
 import (
 	"backend/handlers"
+	"backend/models"
 	"backend/services"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // user time zones must load on the alpine image, which has no zoneinfo
 
 	"github.com/clerk/clerk-sdk-go/v2"
 	"github.com/joho/godotenv"
@@ -14,11 +19,25 @@ import (
 )
 
 func main() {
+	// Mask API keys, tokens, and note titles in everything logged from here on
+	services.RedactLogs(os.Stderr)
+
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using system environment variables")
 	}
 
+	// Label logs, metrics, and responses with this replica's region (optional)
+	services.SetRegion(os.Getenv("REGION"))
+	if err := services.SetProviderRegions(os.Getenv("AI_PROVIDER_REGIONS")); err != nil {
+		log.Fatalf("Invalid AI_PROVIDER_REGIONS: %v", err)
+	}
+
+	// What the log redaction masks: built-in rules by name (all by default) plus extra regexes
+	if err := services.SetRedactionPatterns(os.Getenv("LOG_REDACT_RULES"), os.Getenv("LOG_REDACT_PATTERNS")); err != nil {
+		log.Fatalf("Invalid log redaction config: %v", err)
+	}
+
 	// Initialize Clerk SDK
 	clerkSecretKey := os.Getenv("CLERK_SECRET_KEY")
 	if clerkSecretKey == "" {
@@ -26,11 +45,109 @@ func main() {
 	}
 	clerk.SetKey(clerkSecretKey)
 
+	// Origins allowed to present session tokens (comma-separated; unset accepts any)
+	authorizedParties := os.Getenv("CLERK_AUTHORIZED_PARTIES")
+	handlers.SetAuthorizedParties(strings.Split(authorizedParties, ","))
+	if strings.TrimSpace(authorizedParties) == "" {
+		log.Println("CLERK_AUTHORIZED_PARTIES is not set; session tokens from any origin are accepted")
+	}
+
+	// Admin role (comma-separated Clerk user IDs)
+	handlers.SetAdminUserIDs(strings.Split(os.Getenv("ADMIN_USER_IDS"), ","))
+
+	// AI provider: Gemini, or canned responses without network calls for development and tests
+	if err := services.SetAIProvider(os.Getenv("AI_PROVIDER")); err != nil {
+		log.Fatalf("Invalid AI_PROVIDER: %v", err)
+	}
+	if services.MockAIEnabled() {
+		log.Println("AI_PROVIDER is mock; AI endpoints return canned responses")
+	}
+
 	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
+	if apiKey == "" && !services.MockAIEnabled() {
 		log.Fatal("GEMINI_API_KEY environment variable is required")
 	}
 
+	// Load reloadable runtime settings (log level, rate limits, feature flags)
+	if _, err := services.Config.Reload(); err != nil {
+		log.Fatalf("Invalid runtime configuration: %v", err)
+	}
+
+	// Optional recovery key escrow (disabled without ESCROW_ENCRYPTION_KEY)
+	escrowSealer, err := services.NewSealer(os.Getenv("ESCROW_ENCRYPTION_KEY"))
+	if err != nil {
+		log.Fatalf("Invalid ESCROW_ENCRYPTION_KEY: %v", err)
+	}
+
+	// Optional server-side provider key storage (disabled without PROVIDER_KEY_ENCRYPTION_KEY)
+	providerKeySealer, err := services.NewSealer(os.Getenv("PROVIDER_KEY_ENCRYPTION_KEY"))
+	if err != nil {
+		log.Fatalf("Invalid PROVIDER_KEY_ENCRYPTION_KEY: %v", err)
+	}
+
+	// Optional read-through cache for sync pulls, for replicas far from the database (disabled without PULL_CACHE_TTL)
+	var pullCacheTTL time.Duration
+	if value := os.Getenv("PULL_CACHE_TTL"); value != "" {
+		if pullCacheTTL, err = time.ParseDuration(value); err != nil {
+			log.Fatalf("Invalid PULL_CACHE_TTL: %v", err)
+		}
+	}
+	pullCache := services.NewPullCache(pullCacheTTL)
+
+	// Cache of cleanup and relevant notes responses for identical requests: AI_CACHE_TTL (default 1h, 0
+	// disables), in memory up to AI_CACHE_SIZE responses (default 1000), or shared in Redis with REDIS_URL
+	aiCacheTTL := time.Hour
+	if value := os.Getenv("AI_CACHE_TTL"); value != "" {
+		if aiCacheTTL, err = time.ParseDuration(value); err != nil || aiCacheTTL < 0 {
+			log.Fatalf("Invalid AI_CACHE_TTL: %q", value)
+		}
+	}
+	aiCacheSize := 1000
+	if value := os.Getenv("AI_CACHE_SIZE"); value != "" {
+		if aiCacheSize, err = strconv.Atoi(value); err != nil || aiCacheSize <= 0 {
+			log.Fatalf("Invalid AI_CACHE_SIZE: %q", value)
+		}
+	}
+	var aiCacheStore services.AICacheStore = services.NewMemoryAICacheStore(aiCacheSize)
+	var redisStore *services.RedisAICacheStore
+	if value := os.Getenv("REDIS_URL"); value != "" {
+		if redisStore, err = services.NewRedisAICacheStore(value); err != nil {
+			log.Fatalf("Invalid REDIS_URL: %v", err)
+		}
+		aiCacheStore = redisStore
+	}
+	aiCache := services.NewAICache(aiCacheStore, aiCacheTTL)
+
+	// Deleted notes are purged after TRASH_RETENTION_DAYS (default 30; 0 keeps them)
+	trashRetention := 30 * 24 * time.Hour
+	if value := os.Getenv("TRASH_RETENTION_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			log.Fatalf("Invalid TRASH_RETENTION_DAYS: %q", value)
+		}
+		trashRetention = time.Duration(days) * 24 * time.Hour
+	}
+
+	// Nightly semantic search maintenance, optionally (re)building an approximate index
+	vectorIndex, err := services.ParseVectorIndexSpec(os.Getenv("VECTOR_INDEX"))
+	if err != nil {
+		log.Fatalf("Invalid VECTOR_INDEX: %v", err)
+	}
+	maintenanceWindow := services.DefaultMaintenanceWindow
+	if value := os.Getenv("VECTOR_MAINTENANCE_WINDOW"); value != "" {
+		if maintenanceWindow, err = services.ParseMaintenanceWindow(value); err != nil {
+			log.Fatalf("Invalid VECTOR_MAINTENANCE_WINDOW: %v", err)
+		}
+	}
+
+	// Anonymous trial users are deleted after TRIAL_TTL (default 24h)
+	trialTTL := 24 * time.Hour
+	if value := os.Getenv("TRIAL_TTL"); value != "" {
+		if trialTTL, err = time.ParseDuration(value); err != nil || trialTTL <= 0 {
+			log.Fatalf("Invalid TRIAL_TTL: %q", value)
+		}
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -52,8 +169,60 @@ func main() {
 		log.Fatalf("Failed to initialize Gemini service: %v", err)
 	}
 
+	// Realtime hub for WebSocket presence
+	realtimeHub := services.NewRealtimeHub()
+	if pullCache != nil {
+		realtimeHub.OnChange(pullCache.Invalidate) // Every write path publishes its changes
+	}
+
+	// Background job queue (imports)
+	jobQueue := services.NewJobQueue(database, 2)
+	jobQueue.Register(models.JobTypeImport, services.RunImportJob)
+	jobQueue.Start()
+
+	// Semantic search indexing (uses each user's stored Gemini key)
+	embeddingIndexer := services.NewEmbeddingIndexer(database, providerKeySealer)
+	embeddingIndexer.Start()
+	vectorMaintainer := services.NewVectorMaintainer(database, vectorIndex, maintenanceWindow)
+
+	// Permanent deletion of notes that were in the trash longer than the retention
+	trashPurger := services.NewTrashPurger(database, trashRetention)
+
+	// Snoozed notes come back (and notify, if asked to) once their snooze ends
+	noteSnoozer := services.NewNoteSnoozer(database, realtimeHub)
+
+	// Anonymous trial users (issued while the anonymous_trials feature flag is on) and their purging
+	trialUsers := services.NewTrialUsers(database, trialTTL)
+
+	// SLO monitoring on top of the request metrics
+	sloMonitor := services.NewSLOMonitor(services.Metrics)
+
+	// Dependency health history
+	healthMonitor := services.NewHealthMonitor(database,
+		services.HealthCheck{Dependency: "database", Check: database.Ping},
+		services.HealthCheck{Dependency: "gemini", Check: geminiService.Ping},
+	)
+
+	// Optional persisted chat sessions (disabled without CHAT_ENCRYPTION_KEY)
+	chatSealer, err := services.NewSealer(os.Getenv("CHAT_ENCRYPTION_KEY"))
+	if err != nil {
+		log.Fatalf("Invalid CHAT_ENCRYPTION_KEY: %v", err)
+	}
+
 	// Set up cleanup after all initialization succeeds
 	defer func() {
+		healthMonitor.Close()
+		sloMonitor.Close()
+		jobQueue.Close()
+		trialUsers.Close()
+		trashPurger.Close()
+		noteSnoozer.Close()
+		vectorMaintainer.Close()
+		embeddingIndexer.Close()
+		realtimeHub.Close()
+		if redisStore != nil {
+			redisStore.Close()
+		}
 		if err := database.Close(); err != nil {
 			log.Printf("Error closing database: %v", err)
 		}
@@ -61,38 +230,230 @@ func main() {
 	}()
 
 	// Initialize handlers
-	aiHandlers := handlers.NewAIHandlers(geminiService)
-	syncHandlers := handlers.NewSyncHandlers(database)
+	aiHandlers := handlers.NewAIHandlers(database, geminiService, aiCache)
+	syncHandlers := handlers.NewSyncHandlers(database, database, database, realtimeHub, embeddingIndexer, pullCache, trashRetention)
+	signingHandlers := handlers.NewSigningHandlers(database)
+	collectionHandlers := handlers.NewCollectionHandlers(database, realtimeHub)
+	settingsHandlers := handlers.NewSettingsHandlers(database)
+	mergeSealers := services.UserMergeSealers{
+		Escrow:       escrowSealer,
+		ProviderKeys: providerKeySealer,
+		Chat:         chatSealer,
+	}
+	adminHandlers := handlers.NewAdminHandlers(database, realtimeHub, mergeSealers)
+	realtimeHandlers := handlers.NewRealtimeHandlers(database, realtimeHub)
+	opsHandlers := handlers.NewOpsHandlers(database, sloMonitor)
+	jobHandlers := handlers.NewJobHandlers(jobQueue)
+	stagedNoteHandlers := handlers.NewStagedNoteHandlers(database)
+	statsHandlers := handlers.NewStatsHandlers(database)
+	encryptionHandlers := handlers.NewEncryptionHandlers(database)
+	captureRuleHandlers := handlers.NewCaptureRuleHandlers(database)
+	publicTokenGuard := services.NewPublicTokenGuard(database)
+	inboxHandlers := handlers.NewInboxHandlers(database, publicTokenGuard)
+	shareHandlers := handlers.NewShareHandlers(database, publicTokenGuard)
+	clientErrorHandlers := handlers.NewClientErrorHandlers(database)
+	noteHandlers := handlers.NewNoteHandlers(database, realtimeHub)
+	dataExportHandlers := handlers.NewDataExportHandlers(database, database, database)
+	noteAPIHandlers := handlers.NewNoteAPIHandlers(database, syncHandlers, trashRetention)
+	escrowHandlers := handlers.NewEscrowHandlers(database, escrowSealer, services.NewMailer())
+	providerKeyHandlers := handlers.NewProviderKeyHandlers(database, providerKeySealer)
+	telemetryHandlers := handlers.NewTelemetryHandlers(database, os.Getenv("TELEMETRY_SECRET"))
+	userSettingsHandlers := handlers.NewUserSettingsHandlers(database)
+	semanticSearchHandlers := handlers.NewSemanticSearchHandlers(database, embeddingIndexer)
+	collectionOverviewHandlers := handlers.NewCollectionOverviewHandlers(database, embeddingIndexer)
+	quickSearchHandlers := handlers.NewQuickSearchHandlers(database)
+	hybridSearchHandlers := handlers.NewHybridSearchHandlers(database, embeddingIndexer)
+	searchHistoryHandlers := handlers.NewSearchHistoryHandlers(database)
+	chatHandlers := handlers.NewChatHandlers(database, chatSealer, geminiService)
+	trialHandlers := handlers.NewTrialHandlers(database, realtimeHub, trialUsers, mergeSealers)
+	statusHandlers := handlers.NewStatusHandlers(healthMonitor, sloMonitor)
+
+	// Per-caller rate limits for the AI and sync route groups (RATE_LIMITS entries "ai" and "sync")
+	aiRateLimit := handlers.RateLimitMiddleware(handlers.AIRateGroup, handlers.DefaultAIRateLimit)
+	syncRateLimit := handlers.RateLimitMiddleware(handlers.SyncRateGroup, handlers.DefaultSyncRateLimit)
+	trialCreateRateLimit := handlers.RateLimitMiddleware(handlers.TrialCreateRateGroup, handlers.DefaultTrialCreateRateLimit)
+
+	// aiRoute authenticates callers that send a session token (so their AI exclusions, region, and stored
+	// key apply), falls back to the stored provider key, and rate limits
+	aiRoute := func(next http.HandlerFunc) http.HandlerFunc {
+		return handlers.OptionalAuth(providerKeyHandlers.WithStoredKey(aiRateLimit(next)))
+	}
+
+	// syncRoute authenticates, rate limits, enforces request signing, and tracks the request for draining
+	syncRoute := func(next http.HandlerFunc) http.HandlerFunc {
+		return handlers.AuthMiddleware(syncRateLimit(signingHandlers.VerifySignature(opsHandlers.TrackSync(next))))
+	}
 
 	// Setup routes
 	mux := http.NewServeMux()
 
-	// AI routes
-	mux.HandleFunc("/api/chat", aiHandlers.HandleChat)
-	mux.HandleFunc("/api/notes/relevant", aiHandlers.HandleRelevantNotes)
-	mux.HandleFunc("/api/notes/cleanup", aiHandlers.HandleCleanup)
-	mux.HandleFunc("/api/validate-key", aiHandlers.HandleValidateKey)
+	// AI routes (rate limited per user, API key, or IP)
+	mux.HandleFunc("POST /api/chat", trialHandlers.AllowTrial(aiRoute(aiHandlers.HandleChat)))
+	mux.HandleFunc("GET /api/chat/sessions", trialHandlers.AuthOrTrial(chatHandlers.HandleListChatSessions))
+	mux.HandleFunc("POST /api/chat/sessions", trialHandlers.AuthOrTrial(chatHandlers.HandleCreateChatSession))
+	mux.HandleFunc("DELETE /api/chat/sessions/{id}", trialHandlers.AuthOrTrial(chatHandlers.HandleDeleteChatSession))
+	mux.HandleFunc("GET /api/chat/sessions/{id}/messages", trialHandlers.AuthOrTrial(aiRoute(chatHandlers.HandleListChatMessages)))
+	mux.HandleFunc("POST /api/chat/sessions/{id}/messages", trialHandlers.AuthOrTrial(aiRoute(chatHandlers.HandleSendChatMessage)))
+	mux.HandleFunc("POST /api/notes/relevant", aiRoute(aiHandlers.HandleRelevantNotes))
+	mux.HandleFunc("POST /api/notes/cleanup", trialHandlers.AllowTrial(aiRoute(aiHandlers.HandleCleanup)))
+	mux.HandleFunc("POST /api/notes/translate", aiRoute(aiHandlers.HandleTranslate))
+	mux.HandleFunc("POST /api/notes/summarize", trialHandlers.AllowTrial(aiRoute(aiHandlers.HandleSummarize)))
+	mux.HandleFunc("POST /api/notes/actions", aiRoute(aiHandlers.HandleExtractActions))
+	mux.HandleFunc("POST /api/notes/title", aiRoute(aiHandlers.HandleGenerateTitle))
+	mux.HandleFunc("POST /api/notes/title/batch", aiRoute(aiHandlers.HandleGenerateTitles))
+	mux.HandleFunc("POST /api/trial", trialCreateRateLimit(trialHandlers.HandleCreateTrial))
+	mux.HandleFunc("POST /api/trial/upgrade", handlers.AuthMiddleware(trialHandlers.HandleUpgradeTrial))
+	mux.HandleFunc("POST /api/notes/append-smart", aiRoute(aiHandlers.HandleSmartAppend))
+	mux.HandleFunc("POST /api/validate-key", aiRoute(aiHandlers.HandleValidateKey))
+	mux.HandleFunc("POST /api/capture/audio", aiRoute(aiHandlers.HandleCaptureAudio))
+	mux.HandleFunc("POST /api/transcribe", aiRoute(aiHandlers.HandleTranscribe))
+	mux.HandleFunc("POST /api/ocr", aiRoute(aiHandlers.HandleOCR))
+	mux.HandleFunc("POST /api/clip", trialHandlers.AuthOrTrial(aiRoute(aiHandlers.HandleClip)))
+	mux.HandleFunc("POST /api/ai/count-tokens", aiRoute(aiHandlers.HandleCountTokens))
 
-	// Sync routes (protected with auth middleware)
-	mux.HandleFunc("/api/sync/notes", handlers.AuthMiddleware(syncHandlers.HandleSyncNotes))
-	mux.HandleFunc("/api/sync/push", handlers.AuthMiddleware(syncHandlers.HandleSyncPush))
+	// Capture inbox routes (token management is protected; the inbox itself is public but signed, single-use, and rate limited)
+	mux.HandleFunc("POST /api/capture/inbox-tokens", handlers.AuthMiddleware(inboxHandlers.HandleCreateToken))
+	mux.HandleFunc("DELETE /api/capture/inbox-tokens/{id}", handlers.AuthMiddleware(inboxHandlers.HandleRevokeToken))
+	mux.HandleFunc("POST /api/capture/inbox/{tokenId}", inboxHandlers.HandleInboxCapture)
 
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		if _, err := w.Write([]byte("OK")); err != nil {
-			log.Printf("Error writing health check response: %v", err)
-		}
-	})
+	// Share link route (public, rate limited per link; password-protected links need X-Share-Password)
+	mux.HandleFunc("GET /share/{token}", shareHandlers.HandleSharedNote)
+
+	// Capture rule routes (protected with auth middleware)
+	mux.HandleFunc("GET /api/capture/rules", handlers.AuthMiddleware(captureRuleHandlers.HandleListCaptureRules))
+	mux.HandleFunc("POST /api/capture/rules", handlers.AuthMiddleware(captureRuleHandlers.HandleCreateCaptureRule))
+	mux.HandleFunc("GET /api/capture/rules/match", handlers.AuthMiddleware(captureRuleHandlers.HandleMatchCaptureRule))
+	mux.HandleFunc("PATCH /api/capture/rules/{id}", handlers.AuthMiddleware(captureRuleHandlers.HandleUpdateCaptureRule))
+	mux.HandleFunc("DELETE /api/capture/rules/{id}", handlers.AuthMiddleware(captureRuleHandlers.HandleDeleteCaptureRule))
+
+	// Sync routes (protected with auth middleware, signed once the user registers a signing key)
+	mux.HandleFunc("GET /api/sync/notes", syncRoute(syncHandlers.HandleSyncNotes))
+	mux.HandleFunc("POST /api/sync/push", syncRoute(syncHandlers.HandleSyncPush))
+	mux.HandleFunc("GET /api/sync/verify", syncRoute(syncHandlers.HandleSyncVerify))
+	mux.HandleFunc("POST /api/sync/repair", syncRoute(syncHandlers.HandleSyncRepair))
+	mux.HandleFunc("POST /api/sync/signing-keys", handlers.AuthMiddleware(signingHandlers.VerifySignature(signingHandlers.HandleCreateKey)))
+	mux.HandleFunc("DELETE /api/sync/signing-keys/{id}", handlers.AuthMiddleware(signingHandlers.VerifySignature(signingHandlers.HandleRevokeKey)))
+
+	// User settings routes (protected with auth middleware)
+	mux.HandleFunc("GET /api/user/settings", handlers.AuthMiddleware(userSettingsHandlers.HandleGetUserSettings))
+	mux.HandleFunc("PATCH /api/user/settings", handlers.AuthMiddleware(userSettingsHandlers.HandleUpdateUserSettings))
+	mux.HandleFunc("GET /api/user/settings/ai-regions", handlers.AuthMiddleware(userSettingsHandlers.HandleAIRegions))
+
+	// Client error reports (protected with auth middleware)
+	mux.HandleFunc("POST /api/client-errors", handlers.AuthMiddleware(clientErrorHandlers.HandleReportError))
+
+	// Stored provider key routes (protected with auth middleware)
+	mux.HandleFunc("GET /api/provider-keys", handlers.AuthMiddleware(providerKeyHandlers.HandleListProviderKeys))
+	mux.HandleFunc("GET /api/provider-keys/events", handlers.AuthMiddleware(providerKeyHandlers.HandleListProviderKeyEvents))
+	mux.HandleFunc("PUT /api/provider-keys/{provider}", handlers.AuthMiddleware(providerKeyHandlers.HandlePutProviderKey))
+	mux.HandleFunc("DELETE /api/provider-keys/{provider}", handlers.AuthMiddleware(providerKeyHandlers.HandleDeleteProviderKey))
+	mux.HandleFunc("GET /api/settings/ai-key", handlers.AuthMiddleware(providerKeyHandlers.HandleGetAIKey))
+	mux.HandleFunc("PUT /api/settings/ai-key", handlers.AuthMiddleware(providerKeyHandlers.HandlePutAIKey))
+	mux.HandleFunc("DELETE /api/settings/ai-key", handlers.AuthMiddleware(providerKeyHandlers.HandleDeleteAIKey))
+
+	// Telemetry routes (protected with auth middleware; nothing is recorded without opt-in)
+	mux.HandleFunc("GET /api/telemetry/consent", handlers.AuthMiddleware(telemetryHandlers.HandleGetTelemetryConsent))
+	mux.HandleFunc("PUT /api/telemetry/consent", handlers.AuthMiddleware(telemetryHandlers.HandlePutTelemetryConsent))
+	mux.HandleFunc("POST /api/telemetry/events", handlers.AuthMiddleware(telemetryHandlers.HandleRecordUsage))
+
+	// Note routes: REST notes, metadata, graph, timeline, and exports are authenticated, rate limited, and
+	// signed like sync; share routes are protected with auth middleware
+	mux.HandleFunc("GET /api/notes", syncRoute(noteAPIHandlers.HandleListNotes))
+	mux.HandleFunc("POST /api/notes", syncRoute(noteAPIHandlers.HandleCreateNote))
+	mux.HandleFunc("GET /api/notes/{id}", syncRoute(noteAPIHandlers.HandleGetNote))
+	mux.HandleFunc("PUT /api/notes/{id}", syncRoute(noteAPIHandlers.HandlePutNote))
+	mux.HandleFunc("DELETE /api/notes/{id}", syncRoute(noteAPIHandlers.HandleDeleteNote))
+	mux.HandleFunc("GET /api/notes/{id}/revisions", syncRoute(noteAPIHandlers.HandleNoteRevisions))
+	mux.HandleFunc("POST /api/notes/{id}/restore", syncRoute(noteAPIHandlers.HandleRestoreNote))
+	mux.HandleFunc("POST /api/notes/{id}/purge", syncRoute(noteAPIHandlers.HandlePurgeNote))
+	mux.HandleFunc("GET /api/notes/trash", syncRoute(noteAPIHandlers.HandleTrash))
+	mux.HandleFunc("PATCH /api/notes/{id}/meta", syncRoute(noteHandlers.HandlePatchNoteMeta))
+	mux.HandleFunc("GET /api/notes/graph", syncRoute(noteHandlers.HandleNoteGraph))
+	mux.HandleFunc("GET /api/notes/{id}/timeline", syncRoute(noteHandlers.HandleNoteTimeline))
+	mux.HandleFunc("POST /api/notes/{id}/export", syncRoute(noteHandlers.HandleExportNote))
+	mux.HandleFunc("GET /api/notes/{id}/share", handlers.AuthMiddleware(shareHandlers.HandleListShareLinks))
+	mux.HandleFunc("POST /api/notes/{id}/share", handlers.AuthMiddleware(shareHandlers.HandleCreateShareLink))
+	mux.HandleFunc("DELETE /api/notes/{id}/share", handlers.AuthMiddleware(shareHandlers.HandleRevokeShareLinks))
+	mux.HandleFunc("DELETE /api/notes/{id}/share/{shareId}", handlers.AuthMiddleware(shareHandlers.HandleRevokeShareLink))
+	mux.HandleFunc("GET /api/notes/{id}/share/{shareId}/analytics", handlers.AuthMiddleware(shareHandlers.HandleShareLinkAnalytics))
+	mux.HandleFunc("GET /api/export", syncRoute(dataExportHandlers.HandleDataExport))
+
+	// Search routes (protected with auth middleware)
+	mux.HandleFunc("POST /api/notes/semantic-search", handlers.AuthMiddleware(semanticSearchHandlers.HandleSemanticSearch))
+	mux.HandleFunc("GET /api/notes/semantic-search/stale", handlers.AuthMiddleware(semanticSearchHandlers.HandleStaleEmbeddings))
+	mux.HandleFunc("GET /api/quicksearch", handlers.AuthMiddleware(quickSearchHandlers.HandleQuickSearch))
+	mux.HandleFunc("GET /api/search/hybrid", handlers.AuthMiddleware(hybridSearchHandlers.HandleHybridSearch))
+	mux.HandleFunc("GET /api/search/recent", handlers.AuthMiddleware(searchHistoryHandlers.HandleRecentSearches))
+	mux.HandleFunc("DELETE /api/search/recent", handlers.AuthMiddleware(searchHistoryHandlers.HandleClearRecentSearches))
+	mux.HandleFunc("GET /api/search/suggest", handlers.AuthMiddleware(searchHistoryHandlers.HandleSearchSuggestions))
+
+	// Collection routes (protected with auth middleware; changes are signed and rate limited like sync)
+	mux.HandleFunc("GET /api/collections", handlers.AuthMiddleware(collectionHandlers.HandleListCollections))
+	mux.HandleFunc("POST /api/collections", syncRoute(collectionHandlers.HandleCreateCollection))
+	mux.HandleFunc("GET /api/collections/{id}", handlers.AuthMiddleware(collectionHandlers.HandleGetCollection))
+	mux.HandleFunc("PATCH /api/collections/{id}", syncRoute(collectionHandlers.HandleUpdateCollection))
+	mux.HandleFunc("DELETE /api/collections/{id}", syncRoute(collectionHandlers.HandleDeleteCollection))
+	mux.HandleFunc("GET /api/collections/{id}/overview", handlers.AuthMiddleware(collectionOverviewHandlers.HandleCollectionOverview))
+
+	// Import and job routes (protected with auth middleware)
+	mux.HandleFunc("POST /api/import", handlers.AuthMiddleware(jobHandlers.HandleCreateImport))
+	mux.HandleFunc("GET /api/jobs/{id}", handlers.AuthMiddleware(jobHandlers.HandleGetJob))
+
+	// Staged notes inbox routes (protected with auth middleware)
+	mux.HandleFunc("GET /api/staged-notes", handlers.AuthMiddleware(stagedNoteHandlers.HandleListStagedNotes))
+	mux.HandleFunc("DELETE /api/staged-notes/{id}", handlers.AuthMiddleware(stagedNoteHandlers.HandleDeleteStagedNote))
+	mux.HandleFunc("POST /api/staged-notes/{id}/claim", handlers.AuthMiddleware(stagedNoteHandlers.HandleClaimStagedNote))
+
+	// Stats routes (protected with auth middleware)
+	mux.HandleFunc("GET /api/stats/languages", handlers.AuthMiddleware(statsHandlers.HandleLanguageStats))
+
+	// Encryption metadata routes (protected with auth middleware)
+	mux.HandleFunc("GET /api/encryption/metadata", handlers.AuthMiddleware(encryptionHandlers.HandleGetEncryptionMetadata))
+	mux.HandleFunc("PUT /api/encryption/metadata", handlers.AuthMiddleware(encryptionHandlers.HandlePutEncryptionMetadata))
+	mux.HandleFunc("GET /api/encryption/escrow", handlers.AuthMiddleware(escrowHandlers.HandleGetKeyEscrow))
+	mux.HandleFunc("PUT /api/encryption/escrow", handlers.AuthMiddleware(escrowHandlers.HandlePutKeyEscrow))
+	mux.HandleFunc("DELETE /api/encryption/escrow", handlers.AuthMiddleware(escrowHandlers.HandleDeleteKeyEscrow))
+	mux.HandleFunc("POST /api/encryption/escrow/recovery", handlers.AuthMiddleware(escrowHandlers.HandleStartRecovery))
+	mux.HandleFunc("POST /api/encryption/escrow/recovery/verify", handlers.AuthMiddleware(escrowHandlers.HandleVerifyRecovery))
+
+	// Settings routes (protected with auth middleware)
+	mux.HandleFunc("GET /api/client-settings", handlers.AuthMiddleware(settingsHandlers.HandleListClientSettings))
+	mux.HandleFunc("PUT /api/client-settings/{key}", handlers.AuthMiddleware(settingsHandlers.HandlePutClientSetting))
+	mux.HandleFunc("DELETE /api/client-settings/{key}", handlers.AuthMiddleware(settingsHandlers.HandleDeleteClientSetting))
+
+	// Realtime routes (WebSocket; token may be passed as ?token= since browsers can't set headers)
+	mux.HandleFunc("GET /api/realtime", handlers.TokenFromQuery(handlers.AuthMiddleware(realtimeHandlers.HandleConnect)))
+
+	// Admin routes (protected with admin middleware, all access is audit-logged)
+	mux.HandleFunc("GET /api/admin/impersonate/{userId}/sync", handlers.AdminMiddleware(adminHandlers.HandleImpersonateSync))
+	mux.HandleFunc("POST /api/admin/users/merge", handlers.AdminMiddleware(adminHandlers.HandleMergeUsers))
+	mux.HandleFunc("POST /api/admin/drain", handlers.AdminMiddleware(opsHandlers.HandleDrain))
+	mux.HandleFunc("POST /api/admin/resume", handlers.AdminMiddleware(opsHandlers.HandleResume))
+	mux.HandleFunc("GET /api/admin/config", handlers.AdminMiddleware(opsHandlers.HandleGetConfig))
+	mux.HandleFunc("POST /api/admin/config", handlers.AdminMiddleware(opsHandlers.HandleReloadConfig))
+	mux.HandleFunc("GET /api/admin/slo", handlers.AdminMiddleware(opsHandlers.HandleSLO))
+	mux.HandleFunc("GET /api/admin/health/history", handlers.AdminMiddleware(opsHandlers.HandleHealthHistory))
+	mux.HandleFunc("GET /api/admin/client-errors", handlers.AdminMiddleware(clientErrorHandlers.HandleListClientErrors))
+	mux.HandleFunc("GET /api/admin/telemetry/usage", handlers.AdminMiddleware(telemetryHandlers.HandleUsageReport))
+
+	mux.HandleFunc("GET /health", opsHandlers.HandleHealth)
+	mux.HandleFunc("GET /status", statusHandlers.HandleStatus)
 
 	// Setup CORS
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{
+			"Content-Type", "Authorization", "X-API-Key", "X-Device-ID", "X-Account-ID",
+			"X-Signature-Key-ID", "X-Signature-Timestamp", "X-Signature", "X-Request-ID", "X-Preferred-Region",
+			"X-Trial-Token", "X-Share-Password", "X-AI-Region",
+		},
+		ExposedHeaders:   []string{"X-Request-ID", "Retry-After", "X-Region", "X-Cache", "Cache-Status", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
 		AllowCredentials: false, // Must be false when using "*" for origins
 	})
 
-	handler := c.Handler(mux)
+	// Metrics and request logs sit inside CORS so preflight requests aren't counted
+	handler := c.Handler(handlers.RegionMiddleware(handlers.RequestLogMiddleware(handlers.MetricsMiddleware(mux))))
 
 	// Start server
 	log.Printf("Server starting on port %s...", port)
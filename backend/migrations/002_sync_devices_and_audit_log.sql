@@ -0,0 +1,30 @@
+-- Per-device sync state and admin audit log
+-- Neon PostgreSQL database
+
+-- Sync devices table (last pull/push per client device, used to diagnose sync lag)
+CREATE TABLE IF NOT EXISTS sync_devices (
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(255) NOT NULL, -- Client-generated ID sent via X-Device-ID header
+    last_pull_at TIMESTAMP WITH TIME ZONE,
+    last_push_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, device_id)
+);
+
+-- Admin audit log (every admin/support action is recorded here)
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    admin_user_id VARCHAR(255) NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    target_user_id VARCHAR(255),
+    details JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_target_user_id ON admin_audit_log(target_user_id);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_created_at ON admin_audit_log(created_at);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS admin_audit_log;
+DROP TABLE IF EXISTS sync_devices;
@@ -0,0 +1,19 @@
+-- Soft delete for collections
+-- Neon PostgreSQL database
+
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Names only need to be unique among live collections, so a deleted name can be reused
+ALTER TABLE collections DROP CONSTRAINT IF EXISTS collections_user_id_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_collections_user_id_name_active ON collections(user_id, name) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_collections_deleted_at ON collections(deleted_at);
+
+-- migrate:down
+
+-- Soft-deleted collections are purged so the original per-user unique name constraint can return
+DELETE FROM collections WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_collections_deleted_at;
+DROP INDEX IF EXISTS idx_collections_user_id_name_active;
+ALTER TABLE collections DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE collections ADD CONSTRAINT collections_user_id_name_key UNIQUE (user_id, name);
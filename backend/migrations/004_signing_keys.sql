@@ -0,0 +1,19 @@
+-- Request signing keys for extension clients
+-- Neon PostgreSQL database
+
+-- Signing keys table (HMAC secrets; once a user has an active key, sync requests must be signed)
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    secret BYTEA NOT NULL,
+    label VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_user_id ON signing_keys(user_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS signing_keys;
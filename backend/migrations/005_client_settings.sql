@@ -0,0 +1,19 @@
+-- Encrypted client settings (editor preferences, AI settings, collection order)
+-- Neon PostgreSQL database
+
+CREATE TABLE IF NOT EXISTS client_settings (
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key VARCHAR(100) NOT NULL,
+    value_encrypted BYTEA NOT NULL, -- Encrypted value (empty once deleted)
+    value_iv BYTEA NOT NULL, -- Initialization vector for AES-GCM
+    version BIGINT NOT NULL DEFAULT 1, -- Incremented on every write for optimistic concurrency
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP WITH TIME ZONE, -- Soft delete so other devices learn about removals
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_client_settings_updated_at ON client_settings(user_id, updated_at);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS client_settings;
@@ -0,0 +1,20 @@
+-- Advisory per-note locks for destructive operations (merge, restore, purge)
+-- Neon PostgreSQL database
+
+-- Lease-style locks: a lock whose expires_at has passed may be taken over
+CREATE TABLE IF NOT EXISTS note_locks (
+    note_id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    lock_token VARCHAR(64) NOT NULL,
+    operation VARCHAR(50) NOT NULL, -- merge, restore, purge
+    holder_user_id VARCHAR(255) NOT NULL, -- Session user holding the lock
+    device_id VARCHAR(255),
+    acquired_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_locks_user_id ON note_locks(user_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS note_locks;
@@ -0,0 +1,26 @@
+-- Background jobs (imports and other long-running work)
+-- Neon PostgreSQL database
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued', -- queued, running, completed, failed
+    input BYTEA, -- Uploaded payload, cleared once the job finishes
+    params JSONB,
+    progress_current INTEGER NOT NULL DEFAULT 0,
+    progress_total INTEGER NOT NULL DEFAULT 0,
+    errors JSONB NOT NULL DEFAULT '[]', -- Per-item (partial) errors
+    result JSONB,
+    error TEXT, -- Fatal error for failed jobs
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_user_id ON jobs(user_id);
+CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS jobs;
@@ -0,0 +1,27 @@
+-- Staged notes inbox for server-originated content (imports, email capture, clipper)
+-- Neon PostgreSQL database
+
+-- Staged notes hold PLAINTEXT because the server can't encrypt for the user. A client claims an item,
+-- encrypts it into a real note, pushes it through sync, and then deletes the staging copy.
+CREATE TABLE IF NOT EXISTS staged_notes (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    source VARCHAR(50) NOT NULL, -- import, email, clipper
+    source_ref VARCHAR(255), -- e.g. the import job ID
+    title TEXT NOT NULL DEFAULT '',
+    content TEXT NOT NULL,
+    collection_name VARCHAR(255), -- Suggested collection (folder/notebook name)
+    original_date TIMESTAMP WITH TIME ZONE,
+    claim_token VARCHAR(64),
+    claimed_by_device VARCHAR(255),
+    claimed_until TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL -- Plaintext isn't kept around forever
+);
+
+CREATE INDEX IF NOT EXISTS idx_staged_notes_user_id ON staged_notes(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_staged_notes_expires_at ON staged_notes(expires_at);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS staged_notes;
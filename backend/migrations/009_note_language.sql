@@ -0,0 +1,11 @@
+-- Client-reported note language (content is encrypted, so the server can't detect it)
+-- Neon PostgreSQL database
+
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS language VARCHAR(35); -- BCP 47 tag, e.g. "en" or "pt-BR"
+
+CREATE INDEX IF NOT EXISTS idx_notes_user_language ON notes(user_id, language) WHERE deleted_at IS NULL;
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_notes_user_language;
+ALTER TABLE notes DROP COLUMN IF EXISTS language;
@@ -0,0 +1,30 @@
+-- Capture inbox tokens and replay protection for public tokenized endpoints
+-- Neon PostgreSQL database
+
+-- Capture inbox tokens let email forwarders and the web clipper post into a user's staged notes without a session
+CREATE TABLE IF NOT EXISTS capture_inbox_tokens (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    secret BYTEA NOT NULL, -- HMAC secret; never sent on the wire after creation
+    label VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_capture_inbox_tokens_user_id ON capture_inbox_tokens(user_id);
+
+-- Nonces seen on public tokenized requests, kept until the request's expiry has passed
+CREATE TABLE IF NOT EXISTS token_nonces (
+    token_id VARCHAR(255) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (token_id, nonce)
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_nonces_expires_at ON token_nonces(expires_at);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS token_nonces;
+DROP TABLE IF EXISTS capture_inbox_tokens;
@@ -0,0 +1,22 @@
+-- Per-user E2E key-derivation metadata so new devices can bootstrap decryption
+-- Neon PostgreSQL database
+
+-- Nothing here is secret on its own: the master key is only stored wrapped by a passphrase- or recovery-derived key
+CREATE TABLE IF NOT EXISTS encryption_metadata (
+    user_id VARCHAR(255) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    key_version INTEGER NOT NULL,
+    kdf_algorithm VARCHAR(50) NOT NULL, -- pbkdf2-sha256, argon2id
+    kdf_params JSONB NOT NULL,
+    salt BYTEA NOT NULL,
+    wrapped_key BYTEA NOT NULL, -- Master key wrapped with the passphrase-derived key
+    wrapped_key_iv BYTEA NOT NULL,
+    recovery_wrapped_key BYTEA, -- Master key wrapped with the user's recovery key (optional)
+    recovery_wrapped_key_iv BYTEA,
+    version BIGINT NOT NULL DEFAULT 1, -- Optimistic concurrency
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS encryption_metadata;
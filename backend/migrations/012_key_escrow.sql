@@ -0,0 +1,32 @@
+-- Opt-in recovery key escrow with email-verified retrieval
+-- Neon PostgreSQL database
+
+-- The master key wrapped with a client-generated escrow secret. The secret is sealed with the server's
+-- ESCROW_ENCRYPTION_KEY and only released after the user verifies a code sent to their email.
+CREATE TABLE IF NOT EXISTS key_escrow (
+    user_id VARCHAR(255) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    key_version INTEGER NOT NULL,
+    wrapped_key BYTEA NOT NULL,
+    wrapped_key_iv BYTEA NOT NULL,
+    sealed_secret BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Email verification codes for escrow recovery
+CREATE TABLE IF NOT EXISTS escrow_recovery_requests (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash BYTEA NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    consumed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_escrow_recovery_requests_user_id ON escrow_recovery_requests(user_id, created_at);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS escrow_recovery_requests;
+DROP TABLE IF EXISTS key_escrow;
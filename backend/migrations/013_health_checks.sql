@@ -0,0 +1,18 @@
+-- Dependency health check history
+-- Neon PostgreSQL database
+
+CREATE TABLE IF NOT EXISTS health_checks (
+    id BIGSERIAL PRIMARY KEY,
+    dependency VARCHAR(50) NOT NULL, -- 'database', 'gemini'
+    status VARCHAR(20) NOT NULL, -- 'ok', 'down'
+    latency_ms INTEGER NOT NULL,
+    error TEXT,
+    checked_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_checks_checked_at ON health_checks(checked_at DESC);
+CREATE INDEX IF NOT EXISTS idx_health_checks_dependency ON health_checks(dependency, checked_at DESC);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS health_checks;
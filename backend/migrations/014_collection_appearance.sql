@@ -0,0 +1,14 @@
+-- Collection color, description, ordering, and cover
+-- Neon PostgreSQL database
+
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS color VARCHAR(7); -- '#RRGGBB'
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS sort_index INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS cover TEXT; -- Image URL or client preset key
+
+-- migrate:down
+
+ALTER TABLE collections DROP COLUMN IF EXISTS cover;
+ALTER TABLE collections DROP COLUMN IF EXISTS sort_index;
+ALTER TABLE collections DROP COLUMN IF EXISTS description;
+ALTER TABLE collections DROP COLUMN IF EXISTS color;
@@ -0,0 +1,17 @@
+-- Plaintext note metadata that can change without re-uploading the encrypted body
+-- Neon PostgreSQL database
+
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS is_archived BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS reminder_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_notes_tags ON notes USING GIN (tags);
+CREATE INDEX IF NOT EXISTS idx_notes_reminder_at ON notes(reminder_at) WHERE reminder_at IS NOT NULL;
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_notes_reminder_at;
+DROP INDEX IF EXISTS idx_notes_tags;
+ALTER TABLE notes DROP COLUMN IF EXISTS reminder_at;
+ALTER TABLE notes DROP COLUMN IF EXISTS tags;
+ALTER TABLE notes DROP COLUMN IF EXISTS is_archived;
@@ -0,0 +1,27 @@
+-- Client (extension/web app) error reports
+-- Neon PostgreSQL database
+
+-- Repeats of the same error (same fingerprint) from a user are folded into one row
+CREATE TABLE IF NOT EXISTS client_errors (
+    id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    fingerprint VARCHAR(64) NOT NULL, -- hex(sha256(message + stack))
+    message TEXT NOT NULL,
+    stack TEXT,
+    app_version VARCHAR(50),
+    platform VARCHAR(50),
+    url TEXT,
+    breadcrumbs JSONB NOT NULL DEFAULT '[]',
+    request_ids TEXT[] NOT NULL DEFAULT '{}', -- X-Request-ID values of related backend requests
+    occurrences INTEGER NOT NULL DEFAULT 1,
+    first_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS idx_client_errors_first_seen_at ON client_errors(first_seen_at);
+CREATE INDEX IF NOT EXISTS idx_client_errors_request_ids ON client_errors USING GIN (request_ids);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS client_errors;
@@ -0,0 +1,21 @@
+-- Opt-in feature usage telemetry, aggregated daily
+-- Neon PostgreSQL database
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS telemetry_opt_in BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS telemetry_updated_at TIMESTAMP WITH TIME ZONE;
+
+-- subject is hex(HMAC-SHA256(TELEMETRY_SECRET, day + ":" + user_id)): users can be counted per day
+-- but not identified or followed across days
+CREATE TABLE IF NOT EXISTS feature_usage_daily (
+    day DATE NOT NULL,
+    feature VARCHAR(50) NOT NULL,
+    subject VARCHAR(64) NOT NULL,
+    uses INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, feature, subject)
+);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS feature_usage_daily;
+ALTER TABLE users DROP COLUMN IF EXISTS telemetry_updated_at;
+ALTER TABLE users DROP COLUMN IF EXISTS telemetry_opt_in;
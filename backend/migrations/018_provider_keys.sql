@@ -0,0 +1,32 @@
+-- Server-side storage of user AI provider keys with a rotation audit trail
+-- Neon PostgreSQL database
+
+-- sealed_key is the key encrypted with PROVIDER_KEY_ENCRYPTION_KEY (AES-256-GCM, user ID bound)
+CREATE TABLE IF NOT EXISTS provider_keys (
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    sealed_key BYTEA NOT NULL,
+    key_hint VARCHAR(8) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    rotated_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (user_id, provider)
+);
+
+-- One row per stored, rotated, deleted, or rejected key; only hints of keys are kept
+CREATE TABLE IF NOT EXISTS provider_key_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    old_key_hint VARCHAR(8),
+    new_key_hint VARCHAR(8),
+    request_id VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_provider_key_events_user_created ON provider_key_events(user_id, created_at, id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS provider_key_events;
+DROP TABLE IF EXISTS provider_keys;
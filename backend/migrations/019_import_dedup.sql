@@ -0,0 +1,22 @@
+-- Duplicate detection for imports and inbox captures
+-- Neon PostgreSQL database
+
+ALTER TABLE staged_notes ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
+ALTER TABLE staged_notes ADD COLUMN IF NOT EXISTS is_duplicate BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Every note content ever staged for a user, so re-imports are caught after the staged copies are
+-- claimed and deleted. content_hash is hex(sha256(user_id + NUL + normalized content)): salted per user
+-- so equal notes of different users can't be correlated.
+CREATE TABLE IF NOT EXISTS staged_content_hashes (
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content_hash VARCHAR(64) NOT NULL,
+    source VARCHAR(50) NOT NULL,
+    first_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, content_hash)
+);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS staged_content_hashes;
+ALTER TABLE staged_notes DROP COLUMN IF EXISTS is_duplicate;
+ALTER TABLE staged_notes DROP COLUMN IF EXISTS content_hash;
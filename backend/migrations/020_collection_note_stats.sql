@@ -0,0 +1,112 @@
+-- Denormalized note count and latest note time per collection, maintained by triggers
+-- Neon PostgreSQL database
+
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS note_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS last_note_at TIMESTAMP WITH TIME ZONE;
+
+-- Recompute the stats of the given collections from their live (not soft-deleted) notes
+CREATE OR REPLACE FUNCTION refresh_collection_note_stats(collection_ids TEXT[])
+RETURNS void AS $$
+    UPDATE collections c
+    SET note_count = s.note_count, last_note_at = s.last_note_at
+    FROM (
+        SELECT nc.collection_id AS id, COUNT(n.id) AS note_count, MAX(n.updated_at) AS last_note_at
+        FROM unnest(collection_ids) AS ids(id)
+        JOIN note_collections nc ON nc.collection_id = ids.id
+        LEFT JOIN notes n ON n.id = nc.note_id AND n.deleted_at IS NULL
+        GROUP BY nc.collection_id
+        UNION ALL
+        -- Collections left without any notes
+        SELECT ids.id, 0, NULL
+        FROM unnest(collection_ids) AS ids(id)
+        WHERE NOT EXISTS (SELECT 1 FROM note_collections nc WHERE nc.collection_id = ids.id)
+    ) s
+    WHERE c.id = s.id
+      AND (c.note_count, c.last_note_at) IS DISTINCT FROM (s.note_count, s.last_note_at);
+$$ LANGUAGE sql;
+
+-- Statement-level triggers so a bulk write (sync push, import) refreshes each collection once
+CREATE OR REPLACE FUNCTION note_collections_inserted()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM refresh_collection_note_stats(ARRAY(SELECT DISTINCT collection_id FROM inserted_rows));
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE FUNCTION note_collections_deleted()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM refresh_collection_note_stats(ARRAY(SELECT DISTINCT collection_id FROM deleted_rows));
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+-- Soft deletes, restores, and edits change a collection's count or latest note time
+CREATE OR REPLACE FUNCTION notes_updated_refresh_collections()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM refresh_collection_note_stats(ARRAY(
+        SELECT DISTINCT nc.collection_id
+        FROM new_rows n
+        JOIN old_rows o ON o.id = n.id
+        JOIN note_collections nc ON nc.note_id = n.id
+        WHERE n.deleted_at IS DISTINCT FROM o.deleted_at OR n.updated_at IS DISTINCT FROM o.updated_at
+    ));
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS note_collections_inserted_stats ON note_collections;
+CREATE TRIGGER note_collections_inserted_stats AFTER INSERT ON note_collections
+    REFERENCING NEW TABLE AS inserted_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION note_collections_inserted();
+
+DROP TRIGGER IF EXISTS note_collections_deleted_stats ON note_collections;
+CREATE TRIGGER note_collections_deleted_stats AFTER DELETE ON note_collections
+    REFERENCING OLD TABLE AS deleted_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION note_collections_deleted();
+
+DROP TRIGGER IF EXISTS notes_updated_collection_stats ON notes;
+CREATE TRIGGER notes_updated_collection_stats AFTER UPDATE ON notes
+    REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION notes_updated_refresh_collections();
+
+-- Maintained stats aren't an edit of the collection, so they mustn't bump updated_at
+-- (which would make every client re-pull the collection on each note change)
+CREATE OR REPLACE FUNCTION update_collections_updated_at_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (to_jsonb(NEW) - 'note_count' - 'last_note_at') = (to_jsonb(OLD) - 'note_count' - 'last_note_at')
+       AND (NEW.note_count, NEW.last_note_at) IS DISTINCT FROM (OLD.note_count, OLD.last_note_at) THEN
+        RETURN NEW;
+    END IF;
+    NEW.updated_at = CURRENT_TIMESTAMP;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS update_collections_updated_at ON collections;
+CREATE TRIGGER update_collections_updated_at BEFORE UPDATE ON collections
+    FOR EACH ROW EXECUTE FUNCTION update_collections_updated_at_column();
+
+-- Backfill
+SELECT refresh_collection_note_stats(ARRAY(SELECT id FROM collections));
+
+-- migrate:down
+
+DROP TRIGGER IF EXISTS notes_updated_collection_stats ON notes;
+DROP TRIGGER IF EXISTS note_collections_deleted_stats ON note_collections;
+DROP TRIGGER IF EXISTS note_collections_inserted_stats ON note_collections;
+DROP FUNCTION IF EXISTS notes_updated_refresh_collections();
+DROP FUNCTION IF EXISTS note_collections_deleted();
+DROP FUNCTION IF EXISTS note_collections_inserted();
+DROP FUNCTION IF EXISTS refresh_collection_note_stats(TEXT[]);
+
+DROP TRIGGER IF EXISTS update_collections_updated_at ON collections;
+CREATE TRIGGER update_collections_updated_at BEFORE UPDATE ON collections
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+DROP FUNCTION IF EXISTS update_collections_updated_at_column();
+
+ALTER TABLE collections DROP COLUMN IF EXISTS last_note_at;
+ALTER TABLE collections DROP COLUMN IF EXISTS note_count;
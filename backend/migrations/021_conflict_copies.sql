@@ -0,0 +1,16 @@
+-- Per-user sync conflict policy and "keep both" conflict copies
+-- Neon PostgreSQL database
+
+-- last_write_wins: a stale push overwrites the note; keep_both: the overwritten version is kept as a copy
+ALTER TABLE users ADD COLUMN IF NOT EXISTS conflict_policy VARCHAR(20) NOT NULL DEFAULT 'last_write_wins';
+
+-- Device (X-Device-ID) that last pushed the note, named in conflict copy titles
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS device_id VARCHAR(255);
+-- For conflict copies, the note they were copied from
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS conflict_of VARCHAR(255);
+
+-- migrate:down
+
+ALTER TABLE notes DROP COLUMN IF EXISTS conflict_of;
+ALTER TABLE notes DROP COLUMN IF EXISTS device_id;
+ALTER TABLE users DROP COLUMN IF EXISTS conflict_policy;
@@ -0,0 +1,12 @@
+-- Per-user timezone and locale, so wall-clock times are interpreted in the user's zone instead of UTC
+-- Neon PostgreSQL database
+
+-- IANA zone name, e.g. 'Europe/Lisbon'
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+-- BCP 47 tag, e.g. 'pt-BR'; NULL means the client's default
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(35);
+
+-- migrate:down
+
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
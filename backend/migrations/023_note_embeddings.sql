@@ -0,0 +1,22 @@
+-- Note embeddings for semantic search (pgvector)
+-- Neon PostgreSQL database
+
+CREATE EXTENSION IF NOT EXISTS vector;
+
+-- One embedding per note, computed from its title and the plaintext the client shared for search
+-- (the server can't read encrypted content). Searches are scoped to one user, so an exact scan
+-- over the user's rows is used instead of an approximate index that would filter after the fact.
+CREATE TABLE IF NOT EXISTS note_embeddings (
+    note_id VARCHAR(255) PRIMARY KEY REFERENCES notes(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    model VARCHAR(64) NOT NULL,
+    embedding vector(768) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_embeddings_user_id ON note_embeddings(user_id);
+
+-- migrate:down
+
+-- The vector extension is left installed; other databases on the server may use it
+DROP TABLE IF EXISTS note_embeddings;
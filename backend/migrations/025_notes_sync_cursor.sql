@@ -0,0 +1,10 @@
+-- Keyset index for paged sync pulls (ORDER BY updated_at, id per user)
+-- Neon PostgreSQL database
+
+-- migrate:no-transaction
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_notes_user_updated ON notes(user_id, updated_at, id);
+
+-- migrate:down
+
+DROP INDEX CONCURRENTLY IF EXISTS idx_notes_user_updated;
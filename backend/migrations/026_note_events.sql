@@ -0,0 +1,28 @@
+-- Per-note activity recorded by the server for GET /api/notes/{id}/timeline
+-- Neon PostgreSQL database
+
+-- Events carry no note content: only what happened, from which device, and a related note (the
+-- conflict copy, or the note a copy was made from)
+CREATE TABLE IF NOT EXISTS note_events (
+    id BIGSERIAL PRIMARY KEY,
+    note_id VARCHAR(255) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(32) NOT NULL, -- created, updated, deleted, meta_updated, conflict, conflict_copy
+    device_id VARCHAR(255),
+    related_note_id VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_events_note ON note_events(note_id, created_at DESC, id DESC);
+
+-- Notes that predate the table start their timeline at creation
+-- migrate:backfill
+INSERT INTO note_events (note_id, user_id, type, related_note_id, created_at)
+SELECT n.id, n.user_id, CASE WHEN n.conflict_of IS NULL THEN 'created' ELSE 'conflict_copy' END, n.conflict_of, n.created_at
+FROM notes n
+WHERE NOT EXISTS (SELECT 1 FROM note_events e WHERE e.note_id = n.id)
+LIMIT {{batch_size}};
+
+-- migrate:down
+
+DROP TABLE IF EXISTS note_events;
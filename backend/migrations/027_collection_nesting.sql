@@ -0,0 +1,18 @@
+-- Nested collections
+-- Neon PostgreSQL database
+
+-- Top-level collections have no parent. Cycles are rejected by the API and broken on sync push.
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS parent_id VARCHAR(255) REFERENCES collections(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_collections_parent_id ON collections(parent_id);
+
+-- Names only need to be unique among a collection's live siblings
+DROP INDEX IF EXISTS idx_collections_user_id_name_active;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_collections_user_id_name_active ON collections(user_id, COALESCE(parent_id, ''), name) WHERE deleted_at IS NULL;
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_collections_user_id_name_active;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_collections_user_id_name_active ON collections(user_id, name) WHERE deleted_at IS NULL;
+DROP INDEX IF EXISTS idx_collections_parent_id;
+ALTER TABLE collections DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,17 @@
+-- Source page of notes captured from the browser extension
+-- Neon PostgreSQL database
+
+-- The URL and page title are end-to-end encrypted together. source_url_hash is a client-keyed hash of
+-- the normalized URL, so a user's notes can be looked up by page without the server learning the URL.
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS source_encrypted BYTEA;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS source_iv BYTEA;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS source_url_hash VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_notes_user_source_url_hash ON notes(user_id, source_url_hash) WHERE source_url_hash IS NOT NULL;
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_notes_user_source_url_hash;
+ALTER TABLE notes DROP COLUMN IF EXISTS source_url_hash;
+ALTER TABLE notes DROP COLUMN IF EXISTS source_iv;
+ALTER TABLE notes DROP COLUMN IF EXISTS source_encrypted;
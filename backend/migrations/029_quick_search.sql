@@ -0,0 +1,15 @@
+-- Trigram indexes for the browser extension's quick search over note titles and domains
+-- Neon PostgreSQL database
+
+-- migrate:no-transaction
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_notes_title_trgm ON notes USING GIN (title gin_trgm_ops);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_notes_domain_trgm ON notes USING GIN (domain gin_trgm_ops);
+
+-- migrate:down
+
+-- The pg_trgm extension is left installed; other databases on the server may use it
+DROP INDEX CONCURRENTLY IF EXISTS idx_notes_domain_trgm;
+DROP INDEX CONCURRENTLY IF EXISTS idx_notes_title_trgm;
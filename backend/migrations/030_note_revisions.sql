@@ -0,0 +1,22 @@
+-- Earlier versions of notes' encrypted content, for GET /api/notes/{id}/revisions and restore
+-- Neon PostgreSQL database
+
+-- A revision is the version a push replaced. Revisions are taken at most every few minutes per note
+-- and only the most recent are kept (see handlers/note_revision_handlers.go).
+CREATE TABLE IF NOT EXISTS note_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    note_id VARCHAR(255) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    content_encrypted BYTEA NOT NULL,
+    content_iv BYTEA NOT NULL,
+    device_id VARCHAR(255), -- Device that saved the version
+    saved_at TIMESTAMP WITH TIME ZONE NOT NULL, -- The version's updated_at
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP -- When it was replaced
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_revisions_note ON note_revisions(note_id, created_at DESC, id DESC);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS note_revisions;
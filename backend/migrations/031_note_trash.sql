@@ -0,0 +1,10 @@
+-- Index for listing a user's trash (soft-deleted notes), most recently deleted first
+-- Neon PostgreSQL database
+
+-- migrate:no-transaction
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_notes_user_trash ON notes(user_id, deleted_at DESC, id DESC) WHERE deleted_at IS NOT NULL;
+
+-- migrate:down
+
+DROP INDEX CONCURRENTLY IF EXISTS idx_notes_user_trash;
@@ -0,0 +1,17 @@
+-- Ephemeral users for the anonymous trial mode
+-- Neon PostgreSQL database
+
+-- A trial user is an ordinary user record plus this row; deleting the user (on expiry, or once its
+-- data moved to a Clerk account) removes everything it owns
+CREATE TABLE IF NOT EXISTS trial_users (
+    user_id VARCHAR(255) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_hash BYTEA NOT NULL UNIQUE, -- sha256 of the bearer token; the token itself is never stored
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_trial_users_expires_at ON trial_users(expires_at);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS trial_users;
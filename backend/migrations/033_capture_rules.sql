@@ -0,0 +1,18 @@
+-- Per-user capture rules: defaults for notes captured on a domain
+-- Neon PostgreSQL database
+
+CREATE TABLE IF NOT EXISTS capture_rules (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    domain VARCHAR(253) NOT NULL, -- Lowercase host name; also matches its subdomains
+    collection_id VARCHAR(255) REFERENCES collections(id) ON DELETE SET NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    cleanup_on_capture BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, domain)
+);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS capture_rules;
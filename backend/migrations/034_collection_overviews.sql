@@ -0,0 +1,21 @@
+-- Cached AI overviews of collections
+-- Neon PostgreSQL database
+
+-- One overview per collection, generated from its notes' titles, tags, and embeddings (the server
+-- can't read encrypted content). note_ids and collection_name record what it was generated from, so
+-- it's regenerated once the collection has changed materially.
+CREATE TABLE IF NOT EXISTS collection_overviews (
+    collection_id VARCHAR(255) PRIMARY KEY REFERENCES collections(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    overview JSONB NOT NULL,
+    collection_name VARCHAR(255) NOT NULL,
+    note_ids TEXT[] NOT NULL DEFAULT '{}',
+    model VARCHAR(64) NOT NULL,
+    generated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_collection_overviews_user_id ON collection_overviews(user_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS collection_overviews;
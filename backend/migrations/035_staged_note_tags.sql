@@ -0,0 +1,8 @@
+-- Tags carried over from imported notes (Evernote and Notion exports have them)
+-- Neon PostgreSQL database
+
+ALTER TABLE staged_notes ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+-- migrate:down
+
+ALTER TABLE staged_notes DROP COLUMN IF EXISTS tags;
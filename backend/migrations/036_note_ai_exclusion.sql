@@ -0,0 +1,8 @@
+-- Per-note opt-out of server-side AI processing (embeddings, semantic search, overviews, relevant notes)
+-- Neon PostgreSQL database
+
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS ai_excluded BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- migrate:down
+
+ALTER TABLE notes DROP COLUMN IF EXISTS ai_excluded;
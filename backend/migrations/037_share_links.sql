@@ -0,0 +1,27 @@
+-- Expiring public links to read-only copies of notes
+-- Neon PostgreSQL database
+
+-- The shared copy is encrypted by the client under a per-link key carried in the link's URL fragment,
+-- which browsers never send, so the server can't read shared notes either.
+CREATE TABLE IF NOT EXISTS share_links (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    note_id VARCHAR(255) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE, -- Hex SHA-256 of the link token; the token itself isn't stored
+    title TEXT NOT NULL DEFAULT '',
+    content_encrypted BYTEA NOT NULL,
+    content_iv BYTEA NOT NULL,
+    password_hash TEXT, -- Argon2id, in PHC string format
+    expires_at TIMESTAMP WITH TIME ZONE,
+    view_count INTEGER NOT NULL DEFAULT 0,
+    last_viewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_note_id ON share_links(note_id);
+CREATE INDEX IF NOT EXISTS idx_share_links_user_id ON share_links(user_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS share_links;
@@ -0,0 +1,9 @@
+-- Per-user AI provider region, so AI requests can be kept on regional (e.g. EU-only) endpoints
+-- Neon PostgreSQL database
+
+-- One of the regions configured in AI_PROVIDER_REGIONS; NULL means the provider's default endpoint
+ALTER TABLE users ADD COLUMN IF NOT EXISTS ai_region VARCHAR(32);
+
+-- migrate:down
+
+ALTER TABLE users DROP COLUMN IF EXISTS ai_region;
@@ -0,0 +1,29 @@
+-- Nightly semantic search maintenance: staleness tracking for embeddings and a record of job runs
+-- Neon PostgreSQL database
+
+-- sha256 of the note's encrypted content when the embedding was stored. The server can't re-embed a
+-- note itself, so embeddings whose note changed since are flagged stale for clients to re-send text.
+ALTER TABLE note_embeddings ADD COLUMN IF NOT EXISTS content_hash BYTEA;
+ALTER TABLE note_embeddings ADD COLUMN IF NOT EXISTS stale BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_note_embeddings_stale ON note_embeddings(user_id) WHERE stale;
+
+-- When each maintenance task last finished, so only one replica runs it per window
+CREATE TABLE IF NOT EXISTS maintenance_runs (
+    task VARCHAR(64) PRIMARY KEY,
+    finished_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Existing embeddings are taken to match their note's current content
+-- migrate:backfill
+UPDATE note_embeddings e SET content_hash = sha256(n.content_encrypted)
+FROM notes n
+WHERE n.id = e.note_id
+  AND e.note_id IN (SELECT note_id FROM note_embeddings WHERE content_hash IS NULL LIMIT {{batch_size}});
+
+-- migrate:down
+
+DROP TABLE IF EXISTS maintenance_runs;
+DROP INDEX IF EXISTS idx_note_embeddings_stale;
+ALTER TABLE note_embeddings DROP COLUMN IF EXISTS stale;
+ALTER TABLE note_embeddings DROP COLUMN IF EXISTS content_hash;
@@ -0,0 +1,18 @@
+-- Recent searches per user, for the search box's history and suggestions
+-- Neon PostgreSQL database
+
+-- One row per distinct query (case-insensitively); searching again moves it to the top. Queries are
+-- plaintext, like titles and tags, and users can clear them.
+CREATE TABLE IF NOT EXISTS search_history (
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    query_key VARCHAR(200) NOT NULL, -- lower(query)
+    query VARCHAR(200) NOT NULL,
+    searched_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, query_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_search_history_recent ON search_history(user_id, searched_at DESC);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS search_history;
@@ -0,0 +1,18 @@
+-- Links between notes, for the notes graph
+-- Neon PostgreSQL database
+
+-- Note bodies are end-to-end encrypted, so clients report the notes each note links to. Targets aren't
+-- foreign keys: a link may arrive before the note it points to, and only links between the user's live
+-- notes count.
+CREATE TABLE IF NOT EXISTS note_links (
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    source_id VARCHAR(255) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    target_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (source_id, target_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_links_user_id ON note_links(user_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS note_links;
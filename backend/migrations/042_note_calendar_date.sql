@@ -0,0 +1,32 @@
+-- notes.date becomes a calendar date: the day a note belongs to in the user's own calendar
+-- Neon PostgreSQL database
+
+-- The column was a timestamp holding a mix of client-local dates and UTC timestamps. Each value becomes
+-- its day in the owner's time zone (UTC if Postgres doesn't know the zone), the day clients showed for
+-- both. The type change rewrites the table without firing triggers, so updated_at and the collection
+-- counters are untouched; run it with --allow-destructive.
+CREATE OR REPLACE FUNCTION note_local_day(ts TIMESTAMP WITH TIME ZONE, owner VARCHAR) RETURNS DATE AS $$
+DECLARE
+    zone VARCHAR;
+BEGIN
+    SELECT timezone INTO zone FROM users WHERE id = owner;
+    RETURN (ts AT TIME ZONE COALESCE(zone, 'UTC'))::date;
+EXCEPTION WHEN invalid_parameter_value THEN
+    RETURN (ts AT TIME ZONE 'UTC')::date;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'notes' AND column_name = 'date' AND data_type = 'timestamp with time zone') THEN
+        ALTER TABLE notes ALTER COLUMN date TYPE DATE USING note_local_day(date, user_id);
+    END IF;
+END $$;
+
+DROP FUNCTION IF EXISTS note_local_day(TIMESTAMP WITH TIME ZONE, VARCHAR);
+
+-- migrate:down
+
+-- Dates go back to timestamps at midnight UTC of their day
+ALTER TABLE notes ALTER COLUMN date TYPE TIMESTAMP WITH TIME ZONE USING (date::timestamp AT TIME ZONE 'UTC');
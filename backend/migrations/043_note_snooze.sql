@@ -0,0 +1,15 @@
+-- Snoozed notes, hidden from default pulls until their snooze ends
+-- Neon PostgreSQL database
+
+-- When the note comes back; NULL if it isn't snoozed. A background job clears it once it passes.
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS snoozed_until TIMESTAMP WITH TIME ZONE;
+-- Whether connected devices are notified when the snooze ends
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS snooze_notify BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_notes_snoozed_until ON notes(snoozed_until) WHERE snoozed_until IS NOT NULL;
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_notes_snoozed_until;
+ALTER TABLE notes DROP COLUMN IF EXISTS snooze_notify;
+ALTER TABLE notes DROP COLUMN IF EXISTS snoozed_until;
@@ -0,0 +1,21 @@
+-- View limits and per-view analytics for share links
+-- Neon PostgreSQL database
+
+-- How many times the link can be viewed; NULL for no limit
+ALTER TABLE share_links ADD COLUMN IF NOT EXISTS max_views INTEGER;
+
+-- One row per counted view. Only the referring site's host is kept, never the full URL or anything
+-- identifying the viewer.
+CREATE TABLE IF NOT EXISTS share_link_views (
+    id BIGSERIAL PRIMARY KEY,
+    share_link_id VARCHAR(64) NOT NULL REFERENCES share_links(id) ON DELETE CASCADE,
+    referrer_host VARCHAR(255) NOT NULL DEFAULT '', -- Empty for direct visits
+    viewed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_link_views_link_viewed_at ON share_link_views(share_link_id, viewed_at);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS share_link_views;
+ALTER TABLE share_links DROP COLUMN IF EXISTS max_views;
@@ -0,0 +1,186 @@
+// Package migrations embeds the numbered SQL schema migrations applied by cmd/migrate.
+//
+// Each file is named NNN_description.sql. Statements above a "-- migrate:down" line apply the
+// migration; statements below it revert it. Two directives support changes on a live database:
+//
+//   - A "-- migrate:no-transaction" line (above the down marker) runs the up and down statements one
+//     by one outside a transaction, for statements Postgres refuses to run in one (CREATE INDEX
+//     CONCURRENTLY).
+//   - A "-- migrate:backfill" line starts a statement run repeatedly after the up statements, each
+//     batch in its own transaction, until it changes no rows. It must limit itself to {{batch_size}}
+//     rows per run, e.g. "UPDATE t SET c = ... WHERE id IN (SELECT id FROM t WHERE c IS NULL LIMIT {{batch_size}})".
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Markers and directives recognized in migration files
+const (
+	downMarker             = "-- migrate:down"
+	backfillMarker         = "-- migrate:backfill"
+	noTransactionDirective = "-- migrate:no-transaction"
+)
+
+// BatchSizePlaceholder is replaced by the batch size in backfill statements
+const BatchSizePlaceholder = "{{batch_size}}"
+
+//go:embed *.sql
+var files embed.FS
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version int
+	Name    string // File name, e.g. "001_initial_schema.sql"
+	Up      string
+	Down    string // Empty if the migration can't be reverted
+
+	NoTransaction bool   // Run Up and Down statement by statement outside a transaction
+	Backfill      string // Batched statement run after Up until it changes no rows; empty if none
+}
+
+// All returns the embedded migrations in version order
+func All() ([]Migration, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(names))
+	seen := make(map[int]string, len(names))
+	for _, name := range names {
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", name)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+
+		content, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		up, down, _ := strings.Cut(string(content), downMarker)
+		up, backfill, _ := strings.Cut(up, backfillMarker)
+		backfill = strings.TrimSpace(backfill)
+		if backfill != "" && !strings.Contains(backfill, BatchSizePlaceholder) {
+			return nil, fmt.Errorf("migration %s: backfill must limit each batch to %s rows", name, BatchSizePlaceholder)
+		}
+		if len(SplitStatements(backfill)) > 1 {
+			return nil, fmt.Errorf("migration %s: backfill must be a single statement", name)
+		}
+
+		noTransaction := false
+		for _, line := range strings.Split(up, "\n") {
+			if strings.TrimSpace(line) == noTransactionDirective {
+				noTransaction = true
+			}
+		}
+
+		migrations = append(migrations, Migration{
+			Version:       version,
+			Name:          name,
+			Up:            up,
+			Down:          strings.TrimSpace(down),
+			NoTransaction: noTransaction,
+			Backfill:      backfill,
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// destructivePatterns match statements that can lose data
+var destructivePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^DROP\s+(TABLE|SCHEMA|DATABASE|MATERIALIZED\s+VIEW)\b`),
+	regexp.MustCompile(`(?i)\bDROP\s+COLUMN\b`),
+	regexp.MustCompile(`(?i)^TRUNCATE\b`),
+	regexp.MustCompile(`(?i)^DELETE\s+FROM\b`),
+	regexp.MustCompile(`(?i)\bALTER\s+COLUMN\s+\S+\s+(SET\s+DATA\s+)?TYPE\b`),
+}
+
+// Destructive returns the statements in sql that can lose data: dropping tables, schemas, or columns,
+// truncating, deleting rows, and changing column types
+func Destructive(sql string) []string {
+	var destructive []string
+	for _, statement := range SplitStatements(sql) {
+		for _, pattern := range destructivePatterns {
+			if pattern.MatchString(statement) {
+				destructive = append(destructive, statement)
+				break
+			}
+		}
+	}
+	return destructive
+}
+
+// SplitStatements splits sql into statements at top-level semicolons, dropping comments and empty
+// statements. Semicolons in quoted strings, quoted identifiers, and dollar-quoted bodies are kept.
+func SplitStatements(sql string) []string {
+	var statements []string
+	var current strings.Builder
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end
+				current.WriteByte('\n')
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 3
+			}
+			current.WriteByte(' ')
+		case c == '\'' || c == '"':
+			i = copyQuoted(&current, sql, i, string(c))
+		case c == '$' && dollarTag.MatchString(sql[i:]):
+			i = copyQuoted(&current, sql, i, dollarTag.FindString(sql[i:]))
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// copyQuoted copies the quoted text starting at sql[start] (opened and closed by quote) and returns
+// the index of its last character. Unterminated text runs to the end.
+func copyQuoted(b *strings.Builder, sql string, start int, quote string) int {
+	end := strings.Index(sql[start+len(quote):], quote)
+	if end < 0 {
+		b.WriteString(sql[start:])
+		return len(sql) - 1
+	}
+	last := start + len(quote) + end + len(quote) - 1
+	b.WriteString(sql[start : last+1])
+	return last
+}
+
+// dollarTag matches the opening tag of a dollar-quoted string ($$ or $name$)
+var dollarTag = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
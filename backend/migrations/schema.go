@@ -0,0 +1,272 @@
+package migrations
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema is the tables, columns, and indexes of a database, by name
+type Schema struct {
+	Tables  map[string]map[string]bool // Table name -> its column names
+	Indexes map[string]string          // Index name -> its table
+}
+
+// SchemaDiff lists how a live schema differs from the expected one, e.g. "column notes.snoozed_until"
+type SchemaDiff struct {
+	Missing    []string // Expected but not in the live schema
+	Unexpected []string // In the live schema but created by no migration
+}
+
+// Statements ExpectedSchema follows; identifiers may be quoted or schema-qualified
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^CREATE\s+(?:UNLOGGED\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([^\s(]+)\s*\(`)
+	alterTablePattern  = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?(\S+)\s+(.*)$`)
+	dropTablePattern   = regexp.MustCompile(`(?is)^DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?(.+?)(?:\s+(?:CASCADE|RESTRICT))?$`)
+	createIndexPattern = regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(\S+)\s+ON\s+(?:ONLY\s+)?([^\s(]+)`)
+	alterIndexPattern  = regexp.MustCompile(`(?is)^ALTER\s+INDEX\s+(?:IF\s+EXISTS\s+)?(\S+)\s+RENAME\s+TO\s+(\S+)$`)
+	dropIndexPattern   = regexp.MustCompile(`(?is)^DROP\s+INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+EXISTS\s+)?(.+?)(?:\s+(?:CASCADE|RESTRICT))?$`)
+
+	renameTablePattern  = regexp.MustCompile(`(?is)^RENAME\s+TO\s+(\S+)$`)
+	renameColumnPattern = regexp.MustCompile(`(?is)^RENAME\s+(?:COLUMN\s+)?(\S+)\s+TO\s+(\S+)$`)
+	addColumnPattern    = regexp.MustCompile(`(?is)^ADD\s+(?:COLUMN\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(\S+)`)
+	dropColumnPattern   = regexp.MustCompile(`(?is)^DROP\s+(?:COLUMN\s+)?(?:IF\s+EXISTS\s+)?(\S+)`)
+)
+
+// tableConstraintKeywords start the entries of a table definition or ALTER TABLE action that aren't columns
+var tableConstraintKeywords = map[string]bool{
+	"CONSTRAINT": true, "PRIMARY": true, "UNIQUE": true, "FOREIGN": true, "CHECK": true, "EXCLUDE": true, "LIKE": true,
+}
+
+// ExpectedSchema returns the schema the migrations' up statements leave, applied in order. It follows
+// CREATE TABLE, ALTER TABLE (adding, dropping, and renaming columns, and renaming the table), DROP
+// TABLE, CREATE INDEX, ALTER INDEX ... RENAME, and DROP INDEX; objects created in DO blocks or
+// functions aren't tracked.
+func ExpectedSchema(ms []Migration) Schema {
+	schema := Schema{Tables: map[string]map[string]bool{}, Indexes: map[string]string{}}
+	for _, m := range ms {
+		for _, statement := range SplitStatements(m.Up) {
+			schema.apply(strings.Join(strings.Fields(statement), " "))
+		}
+	}
+	return schema
+}
+
+// Diff compares a live schema against the expected one. Columns of missing or unexpected tables aren't
+// listed separately.
+func (s Schema) Diff(live Schema) SchemaDiff {
+	var diff SchemaDiff
+	for table, columns := range s.Tables {
+		liveColumns, ok := live.Tables[table]
+		if !ok {
+			diff.Missing = append(diff.Missing, "table "+table)
+			continue
+		}
+		for column := range columns {
+			if !liveColumns[column] {
+				diff.Missing = append(diff.Missing, fmt.Sprintf("column %s.%s", table, column))
+			}
+		}
+		for column := range liveColumns {
+			if !columns[column] {
+				diff.Unexpected = append(diff.Unexpected, fmt.Sprintf("column %s.%s", table, column))
+			}
+		}
+	}
+	for table := range live.Tables {
+		if _, ok := s.Tables[table]; !ok {
+			diff.Unexpected = append(diff.Unexpected, "table "+table)
+		}
+	}
+
+	for index, table := range s.Indexes {
+		if _, ok := live.Indexes[index]; !ok {
+			diff.Missing = append(diff.Missing, fmt.Sprintf("index %s on %s", index, table))
+		}
+	}
+	for index, table := range live.Indexes {
+		if _, ok := s.Indexes[index]; !ok {
+			diff.Unexpected = append(diff.Unexpected, fmt.Sprintf("index %s on %s", index, table))
+		}
+	}
+
+	sort.Strings(diff.Missing)
+	sort.Strings(diff.Unexpected)
+	return diff
+}
+
+// apply updates the schema for one statement, with its whitespace collapsed
+func (s Schema) apply(statement string) {
+	if match := createTablePattern.FindStringSubmatchIndex(statement); match != nil {
+		table := identifier(statement[match[2]:match[3]])
+		if _, ok := s.Tables[table]; ok {
+			return // CREATE TABLE IF NOT EXISTS leaves an existing table as it is
+		}
+		columns := map[string]bool{}
+		body := parenthesized(statement[match[1]-1:])
+		for _, entry := range splitTopLevel(body) {
+			if name := entryName(entry); name != "" {
+				columns[name] = true
+			}
+		}
+		s.Tables[table] = columns
+		return
+	}
+
+	if match := alterTablePattern.FindStringSubmatch(statement); match != nil {
+		table := identifier(match[1])
+		columns, ok := s.Tables[table]
+		if !ok {
+			return
+		}
+		if rename := renameTablePattern.FindStringSubmatch(match[2]); rename != nil {
+			renamed := identifier(rename[1])
+			delete(s.Tables, table)
+			s.Tables[renamed] = columns
+			for index, indexTable := range s.Indexes {
+				if indexTable == table {
+					s.Indexes[index] = renamed
+				}
+			}
+			return
+		}
+		if rename := renameColumnPattern.FindStringSubmatch(match[2]); rename != nil && !strings.EqualFold(rename[1], "CONSTRAINT") {
+			delete(columns, identifier(rename[1]))
+			columns[identifier(rename[2])] = true
+			return
+		}
+		for _, action := range splitTopLevel(match[2]) {
+			if add := addColumnPattern.FindStringSubmatch(action); add != nil && !isConstraint(add[1]) {
+				columns[identifier(add[1])] = true
+			} else if drop := dropColumnPattern.FindStringSubmatch(action); drop != nil && !strings.EqualFold(drop[1], "CONSTRAINT") {
+				delete(columns, identifier(drop[1]))
+			}
+		}
+		return
+	}
+
+	if match := dropTablePattern.FindStringSubmatch(statement); match != nil {
+		for _, name := range strings.Split(match[1], ",") {
+			table := identifier(name)
+			delete(s.Tables, table)
+			for index, indexTable := range s.Indexes {
+				if indexTable == table {
+					delete(s.Indexes, index)
+				}
+			}
+		}
+		return
+	}
+
+	if match := createIndexPattern.FindStringSubmatch(statement); match != nil {
+		// Unnamed indexes (CREATE INDEX ON t) get generated names, which can't be predicted
+		if !strings.EqualFold(match[1], "ON") {
+			index := identifier(match[1])
+			if _, ok := s.Indexes[index]; !ok {
+				s.Indexes[index] = identifier(match[2])
+			}
+		}
+		return
+	}
+
+	if match := alterIndexPattern.FindStringSubmatch(statement); match != nil {
+		if table, ok := s.Indexes[identifier(match[1])]; ok {
+			delete(s.Indexes, identifier(match[1]))
+			s.Indexes[identifier(match[2])] = table
+		}
+		return
+	}
+
+	if match := dropIndexPattern.FindStringSubmatch(statement); match != nil {
+		for _, name := range strings.Split(match[1], ",") {
+			delete(s.Indexes, identifier(name))
+		}
+	}
+}
+
+// entryName returns the column a table definition entry defines, or "" for a table constraint
+func entryName(entry string) string {
+	fields := strings.Fields(entry)
+	if len(fields) == 0 || isConstraint(fields[0]) {
+		return ""
+	}
+	return identifier(fields[0])
+}
+
+// isConstraint reports whether the first word of an entry, which may run into a parenthesis as in
+// "UNIQUE(user_id, name)", starts a table constraint
+func isConstraint(word string) bool {
+	word, _, _ = strings.Cut(word, "(")
+	return tableConstraintKeywords[strings.ToUpper(word)]
+}
+
+// identifier returns the name of a possibly quoted and schema-qualified identifier as Postgres stores
+// it: unquoted names are folded to lower case
+func identifier(name string) string {
+	name = strings.TrimSpace(name)
+	quoted := false
+	for i := 0; i < len(name); i++ {
+		switch {
+		case name[i] == '"':
+			quoted = !quoted
+		case name[i] == '.' && !quoted:
+			return identifier(name[i+1:]) // Drop the schema
+		}
+	}
+	if strings.HasPrefix(name, `"`) && strings.HasSuffix(name, `"`) && len(name) >= 2 {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+	return strings.ToLower(name)
+}
+
+// parenthesized returns the text inside the parentheses s starts with
+func parenthesized(s string) string {
+	depth := 0
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return s[1:i]
+			}
+		}
+	}
+	return strings.TrimPrefix(s, "(")
+}
+
+// splitTopLevel splits s at commas outside parentheses and quotes
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
@@ -0,0 +1,78 @@
+// Admin and support data models
+package models
+
+import "time"
+
+// DeviceSyncState represents the last sync activity of a single client device
+type DeviceSyncState struct {
+	DeviceID   string     `json:"deviceId"`
+	LastPullAt *time.Time `json:"lastPullAt,omitempty"`
+	LastPushAt *time.Time `json:"lastPushAt,omitempty"`
+	LagSeconds int64      `json:"lagSeconds"` // How far the device's last pull trails the newest server change
+}
+
+// SyncMetadata represents a read-only view of a user's sync state (never includes note content)
+type SyncMetadata struct {
+	UserID           string            `json:"userId"`
+	UserCreatedAt    *time.Time        `json:"userCreatedAt,omitempty"`
+	NoteCount        int               `json:"noteCount"`
+	DeletedNoteCount int               `json:"deletedNoteCount"`
+	CollectionCount  int               `json:"collectionCount"`
+	LastNoteUpdateAt *time.Time        `json:"lastNoteUpdateAt,omitempty"`
+	LastDeletionAt   *time.Time        `json:"lastDeletionAt,omitempty"`
+	Devices          []DeviceSyncState `json:"devices"`
+}
+
+// Dependency health statuses
+const (
+	HealthOK   = "ok"
+	HealthDown = "down"
+)
+
+// HealthCheckResult is the outcome of one dependency check
+type HealthCheckResult struct {
+	Dependency string    `json:"dependency"`
+	Status     string    `json:"status"`
+	LatencyMs  int64     `json:"latencyMs"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+// HealthIncident is a run of consecutive failed checks for one dependency
+type HealthIncident struct {
+	Dependency string     `json:"dependency"`
+	Start      time.Time  `json:"start"`
+	End        *time.Time `json:"end,omitempty"` // First successful check after the incident; nil if ongoing
+	Checks     int        `json:"checks"`
+	LastError  string     `json:"lastError,omitempty"`
+}
+
+// HealthHistoryResponse is returned by GET /api/admin/health/history
+type HealthHistoryResponse struct {
+	Checks    []HealthCheckResult `json:"checks"`    // Newest first
+	Incidents []HealthIncident    `json:"incidents"` // Newest first
+}
+
+// UserMergeRequest is the body of POST /api/admin/users/merge
+type UserMergeRequest struct {
+	SourceUserID string `json:"sourceUserId"`
+	TargetUserID string `json:"targetUserId"`
+	DryRun       *bool  `json:"dryRun,omitempty"` // Defaults to true; the merge is only applied if false
+}
+
+// RenamedCollection is a moved collection renamed because the target has one of the same name
+type RenamedCollection struct {
+	CollectionID string `json:"collectionId"`
+	Name         string `json:"name"` // The new name
+}
+
+// UserMergeReport describes what a user merge moved, or on a dry run would move
+type UserMergeReport struct {
+	SourceUserID       string              `json:"sourceUserId"`
+	TargetUserID       string              `json:"targetUserId"`
+	DryRun             bool                `json:"dryRun"`
+	Moved              map[string]int64    `json:"moved"`   // Rows moved to the target, by table
+	Dropped            map[string]int64    `json:"dropped"` // Source rows the target already had or that can't move, by table
+	RenamedCollections []RenamedCollection `json:"renamedCollections"`
+	Blockers           []string            `json:"blockers"` // Why the merge was refused; nothing was changed
+}
@@ -0,0 +1,28 @@
+// Domain-scoped capture rule data models
+package models
+
+import "time"
+
+// CaptureRule sets defaults for notes captured on a domain and its subdomains
+type CaptureRule struct {
+	ID               string    `json:"id"`
+	Domain           string    `json:"domain"`                 // e.g. "github.com"; also matches "gist.github.com"
+	CollectionID     *string   `json:"collectionId,omitempty"` // Unset, or the collection was deleted
+	Tags             []string  `json:"tags"`
+	CleanupOnCapture bool      `json:"cleanupOnCapture"` // Run AI cleanup on captured content
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// CaptureRuleRequest creates a rule, or changes the given fields of one; omitted fields are unchanged
+type CaptureRuleRequest struct {
+	Domain           *string  `json:"domain,omitempty"`
+	CollectionID     *string  `json:"collectionId,omitempty"` // "" clears the collection
+	Tags             []string `json:"tags,omitempty"`
+	CleanupOnCapture *bool    `json:"cleanupOnCapture,omitempty"`
+}
+
+// CaptureRuleMatch is the rule that applies to a captured page, or nil if none does
+type CaptureRuleMatch struct {
+	Rule *CaptureRule `json:"rule"`
+}
@@ -0,0 +1,44 @@
+// Chat session data models
+package models
+
+import "time"
+
+// Chat message roles
+const (
+	ChatRoleUser      = "user"
+	ChatRoleAssistant = "assistant"
+)
+
+// ChatSession is a persisted multi-turn conversation
+type ChatSession struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"` // Time of the latest message
+}
+
+// CreateChatSessionRequest starts a new chat session
+type CreateChatSessionRequest struct {
+	Title string `json:"title,omitempty"`
+}
+
+// ChatMessage is one turn of a chat session
+type ChatMessage struct {
+	ID        string    `json:"id"`
+	Role      string    `json:"role"` // user or assistant
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ChatSessionMessageRequest sends a message in a chat session; earlier turns are added by the server
+type ChatSessionMessageRequest struct {
+	Provider     string `json:"provider"`
+	Prompt       string `json:"prompt"`
+	ContextNotes []Note `json:"contextNotes"`
+}
+
+// ChatTurnResponse is the stored user message and the assistant's reply
+type ChatTurnResponse struct {
+	Message ChatMessage `json:"message"`
+	Reply   ChatMessage `json:"reply"`
+}
@@ -0,0 +1,44 @@
+// Client error report data models
+package models
+
+import "time"
+
+// Breadcrumb is one step the client took before an error (navigation, click, request)
+type Breadcrumb struct {
+	Timestamp time.Time `json:"timestamp"`
+	Category  string    `json:"category"` // e.g. "navigation", "ui", "http"
+	Message   string    `json:"message"`
+}
+
+// ClientErrorReport is a structured error report from the extension or web app
+type ClientErrorReport struct {
+	Message     string       `json:"message"`
+	Stack       string       `json:"stack,omitempty"`
+	AppVersion  string       `json:"appVersion"`
+	Platform    string       `json:"platform"` // e.g. "chrome-extension", "web"
+	URL         string       `json:"url,omitempty"`
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs,omitempty"`
+	RequestIDs  []string     `json:"requestIds,omitempty"` // X-Request-ID of backend responses related to the error
+}
+
+// ClientErrorReportResponse tells the client whether its report was kept
+type ClientErrorReportResponse struct {
+	Accepted bool `json:"accepted"` // False if the report was sampled out
+}
+
+// ClientError is a stored error report, with repeats folded in
+type ClientError struct {
+	ID          string       `json:"id"`
+	UserID      string       `json:"userId"`
+	Fingerprint string       `json:"fingerprint"`
+	Message     string       `json:"message"`
+	Stack       string       `json:"stack,omitempty"`
+	AppVersion  string       `json:"appVersion,omitempty"`
+	Platform    string       `json:"platform,omitempty"`
+	URL         string       `json:"url,omitempty"`
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs"`
+	RequestIDs  []string     `json:"requestIds"`
+	Occurrences int          `json:"occurrences"`
+	FirstSeenAt time.Time    `json:"firstSeenAt"`
+	LastSeenAt  time.Time    `json:"lastSeenAt"`
+}
@@ -0,0 +1,30 @@
+// Collection overview data models
+package models
+
+import "time"
+
+// CollectionOverview is an AI summary of what a collection contains, generated from its notes' titles,
+// tags, and embeddings
+type CollectionOverview struct {
+	CollectionID string                   `json:"collectionId"`
+	Description  string                   `json:"description"` // A sentence or two, short enough to save as the collection's description
+	Themes       []string                 `json:"themes"`
+	NotableNotes []CollectionOverviewNote `json:"notableNotes"`
+	Gaps         []string                 `json:"gaps"`      // Topics the collection seems to be missing
+	NoteCount    int                      `json:"noteCount"` // Notes in the collection when it was generated
+	GeneratedAt  time.Time                `json:"generatedAt"`
+}
+
+// CollectionOverviewNote is a note the overview singles out, and why
+type CollectionOverviewNote struct {
+	NoteID string `json:"noteId"`
+	Title  string `json:"title"`
+	Reason string `json:"reason"`
+}
+
+// CollectionOverviewSource is a note the AI generates an overview from
+type CollectionOverviewSource struct {
+	ID    string   `json:"id"`
+	Title string   `json:"title"`
+	Tags  []string `json:"tags,omitempty"`
+}
@@ -0,0 +1,53 @@
+// Collection management data models
+package models
+
+// Cascade policies for member notes when a collection is deleted
+const (
+	CascadeOrphan = "orphan" // Remove notes from the collection, keep them
+	CascadeMove   = "move"   // Move notes to another collection
+	CascadeDelete = "delete" // Soft-delete the notes
+)
+
+// DeleteCollectionRequest represents a request to delete a collection
+type DeleteCollectionRequest struct {
+	Policy             string `json:"policy"`                       // orphan (default), move, or delete
+	TargetCollectionID string `json:"targetCollectionId,omitempty"` // Required for the move policy
+}
+
+// NoteCascadeResult represents what happened to a single member note
+type NoteCascadeResult struct {
+	NoteID string `json:"noteId"`
+	Status string `json:"status"` // orphaned, moved, or deleted
+}
+
+// DeleteCollectionResponse represents the result of deleting a collection
+type DeleteCollectionResponse struct {
+	CollectionID string              `json:"collectionId"`
+	Policy       string              `json:"policy"`
+	Notes        []NoteCascadeResult `json:"notes"`
+	Reparented   []string            `json:"reparentedCollectionIds,omitempty"` // Child collections moved up to the deleted collection's parent
+}
+
+// CreateCollectionRequest represents a request to create a collection
+type CreateCollectionRequest struct {
+	ID          string  `json:"id,omitempty"` // Client-generated ID; generated by the server if omitted
+	Name        string  `json:"name"`
+	Icon        string  `json:"icon"`
+	Color       *string `json:"color,omitempty"`
+	Description *string `json:"description,omitempty"`
+	SortIndex   *int    `json:"sortIndex,omitempty"`
+	Cover       *string `json:"cover,omitempty"`
+	ParentID    *string `json:"parentId,omitempty"` // Omitted or "" for a top-level collection
+}
+
+// UpdateCollectionRequest represents a partial update of a collection; omitted fields are unchanged,
+// "" clears color, description, or cover, and parentId "" moves the collection to the top level
+type UpdateCollectionRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Icon        *string `json:"icon,omitempty"`
+	Color       *string `json:"color,omitempty"`
+	Description *string `json:"description,omitempty"`
+	SortIndex   *int    `json:"sortIndex,omitempty"`
+	Cover       *string `json:"cover,omitempty"`
+	ParentID    *string `json:"parentId,omitempty"`
+}
@@ -0,0 +1,34 @@
+// Full data export data models
+package models
+
+import "time"
+
+// DataExportVersion is the version of the export archive layout, bumped on incompatible changes
+const DataExportVersion = 1
+
+// DataExportManifest is manifest.json at the root of a data export archive
+type DataExportManifest struct {
+	Version     int                    `json:"version"`
+	ExportedAt  time.Time              `json:"exportedAt"`
+	UserID      string                 `json:"userId"`
+	Encryption  *EncryptionMetadata    `json:"encryption,omitempty"` // Derives the key the notes are encrypted under; nil if never set up
+	Collections []DataExportCollection `json:"collections"`
+	Notes       []DataExportNote       `json:"notes"`
+}
+
+// DataExportCollection is a collection and the archive folder holding its notes
+type DataExportCollection struct {
+	SyncCollection
+	Path string `json:"path"`
+}
+
+// DataExportNote lists a note in the manifest. Its encrypted body is in the archive file at Path.
+type DataExportNote struct {
+	ID            string    `json:"id"`
+	Title         string    `json:"title"`
+	Path          string    `json:"path"`
+	CollectionIDs []string  `json:"collectionIds"`
+	Tags          []string  `json:"tags"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,87 @@
+// E2E encryption metadata data models
+package models
+
+import "time"
+
+// Supported key-derivation functions
+const (
+	KDFPBKDF2SHA256 = "pbkdf2-sha256"
+	KDFArgon2id     = "argon2id"
+)
+
+// KDFParams describes how the passphrase-derived key is computed
+type KDFParams struct {
+	Algorithm   string `json:"algorithm"`
+	Iterations  int    `json:"iterations"`            // PBKDF2 iterations or Argon2 time cost
+	MemoryKiB   int    `json:"memoryKiB,omitempty"`   // Argon2 only
+	Parallelism int    `json:"parallelism,omitempty"` // Argon2 only
+}
+
+// EncryptionMetadata is what a new device needs to derive the user's keys.
+// Binary fields are base64 encoded; the master key only ever appears wrapped.
+type EncryptionMetadata struct {
+	KeyVersion           int       `json:"keyVersion"`
+	KDF                  KDFParams `json:"kdf"`
+	Salt                 string    `json:"salt"`
+	WrappedKey           string    `json:"wrappedKey"`
+	WrappedKeyIV         string    `json:"wrappedKeyIV"`
+	RecoveryWrappedKey   string    `json:"recoveryWrappedKey,omitempty"`
+	RecoveryWrappedKeyIV string    `json:"recoveryWrappedKeyIV,omitempty"`
+	Version              int64     `json:"version"`
+	UpdatedAt            time.Time `json:"updatedAt"`
+}
+
+// PutEncryptionMetadataRequest represents a write of the user's encryption metadata
+type PutEncryptionMetadataRequest struct {
+	KeyVersion           int       `json:"keyVersion"`
+	KDF                  KDFParams `json:"kdf"`
+	Salt                 string    `json:"salt"`
+	WrappedKey           string    `json:"wrappedKey"`
+	WrappedKeyIV         string    `json:"wrappedKeyIV"`
+	RecoveryWrappedKey   string    `json:"recoveryWrappedKey,omitempty"`
+	RecoveryWrappedKeyIV string    `json:"recoveryWrappedKeyIV,omitempty"`
+	BaseVersion          *int64    `json:"baseVersion,omitempty"` // Version the client last saw; omit to create
+}
+
+// EncryptionMetadataConflictResponse is returned when a write was based on a stale version
+type EncryptionMetadataConflictResponse struct {
+	Error   string             `json:"error"`
+	Current EncryptionMetadata `json:"current"`
+}
+
+// PutKeyEscrowRequest opts into recovery escrow: the master key wrapped with a client-generated
+// escrow secret, plus that secret (all base64 encoded). The server seals the secret at rest.
+type PutKeyEscrowRequest struct {
+	KeyVersion   int    `json:"keyVersion"`
+	WrappedKey   string `json:"wrappedKey"`
+	WrappedKeyIV string `json:"wrappedKeyIV"`
+	EscrowSecret string `json:"escrowSecret"`
+}
+
+// KeyEscrowStatus reports whether the user has opted into recovery escrow
+type KeyEscrowStatus struct {
+	Enabled    bool       `json:"enabled"`
+	KeyVersion int        `json:"keyVersion,omitempty"`
+	UpdatedAt  *time.Time `json:"updatedAt,omitempty"`
+}
+
+// StartEscrowRecoveryResponse is returned after a recovery code has been emailed
+type StartEscrowRecoveryResponse struct {
+	RecoveryID string    `json:"recoveryId"`
+	Email      string    `json:"email"` // Masked, e.g. "j***@example.com"
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// VerifyEscrowRecoveryRequest exchanges an emailed code for the escrowed key
+type VerifyEscrowRecoveryRequest struct {
+	RecoveryID string `json:"recoveryId"`
+	Code       string `json:"code"`
+}
+
+// EscrowRecoveryResponse releases the escrowed key after a verified recovery
+type EscrowRecoveryResponse struct {
+	KeyVersion   int    `json:"keyVersion"`
+	WrappedKey   string `json:"wrappedKey"`
+	WrappedKeyIV string `json:"wrappedKeyIV"`
+	EscrowSecret string `json:"escrowSecret"`
+}
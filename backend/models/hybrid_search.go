@@ -0,0 +1,25 @@
+// Hybrid search data models
+package models
+
+import "time"
+
+// HybridSearchResult is a note matching a hybrid search by keyword, by meaning, or both
+type HybridSearchResult struct {
+	NoteID       string     `json:"noteId"`
+	Title        string     `json:"title"`
+	Domain       string     `json:"domain,omitempty"`
+	Tags         []string   `json:"tags"`
+	Score        float64    `json:"score"`                  // Reciprocal-rank fusion of the two rankings, higher is better
+	KeywordRank  int        `json:"keywordRank,omitempty"`  // 1-based rank among keyword matches; omitted if not one
+	SemanticRank int        `json:"semanticRank,omitempty"` // 1-based rank among semantic matches; omitted if not one
+	Archived     bool       `json:"archived,omitempty"`
+	DeletedAt    *time.Time `json:"deletedAt,omitempty"` // Set for notes in the trash
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// HybridSearchResponse lists the best matches first. Semantic is false when only keyword matching ran
+// (no provider key, or the provider failed).
+type HybridSearchResponse struct {
+	Results  []HybridSearchResult `json:"results"`
+	Semantic bool                 `json:"semantic"`
+}
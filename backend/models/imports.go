@@ -0,0 +1,49 @@
+// Import data models
+package models
+
+import "time"
+
+// Import formats
+const (
+	ImportFormatMarkdown = "markdown"
+	ImportFormatENEX     = "enex"   // Evernote notebook export
+	ImportFormatNotion   = "notion" // Notion "Markdown & CSV" ZIP export
+)
+
+// How imports treat notes whose content was already staged for the user
+const (
+	DuplicatesSkip = "skip" // Leave them out of the inbox (default)
+	DuplicatesFlag = "flag" // Stage them, marked as duplicates
+)
+
+// ImportParams are the options of an import job
+type ImportParams struct {
+	Format      string `json:"format"`
+	Filename    string `json:"filename"`
+	OnDuplicate string `json:"onDuplicate,omitempty"`
+}
+
+// ImportedNote represents a note converted from an external format (plaintext, not yet encrypted)
+type ImportedNote struct {
+	Title      string     `json:"title"`
+	Content    string     `json:"content"`
+	Collection string     `json:"collection,omitempty"` // Source folder/notebook name
+	Tags       []string   `json:"tags,omitempty"`
+	SourcePath string     `json:"sourcePath"`
+	Date       *time.Time `json:"date,omitempty"`
+}
+
+// ImportResult represents the output of an import job; the notes themselves land in the staged notes inbox
+type ImportResult struct {
+	StagedNoteIDs []string        `json:"stagedNoteIds"`
+	Collections   []string        `json:"collections"`
+	Duplicates    []DuplicateNote `json:"duplicates"`
+}
+
+// DuplicateNote reports a note whose content had already been staged for the user
+type DuplicateNote struct {
+	SourcePath   string    `json:"sourcePath,omitempty"`
+	Title        string    `json:"title"`
+	StagedNoteID string    `json:"stagedNoteId,omitempty"` // Set if the duplicate was staged anyway (flagged)
+	FirstSeenAt  time.Time `json:"firstSeenAt"`            // When the content was first staged
+}
@@ -0,0 +1,38 @@
+// Capture inbox data models
+package models
+
+import "time"
+
+// Error codes for public tokenized endpoints
+const (
+	ErrCodeTokenExpired  = "TOKEN_EXPIRED"
+	ErrCodeTokenReplayed = "TOKEN_REPLAYED"
+	ErrCodeRateLimited   = "RATE_LIMITED"
+)
+
+// CreateInboxTokenRequest represents a request to create a capture inbox token
+type CreateInboxTokenRequest struct {
+	Label string `json:"label,omitempty"` // e.g. "Email forwarding"
+}
+
+// InboxTokenResponse represents a newly created capture inbox token. The secret is only returned once.
+type InboxTokenResponse struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"` // Base64 encoded HMAC-SHA256 secret
+	Label     string    `json:"label,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// InboxCaptureRequest represents content posted to a capture inbox
+type InboxCaptureRequest struct {
+	Source    string `json:"source"` // email or clipper
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	SourceURL string `json:"sourceUrl,omitempty"`
+}
+
+// InboxCaptureResponse is returned when content is accepted into the staged notes inbox
+type InboxCaptureResponse struct {
+	StagedNoteID string `json:"stagedNoteId"`
+	Duplicate    bool   `json:"duplicate"` // The same content was staged before
+}
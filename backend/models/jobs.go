@@ -0,0 +1,52 @@
+// Background job data models
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Job types
+const (
+	JobTypeImport = "import"
+)
+
+// JobProgress represents how far a job has progressed
+type JobProgress struct {
+	Current int `json:"current"`
+	Total   int `json:"total"`
+}
+
+// JobItemError represents a partial failure on a single item of a job
+type JobItemError struct {
+	Item    string `json:"item"`
+	Message string `json:"message"`
+}
+
+// Job represents a background job as reported to clients
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Status     string          `json:"status"`
+	Progress   JobProgress     `json:"progress"`
+	Errors     []JobItemError  `json:"errors"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// JobCreatedResponse is returned when a job is enqueued
+type JobCreatedResponse struct {
+	JobID  string `json:"jobId"`
+	Status string `json:"status"`
+}
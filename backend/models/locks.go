@@ -0,0 +1,32 @@
+// Note lock data models
+package models
+
+import "time"
+
+// Destructive operations guarded by note locks
+const (
+	LockMerge   = "merge"
+	LockRestore = "restore"
+	LockPurge   = "purge"
+	LockDelete  = "delete" // Cascade soft-delete of a collection's notes
+)
+
+// ErrCodeNoteLocked is returned when a note is held by a destructive operation
+const ErrCodeNoteLocked = "NOTE_LOCKED"
+
+// NoteLock describes who holds a note lock
+type NoteLock struct {
+	NoteID       string    `json:"noteId"`
+	Operation    string    `json:"operation"`
+	HolderUserID string    `json:"holderUserId"`
+	DeviceID     string    `json:"deviceId,omitempty"`
+	AcquiredAt   time.Time `json:"acquiredAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// NoteLockedResponse is returned with 409 when a note lock is contended
+type NoteLockedResponse struct {
+	Error string     `json:"error"`
+	Code  string     `json:"code"`
+	Locks []NoteLock `json:"locks"`
+}
@@ -0,0 +1,117 @@
+// Note calendar dates
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// NoteDate is the calendar day a note belongs to, as the user saw it on their device: a date without
+// a time or time zone, stored in notes.date (DATE) and encoded as "YYYY-MM-DD".
+//
+// Older clients send an RFC 3339 timestamp instead. One with a non-zero offset is the client's local
+// time, so its day is taken as written. So is exact UTC midnight: that's a date pulled as "YYYY-MM-DD"
+// and echoed back as a timestamp, and resolving it in a zone west of UTC would move it back a day.
+// Any other UTC timestamp ("Z") is an instant on the client, so its day depends on the user's time
+// zone; it stays unresolved until In places it there.
+type NoteDate struct {
+	day     time.Time // Midnight UTC of the day, or the UTC timestamp while unresolved
+	instant bool      // day is a UTC timestamp that hasn't been placed in the user's zone yet
+}
+
+// ParseNoteDate parses a "YYYY-MM-DD" date or a legacy RFC 3339 timestamp
+func ParseNoteDate(value string) (NoteDate, error) {
+	if day, err := time.Parse(time.DateOnly, value); err == nil {
+		return NoteDate{day: day}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return NoteDate{}, fmt.Errorf("date must be YYYY-MM-DD or an RFC 3339 timestamp: %q", value)
+	}
+	if _, offset := t.Zone(); offset == 0 && !isMidnight(t) {
+		return NoteDate{day: t.UTC(), instant: true}, nil
+	}
+	return NoteDateOf(t), nil
+}
+
+// NoteDateOf returns the day of t in t's own location
+func NoteDateOf(t time.Time) NoteDate {
+	return NoteDate{day: time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}
+}
+
+func isMidnight(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
+
+// In resolves a date parsed from a UTC timestamp to its day in loc. Other dates are returned as they are.
+func (d NoteDate) In(loc *time.Location) NoteDate {
+	if !d.instant {
+		return d
+	}
+	return NoteDateOf(d.day.In(loc))
+}
+
+// IsZero reports whether the date is unset
+func (d NoteDate) IsZero() bool {
+	return d.day.IsZero()
+}
+
+// String returns the date as YYYY-MM-DD. An unresolved UTC timestamp gives its day in UTC.
+func (d NoteDate) String() string {
+	return d.In(time.UTC).day.Format(time.DateOnly)
+}
+
+// MarshalJSON encodes the date as "YYYY-MM-DD", or null if it's unset
+func (d NoteDate) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON decodes a "YYYY-MM-DD" date or a legacy RFC 3339 timestamp; null or "" leave it unset
+func (d *NoteDate) UnmarshalJSON(data []byte) error {
+	var value *string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("date must be a string: %w", err)
+	}
+	if value == nil || *value == "" {
+		*d = NoteDate{}
+		return nil
+	}
+	parsed, err := ParseNoteDate(*value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Value stores the date as YYYY-MM-DD, or NULL if it's unset
+func (d NoteDate) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.String(), nil
+}
+
+// Scan reads a DATE column
+func (d *NoteDate) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = NoteDate{}
+	case time.Time:
+		*d = NoteDateOf(v)
+	case string:
+		parsed, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return fmt.Errorf("invalid note date %q: %w", v, err)
+		}
+		*d = NoteDate{day: parsed}
+	default:
+		return fmt.Errorf("cannot scan %T into a note date", src)
+	}
+	return nil
+}
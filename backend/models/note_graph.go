@@ -0,0 +1,40 @@
+// Notes graph data models
+package models
+
+// GraphNote is a note in the notes graph
+type GraphNote struct {
+	NoteID string `json:"noteId"`
+	Title  string `json:"title"`
+}
+
+// GraphHub is one of the most linked notes
+type GraphHub struct {
+	GraphNote
+	Links     int `json:"links"`     // Distinct notes it links to or is linked from
+	Backlinks int `json:"backlinks"` // Notes linking to it
+}
+
+// GraphCluster is a group of notes connected by links
+type GraphCluster struct {
+	Size    int      `json:"size"`
+	NoteIDs []string `json:"noteIds"`
+}
+
+// LinkSuggestion proposes linking an unlinked note to a note sharing its tags
+type LinkSuggestion struct {
+	NoteID     string   `json:"noteId"`
+	TargetID   string   `json:"targetId"`
+	Title      string   `json:"title"` // The target's title
+	SharedTags []string `json:"sharedTags"`
+}
+
+// NoteGraphMetrics describes how the user's live notes link to each other
+type NoteGraphMetrics struct {
+	NoteCount   int              `json:"noteCount"`
+	LinkCount   int              `json:"linkCount"`
+	OrphanCount int              `json:"orphanCount"`
+	Orphans     []GraphNote      `json:"orphans"` // Notes without links, most recently updated first
+	Hubs        []GraphHub       `json:"hubs"`
+	Clusters    []GraphCluster   `json:"clusters"` // Largest first; notes without links aren't clusters
+	Suggestions []LinkSuggestion `json:"suggestions"`
+}
@@ -0,0 +1,83 @@
+// Note metadata data models
+package models
+
+import "time"
+
+// PatchNoteMetaRequest changes a note's plaintext metadata without touching its encrypted body.
+// Omitted fields are unchanged; collectionIds, tags, and linkedNoteIds replace the current sets.
+type PatchNoteMetaRequest struct {
+	IsPinned      *bool     `json:"isPinned,omitempty"`
+	IsArchived    *bool     `json:"isArchived,omitempty"`
+	AIExcluded    *bool     `json:"aiExcluded,omitempty"`
+	CollectionIDs *[]string `json:"collectionIds,omitempty"`
+	Tags          *[]string `json:"tags,omitempty"`
+	ReminderAt    *string   `json:"reminderAt,omitempty"`    // RFC 3339 timestamp; "" clears the reminder
+	LinkedNoteIDs *[]string `json:"linkedNoteIds,omitempty"` // Notes the decrypted body links to, found by the client
+	SnoozedUntil  *string   `json:"snoozedUntil,omitempty"`  // Future RFC 3339 timestamp or YYYY-MM-DD; "" ends the snooze
+	SnoozeNotify  *bool     `json:"snoozeNotify,omitempty"`  // Notify connected devices when the snooze ends
+}
+
+// NoteMeta is a note's plaintext metadata
+type NoteMeta struct {
+	ID            string     `json:"id"`
+	IsPinned      bool       `json:"isPinned"`
+	IsArchived    bool       `json:"isArchived"`
+	AIExcluded    bool       `json:"aiExcluded"`
+	CollectionIDs []string   `json:"collectionIds"`
+	Tags          []string   `json:"tags"`
+	ReminderAt    *time.Time `json:"reminderAt,omitempty"`
+	LinkedNoteIDs []string   `json:"linkedNoteIds"`
+	SnoozedUntil  *time.Time `json:"snoozedUntil,omitempty"`
+	SnoozeNotify  bool       `json:"snoozeNotify"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// ExportNoteRequest carries the note's decrypted markdown, which the server can't read from storage
+type ExportNoteRequest struct {
+	Markdown string `json:"markdown"`
+}
+
+// NoteRevision is an earlier version of a note's title and encrypted content
+type NoteRevision struct {
+	ID               string    `json:"id"`
+	Title            string    `json:"title"`
+	ContentEncrypted string    `json:"contentEncrypted"` // Base64, decrypted by the client like the note's content
+	ContentIV        string    `json:"contentIV"`
+	DeviceID         string    `json:"deviceId,omitempty"` // Device that saved this version
+	SavedAt          time.Time `json:"savedAt"`            // The version's updatedAt
+	ReplacedAt       time.Time `json:"replacedAt"`         // When a later save replaced it
+}
+
+// RestoreNoteRequest restores a note to one of its revisions, or without a revision ID from the trash
+type RestoreNoteRequest struct {
+	RevisionID string `json:"revisionId,omitempty"`
+}
+
+// TrashedNote is a soft-deleted note, restorable until it's purged
+type TrashedNote struct {
+	SyncNote
+	PurgeAt *time.Time `json:"purgeAt,omitempty"` // When it's permanently deleted; omitted if the trash is kept
+}
+
+// Note timeline event types
+const (
+	NoteEventCreated      = "created"
+	NoteEventUpdated      = "updated"
+	NoteEventDeleted      = "deleted"
+	NoteEventRestored     = "restored" // A deleted note was saved again, e.g. restored from the trash
+	NoteEventMetaUpdated  = "meta_updated"
+	NoteEventConflict     = "conflict"      // A push overwrote changes it hadn't seen; relatedNoteId is the kept copy, if any
+	NoteEventConflictCopy = "conflict_copy" // The note was created as a conflict copy of relatedNoteId
+	NoteEventShared       = "shared"        // A share link was created
+	NoteEventUnshared     = "unshared"      // Share links were revoked
+	NoteEventSnoozeEnded  = "snooze_ended"  // The note's snooze passed and it came back
+)
+
+// NoteEvent is one entry of a note's activity timeline. Events never carry note content.
+type NoteEvent struct {
+	ID            string    `json:"id"`
+	Type          string    `json:"type"`
+	DeviceID      string    `json:"deviceId,omitempty"`
+	RelatedNoteID string    `json:"relatedNoteId,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
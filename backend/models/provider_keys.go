@@ -0,0 +1,45 @@
+// Stored AI provider key data models
+package models
+
+import "time"
+
+// Provider key audit actions
+const (
+	ProviderKeyStored   = "stored"   // First key saved for a provider
+	ProviderKeyRotated  = "rotated"  // Stored key replaced by a new one
+	ProviderKeyDeleted  = "deleted"  // Stored key removed
+	ProviderKeyRejected = "rejected" // New key failed validation; the stored key was kept
+)
+
+// ErrCodeProviderKeyRejected is returned when the provider refuses a key being stored
+const ErrCodeProviderKeyRejected = "PROVIDER_KEY_REJECTED"
+
+// PutProviderKeyRequest stores or rotates the key for a provider
+type PutProviderKeyRequest struct {
+	APIKey string `json:"apiKey"`
+}
+
+// StoredProviderKey describes a stored key without revealing it
+type StoredProviderKey struct {
+	Provider  string     `json:"provider"`
+	KeyHint   string     `json:"keyHint"` // Last 4 characters
+	CreatedAt time.Time  `json:"createdAt"`
+	RotatedAt *time.Time `json:"rotatedAt,omitempty"`
+}
+
+// AIKeySetting is a stored key as the /api/settings/ai-key endpoints show it
+type AIKeySetting struct {
+	StoredProviderKey
+	MaskedKey string `json:"maskedKey"` // e.g. "••••••••abcd"
+}
+
+// ProviderKeyEvent is one entry in the provider key audit trail
+type ProviderKeyEvent struct {
+	ID         string    `json:"id"`
+	Provider   string    `json:"provider"`
+	Action     string    `json:"action"`
+	OldKeyHint string    `json:"oldKeyHint,omitempty"`
+	NewKeyHint string    `json:"newKeyHint,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
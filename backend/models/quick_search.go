@@ -0,0 +1,21 @@
+// Quick search data models
+package models
+
+import "time"
+
+// QuickSearchResult is a note matching a quick search by title, domain, or tag
+type QuickSearchResult struct {
+	NoteID    string     `json:"noteId"`
+	Title     string     `json:"title"`
+	Domain    string     `json:"domain,omitempty"`
+	Tags      []string   `json:"tags"`
+	Score     float64    `json:"score"` // Trigram similarity of the best matching field; 1 for an exact tag match
+	Archived  bool       `json:"archived,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"` // Set for notes in the trash
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// QuickSearchResponse lists the best matches first
+type QuickSearchResponse struct {
+	Results []QuickSearchResult `json:"results"`
+}
@@ -0,0 +1,50 @@
+// Realtime (WebSocket) message models
+package models
+
+import "time"
+
+// Realtime message types
+const (
+	RealtimePresence    = "presence"     // Client: set own presence on a note. Server: current presence on a note
+	RealtimeChanges     = "changes"      // Server: notes or collections changed; pull them with /api/sync/notes?since=
+	RealtimeResync      = "resync"       // Server: messages were dropped because the client fell behind; re-pull and re-send presence
+	RealtimeSnoozeEnded = "snooze_ended" // Server: a snoozed note with snoozeNotify came back (noteId)
+	RealtimeError       = "error"
+)
+
+// Presence states
+const (
+	PresenceViewing = "viewing"
+	PresenceEditing = "editing"
+	PresenceLeft    = "left"
+)
+
+// RealtimeMessage represents a message exchanged over the realtime WebSocket connection
+type RealtimeMessage struct {
+	Type     string          `json:"type"`
+	NoteID   string          `json:"noteId,omitempty"`
+	State    string          `json:"state,omitempty"`    // Client presence updates
+	Presence []PresenceEntry `json:"presence,omitempty"` // Server presence snapshots
+	Changes  *ChangeBatch    `json:"changes,omitempty"`  // Server change notifications
+	Dropped  int             `json:"dropped,omitempty"`  // Server resync markers: messages dropped so far
+	Error    string          `json:"error,omitempty"`
+}
+
+// ChangeBatch coalesces the changes made during a burst (e.g. a large import) into one notification.
+// ID lists are capped; when Truncated is set, clients should pull everything changed since Since.
+type ChangeBatch struct {
+	NoteCount       int       `json:"noteCount"`
+	NoteIDs         []string  `json:"noteIds"`
+	CollectionCount int       `json:"collectionCount"`
+	CollectionIDs   []string  `json:"collectionIds"`
+	Truncated       bool      `json:"truncated"`
+	Since           time.Time `json:"since"` // When the first change in the batch was published
+}
+
+// PresenceEntry represents one connection viewing or editing a note
+type PresenceEntry struct {
+	UserID    string    `json:"userId"`
+	DeviceID  string    `json:"deviceId,omitempty"`
+	State     string    `json:"state"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
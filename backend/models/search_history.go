@@ -0,0 +1,33 @@
+// Search history and suggestion data models
+package models
+
+import "time"
+
+// Search suggestion kinds
+const (
+	SuggestionKindQuery = "query" // A recent search
+	SuggestionKindTag   = "tag"
+	SuggestionKindTitle = "title"
+)
+
+// RecentSearch is a query the user searched for
+type RecentSearch struct {
+	Query      string    `json:"query"`
+	SearchedAt time.Time `json:"searchedAt"`
+}
+
+// RecentSearchesResponse lists recent searches, most recent first
+type RecentSearchesResponse struct {
+	Searches []RecentSearch `json:"searches"`
+}
+
+// SearchSuggestion is a completion offered for the search box
+type SearchSuggestion struct {
+	Text string `json:"text"`
+	Kind string `json:"kind"` // query, tag, or title
+}
+
+// SearchSuggestionsResponse lists suggestions: recent searches first, then tags, then note titles
+type SearchSuggestionsResponse struct {
+	Suggestions []SearchSuggestion `json:"suggestions"`
+}
@@ -0,0 +1,34 @@
+// Semantic search data models
+package models
+
+import "time"
+
+// SemanticSearchRequest searches the user's indexed notes by meaning
+type SemanticSearchRequest struct {
+	Query           string `json:"query"`
+	Limit           int    `json:"limit,omitempty"`           // Defaults to 10, at most 50
+	CollectionID    string `json:"collectionId,omitempty"`    // Only search notes in this collection
+	IncludeTrashed  bool   `json:"includeTrashed,omitempty"`  // Also search notes in the trash
+	IncludeArchived bool   `json:"includeArchived,omitempty"` // Also search archived notes
+}
+
+// SemanticSearchResult is a matching note; clients decrypt its content locally
+type SemanticSearchResult struct {
+	NoteID    string     `json:"noteId"`
+	Title     string     `json:"title"`
+	Score     float64    `json:"score"` // Cosine similarity, higher is closer
+	Archived  bool       `json:"archived,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"` // Set for notes in the trash
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// SemanticSearchResponse lists the closest notes, best match first
+type SemanticSearchResponse struct {
+	Results []SemanticSearchResult `json:"results"`
+}
+
+// StaleEmbeddingsResponse lists notes whose content changed since their embedding was computed, for
+// clients to push embeddingText for again
+type StaleEmbeddingsResponse struct {
+	NoteIDs []string `json:"noteIds"`
+}
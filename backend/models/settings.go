@@ -0,0 +1,33 @@
+// Settings data models
+package models
+
+import "time"
+
+// ClientSetting represents a single encrypted client setting
+type ClientSetting struct {
+	Key            string     `json:"key"`
+	ValueEncrypted string     `json:"valueEncrypted"` // Base64 encoded encrypted value
+	ValueIV        string     `json:"valueIV"`        // Base64 encoded IV
+	Version        int64      `json:"version"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	DeletedAt      *time.Time `json:"deletedAt,omitempty"`
+}
+
+// PutClientSettingRequest represents a write to a client setting
+type PutClientSettingRequest struct {
+	ValueEncrypted string `json:"valueEncrypted"`
+	ValueIV        string `json:"valueIV"`
+	BaseVersion    *int64 `json:"baseVersion,omitempty"` // Version the client last saw; omit to create
+}
+
+// ClientSettingsResponse represents a list of client settings
+type ClientSettingsResponse struct {
+	Settings []ClientSetting `json:"settings"`
+	LastSync time.Time       `json:"lastSync"`
+}
+
+// ClientSettingConflictResponse is returned when a write was based on a stale version
+type ClientSettingConflictResponse struct {
+	Error   string        `json:"error"`
+	Current ClientSetting `json:"current"`
+}
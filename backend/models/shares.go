@@ -0,0 +1,65 @@
+// Note sharing data models
+package models
+
+import "time"
+
+// Error codes for share links
+const (
+	ErrCodePasswordRequired = "PASSWORD_REQUIRED"
+	ErrCodePasswordInvalid  = "PASSWORD_INVALID"
+	ErrCodeViewLimitReached = "VIEW_LIMIT_REACHED"
+)
+
+// CreateShareLinkRequest creates a public link to a read-only copy of a note. The copy is encrypted by
+// the client under a key of its own, which it puts in the link's URL fragment rather than sending it.
+type CreateShareLinkRequest struct {
+	ContentEncrypted string     `json:"contentEncrypted"` // Base64
+	ContentIV        string     `json:"contentIV"`        // Base64
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	Password         string     `json:"password,omitempty"`
+	MaxViews         *int       `json:"maxViews,omitempty"` // Views allowed before the link stops working
+}
+
+// ShareLink is a public link to a note. The token is only returned when the link is created.
+type ShareLink struct {
+	ID           string     `json:"id"`
+	NoteID       string     `json:"noteId"`
+	Token        string     `json:"token,omitempty"`
+	HasPassword  bool       `json:"hasPassword"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	ViewCount    int        `json:"viewCount"`
+	MaxViews     *int       `json:"maxViews,omitempty"`
+	LastViewedAt *time.Time `json:"lastViewedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// SharedNote is what a share link serves: the note's title and its copy encrypted under the link's key
+type SharedNote struct {
+	Title            string     `json:"title"`
+	ContentEncrypted string     `json:"contentEncrypted"`
+	ContentIV        string     `json:"contentIV"`
+	SharedAt         time.Time  `json:"sharedAt"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+}
+
+// ShareLinkAnalytics reports how a share link has been viewed over a range of UTC days
+type ShareLinkAnalytics struct {
+	ShareLink
+	Since     string               `json:"since"` // YYYY-MM-DD
+	Until     string               `json:"until"`
+	Daily     []ShareLinkDayViews  `json:"daily"`     // Every day in the range, oldest first
+	Referrers []ShareLinkReferrers `json:"referrers"` // Most views first
+}
+
+// ShareLinkDayViews is the number of views of a share link on a UTC day
+type ShareLinkDayViews struct {
+	Date  string `json:"date"` // YYYY-MM-DD
+	Views int    `json:"views"`
+}
+
+// ShareLinkReferrers is the number of views of a share link referred by a site. Host is empty for
+// direct visits.
+type ShareLinkReferrers struct {
+	Host  string `json:"host"`
+	Views int    `json:"views"`
+}
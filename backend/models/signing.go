@@ -0,0 +1,17 @@
+// Request signing data models
+package models
+
+import "time"
+
+// CreateSigningKeyRequest represents a request to register a new signing key
+type CreateSigningKeyRequest struct {
+	Label string `json:"label,omitempty"` // e.g. "Chrome extension"
+}
+
+// SigningKeyResponse represents a newly created signing key. The secret is only returned once.
+type SigningKeyResponse struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"` // Base64 encoded HMAC-SHA256 secret
+	Label     string    `json:"label,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,34 @@
+// Staged notes inbox data models
+package models
+
+import "time"
+
+// Staged note sources
+const (
+	StagedSourceImport  = "import"
+	StagedSourceEmail   = "email"
+	StagedSourceClipper = "clipper"
+)
+
+// StagedNote represents server-originated plaintext content waiting for a client to encrypt it
+type StagedNote struct {
+	ID             string     `json:"id"`
+	Source         string     `json:"source"`
+	SourceRef      string     `json:"sourceRef,omitempty"`
+	Title          string     `json:"title"`
+	Content        string     `json:"content"`
+	CollectionName string     `json:"collectionName,omitempty"`
+	Tags           []string   `json:"tags"`      // Suggested tags (from the imported note)
+	Duplicate      bool       `json:"duplicate"` // The same content was staged before
+	OriginalDate   *time.Time `json:"originalDate,omitempty"`
+	ClaimedBy      string     `json:"claimedBy,omitempty"` // Device ID holding the claim
+	ClaimedUntil   *time.Time `json:"claimedUntil,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+}
+
+// ClaimStagedNoteResponse is returned when a client claims a staged note
+type ClaimStagedNoteResponse struct {
+	StagedNote
+	ClaimToken string `json:"claimToken"`
+}
@@ -0,0 +1,19 @@
+// Usage statistics data models
+package models
+
+import "time"
+
+// LanguageStat represents how many of a user's notes are in a language
+type LanguageStat struct {
+	Language   string    `json:"language"` // BCP 47 tag
+	NoteCount  int       `json:"noteCount"`
+	Share      float64   `json:"share"` // Fraction of notes with a known language
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+// LanguageStatsResponse represents a user's note language distribution, most used first
+type LanguageStatsResponse struct {
+	Languages       []LanguageStat `json:"languages"`
+	PrimaryLanguage string         `json:"primaryLanguage,omitempty"` // Default for AI prompts
+	UnknownCount    int            `json:"unknownCount"`              // Notes without a reported language
+}
@@ -0,0 +1,24 @@
+// Public status data models
+package models
+
+import "time"
+
+// Coarse component states shown on the public status page
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded" // Failing intermittently or slower than its objectives
+	StatusOutage      = "outage"   // Failing repeatedly
+)
+
+// ComponentStatus is the state of one user-facing component
+type ComponentStatus struct {
+	Name   string `json:"name"` // api, database, ai
+	Status string `json:"status"`
+}
+
+// StatusResponse is returned by GET /status
+type StatusResponse struct {
+	Status     string            `json:"status"` // Worst component state
+	Components []ComponentStatus `json:"components"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+}
@@ -1,7 +1,10 @@
 // Sync-related data models
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // SyncNote represents a note in sync operations
 type SyncNote struct {
@@ -11,22 +14,104 @@ type SyncNote struct {
 	ContentEncrypted string     `json:"contentEncrypted"` // Base64 encoded encrypted content (as string)
 	ContentIV        string     `json:"contentIV"`        // Base64 encoded IV (as string)
 	Domain           *string    `json:"domain,omitempty"`
-	Date             time.Time  `json:"date"`
+	SourceEncrypted  *string    `json:"sourceEncrypted,omitempty"` // Base64 encrypted source page (URL and title); omitted on push to keep, "" clears
+	SourceIV         *string    `json:"sourceIV,omitempty"`        // Base64 IV of sourceEncrypted
+	SourceURLHash    *string    `json:"sourceUrlHash,omitempty"`   // Hex HMAC-SHA256 of the normalized source URL under a client-held key
+	Language         *string    `json:"language,omitempty"`        // Client-detected BCP 47 language tag
+	Date             NoteDate   `json:"date"`                      // Calendar day, YYYY-MM-DD (see NoteDate)
 	IsPinned         bool       `json:"isPinned"`
+	IsArchived       *bool      `json:"isArchived,omitempty"`   // Omitted on push to keep the stored value
+	Tags             []string   `json:"tags"`                   // Omitted (null) on push to keep the stored tags
+	ReminderAt       *time.Time `json:"reminderAt,omitempty"`   // Omitted on push to keep; clear with PATCH /api/notes/{id}/meta
+	SnoozedUntil     *time.Time `json:"snoozedUntil,omitempty"` // Hidden until then; set with PATCH /api/notes/{id}/meta, ignored on push
 	CollectionIDs    []string   `json:"collectionIds,omitempty"`
+	AIExcluded       *bool      `json:"aiExcluded,omitempty"`    // Kept out of server-side AI features; omitted on push to keep
+	ConflictOf       *string    `json:"conflictOf,omitempty"`    // For conflict copies, the note they were copied from
+	BaseUpdatedAt    *time.Time `json:"baseUpdatedAt,omitempty"` // Push only: server updatedAt the edit was based on
+	EmbeddingText    *string    `json:"embeddingText,omitempty"` // Push only, never stored: plaintext to index for semantic search ("" removes)
 	CreatedAt        time.Time  `json:"createdAt"`
 	UpdatedAt        time.Time  `json:"updatedAt"`
 	DeletedAt        *time.Time `json:"deletedAt,omitempty"`
 }
 
-// SyncCollection represents a collection in sync operations
+// SyncConflict reports a pushed note based on an older version than the server's
+type SyncConflict struct {
+	NoteID string    `json:"noteId"`
+	CopyID string    `json:"copyId,omitempty"` // The overwritten version, kept under the keep_both policy
+	Server *SyncNote `json:"server,omitempty"` // The server's version when the note was pushed
+	Client *SyncNote `json:"client,omitempty"` // The pushed version
+}
+
+// Statuses of a pushed item
+const (
+	SyncItemAccepted = "accepted"
+	SyncItemRejected = "rejected"
+)
+
+// Reasons a pushed item was rejected
+const (
+	SyncRejectInvalid    = "invalid"     // The item is malformed
+	SyncRejectFailed     = "failed"      // The server failed to apply it
+	SyncRejectTooLarge   = "too_large"   // Encrypted content over the note size limit
+	SyncRejectForbidden  = "forbidden"   // The ID belongs to another user's note or collection
+	SyncRejectConflict   = "conflict"    // Based on an outdated version, under the reject conflict policy
+	SyncRejectRolledBack = "rolled_back" // Valid, but another item in the push was rejected
+)
+
+// ErrCodeSyncPushRejected is returned when a push is rolled back because one of its items was rejected
+const ErrCodeSyncPushRejected = "SYNC_PUSH_REJECTED"
+
+// ErrCodeLimitExceeded is returned when a push exceeds a configured count limit
+const ErrCodeLimitExceeded = "LIMIT_EXCEEDED"
+
+// Limits reported in LimitExceededResponse
+const (
+	LimitNoteCount = "note_count" // Live notes per user
+	LimitPushItems = "push_items" // Notes and collections in a single push
+)
+
+// LimitExceededResponse is returned when a request exceeds a limit
+type LimitExceededResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+	Limit string `json:"limit"`
+	Max   int    `json:"max"`
+}
+
+// SyncItemResult reports what happened to a single pushed collection or note
+type SyncItemResult struct {
+	Type   string `json:"type"` // collection or note
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"` // Rejected items only
+	Error  string `json:"error,omitempty"`  // Rejected items only, human-readable
+}
+
+// SyncPushRejectedResponse is returned when a push is rolled back; nothing in it was applied
+type SyncPushRejectedResponse struct {
+	Error     string           `json:"error"`
+	Code      string           `json:"code"`
+	Results   []SyncItemResult `json:"results"`
+	Conflicts []SyncConflict   `json:"conflicts,omitempty"` // Notes rejected for conflicts, with both versions
+}
+
+// SyncCollection represents a collection in sync operations.
+// Appearance fields are pointers so pushes from clients that predate them keep the stored values.
 type SyncCollection struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"userId"`
-	Name      string    `json:"name"`
-	Icon      string    `json:"icon"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID          string     `json:"id"`
+	UserID      string     `json:"userId"`
+	Name        string     `json:"name"`
+	Icon        string     `json:"icon"`
+	Color       *string    `json:"color,omitempty"`       // "#RRGGBB"; "" clears
+	Description *string    `json:"description,omitempty"` // "" clears
+	SortIndex   *int       `json:"sortIndex,omitempty"`
+	Cover       *string    `json:"cover,omitempty"`      // Image URL or client preset key; "" clears
+	ParentID    *string    `json:"parentId,omitempty"`   // Unset for top-level collections; on push, omitted keeps the parent and "" moves to the top level
+	NoteCount   int        `json:"noteCount"`            // Live notes in the collection (server-maintained, ignored on push)
+	LastNoteAt  *time.Time `json:"lastNoteAt,omitempty"` // Latest update of a live note in it (server-maintained)
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
 }
 
 // SyncRequest represents a batch sync request
@@ -40,7 +125,20 @@ type SyncRequest struct {
 type SyncResponse struct {
 	Notes       []SyncNote       `json:"notes"`
 	Collections []SyncCollection `json:"collections"`
+	Conflicts   []SyncConflict   `json:"conflicts,omitempty"` // Push only
+	Results     []SyncItemResult `json:"results,omitempty"`   // Push only, in request order (collections first)
 	LastSync    time.Time        `json:"lastSync"`
+	NextCursor  string           `json:"nextCursor,omitempty"` // Paged pulls only; omitted on the last page
+	FullResync  bool             `json:"fullResync,omitempty"` // Pull only: every note, since was older than the trash retention
+}
+
+// PartialSyncResponse is returned by GET /api/sync/notes?fields=; notes carry only the requested fields
+type PartialSyncResponse struct {
+	Notes       []map[string]json.RawMessage `json:"notes"`
+	Collections []SyncCollection             `json:"collections"`
+	LastSync    time.Time                    `json:"lastSync"`
+	NextCursor  string                       `json:"nextCursor,omitempty"`
+	FullResync  bool                         `json:"fullResync,omitempty"`
 }
 
 // DBNote represents a note in the database (for internal use)
@@ -51,8 +149,12 @@ type DBNote struct {
 	ContentEncrypted []byte
 	ContentIV        []byte
 	Domain           *string
-	Date             time.Time
+	Language         *string
+	Date             NoteDate
 	IsPinned         bool
+	IsArchived       bool
+	Tags             []string
+	ReminderAt       *time.Time
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
 	DeletedAt        *time.Time
@@ -60,10 +162,60 @@ type DBNote struct {
 
 // DBCollection represents a collection in the database (for internal use)
 type DBCollection struct {
-	ID        string
-	UserID    string
-	Name      string
-	Icon      string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID          string
+	UserID      string
+	Name        string
+	Icon        string
+	Color       *string
+	Description *string
+	SortIndex   int
+	Cover       *string
+	NoteCount   int
+	LastNoteAt  *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   *time.Time
+}
+
+// SyncBucketDigest represents the digest of the notes whose ID hash starts with Prefix
+type SyncBucketDigest struct {
+	Prefix string `json:"prefix"` // Single hex digit
+	Count  int    `json:"count"`
+	Hash   string `json:"hash"`
+}
+
+// SyncCollectionDigest represents the note count and digest of a single collection
+type SyncCollectionDigest struct {
+	CollectionID string `json:"collectionId"`
+	NoteCount    int    `json:"noteCount"`
+	Hash         string `json:"hash"`
+}
+
+// SyncVerifyResponse represents the server's digest of a user's active notes
+type SyncVerifyResponse struct {
+	RootHash           string                 `json:"rootHash"`
+	NoteCount          int                    `json:"noteCount"`
+	UncategorizedCount int                    `json:"uncategorizedCount"`
+	Buckets            []SyncBucketDigest     `json:"buckets"`
+	Collections        []SyncCollectionDigest `json:"collections"`
+	GeneratedAt        time.Time              `json:"generatedAt"`
+}
+
+// SyncRepairEntry represents a note the client holds, identified by its leaf hash
+type SyncRepairEntry struct {
+	ID   string `json:"id"`
+	Hash string `json:"hash"` // Leaf hash as returned by the verify digest algorithm
+}
+
+// SyncRepairRequest represents the client's view of its notes for a targeted repair
+type SyncRepairRequest struct {
+	Notes   []SyncRepairEntry `json:"notes"`
+	Buckets []string          `json:"buckets,omitempty"` // Restrict the comparison to these bucket prefixes
+}
+
+// SyncRepairResponse represents the records the client needs to converge with the server
+type SyncRepairResponse struct {
+	Notes          []SyncNote `json:"notes"`          // Missing or mismatched notes (soft-deleted ones carry deletedAt)
+	UnknownNoteIDs []string   `json:"unknownNoteIds"` // Notes the server has never seen; the client should push them
+	LastSync       time.Time  `json:"lastSync"`
 }
@@ -0,0 +1,46 @@
+// Feature usage telemetry data models
+package models
+
+import "time"
+
+// Features that can be reported through telemetry
+const (
+	FeatureAICleanup     = "ai_cleanup"
+	FeatureChat          = "chat"
+	FeatureShareLinks    = "share_links"
+	FeatureSmartAppend   = "smart_append"
+	FeatureAudioCapture  = "audio_capture"
+	FeatureRelevantNotes = "relevant_notes"
+	FeatureImport        = "import"
+	FeatureWebClipper    = "web_clipper"
+)
+
+// TelemetryConsent is the user's telemetry opt-in state
+type TelemetryConsent struct {
+	OptedIn   bool       `json:"optedIn"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// FeatureUse reports how many times a feature was used since the last report
+type FeatureUse struct {
+	Feature string `json:"feature"`
+	Count   int    `json:"count"`
+}
+
+// TelemetryEventsRequest is a batch of feature use counts
+type TelemetryEventsRequest struct {
+	Events []FeatureUse `json:"events"`
+}
+
+// TelemetryEventsResponse tells the client whether its events were recorded
+type TelemetryEventsResponse struct {
+	Recorded bool `json:"recorded"` // False if the user hasn't opted in
+}
+
+// FeatureUsageDay is one feature's aggregated usage on one day
+type FeatureUsageDay struct {
+	Day     string `json:"day"` // YYYY-MM-DD (UTC)
+	Feature string `json:"feature"`
+	Users   int    `json:"users"`
+	Uses    int    `json:"uses"`
+}
@@ -0,0 +1,11 @@
+// Anonymous trial session data models
+package models
+
+import "time"
+
+// TrialSession is returned when a trial starts. The token is shown only once.
+type TrialSession struct {
+	UserID    string    `json:"userId"`
+	Token     string    `json:"token"` // Sent as X-Trial-Token
+	ExpiresAt time.Time `json:"expiresAt"`
+}
@@ -31,9 +31,202 @@ type CleanupRequest struct {
 	Content  string `json:"content"`
 }
 
+// TranslateRequest represents a request to translate note content
+type TranslateRequest struct {
+	Provider       string `json:"provider"`
+	Content        string `json:"content"`
+	TargetLanguage string `json:"targetLanguage"` // A language name or code, e.g. "German" or "pt-BR"
+}
+
+// Summary length presets
+const (
+	SummaryLengthOneLine   = "one-line"  // A single sentence
+	SummaryLengthParagraph = "paragraph" // A short paragraph (default)
+	SummaryLengthBullets   = "bullets"   // A few bullet points
+)
+
+// SummarizeRequest represents a request to summarize note content
+type SummarizeRequest struct {
+	Provider string `json:"provider"`
+	Content  string `json:"content"`
+	Length   string `json:"length,omitempty"` // One of the summary length presets, paragraph by default
+}
+
+// Action item priorities
+const (
+	ActionPriorityLow    = "low"
+	ActionPriorityMedium = "medium"
+	ActionPriorityHigh   = "high"
+)
+
+// ExtractActionsRequest represents a request to extract action items from note content
+type ExtractActionsRequest struct {
+	Provider string `json:"provider"`
+	Content  string `json:"content"`
+	Today    string `json:"today,omitempty"` // The user's current date (YYYY-MM-DD) for relative due dates, UTC today by default
+}
+
+// ActionItem is a task extracted from note content
+type ActionItem struct {
+	Text     string `json:"text"`
+	Due      string `json:"due,omitempty"`      // Due date (YYYY-MM-DD), if the note gives one
+	Priority string `json:"priority,omitempty"` // One of the action item priorities, if the note implies one
+}
+
+// ExtractActionsResponse represents the action items found in note content
+type ExtractActionsResponse struct {
+	Tasks []ActionItem `json:"tasks"`
+}
+
+// GenerateTitleRequest represents a request to generate a title for note content
+type GenerateTitleRequest struct {
+	Provider  string `json:"provider"`
+	Content   string `json:"content"`
+	MaxLength int    `json:"maxLength,omitempty"` // In characters, 60 by default
+}
+
+// TitleInput is a single note to generate a title for
+type TitleInput struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+// GenerateTitlesRequest represents a request to generate titles for several notes at once
+type GenerateTitlesRequest struct {
+	Provider  string       `json:"provider"`
+	Notes     []TitleInput `json:"notes"`
+	MaxLength int          `json:"maxLength,omitempty"` // In characters, 60 by default
+}
+
+// GenerateTitlesResponse holds generated titles keyed by note ID. Notes the model gave no usable
+// title for are left out.
+type GenerateTitlesResponse struct {
+	Titles map[string]string `json:"titles"`
+}
+
+// SmartAppendRequest represents a request to merge a quick capture into an existing note
+type SmartAppendRequest struct {
+	Provider    string `json:"provider"`
+	NoteID      string `json:"noteId"`
+	NoteContent string `json:"noteContent"` // Decrypted content of the target note (E2E, supplied by client)
+	Capture     string `json:"capture"`
+}
+
+// SmartAppendResponse represents the result of a smart append
+type SmartAppendResponse struct {
+	NoteID        string `json:"noteId"`
+	Section       string `json:"section"` // Heading the capture was placed under (empty if appended at the end)
+	MergedContent string `json:"mergedContent"`
+}
+
+// TokenCountInput is a single piece of content to count tokens for
+type TokenCountInput struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+// CountTokensRequest represents a request to count tokens before sending content to the AI
+type CountTokensRequest struct {
+	Provider string            `json:"provider"`
+	Models   []string          `json:"models"` // Defaults to the model used by the AI endpoints
+	Contents []TokenCountInput `json:"contents"`
+}
+
+// TokenCount is the token count of a single content item
+type TokenCount struct {
+	ID     string `json:"id"`
+	Tokens int    `json:"tokens"`
+}
+
+// ModelTokenCounts represents token counts for one model
+type ModelTokenCounts struct {
+	Model           string       `json:"model"`
+	InputTokenLimit int          `json:"inputTokenLimit"`
+	Total           int          `json:"total"`
+	Counts          []TokenCount `json:"counts"`
+}
+
+// CountTokensResponse represents token counts per model
+type CountTokensResponse struct {
+	Models []ModelTokenCounts `json:"models"`
+}
+
+// CollectionOption represents a collection the AI may file a note into
+type CollectionOption struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// CaptureProgress represents a partial progress event of the audio capture pipeline
+type CaptureProgress struct {
+	Stage   string `json:"stage"` // transcribing, cleaning, titling, categorizing
+	Message string `json:"message,omitempty"`
+}
+
+// CaptureResult represents the final result of the audio capture pipeline
+type CaptureResult struct {
+	Transcript     string `json:"transcript"`
+	CleanedContent string `json:"cleanedContent"`
+	Title          string `json:"title"`
+	CollectionID   string `json:"collectionId,omitempty"`
+}
+
+// TranscribeRequest represents a JSON request to transcribe audio (uploads can also be multipart)
+type TranscribeRequest struct {
+	Provider string `json:"provider"`
+	Audio    string `json:"audio"`              // Base64-encoded recording
+	MimeType string `json:"mimeType,omitempty"` // Detected from the audio if omitted
+	Cleanup  bool   `json:"cleanup,omitempty"`  // Also return a cleaned-up version of the transcript
+}
+
+// TranscribeResponse represents the transcript of an audio recording
+type TranscribeResponse struct {
+	Transcript     string `json:"transcript"`
+	CleanedContent string `json:"cleanedContent,omitempty"` // Only when cleanup was requested and succeeded
+}
+
+// OCRRequest represents a JSON request to extract the text of an image (uploads can also be multipart)
+type OCRRequest struct {
+	Provider string `json:"provider"`
+	Image    string `json:"image"`              // Base64-encoded image
+	MimeType string `json:"mimeType,omitempty"` // Detected from the image if omitted
+}
+
+// OCRResponse represents the text extracted from an image
+type OCRResponse struct {
+	Content string `json:"content"` // Markdown
+}
+
+// ClipRequest represents a request to clip a web page into a note
+type ClipRequest struct {
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+	Cleanup  *bool  `json:"cleanup,omitempty"` // Run AI cleanup on the page; defaults to its capture rule's cleanupOnCapture
+}
+
+// ClipResponse represents the main content of a clipped web page, ready to become a note
+type ClipResponse struct {
+	URL      string       `json:"url"` // After redirects
+	Title    string       `json:"title"`
+	Markdown string       `json:"markdown"`
+	Domain   string       `json:"domain"`  // For the note's domain
+	Cleaned  bool         `json:"cleaned"` // Whether AI cleanup ran on the markdown
+	Rule     *CaptureRule `json:"rule"`    // The capture rule for the page, or null
+}
+
+// Machine-readable error codes
+const (
+	ErrCodeProviderUnavailable = "PROVIDER_UNAVAILABLE"
+	ErrCodeProviderTimeout     = "PROVIDER_TIMEOUT"
+	ErrCodeQuotaExceeded       = "QUOTA_EXCEEDED"
+	ErrCodeRegionUnavailable   = "REGION_UNAVAILABLE"
+)
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error      string `json:"error"`
+	Code       string `json:"code,omitempty"`
+	RetryAfter int    `json:"retryAfter,omitempty"` // Seconds before the request may succeed
 }
 
 // RelevantNotesResponse represents a response containing relevant note IDs
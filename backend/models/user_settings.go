@@ -0,0 +1,28 @@
+// Server-side user settings data models
+package models
+
+// Sync conflict policies
+const (
+	ConflictLastWriteWins = "last_write_wins" // A stale push overwrites the note (default)
+	ConflictKeepBoth      = "keep_both"       // The overwritten version is kept as a "(conflicted copy ...)" note
+	ConflictReject        = "reject"          // A stale push is rejected so the client can merge and push again
+)
+
+// DefaultTimezone applies until the user sets a timezone
+const DefaultTimezone = "UTC"
+
+// UserSettings are per-user settings the server itself acts on (unlike encrypted client settings)
+type UserSettings struct {
+	ConflictPolicy string  `json:"conflictPolicy"`
+	Timezone       string  `json:"timezone"`           // IANA zone name, "UTC" by default
+	Locale         *string `json:"locale,omitempty"`   // BCP 47 tag; unset means the client's default
+	AIRegion       *string `json:"aiRegion,omitempty"` // AI provider region; unset means the provider's default endpoint
+}
+
+// UpdateUserSettingsRequest changes the given settings; omitted fields are unchanged
+type UpdateUserSettingsRequest struct {
+	ConflictPolicy *string `json:"conflictPolicy,omitempty"`
+	Timezone       *string `json:"timezone,omitempty"`
+	Locale         *string `json:"locale,omitempty"`   // "" clears the locale
+	AIRegion       *string `json:"aiRegion,omitempty"` // "" clears the AI region
+}
@@ -0,0 +1,107 @@
+// Package pagination provides limit/cursor pagination shared by list endpoints.
+//
+// Lists are paged by keyset: each item's position is its sort timestamp plus ID (as a tiebreaker),
+// encoded into an opaque cursor so clients can't depend on its contents.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default page sizes
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// ErrInvalidCursor is returned for cursors that weren't produced by Encode
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrInvalidLimit is returned for non-positive or non-numeric limits
+var ErrInvalidLimit = errors.New("invalid limit")
+
+// Cursor marks the position of the last item on a page
+type Cursor struct {
+	SortValue time.Time `json:"s"`
+	ID        string    `json:"i"`
+}
+
+// Params are the pagination parameters of a list request
+type Params struct {
+	Limit  int
+	Cursor *Cursor // nil for the first page
+}
+
+// Page is the response envelope shared by all paginated list endpoints
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+// Encode returns the opaque string form of a cursor
+func (c Cursor) Encode() string {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "" // Marshaling a time and a string can't fail
+	}
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// Decode parses an opaque cursor string
+func Decode(value string) (*Cursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(payload, &c); err != nil || c.ID == "" || c.SortValue.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// ParseParams reads ?limit= and ?cursor= from a request, clamping the limit to MaxLimit
+func ParseParams(r *http.Request) (Params, error) {
+	params := Params{Limit: DefaultLimit}
+	query := r.URL.Query()
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return params, ErrInvalidLimit
+		}
+		params.Limit = min(limit, MaxLimit)
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := Decode(value)
+		if err != nil {
+			return params, err
+		}
+		params.Cursor = cursor
+	}
+
+	return params, nil
+}
+
+// NewPage builds a page from up to limit+1 fetched items; the extra item only signals that
+// another page exists. cursorFor returns the position of an item for the next cursor.
+func NewPage[T any](items []T, limit int, cursorFor func(T) Cursor) Page[T] {
+	page := Page[T]{Items: items}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.HasMore = true
+		page.NextCursor = cursorFor(page.Items[limit-1]).Encode()
+	}
+	return page
+}
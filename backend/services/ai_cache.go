@@ -0,0 +1,150 @@
+// Cache of AI responses for identical requests
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxAICacheEntryBytes is the largest response the AI cache keeps; bigger ones are answered uncached
+const maxAICacheEntryBytes = 64 << 10
+
+// aiCacheTimeout bounds a cache lookup or store, so a slow shared store can't hold up AI requests
+const aiCacheTimeout = 500 * time.Millisecond
+
+// AICacheStore keeps cached AI responses by key until they expire
+type AICacheStore interface {
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// AICache caches AI responses for identical requests, so repeating a request on unchanged content
+// doesn't spend the key's quota again. It's best-effort: store errors are logged and count as misses.
+type AICache struct {
+	store AICacheStore
+	ttl   time.Duration
+}
+
+// NewAICache creates a cache keeping responses in store for ttl, or returns nil (caching disabled) if
+// ttl isn't positive. A nil *AICache misses every lookup.
+func NewAICache(store AICacheStore, ttl time.Duration) *AICache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &AICache{store: store, ttl: ttl}
+}
+
+// AICacheKey derives the cache key of an AI request from the feature, the model answering it, a
+// fingerprint of the API key paying for it, and the request input the prompt is built from. Keys differ
+// per API key, so one caller's responses are never served to another.
+func AICacheKey(feature, model, keyFingerprint string, input []byte) string {
+	hash := sha256.New()
+	for _, part := range []string{feature, model, keyFingerprint} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(input)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Get returns the cached response for key
+func (c *AICache) Get(ctx context.Context, key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, aiCacheTimeout)
+	defer cancel()
+
+	value, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		log.Printf("Error reading AI cache: %v", err)
+		return nil, false
+	}
+	return value, ok
+}
+
+// Set caches a response under key, unless it's larger than maxAICacheEntryBytes
+func (c *AICache) Set(ctx context.Context, key string, value []byte) {
+	if c == nil || len(value) > maxAICacheEntryBytes {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, aiCacheTimeout)
+	defer cancel()
+
+	if err := c.store.Set(ctx, key, value, c.ttl); err != nil {
+		log.Printf("Error writing AI cache: %v", err)
+	}
+}
+
+type memoryAICacheEntry struct {
+	value    []byte
+	expires  time.Time
+	lastUsed time.Time
+}
+
+// MemoryAICacheStore keeps cached AI responses in this replica's memory. Once it holds maxEntries it
+// drops expired responses, then the least recently used one.
+type MemoryAICacheStore struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*memoryAICacheEntry
+}
+
+// NewMemoryAICacheStore creates an in-memory store holding at most maxEntries responses
+func NewMemoryAICacheStore(maxEntries int) *MemoryAICacheStore {
+	return &MemoryAICacheStore{maxEntries: maxEntries, entries: make(map[string]*memoryAICacheEntry)}
+}
+
+// Get returns the response cached under key, if it hasn't expired
+func (s *MemoryAICacheStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	now := time.Now()
+	if now.After(entry.expires) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+	entry.lastUsed = now
+	return entry.value, true, nil
+}
+
+// Set caches a response under key for ttl, making room if the store is full
+func (s *MemoryAICacheStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if _, replacing := s.entries[key]; !replacing && len(s.entries) >= s.maxEntries {
+		s.evictLocked(now)
+	}
+	s.entries[key] = &memoryAICacheEntry{value: value, expires: now.Add(ttl), lastUsed: now}
+	return nil
+}
+
+// evictLocked drops expired responses, or the least recently used one if none have expired
+func (s *MemoryAICacheStore) evictLocked(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.lastUsed.Before(oldest) {
+			oldestKey, oldest = key, entry.lastUsed
+		}
+	}
+	if len(s.entries) >= s.maxEntries {
+		delete(s.entries, oldestKey)
+	}
+}
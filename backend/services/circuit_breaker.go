@@ -0,0 +1,141 @@
+// Failure-rate circuit breaker for AI providers
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Circuit breaker tuning
+const (
+	breakerWindowSize       = 20               // Most recent calls considered for the failure rate
+	breakerMinRequests      = 10               // Calls required in the window before the breaker may open
+	breakerFailureThreshold = 0.5              // Failure rate that opens the breaker
+	breakerCooldown         = 30 * time.Second // How long the breaker stays open before allowing a probe
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// ProviderUnavailableError is returned without calling the provider while its circuit is open
+type ProviderUnavailableError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *ProviderUnavailableError) Error() string {
+	return fmt.Sprintf("%s is unavailable, retry after %s", e.Provider, e.RetryAfter.Round(time.Second))
+}
+
+// CircuitBreaker tracks recent call outcomes for a provider and fails fast while it is degraded
+type CircuitBreaker struct {
+	mu            sync.Mutex
+	provider      string
+	state         breakerState
+	outcomes      [breakerWindowSize]bool // true = failure
+	next          int
+	count         int
+	failures      int
+	openedAt      time.Time
+	probeInFlight bool
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*CircuitBreaker{}
+)
+
+// BreakerFor returns the shared circuit breaker for an AI provider.
+// Breakers are process-wide because services are created per request with the user's key.
+func BreakerFor(provider string) *CircuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[provider]
+	if !ok {
+		b = &CircuitBreaker{provider: provider}
+		breakers[provider] = b
+	}
+	return b
+}
+
+// Allow reports whether a call may proceed, returning a ProviderUnavailableError while open
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		elapsed := time.Since(b.openedAt)
+		if elapsed < breakerCooldown {
+			return &ProviderUnavailableError{Provider: b.provider, RetryAfter: breakerCooldown - elapsed}
+		}
+		// Cooldown over: let a single probe through
+		b.state = breakerHalfOpen
+		b.probeInFlight = true
+		return nil
+	case breakerHalfOpen:
+		if b.probeInFlight {
+			return &ProviderUnavailableError{Provider: b.provider, RetryAfter: time.Second}
+		}
+		b.probeInFlight = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record registers the outcome of a call that Allow let through
+func (b *CircuitBreaker) Record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.probeInFlight = false
+		if failed {
+			b.trip()
+		} else {
+			b.reset()
+		}
+		return
+	}
+
+	if b.count == breakerWindowSize {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+	} else {
+		b.count++
+	}
+	b.outcomes[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % breakerWindowSize
+
+	if b.state == breakerClosed && b.count >= breakerMinRequests &&
+		float64(b.failures)/float64(b.count) >= breakerFailureThreshold {
+		b.trip()
+	}
+}
+
+// trip opens the breaker (caller must hold the lock)
+func (b *CircuitBreaker) trip() {
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	Warnf("Circuit breaker for %s opened (%d/%d recent calls failed)", b.provider, b.failures, b.count)
+}
+
+// reset closes the breaker and clears its history (caller must hold the lock)
+func (b *CircuitBreaker) reset() {
+	b.state = breakerClosed
+	b.outcomes = [breakerWindowSize]bool{}
+	b.next, b.count, b.failures = 0, 0, 0
+	b.probeInFlight = false
+}
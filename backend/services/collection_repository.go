@@ -0,0 +1,133 @@
+// Collection reads and hierarchy checks shared by the sync and collection handlers
+package services
+
+import (
+	"backend/models"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrCollectionNotFound is returned when a collection doesn't exist or belongs to another user
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// ErrCollectionCycle is returned when a collection would be nested inside itself or one of its descendants
+var ErrCollectionCycle = errors.New("a collection can't be nested inside itself or its subcollections")
+
+// CollectionColumns are the columns read by ScanCollection
+const CollectionColumns = `id, user_id, name, COALESCE(icon, ''), color, description, sort_index, cover, note_count,
+	last_note_at, created_at, updated_at, deleted_at, parent_id`
+
+// ScanCollection reads a collection row in the column order of CollectionColumns
+func ScanCollection(row RowScanner) (models.SyncCollection, error) {
+	var coll models.SyncCollection
+	var color, description, cover, parentID sql.NullString
+	var sortIndex int
+	var lastNoteAt, deletedAt sql.NullTime
+	if err := row.Scan(&coll.ID, &coll.UserID, &coll.Name, &coll.Icon, &color, &description, &sortIndex, &cover,
+		&coll.NoteCount, &lastNoteAt, &coll.CreatedAt, &coll.UpdatedAt, &deletedAt, &parentID); err != nil {
+		return coll, err
+	}
+	if parentID.Valid {
+		coll.ParentID = &parentID.String
+	}
+	if lastNoteAt.Valid {
+		coll.LastNoteAt = &lastNoteAt.Time
+	}
+	if color.Valid {
+		coll.Color = &color.String
+	}
+	if description.Valid {
+		coll.Description = &description.String
+	}
+	if cover.Valid {
+		coll.Cover = &cover.String
+	}
+	coll.SortIndex = &sortIndex
+	if deletedAt.Valid {
+		coll.DeletedAt = &deletedAt.Time
+	}
+	return coll, nil
+}
+
+// SyncCollections returns the user's collections changed since a time, including deleted ones, or all
+// live collections if since is nil
+func (d *Database) SyncCollections(ctx context.Context, userID string, since *time.Time) ([]models.SyncCollection, error) {
+	var rows *sql.Rows
+	var err error
+
+	if since != nil {
+		query := `
+			SELECT ` + CollectionColumns + `
+			FROM collections
+			WHERE user_id = $1 AND updated_at >= $2
+			ORDER BY updated_at DESC
+		`
+		rows, err = d.DB.QueryContext(ctx, query, userID, *since)
+	} else {
+		query := `
+			SELECT ` + CollectionColumns + `
+			FROM collections
+			WHERE user_id = $1 AND deleted_at IS NULL
+			ORDER BY updated_at DESC
+		`
+		rows, err = d.DB.QueryContext(ctx, query, userID)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var collections []models.SyncCollection
+	for rows.Next() {
+		coll, err := ScanCollection(rows)
+		if err != nil {
+			continue
+		}
+		collections = append(collections, coll)
+	}
+
+	return collections, nil
+}
+
+// CollectionAncestors returns a live collection of the user and its ancestors, or ErrCollectionNotFound.
+// UNION stops the walk if the hierarchy ever contains a cycle.
+func CollectionAncestors(ctx context.Context, q Queryer, userID, collectionID string) ([]string, error) {
+	ancestors, err := queryStrings(ctx, q, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM collections WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+			UNION
+			SELECT c.id, c.parent_id FROM collections c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT id FROM ancestors
+	`, collectionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ancestors) == 0 {
+		return nil, ErrCollectionNotFound
+	}
+	return ancestors, nil
+}
+
+// ValidateCollectionParent checks that parentID is a live collection of the user that collectionID can be
+// nested in: not the collection itself or one of its descendants
+func ValidateCollectionParent(ctx context.Context, q Queryer, userID, collectionID, parentID string) error {
+	ancestors, err := CollectionAncestors(ctx, q, userID, parentID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ancestors {
+		if id == collectionID {
+			return ErrCollectionCycle
+		}
+	}
+	return nil
+}
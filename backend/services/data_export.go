@@ -0,0 +1,146 @@
+// Writing a user's notes and collections into a ZIP archive they can take out of Jottin
+package services
+
+import (
+	"archive/zip"
+	"backend/models"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+)
+
+// DataExportManifestName is the manifest's path in a data export archive
+const DataExportManifestName = "manifest.json"
+
+// DataExportArchive streams a data export ZIP: a folder per collection (nested like the collections), a
+// JSON file per note holding the note as synced (its body still encrypted), and a manifest listing both.
+// Notes are written as they're added; the manifest is written by Close.
+type DataExportArchive struct {
+	zip      *zip.Writer
+	manifest models.DataExportManifest
+	folders  map[string]string // Collection ID to folder path
+	used     map[string]bool   // Paths taken in the archive
+}
+
+// NewDataExportArchive starts an archive on w with the folders of the user's live collections. The
+// manifest's collections and notes are filled in from what's written.
+func NewDataExportArchive(w io.Writer, manifest models.DataExportManifest, collections []models.SyncCollection) (*DataExportArchive, error) {
+	a := &DataExportArchive{
+		zip:      zip.NewWriter(w),
+		manifest: manifest,
+		folders:  make(map[string]string, len(collections)),
+		used:     map[string]bool{DataExportManifestName: true},
+	}
+	a.manifest.Collections = make([]models.DataExportCollection, 0, len(collections))
+	a.manifest.Notes = []models.DataExportNote{}
+
+	// Oldest first, so a name clash between siblings suffixes the newer collection's folder
+	sorted := append([]models.SyncCollection(nil), collections...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].CreatedAt.Equal(sorted[j].CreatedAt) {
+			return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	byID := make(map[string]models.SyncCollection, len(sorted))
+	for _, coll := range sorted {
+		byID[coll.ID] = coll
+	}
+	for _, coll := range sorted {
+		folder := a.folder(byID, coll.ID, map[string]bool{})
+		if _, err := a.zip.CreateHeader(&zip.FileHeader{Name: folder + "/", Modified: coll.UpdatedAt}); err != nil {
+			return nil, err
+		}
+		a.manifest.Collections = append(a.manifest.Collections, models.DataExportCollection{SyncCollection: coll, Path: folder})
+	}
+	return a, nil
+}
+
+// AddNote writes a note into the folder of its first collection (by ID), or the archive root if it's in
+// none, under a file named after its title
+func (a *DataExportArchive) AddNote(note models.SyncNote, collectionIDs []string) error {
+	collectionIDs = append([]string{}, collectionIDs...)
+	sort.Strings(collectionIDs)
+	folder := ""
+	for _, id := range collectionIDs {
+		if f, ok := a.folders[id]; ok {
+			folder = f
+			break
+		}
+	}
+	note.CollectionIDs = collectionIDs
+
+	name := a.unique(folder, ExportFilename(note.Title, "note"), ".json")
+	contents, err := json.MarshalIndent(note, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := a.write(name, note.UpdatedAt, contents); err != nil {
+		return err
+	}
+
+	a.manifest.Notes = append(a.manifest.Notes, models.DataExportNote{
+		ID:            note.ID,
+		Title:         note.Title,
+		Path:          name,
+		CollectionIDs: collectionIDs,
+		Tags:          note.Tags,
+		CreatedAt:     note.CreatedAt,
+		UpdatedAt:     note.UpdatedAt,
+	})
+	return nil
+}
+
+// Close writes the manifest and finishes the archive. It doesn't close the underlying writer.
+func (a *DataExportArchive) Close() error {
+	contents, err := json.MarshalIndent(a.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := a.write(DataExportManifestName, a.manifest.ExportedAt, contents); err != nil {
+		return err
+	}
+	return a.zip.Close()
+}
+
+// folder returns a collection's folder path, assigning it (and its ancestors') on first use. A parent
+// that isn't exported, or a cycle in the hierarchy, puts the collection at the archive root.
+func (a *DataExportArchive) folder(collections map[string]models.SyncCollection, id string, visiting map[string]bool) string {
+	if folder, ok := a.folders[id]; ok {
+		return folder
+	}
+	visiting[id] = true
+	coll := collections[id]
+	parent := ""
+	if coll.ParentID != nil {
+		if _, ok := collections[*coll.ParentID]; ok && !visiting[*coll.ParentID] {
+			parent = a.folder(collections, *coll.ParentID, visiting)
+		}
+	}
+	folder := a.unique(parent, ExportFilename(coll.Name, "collection"), "")
+	a.folders[id] = folder
+	return folder
+}
+
+// unique claims a path in dir for name plus ext, suffixing " (2)", " (3)", ... if it's taken
+func (a *DataExportArchive) unique(dir, name, ext string) string {
+	candidate := path.Join(dir, name+ext)
+	for n := 2; a.used[candidate]; n++ {
+		candidate = path.Join(dir, fmt.Sprintf("%s (%d)%s", name, n, ext))
+	}
+	a.used[candidate] = true
+	return candidate
+}
+
+// write adds a compressed file to the archive
+func (a *DataExportArchive) write(name string, modified time.Time, contents []byte) error {
+	file, err := a.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(contents)
+	return err
+}
@@ -2,10 +2,13 @@
 package services
 
 import (
+	"backend/models"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"log"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -13,19 +16,20 @@ import (
 
 // Database provides database connection and operations
 type Database struct {
-	DB *sql.DB
+	DB     *sql.DB // Through Neon's connection pooler when DATABASE_URL is a Neon endpoint
+	Direct *sql.DB // Straight to Postgres, for session state like advisory locks; DB elsewhere
 }
 
-// NewDatabase creates a new Database instance and connects to Neon PostgreSQL
+// NewDatabase creates a new Database instance and connects to Neon PostgreSQL. Queries go through the
+// pooled endpoint, whichever Neon endpoint DATABASE_URL names (see ParseDatabaseURL).
 func NewDatabase() (*Database, error) {
-	// Get connection string from environment
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		return nil, fmt.Errorf("DATABASE_URL environment variable is required")
+	urls, err := DatabaseURLsFromEnv()
+	if err != nil {
+		return nil, err
 	}
 
 	// Open connection
-	db, err := sql.Open("pgx", connStr)
+	db, err := sql.Open("pgx", urls.Pooled)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
@@ -40,14 +44,40 @@ func NewDatabase() (*Database, error) {
 	defer cancel()
 
 	if err := db.PingContext(ctx); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Printf("Error closing database during cleanup: %v", closeErr)
+		}
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &Database{DB: db}, nil
+	// Session work is rare (nightly maintenance), so the direct pool stays small and connects on demand
+	direct := db
+	if urls.Direct != urls.Pooled {
+		if direct, err = sql.Open("pgx", urls.Direct); err != nil {
+			if closeErr := db.Close(); closeErr != nil {
+				log.Printf("Error closing database during cleanup: %v", closeErr)
+			}
+			return nil, fmt.Errorf("failed to open direct database connection: %w", err)
+		}
+		direct.SetMaxOpenConns(2)
+		direct.SetMaxIdleConns(0)
+	}
+
+	return &Database{DB: db, Direct: direct}, nil
 }
 
-// Close the database connection
+// Ping checks that the database is reachable
+func (d *Database) Ping(ctx context.Context) error {
+	return d.DB.PingContext(ctx)
+}
+
+// Close the database connections
 func (d *Database) Close() error {
+	if d.Direct != nil && d.Direct != d.DB {
+		if err := d.Direct.Close(); err != nil {
+			log.Printf("Error closing direct database connection: %v", err)
+		}
+	}
 	return d.DB.Close()
 }
 
@@ -61,3 +91,63 @@ func (d *Database) EnsureUser(ctx context.Context, userID, email string) error {
 	_, err := d.DB.ExecContext(ctx, query, userID, email)
 	return err
 }
+
+// Device sync operations recorded by TouchDevice
+const (
+	DevicePull = "pull"
+	DevicePush = "push"
+)
+
+// TouchDevice records the time a client device last pulled or pushed changes
+func (d *Database) TouchDevice(ctx context.Context, userID, deviceID, operation string) error {
+	column := "last_pull_at"
+	if operation == DevicePush {
+		column = "last_push_at"
+	}
+
+	//nolint:gosec // column is one of two constants, not user input
+	query := fmt.Sprintf(`
+		INSERT INTO sync_devices (user_id, device_id, %[1]s)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET %[1]s = CURRENT_TIMESTAMP
+	`, column)
+	_, err := d.DB.ExecContext(ctx, query, userID, deviceID)
+	return err
+}
+
+// UserSettings returns the user's settings, or the defaults if the user has no row yet
+func (d *Database) UserSettings(ctx context.Context, userID string) (models.UserSettings, error) {
+	settings := models.UserSettings{ConflictPolicy: models.ConflictLastWriteWins, Timezone: models.DefaultTimezone}
+	var locale, aiRegion sql.NullString
+	err := d.DB.QueryRowContext(ctx, `SELECT conflict_policy, timezone, locale, ai_region FROM users WHERE id = $1`, userID).
+		Scan(&settings.ConflictPolicy, &settings.Timezone, &locale, &aiRegion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if locale.Valid {
+		settings.Locale = &locale.String
+	}
+	if aiRegion.Valid {
+		settings.AIRegion = &aiRegion.String
+	}
+	return settings, nil
+}
+
+// RecordAuditEvent appends an entry to the admin audit log. adminUserID is the admin who signed in, not
+// an account they act on behalf of.
+func (d *Database) RecordAuditEvent(ctx context.Context, adminUserID, action, targetUserID string, details map[string]interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+
+	query := `
+		INSERT INTO admin_audit_log (admin_user_id, action, target_user_id, details, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+	`
+	_, err = d.DB.ExecContext(ctx, query, adminUserID, action, targetUserID, detailsJSON)
+	return err
+}
@@ -0,0 +1,377 @@
+// Background indexing of note embeddings for semantic search
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// embeddingQueueSize bounds notes waiting to be indexed; pushes beyond it are dropped and
+	// indexed the next time the note is pushed
+	embeddingQueueSize = 256
+	// maxEmbeddingTextLength truncates the text a note is embedded from (in characters)
+	maxEmbeddingTextLength = 8000
+	// embeddingRetries is how many times a batch request failing with a transient error is retried
+	embeddingRetries = 3
+	// embeddingRetryDelay is the wait before the first retry, doubled before each one after it
+	embeddingRetryDelay = 2 * time.Second
+)
+
+// ErrNoStoredProviderKey is returned when a user has no stored Gemini key to index with
+var ErrNoStoredProviderKey = errors.New("no stored provider key")
+
+// embeddingTask is a note to (re-)index. Its text only lives in memory, never in the database.
+type embeddingTask struct {
+	userID string
+	noteID string
+	title  string
+	text   string
+}
+
+// EmbeddingIndexer computes note embeddings in the background with each user's stored Gemini key
+type EmbeddingIndexer struct {
+	db     *Database
+	sealer *Sealer
+	tasks  chan embeddingTask
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewEmbeddingIndexer creates a new EmbeddingIndexer. A nil sealer means no stored keys exist, so
+// notes are never indexed.
+func NewEmbeddingIndexer(db *Database, sealer *Sealer) *EmbeddingIndexer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EmbeddingIndexer{
+		db:     db,
+		sealer: sealer,
+		tasks:  make(chan embeddingTask, embeddingQueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start starts the indexing worker
+func (x *EmbeddingIndexer) Start() {
+	x.wg.Add(1)
+	go x.worker()
+}
+
+// Close stops the worker; notes still queued are indexed on their next push
+func (x *EmbeddingIndexer) Close() {
+	x.cancel()
+	x.wg.Wait()
+}
+
+// Enqueue schedules a note to be embedded from its title and the plaintext the client shared for
+// search. Empty text removes the note's embedding. Never blocks: a full queue drops the note.
+func (x *EmbeddingIndexer) Enqueue(userID, noteID, title, text string) {
+	if x.sealer == nil {
+		return
+	}
+	select {
+	case x.tasks <- embeddingTask{userID: userID, noteID: noteID, title: title, text: text}:
+	default:
+		log.Printf("Embedding queue full, skipping note %s", noteID)
+	}
+}
+
+// APIKey returns the user's stored Gemini key, or ErrNoStoredProviderKey
+func (x *EmbeddingIndexer) APIKey(ctx context.Context, userID string) (string, error) {
+	return StoredProviderKey(ctx, x.db, x.sealer, userID, geminiProvider)
+}
+
+func (x *EmbeddingIndexer) worker() {
+	defer x.wg.Done()
+	for {
+		select {
+		case <-x.ctx.Done():
+			return
+		case task := <-x.tasks:
+			x.indexBatch(x.drain(task))
+		}
+	}
+}
+
+// drain returns the task together with the tasks queued behind it, so they can be embedded in batches.
+// A note queued more than once is only indexed from its latest text.
+func (x *EmbeddingIndexer) drain(first embeddingTask) []embeddingTask {
+	tasks := []embeddingTask{first}
+	positions := map[string]int{first.userID + "/" + first.noteID: 0}
+	for drained := 1; drained < embeddingQueueSize; drained++ {
+		select {
+		case task := <-x.tasks:
+			key := task.userID + "/" + task.noteID
+			if i, ok := positions[key]; ok {
+				tasks[i] = task
+				continue
+			}
+			positions[key] = len(tasks)
+			tasks = append(tasks, task)
+		default:
+			return tasks
+		}
+	}
+	return tasks
+}
+
+// indexBatch indexes drained tasks user by user, since each user's notes are embedded with their own key
+func (x *EmbeddingIndexer) indexBatch(tasks []embeddingTask) {
+	var userIDs []string
+	byUser := make(map[string][]embeddingTask)
+	for _, task := range tasks {
+		if _, ok := byUser[task.userID]; !ok {
+			userIDs = append(userIDs, task.userID)
+		}
+		byUser[task.userID] = append(byUser[task.userID], task)
+	}
+
+	for _, userID := range userIDs {
+		if x.ctx.Err() != nil {
+			return
+		}
+		if err := x.indexUser(userID, byUser[userID]); err != nil {
+			log.Printf("Error indexing %d note(s) of user %s: %v", len(byUser[userID]), userID, err)
+		}
+	}
+}
+
+// indexUser embeds a user's notes in batch requests and stores the vectors, or removes the embeddings
+// of notes with no text or excluded from AI features (their text is then never sent to the provider).
+// Notes the provider won't embed are logged and skipped; the rest are still indexed.
+func (x *EmbeddingIndexer) indexUser(userID string, tasks []embeddingTask) error {
+	noteIDs := make([]string, len(tasks))
+	for i, task := range tasks {
+		noteIDs[i] = task.noteID
+	}
+	live, err := x.liveNotes(userID, noteIDs)
+	if err != nil {
+		return err
+	}
+
+	var removed []string
+	var pending []embeddingTask
+	for _, task := range tasks {
+		excluded, ok := live[task.noteID]
+		switch {
+		case !ok:
+			// Purged notes took their embedding with them (ON DELETE CASCADE)
+		case excluded || strings.TrimSpace(task.text) == "":
+			removed = append(removed, task.noteID)
+		default:
+			pending = append(pending, task)
+		}
+	}
+	if len(removed) > 0 {
+		_, err := x.db.DB.ExecContext(x.ctx, `DELETE FROM note_embeddings WHERE user_id = $1 AND note_id = ANY($2)`,
+			userID, removed)
+		if err != nil {
+			return err
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	apiKey, err := x.APIKey(x.ctx, userID)
+	if errors.Is(err, ErrNoStoredProviderKey) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	geminiService, err := NewUserGeminiService(x.ctx, x.db, userID, apiKey)
+	if err != nil {
+		return err
+	}
+	defer geminiService.Close()
+
+	for start := 0; start < len(pending); start += maxEmbeddingBatchSize {
+		chunk := pending[start:min(start+maxEmbeddingBatchSize, len(pending))]
+		embeddings, err := x.embedChunk(geminiService, chunk)
+		if err != nil {
+			// Notes not indexed yet are indexed the next time they're pushed
+			return err
+		}
+		for i, task := range chunk {
+			if embeddings[i] == nil {
+				continue
+			}
+			if err := x.store(task, embeddings[i]); err != nil {
+				log.Printf("Error indexing note %s: %v", task.noteID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// liveNotes returns which of the user's notes are live, mapped to whether each is excluded from AI features
+func (x *EmbeddingIndexer) liveNotes(userID string, noteIDs []string) (map[string]bool, error) {
+	rows, err := x.db.DB.QueryContext(x.ctx, `
+		SELECT id, ai_excluded FROM notes WHERE user_id = $1 AND id = ANY($2) AND deleted_at IS NULL
+	`, userID, noteIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	live := make(map[string]bool, len(noteIDs))
+	for rows.Next() {
+		var id string
+		var excluded bool
+		if err := rows.Scan(&id, &excluded); err != nil {
+			return nil, err
+		}
+		live[id] = excluded
+	}
+	return live, rows.Err()
+}
+
+// embedChunk embeds up to maxEmbeddingBatchSize notes in one batch request. If the provider rejects the
+// batch (one bad note fails the whole request), each note is embedded on its own so the others still get
+// indexed. A nil entry is a note that couldn't be embedded.
+func (x *EmbeddingIndexer) embedChunk(gemini *GeminiService, chunk []embeddingTask) ([][]float32, error) {
+	docs := make([]EmbeddingDocument, len(chunk))
+	for i, task := range chunk {
+		text := task.text
+		if utf8.RuneCountInString(text) > maxEmbeddingTextLength {
+			text = string([]rune(text)[:maxEmbeddingTextLength])
+		}
+		docs[i] = EmbeddingDocument{Title: task.title, Text: text}
+	}
+
+	embeddings, err := x.embedWithRetry(gemini, docs)
+	if err != nil && len(docs) > 1 && isRejectedEmbedding(err) {
+		return x.embedEach(gemini, chunk, docs)
+	}
+	if err != nil && isRejectedEmbedding(err) {
+		log.Printf("Error indexing note %s: %v", chunk[0].noteID, err)
+		return make([][]float32, 1), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, embedding := range embeddings {
+		if embedding == nil {
+			log.Printf("Error indexing note %s: no usable embedding returned", chunk[i].noteID)
+		}
+	}
+	return embeddings, nil
+}
+
+// embedEach embeds the notes of a rejected batch one request at a time, skipping the ones rejected again
+func (x *EmbeddingIndexer) embedEach(gemini *GeminiService, chunk []embeddingTask, docs []EmbeddingDocument) ([][]float32, error) {
+	embeddings := make([][]float32, len(docs))
+	for i := range docs {
+		single, err := x.embedWithRetry(gemini, docs[i:i+1])
+		switch {
+		case err != nil && !isRejectedEmbedding(err):
+			return nil, err
+		case err != nil:
+			log.Printf("Error indexing note %s: %v", chunk[i].noteID, err)
+		case single[0] == nil:
+			log.Printf("Error indexing note %s: no usable embedding returned", chunk[i].noteID)
+		default:
+			embeddings[i] = single[0]
+		}
+	}
+	return embeddings, nil
+}
+
+// embedWithRetry makes a batch embedding request, retrying transient provider errors (overload, quota,
+// timeouts) with exponential backoff
+func (x *EmbeddingIndexer) embedWithRetry(gemini *GeminiService, docs []EmbeddingDocument) ([][]float32, error) {
+	delay := embeddingRetryDelay
+	for attempt := 0; ; attempt++ {
+		embeddings, err := gemini.EmbedNotes(x.ctx, docs)
+		if err == nil || attempt == embeddingRetries || !isTransientEmbeddingError(err) || x.ctx.Err() != nil {
+			return embeddings, err
+		}
+
+		select {
+		case <-x.ctx.Done():
+			return nil, x.ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// store saves a note's embedding. Only live notes that aren't excluded from AI features are indexed.
+func (x *EmbeddingIndexer) store(task embeddingTask, embedding []float32) error {
+	_, err := x.db.DB.ExecContext(x.ctx, `
+		INSERT INTO note_embeddings (note_id, user_id, model, embedding, content_hash, stale, updated_at)
+		SELECT id, user_id, $3, $4::vector, sha256(content_encrypted), FALSE, CURRENT_TIMESTAMP
+		FROM notes
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL AND NOT ai_excluded
+		ON CONFLICT (note_id) DO UPDATE SET
+			model = EXCLUDED.model,
+			embedding = EXCLUDED.embedding,
+			content_hash = EXCLUDED.content_hash,
+			stale = FALSE,
+			updated_at = EXCLUDED.updated_at
+	`, task.noteID, task.userID, EmbeddingModel, FormatVector(embedding))
+	if err != nil {
+		return fmt.Errorf("failed to store embedding: %w", err)
+	}
+	return nil
+}
+
+// isTransientEmbeddingError reports whether a failed embedding request may succeed if retried. An open
+// circuit breaker isn't retried: the provider is already known to be down.
+func isTransientEmbeddingError(err error) bool {
+	var unavailable *ProviderUnavailableError
+	if errors.As(err, &unavailable) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable, codes.Internal, codes.DeadlineExceeded, codes.Aborted:
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// isRejectedEmbedding reports whether the provider refused the content of an embedding request
+func isRejectedEmbedding(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusBadRequest
+	}
+	return status.Code(err) == codes.InvalidArgument
+}
+
+// FormatVector encodes an embedding in pgvector's text format, e.g. [0.1,0.2]
+func FormatVector(values []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
@@ -0,0 +1,127 @@
+// Gemini Files API uploads for inputs too large to inline in a prompt
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	// inlineBlobLimit is the largest binary input sent inline (requests are capped at 20MB
+	// including the prompt); larger inputs go through the Files API
+	inlineBlobLimit = 15 << 20
+	// inlineTextLimit is the largest text input sent inline; long transcripts are uploaded instead
+	inlineTextLimit = 1 << 20
+	// fileUploadTimeout bounds uploading a file and waiting for Gemini to process it
+	fileUploadTimeout = 2 * time.Minute
+	// fileProcessingPollInterval is how often an uploaded file's processing state is checked
+	fileProcessingPollInterval = 2 * time.Second
+	// fileDeleteTimeout bounds deleting an uploaded file
+	fileDeleteTimeout = 10 * time.Second
+)
+
+// blobInput returns a prompt part for binary data, uploading it through the Files API when it's
+// too large to inline. The returned release func deletes the upload and must always be called.
+func (s *GeminiService) blobInput(ctx context.Context, data []byte, mimeType string) (genai.Part, func(), error) {
+	if len(data) <= inlineBlobLimit {
+		return genai.Blob{MIMEType: mimeType, Data: data}, func() {}, nil
+	}
+	return s.uploadInput(ctx, data, mimeType)
+}
+
+// textInput returns a prompt part for text, uploading it through the Files API when it's
+// too long to inline. The returned release func deletes the upload and must always be called.
+func (s *GeminiService) textInput(ctx context.Context, text string) (genai.Part, func(), error) {
+	if len(text) <= inlineTextLimit {
+		return genai.Text(text), func() {}, nil
+	}
+	return s.uploadInput(ctx, []byte(text), "text/plain")
+}
+
+// uploadInput uploads data and waits until Gemini has processed it
+func (s *GeminiService) uploadInput(ctx context.Context, data []byte, mimeType string) (part genai.Part, release func(), err error) {
+	if s.mock {
+		return nil, nil, ErrMockUnsupported
+	}
+	breaker := BreakerFor(geminiProvider)
+	if err := breaker.Allow(); err != nil {
+		return nil, nil, err
+	}
+	defer func() { breaker.Record(isProviderFailure(err)) }()
+
+	ctx, cancel := context.WithTimeout(ctx, fileUploadTimeout)
+	defer cancel()
+
+	file, err := s.client.UploadFile(ctx, "", bytes.NewReader(data), &genai.UploadFileOptions{MIMEType: mimeType})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+	s.trackUpload(file.Name)
+	release = func() { s.deleteUpload(file.Name) }
+	Debugf("Uploaded Gemini file %s (%d bytes, expires %s)", file.Name, len(data), file.ExpirationTime.Format(time.RFC3339))
+
+	for file.State == genai.FileStateProcessing {
+		select {
+		case <-ctx.Done():
+			release()
+			return nil, nil, fmt.Errorf("file %s still processing: %w", file.Name, ctx.Err())
+		case <-time.After(fileProcessingPollInterval):
+		}
+		if file, err = s.client.GetFile(ctx, file.Name); err != nil {
+			release()
+			return nil, nil, fmt.Errorf("failed to check file state: %w", err)
+		}
+	}
+	if file.State != genai.FileStateActive {
+		release()
+		return nil, nil, fmt.Errorf("file %s failed processing (state %s)", file.Name, file.State)
+	}
+
+	return genai.FileData{MIMEType: file.MIMEType, URI: file.URI}, release, nil
+}
+
+// trackUpload remembers an uploaded file so Close can delete it if release is never reached
+func (s *GeminiService) trackUpload(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.uploads == nil {
+		s.uploads = make(map[string]bool)
+	}
+	s.uploads[name] = true
+}
+
+// deleteUpload deletes an uploaded file. Failures are only logged: Gemini expires files after 48 hours.
+func (s *GeminiService) deleteUpload(name string) {
+	s.mu.Lock()
+	tracked := s.uploads[name]
+	delete(s.uploads, name)
+	s.mu.Unlock()
+	if !tracked {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fileDeleteTimeout)
+	defer cancel()
+	if err := s.client.DeleteFile(ctx, name); err != nil {
+		log.Printf("Error deleting Gemini file %s (it will expire on its own): %v", name, err)
+	}
+}
+
+// deleteAllUploads deletes every upload that hasn't been released yet
+func (s *GeminiService) deleteAllUploads() {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.uploads))
+	for name := range s.uploads {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+
+	for _, name := range names {
+		s.deleteUpload(name)
+	}
+}
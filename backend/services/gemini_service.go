@@ -5,45 +5,225 @@ import (
 	"backend/models"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// GeminiService provides AI-powered features using Google Gemini
+// geminiProvider names the Gemini circuit breaker
+const geminiProvider = "gemini"
+
+// Per-endpoint timeouts for Gemini calls
+const (
+	chatTimeout          = 60 * time.Second
+	relevantNotesTimeout = 30 * time.Second
+	cleanupTimeout       = 45 * time.Second
+	summarizeTimeout     = 45 * time.Second
+	translateTimeout     = 60 * time.Second
+	actionsTimeout       = 45 * time.Second
+	titleTimeout         = 15 * time.Second
+	titleBatchTimeout    = 45 * time.Second
+	transcribeTimeout    = 120 * time.Second
+	ocrTimeout           = 60 * time.Second
+	countTokensTimeout   = 15 * time.Second
+	embedTimeout         = 15 * time.Second
+	embedBatchTimeout    = 60 * time.Second
+	overviewTimeout      = 45 * time.Second
+)
+
+// Retries of Gemini generation calls the provider rejected as rate limited or overloaded
+const (
+	generateRetries    = 2                      // Retries after the first attempt
+	generateRetryDelay = 500 * time.Millisecond // Base delay before the first retry, doubling after each
+)
+
+// maxChatHistoryMessages bounds the earlier chat turns included in a prompt
+const maxChatHistoryMessages = 20
+
+// DefaultGeminiModel is the model used by the AI endpoints
+const DefaultGeminiModel = "gemini-2.0-flash-exp"
+
+// Note embedding model for semantic search; changing it requires re-indexing (note_embeddings.model)
+const (
+	EmbeddingModel      = "text-embedding-004"
+	EmbeddingDimensions = 768
+)
+
+// maxEmbeddingBatchSize is the most contents the provider embeds in one batch request
+const maxEmbeddingBatchSize = 100
+
+// GeminiService provides AI-powered features using Google Gemini. Every call takes the caller's context
+// (the HTTP request's, for handlers), so a cancelled request stops its Gemini calls instead of spending
+// the user's quota; each call is also bounded by its own timeout.
 type GeminiService struct {
-	client *genai.Client
-	ctx    context.Context
+	client  *genai.Client
+	mock    bool // Answered by the mock provider (see SetAIProvider); client is nil
+	mu      sync.Mutex
+	uploads map[string]bool // Files API uploads not yet deleted
 }
 
 // NewGeminiService creates a new GeminiService instance
 func NewGeminiService(apiKey string) (*GeminiService, error) {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if mockAI {
+		return newMockGeminiService(), nil
+	}
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey(apiKey))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Gemini client: %v", err)
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
 	}
 
-	return &GeminiService{
-		client: client,
-		ctx:    ctx,
-	}, nil
+	return &GeminiService{client: client}, nil
 }
 
-// Close closes the Gemini client connection
+// Close deletes leftover file uploads and closes the Gemini client connection
 func (s *GeminiService) Close() {
 	if s.client != nil {
+		s.deleteAllUploads()
 		if err := s.client.Close(); err != nil {
 			log.Printf("Error closing Gemini client: %v", err)
 		}
 	}
 }
 
-// GetChatResponse generates a chat response based on prompt and context notes
-func (s *GeminiService) GetChatResponse(prompt string, contextNotes []models.Note) (string, error) {
+// Ping checks that Gemini is reachable by fetching the default model's metadata (no tokens are used)
+func (s *GeminiService) Ping(ctx context.Context) error {
+	if s.mock {
+		return nil
+	}
+	if _, err := s.client.GenerativeModel(DefaultGeminiModel).Info(ctx); err != nil {
+		return fmt.Errorf("failed to reach Gemini: %w", err)
+	}
+	return nil
+}
+
+// generate calls Gemini through the provider's circuit breaker with a per-endpoint timeout, which
+// bounds all attempts together. Rate limited (429) and overloaded (5xx) attempts are retried up to
+// generateRetries times with jittered exponential backoff; each attempt passes the breaker, so retries
+// stop as soon as it opens, and a retry is skipped if the timeout would expire during its wait.
+func (s *GeminiService) generate(ctx context.Context, model *genai.GenerativeModel, timeout time.Duration, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	if s.mock {
+		return nil, ErrMockUnsupported
+	}
+	breaker := BreakerFor(geminiProvider)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	delay := generateRetryDelay
+	for attempt := 0; ; attempt++ {
+		if err := breaker.Allow(); err != nil {
+			return nil, err
+		}
+		resp, err := model.GenerateContent(ctx, parts...)
+		breaker.Record(isProviderFailure(err))
+		if err == nil || attempt == generateRetries || !isRetryableAIError(err) {
+			return resp, err
+		}
+
+		// Equal jitter: half the delay, plus up to the other half at random, spreads out retries from
+		// requests that failed together
+		wait := delay/2 + rand.N(delay/2)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, err
+		}
+		Debugf("Retrying Gemini call in %s (attempt %d): %v", wait.Round(time.Millisecond), attempt+2, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// embed calls the embedding model through the provider's circuit breaker
+func (s *GeminiService) embed(ctx context.Context, taskType genai.TaskType, title, text string) ([]float32, error) {
+	if s.mock {
+		return mockEmbedding(title, text), nil
+	}
+	breaker := BreakerFor(geminiProvider)
+	if err := breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, embedTimeout)
+	defer cancel()
+
+	model := s.client.EmbeddingModel(EmbeddingModel)
+	model.TaskType = taskType
+	resp, err := model.EmbedContentWithTitle(ctx, title, genai.Text(text))
+	breaker.Record(isProviderFailure(err))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Embedding == nil || len(resp.Embedding.Values) != EmbeddingDimensions {
+		return nil, errors.New("unexpected embedding size")
+	}
+	return resp.Embedding.Values, nil
+}
+
+// isProviderFailure reports whether an error indicates the upstream itself is degraded.
+// Client errors (bad key, quota, blocked content) are per-user and must not open the breaker,
+// and neither must callers giving up (a cancelled request).
+func isProviderFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= 500
+	}
+	var blockedErr *genai.BlockedError
+	return !errors.As(err, &blockedErr)
+}
+
+// isRetryableAIError reports whether a failed Gemini call may succeed if made again shortly: the
+// provider was rate limiting or overloaded. Blocked content, bad requests, and timeouts aren't retried.
+func isRetryableAIError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable, codes.Internal:
+		return true
+	}
+	return false
+}
+
+// IsProviderOverloaded reports whether a Gemini call failed because the provider itself was
+// overloaded or erroring (5xx), which retries didn't get past
+func IsProviderOverloaded(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= 500
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal:
+		return true
+	}
+	return false
+}
+
+// GetChatResponse generates a chat response based on prompt and context notes. history holds the
+// earlier turns of the conversation, oldest first (nil for a one-off question); only the latest
+// maxChatHistoryMessages are included.
+func (s *GeminiService) GetChatResponse(ctx context.Context, prompt string, contextNotes []models.Note, history []models.ChatMessage) (string, error) {
+	if s.mock {
+		return mockChatResponse(prompt, contextNotes, history), nil
+	}
+
 	var contextParts []string
 	for _, note := range contextNotes {
 		contextParts = append(contextParts, fmt.Sprintf("Title: %s\nContent: %s", note.Title, note.Content))
@@ -58,11 +238,35 @@ NOTES:
 QUESTION:
 %s`, context, prompt)
 
-	model := s.client.GenerativeModel("gemini-2.0-flash-exp")
-	resp, err := model.GenerateContent(s.ctx, genai.Text(fullPrompt))
+	if len(history) > maxChatHistoryMessages {
+		history = history[len(history)-maxChatHistoryMessages:]
+	}
+	if len(history) > 0 {
+		var turns []string
+		for _, message := range history {
+			speaker := "User"
+			if message.Role == models.ChatRoleAssistant {
+				speaker = "Assistant"
+			}
+			turns = append(turns, fmt.Sprintf("%s: %s", speaker, message.Content))
+		}
+		fullPrompt = fmt.Sprintf(`Continue the conversation below. Based on the following notes, answer the user's latest question.
+
+CONVERSATION SO FAR:
+%s
+
+NOTES:
+%s
+
+QUESTION:
+%s`, strings.Join(turns, "\n\n"), context, prompt)
+	}
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	resp, err := s.generate(ctx, model, chatTimeout, genai.Text(fullPrompt))
 	if err != nil {
 		log.Printf("Error generating chat response: %v", err)
-		return "", fmt.Errorf("failed to generate response: %v", err)
+		return "", fmt.Errorf("failed to generate response: %w", err)
 	}
 
 	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
@@ -73,10 +277,13 @@ QUESTION:
 }
 
 // FindRelevantNotes finds notes relevant to the current content
-func (s *GeminiService) FindRelevantNotes(currentContent string, allNotes []models.Note) ([]models.Note, error) {
+func (s *GeminiService) FindRelevantNotes(ctx context.Context, currentContent string, allNotes []models.Note) ([]models.Note, error) {
 	if strings.TrimSpace(currentContent) == "" || len(allNotes) == 0 {
 		return []models.Note{}, nil
 	}
+	if s.mock {
+		return mockRelevantNotes(currentContent, allNotes), nil
+	}
 
 	type NoteSummary struct {
 		ID             string `json:"id"`
@@ -119,13 +326,13 @@ Your response must be a JSON object with a single key "relevantNoteIds" which is
 Example response: {"relevantNoteIds": ["note-3", "note-1", "note-5"]}
 `, currentContent, string(summariesJSON))
 
-	model := s.client.GenerativeModel("gemini-2.0-flash-exp")
+	model := s.client.GenerativeModel(DefaultGeminiModel)
 	model.ResponseMIMEType = "application/json"
 
-	resp, err := model.GenerateContent(s.ctx, genai.Text(prompt))
+	resp, err := s.generate(ctx, model, relevantNotesTimeout, genai.Text(prompt))
 	if err != nil {
 		log.Printf("Error finding relevant notes: %v", err)
-		return []models.Note{}, fmt.Errorf("failed to find relevant notes: %v", err)
+		return []models.Note{}, fmt.Errorf("failed to find relevant notes: %w", err)
 	}
 
 	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
@@ -136,7 +343,7 @@ Example response: {"relevantNoteIds": ["note-3", "note-1", "note-5"]}
 	var result models.RelevantNotesResponse
 	if err := json.Unmarshal([]byte(responseText), &result); err != nil {
 		log.Printf("Error parsing JSON response: %v", err)
-		return []models.Note{}, fmt.Errorf("failed to parse response: %v", err)
+		return []models.Note{}, fmt.Errorf("failed to parse response: %w", err)
 	}
 
 	var relevantNotes []models.Note
@@ -152,25 +359,93 @@ Example response: {"relevantNoteIds": ["note-3", "note-1", "note-5"]}
 	return relevantNotes, nil
 }
 
+// EmbeddingDocument is a note to compute a semantic search embedding for
+type EmbeddingDocument struct {
+	Title string
+	Text  string
+}
+
+// EmbedNotes computes the embeddings notes are indexed under for semantic search, in a single batch
+// request of at most maxEmbeddingBatchSize notes. An entry is nil if the provider returned no usable
+// embedding for that note.
+func (s *GeminiService) EmbedNotes(ctx context.Context, docs []EmbeddingDocument) ([][]float32, error) {
+	if s.mock {
+		embeddings := make([][]float32, len(docs))
+		for i, doc := range docs {
+			embeddings[i] = mockEmbedding(doc.Title, doc.Text)
+		}
+		return embeddings, nil
+	}
+
+	breaker := BreakerFor(geminiProvider)
+	if err := breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, embedBatchTimeout)
+	defer cancel()
+
+	model := s.client.EmbeddingModel(EmbeddingModel)
+	model.TaskType = genai.TaskTypeRetrievalDocument
+	batch := model.NewBatch()
+	for _, doc := range docs {
+		batch.AddContentWithTitle(doc.Title, genai.Text(doc.Text))
+	}
+	resp, err := model.BatchEmbedContents(ctx, batch)
+	breaker.Record(isProviderFailure(err))
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed notes: %w", err)
+	}
+	if len(resp.Embeddings) != len(docs) {
+		return nil, fmt.Errorf("failed to embed notes: got %d embeddings for %d notes", len(resp.Embeddings), len(docs))
+	}
+
+	embeddings := make([][]float32, len(docs))
+	for i, embedding := range resp.Embeddings {
+		if embedding != nil && len(embedding.Values) == EmbeddingDimensions {
+			embeddings[i] = embedding.Values
+		}
+	}
+	return embeddings, nil
+}
+
+// EmbedQuery computes the embedding of a semantic search query
+func (s *GeminiService) EmbedQuery(ctx context.Context, query string) ([]float32, error) {
+	embedding, err := s.embed(ctx, genai.TaskTypeRetrievalQuery, "", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	return embedding, nil
+}
+
 // CleanUpNote cleans up and formats note content using AI
-func (s *GeminiService) CleanUpNote(content string) (string, error) {
-	prompt := fmt.Sprintf(`You are an expert note organizer. Clean up and structure the following note.
+func (s *GeminiService) CleanUpNote(ctx context.Context, content string) (string, error) {
+	if s.mock {
+		return mockCleanUp(content), nil
+	}
+
+	prompt := `You are an expert note organizer. Clean up and structure the following note.
 Fix any spelling and grammar mistakes.
 Format it with clear markdown, using bullet points, bolding for headers, and other elements to improve readability.
 Do not add any new information, only reformat and correct the existing content.
 Return only the cleaned-up note content, without any introductory text like "Here is the cleaned-up note:".
 
 Original Note:
----
-%s
----
-`, content)
+`
 
-	model := s.client.GenerativeModel("gemini-2.0-flash-exp")
-	resp, err := model.GenerateContent(s.ctx, genai.Text(prompt))
+	// Long transcripts are uploaded through the Files API instead of being inlined
+	notePart, release, err := s.textInput(ctx, content)
+	if err != nil {
+		log.Printf("Error preparing note for cleanup: %v", err)
+		return content, fmt.Errorf("failed to clean up note: %w", err)
+	}
+	defer release()
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	resp, err := s.generate(ctx, model, cleanupTimeout, genai.Text(prompt), notePart)
 	if err != nil {
 		log.Printf("Error cleaning up note: %v", err)
-		return content, fmt.Errorf("failed to clean up note: %v", err)
+		return content, fmt.Errorf("failed to clean up note: %w", err)
 	}
 
 	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
@@ -179,3 +454,569 @@ Original Note:
 
 	return fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0]), nil
 }
+
+// TranslateNote translates markdown note content into the target language, keeping its formatting
+func (s *GeminiService) TranslateNote(ctx context.Context, content, targetLanguage string) (string, error) {
+	prompt := fmt.Sprintf(`You are an expert translator. Translate the following markdown note into %s.
+Keep the markdown formatting exactly as it is: headings, lists, checkboxes, tables, links, emphasis, and line breaks.
+Do not translate code blocks, inline code, URLs, or link targets.
+Do not add, remove, or summarize any content.
+Return only the translated note, without any introductory text like "Here is the translation:".
+
+Original Note:
+`, targetLanguage)
+
+	// Long transcripts are uploaded through the Files API instead of being inlined
+	notePart, release, err := s.textInput(ctx, content)
+	if err != nil {
+		log.Printf("Error preparing note for translation: %v", err)
+		return "", fmt.Errorf("failed to translate note: %w", err)
+	}
+	defer release()
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	resp, err := s.generate(ctx, model, translateTimeout, genai.Text(prompt), notePart)
+	if err != nil {
+		log.Printf("Error translating note: %v", err)
+		return "", fmt.Errorf("failed to translate note: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", errors.New("failed to translate note: empty response")
+	}
+
+	return fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0]), nil
+}
+
+// SmartAppend places a quick capture into the most fitting section of an existing note
+// and returns the heading it was placed under along with the merged markdown
+func (s *GeminiService) SmartAppend(ctx context.Context, noteContent, capture string) (section, merged string, err error) {
+	fallback := strings.TrimRight(noteContent, "\n") + "\n\n" + capture
+	if strings.TrimSpace(noteContent) == "" {
+		return "", capture, nil
+	}
+
+	prompt := fmt.Sprintf(`You are an expert note organizer. A user captured a quick thought that belongs in an existing note.
+Decide which section (markdown heading) of the existing note the capture fits best, and insert it there,
+matching the surrounding formatting (e.g. as a bullet point if the section is a list).
+If no section fits, append the capture at the end of the note.
+Do not change, reorder, or remove any existing content. Do not rewrite the capture beyond light formatting.
+
+Your response must be a JSON object with two keys:
+- "section": the exact heading text the capture was placed under, or an empty string if appended at the end
+- "mergedContent": the full note content including the inserted capture
+Example response: {"section": "Ideas", "mergedContent": "# Project\n\n## Ideas\n- existing idea\n- new capture\n"}
+
+Existing Note:
+---
+%s
+---
+
+Capture:
+---
+%s
+---
+`, noteContent, capture)
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	model.ResponseMIMEType = "application/json"
+
+	resp, err := s.generate(ctx, model, cleanupTimeout, genai.Text(prompt))
+	if err != nil {
+		log.Printf("Error placing capture: %v", err)
+		return "", fallback, fmt.Errorf("failed to place capture: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", fallback, nil
+	}
+
+	responseText := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
+	var result struct {
+		Section       string `json:"section"`
+		MergedContent string `json:"mergedContent"`
+	}
+	if err := json.Unmarshal([]byte(responseText), &result); err != nil {
+		log.Printf("Error parsing JSON response: %v", err)
+		return "", fallback, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// Guard against the model dropping the capture or truncating the note
+	if strings.TrimSpace(result.MergedContent) == "" || len(result.MergedContent) < len(noteContent) {
+		return "", fallback, nil
+	}
+
+	return result.Section, result.MergedContent, nil
+}
+
+// CountTokens counts the tokens of each content item for a model and returns the model's input token limit
+func (s *GeminiService) CountTokens(ctx context.Context, modelName string, contents []string) (counts []int, inputTokenLimit int, err error) {
+	if s.mock {
+		return nil, 0, ErrMockUnsupported
+	}
+
+	breaker := BreakerFor(geminiProvider)
+	if err := breaker.Allow(); err != nil {
+		return nil, 0, err
+	}
+	defer func() { breaker.Record(isProviderFailure(err)) }()
+
+	ctx, cancel := context.WithTimeout(ctx, countTokensTimeout)
+	defer cancel()
+
+	model := s.client.GenerativeModel(modelName)
+	info, err := model.Info(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get model info for %s: %w", modelName, err)
+	}
+
+	counts = make([]int, len(contents))
+	for i, content := range contents {
+		if content == "" {
+			continue // The API rejects empty content
+		}
+		resp, err := model.CountTokens(ctx, genai.Text(content))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to count tokens for %s: %w", modelName, err)
+		}
+		counts[i] = int(resp.TotalTokens)
+	}
+
+	return counts, int(info.InputTokenLimit), nil
+}
+
+// TranscribeAudio transcribes an audio recording into plain text
+func (s *GeminiService) TranscribeAudio(ctx context.Context, audio []byte, mimeType string) (string, error) {
+	prompt := `Transcribe the following audio recording verbatim.
+Return only the transcript text, without timestamps, speaker labels, or any introductory text.
+If the recording contains no speech, return an empty response.`
+
+	// Recordings too large to inline are uploaded through the Files API
+	audioPart, release, err := s.blobInput(ctx, audio, mimeType)
+	if err != nil {
+		log.Printf("Error preparing audio for transcription: %v", err)
+		return "", fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+	defer release()
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	resp, err := s.generate(ctx, model, transcribeTimeout, audioPart, genai.Text(prompt))
+	if err != nil {
+		log.Printf("Error transcribing audio: %v", err)
+		return "", fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", nil
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])), nil
+}
+
+// ExtractImageText reads the text in a photo (a whiteboard, receipt, handwritten page, screenshot) and
+// returns it as markdown, or "" if the image has no legible text
+func (s *GeminiService) ExtractImageText(ctx context.Context, image []byte, mimeType string) (string, error) {
+	prompt := `Extract all legible text from this image (for example a whiteboard, receipt, document, or handwritten page) as a markdown note.
+Keep the text's own words and language; don't summarize, translate, or add anything.
+Recreate its structure with markdown: headings for titles, lists for bullet or numbered points, tables for tabular data such as receipt lines, and checkboxes ("- [ ]", "- [x]") for to-do items.
+Mark words you can't read with [illegible].
+Return only the markdown, without code fences or introductory text.
+If the image contains no legible text, return an empty response.`
+
+	imagePart, release, err := s.blobInput(ctx, image, mimeType)
+	if err != nil {
+		log.Printf("Error preparing image for text extraction: %v", err)
+		return "", fmt.Errorf("failed to extract image text: %w", err)
+	}
+	defer release()
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	resp, err := s.generate(ctx, model, ocrTimeout, imagePart, genai.Text(prompt))
+	if err != nil {
+		log.Printf("Error extracting image text: %v", err)
+		return "", fmt.Errorf("failed to extract image text: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", nil
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])), nil
+}
+
+// summaryInstructions tells the model how long a summary of each length preset should be
+var summaryInstructions = map[string]string{
+	models.SummaryLengthOneLine:   "Summarize the following note in a single sentence of at most 25 words.",
+	models.SummaryLengthParagraph: "Summarize the following note in one short paragraph of 3 to 5 sentences.",
+	models.SummaryLengthBullets:   "Summarize the following note as 3 to 7 concise markdown bullet points (\"- \"), most important first.",
+}
+
+// SummarizeNote summarizes note content at one of the summary length presets
+func (s *GeminiService) SummarizeNote(ctx context.Context, content, length string) (string, error) {
+	instruction, ok := summaryInstructions[length]
+	if !ok {
+		return "", fmt.Errorf("unknown summary length %q", length)
+	}
+	prompt := instruction + `
+Only use information from the note, and write in the note's language.
+Return only the summary, without any introductory text like "Here is a summary:".
+
+Note:
+`
+
+	// Long transcripts are uploaded through the Files API instead of being inlined
+	notePart, release, err := s.textInput(ctx, content)
+	if err != nil {
+		log.Printf("Error preparing note for summary: %v", err)
+		return "", fmt.Errorf("failed to summarize note: %w", err)
+	}
+	defer release()
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	resp, err := s.generate(ctx, model, summarizeTimeout, genai.Text(prompt), notePart)
+	if err != nil {
+		log.Printf("Error summarizing note: %v", err)
+		return "", fmt.Errorf("failed to summarize note: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", nil
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])), nil
+}
+
+// maxActionItems bounds the action items extracted from a note
+const maxActionItems = 50
+
+// ExtractActions finds the tasks, follow-ups, and commitments in note content (e.g. meeting notes).
+// Relative due dates are resolved against today (YYYY-MM-DD); due dates that don't parse and unknown
+// priorities are dropped rather than failing the request.
+func (s *GeminiService) ExtractActions(ctx context.Context, content, today string) ([]models.ActionItem, error) {
+	if strings.TrimSpace(content) == "" {
+		return []models.ActionItem{}, nil
+	}
+
+	prompt := fmt.Sprintf(`You are an expert note organizer. Extract the action items from the following note:
+tasks, follow-ups, and commitments someone still has to do. Ignore things that are already done.
+Write each task as a short imperative sentence in the note's language, keeping who it is for if the note says so.
+Today is %s.
+
+Your response must be a JSON object with a single key "tasks", an array of up to %d objects with these keys:
+- "text": the task
+- "due": the due date as YYYY-MM-DD if the note gives one (resolve relative dates like "next Friday" from today), otherwise omit it
+- "priority": "low", "medium", or "high" if the note implies how urgent the task is, otherwise omit it
+If the note has no action items, return {"tasks": []}.
+Example response: {"tasks": [{"text": "Send the budget draft to Maria", "due": "2024-05-10", "priority": "high"}, {"text": "Book a room for the retro"}]}
+
+Note:
+`, today, maxActionItems)
+
+	// Long transcripts are uploaded through the Files API instead of being inlined
+	notePart, release, err := s.textInput(ctx, content)
+	if err != nil {
+		log.Printf("Error preparing note for action items: %v", err)
+		return nil, fmt.Errorf("failed to extract action items: %w", err)
+	}
+	defer release()
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	model.ResponseMIMEType = "application/json"
+
+	resp, err := s.generate(ctx, model, actionsTimeout, genai.Text(prompt), notePart)
+	if err != nil {
+		log.Printf("Error extracting action items: %v", err)
+		return nil, fmt.Errorf("failed to extract action items: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return []models.ActionItem{}, nil
+	}
+
+	responseText := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
+	var result struct {
+		Tasks []models.ActionItem `json:"tasks"`
+	}
+	if err := json.Unmarshal([]byte(responseText), &result); err != nil {
+		log.Printf("Error parsing JSON response: %v", err)
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	tasks := []models.ActionItem{}
+	for _, task := range result.Tasks {
+		task.Text = strings.TrimSpace(task.Text)
+		if task.Text == "" || len(tasks) == maxActionItems {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, task.Due); err != nil {
+			task.Due = ""
+		}
+		switch task.Priority = strings.ToLower(strings.TrimSpace(task.Priority)); task.Priority {
+		case models.ActionPriorityLow, models.ActionPriorityMedium, models.ActionPriorityHigh:
+		default:
+			task.Priority = ""
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// GenerateTitle generates a concise title (at most maxLength characters) for note content
+func (s *GeminiService) GenerateTitle(ctx context.Context, content string, maxLength int) (string, error) {
+	prompt := fmt.Sprintf(`Generate a concise, descriptive title for the following note.
+The title must be at most %d characters long, must not be wrapped in quotes, and must not end with punctuation.
+Return only the title text.
+
+Note:
+---
+%s
+---
+`, maxLength, content)
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	resp, err := s.generate(ctx, model, titleTimeout, genai.Text(prompt))
+	if err != nil {
+		log.Printf("Error generating title: %v", err)
+		return "", fmt.Errorf("failed to generate title: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", nil
+	}
+
+	return normalizeTitle(fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0]), maxLength), nil
+}
+
+// maxTitleBatchExcerpt bounds how much of each note's content GenerateTitles sends, in characters;
+// the start of a note is enough to title it
+const maxTitleBatchExcerpt = 2000
+
+// GenerateTitles generates concise titles (at most maxLength characters) for several notes in one
+// request, keyed by note ID. Notes the model returns no usable title for are left out.
+func (s *GeminiService) GenerateTitles(ctx context.Context, notes []models.TitleInput, maxLength int) (map[string]string, error) {
+	excerpts := make([]models.TitleInput, len(notes))
+	for i, note := range notes {
+		excerpt := []rune(note.Content)
+		excerpts[i] = models.TitleInput{ID: note.ID, Content: string(excerpt[:min(len(excerpt), maxTitleBatchExcerpt)])}
+	}
+	notesJSON, err := json.Marshal(excerpts)
+	if err != nil {
+		log.Printf("Error marshaling title notes: %v", err)
+		return nil, fmt.Errorf("failed to marshal notes: %w", err)
+	}
+
+	prompt := fmt.Sprintf(`Generate a concise, descriptive title for each of the following notes.
+Each title must be at most %d characters long, must not be wrapped in quotes, and must not end with punctuation.
+
+Notes:
+---
+%s
+---
+
+Your response must be a JSON object with a single key "titles" mapping each note's ID to its title.
+Example response: {"titles": {"note-1": "Weekly team sync", "note-2": "Trip packing list"}}
+`, maxLength, string(notesJSON))
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	model.ResponseMIMEType = "application/json"
+
+	resp, err := s.generate(ctx, model, titleBatchTimeout, genai.Text(prompt))
+	if err != nil {
+		log.Printf("Error generating titles: %v", err)
+		return nil, fmt.Errorf("failed to generate titles: %w", err)
+	}
+
+	titles := make(map[string]string, len(notes))
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return titles, nil
+	}
+
+	responseText := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
+	var result struct {
+		Titles map[string]string `json:"titles"`
+	}
+	if err := json.Unmarshal([]byte(responseText), &result); err != nil {
+		log.Printf("Error parsing JSON response: %v", err)
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// Only accept IDs we actually offered
+	for _, note := range notes {
+		if title := normalizeTitle(result.Titles[note.ID], maxLength); title != "" {
+			titles[note.ID] = title
+		}
+	}
+	return titles, nil
+}
+
+// CategorizeNote picks the collection that best fits the note content.
+// Returns an empty string if none of the collections is a good fit.
+func (s *GeminiService) CategorizeNote(ctx context.Context, content string, collections []models.CollectionOption) (string, error) {
+	if strings.TrimSpace(content) == "" || len(collections) == 0 {
+		return "", nil
+	}
+
+	collectionsJSON, err := json.Marshal(collections)
+	if err != nil {
+		log.Printf("Error marshaling collections: %v", err)
+		return "", fmt.Errorf("failed to marshal collections: %w", err)
+	}
+
+	prompt := fmt.Sprintf(`
+Note Content:
+---
+%s
+---
+
+Available Collections:
+---
+%s
+---
+
+Based on the "Note Content", pick the single collection from "Available Collections" that the note belongs in.
+Your response must be a JSON object with a single key "collectionId" holding the ID of the chosen collection,
+or an empty string if no collection is a good fit.
+Example response: {"collectionId": "collection-2"}
+`, content, string(collectionsJSON))
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	model.ResponseMIMEType = "application/json"
+
+	resp, err := s.generate(ctx, model, titleTimeout, genai.Text(prompt))
+	if err != nil {
+		log.Printf("Error categorizing note: %v", err)
+		return "", fmt.Errorf("failed to categorize note: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", nil
+	}
+
+	responseText := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
+	var result struct {
+		CollectionID string `json:"collectionId"`
+	}
+	if err := json.Unmarshal([]byte(responseText), &result); err != nil {
+		log.Printf("Error parsing JSON response: %v", err)
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// Only accept IDs we actually offered
+	for _, coll := range collections {
+		if coll.ID == result.CollectionID {
+			return coll.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// Collection overview limits
+const (
+	maxOverviewThemes       = 8
+	maxOverviewNotableNotes = 5
+	maxOverviewGaps         = 5
+)
+
+// CollectionOverview summarizes a collection from its notes' titles and tags (listed most representative
+// first): a short description, its themes, notable notes, and gaps. Notable notes are limited to those offered.
+func (s *GeminiService) CollectionOverview(ctx context.Context, name string, notes []models.CollectionOverviewSource) (*models.CollectionOverview, error) {
+	notesJSON, err := json.Marshal(notes)
+	if err != nil {
+		log.Printf("Error marshaling overview notes: %v", err)
+		return nil, fmt.Errorf("failed to marshal notes: %w", err)
+	}
+
+	prompt := fmt.Sprintf(`
+Collection Name: %s
+
+Notes (titles and tags only, the most representative of the collection first):
+---
+%s
+---
+
+Write an overview of what this collection of notes contains.
+Your response must be a JSON object with these keys:
+- "description": one or two sentences describing the collection, at most 300 characters
+- "themes": up to %d short phrases naming the main themes
+- "notableNotes": up to %d objects {"noteId": "...", "reason": "..."} for the notes that best represent or stand out in the collection, using IDs from the list above
+- "gaps": up to %d short phrases naming topics the collection seems to be missing, given its themes
+Example response: {"description": "Recipes for quick weeknight dinners.", "themes": ["pasta", "meal prep"], "notableNotes": [{"noteId": "note-1", "reason": "The most complete recipe"}], "gaps": ["desserts"]}
+`, name, string(notesJSON), maxOverviewThemes, maxOverviewNotableNotes, maxOverviewGaps)
+
+	model := s.client.GenerativeModel(DefaultGeminiModel)
+	model.ResponseMIMEType = "application/json"
+
+	resp, err := s.generate(ctx, model, overviewTimeout, genai.Text(prompt))
+	if err != nil {
+		log.Printf("Error generating collection overview: %v", err)
+		return nil, fmt.Errorf("failed to generate collection overview: %w", err)
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, errors.New("failed to generate collection overview: empty response")
+	}
+
+	responseText := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
+	var result struct {
+		Description  string   `json:"description"`
+		Themes       []string `json:"themes"`
+		NotableNotes []struct {
+			NoteID string `json:"noteId"`
+			Reason string `json:"reason"`
+		} `json:"notableNotes"`
+		Gaps []string `json:"gaps"`
+	}
+	if err := json.Unmarshal([]byte(responseText), &result); err != nil {
+		log.Printf("Error parsing JSON response: %v", err)
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// Only accept notes we actually offered, each once
+	titles := make(map[string]string, len(notes))
+	for _, note := range notes {
+		titles[note.ID] = note.Title
+	}
+	overview := &models.CollectionOverview{
+		Description:  strings.TrimSpace(result.Description),
+		Themes:       overviewPhrases(result.Themes, maxOverviewThemes),
+		NotableNotes: []models.CollectionOverviewNote{},
+		Gaps:         overviewPhrases(result.Gaps, maxOverviewGaps),
+	}
+	for _, notable := range result.NotableNotes {
+		title, ok := titles[notable.NoteID]
+		if !ok || len(overview.NotableNotes) == maxOverviewNotableNotes {
+			continue
+		}
+		delete(titles, notable.NoteID)
+		overview.NotableNotes = append(overview.NotableNotes, models.CollectionOverviewNote{
+			NoteID: notable.NoteID,
+			Title:  title,
+			Reason: strings.TrimSpace(notable.Reason),
+		})
+	}
+	return overview, nil
+}
+
+// overviewPhrases trims phrases, dropping empty ones, and keeps at most limit
+func overviewPhrases(phrases []string, limit int) []string {
+	kept := make([]string, 0, len(phrases))
+	for _, phrase := range phrases {
+		if phrase = strings.TrimSpace(phrase); phrase != "" && len(kept) < limit {
+			kept = append(kept, phrase)
+		}
+	}
+	return kept
+}
+
+// normalizeTitle strips quotes and trailing punctuation and enforces the maximum length
+func normalizeTitle(title string, maxLength int) string {
+	title = strings.TrimSpace(strings.Split(strings.TrimSpace(title), "\n")[0])
+	title = strings.Trim(title, "\"'`*#")
+	title = strings.TrimRight(strings.TrimSpace(title), ".:;,")
+
+	runes := []rune(title)
+	if maxLength > 0 && len(runes) > maxLength {
+		title = strings.TrimSpace(string(runes[:maxLength]))
+	}
+	return title
+}
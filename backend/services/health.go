@@ -0,0 +1,182 @@
+// Periodic dependency health checks with persisted history
+package services
+
+import (
+	"backend/models"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// healthCheckInterval is how often dependencies are checked
+	healthCheckInterval = time.Minute
+	// healthCheckTimeout bounds a single dependency check
+	healthCheckTimeout = 10 * time.Second
+	// HealthHistoryRetention is how long check results are kept
+	HealthHistoryRetention = 30 * 24 * time.Hour
+	// maxPendingHealthChecks caps results buffered while the database is unreachable
+	maxPendingHealthChecks = 1000
+)
+
+// HealthCheck checks one dependency, returning an error if it's unhealthy
+type HealthCheck struct {
+	Dependency string
+	Check      func(ctx context.Context) error
+}
+
+// DependencyHealth is the latest known state of a dependency
+type DependencyHealth struct {
+	Result              models.HealthCheckResult
+	ConsecutiveFailures int
+}
+
+// HealthMonitor periodically runs dependency checks and records their results in health_checks.
+// Results recorded while the database is down are buffered and written once it's back.
+type HealthMonitor struct {
+	db     *Database
+	checks []HealthCheck
+
+	mu      sync.Mutex
+	pending []models.HealthCheckResult
+	current map[string]DependencyHealth // Dependency -> latest state, kept in memory for status reporting
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewHealthMonitor creates a new HealthMonitor and starts checking
+func NewHealthMonitor(db *Database, checks ...HealthCheck) *HealthMonitor {
+	m := &HealthMonitor{
+		db:      db,
+		checks:  checks,
+		current: make(map[string]DependencyHealth),
+		done:    make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go m.loop()
+	return m
+}
+
+// Close stops checking
+func (m *HealthMonitor) Close() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+// Current returns the latest state of each checked dependency, in check order. Dependencies not
+// checked yet are omitted.
+func (m *HealthMonitor) Current() []DependencyHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := make([]DependencyHealth, 0, len(m.checks))
+	for _, check := range m.checks {
+		if health, ok := m.current[check.Dependency]; ok {
+			current = append(current, health)
+		}
+	}
+	return current
+}
+
+func (m *HealthMonitor) loop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	m.runChecks()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.runChecks()
+		}
+	}
+}
+
+// runChecks checks every dependency concurrently and persists the results
+func (m *HealthMonitor) runChecks() {
+	results := make([]models.HealthCheckResult, len(m.checks))
+
+	var wg sync.WaitGroup
+	for i, check := range m.checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	for _, result := range results {
+		health := DependencyHealth{Result: result}
+		if result.Status != models.HealthOK {
+			Warnf("Health check failed for %s: %s", result.Dependency, result.Error)
+			health.ConsecutiveFailures = m.current[result.Dependency].ConsecutiveFailures + 1
+		}
+		m.current[result.Dependency] = health
+	}
+	m.mu.Unlock()
+	m.persist(results)
+}
+
+func runHealthCheck(check HealthCheck) models.HealthCheckResult {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Check(ctx)
+	result := models.HealthCheckResult{
+		Dependency: check.Dependency,
+		Status:     models.HealthOK,
+		LatencyMs:  time.Since(start).Milliseconds(),
+		CheckedAt:  start,
+	}
+	if err != nil {
+		result.Status = models.HealthDown
+		result.Error = Redact(err.Error()) // Provider errors can embed request URLs with keys
+	}
+	return result
+}
+
+// persist writes results along with any buffered from earlier failed writes, then prunes old history
+func (m *HealthMonitor) persist(results []models.HealthCheckResult) {
+	m.mu.Lock()
+	batch := append(m.pending, results...)
+	m.pending = nil
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	for i, result := range batch {
+		_, err := m.db.DB.ExecContext(ctx, `
+			INSERT INTO health_checks (dependency, status, latency_ms, error, checked_at)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		`, result.Dependency, result.Status, result.LatencyMs, result.Error, result.CheckedAt)
+		if err != nil {
+			log.Printf("Error recording health checks: %v", err)
+			m.buffer(batch[i:])
+			return
+		}
+	}
+
+	if _, err := m.db.DB.ExecContext(ctx, `DELETE FROM health_checks WHERE checked_at < $1`, time.Now().Add(-HealthHistoryRetention)); err != nil {
+		log.Printf("Error pruning health check history: %v", err)
+	}
+}
+
+// buffer keeps unwritten results for the next run, dropping the oldest beyond maxPendingHealthChecks
+func (m *HealthMonitor) buffer(results []models.HealthCheckResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending = append(results, m.pending...)
+	if excess := len(m.pending) - maxPendingHealthChecks; excess > 0 {
+		m.pending = m.pending[excess:]
+	}
+}
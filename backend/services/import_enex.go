@@ -0,0 +1,358 @@
+// Evernote ENEX importer, converting ENML note bodies into Markdown
+package services
+
+import (
+	"backend/models"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// enexTimeLayout is the timestamp format of ENEX created and updated elements
+const enexTimeLayout = "20060102T150405Z"
+
+// enexExtensions are the files picked up from a zip of Evernote notebooks
+var enexExtensions = map[string]bool{".enex": true}
+
+// enexExport is an Evernote notebook export. Attachments (resource elements) aren't read.
+type enexExport struct {
+	Notes []enexNote `xml:"note"`
+}
+
+type enexNote struct {
+	Title   string   `xml:"title"`
+	Content string   `xml:"content"` // ENML document
+	Created string   `xml:"created"`
+	Tags    []string `xml:"tag"`
+}
+
+// Markdown cleanup after converting ENML
+var (
+	markdownBlankLines     = regexp.MustCompile(`\n{3,}`)
+	markdownTrailingSpaces = regexp.MustCompile(`[ \t]+\n`)
+	enmlWhitespace         = regexp.MustCompile(`\s+`)
+)
+
+// importENEX converts a single Evernote .enex notebook or a zip of them. Each notebook's file name
+// becomes the collection of its notes.
+func importENEX(ctx context.Context, job *JobContext, filename string) ([]models.ImportedNote, error) {
+	files, err := importFiles(job, filename, enexExtensions)
+	if err != nil {
+		return nil, err
+	}
+
+	var notebooks []enexExport
+	var total int
+	for _, f := range files {
+		data, err := readImportFile(f, maxImportedFileSize)
+		if err != nil {
+			job.AddError(f.path, err.Error())
+			notebooks = append(notebooks, enexExport{})
+			continue
+		}
+		var notebook enexExport
+		if err := xml.Unmarshal(data, &notebook); err != nil {
+			job.AddError(f.path, fmt.Sprintf("invalid ENEX file: %v", err))
+		}
+		notebooks = append(notebooks, notebook)
+		total += len(notebook.Notes)
+	}
+
+	job.SetTotal(total)
+
+	var notes []models.ImportedNote
+	for i, notebook := range notebooks {
+		base := path.Base(files[i].path)
+		collection := strings.TrimSuffix(base, path.Ext(base))
+		for j, entry := range notebook.Notes {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			sourcePath := files[i].path + "#" + strconv.Itoa(j+1)
+			note, warnings, err := readENEXNote(entry)
+			if err != nil {
+				job.AddError(sourcePath, err.Error())
+				job.Advance()
+				continue
+			}
+			for _, warning := range warnings {
+				job.AddError(sourcePath, warning)
+			}
+			note.Collection = collection
+			note.SourcePath = sourcePath
+			notes = append(notes, note)
+			job.Advance()
+		}
+	}
+
+	return notes, nil
+}
+
+// readENEXNote converts an Evernote note. Parts of it that can't be imported (attachments and encrypted
+// text) are left out and reported as warnings.
+func readENEXNote(entry enexNote) (models.ImportedNote, []string, error) {
+	converter := &enmlConverter{}
+	content, err := converter.convert(entry.Content)
+	if err != nil {
+		return models.ImportedNote{}, nil, fmt.Errorf("invalid note content: %w", err)
+	}
+	if len(content) > maxImportedNoteSize {
+		return models.ImportedNote{}, nil, fmt.Errorf("note exceeds %d bytes", maxImportedNoteSize)
+	}
+	if !utf8.ValidString(content) {
+		return models.ImportedNote{}, nil, fmt.Errorf("note is not valid UTF-8")
+	}
+
+	var warnings []string
+	if converter.attachments > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d attachment(s) not imported", converter.attachments))
+	}
+	if converter.encrypted > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d encrypted section(s) not imported", converter.encrypted))
+	}
+
+	note := models.ImportedNote{
+		Title:   strings.TrimSpace(entry.Title),
+		Content: content,
+		Tags:    importTags(entry.Tags),
+	}
+	if created, err := time.Parse(enexTimeLayout, strings.TrimSpace(entry.Created)); err == nil {
+		note.Date = &created
+	}
+	return note, warnings, nil
+}
+
+// enmlNode is an element (or, with an empty name, a text run) of an ENML document
+type enmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*enmlNode
+}
+
+// enmlConverter renders ENML (Evernote's XHTML dialect) as Markdown, counting what it leaves out
+type enmlConverter struct {
+	attachments int // en-media elements
+	encrypted   int // en-crypt elements
+}
+
+// convert parses an ENML document and renders it as Markdown
+func (c *enmlConverter) convert(document string) (string, error) {
+	root, err := parseENML(document)
+	if err != nil {
+		return "", err
+	}
+	markdown := c.render(root, false)
+	markdown = markdownTrailingSpaces.ReplaceAllString(markdown, "\n")
+	markdown = markdownBlankLines.ReplaceAllString(markdown, "\n\n")
+	return strings.TrimSpace(markdown), nil
+}
+
+// parseENML builds the element tree of an ENML document. Parsing is lenient about HTML entities and
+// unclosed void elements, which notes edited outside Evernote sometimes contain.
+func parseENML(document string) (*enmlNode, error) {
+	decoder := xml.NewDecoder(strings.NewReader(document))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	root := &enmlNode{name: "#root"}
+	stack := []*enmlNode{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &enmlNode{name: strings.ToLower(t.Name.Local), attrs: make(map[string]string, len(t.Attr))}
+			for _, attr := range t.Attr {
+				node.attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+			}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			// Close up to the matching element, ignoring stray end tags
+			name := strings.ToLower(t.Name.Local)
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &enmlNode{text: string(t)})
+		}
+	}
+}
+
+// render returns the Markdown for a node. Block elements are surrounded by blank lines, which convert
+// collapses afterwards.
+func (c *enmlConverter) render(n *enmlNode, pre bool) string {
+	if n.name == "" {
+		if pre {
+			return n.text
+		}
+		return enmlWhitespace.ReplaceAllString(n.text, " ")
+	}
+
+	inner := func() string {
+		var b strings.Builder
+		for _, child := range n.children {
+			b.WriteString(c.render(child, pre || n.name == "pre"))
+		}
+		return b.String()
+	}
+
+	switch n.name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		return "\n\n" + strings.Repeat("#", int(n.name[1]-'0')) + " " + strings.TrimSpace(inner()) + "\n\n"
+	case "p", "div", "en-note", "section", "article", "header", "footer", "center", "dl", "dd", "dt":
+		return "\n\n" + inner() + "\n\n"
+	case "br":
+		return "\n"
+	case "hr":
+		return "\n\n---\n\n"
+	case "b", "strong":
+		return enmlWrap(inner(), "**")
+	case "i", "em":
+		return enmlWrap(inner(), "*")
+	case "s", "strike", "del":
+		return enmlWrap(inner(), "~~")
+	case "code", "tt":
+		if pre {
+			return inner()
+		}
+		return enmlWrap(inner(), "`")
+	case "pre":
+		return "\n\n```\n" + strings.Trim(inner(), "\n") + "\n```\n\n"
+	case "a":
+		text, href := strings.TrimSpace(inner()), strings.TrimSpace(n.attrs["href"])
+		if href == "" || text == "" {
+			return text
+		}
+		return "[" + text + "](" + href + ")"
+	case "img":
+		if src := n.attrs["src"]; strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://") {
+			return "![" + n.attrs["alt"] + "](" + src + ")"
+		}
+		return ""
+	case "ul", "ol":
+		return "\n\n" + c.renderList(n, pre) + "\n\n"
+	case "blockquote":
+		lines := strings.Split(strings.TrimSpace(markdownBlankLines.ReplaceAllString(inner(), "\n\n")), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return "\n\n" + strings.Join(lines, "\n") + "\n\n"
+	case "table":
+		return "\n\n" + c.renderTable(n) + "\n\n"
+	case "en-todo":
+		if n.attrs["checked"] == "true" {
+			return "- [x] "
+		}
+		return "- [ ] "
+	case "en-media":
+		c.attachments++
+		return ""
+	case "en-crypt":
+		c.encrypted++
+		return ""
+	case "head", "title", "script", "style":
+		return ""
+	default:
+		return inner()
+	}
+}
+
+// renderList renders a ul or ol element's items, indenting their continuation lines (nested lists
+// included) under the item marker
+func (c *enmlConverter) renderList(list *enmlNode, pre bool) string {
+	var items []string
+	for _, child := range list.children {
+		if child.name != "li" {
+			continue
+		}
+		marker := "- "
+		if list.name == "ol" {
+			marker = strconv.Itoa(len(items)+1) + ". "
+		}
+		var b strings.Builder
+		for _, grandchild := range child.children {
+			b.WriteString(c.render(grandchild, pre))
+		}
+		text := strings.TrimSpace(markdownBlankLines.ReplaceAllString(b.String(), "\n\n"))
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\n\n", "\n"), "\n", "\n"+strings.Repeat(" ", len(marker)))
+		items = append(items, marker+text)
+	}
+	return strings.Join(items, "\n")
+}
+
+// renderTable renders a table as a GitHub-flavored Markdown table, its first row as the header
+func (c *enmlConverter) renderTable(table *enmlNode) string {
+	var rows [][]string
+	var collect func(n *enmlNode)
+	collect = func(n *enmlNode) {
+		for _, child := range n.children {
+			switch child.name {
+			case "tr":
+				var cells []string
+				for _, cell := range child.children {
+					if cell.name != "td" && cell.name != "th" {
+						continue
+					}
+					text := strings.TrimSpace(enmlWhitespace.ReplaceAllString(c.render(cell, false), " "))
+					cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+				}
+				rows = append(rows, cells)
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			}
+		}
+	}
+	collect(table)
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	lines := make([]string, 0, len(rows)+1)
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// enmlWrap wraps text in an inline Markdown marker, keeping surrounding whitespace outside it
+func enmlWrap(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	leading := text[:len(text)-len(strings.TrimLeft(text, " \t\n"))]
+	trailing := text[len(strings.TrimRight(text, " \t\n")):]
+	return leading + marker + trimmed + marker + trailing
+}
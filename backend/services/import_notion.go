@@ -0,0 +1,119 @@
+// Notion importer for "Markdown & CSV" workspace and page exports
+package services
+
+import (
+	"backend/models"
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// notionExtensions are the files picked up from a Notion export: pages, plus the nested archives large
+// exports are split into. Database CSVs and attachments are skipped.
+var notionExtensions = map[string]bool{".md": true, ".zip": true}
+
+// notionID matches the page ID Notion appends to exported file and folder names
+var notionID = regexp.MustCompile(`\s+[0-9a-f]{32}$`)
+
+// notionDateLayouts are the formats of date properties in exported pages
+var notionDateLayouts = []string{"January 2, 2006 3:04 PM", "January 2, 2006"}
+
+// importNotion converts a Notion export zip. Each page becomes a note in the collection named after its
+// parent page; database rows' Tags and Created properties become the note's tags and date.
+func importNotion(ctx context.Context, job *JobContext, filename string) ([]models.ImportedNote, error) {
+	if !strings.EqualFold(path.Ext(filename), ".zip") {
+		return nil, fmt.Errorf("a Notion import must be the .zip Notion exports")
+	}
+	files, err := zipImportFiles(job.Input, notionExtensions)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages, archives []importFile
+	for _, f := range files {
+		if strings.EqualFold(path.Ext(f.path), ".zip") {
+			archives = append(archives, f)
+		} else {
+			pages = append(pages, f)
+		}
+	}
+
+	total := len(pages)
+	job.SetTotal(total)
+	notes, err := importNotionPages(ctx, job, pages, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unpack nested archives one level deep, one at a time: each archive's pages are read before the
+	// next is inflated, so at most one is held in memory
+	for _, archive := range archives {
+		data, err := readImportFile(archive, maxImportedFileSize)
+		if err != nil {
+			job.AddError(archive.path, err.Error())
+			continue
+		}
+		nested, err := zipImportFiles(data, map[string]bool{".md": true})
+		if err != nil {
+			job.AddError(archive.path, err.Error())
+			continue
+		}
+		total += len(nested)
+		job.SetTotal(total)
+		if notes, err = importNotionPages(ctx, job, nested, notes); err != nil {
+			return nil, err
+		}
+	}
+
+	return notes, nil
+}
+
+// importNotionPages converts exported pages, appending them to notes
+func importNotionPages(ctx context.Context, job *JobContext, pages []importFile, notes []models.ImportedNote) ([]models.ImportedNote, error) {
+	for _, f := range pages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		note, err := readMarkdownNote(f)
+		if err != nil {
+			job.AddError(f.path, err.Error())
+			job.Advance()
+			continue
+		}
+		note.Title = notionID.ReplaceAllString(note.Title, "")
+		note.Collection = notionID.ReplaceAllString(note.Collection, "")
+		note.Tags, note.Date = notionProperties(note.Content)
+		notes = append(notes, note)
+		job.Advance()
+	}
+	return notes, nil
+}
+
+// notionProperties reads the tags and creation date from the "Name: value" property lines Notion puts
+// at the top of exported database rows. The lines are left in the content.
+func notionProperties(content string) ([]string, *time.Time) {
+	var tags []string
+	var created *time.Time
+	for _, line := range strings.Split(content, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok {
+			break // The properties end at the first line that isn't one
+		}
+		switch strings.ToLower(name) {
+		case "tags":
+			tags = importTags(strings.Split(value, ","))
+		case "created", "created time":
+			for _, layout := range notionDateLayouts {
+				if date, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+					created = &date
+					break
+				}
+			}
+		}
+	}
+	return tags, created
+}
@@ -0,0 +1,211 @@
+// Note importers converting external formats into plaintext notes
+package services
+
+import (
+	"archive/zip"
+	"backend/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Import limits
+const (
+	maxImportedNoteSize = 1 << 20 // Caps a single imported note's content
+	// maxImportedFileSize caps a file unpacked from an uploaded archive (an Evernote notebook or a nested
+	// Notion archive), so a small archive can't expand without bound
+	maxImportedFileSize = 100 << 20
+	// Imported tags past the limits notes are pushed with are dropped
+	maxImportedTags      = 50
+	maxImportedTagLength = 64
+)
+
+// markdownExtensions are the files picked up from a Markdown import
+var markdownExtensions = map[string]bool{
+	".md":       true,
+	".markdown": true,
+	".txt":      true,
+}
+
+// RunImportJob is the JobFunc for import jobs. Converted notes are placed in the user's staged
+// notes inbox; notes that fail to convert are reported as partial errors instead of failing the whole import,
+// and notes already imported before are reported as duplicates.
+func RunImportJob(ctx context.Context, job *JobContext) (interface{}, error) {
+	var params models.ImportParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return nil, fmt.Errorf("invalid import params: %w", err)
+	}
+
+	var notes []models.ImportedNote
+	var err error
+	switch params.Format {
+	case models.ImportFormatMarkdown:
+		notes, err = importMarkdown(ctx, job, params.Filename)
+	case models.ImportFormatENEX:
+		notes, err = importENEX(ctx, job, params.Filename)
+	case models.ImportFormatNotion:
+		notes, err = importNotion(ctx, job, params.Filename)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", params.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids, duplicates, err := job.db.StageNotes(ctx, job.UserID, models.StagedSourceImport, job.ID, notes, params.OnDuplicate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stage imported notes: %w", err)
+	}
+
+	result := &models.ImportResult{StagedNoteIDs: ids, Collections: []string{}, Duplicates: duplicates}
+	seenCollections := make(map[string]bool)
+	for _, note := range notes {
+		if note.Collection != "" && !seenCollections[note.Collection] {
+			seenCollections[note.Collection] = true
+			result.Collections = append(result.Collections, note.Collection)
+		}
+	}
+	return result, nil
+}
+
+// importFile is a single file of an upload, read lazily
+type importFile struct {
+	path string
+	open func() (io.ReadCloser, error)
+}
+
+// importFiles returns the upload itself, or for a zip the files in it with one of the extensions, by path
+func importFiles(job *JobContext, filename string, extensions map[string]bool) ([]importFile, error) {
+	if !strings.EqualFold(path.Ext(filename), ".zip") {
+		return []importFile{{path: filename, open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(job.Input)), nil
+		}}}, nil
+	}
+	return zipImportFiles(job.Input, extensions)
+}
+
+// zipImportFiles returns the files of a zip archive with one of the extensions, by path
+func zipImportFiles(data []byte, extensions map[string]bool) ([]importFile, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip archive: %w", err)
+	}
+	var files []importFile
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() || !extensions[strings.ToLower(path.Ext(f.Name))] {
+			continue
+		}
+		files = append(files, importFile{path: f.Name, open: f.Open})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// readImportFile reads a whole file of an upload, failing if it's larger than limit
+func readImportFile(f importFile, limit int64) ([]byte, error) {
+	rc, err := f.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			log.Printf("Error closing imported file %s: %v", f.path, err)
+		}
+	}()
+
+	content, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("file exceeds %d bytes", limit)
+	}
+	return content, nil
+}
+
+// importTags trims imported tags and drops blank, duplicate (ignoring case), and overlong ones
+func importTags(tags []string) []string {
+	imported := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > maxImportedTagLength || seen[key] {
+			continue
+		}
+		seen[key] = true
+		imported = append(imported, tag)
+		if len(imported) == maxImportedTags {
+			break
+		}
+	}
+	return imported
+}
+
+// importMarkdown converts a single Markdown file or a zip of Markdown files.
+// Folder names inside a zip become collection names.
+func importMarkdown(ctx context.Context, job *JobContext, filename string) ([]models.ImportedNote, error) {
+	files, err := importFiles(job, filename, markdownExtensions)
+	if err != nil {
+		return nil, err
+	}
+
+	job.SetTotal(len(files))
+
+	var notes []models.ImportedNote
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		note, err := readMarkdownNote(f)
+		if err != nil {
+			job.AddError(f.path, err.Error())
+			job.Advance()
+			continue
+		}
+		notes = append(notes, note)
+		job.Advance()
+	}
+
+	return notes, nil
+}
+
+func readMarkdownNote(f importFile) (models.ImportedNote, error) {
+	content, err := readImportFile(f, maxImportedNoteSize)
+	if err != nil {
+		return models.ImportedNote{}, err
+	}
+	if !utf8.Valid(content) {
+		return models.ImportedNote{}, fmt.Errorf("file is not valid UTF-8")
+	}
+
+	text := strings.TrimPrefix(string(content), "\ufeff")
+	base := path.Base(f.path)
+	title := strings.TrimSuffix(base, path.Ext(base))
+
+	// Prefer a leading "# Heading" over the file name
+	if firstLine, rest, _ := strings.Cut(strings.TrimLeft(text, "\r\n"), "\n"); strings.HasPrefix(firstLine, "# ") {
+		title = strings.TrimSpace(strings.TrimPrefix(firstLine, "# "))
+		text = strings.TrimLeft(rest, "\r\n")
+	}
+
+	collection := ""
+	if dir := path.Dir(f.path); dir != "." && dir != "/" {
+		collection = path.Base(dir)
+	}
+
+	return models.ImportedNote{
+		Title:      title,
+		Content:    text,
+		Collection: collection,
+		SourcePath: f.path,
+	}, nil
+}
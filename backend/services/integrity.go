@@ -0,0 +1,65 @@
+// Merkle-style digests used to detect divergence between client and server note sets
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DigestBucketCount is the number of buckets notes are partitioned into (by first hex digit of the ID hash)
+const DigestBucketCount = 16
+
+// NoteDigestEntry is a single note's contribution to a digest
+type NoteDigestEntry struct {
+	ID        string
+	UpdatedAt time.Time
+}
+
+// NoteLeafHash hashes a note's identity and version as hex(sha256(id + ":" + updatedAtUnixMillis))
+// Millisecond precision matches what JavaScript clients can represent.
+func NoteLeafHash(id string, updatedAt time.Time) string {
+	sum := sha256.Sum256([]byte(id + ":" + strconv.FormatInt(updatedAt.UnixMilli(), 10)))
+	return hex.EncodeToString(sum[:])
+}
+
+// NoteBucket returns the bucket (0-15) a note ID falls into: the first hex digit of hex(sha256(id))
+func NoteBucket(id string) int {
+	sum := sha256.Sum256([]byte(id))
+	return int(sum[0] >> 4)
+}
+
+// DigestLeaves combines leaf hashes into a single hash: hex(sha256(concat(sorted leaves)))
+// An empty set hashes to hex(sha256("")).
+func DigestLeaves(leaves []string) string {
+	sorted := append([]string(nil), leaves...)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	for _, leaf := range sorted {
+		h.Write([]byte(leaf))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// BucketDigests partitions entries into DigestBucketCount buckets and returns each bucket's
+// leaf count and digest, plus the root hash over the bucket digests in bucket order
+func BucketDigests(entries []NoteDigestEntry) (root string, counts []int, hashes []string) {
+	leaves := make([][]string, DigestBucketCount)
+	for _, entry := range entries {
+		bucket := NoteBucket(entry.ID)
+		leaves[bucket] = append(leaves[bucket], NoteLeafHash(entry.ID, entry.UpdatedAt))
+	}
+
+	counts = make([]int, DigestBucketCount)
+	hashes = make([]string, DigestBucketCount)
+	h := sha256.New()
+	for i := range leaves {
+		counts[i] = len(leaves[i])
+		hashes[i] = DigestLeaves(leaves[i])
+		h.Write([]byte(hashes[i]))
+	}
+	return hex.EncodeToString(h.Sum(nil)), counts, hashes
+}
@@ -0,0 +1,344 @@
+// Background job queue backed by the jobs table
+package services
+
+import (
+	"backend/models"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// jobPollInterval is how often idle workers look for queued jobs they weren't woken for
+	jobPollInterval = 5 * time.Second
+	// jobProgressFlushInterval throttles progress writes while a job runs
+	jobProgressFlushInterval = time.Second
+	// jobStaleAfter is when a running job is assumed to belong to a crashed worker and is requeued
+	jobStaleAfter = 30 * time.Minute
+	// jobTimeout bounds a single job run
+	jobTimeout = 20 * time.Minute
+)
+
+// JobFunc runs a job and returns its result, which is stored as JSON.
+// Progress and per-item errors are reported through the JobContext.
+type JobFunc func(ctx context.Context, job *JobContext) (interface{}, error)
+
+// JobContext is the running state of a job handed to its JobFunc
+type JobContext struct {
+	ID     string
+	UserID string
+	Input  []byte
+	Params json.RawMessage
+
+	jobType   string
+	db        *Database
+	mu        sync.Mutex
+	progress  models.JobProgress
+	errors    []models.JobItemError
+	lastFlush time.Time
+}
+
+// JobQueue runs background jobs on a fixed pool of workers
+type JobQueue struct {
+	db       *Database
+	workers  int
+	handlers map[string]JobFunc
+	wake     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewJobQueue creates a new JobQueue. Register job types before calling Start.
+func NewJobQueue(db *Database, workers int) *JobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobQueue{
+		db:       db,
+		workers:  workers,
+		handlers: make(map[string]JobFunc),
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Register sets the function that runs jobs of the given type
+func (q *JobQueue) Register(jobType string, fn JobFunc) {
+	q.handlers[jobType] = fn
+}
+
+// Start requeues jobs interrupted by a crash and starts the workers
+func (q *JobQueue) Start() {
+	result, err := q.db.DB.ExecContext(q.ctx, `
+		UPDATE jobs SET status = $1, started_at = NULL
+		WHERE status = $2 AND started_at < CURRENT_TIMESTAMP - $3 * INTERVAL '1 second'
+	`, models.JobQueued, models.JobRunning, int(jobStaleAfter.Seconds()))
+	if err != nil {
+		log.Printf("Error requeueing stale jobs: %v", err)
+	} else if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Requeued %d stale job(s)", n)
+	}
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// Close stops the workers, waiting for running jobs to observe cancellation
+func (q *JobQueue) Close() {
+	q.cancel()
+	q.wg.Wait()
+}
+
+// Enqueue stores a new job and wakes a worker. It returns the job ID.
+func (q *JobQueue) Enqueue(ctx context.Context, userID, jobType string, input []byte, params interface{}) (string, error) {
+	if _, ok := q.handlers[jobType]; !ok {
+		return "", fmt.Errorf("unknown job type %q", jobType)
+	}
+
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode job params: %w", err)
+	}
+
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	jobID := "job_" + hex.EncodeToString(idBytes)
+
+	query := `
+		INSERT INTO jobs (id, user_id, type, status, input, params, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+	`
+	if _, err := q.db.DB.ExecContext(ctx, query, jobID, userID, jobType, models.JobQueued, input, paramsJSON); err != nil {
+		return "", err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return jobID, nil
+}
+
+// GetJob fetches a job owned by the user. It returns sql.ErrNoRows if there is no such job.
+func (q *JobQueue) GetJob(ctx context.Context, userID, jobID string) (*models.Job, error) {
+	query := `
+		SELECT id, type, status, progress_current, progress_total, errors, result, error,
+		       created_at, started_at, finished_at
+		FROM jobs
+		WHERE id = $1 AND user_id = $2
+	`
+
+	var job models.Job
+	var errorsJSON, resultJSON []byte
+	var fatal sql.NullString
+	var startedAt, finishedAt sql.NullTime
+	err := q.db.DB.QueryRowContext(ctx, query, jobID, userID).Scan(
+		&job.ID, &job.Type, &job.Status, &job.Progress.Current, &job.Progress.Total,
+		&errorsJSON, &resultJSON, &fatal, &job.CreatedAt, &startedAt, &finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(errorsJSON, &job.Errors); err != nil {
+		return nil, fmt.Errorf("failed to decode job errors: %w", err)
+	}
+	if job.Errors == nil {
+		job.Errors = []models.JobItemError{}
+	}
+	job.Result = resultJSON
+	job.Error = fatal.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
+
+func (q *JobQueue) worker() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the queue before going idle
+		for {
+			job, err := q.claim()
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) && q.ctx.Err() == nil {
+					log.Printf("Error claiming job: %v", err)
+				}
+				break
+			}
+			q.run(job)
+		}
+
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim marks the oldest queued job as running and returns it
+func (q *JobQueue) claim() (*JobContext, error) {
+	query := `
+		UPDATE jobs SET status = $1, started_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = $2
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, type, input, params
+	`
+
+	job := &JobContext{db: q.db, lastFlush: time.Now()}
+	var params []byte
+	err := q.db.DB.QueryRowContext(q.ctx, query, models.JobRunning, models.JobQueued).Scan(
+		&job.ID, &job.UserID, &job.jobType, &job.Input, &params,
+	)
+	if err != nil {
+		return nil, err
+	}
+	job.Params = params
+	return job, nil
+}
+
+// run executes a claimed job and records its outcome
+func (q *JobQueue) run(job *JobContext) {
+	fn, ok := q.handlers[job.jobType]
+	if !ok {
+		q.finish(job, nil, fmt.Errorf("unknown job type %q", job.jobType))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(q.ctx, jobTimeout)
+	defer cancel()
+
+	result, err := func() (result interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("job panicked: %v", p)
+			}
+		}()
+		return fn(ctx, job)
+	}()
+
+	if q.ctx.Err() != nil {
+		// Shutting down: leave the job running so it's requeued once it goes stale
+		log.Printf("Job %s interrupted by shutdown", job.ID)
+		return
+	}
+	q.finish(job, result, err)
+}
+
+// finish stores the job's final state and drops its input
+func (q *JobQueue) finish(job *JobContext, result interface{}, jobErr error) {
+	status := models.JobCompleted
+	var resultJSON []byte
+	var fatal interface{}
+	if jobErr != nil {
+		log.Printf("Job %s (%s) failed: %v", job.ID, job.jobType, jobErr)
+		status = models.JobFailed
+		fatal = jobErr.Error()
+	} else if result != nil {
+		var err error
+		if resultJSON, err = json.Marshal(result); err != nil {
+			log.Printf("Error encoding result of job %s: %v", job.ID, err)
+			status = models.JobFailed
+			fatal = "failed to encode job result"
+		}
+	}
+
+	job.mu.Lock()
+	progress := job.progress
+	errorsJSON, err := json.Marshal(job.itemErrorsLocked())
+	job.mu.Unlock()
+	if err != nil {
+		log.Printf("Error encoding errors of job %s: %v", job.ID, err)
+		errorsJSON = []byte("[]")
+	}
+
+	query := `
+		UPDATE jobs
+		SET status = $1, result = $2, error = $3, progress_current = $4, progress_total = $5,
+		    errors = $6, input = NULL, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $7
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := q.db.DB.ExecContext(ctx, query, status, resultJSON, fatal, progress.Current, progress.Total, errorsJSON, job.ID); err != nil {
+		log.Printf("Error finishing job %s: %v", job.ID, err)
+	}
+}
+
+// SetTotal sets the number of items the job will process
+func (j *JobContext) SetTotal(total int) {
+	j.mu.Lock()
+	j.progress.Total = total
+	j.mu.Unlock()
+	j.flush(true)
+}
+
+// Advance marks one more item as processed
+func (j *JobContext) Advance() {
+	j.mu.Lock()
+	j.progress.Current++
+	j.mu.Unlock()
+	j.flush(false)
+}
+
+// AddError records a partial failure; the job keeps going
+func (j *JobContext) AddError(item, message string) {
+	j.mu.Lock()
+	j.errors = append(j.errors, models.JobItemError{Item: item, Message: message})
+	j.mu.Unlock()
+	j.flush(false)
+}
+
+func (j *JobContext) itemErrorsLocked() []models.JobItemError {
+	if j.errors == nil {
+		return []models.JobItemError{}
+	}
+	return j.errors
+}
+
+// flush writes progress to the database, at most once per jobProgressFlushInterval unless forced
+func (j *JobContext) flush(force bool) {
+	j.mu.Lock()
+	if !force && time.Since(j.lastFlush) < jobProgressFlushInterval {
+		j.mu.Unlock()
+		return
+	}
+	j.lastFlush = time.Now()
+	progress := j.progress
+	errorsJSON, err := json.Marshal(j.itemErrorsLocked())
+	j.mu.Unlock()
+	if err != nil {
+		log.Printf("Error encoding errors of job %s: %v", j.ID, err)
+		return
+	}
+
+	query := `UPDATE jobs SET progress_current = $1, progress_total = $2, errors = $3 WHERE id = $4`
+	if _, err := j.db.DB.Exec(query, progress.Current, progress.Total, errorsJSON, j.ID); err != nil {
+		log.Printf("Error updating progress of job %s: %v", j.ID, err)
+	}
+}
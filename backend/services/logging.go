@@ -0,0 +1,18 @@
+// Leveled logging helpers honoring the runtime LOG_LEVEL
+package services
+
+import "log"
+
+// Debugf logs a debug message if the runtime log level allows it
+func Debugf(format string, args ...interface{}) {
+	if Config.LogEnabled(LogLevelDebug) {
+		log.Printf("[DEBUG] "+format, args...)
+	}
+}
+
+// Warnf logs a warning if the runtime log level allows it
+func Warnf(format string, args ...interface{}) {
+	if Config.LogEnabled(LogLevelWarn) {
+		log.Printf("[WARN] "+format, args...)
+	}
+}
@@ -0,0 +1,100 @@
+// In-process request metrics per route
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// metricsRetention is how long request samples are kept
+	metricsRetention = 15 * time.Minute
+	// maxRouteSamples caps the samples kept per route so a traffic spike can't exhaust memory
+	maxRouteSamples = 50000
+)
+
+type requestSample struct {
+	at       time.Time
+	status   int
+	duration time.Duration
+}
+
+// RouteStats summarizes a route's requests over a window
+type RouteStats struct {
+	Route       string        `json:"route"`
+	Region      string        `json:"region,omitempty"` // The replica's REGION
+	Requests    int           `json:"requests"`
+	Errors      int           `json:"errors"`      // 5xx responses
+	SuccessRate float64       `json:"successRate"` // Fraction of non-5xx responses
+	P95Latency  time.Duration `json:"p95Latency"`
+}
+
+// RequestMetrics keeps recent request samples per route
+type RequestMetrics struct {
+	mu      sync.Mutex
+	samples map[string][]requestSample // Route -> samples, oldest first
+}
+
+// Metrics is the process-wide request metrics registry
+var Metrics = &RequestMetrics{samples: make(map[string][]requestSample)}
+
+// Observe records a finished request
+func (m *RequestMetrics) Observe(route string, status int, duration time.Duration) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	samples := append(m.samples[route], requestSample{at: now, status: status, duration: duration})
+	// Drop expired samples (and the oldest ones beyond the cap)
+	cutoff := now.Add(-metricsRetention)
+	drop := sort.Search(len(samples), func(i int) bool { return samples[i].at.After(cutoff) })
+	drop = max(drop, len(samples)-maxRouteSamples)
+	if drop > 0 {
+		samples = append(samples[:0:0], samples[drop:]...)
+	}
+	m.samples[route] = samples
+}
+
+// Stats summarizes every route's requests over the given window (at most the retention period)
+func (m *RequestMetrics) Stats(window time.Duration) []RouteStats {
+	cutoff := time.Now().Add(-window)
+
+	m.mu.Lock()
+	var stats []RouteStats
+	var durations []time.Duration
+	for route, samples := range m.samples {
+		start := sort.Search(len(samples), func(i int) bool { return samples[i].at.After(cutoff) })
+		recent := samples[start:]
+		if len(recent) == 0 {
+			continue
+		}
+
+		stat := RouteStats{Route: route, Region: Region(), Requests: len(recent)}
+		durations = durations[:0]
+		for _, sample := range recent {
+			if sample.status >= 500 {
+				stat.Errors++
+			}
+			durations = append(durations, sample.duration)
+		}
+		stat.SuccessRate = float64(stat.Requests-stat.Errors) / float64(stat.Requests)
+		stat.P95Latency = percentile(durations, 0.95)
+		stats = append(stats, stat)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Route < stats[j].Route })
+	return stats
+}
+
+// percentile returns the p-th percentile (nearest rank) of durations, sorting them in place
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	rank := int(float64(len(durations))*p+0.999999) - 1
+	return durations[min(max(rank, 0), len(durations)-1)]
+}
@@ -0,0 +1,177 @@
+// Deterministic stand-in for the AI provider, for development and integration tests
+package services
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// AI providers the server can run on (AI_PROVIDER)
+const (
+	AIProviderGemini = "gemini"
+	AIProviderMock   = "mock"
+)
+
+// ErrMockUnsupported is returned by AI features the mock provider doesn't imitate
+var ErrMockUnsupported = errors.New("not supported by the mock AI provider")
+
+// mockAI reports whether AI requests are answered by the mock provider instead of Gemini
+var mockAI bool
+
+// mockRelevantLimit is how many notes the mock provider finds relevant at most, like the real prompt asks for
+const mockRelevantLimit = 3
+
+// SetAIProvider selects the provider AI requests run on: "gemini" (or "") or "mock". With the mock
+// provider every GeminiService answers cleanup, chat, and relevant note requests with canned results
+// derived only from their input, and computes embeddings by hashing words, without network calls or
+// API keys being checked; other AI features fail with ErrMockUnsupported.
+func SetAIProvider(name string) error {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", AIProviderGemini:
+		mockAI = false
+	case AIProviderMock:
+		mockAI = true
+	default:
+		return fmt.Errorf("unknown AI provider %q, expected %s or %s", name, AIProviderGemini, AIProviderMock)
+	}
+	return nil
+}
+
+// MockAIEnabled reports whether the mock provider is selected
+func MockAIEnabled() bool {
+	return mockAI
+}
+
+// newMockGeminiService creates a GeminiService answered by the mock provider
+func newMockGeminiService() *GeminiService {
+	return &GeminiService{mock: true}
+}
+
+// Helper functions
+
+// mockChatResponse answers a chat message by echoing it with the notes and turns it was given
+func mockChatResponse(prompt string, contextNotes []models.Note, history []models.ChatMessage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Mock answer to: %s\n\n", strings.TrimSpace(prompt))
+	if len(contextNotes) == 0 {
+		b.WriteString("No notes were provided.")
+	} else {
+		titles := make([]string, len(contextNotes))
+		for i, note := range contextNotes {
+			titles[i] = note.Title
+		}
+		fmt.Fprintf(&b, "Based on %d note(s): %s.", len(contextNotes), strings.Join(titles, ", "))
+	}
+	if len(history) > 0 {
+		fmt.Fprintf(&b, "\n\nEarlier messages in this conversation: %d.", min(len(history), maxChatHistoryMessages))
+	}
+	return b.String()
+}
+
+// mockRelevantNotes ranks notes by how many distinct words of the current content they share, most
+// first, then by ID, and returns the top mockRelevantLimit that share any
+func mockRelevantNotes(currentContent string, allNotes []models.Note) []models.Note {
+	words := map[string]bool{}
+	for _, word := range mockWords(currentContent) {
+		words[word] = true
+	}
+
+	type scoredNote struct {
+		note  models.Note
+		score int
+	}
+	var scored []scoredNote
+	for _, note := range allNotes {
+		seen := map[string]bool{}
+		for _, word := range mockWords(note.Title + " " + note.Content) {
+			if words[word] {
+				seen[word] = true
+			}
+		}
+		if len(seen) > 0 {
+			scored = append(scored, scoredNote{note: note, score: len(seen)})
+		}
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].note.ID < scored[j].note.ID
+	})
+
+	relevant := []models.Note{}
+	for i := 0; i < len(scored) && i < mockRelevantLimit; i++ {
+		relevant = append(relevant, scored[i].note)
+	}
+	return relevant
+}
+
+// mockCleanUp tidies a note without changing its words: trailing whitespace and runs of blank lines
+// are removed, and "*" and "+" bullets become "-"
+func mockCleanUp(content string) string {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	cleaned := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(trimmed, "* ") || strings.HasPrefix(trimmed, "+ ") {
+			line = line[:len(line)-len(trimmed)] + "- " + trimmed[2:]
+		}
+		if line == "" && (len(cleaned) == 0 || cleaned[len(cleaned)-1] == "") {
+			continue
+		}
+		cleaned = append(cleaned, line)
+	}
+	return strings.TrimSpace(strings.Join(cleaned, "\n"))
+}
+
+// mockEmbedding hashes the words of a title and text into a unit vector, so texts sharing words are
+// similar under cosine similarity
+func mockEmbedding(title, text string) []float32 {
+	vector := make([]float64, EmbeddingDimensions)
+	for _, word := range mockWords(title + " " + text) {
+		hash := fnv.New64a()
+		hash.Write([]byte(word))
+		sum := hash.Sum64()
+		sign := 1.0
+		if sum&(1<<63) != 0 {
+			sign = -1
+		}
+		vector[sum%EmbeddingDimensions] += sign
+	}
+
+	var norm float64
+	for _, value := range vector {
+		norm += value * value
+	}
+	embedding := make([]float32, EmbeddingDimensions)
+	if norm == 0 {
+		embedding[0] = 1 // Texts without words still get a valid unit vector
+		return embedding
+	}
+	norm = math.Sqrt(norm)
+	for i, value := range vector {
+		embedding[i] = float32(value / norm)
+	}
+	return embedding
+}
+
+// mockWords returns the lowercase words of at least three letters or digits in text
+func mockWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	words := fields[:0]
+	for _, field := range fields {
+		if len([]rune(field)) >= 3 {
+			words = append(words, field)
+		}
+	}
+	return words
+}
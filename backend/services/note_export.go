@@ -0,0 +1,399 @@
+// Rendering of note markdown into standalone HTML and PDF documents for export
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// Export formats
+const (
+	ExportFormatHTML = "html"
+	ExportFormatPDF  = "pdf"
+)
+
+// maxExportFilenameRunes caps the length of exported file and folder names
+const maxExportFilenameRunes = 100
+
+// exportMarkdown parses GitHub-flavored markdown. Raw HTML is left out of rendered documents and links
+// with dangerous schemes (javascript: and the like) are dropped, since the markdown is user content.
+var exportMarkdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// exportHTMLTemplate wraps rendered markdown in a self-contained, styled page. The content security
+// policy keeps scripts out and only loads images over https.
+var exportHTMLTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="Content-Security-Policy" content="default-src 'none'; img-src https: data:; style-src 'unsafe-inline'">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 720px; margin: 40px auto; padding: 0 20px; font: 16px/1.6 -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; color: #1f2328; }
+h1, h2, h3, h4, h5, h6 { line-height: 1.25; margin: 1.5em 0 0.5em; }
+h1.title { margin-top: 0; padding-bottom: 0.3em; border-bottom: 1px solid #d0d7de; }
+a { color: #0969da; }
+code, pre { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; font-size: 0.9em; background: #f6f8fa; border-radius: 6px; }
+code { padding: 0.2em 0.4em; }
+pre { padding: 16px; overflow: auto; }
+pre code { padding: 0; background: none; }
+blockquote { margin: 0; padding: 0 1em; color: #59636e; border-left: 4px solid #d0d7de; }
+table { border-collapse: collapse; }
+th, td { padding: 6px 13px; border: 1px solid #d0d7de; }
+img { max-width: 100%; }
+hr { border: 0; border-top: 1px solid #d0d7de; }
+</style>
+</head>
+<body>
+<h1 class="title">{{.Title}}</h1>
+{{.Body}}
+</body>
+</html>
+`))
+
+// RenderNoteHTML renders a note's markdown into a standalone HTML document titled title
+func RenderNoteHTML(title, markdown string) ([]byte, error) {
+	var body bytes.Buffer
+	if err := exportMarkdown.Convert([]byte(markdown), &body); err != nil {
+		return nil, fmt.Errorf("failed to render markdown: %w", err)
+	}
+
+	var doc bytes.Buffer
+	err := exportHTMLTemplate.Execute(&doc, struct {
+		Title string
+		Body  template.HTML
+	}{
+		Title: title,
+		Body:  template.HTML(body.String()), // goldmark escapes text and omits raw HTML
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render document: %w", err)
+	}
+	return doc.Bytes(), nil
+}
+
+// RenderNotePDF renders a note's markdown into an A4 PDF document titled title. It uses the standard PDF
+// fonts, which only cover Western European (Windows-1252) characters; others are shown as dots.
+func RenderNotePDF(title, markdown string) ([]byte, error) {
+	source := []byte(markdown)
+	doc := exportMarkdown.Parser().Parse(text.NewReader(source))
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("Jottin", false)
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.AddPage()
+
+	r := &pdfRenderer{pdf: pdf, source: source, tr: pdf.UnicodeTranslatorFromDescriptor("")}
+	r.title(title)
+	if err := ast.Walk(doc, r.walk); err != nil {
+		return nil, fmt.Errorf("failed to render markdown: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		return nil, fmt.Errorf("failed to write PDF: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// PDF layout, in millimeters and points
+const (
+	pdfMargin     = 20.0
+	pdfIndent     = 6.0
+	pdfBodySize   = 11.0
+	pdfLineHeight = 5.5
+	pdfBlockGap   = 3.0
+)
+
+// pdfHeadingSizes are the font sizes of heading levels 1-6
+var pdfHeadingSizes = [...]float64{20, 16, 14, 12, 11, 11}
+
+// pdfRenderer draws a goldmark document onto a PDF. Inline text flows with Write, so it wraps at the
+// right margin and returns to the left margin, which lists and block quotes move in.
+type pdfRenderer struct {
+	pdf    *fpdf.Fpdf
+	source []byte
+	tr     func(string) string // UTF-8 to the standard fonts' encoding
+
+	size       float64
+	bold       int // Nesting depth of strong emphasis and headings
+	italic     int
+	link       string
+	quoteDepth int
+	lists      []*pdfList
+}
+
+// pdfList tracks the numbering of an open list
+type pdfList struct {
+	ordered bool
+	next    int
+}
+
+// title draws the document title above the note's content
+func (r *pdfRenderer) title(title string) {
+	r.pdf.SetFont("Helvetica", "B", 22)
+	r.pdf.MultiCell(0, 10, r.tr(title), "", "L", false)
+	left, _, right, _ := r.pdf.GetMargins()
+	width, _ := r.pdf.GetPageSize()
+	r.pdf.SetDrawColor(208, 215, 222)
+	r.pdf.Line(left, r.pdf.GetY()+1, width-right, r.pdf.GetY()+1)
+	r.pdf.Ln(6)
+	r.size = pdfBodySize
+	r.setFont()
+}
+
+// walk renders each node as ast.Walk enters and leaves it
+func (r *pdfRenderer) walk(node ast.Node, entering bool) (ast.WalkStatus, error) {
+	switch n := node.(type) {
+	case *ast.Heading:
+		if entering {
+			r.size = pdfHeadingSizes[min(n.Level, len(pdfHeadingSizes))-1]
+			r.bold++
+			r.pdf.Ln(pdfBlockGap)
+		} else {
+			r.bold--
+			r.size = pdfBodySize
+			r.endBlock()
+		}
+	case *ast.Paragraph:
+		if !entering {
+			r.endBlock()
+		}
+	case *ast.TextBlock: // Paragraphs of tight list items
+		if !entering {
+			r.pdf.Ln(r.lineHeight())
+		}
+	case *ast.Text:
+		if entering {
+			r.write(string(n.Segment.Value(r.source)))
+			if n.HardLineBreak() {
+				r.pdf.Ln(r.lineHeight())
+			} else if n.SoftLineBreak() {
+				r.write(" ")
+			}
+		}
+	case *ast.String:
+		if entering {
+			r.write(string(n.Value))
+		}
+	case *ast.Emphasis:
+		depth := &r.italic
+		if n.Level >= 2 {
+			depth = &r.bold
+		}
+		if entering {
+			*depth++
+		} else {
+			*depth--
+		}
+	case *ast.CodeSpan:
+		if entering {
+			r.pdf.SetFont("Courier", "", r.size)
+			r.pdf.Write(r.lineHeight(), r.tr(r.inlineText(n)))
+			r.setFont()
+		}
+		return ast.WalkSkipChildren, nil
+	case *ast.Link:
+		if entering {
+			r.link = string(n.Destination)
+		} else {
+			r.link = ""
+		}
+	case *ast.AutoLink:
+		if entering {
+			r.link = string(n.URL(r.source))
+			r.write(string(n.Label(r.source)))
+			r.link = ""
+		}
+		return ast.WalkSkipChildren, nil
+	case *ast.Image:
+		if entering {
+			r.link = string(n.Destination)
+			r.write("[image: " + r.inlineText(n) + "]")
+			r.link = ""
+		}
+		return ast.WalkSkipChildren, nil
+	case *ast.List:
+		if entering {
+			r.lists = append(r.lists, &pdfList{ordered: n.IsOrdered(), next: n.Start})
+			r.indent(1)
+		} else {
+			r.lists = r.lists[:len(r.lists)-1]
+			r.indent(-1)
+			if len(r.lists) == 0 {
+				r.pdf.Ln(pdfBlockGap)
+			}
+		}
+	case *ast.ListItem:
+		if entering {
+			list := r.lists[len(r.lists)-1]
+			marker := "- "
+			if list.ordered {
+				marker = fmt.Sprintf("%d. ", list.next)
+				list.next++
+			}
+			r.pdf.Write(r.lineHeight(), marker)
+		}
+	case *extast.TaskCheckBox:
+		if entering {
+			if n.IsChecked {
+				r.write("[x] ")
+			} else {
+				r.write("[ ] ")
+			}
+		}
+	case *ast.Blockquote:
+		if entering {
+			r.quoteDepth++
+			r.indent(1)
+			r.pdf.SetTextColor(89, 99, 110)
+		} else {
+			r.quoteDepth--
+			r.indent(-1)
+			if r.quoteDepth == 0 {
+				r.pdf.SetTextColor(31, 35, 40)
+			}
+		}
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		if entering {
+			r.codeBlock(node)
+		}
+		return ast.WalkSkipChildren, nil
+	case *ast.ThematicBreak:
+		if entering {
+			left, _, right, _ := r.pdf.GetMargins()
+			width, _ := r.pdf.GetPageSize()
+			r.pdf.Line(left, r.pdf.GetY()+pdfBlockGap, width-right, r.pdf.GetY()+pdfBlockGap)
+			r.pdf.Ln(2 * pdfBlockGap)
+		}
+	case *extast.TableHeader:
+		if entering {
+			r.bold++
+		} else {
+			r.bold--
+			r.pdf.Ln(r.lineHeight())
+		}
+	case *extast.TableRow:
+		if !entering {
+			r.pdf.Ln(r.lineHeight())
+		}
+	case *extast.TableCell:
+		if entering && node.PreviousSibling() != nil {
+			r.write(" | ")
+		}
+	case *ast.HTMLBlock, *ast.RawHTML:
+		return ast.WalkSkipChildren, nil // Omitted, as in the HTML export
+	}
+	return ast.WalkContinue, nil
+}
+
+// write draws inline text in the current style, as a link if inside one with a safe destination
+func (r *pdfRenderer) write(s string) {
+	r.setFont()
+	if r.link != "" && !html.IsDangerousURL([]byte(r.link)) {
+		r.pdf.SetTextColor(9, 105, 218)
+		r.pdf.WriteLinkString(r.lineHeight(), r.tr(s), r.link)
+		if r.quoteDepth > 0 {
+			r.pdf.SetTextColor(89, 99, 110)
+		} else {
+			r.pdf.SetTextColor(31, 35, 40)
+		}
+		return
+	}
+	r.pdf.Write(r.lineHeight(), r.tr(s))
+}
+
+// inlineText returns the plain text of an inline node's descendants, such as an image's alt text
+func (r *pdfRenderer) inlineText(node ast.Node) string {
+	var b strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		switch c := child.(type) {
+		case *ast.Text:
+			b.Write(c.Segment.Value(r.source))
+		case *ast.String:
+			b.Write(c.Value)
+		default:
+			b.WriteString(r.inlineText(c))
+		}
+	}
+	return b.String()
+}
+
+// codeBlock draws a code block's lines in a shaded monospaced box
+func (r *pdfRenderer) codeBlock(node ast.Node) {
+	var code strings.Builder
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(r.source))
+	}
+	r.pdf.SetFont("Courier", "", pdfBodySize-1)
+	r.pdf.SetFillColor(246, 248, 250)
+	r.pdf.MultiCell(0, pdfLineHeight-0.5, r.tr(strings.TrimRight(code.String(), "\n")), "", "L", true)
+	r.pdf.Ln(pdfBlockGap)
+	r.setFont()
+}
+
+// endBlock finishes a paragraph or heading, leaving a gap unless it's inside a list item
+func (r *pdfRenderer) endBlock() {
+	r.setFont()
+	r.pdf.Ln(r.lineHeight())
+	if len(r.lists) == 0 {
+		r.pdf.Ln(pdfBlockGap)
+	}
+}
+
+// indent moves the left margin in (or back out) by one level and starts the next line there
+func (r *pdfRenderer) indent(levels int) {
+	left, _, _, _ := r.pdf.GetMargins()
+	r.pdf.SetLeftMargin(left + float64(levels)*pdfIndent)
+	r.pdf.SetX(left + float64(levels)*pdfIndent)
+}
+
+// setFont applies the current size and emphasis
+func (r *pdfRenderer) setFont() {
+	style := ""
+	if r.bold > 0 {
+		style += "B"
+	}
+	if r.italic > 0 {
+		style += "I"
+	}
+	r.pdf.SetFont("Helvetica", style, r.size)
+}
+
+// lineHeight is the line height for the current font size
+func (r *pdfRenderer) lineHeight() float64 {
+	return pdfLineHeight * r.size / pdfBodySize
+}
+
+// ExportFilename turns a note title or collection name into a file or folder name (without extension),
+// dropping characters file systems reject. Names left empty become fallback.
+func ExportFilename(name, fallback string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > maxExportFilenameRunes {
+		name = string(runes[:maxExportFilenameRunes])
+	}
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		return fallback
+	}
+	return name
+}
@@ -0,0 +1,248 @@
+// Metrics over the links between a user's notes
+package services
+
+import (
+	"backend/models"
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Notes graph limits
+const (
+	maxGraphOrphans  = 100
+	maxGraphHubs     = 10
+	minGraphHubLinks = 3 // A note needs this many distinct neighbors to count as a hub
+	maxGraphClusters = 20
+	// maxGraphSuggestions bounds the link suggestions overall and per unlinked note
+	maxGraphSuggestions        = 50
+	maxGraphSuggestionsPerNote = 3
+)
+
+// graphNode is a live note with its plaintext tags, as loaded for the graph
+type graphNode struct {
+	id    string
+	title string
+	tags  []string
+}
+
+// NoteGraph computes the user's notes graph metrics: notes without links, the most linked notes,
+// groups of linked notes, and links to suggest for unlinked notes from the tags they share
+func (d *Database) NoteGraph(ctx context.Context, userID string) (*models.NoteGraphMetrics, error) {
+	nodes, err := d.graphNodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	links, err := d.graphLinks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return noteGraphMetrics(nodes, links), nil
+}
+
+// graphNodes returns the user's live notes, most recently updated first
+func (d *Database) graphNodes(ctx context.Context, userID string) ([]graphNode, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT id, title, to_json(tags) FROM notes
+		WHERE user_id = $1 AND deleted_at IS NULL
+		ORDER BY updated_at DESC, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var nodes []graphNode
+	for rows.Next() {
+		var node graphNode
+		var tags []byte
+		if err := rows.Scan(&node.id, &node.title, &tags); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(tags, &node.tags); err != nil {
+			log.Printf("Error decoding tags of note %s: %v", node.id, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// graphLinks returns the links between the user's live notes as source and target IDs
+func (d *Database) graphLinks(ctx context.Context, userID string) ([][2]string, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT l.source_id, l.target_id
+		FROM note_links l
+		JOIN notes s ON s.id = l.source_id AND s.deleted_at IS NULL
+		JOIN notes t ON t.id = l.target_id AND t.user_id = l.user_id AND t.deleted_at IS NULL
+		WHERE l.user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var links [][2]string
+	for rows.Next() {
+		var link [2]string
+		if err := rows.Scan(&link[0], &link[1]); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// noteGraphMetrics computes the graph metrics of nodes (most recently updated first, which orders
+// every list where counts tie) and the links between them
+func noteGraphMetrics(nodes []graphNode, links [][2]string) *models.NoteGraphMetrics {
+	index := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		index[node.id] = i
+	}
+	neighbors := make([]map[int]bool, len(nodes))
+	backlinks := make([]int, len(nodes))
+	metrics := &models.NoteGraphMetrics{
+		NoteCount:   len(nodes),
+		Orphans:     []models.GraphNote{},
+		Hubs:        []models.GraphHub{},
+		Clusters:    []models.GraphCluster{},
+		Suggestions: []models.LinkSuggestion{},
+	}
+	for _, link := range links {
+		source, target := index[link[0]], index[link[1]]
+		if source == target {
+			continue
+		}
+		metrics.LinkCount++
+		backlinks[target]++
+		for _, pair := range [][2]int{{source, target}, {target, source}} {
+			if neighbors[pair[0]] == nil {
+				neighbors[pair[0]] = make(map[int]bool)
+			}
+			neighbors[pair[0]][pair[1]] = true
+		}
+	}
+
+	var orphans, hubs []int
+	for i := range nodes {
+		switch links := len(neighbors[i]); {
+		case links == 0:
+			orphans = append(orphans, i)
+		case links >= minGraphHubLinks:
+			hubs = append(hubs, i)
+		}
+	}
+
+	metrics.OrphanCount = len(orphans)
+	orphans = orphans[:min(len(orphans), maxGraphOrphans)]
+	for _, i := range orphans {
+		metrics.Orphans = append(metrics.Orphans, models.GraphNote{NoteID: nodes[i].id, Title: nodes[i].title})
+	}
+
+	sort.SliceStable(hubs, func(a, b int) bool {
+		if len(neighbors[hubs[a]]) != len(neighbors[hubs[b]]) {
+			return len(neighbors[hubs[a]]) > len(neighbors[hubs[b]])
+		}
+		return backlinks[hubs[a]] > backlinks[hubs[b]]
+	})
+	for _, i := range hubs[:min(len(hubs), maxGraphHubs)] {
+		metrics.Hubs = append(metrics.Hubs, models.GraphHub{
+			GraphNote: models.GraphNote{NoteID: nodes[i].id, Title: nodes[i].title},
+			Links:     len(neighbors[i]),
+			Backlinks: backlinks[i],
+		})
+	}
+
+	metrics.Clusters = graphClusters(nodes, neighbors)
+	metrics.Suggestions = linkSuggestions(nodes, orphans)
+	return metrics
+}
+
+// graphClusters returns the connected groups of linked notes, largest first
+func graphClusters(nodes []graphNode, neighbors []map[int]bool) []models.GraphCluster {
+	clusters := []models.GraphCluster{}
+	visited := make([]bool, len(nodes))
+	for start := range nodes {
+		if visited[start] || len(neighbors[start]) == 0 {
+			continue
+		}
+		visited[start] = true
+		members := []int{start}
+		for next := 0; next < len(members); next++ {
+			for neighbor := range neighbors[members[next]] {
+				if !visited[neighbor] {
+					visited[neighbor] = true
+					members = append(members, neighbor)
+				}
+			}
+		}
+
+		sort.Ints(members) // Most recently updated first
+		cluster := models.GraphCluster{Size: len(members), NoteIDs: make([]string, len(members))}
+		for i, member := range members {
+			cluster.NoteIDs[i] = nodes[member].id
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	sort.SliceStable(clusters, func(a, b int) bool { return clusters[a].Size > clusters[b].Size })
+	return clusters[:min(len(clusters), maxGraphClusters)]
+}
+
+// linkSuggestions suggests links for unlinked notes to the notes sharing the most of their tags
+// (compared case-insensitively), the most recently updated first among equals
+func linkSuggestions(nodes []graphNode, orphans []int) []models.LinkSuggestion {
+	tagged := make(map[string][]int)
+	for i, node := range nodes {
+		for _, tag := range node.tags {
+			key := strings.ToLower(tag)
+			tagged[key] = append(tagged[key], i)
+		}
+	}
+
+	suggestions := []models.LinkSuggestion{}
+	for _, orphan := range orphans {
+		shared := make(map[int][]string)
+		var candidates []int
+		for _, tag := range nodes[orphan].tags {
+			for _, candidate := range tagged[strings.ToLower(tag)] {
+				if candidate == orphan {
+					continue
+				}
+				if shared[candidate] == nil {
+					candidates = append(candidates, candidate)
+				}
+				shared[candidate] = append(shared[candidate], tag)
+			}
+		}
+
+		sort.Slice(candidates, func(a, b int) bool {
+			if len(shared[candidates[a]]) != len(shared[candidates[b]]) {
+				return len(shared[candidates[a]]) > len(shared[candidates[b]])
+			}
+			return candidates[a] < candidates[b]
+		})
+		for _, candidate := range candidates[:min(len(candidates), maxGraphSuggestionsPerNote)] {
+			if len(suggestions) == maxGraphSuggestions {
+				return suggestions
+			}
+			suggestions = append(suggestions, models.LinkSuggestion{
+				NoteID:     nodes[orphan].id,
+				TargetID:   nodes[candidate].id,
+				Title:      nodes[candidate].title,
+				SharedTags: shared[candidate],
+			})
+		}
+	}
+	return suggestions
+}
@@ -0,0 +1,150 @@
+// Advisory per-note locks guarding destructive operations
+package services
+
+import (
+	"backend/models"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// NoteLockTTL bounds how long a crashed holder can keep a note locked
+const NoteLockTTL = 2 * time.Minute
+
+// NoteLockedError is returned when a note is already locked by another operation
+type NoteLockedError struct {
+	Locks []models.NoteLock
+}
+
+func (e *NoteLockedError) Error() string {
+	return fmt.Sprintf("%d note(s) locked by another operation", len(e.Locks))
+}
+
+// NoteLockHandle releases locks taken by AcquireNoteLocks
+type NoteLockHandle struct {
+	db      *Database
+	token   string
+	noteIDs []string
+}
+
+// AcquireNoteLocks locks the given notes for a destructive operation. Either all notes are
+// locked or none are, in which case a NoteLockedError lists the current holders.
+func (d *Database) AcquireNoteLocks(ctx context.Context, userID string, noteIDs []string, lock models.NoteLock) (handle *NoteLockHandle, err error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back note lock: %v", rbErr)
+			}
+		}
+	}()
+
+	// Take over expired locks; a live lock leaves the row untouched and returns nothing
+	query := `
+		INSERT INTO note_locks (note_id, user_id, lock_token, operation, holder_user_id, device_id, acquired_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + $7 * INTERVAL '1 second')
+		ON CONFLICT (note_id) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			lock_token = EXCLUDED.lock_token,
+			operation = EXCLUDED.operation,
+			holder_user_id = EXCLUDED.holder_user_id,
+			device_id = EXCLUDED.device_id,
+			acquired_at = EXCLUDED.acquired_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE note_locks.expires_at < CURRENT_TIMESTAMP
+		RETURNING note_id
+	`
+	var contended []string
+	for _, noteID := range noteIDs {
+		var locked string
+		err = tx.QueryRowContext(ctx, query, noteID, userID, token, lock.Operation, lock.HolderUserID,
+			nullableString(lock.DeviceID), int(NoteLockTTL.Seconds())).Scan(&locked)
+		if errors.Is(err, sql.ErrNoRows) {
+			contended = append(contended, noteID)
+			err = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(contended) > 0 {
+		locks, lookupErr := d.ActiveNoteLocks(ctx, userID, contended)
+		if lookupErr != nil {
+			err = lookupErr
+			return nil, err
+		}
+		err = &NoteLockedError{Locks: locks}
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &NoteLockHandle{db: d, token: token, noteIDs: noteIDs}, nil
+}
+
+// Release frees the locks. It uses a fresh context so locks are released even if the request was cancelled.
+func (h *NoteLockHandle) Release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.db.DB.ExecContext(ctx, `DELETE FROM note_locks WHERE lock_token = $1`, h.token); err != nil {
+		log.Printf("Error releasing locks on %d note(s): %v", len(h.noteIDs), err)
+	}
+}
+
+// ActiveNoteLocks returns unexpired locks held on any of the given notes
+func (d *Database) ActiveNoteLocks(ctx context.Context, userID string, noteIDs []string) ([]models.NoteLock, error) {
+	return activeNoteLocks(ctx, d.DB, userID, noteIDs)
+}
+
+func activeNoteLocks(ctx context.Context, q Queryer, userID string, noteIDs []string) ([]models.NoteLock, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT note_id, operation, holder_user_id, COALESCE(device_id, ''), acquired_at, expires_at
+		FROM note_locks
+		WHERE user_id = $1 AND note_id = ANY($2) AND expires_at >= CURRENT_TIMESTAMP
+		ORDER BY note_id
+	`, userID, noteIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	locks := []models.NoteLock{}
+	for rows.Next() {
+		var lock models.NoteLock
+		if err := rows.Scan(&lock.NoteID, &lock.Operation, &lock.HolderUserID, &lock.DeviceID, &lock.AcquiredAt, &lock.ExpiresAt); err != nil {
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, rows.Err()
+}
+
+// nullableString maps an empty string to NULL
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,463 @@
+// Note reads, timeline events, and revisions shared by the sync and notes API handlers
+package services
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Revision retention
+const (
+	// noteRevisionInterval is the minimum time between a note's revisions, so an editing session
+	// (autosaving every few seconds) keeps the version from before it rather than one per save
+	noteRevisionInterval = 10 * time.Minute
+	maxNoteRevisions     = 50 // Per note; older revisions are dropped
+)
+
+// NoteFieldMask selects the JSON fields of the notes returned by a sync pull; nil selects all of them
+type NoteFieldMask map[string]bool
+
+// Has reports whether the mask selects a field
+func (m NoteFieldMask) Has(field string) bool {
+	return m == nil || m[field]
+}
+
+// Apply reduces notes to the masked fields. Fields without a value (e.g. an unset reminder) stay omitted.
+func (m NoteFieldMask) Apply(notes []models.SyncNote) ([]map[string]json.RawMessage, error) {
+	partial := make([]map[string]json.RawMessage, 0, len(notes))
+	for _, note := range notes {
+		encoded, err := json.Marshal(note)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &fields); err != nil {
+			return nil, err
+		}
+		for field := range fields {
+			if !m[field] {
+				delete(fields, field)
+			}
+		}
+		partial = append(partial, fields)
+	}
+	return partial, nil
+}
+
+// notSnoozed matches notes n that aren't snoozed, including ones whose snooze passed but that the
+// NoteSnoozer hasn't brought back yet
+const notSnoozed = "(n.snoozed_until IS NULL OR n.snoozed_until <= CURRENT_TIMESTAMP)"
+
+// NoteFilter narrows the notes returned by ListNotes; zero fields don't filter
+type NoteFilter struct {
+	CollectionID    string
+	Tag             string   // Matched case-insensitively
+	SourceURLHashes []string // Notes captured on any of these pages
+	Pinned          *bool
+	Archived        *bool
+	Snoozed         *bool // Unset hides snoozed notes, like false; true lists only them
+}
+
+// SyncNotes returns the user's notes changed since a time (all live notes if since is nil), reading only
+// what the field mask selects. With page set, it returns one page (plus one extra note to detect more).
+// Without since, snoozed notes are left out unless includeSnoozed is set; changes since a time always
+// include them, so devices learn that a note they have was snoozed.
+func (d *Database) SyncNotes(ctx context.Context, userID string, since *time.Time, includeSnoozed bool, mask NoteFieldMask, page *pagination.Params) ([]models.SyncNote, error) {
+	conditions := "n.user_id = $1 AND n.deleted_at IS NULL"
+	args := []interface{}{userID}
+	if since != nil {
+		conditions = "n.user_id = $1 AND n.updated_at >= $2 AND (n.deleted_at IS NULL OR n.deleted_at >= $2)"
+		args = append(args, *since)
+	} else if !includeSnoozed {
+		conditions += " AND " + notSnoozed
+	}
+
+	order, limit := "n.updated_at DESC", ""
+	if page != nil {
+		// Oldest change first: a note changed mid-pull moves past the cursor and arrives on a later page
+		order = "n.updated_at, n.id"
+		if page.Cursor != nil {
+			conditions += fmt.Sprintf(" AND (n.updated_at, n.id) > ($%d, $%d)", len(args)+1, len(args)+2)
+			args = append(args, page.Cursor.SortValue, page.Cursor.ID)
+		}
+		limit = fmt.Sprintf("LIMIT $%d", len(args)+1)
+		args = append(args, page.Limit+1)
+	}
+
+	query := `
+		SELECT ` + noteColumns(mask) + `
+		FROM notes n
+		WHERE ` + conditions + `
+		ORDER BY ` + order + `
+		` + limit
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return d.scanNotes(ctx, rows, mask)
+}
+
+// NotesByIDs returns the given notes (including soft-deleted ones) owned by the user
+func (d *Database) NotesByIDs(ctx context.Context, userID string, noteIDs []string) ([]models.SyncNote, error) {
+	query := `
+		SELECT ` + noteColumns(nil) + `
+		FROM notes n
+		WHERE n.user_id = $1 AND n.id = ANY($2)
+		ORDER BY n.updated_at DESC
+	`
+	rows, err := d.DB.QueryContext(ctx, query, userID, noteIDs)
+	if err != nil {
+		return nil, err
+	}
+	return d.scanNotes(ctx, rows, nil)
+}
+
+// ListNotes returns one page (plus one extra note to detect more) of the user's live notes matching the
+// filter, most recently updated first
+func (d *Database) ListNotes(ctx context.Context, userID string, filter NoteFilter, page pagination.Params) ([]models.SyncNote, error) {
+	conditions := []string{"n.user_id = $1", "n.deleted_at IS NULL"}
+	args := []interface{}{userID}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.CollectionID != "" {
+		addCondition("EXISTS (SELECT 1 FROM note_collections nc WHERE nc.note_id = n.id AND nc.collection_id = $%d)", filter.CollectionID)
+	}
+	if filter.Tag != "" {
+		addCondition("EXISTS (SELECT 1 FROM unnest(n.tags) t WHERE lower(t) = lower($%d))", filter.Tag)
+	}
+	if len(filter.SourceURLHashes) > 0 {
+		addCondition("n.source_url_hash = ANY($%d)", filter.SourceURLHashes)
+	}
+	if filter.Pinned != nil {
+		addCondition("n.is_pinned = $%d", *filter.Pinned)
+	}
+	if filter.Archived != nil {
+		addCondition("n.is_archived = $%d", *filter.Archived)
+	}
+	addCondition("COALESCE(n.snoozed_until > CURRENT_TIMESTAMP, FALSE) = $%d", filter.Snoozed != nil && *filter.Snoozed)
+	if page.Cursor != nil {
+		args = append(args, page.Cursor.SortValue, page.Cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(n.updated_at, n.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	args = append(args, page.Limit+1)
+
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT `+noteColumns(nil)+`
+		FROM notes n
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY n.updated_at DESC, n.id DESC
+		LIMIT `+fmt.Sprintf("$%d", len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	return d.scanNotes(ctx, rows, nil)
+}
+
+// DeletedNotes returns one page (plus one extra note to detect more) of the user's soft-deleted notes,
+// most recently deleted first
+func (d *Database) DeletedNotes(ctx context.Context, userID string, page pagination.Params) ([]models.SyncNote, error) {
+	var cursorTime *time.Time
+	var cursorID string
+	if page.Cursor != nil {
+		cursorTime, cursorID = &page.Cursor.SortValue, page.Cursor.ID
+	}
+
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT `+noteColumns(nil)+`
+		FROM notes n
+		WHERE n.user_id = $1 AND n.deleted_at IS NOT NULL
+		  AND ($3::timestamptz IS NULL OR (n.deleted_at, n.id) < ($3, $4))
+		ORDER BY n.deleted_at DESC, n.id DESC
+		LIMIT $2
+	`, userID, page.Limit+1, cursorTime, cursorID)
+	if err != nil {
+		return nil, err
+	}
+	return d.scanNotes(ctx, rows, nil)
+}
+
+// NoteDigestEntries returns the ID and version of every active note plus each note's collection IDs
+func (d *Database) NoteDigestEntries(ctx context.Context, userID string) ([]NoteDigestEntry, map[string][]string, error) {
+	query := `
+		SELECT n.id, n.updated_at, nc.collection_id
+		FROM notes n
+		LEFT JOIN note_collections nc ON nc.note_id = n.id
+		WHERE n.user_id = $1 AND n.deleted_at IS NULL
+		ORDER BY n.id
+	`
+	rows, err := d.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var entries []NoteDigestEntry
+	memberships := make(map[string][]string)
+	for rows.Next() {
+		var entry NoteDigestEntry
+		var collectionID sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.UpdatedAt, &collectionID); err != nil {
+			return nil, nil, err
+		}
+		// Rows are ordered by note ID, so a note with several collections appears consecutively
+		if len(entries) == 0 || entries[len(entries)-1].ID != entry.ID {
+			entries = append(entries, entry)
+		}
+		if collectionID.Valid {
+			memberships[entry.ID] = append(memberships[entry.ID], collectionID.String)
+		}
+	}
+	return entries, memberships, rows.Err()
+}
+
+// AIExcludedNoteIDs returns which of the given notes the user has excluded from AI features
+func (d *Database) AIExcludedNoteIDs(ctx context.Context, userID string, noteIDs []string) (map[string]bool, error) {
+	ids, err := queryStrings(ctx, d.DB, `
+		SELECT id FROM notes WHERE user_id = $1 AND id = ANY($2) AND ai_excluded
+	`, userID, noteIDs)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		excluded[id] = true
+	}
+	return excluded, nil
+}
+
+// NoteCountAfterPush returns how many live notes the user has, and how many they'd have once the push is
+// applied (pushed notes that aren't live are added, deleted ones that are live are removed)
+func (d *Database) NoteCountAfterPush(ctx context.Context, userID string, req *models.SyncRequest) (current, after int, err error) {
+	upserts, deletes := map[string]bool{}, map[string]bool{}
+	for i := range req.Notes {
+		if req.Notes[i].DeletedAt != nil {
+			deletes[req.Notes[i].ID] = true
+		} else {
+			upserts[req.Notes[i].ID] = true
+		}
+	}
+	upsertIDs, deleteIDs := make([]string, 0, len(upserts)), make([]string, 0, len(deletes))
+	for id := range upserts {
+		upsertIDs = append(upsertIDs, id)
+	}
+	for id := range deletes {
+		deleteIDs = append(deleteIDs, id)
+	}
+
+	var liveUpserted, liveDeleted int
+	err = d.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE id = ANY($2)),
+		       COUNT(*) FILTER (WHERE id = ANY($3))
+		FROM notes
+		WHERE user_id = $1 AND deleted_at IS NULL
+	`, userID, upsertIDs, deleteIDs).Scan(&current, &liveUpserted, &liveDeleted)
+	if err != nil {
+		return 0, 0, err
+	}
+	return current, current + len(upsertIDs) - liveUpserted - liveDeleted, nil
+}
+
+// NewNoteID returns a random UUID (v4), the ID format clients use for notes
+func NewNoteID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// RecordNoteEvent appends an entry to a note's timeline. deviceID and relatedNoteID are optional.
+func RecordNoteEvent(ctx context.Context, e Execer, userID, noteID, eventType, deviceID, relatedNoteID string) error {
+	_, err := e.ExecContext(ctx, `
+		INSERT INTO note_events (note_id, user_id, type, device_id, related_note_id)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''))
+	`, noteID, userID, eventType, deviceID, relatedNoteID)
+	return err
+}
+
+// RecordNoteEvents appends an entry to the timeline of each note, of the matching type in eventTypes.
+// deviceID is optional.
+func RecordNoteEvents(ctx context.Context, e Execer, userID, deviceID string, noteIDs, eventTypes []string) error {
+	_, err := e.ExecContext(ctx, `
+		INSERT INTO note_events (note_id, user_id, type, device_id)
+		SELECT e.note_id, $1, e.type, NULLIF($2, '')
+		FROM unnest($3::text[], $4::text[]) AS e(note_id, type)
+	`, userID, deviceID, noteIDs, eventTypes)
+	return err
+}
+
+// SaveNoteRevisions keeps the stored versions of notes (each with a distinct ID) as revisions before they're
+// replaced by the matching titles and contents, in one statement. Nothing is kept for new notes or unchanged
+// content, nor (unless force is set) if a note's last revision was taken within noteRevisionInterval.
+// Revisions beyond maxNoteRevisions are dropped.
+func SaveNoteRevisions(ctx context.Context, e Execer, userID string, noteIDs, titles []string, contents [][]byte, force bool) error {
+	// The DELETE doesn't see the revisions being inserted, so one fewer of the older ones is kept
+	_, err := e.ExecContext(ctx, `
+		WITH saved AS (
+			INSERT INTO note_revisions (note_id, user_id, title, content_encrypted, content_iv, device_id, saved_at)
+			SELECT n.id, n.user_id, n.title, n.content_encrypted, n.content_iv, n.device_id, n.updated_at
+			FROM notes n
+			JOIN unnest($2::text[], $3::text[], $4::bytea[]) AS p(id, title, content) ON p.id = n.id
+			WHERE n.user_id = $1
+			  AND (n.content_encrypted <> p.content OR n.title <> p.title)
+			  AND ($5 OR NOT EXISTS (
+				SELECT 1 FROM note_revisions r WHERE r.note_id = n.id AND r.created_at > $6
+			  ))
+			RETURNING note_id
+		)
+		DELETE FROM note_revisions r
+		USING (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY note_id ORDER BY id DESC) AS position
+			FROM note_revisions
+			WHERE note_id IN (SELECT note_id FROM saved)
+		) older
+		WHERE r.id = older.id AND older.position >= $7
+	`, userID, noteIDs, titles, contents, force, time.Now().Add(-noteRevisionInterval), maxNoteRevisions)
+	return err
+}
+
+// noteColumns returns the columns read by scanNotes. Encrypted bodies the mask leaves out are replaced
+// by empty values so they're never read.
+func noteColumns(mask NoteFieldMask) string {
+	content, iv := "n.content_encrypted", "n.content_iv"
+	if !mask.Has("contentEncrypted") {
+		content = "''::bytea"
+	}
+	if !mask.Has("contentIV") {
+		iv = "''::bytea"
+	}
+	source := "n.source_encrypted"
+	if !mask.Has("sourceEncrypted") {
+		source = "NULL::bytea"
+	}
+	return `n.id, n.user_id, n.title, ` + content + `, ` + iv + `,
+		n.domain, n.language, n.date, n.is_pinned, n.is_archived, to_json(n.tags), n.reminder_at,
+		n.conflict_of, n.created_at, n.updated_at, n.deleted_at, ` + source + `, n.source_iv, n.source_url_hash,
+		n.ai_excluded, n.snoozed_until`
+}
+
+// scanNotes reads note rows (in the column order of noteColumns) and closes them
+func (d *Database) scanNotes(ctx context.Context, rows *sql.Rows, mask NoteFieldMask) ([]models.SyncNote, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var notes []models.SyncNote
+	for rows.Next() {
+		var note models.SyncNote
+		var domain, language, conflictOf, sourceURLHash sql.NullString
+		var isArchived, aiExcluded bool
+		var tags []byte
+		var reminderAt, deletedAt, snoozedUntil sql.NullTime
+		var contentEncryptedBytes []byte
+		var contentIVBytes []byte
+		var sourceEncryptedBytes, sourceIVBytes []byte
+
+		err := rows.Scan(
+			&note.ID, &note.UserID, &note.Title, &contentEncryptedBytes, &contentIVBytes,
+			&domain, &language, &note.Date, &note.IsPinned, &isArchived, &tags, &reminderAt,
+			&conflictOf, &note.CreatedAt, &note.UpdatedAt, &deletedAt,
+			&sourceEncryptedBytes, &sourceIVBytes, &sourceURLHash, &aiExcluded, &snoozedUntil,
+		)
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(tags, &note.Tags); err != nil {
+			log.Printf("Error decoding tags of note %s: %v", note.ID, err)
+			note.Tags = []string{}
+		}
+		note.IsArchived = &isArchived
+		note.AIExcluded = &aiExcluded
+		if reminderAt.Valid {
+			note.ReminderAt = &reminderAt.Time
+		}
+		if snoozedUntil.Valid {
+			note.SnoozedUntil = &snoozedUntil.Time
+		}
+
+		// Convert bytes to base64 strings for JSON response
+		note.ContentEncrypted = base64.StdEncoding.EncodeToString(contentEncryptedBytes)
+		note.ContentIV = base64.StdEncoding.EncodeToString(contentIVBytes)
+
+		if domain.Valid {
+			note.Domain = &domain.String
+		}
+		if language.Valid {
+			note.Language = &language.String
+		}
+		if conflictOf.Valid {
+			note.ConflictOf = &conflictOf.String
+		}
+		if sourceEncryptedBytes != nil {
+			source := base64.StdEncoding.EncodeToString(sourceEncryptedBytes)
+			note.SourceEncrypted = &source
+		}
+		if sourceIVBytes != nil {
+			sourceIV := base64.StdEncoding.EncodeToString(sourceIVBytes)
+			note.SourceIV = &sourceIV
+		}
+		if sourceURLHash.Valid {
+			note.SourceURLHash = &sourceURLHash.String
+		}
+		if deletedAt.Valid {
+			note.DeletedAt = &deletedAt.Time
+		}
+
+		// Fetch collection IDs for this note (a query per note, so skipped when not asked for)
+		if mask.Has("collectionIds") {
+			collectionIDs, err := d.noteCollectionIDs(ctx, note.ID)
+			if err != nil {
+				log.Printf("Error fetching collections for note %s: %v", note.ID, err)
+				collectionIDs = []string{} // Use empty slice on error
+			}
+			note.CollectionIDs = collectionIDs
+		}
+
+		notes = append(notes, note)
+	}
+
+	return notes, nil
+}
+
+func (d *Database) noteCollectionIDs(ctx context.Context, noteID string) ([]string, error) {
+	query := `SELECT collection_id FROM note_collections WHERE note_id = $1`
+	rows, err := d.DB.QueryContext(ctx, query, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var collectionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			collectionIDs = append(collectionIDs, id)
+		}
+	}
+	return collectionIDs, nil
+}
@@ -0,0 +1,175 @@
+// Background resurfacing of snoozed notes once their snooze ends
+package services
+
+import (
+	"backend/models"
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// snoozeCheckInterval is how often ended snoozes are looked for
+	snoozeCheckInterval = time.Minute
+	// snoozeBatchSize is how many notes one run brings back per statement
+	snoozeBatchSize = 500
+	// snoozeTimeout bounds a single batch
+	snoozeTimeout = 30 * time.Second
+)
+
+// NoteSnoozer brings snoozed notes back once their snooze passes: it clears the snooze and bumps the
+// note's updated_at, so devices pull it again, records a snooze_ended timeline event, and for notes
+// snoozed with snoozeNotify, notifies the user's connected devices
+type NoteSnoozer struct {
+	db  *Database
+	hub *RealtimeHub
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// resurfacedNote is a note a run brought back
+type resurfacedNote struct {
+	id     string
+	userID string
+	notify bool
+}
+
+// NewNoteSnoozer creates a NoteSnoozer and starts checking for ended snoozes
+func NewNoteSnoozer(db *Database, hub *RealtimeHub) *NoteSnoozer {
+	s := &NoteSnoozer{db: db, hub: hub, done: make(chan struct{})}
+	s.wg.Add(1)
+	go s.loop()
+	return s
+}
+
+// Close stops checking
+func (s *NoteSnoozer) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *NoteSnoozer) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(snoozeCheckInterval)
+	defer ticker.Stop()
+
+	s.resurface()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.resurface()
+		}
+	}
+}
+
+// resurface brings back notes whose snooze ended, in batches until none are left. Notes locked by an
+// operation in progress are left for the next run.
+func (s *NoteSnoozer) resurface() {
+	var total int
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), snoozeTimeout)
+		notes, err := s.resurfaceBatch(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Error resurfacing snoozed notes: %v", err)
+			return
+		}
+
+		changed := make(map[string][]string)
+		for _, note := range notes {
+			changed[note.userID] = append(changed[note.userID], note.id)
+			if note.notify {
+				s.hub.NotifySnoozeEnded(note.userID, note.id)
+			}
+		}
+		for userID, noteIDs := range changed {
+			s.hub.PublishChanges(userID, noteIDs, nil)
+		}
+
+		total += len(notes)
+		if len(notes) < snoozeBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("Resurfaced %d snoozed note(s)", total)
+	}
+}
+
+// resurfaceBatch brings back up to snoozeBatchSize notes in one transaction. Replicas running at the
+// same time skip each other's rows, so every note is brought back once.
+func (s *NoteSnoozer) resurfaceBatch(ctx context.Context) (notes []resurfacedNote, err error) {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back snooze resurfacing: %v", rbErr)
+			}
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		WITH due AS (
+			SELECT n.id, n.snooze_notify FROM notes n
+			WHERE n.snoozed_until <= CURRENT_TIMESTAMP AND n.deleted_at IS NULL
+			  AND NOT EXISTS (SELECT 1 FROM note_locks l WHERE l.note_id = n.id AND l.expires_at >= CURRENT_TIMESTAMP)
+			ORDER BY n.snoozed_until
+			LIMIT $1
+			FOR UPDATE OF n SKIP LOCKED
+		)
+		UPDATE notes n SET snoozed_until = NULL, snooze_notify = FALSE, updated_at = CURRENT_TIMESTAMP
+		FROM due
+		WHERE n.id = due.id
+		RETURNING n.id, n.user_id, due.snooze_notify
+	`, snoozeBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	if notes, err = scanResurfacedNotes(rows); err != nil {
+		return nil, err
+	}
+
+	for _, note := range notes {
+		if err = RecordNoteEvent(ctx, tx, note.userID, note.id, models.NoteEventSnoozeEnded, "", ""); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// scanResurfacedNotes reads the rows returned by resurfaceBatch and closes them
+func scanResurfacedNotes(rows *sql.Rows) ([]resurfacedNote, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var notes []resurfacedNote
+	for rows.Next() {
+		var note resurfacedNote
+		if err := rows.Scan(&note.id, &note.userID, &note.notify); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
@@ -0,0 +1,72 @@
+// Validation and lookup of user AI provider API keys
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// providerKeyValidationTimeout bounds the provider call made to validate a key
+const providerKeyValidationTimeout = 10 * time.Second
+
+// Errors returned by ValidateProviderKey
+var (
+	ErrUnsupportedProvider = errors.New("unsupported provider")
+	ErrProviderKeyRejected = errors.New("provider rejected the API key")
+)
+
+// ValidateProviderKey checks a key against the provider with a call that uses no tokens. Returns
+// ErrProviderKeyRejected if the provider refuses the key, or another error if it couldn't be checked.
+func ValidateProviderKey(ctx context.Context, provider, apiKey string) error {
+	if provider != geminiProvider {
+		return ErrUnsupportedProvider
+	}
+
+	geminiService, err := NewGeminiService(apiKey)
+	if err != nil {
+		return err
+	}
+	defer geminiService.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, providerKeyValidationTimeout)
+	defer cancel()
+
+	err = geminiService.Ping(ctx)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+			return ErrProviderKeyRejected
+		}
+	}
+	return err
+}
+
+// StoredProviderKey opens the user's stored key for a provider. Returns ErrNoStoredProviderKey if none is
+// stored or key storage isn't configured (nil sealer).
+func StoredProviderKey(ctx context.Context, db *Database, sealer *Sealer, userID, provider string) (string, error) {
+	if sealer == nil {
+		return "", ErrNoStoredProviderKey
+	}
+
+	var sealed []byte
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT sealed_key FROM provider_keys WHERE user_id = $1 AND provider = $2
+	`, userID, provider).Scan(&sealed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNoStoredProviderKey
+	}
+	if err != nil {
+		return "", err
+	}
+	apiKey, err := sealer.Open(userID, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(apiKey), nil
+}
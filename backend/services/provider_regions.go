@@ -0,0 +1,98 @@
+// AI provider data-residency regions users can route their requests through
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// ErrProviderRegionUnavailable is returned when a user's chosen AI region has no configured endpoint.
+// Requests fail rather than fall back to the default endpoint, which may be outside the region.
+var ErrProviderRegionUnavailable = errors.New("AI provider region is not available")
+
+// providerRegionEndpoints maps the AI regions users can choose to the provider endpoint serving each
+var providerRegionEndpoints = map[string]string{}
+
+// SetProviderRegions configures the AI regions from a comma-separated list of region=endpoint pairs,
+// e.g. "eu=europe-generativelanguage.example.com:443"
+func SetProviderRegions(spec string) error {
+	endpoints := map[string]string{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, endpoint, ok := strings.Cut(pair, "=")
+		name, endpoint = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(endpoint)
+		if !ok || name == "" || endpoint == "" {
+			return fmt.Errorf("invalid AI region %q, expected region=endpoint", pair)
+		}
+		endpoints[name] = endpoint
+	}
+	providerRegionEndpoints = endpoints
+	return nil
+}
+
+// ProviderRegions returns the names of the configured AI regions, sorted
+func ProviderRegions() []string {
+	names := make([]string, 0, len(providerRegionEndpoints))
+	for name := range providerRegionEndpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsProviderRegion reports whether name is a configured AI region
+func IsProviderRegion(name string) bool {
+	_, ok := providerRegionEndpoints[name]
+	return ok
+}
+
+// NewGeminiServiceInRegion creates a GeminiService whose requests go to the region's endpoint, or to
+// the provider's default endpoint for region "". The mock provider has no regions.
+func NewGeminiServiceInRegion(apiKey, region string) (*GeminiService, error) {
+	if region == "" || mockAI {
+		return NewGeminiService(apiKey)
+	}
+	endpoint, ok := providerRegionEndpoints[region]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProviderRegionUnavailable, region)
+	}
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey(apiKey), option.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	return &GeminiService{client: client}, nil
+}
+
+// NewUserGeminiService creates a GeminiService for the user's requests, in the AI region chosen in
+// their settings
+func NewUserGeminiService(ctx context.Context, users AIUserRepository, userID, apiKey string) (*GeminiService, error) {
+	region, err := users.ProviderRegion(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AI region: %w", err)
+	}
+	return NewGeminiServiceInRegion(apiKey, region)
+}
+
+// ProviderRegion returns the AI region the user chose, or "" for the provider's default endpoint
+func (d *Database) ProviderRegion(ctx context.Context, userID string) (string, error) {
+	var region sql.NullString
+	err := d.DB.QueryRowContext(ctx, `SELECT ai_region FROM users WHERE id = $1`, userID).Scan(&region)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return region.String, nil
+}
@@ -0,0 +1,72 @@
+// Replay protection and rate limiting for public tokenized endpoints (capture inbox, share links)
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PublicTokenMaxTTL bounds how far in the future a public request's expiry may be
+const PublicTokenMaxTTL = 10 * time.Minute
+
+// publicTokenRateGroup is the RATE_LIMITS group applied per public token
+const publicTokenRateGroup = "public_token"
+
+// defaultPublicTokenRateLimit applies when RATE_LIMITS has no public_token entry
+var defaultPublicTokenRateLimit = RateLimit{Requests: 30, Window: time.Minute}
+
+// Errors returned by PublicTokenGuard
+var (
+	ErrTokenExpired  = errors.New("request expired")
+	ErrTokenReplayed = errors.New("request nonce already used")
+)
+
+// PublicTokenGuard rejects expired and replayed requests and rate limits each token
+type PublicTokenGuard struct {
+	db      *Database
+	limiter *RateLimiter
+}
+
+// NewPublicTokenGuard creates a new PublicTokenGuard
+func NewPublicTokenGuard(db *Database) *PublicTokenGuard {
+	return &PublicTokenGuard{db: db, limiter: NewRateLimiter(publicTokenRateGroup, defaultPublicTokenRateLimit)}
+}
+
+// Allow counts a request against the token's rate limit, returning how long to wait if it's exceeded
+func (g *PublicTokenGuard) Allow(tokenID string) (retryAfter time.Duration, ok bool) {
+	return g.limiter.Allow(tokenID)
+}
+
+// CheckExpiry validates a request's expiry: it must not have passed and may be at most PublicTokenMaxTTL away
+func (g *PublicTokenGuard) CheckExpiry(expires time.Time) error {
+	now := time.Now()
+	if !expires.After(now) || expires.After(now.Add(PublicTokenMaxTTL)) {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// ConsumeNonce records a request's nonce, returning ErrTokenReplayed if it was already used.
+// Nonces are kept until the request's expiry, after which CheckExpiry rejects the request anyway.
+func (g *PublicTokenGuard) ConsumeNonce(ctx context.Context, tokenID, nonce string, expires time.Time) error {
+	if _, err := g.db.DB.ExecContext(ctx, `DELETE FROM token_nonces WHERE token_id = $1 AND expires_at < CURRENT_TIMESTAMP`, tokenID); err != nil {
+		return err
+	}
+
+	result, err := g.db.DB.ExecContext(ctx, `
+		INSERT INTO token_nonces (token_id, nonce, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (token_id, nonce) DO NOTHING
+	`, tokenID, nonce, expires)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTokenReplayed
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Short-lived per-user cache of sync pull responses
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// maxPullCacheEntries caps the cached responses across all users so the cache can't exhaust memory
+const maxPullCacheEntries = 10000
+
+type pullCacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+// userPullCache holds a user's cached responses. Its generation changes whenever they're invalidated,
+// so a pull that read the database before a change can't store its stale response afterwards.
+type userPullCache struct {
+	generation uint64
+	entries    map[string]pullCacheEntry // Normalized query -> response body
+}
+
+// PullCache is a read-through cache of serialized sync pull responses, for replicas far from the
+// database. Changes made through this replica drop the user's entries immediately; changes made
+// through another replica can be served stale for at most the TTL.
+type PullCache struct {
+	ttl time.Duration
+
+	mu             sync.Mutex
+	users          map[string]*userPullCache
+	size           int
+	nextGeneration uint64
+}
+
+// NewPullCache creates a cache keeping responses for ttl, or returns nil (caching disabled) if ttl isn't
+// positive. A nil *PullCache misses every lookup.
+func NewPullCache(ttl time.Duration) *PullCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &PullCache{ttl: ttl, users: make(map[string]*userPullCache)}
+}
+
+// Generation returns the user's current cache generation, to be passed to Set once the response is built
+func (c *PullCache) Generation(userID string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userLocked(userID).generation
+}
+
+// Get returns the cached response for the user's query, if it hasn't expired
+func (c *PullCache) Get(userID, key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	user, ok := c.users[userID]
+	if !ok {
+		return nil, false
+	}
+	entry, ok := user.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+// Set caches a response built while the user's cache was at generation. It's dropped if the user's data
+// changed in the meantime, or if the cache is full of unexpired entries.
+func (c *PullCache) Set(userID, key string, generation uint64, body []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	user := c.userLocked(userID)
+	if user.generation != generation {
+		return
+	}
+	if _, replacing := user.entries[key]; !replacing {
+		if c.size >= maxPullCacheEntries {
+			c.pruneLocked()
+		}
+		if c.size >= maxPullCacheEntries {
+			return
+		}
+		c.size++
+	}
+	user.entries[key] = pullCacheEntry{body: body, expires: time.Now().Add(c.ttl)}
+}
+
+// Invalidate drops the user's cached responses
+func (c *PullCache) Invalidate(userID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	user, ok := c.users[userID]
+	if !ok {
+		return // Nothing cached, and no pull in flight has taken a generation
+	}
+	c.size -= len(user.entries)
+	c.nextGeneration++
+	user.generation = c.nextGeneration
+	user.entries = make(map[string]pullCacheEntry)
+}
+
+// userLocked returns the user's cache, creating it with a fresh generation
+func (c *PullCache) userLocked(userID string) *userPullCache {
+	user, ok := c.users[userID]
+	if !ok {
+		c.nextGeneration++
+		user = &userPullCache{generation: c.nextGeneration, entries: make(map[string]pullCacheEntry)}
+		c.users[userID] = user
+	}
+	return user
+}
+
+// pruneLocked drops expired entries and users without entries. A pull in flight for a dropped user
+// can't store its response: the user is recreated with a new generation.
+func (c *PullCache) pruneLocked() {
+	now := time.Now()
+	for userID, user := range c.users {
+		for key, entry := range user.entries {
+			if now.After(entry.expires) {
+				delete(user.entries, key)
+				c.size--
+			}
+		}
+		if len(user.entries) == 0 {
+			delete(c.users, userID)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Sliding-window rate limiting keyed by user, token, or other caller identity
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRateLimitKeys is the number of keys tracked before idle ones are dropped, so a limiter keyed by
+// caller IP or API key can't grow without bound
+const maxRateLimitKeys = 10000
+
+// RateLimiter enforces a RATE_LIMITS group per key over a sliding window
+type RateLimiter struct {
+	group    string
+	fallback RateLimit // Applies when RATE_LIMITS has no entry for the group
+
+	mu   sync.Mutex
+	hits map[string][]time.Time // Key -> request times within the window
+}
+
+// RateLimitStatus is a key's standing against its limit after a request was counted (or rejected)
+type RateLimitStatus struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration // Until the oldest counted request leaves the window, freeing one up
+}
+
+// NewRateLimiter creates a RateLimiter for a RATE_LIMITS group
+func NewRateLimiter(group string, fallback RateLimit) *RateLimiter {
+	return &RateLimiter{group: group, fallback: fallback, hits: make(map[string][]time.Time)}
+}
+
+// Allow counts a request against the key's limit, returning how long to wait if it's exceeded
+func (l *RateLimiter) Allow(key string) (retryAfter time.Duration, ok bool) {
+	status := l.Take(key)
+	if !status.Allowed {
+		return status.Reset, false
+	}
+	return 0, true
+}
+
+// Take counts a request against the key's limit like Allow, and reports the key's resulting standing
+func (l *RateLimiter) Take(key string) RateLimitStatus {
+	limit, configured := Config.RateLimitFor(l.group)
+	if !configured {
+		limit = l.fallback
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-limit.Window)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hits, tracked := l.hits[key]
+	if !tracked && len(l.hits) >= maxRateLimitKeys {
+		l.pruneLocked(cutoff)
+	}
+	kept := hits[:0]
+	for _, at := range hits {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	status := RateLimitStatus{Limit: limit.Requests}
+	if len(kept) < limit.Requests {
+		kept = append(kept, now)
+		status.Allowed = true
+		status.Remaining = limit.Requests - len(kept)
+	}
+	l.hits[key] = kept
+	if len(kept) > 0 {
+		status.Reset = kept[0].Add(limit.Window).Sub(now)
+	}
+	return status
+}
+
+// pruneLocked drops keys without requests since cutoff
+func (l *RateLimiter) pruneLocked(cutoff time.Time) {
+	for key, hits := range l.hits {
+		if len(hits) == 0 || !hits[len(hits)-1].After(cutoff) {
+			delete(l.hits, key)
+		}
+	}
+}
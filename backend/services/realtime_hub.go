@@ -0,0 +1,375 @@
+// Realtime hub tracking WebSocket clients and note presence
+package services
+
+import (
+	"backend/models"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PresenceTTL is how long a presence entry lives without being refreshed by the client
+const PresenceTTL = 30 * time.Second
+
+// realtimeSendBuffer is the number of outgoing messages queued per client, including one slot
+// reserved for a resync marker
+const realtimeSendBuffer = 64
+
+// RealtimeStallTimeout is how long a client's queue may stay full before the client is disconnected
+const RealtimeStallTimeout = 30 * time.Second
+
+// maxChangeBatchIDs caps the note and collection IDs listed in one change notification
+const maxChangeBatchIDs = 100
+
+// RealtimeClient represents a single WebSocket connection
+type RealtimeClient struct {
+	ID       string
+	UserID   string
+	DeviceID string
+	Send     chan []byte // Outgoing messages, closed when the client is unregistered
+
+	fullSince time.Time   // When the queue filled up; zero while it has room (hub lock)
+	dropped   int         // Messages dropped since the queue filled up (hub lock)
+	stalled   atomic.Bool // Set once the queue has been full for RealtimeStallTimeout
+}
+
+// Stalled reports whether the client fell too far behind and must be disconnected. Its queued
+// messages are stale by then, so writers should close the connection instead of draining them.
+func (c *RealtimeClient) Stalled() bool {
+	return c.stalled.Load()
+}
+
+type presenceEntry struct {
+	client    *RealtimeClient
+	state     string
+	expiresAt time.Time
+}
+
+// changeBatch collects a user's changes until the debounce window closes
+type changeBatch struct {
+	noteIDs       map[string]bool
+	collectionIDs map[string]bool
+	since         time.Time
+	timer         *time.Timer
+}
+
+// RealtimeHub fans out realtime messages to connected clients
+type RealtimeHub struct {
+	mu       sync.Mutex
+	clients  map[*RealtimeClient]bool
+	presence map[string]map[*RealtimeClient]*presenceEntry // Note ID -> present clients
+	changes  map[string]*changeBatch                       // User ID -> pending change notification
+	done     chan struct{}
+
+	listeners []func(userID string) // Called on every PublishChanges, before batching
+}
+
+// NewRealtimeHub creates a new RealtimeHub and starts its presence expiry loop
+func NewRealtimeHub() *RealtimeHub {
+	h := &RealtimeHub{
+		clients:  make(map[*RealtimeClient]bool),
+		presence: make(map[string]map[*RealtimeClient]*presenceEntry),
+		changes:  make(map[string]*changeBatch),
+		done:     make(chan struct{}),
+	}
+	go h.expireLoop()
+	return h
+}
+
+// Close stops the hub's background work, dropping pending change notifications
+func (h *RealtimeHub) Close() {
+	close(h.done)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for userID, batch := range h.changes {
+		batch.timer.Stop()
+		delete(h.changes, userID)
+	}
+}
+
+// Register adds a new client connection to the hub
+func (h *RealtimeHub) Register(userID, deviceID string) *RealtimeClient {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		log.Printf("Error generating realtime client ID: %v", err)
+	}
+
+	client := &RealtimeClient{
+		ID:       hex.EncodeToString(idBytes),
+		UserID:   userID,
+		DeviceID: deviceID,
+		Send:     make(chan []byte, realtimeSendBuffer),
+	}
+
+	h.mu.Lock()
+	h.clients[client] = true
+	h.mu.Unlock()
+	return client
+}
+
+// Unregister removes a client, clears its presence, and closes its send channel
+func (h *RealtimeHub) Unregister(client *RealtimeClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.clients[client] {
+		return
+	}
+	delete(h.clients, client)
+
+	for noteID, entries := range h.presence {
+		if _, ok := entries[client]; ok {
+			delete(entries, client)
+			h.broadcastPresenceLocked(noteID)
+		}
+	}
+	close(client.Send)
+}
+
+// Send queues a message for a registered client (see sendLocked)
+func (h *RealtimeHub) Send(client *RealtimeClient, payload []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.clients[client] {
+		h.sendLocked(client, payload)
+	}
+}
+
+// SetPresence records the client's presence on a note and broadcasts the updated presence
+// to everyone present on that note. Callers must check the client may access the note.
+func (h *RealtimeHub) SetPresence(client *RealtimeClient, noteID, state string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.clients[client] {
+		return
+	}
+
+	entries := h.presence[noteID]
+	if state == models.PresenceLeft {
+		if _, ok := entries[client]; !ok {
+			return
+		}
+		delete(entries, client)
+	} else {
+		if entries == nil {
+			entries = make(map[*RealtimeClient]*presenceEntry)
+			h.presence[noteID] = entries
+		}
+		entries[client] = &presenceEntry{client: client, state: state, expiresAt: time.Now().Add(PresenceTTL)}
+	}
+
+	h.broadcastPresenceLocked(noteID)
+}
+
+// OnChange registers fn to be called (synchronously) whenever a user's notes or collections change.
+// Register listeners before the hub is in use.
+func (h *RealtimeHub) OnChange(fn func(userID string)) {
+	h.listeners = append(h.listeners, fn)
+}
+
+// PublishChanges notifies the user's connected clients that notes or collections changed.
+// Changes are coalesced: the notification goes out once no new changes arrive for the debounce
+// window, or after the max delay while a burst continues (see Config.RealtimeBatching).
+func (h *RealtimeHub) PublishChanges(userID string, noteIDs, collectionIDs []string) {
+	if len(noteIDs) == 0 && len(collectionIDs) == 0 {
+		return
+	}
+	for _, listener := range h.listeners {
+		listener(userID)
+	}
+	debounce, maxDelay := Config.RealtimeBatching()
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	batch := h.changes[userID]
+	if batch == nil {
+		batch = &changeBatch{
+			noteIDs:       make(map[string]bool),
+			collectionIDs: make(map[string]bool),
+			since:         now,
+		}
+		batch.timer = time.AfterFunc(debounce, func() { h.flushChanges(userID, batch) })
+		h.changes[userID] = batch
+	} else {
+		// Push the flush back, but never past the batch's max delay
+		delay := debounce
+		if deadline := batch.since.Add(maxDelay); now.Add(delay).After(deadline) {
+			delay = max(deadline.Sub(now), 0)
+		}
+		batch.timer.Reset(delay)
+	}
+
+	for _, id := range noteIDs {
+		batch.noteIDs[id] = true
+	}
+	for _, id := range collectionIDs {
+		batch.collectionIDs[id] = true
+	}
+}
+
+// flushChanges sends a pending change batch to every client of the user
+func (h *RealtimeHub) flushChanges(userID string, batch *changeBatch) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// A timer reset after firing may run again for a batch that was already sent
+	if h.changes[userID] != batch {
+		return
+	}
+	delete(h.changes, userID)
+
+	changes := &models.ChangeBatch{
+		NoteCount:       len(batch.noteIDs),
+		NoteIDs:         cappedKeys(batch.noteIDs, maxChangeBatchIDs),
+		CollectionCount: len(batch.collectionIDs),
+		CollectionIDs:   cappedKeys(batch.collectionIDs, maxChangeBatchIDs),
+		Since:           batch.since,
+	}
+	changes.Truncated = len(changes.NoteIDs) < changes.NoteCount || len(changes.CollectionIDs) < changes.CollectionCount
+
+	payload, err := json.Marshal(models.RealtimeMessage{Type: models.RealtimeChanges, Changes: changes})
+	if err != nil {
+		log.Printf("Error marshaling change batch: %v", err)
+		return
+	}
+	for client := range h.clients {
+		if client.UserID == userID {
+			h.sendLocked(client, payload)
+		}
+	}
+}
+
+// NotifySnoozeEnded tells the user's connected clients that a snoozed note came back. Unlike change
+// notifications it isn't batched, so each note can be announced on its own.
+func (h *RealtimeHub) NotifySnoozeEnded(userID, noteID string) {
+	payload, err := json.Marshal(models.RealtimeMessage{Type: models.RealtimeSnoozeEnded, NoteID: noteID})
+	if err != nil {
+		log.Printf("Error marshaling snooze notification: %v", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		if client.UserID == userID {
+			h.sendLocked(client, payload)
+		}
+	}
+}
+
+// cappedKeys returns up to limit keys of a set
+func cappedKeys(set map[string]bool, limit int) []string {
+	keys := make([]string, 0, min(len(set), limit))
+	for key := range set {
+		if len(keys) == limit {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// expireLoop periodically drops presence entries that weren't refreshed within PresenceTTL
+func (h *RealtimeHub) expireLoop() {
+	ticker := time.NewTicker(PresenceTTL / 6)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case now := <-ticker.C:
+			h.mu.Lock()
+			for noteID, entries := range h.presence {
+				expired := false
+				for client, entry := range entries {
+					if now.After(entry.expiresAt) {
+						delete(entries, client)
+						expired = true
+					}
+				}
+				if expired {
+					h.broadcastPresenceLocked(noteID)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// broadcastPresenceLocked sends the note's presence snapshot to every client present on it,
+// including a client that just left (caller must hold the lock)
+func (h *RealtimeHub) broadcastPresenceLocked(noteID string) {
+	entries := h.presence[noteID]
+
+	snapshot := make([]models.PresenceEntry, 0, len(entries))
+	for _, entry := range entries {
+		snapshot = append(snapshot, models.PresenceEntry{
+			UserID:    entry.client.UserID,
+			DeviceID:  entry.client.DeviceID,
+			State:     entry.state,
+			ExpiresAt: entry.expiresAt,
+		})
+	}
+	if len(entries) == 0 {
+		delete(h.presence, noteID)
+	}
+
+	payload, err := json.Marshal(models.RealtimeMessage{Type: models.RealtimePresence, NoteID: noteID, Presence: snapshot})
+	if err != nil {
+		log.Printf("Error marshaling presence: %v", err)
+		return
+	}
+	for client := range entries {
+		h.sendLocked(client, payload)
+	}
+}
+
+// sendLocked queues a message for a client without blocking, so a slow client can't hold up the hub
+// or grow its queue without bound. Messages to a full queue are dropped and replaced by a single
+// resync marker telling the client to re-pull; a client whose queue stays full for
+// RealtimeStallTimeout is marked stalled for its connection to be closed.
+func (h *RealtimeHub) sendLocked(client *RealtimeClient, payload []byte) {
+	if client.Stalled() {
+		return
+	}
+
+	// Only the hub sends, under its lock, so the queue can't fill up between the check and the send
+	if len(client.Send) < cap(client.Send)-1 {
+		client.Send <- payload
+		client.fullSince = time.Time{}
+		client.dropped = 0
+		return
+	}
+
+	now := time.Now()
+	if client.fullSince.IsZero() {
+		client.fullSince = now
+	}
+	client.dropped++
+
+	if now.Sub(client.fullSince) >= RealtimeStallTimeout {
+		log.Printf("Disconnecting stalled realtime client %s (%d messages dropped)", client.ID, client.dropped)
+		client.stalled.Store(true)
+		return
+	}
+
+	// Use the reserved slot for the marker unless one is already waiting in it
+	if len(client.Send) == cap(client.Send)-1 {
+		marker, err := json.Marshal(models.RealtimeMessage{Type: models.RealtimeResync, Dropped: client.dropped})
+		if err != nil {
+			log.Printf("Error marshaling resync marker: %v", err)
+			return
+		}
+		client.Send <- marker
+	}
+}
@@ -0,0 +1,128 @@
+// Redaction of secrets before they reach the logs
+package services
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redactedPlaceholder replaces masked values
+const redactedPlaceholder = "[REDACTED]"
+
+// redactionRule replaces matches of pattern with replacement (which may keep a prefix via ${1})
+type redactionRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// builtinRedactionRules are the rules LOG_REDACT_RULES can select by name, applied in redactionRuleOrder
+var builtinRedactionRules = map[string]redactionRule{
+	// JWTs (Clerk session tokens)
+	"jwt": {regexp.MustCompile(`eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]*`), redactedPlaceholder},
+	// Google API keys
+	"google_api_key": {regexp.MustCompile(`AIza[0-9A-Za-z_-]{35}`), redactedPlaceholder},
+	// Bearer tokens (at least 8 token characters, so prose like "bearer of" is left alone)
+	"bearer": {regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]{8,}`), "${1}" + redactedPlaceholder},
+	// Secrets in query strings, e.g. the WebSocket ?token= or a provider's ?key=
+	"query_secret": {regexp.MustCompile(`(?i)([?&](?:key|api_?key|token|access_token|claimToken|sig|password)=)[^&\s"']+`), "${1}" + redactedPlaceholder},
+	// Passwords in JSON (the whole string value, which may contain spaces)
+	"password": {regexp.MustCompile(`(?i)("(?:password|new_?password|current_?password|passphrase)"\s*:\s*)"(?:[^"\\]|\\.)*"`), "${1}\"" + redactedPlaceholder + "\""},
+	// Sensitive headers and fields, e.g. "X-API-Key: ..." or "apiKey":"..."
+	"sensitive_field": {regexp.MustCompile(`(?i)((?:x-api-key|authorization|x-signature|api_?key|secret)"?\s*[:=]\s*"?)[^\s"',}]+`), "${1}" + redactedPlaceholder},
+	// Email addresses
+	"email": {regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`), redactedPlaceholder},
+	// Note titles in JSON (the whole string value, which may contain spaces)
+	"note_title": {regexp.MustCompile(`(?i)("title"\s*:\s*)"(?:[^"\\]|\\.)*"`), "${1}\"" + redactedPlaceholder + "\""},
+}
+
+// redactionRuleOrder applies whole-token rules before the prefix-keeping ones, so a JWT after
+// "Authorization: Bearer" is masked entirely
+var redactionRuleOrder = []string{"jwt", "google_api_key", "bearer", "query_secret", "password", "sensitive_field", "email", "note_title"}
+
+// redactionRules are the rules Redact applies, all built-in rules unless configured otherwise
+var redactionRules = defaultRedactionRules()
+
+// SetRedactionPatterns configures what Redact masks. rules is a comma-separated list of built-in rule
+// names (see RedactionRuleNames), or "" for all of them. patterns is a whitespace-separated list of
+// extra regular expressions whose matches are masked entirely (use \s to match a space).
+func SetRedactionPatterns(rules, patterns string) error {
+	selected := defaultRedactionRules()
+	if strings.TrimSpace(rules) != "" {
+		names := map[string]bool{}
+		for _, name := range strings.Split(rules, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, ok := builtinRedactionRules[name]; !ok {
+				return fmt.Errorf("unknown redaction rule %q, expected one of %s", name, strings.Join(RedactionRuleNames(), ", "))
+			}
+			names[name] = true
+		}
+		selected = selected[:0]
+		for _, name := range redactionRuleOrder {
+			if names[name] {
+				selected = append(selected, builtinRedactionRules[name])
+			}
+		}
+	}
+
+	for _, pattern := range strings.Fields(patterns) {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		selected = append(selected, redactionRule{pattern: compiled, replacement: redactedPlaceholder})
+	}
+	redactionRules = selected
+	return nil
+}
+
+// RedactionRuleNames returns the names of the built-in redaction rules, sorted
+func RedactionRuleNames() []string {
+	names := make([]string, 0, len(builtinRedactionRules))
+	for name := range builtinRedactionRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Redact masks API keys, tokens, passwords, emails, note titles, and other sensitive values in s
+func Redact(s string) string {
+	for _, rule := range redactionRules {
+		s = rule.pattern.ReplaceAllString(s, rule.replacement)
+	}
+	return s
+}
+
+// defaultRedactionRules returns every built-in rule
+func defaultRedactionRules() []redactionRule {
+	rules := make([]redactionRule, 0, len(redactionRuleOrder))
+	for _, name := range redactionRuleOrder {
+		rules = append(rules, builtinRedactionRules[name])
+	}
+	return rules
+}
+
+// redactingWriter redacts each log line before writing it
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := w.out.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil // Report the original length so the logger doesn't treat masking as a short write
+}
+
+// RedactLogs routes the standard logger through the redaction layer, so secrets embedded
+// in errors (e.g. provider request URLs) are masked no matter where they're logged
+func RedactLogs(out io.Writer) {
+	log.SetOutput(redactingWriter{out: out})
+}
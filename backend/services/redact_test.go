@@ -0,0 +1,80 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "bearer token", in: "Authorization: Bearer abc123def456", want: "Authorization: [REDACTED] [REDACTED]"},
+		{name: "bearer token in prose", in: "request failed with bearer sk_live_0123456789", want: "request failed with bearer [REDACTED]"},
+		{name: "session JWT", in: "token eyJhbGciOi.eyJzdWIiOi.c2lnbmF0dXJl expired", want: "token [REDACTED] expired"},
+		{name: "X-API-Key header", in: "X-API-Key: AIzaSyD-not-a-real-key", want: "X-API-Key: [REDACTED]"},
+		{name: "apiKey JSON field", in: `{"apiKey":"sk-123"}`, want: `{"apiKey":"[REDACTED]"}`},
+		{name: "Google API key", in: "key AIza" + strings.Repeat("x", 35) + " rejected", want: "key [REDACTED] rejected"},
+		{name: "JSON password", in: `{"email_verified":true,"password":"correct horse battery"}`, want: `{"email_verified":true,"password":"[REDACTED]"}`},
+		{name: "JSON new password with escapes", in: `{"newPassword": "a \"quoted\" pass"}`, want: `{"newPassword": "[REDACTED]"}`},
+		{name: "email", in: "invite sent to jane.doe+notes@example.co.uk", want: "invite sent to [REDACTED]"},
+		{name: "query token", in: "GET /api/realtime?token=abc.def&device=laptop", want: "GET /api/realtime?token=[REDACTED]&device=laptop"},
+		{name: "query key", in: "https://api.example.com/v1/models?alt=json&key=secret123", want: "https://api.example.com/v1/models?alt=json&key=[REDACTED]"},
+		{name: "query password", in: "/share/abc?password=hunter2", want: "/share/abc?password=[REDACTED]"},
+		{name: "note title", in: `{"id":"n1","title":"Tax return 2026"}`, want: `{"id":"n1","title":"[REDACTED]"}`},
+
+		// Values that look similar but aren't secrets are left alone
+		{name: "bearer in prose", in: "the bearer of bad news", want: "the bearer of bad news"},
+		{name: "authorization without value", in: "Authorization failed for user", want: "Authorization failed for user"},
+		{name: "query parameter starting with key", in: "/api/notes?keyboard=qwerty&sort=asc", want: "/api/notes?keyboard=qwerty&sort=asc"},
+		{name: "password hint field", in: `{"passwordHint":"pet name"}`, want: `{"passwordHint":"pet name"}`},
+		{name: "mention without domain", in: "ping @alice about notes", want: "ping @alice about notes"},
+		{name: "titles list key", in: `{"titles":3}`, want: `{"titles":3}`},
+		{name: "plain log line", in: "GET /api/sync/notes 200 12ms", want: "GET /api/sync/notes 200 12ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.in); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetRedactionPatterns(t *testing.T) {
+	t.Cleanup(func() { redactionRules = defaultRedactionRules() })
+
+	tests := []struct {
+		name     string
+		rules    string
+		patterns string
+		in       string
+		want     string
+		wantErr  bool
+	}{
+		{name: "all rules by default", in: "mail bob@example.com", want: "mail [REDACTED]"},
+		{name: "selected rules only", rules: "bearer, query_secret", in: "bob@example.com?token=x", want: "bob@example.com?token=[REDACTED]"},
+		{name: "extra pattern", patterns: `acct_[0-9]{6} ssn\s\d+`, in: "acct_123456 ssn 987", want: "[REDACTED] [REDACTED]"},
+		{name: "unknown rule", rules: "jwt,credit_card", wantErr: true},
+		{name: "invalid pattern", patterns: "(unclosed", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SetRedactionPatterns(tt.rules, tt.patterns)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("SetRedactionPatterns accepted an invalid config")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Redact(tt.in); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+// Redis-backed store for the AI response cache, shared by replicas
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisDialTimeout bounds connecting (and authenticating) to Redis
+const redisDialTimeout = 2 * time.Second
+
+// redisKeyPrefix namespaces the AI cache's keys in a shared Redis database
+const redisKeyPrefix = "jottin:ai-cache:"
+
+// RedisAICacheStore keeps cached AI responses in Redis, so replicas share them and they survive
+// restarts. It speaks just enough of the Redis protocol for GET and SET over a single connection,
+// which is reopened after any error.
+type RedisAICacheStore struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisAICacheStore creates a store for a redis:// or rediss:// (TLS) URL, e.g.
+// redis://:password@localhost:6379/0. It connects on first use.
+func NewRedisAICacheStore(rawURL string) (*RedisAICacheStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // url.Error repeats the whole URL, password included
+		}
+		return nil, fmt.Errorf("not a valid URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("must start with redis:// or rediss://, not %s://", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("no host")
+	}
+
+	s := &RedisAICacheStore{addr: u.Host}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if s.db, err = strconv.Atoi(path); err != nil || s.db < 0 {
+			return nil, fmt.Errorf("database %q is not a number", path)
+		}
+	}
+	if u.Scheme == "rediss" {
+		s.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+	return s, nil
+}
+
+// Get returns the response cached under key
+func (s *RedisAICacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.do(ctx, "GET", redisKeyPrefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, value != nil, nil
+}
+
+// Set caches a response under key for ttl
+func (s *RedisAICacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.do(ctx, "SET", redisKeyPrefix+key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Close closes the connection
+func (s *RedisAICacheStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+}
+
+// do runs a command and returns its reply: a bulk string's value, nil for a missing value, or a simple
+// string or integer as text
+func (s *RedisAICacheStore) do(ctx context.Context, args ...string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connectLocked(ctx); err != nil {
+			return nil, err
+		}
+	}
+	deadline, _ := ctx.Deadline() // No deadline clears the one left by connecting
+	if err := s.conn.SetDeadline(deadline); err != nil {
+		s.closeLocked()
+		return nil, err
+	}
+	reply, err := s.roundTripLocked(args...)
+	if err != nil {
+		var redisErr redisError
+		if !errors.As(err, &redisErr) {
+			s.closeLocked() // The connection's state is unknown after a network error
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+// connectLocked dials Redis, authenticates, and selects the database
+func (s *RedisAICacheStore) connectLocked(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: redisDialTimeout}
+	var conn net.Conn
+	var err error
+	if s.tls != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tls}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	s.conn, s.reader = conn, bufio.NewReader(conn)
+	if err := s.conn.SetDeadline(time.Now().Add(redisDialTimeout)); err != nil {
+		s.closeLocked()
+		return err
+	}
+
+	var setup [][]string
+	switch {
+	case s.username != "" && s.password != "":
+		setup = append(setup, []string{"AUTH", s.username, s.password})
+	case s.password != "":
+		setup = append(setup, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	for _, command := range setup {
+		if _, err := s.roundTripLocked(command...); err != nil {
+			s.closeLocked()
+			return fmt.Errorf("failed to set up Redis connection (%s): %w", command[0], err)
+		}
+	}
+	return nil
+}
+
+// closeLocked closes the connection, if open
+func (s *RedisAICacheStore) closeLocked() {
+	if s.conn == nil {
+		return
+	}
+	if err := s.conn.Close(); err != nil {
+		log.Printf("Error closing Redis connection: %v", err)
+	}
+	s.conn, s.reader = nil, nil
+}
+
+// roundTripLocked writes a command as an array of bulk strings and reads its reply
+func (s *RedisAICacheStore) roundTripLocked(args ...string) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty Redis reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis bulk length %q", line[1:])
+		}
+		if size < 0 {
+			return nil, nil // Missing value
+		}
+		value := make([]byte, size+2) // With its trailing CRLF
+		if _, err := io.ReadFull(s.reader, value); err != nil {
+			return nil, err
+		}
+		return value[:size], nil
+	default:
+		return nil, fmt.Errorf("unexpected Redis reply %q", line)
+	}
+}
+
+// redisError is an error reply from Redis, after which the connection is still usable
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
@@ -0,0 +1,25 @@
+// Deployment region of this replica
+package services
+
+import (
+	"log"
+	"strings"
+)
+
+// region is the REGION this replica was deployed to; empty for single-region deployments
+var region string
+
+// SetRegion records the region this replica runs in and prefixes every log line with it, so logs
+// shipped from several regions can be told apart
+func SetRegion(name string) {
+	region = strings.TrimSpace(name)
+	if region != "" {
+		log.SetPrefix("[" + region + "] ")
+		log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	}
+}
+
+// Region returns the region this replica runs in, or "" if none is configured
+func Region() string {
+	return region
+}
@@ -0,0 +1,100 @@
+// Repository interfaces over the notes, collections, users, and share links the handlers read and write
+package services
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// NoteRepository reads and writes a user's notes
+type NoteRepository interface {
+	// SyncNotes returns the notes changed since a time (all live notes if since is nil), with only the
+	// fields the mask selects. With page set, it returns one page plus one extra note to detect more.
+	SyncNotes(ctx context.Context, userID string, since *time.Time, includeSnoozed bool, mask NoteFieldMask, page *pagination.Params) ([]models.SyncNote, error)
+	// NotesByIDs returns the given notes, including soft-deleted ones
+	NotesByIDs(ctx context.Context, userID string, noteIDs []string) ([]models.SyncNote, error)
+	// ListNotes returns one page (plus one extra note) of live notes matching the filter, most recently
+	// updated first
+	ListNotes(ctx context.Context, userID string, filter NoteFilter, page pagination.Params) ([]models.SyncNote, error)
+	// DeletedNotes returns one page (plus one extra note) of soft-deleted notes, most recently deleted first
+	DeletedNotes(ctx context.Context, userID string, page pagination.Params) ([]models.SyncNote, error)
+	// NoteDigestEntries returns the ID and version of every live note plus each note's collection IDs
+	NoteDigestEntries(ctx context.Context, userID string) ([]NoteDigestEntry, map[string][]string, error)
+	// NoteCountAfterPush returns how many live notes the user has, and how many they'd have after the push
+	NoteCountAfterPush(ctx context.Context, userID string, req *models.SyncRequest) (current, after int, err error)
+	// ApplyPush applies a validated push atomically (see Database.ApplyPush)
+	ApplyPush(ctx context.Context, userID, deviceID, policy string, req *models.SyncRequest) (SyncPush, error)
+	// ActiveNoteLocks returns the unexpired locks held on any of the notes
+	ActiveNoteLocks(ctx context.Context, userID string, noteIDs []string) ([]models.NoteLock, error)
+}
+
+// CollectionRepository reads a user's collections
+type CollectionRepository interface {
+	// SyncCollections returns the collections changed since a time (all live ones if since is nil)
+	SyncCollections(ctx context.Context, userID string, since *time.Time) ([]models.SyncCollection, error)
+}
+
+// UserRepository reads and writes users and their devices
+type UserRepository interface {
+	EnsureUser(ctx context.Context, userID, email string) error
+	TouchDevice(ctx context.Context, userID, deviceID, operation string) error
+	// UserSettings returns the user's settings, or the defaults if the user has no row yet
+	UserSettings(ctx context.Context, userID string) (models.UserSettings, error)
+}
+
+// AIUserRepository reads the settings AI requests apply for a signed-in user
+type AIUserRepository interface {
+	// ProviderRegion returns the AI region the user chose, or "" for the provider's default endpoint
+	ProviderRegion(ctx context.Context, userID string) (string, error)
+	// AIExcludedNoteIDs returns which of the notes the user opted out of AI processing
+	AIExcludedNoteIDs(ctx context.Context, userID string, noteIDs []string) (map[string]bool, error)
+}
+
+// ShareRepository reads and writes share links to notes and counts their views
+type ShareRepository interface {
+	// HasNote reports whether the user has the note, including in the trash
+	HasNote(ctx context.Context, userID, noteID string) (bool, error)
+	// ShareLinks returns the note's live links, newest first
+	ShareLinks(ctx context.Context, userID, noteID string) ([]models.ShareLink, error)
+	// ShareLink returns one of the note's links, revoked or not, or ErrShareLinkNotFound
+	ShareLink(ctx context.Context, userID, noteID, shareID string) (models.ShareLink, error)
+	// CreateShareLink stores a link to a live note of the user, or returns ErrShareNoteNotFound
+	CreateShareLink(ctx context.Context, share NewShareLink) (models.ShareLink, error)
+	// RevokeShareLinks revokes one of the note's live links, or all of them if shareID is empty
+	RevokeShareLinks(ctx context.Context, userID, noteID, shareID, deviceID string) (int64, error)
+	// SharedNoteByToken looks up a link by its token's hash, or returns ErrShareLinkNotFound
+	SharedNoteByToken(ctx context.Context, tokenHash string) (SharedNoteLink, error)
+	// CountShareView counts a view atomically, returning false once the view limit is reached
+	CountShareView(ctx context.Context, shareID, referrerHost string) (bool, error)
+	// ShareViewsByDay returns a link's views in [since, end) by UTC day, leaving out days without views
+	ShareViewsByDay(ctx context.Context, shareID string, since, end time.Time) (map[string]int, error)
+	// ShareReferrers returns the sites that referred the most views in [since, end), most views first
+	ShareReferrers(ctx context.Context, shareID string, since, end time.Time, limit int) ([]models.ShareLinkReferrers, error)
+}
+
+// Database implements the repositories on Postgres; handler tests can substitute in-memory fakes
+var (
+	_ NoteRepository       = (*Database)(nil)
+	_ CollectionRepository = (*Database)(nil)
+	_ UserRepository       = (*Database)(nil)
+	_ AIUserRepository     = (*Database)(nil)
+	_ ShareRepository      = (*Database)(nil)
+)
+
+// Queryer is satisfied by both *sql.DB and *sql.Tx
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Execer is satisfied by both *sql.DB and *sql.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// RowScanner is satisfied by both *sql.Row and *sql.Rows
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
@@ -0,0 +1,232 @@
+// In-memory share links and their views
+package repositorytest
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"slices"
+	"sort"
+	"time"
+)
+
+// storedShare is a share link with the fields only the server reads
+type storedShare struct {
+	link         models.ShareLink
+	userID       string
+	tokenHash    string
+	title        string
+	content, iv  []byte
+	passwordHash string
+	revoked      bool
+}
+
+// shareView is one counted view of a share link
+type shareView struct {
+	shareID      string
+	referrerHost string
+	viewedAt     time.Time
+}
+
+// AddShareView records a view of a link at a given time, without counting it against the link's limit
+func (s *Store) AddShareView(shareID, referrerHost string, viewedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shareViews = append(s.shareViews, shareView{shareID: shareID, referrerHost: referrerHost, viewedAt: viewedAt})
+}
+
+// HasNote reports whether the user has the note, including in the trash
+func (s *Store) HasNote(_ context.Context, userID, noteID string) (bool, error) {
+	if s.Err != nil {
+		return false, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	note, ok := s.notes[noteID]
+	return ok && note.UserID == userID, nil
+}
+
+// ShareLinks returns the note's live links, newest first
+func (s *Store) ShareLinks(_ context.Context, userID, noteID string) ([]models.ShareLink, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links := []models.ShareLink{}
+	for _, share := range s.shares {
+		if share.userID == userID && share.link.NoteID == noteID && !share.revoked {
+			links = append(links, share.link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ID < links[j].ID })
+	slices.SortStableFunc(links, func(a, b models.ShareLink) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return links, nil
+}
+
+// ShareLink returns one of the note's links, revoked or not, or ErrShareLinkNotFound
+func (s *Store) ShareLink(_ context.Context, userID, noteID, shareID string) (models.ShareLink, error) {
+	if s.Err != nil {
+		return models.ShareLink{}, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, ok := s.shares[shareID]
+	if !ok || share.userID != userID || share.link.NoteID != noteID {
+		return models.ShareLink{}, services.ErrShareLinkNotFound
+	}
+	return share.link, nil
+}
+
+// CreateShareLink stores a link to a live note of the user, or returns ErrShareNoteNotFound
+func (s *Store) CreateShareLink(_ context.Context, share services.NewShareLink) (models.ShareLink, error) {
+	if s.Err != nil {
+		return models.ShareLink{}, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	note, ok := s.notes[share.NoteID]
+	if !ok || note.UserID != share.UserID || note.DeletedAt != nil {
+		return models.ShareLink{}, services.ErrShareNoteNotFound
+	}
+	stored := &storedShare{
+		link: models.ShareLink{
+			ID:          share.ID,
+			NoteID:      share.NoteID,
+			HasPassword: share.PasswordHash != nil,
+			ExpiresAt:   share.ExpiresAt,
+			MaxViews:    share.MaxViews,
+			CreatedAt:   time.Now(),
+		},
+		userID:    share.UserID,
+		tokenHash: share.TokenHash,
+		title:     note.Title,
+		content:   share.Content,
+		iv:        share.IV,
+	}
+	if share.PasswordHash != nil {
+		stored.passwordHash = *share.PasswordHash
+	}
+	s.shares[share.ID] = stored
+	return stored.link, nil
+}
+
+// RevokeShareLinks revokes one of the note's live links, or all of them if shareID is empty
+func (s *Store) RevokeShareLinks(_ context.Context, userID, noteID, shareID, _ string) (int64, error) {
+	if s.Err != nil {
+		return 0, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revoked int64
+	for _, share := range s.shares {
+		if share.userID == userID && share.link.NoteID == noteID && !share.revoked && (shareID == "" || share.link.ID == shareID) {
+			share.revoked = true
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// SharedNoteByToken looks up a link by its token's hash, or returns ErrShareLinkNotFound
+func (s *Store) SharedNoteByToken(_ context.Context, tokenHash string) (services.SharedNoteLink, error) {
+	if s.Err != nil {
+		return services.SharedNoteLink{}, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, share := range s.shares {
+		if share.tokenHash != tokenHash {
+			continue
+		}
+		note := s.notes[share.link.NoteID]
+		limit := share.link.MaxViews
+		return services.SharedNoteLink{
+			ID:           share.link.ID,
+			Note:         models.SharedNote{Title: share.title, SharedAt: share.link.CreatedAt},
+			Content:      share.content,
+			IV:           share.iv,
+			PasswordHash: share.passwordHash,
+			ExpiresAt:    share.link.ExpiresAt,
+			Revoked:      share.revoked,
+			NoteDeleted:  note.DeletedAt != nil,
+			Limited:      limit != nil,
+			LimitReached: limit != nil && share.link.ViewCount >= *limit,
+		}, nil
+	}
+	return services.SharedNoteLink{}, services.ErrShareLinkNotFound
+}
+
+// CountShareView counts a view and records it, unless the link's view limit has been reached
+func (s *Store) CountShareView(_ context.Context, shareID, referrerHost string) (bool, error) {
+	if s.Err != nil {
+		return false, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	share, ok := s.shares[shareID]
+	if !ok || (share.link.MaxViews != nil && share.link.ViewCount >= *share.link.MaxViews) {
+		return false, nil
+	}
+	now := time.Now()
+	share.link.ViewCount++
+	share.link.LastViewedAt = &now
+	s.shareViews = append(s.shareViews, shareView{shareID: shareID, referrerHost: referrerHost, viewedAt: now})
+	return true, nil
+}
+
+// ShareViewsByDay returns a link's views in [since, end) by UTC day, leaving out days without views
+func (s *Store) ShareViewsByDay(_ context.Context, shareID string, since, end time.Time) (map[string]int, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	views := map[string]int{}
+	for _, view := range s.shareViewsLocked(shareID, since, end) {
+		views[view.viewedAt.UTC().Format(time.DateOnly)]++
+	}
+	return views, nil
+}
+
+// ShareReferrers returns the sites that referred the most views in [since, end), most views first
+func (s *Store) ShareReferrers(_ context.Context, shareID string, since, end time.Time, limit int) ([]models.ShareLinkReferrers, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	views := map[string]int{}
+	for _, view := range s.shareViewsLocked(shareID, since, end) {
+		views[view.referrerHost]++
+	}
+	referrers := []models.ShareLinkReferrers{}
+	for host, count := range views {
+		referrers = append(referrers, models.ShareLinkReferrers{Host: host, Views: count})
+	}
+	sort.Slice(referrers, func(i, j int) bool {
+		if referrers[i].Views != referrers[j].Views {
+			return referrers[i].Views > referrers[j].Views
+		}
+		return referrers[i].Host < referrers[j].Host
+	})
+	return firstN(referrers, limit), nil
+}
+
+// shareViewsLocked returns a link's views in [since, end); s.mu must be held
+func (s *Store) shareViewsLocked(shareID string, since, end time.Time) []shareView {
+	var views []shareView
+	for _, view := range s.shareViews {
+		if view.shareID == shareID && !view.viewedAt.Before(since) && view.viewedAt.Before(end) {
+			views = append(views, view)
+		}
+	}
+	return views
+}
@@ -0,0 +1,571 @@
+// In-memory fakes of the repositories, for handler tests that shouldn't need Postgres
+package repositorytest
+
+import (
+	"backend/models"
+	"backend/pagination"
+	"backend/services"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is an in-memory services.NoteRepository, CollectionRepository, UserRepository,
+// AIUserRepository, and ShareRepository. It keeps what handlers rely on: ownership, soft deletes,
+// snoozing, conflict detection and the conflict policies, all-or-nothing pushes, and share link view
+// limits. Revisions, timeline events, and
+// server-maintained collection counts aren't kept, field masks read every field, and NoteFilter's
+// source page filter is ignored.
+type Store struct {
+	// Err, when set, is returned by every method, to test how handlers handle a failing database
+	Err error
+	// BeforePush, when set, is called as ApplyPush starts, e.g. to take a note lock after the handler
+	// checked for them
+	BeforePush func()
+
+	mu          sync.Mutex
+	notes       map[string]models.SyncNote // By ID, each with its UserID
+	devices     map[string]string          // Device that last wrote each note
+	collections map[string]models.SyncCollection
+	users       map[string]string // Email by user ID
+	settings    map[string]models.UserSettings
+	locks       map[string][]models.NoteLock // By user ID
+	touches     []string                     // "userID deviceID operation" per TouchDevice call
+	shares      map[string]*storedShare      // By ID
+	shareViews  []shareView
+}
+
+// Store implements every repository handlers take
+var (
+	_ services.NoteRepository       = (*Store)(nil)
+	_ services.CollectionRepository = (*Store)(nil)
+	_ services.UserRepository       = (*Store)(nil)
+	_ services.AIUserRepository     = (*Store)(nil)
+	_ services.ShareRepository      = (*Store)(nil)
+)
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{
+		notes:       make(map[string]models.SyncNote),
+		devices:     make(map[string]string),
+		collections: make(map[string]models.SyncCollection),
+		users:       make(map[string]string),
+		settings:    make(map[string]models.UserSettings),
+		locks:       make(map[string][]models.NoteLock),
+		shares:      make(map[string]*storedShare),
+	}
+}
+
+// AddNote stores a note as it is; it must have a UserID
+func (s *Store) AddNote(note models.SyncNote) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notes[note.ID] = note
+}
+
+// AddCollection stores a collection as it is; it must have a UserID
+func (s *Store) AddCollection(coll models.SyncCollection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collections[coll.ID] = coll
+}
+
+// SetUserSettings replaces a user's settings
+func (s *Store) SetUserSettings(userID string, settings models.UserSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[userID] = settings
+}
+
+// AddLock holds a lock on one of the user's notes
+func (s *Store) AddLock(userID string, lock models.NoteLock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[userID] = append(s.locks[userID], lock)
+}
+
+// Note returns a stored note, including soft-deleted ones
+func (s *Store) Note(noteID string) (models.SyncNote, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	note, ok := s.notes[noteID]
+	return note, ok
+}
+
+// Notes returns the user's stored notes, including soft-deleted ones, by ID
+func (s *Store) Notes(userID string) []models.SyncNote {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.userNotesLocked(userID, func(models.SyncNote) bool { return true })
+}
+
+// UserExists reports whether EnsureUser was called for a user
+func (s *Store) UserExists(userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.users[userID]
+	return ok
+}
+
+// Touches returns the TouchDevice calls made so far, as "userID deviceID operation"
+func (s *Store) Touches() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.touches)
+}
+
+// SyncNotes returns the notes changed since a time, including deleted ones, or all live notes if since
+// is nil (without snoozed ones unless includeSnoozed). With page set, it returns one page plus one extra
+// note, oldest change first; otherwise the most recently updated come first.
+func (s *Store) SyncNotes(_ context.Context, userID string, since *time.Time, includeSnoozed bool, _ services.NoteFieldMask, page *pagination.Params) ([]models.SyncNote, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	notes := s.userNotesLocked(userID, func(n models.SyncNote) bool {
+		if since != nil {
+			return !n.UpdatedAt.Before(*since) && (n.DeletedAt == nil || !n.DeletedAt.Before(*since))
+		}
+		return n.DeletedAt == nil && (includeSnoozed || !snoozed(n, now))
+	})
+	if page == nil {
+		sortNewestFirst(notes, func(n models.SyncNote) time.Time { return n.UpdatedAt })
+		return notes, nil
+	}
+
+	slices.SortFunc(notes, func(a, b models.SyncNote) int {
+		if c := a.UpdatedAt.Compare(b.UpdatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	if page.Cursor != nil {
+		notes = slices.DeleteFunc(notes, func(n models.SyncNote) bool {
+			c := n.UpdatedAt.Compare(page.Cursor.SortValue)
+			return c < 0 || c == 0 && n.ID <= page.Cursor.ID
+		})
+	}
+	return firstN(notes, page.Limit+1), nil
+}
+
+// NotesByIDs returns the given notes owned by the user, including soft-deleted ones
+func (s *Store) NotesByIDs(_ context.Context, userID string, noteIDs []string) ([]models.SyncNote, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notes := s.userNotesLocked(userID, func(n models.SyncNote) bool { return slices.Contains(noteIDs, n.ID) })
+	sortNewestFirst(notes, func(n models.SyncNote) time.Time { return n.UpdatedAt })
+	return notes, nil
+}
+
+// ListNotes returns one page (plus one extra note) of live notes matching the filter, most recently
+// updated first
+func (s *Store) ListNotes(_ context.Context, userID string, filter services.NoteFilter, page pagination.Params) ([]models.SyncNote, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	wantSnoozed := filter.Snoozed != nil && *filter.Snoozed
+	notes := s.userNotesLocked(userID, func(n models.SyncNote) bool {
+		switch {
+		case n.DeletedAt != nil, snoozed(n, now) != wantSnoozed:
+			return false
+		case filter.CollectionID != "" && !slices.Contains(n.CollectionIDs, filter.CollectionID):
+			return false
+		case filter.Tag != "" && !slices.ContainsFunc(n.Tags, func(t string) bool { return strings.EqualFold(t, filter.Tag) }):
+			return false
+		case filter.Pinned != nil && n.IsPinned != *filter.Pinned:
+			return false
+		case filter.Archived != nil && (n.IsArchived != nil && *n.IsArchived) != *filter.Archived:
+			return false
+		}
+		return true
+	})
+	sortNewestFirst(notes, func(n models.SyncNote) time.Time { return n.UpdatedAt })
+	return firstN(afterCursor(notes, page.Cursor, func(n models.SyncNote) time.Time { return n.UpdatedAt }), page.Limit+1), nil
+}
+
+// DeletedNotes returns one page (plus one extra note) of soft-deleted notes, most recently deleted first
+func (s *Store) DeletedNotes(_ context.Context, userID string, page pagination.Params) ([]models.SyncNote, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notes := s.userNotesLocked(userID, func(n models.SyncNote) bool { return n.DeletedAt != nil })
+	deletedAt := func(n models.SyncNote) time.Time { return *n.DeletedAt }
+	sortNewestFirst(notes, deletedAt)
+	return firstN(afterCursor(notes, page.Cursor, deletedAt), page.Limit+1), nil
+}
+
+// NoteDigestEntries returns the ID and version of every live note, by ID, plus each note's collections
+func (s *Store) NoteDigestEntries(_ context.Context, userID string) ([]services.NoteDigestEntry, map[string][]string, error) {
+	if s.Err != nil {
+		return nil, nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []services.NoteDigestEntry
+	memberships := make(map[string][]string)
+	for _, note := range s.userNotesLocked(userID, func(n models.SyncNote) bool { return n.DeletedAt == nil }) {
+		entries = append(entries, services.NoteDigestEntry{ID: note.ID, UpdatedAt: note.UpdatedAt})
+		if len(note.CollectionIDs) > 0 {
+			memberships[note.ID] = slices.Clone(note.CollectionIDs)
+		}
+	}
+	return entries, memberships, nil
+}
+
+// NoteCountAfterPush returns how many live notes the user has, and how many they'd have after the push
+func (s *Store) NoteCountAfterPush(_ context.Context, userID string, req *models.SyncRequest) (current, after int, err error) {
+	if s.Err != nil {
+		return 0, 0, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live := map[string]bool{}
+	for _, note := range s.userNotesLocked(userID, func(n models.SyncNote) bool { return n.DeletedAt == nil }) {
+		live[note.ID] = true
+	}
+	current = len(live)
+	for i := range req.Notes {
+		live[req.Notes[i].ID] = req.Notes[i].DeletedAt == nil
+	}
+	for _, isLive := range live {
+		if isLive {
+			after++
+		}
+	}
+	return current, after, nil
+}
+
+// ApplyPush applies a push all-or-nothing, like Database.ApplyPush: collections first, then notes, with
+// stale notes resolved by the conflict policy. On error nothing is stored and every item that wasn't
+// rejected is marked rolled back.
+func (s *Store) ApplyPush(_ context.Context, userID, deviceID, policy string, req *models.SyncRequest) (services.SyncPush, error) {
+	var push services.SyncPush
+	for i := range req.Collections {
+		push.Results = append(push.Results, models.SyncItemResult{Type: "collection", ID: req.Collections[i].ID, Status: models.SyncItemAccepted})
+	}
+	for i := range req.Notes {
+		push.Results = append(push.Results, models.SyncItemResult{Type: "note", ID: req.Notes[i].ID, Status: models.SyncItemAccepted})
+	}
+	if s.Err != nil {
+		services.MarkRolledBack(push.Results)
+		return push, s.Err
+	}
+	if s.BeforePush != nil {
+		s.BeforePush()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Work on copies, kept only if the whole push applies
+	notes, devices, collections := maps.Clone(s.notes), maps.Clone(s.devices), maps.Clone(s.collections)
+	now := time.Now()
+	reject := func(index int, reason, message string) {
+		push.Results[index].Status = models.SyncItemRejected
+		push.Results[index].Reason, push.Results[index].Error = reason, message
+	}
+	forbidden, rejectedConflicts := false, false
+
+	for i := range req.Collections {
+		pushed := req.Collections[i]
+		stored, exists := collections[pushed.ID]
+		if exists && stored.UserID != userID {
+			reject(i, models.SyncRejectForbidden, "The collection belongs to another account")
+			forbidden = true
+			continue
+		}
+		collections[pushed.ID] = mergeCollection(stored, pushed, userID)
+		push.CollectionIDs = append(push.CollectionIDs, pushed.ID)
+	}
+
+	offset := len(req.Collections)
+	for i := range req.Notes {
+		pushed := req.Notes[i]
+		stored, exists := notes[pushed.ID]
+		if exists && stored.UserID != userID {
+			reject(offset+i, models.SyncRejectForbidden, "The note belongs to another account")
+			forbidden = true
+			continue
+		}
+
+		if pushed.DeletedAt != nil {
+			if exists {
+				stored.DeletedAt, stored.UpdatedAt = &now, now
+				notes[pushed.ID], devices[pushed.ID] = stored, deviceID
+			}
+			push.NoteIDs = append(push.NoteIDs, pushed.ID)
+			continue
+		}
+
+		stale := exists && stored.DeletedAt == nil && pushed.BaseUpdatedAt != nil && stored.UpdatedAt.After(*pushed.BaseUpdatedAt)
+		if stale && (deviceID == "" || devices[pushed.ID] != deviceID) {
+			server, client := stored, pushed
+			client.UserID, client.BaseUpdatedAt, client.EmbeddingText = userID, nil, nil
+			conflict := models.SyncConflict{NoteID: pushed.ID, Server: &server, Client: &client}
+			if policy == models.ConflictReject {
+				push.Conflicts = append(push.Conflicts, conflict)
+				reject(offset+i, models.SyncRejectConflict, "Note changed on the server since baseUpdatedAt")
+				rejectedConflicts = true
+				continue
+			}
+			if policy == models.ConflictKeepBoth {
+				copyID, err := services.NewNoteID()
+				if err != nil {
+					reject(offset+i, models.SyncRejectFailed, "Failed to apply change")
+					services.MarkRolledBack(push.Results)
+					return services.SyncPush{Results: push.Results}, err
+				}
+				device := devices[pushed.ID]
+				if device == "" {
+					device = "another device"
+				}
+				copied := stored
+				copied.ID, copied.Title, copied.IsPinned = copyID, stored.Title+" (conflicted copy from "+device+")", false
+				copied.ConflictOf, copied.CreatedAt, copied.UpdatedAt = &stored.ID, now, now
+				notes[copyID], devices[copyID] = copied, devices[pushed.ID]
+				conflict.CopyID = copyID
+				push.NoteIDs = append(push.NoteIDs, copyID)
+			}
+			push.Conflicts = append(push.Conflicts, conflict)
+		}
+
+		notes[pushed.ID], devices[pushed.ID] = mergeNote(stored, pushed, userID), deviceID
+		if pushed.EmbeddingText != nil {
+			push.Embed = append(push.Embed, &req.Notes[i])
+		}
+		push.NoteIDs = append(push.NoteIDs, pushed.ID)
+	}
+
+	if forbidden || rejectedConflicts {
+		services.MarkRolledBack(push.Results)
+		err := services.ErrPushConflicts
+		if forbidden {
+			err = services.ErrPushForbidden
+		}
+		return services.SyncPush{Results: push.Results, Conflicts: push.Conflicts}, err
+	}
+	noteIDs := make([]string, 0, len(req.Notes))
+	for i := range req.Notes {
+		noteIDs = append(noteIDs, req.Notes[i].ID)
+	}
+	if locks := s.activeLocks(userID, noteIDs); len(locks) > 0 {
+		services.MarkRolledBack(push.Results)
+		return services.SyncPush{Results: push.Results}, &services.NoteLockedError{Locks: locks}
+	}
+	s.notes, s.devices, s.collections = notes, devices, collections
+	return push, nil
+}
+
+// ActiveNoteLocks returns the unexpired locks held on any of the notes
+func (s *Store) ActiveNoteLocks(_ context.Context, userID string, noteIDs []string) ([]models.NoteLock, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeLocks(userID, noteIDs), nil
+}
+
+// activeLocks returns the user's unexpired locks on any of the notes; s.mu must be held
+func (s *Store) activeLocks(userID string, noteIDs []string) []models.NoteLock {
+	now := time.Now()
+	locks := []models.NoteLock{}
+	for _, lock := range s.locks[userID] {
+		if slices.Contains(noteIDs, lock.NoteID) && !lock.ExpiresAt.Before(now) {
+			locks = append(locks, lock)
+		}
+	}
+	return locks
+}
+
+// AIExcludedNoteIDs returns which of the notes the user opted out of AI processing
+func (s *Store) AIExcludedNoteIDs(_ context.Context, userID string, noteIDs []string) (map[string]bool, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	excluded := map[string]bool{}
+	for _, id := range noteIDs {
+		if note, ok := s.notes[id]; ok && note.UserID == userID && note.AIExcluded != nil && *note.AIExcluded {
+			excluded[id] = true
+		}
+	}
+	return excluded, nil
+}
+
+// SyncCollections returns the collections changed since a time, including deleted ones, or all live
+// collections if since is nil, most recently updated first
+func (s *Store) SyncCollections(_ context.Context, userID string, since *time.Time) ([]models.SyncCollection, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var colls []models.SyncCollection
+	for _, coll := range s.collections {
+		if coll.UserID != userID {
+			continue
+		}
+		if since != nil && !coll.UpdatedAt.Before(*since) || since == nil && coll.DeletedAt == nil {
+			colls = append(colls, coll)
+		}
+	}
+	slices.SortFunc(colls, func(a, b models.SyncCollection) int { return strings.Compare(a.ID, b.ID) })
+	sortNewestFirst(colls, func(c models.SyncCollection) time.Time { return c.UpdatedAt })
+	return colls, nil
+}
+
+// EnsureUser records the user and their email
+func (s *Store) EnsureUser(_ context.Context, userID, email string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[userID] = email
+	return nil
+}
+
+// TouchDevice records that a device pulled or pushed
+func (s *Store) TouchDevice(_ context.Context, userID, deviceID, operation string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touches = append(s.touches, userID+" "+deviceID+" "+operation)
+	return nil
+}
+
+// UserSettings returns the user's settings, or the defaults if none were set
+func (s *Store) UserSettings(_ context.Context, userID string) (models.UserSettings, error) {
+	defaults := models.UserSettings{ConflictPolicy: models.ConflictLastWriteWins, Timezone: models.DefaultTimezone}
+	if s.Err != nil {
+		return defaults, s.Err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if settings, ok := s.settings[userID]; ok {
+		return settings, nil
+	}
+	return defaults, nil
+}
+
+// ProviderRegion returns the AI region in the user's settings, or ""
+func (s *Store) ProviderRegion(ctx context.Context, userID string) (string, error) {
+	settings, err := s.UserSettings(ctx, userID)
+	if err != nil || settings.AIRegion == nil {
+		return "", err
+	}
+	return *settings.AIRegion, nil
+}
+
+// Helper functions
+
+// userNotesLocked returns copies of the user's notes that keep accepts, by ID
+func (s *Store) userNotesLocked(userID string, keep func(models.SyncNote) bool) []models.SyncNote {
+	var notes []models.SyncNote
+	for _, note := range s.notes {
+		if note.UserID == userID && keep(note) {
+			notes = append(notes, note)
+		}
+	}
+	slices.SortFunc(notes, func(a, b models.SyncNote) int { return strings.Compare(a.ID, b.ID) })
+	return notes
+}
+
+// mergeNote applies a pushed note over the stored one, keeping the fields the push omits
+func mergeNote(stored, pushed models.SyncNote, userID string) models.SyncNote {
+	merged := pushed
+	merged.UserID, merged.BaseUpdatedAt, merged.EmbeddingText, merged.ConflictOf = userID, nil, nil, stored.ConflictOf
+	merged.SnoozedUntil = stored.SnoozedUntil // Only set through the metadata endpoint
+	if pushed.IsArchived == nil {
+		merged.IsArchived = stored.IsArchived
+	}
+	if pushed.Tags == nil {
+		merged.Tags = stored.Tags
+	}
+	if pushed.ReminderAt == nil {
+		merged.ReminderAt = stored.ReminderAt
+	}
+	if pushed.AIExcluded == nil {
+		merged.AIExcluded = stored.AIExcluded
+	}
+	if pushed.SourceEncrypted == nil {
+		merged.SourceEncrypted, merged.SourceIV, merged.SourceURLHash = stored.SourceEncrypted, stored.SourceIV, stored.SourceURLHash
+	}
+	return merged
+}
+
+// mergeCollection applies a pushed collection over the stored one, keeping the fields the push omits
+func mergeCollection(stored, pushed models.SyncCollection, userID string) models.SyncCollection {
+	merged := pushed
+	merged.UserID, merged.NoteCount, merged.LastNoteAt = userID, stored.NoteCount, stored.LastNoteAt
+	for _, field := range []struct{ pushed, stored **string }{
+		{&merged.Color, &stored.Color},
+		{&merged.Description, &stored.Description},
+		{&merged.Cover, &stored.Cover},
+		{&merged.ParentID, &stored.ParentID},
+	} {
+		if *field.pushed == nil {
+			*field.pushed = *field.stored
+		}
+	}
+	if pushed.SortIndex == nil {
+		merged.SortIndex = stored.SortIndex
+	}
+	return merged
+}
+
+// snoozed reports whether a note is hidden until a later time
+func snoozed(note models.SyncNote, now time.Time) bool {
+	return note.SnoozedUntil != nil && note.SnoozedUntil.After(now)
+}
+
+// sortNewestFirst sorts items by a time, latest first, then by descending ID (items are sorted by ID)
+func sortNewestFirst[T any](items []T, at func(T) time.Time) {
+	slices.Reverse(items)
+	slices.SortStableFunc(items, func(a, b T) int { return at(b).Compare(at(a)) })
+}
+
+// afterCursor drops the notes up to and including a descending cursor
+func afterCursor(notes []models.SyncNote, cursor *pagination.Cursor, at func(models.SyncNote) time.Time) []models.SyncNote {
+	if cursor == nil {
+		return notes
+	}
+	return slices.DeleteFunc(notes, func(n models.SyncNote) bool {
+		c := at(n).Compare(cursor.SortValue)
+		return c > 0 || c == 0 && n.ID >= cursor.ID
+	})
+}
+
+// firstN returns at most the first n items
+func firstN[T any](items []T, n int) []T {
+	if len(items) > n {
+		return items[:n]
+	}
+	return items
+}
@@ -0,0 +1,352 @@
+// Runtime configuration that can be reloaded without restarting the server
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Log levels in increasing severity
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+var logLevelSeverity = map[string]int{
+	LogLevelDebug: 0,
+	LogLevelInfo:  1,
+	LogLevelWarn:  2,
+	LogLevelError: 3,
+}
+
+// RateLimit describes how many requests a route group allows per window
+type RateLimit struct {
+	Requests int           `json:"requests"`
+	Window   time.Duration `json:"window"`
+}
+
+// SLOTarget is the service-level objective of a route: the minimum fraction of non-5xx
+// responses and the maximum p95 latency
+type SLOTarget struct {
+	SuccessRate float64       `json:"successRate"`
+	P95Latency  time.Duration `json:"p95Latency"`
+}
+
+// defaultSLORoute is the SLO_TARGETS key applied to routes without their own target
+const defaultSLORoute = "default"
+
+// Default realtime change batching windows
+const (
+	defaultRealtimeDebounce = 500 * time.Millisecond
+	defaultRealtimeMaxDelay = 5 * time.Second
+)
+
+// SyncLimits bound what a user can store through sync
+type SyncLimits struct {
+	MaxNoteBytes    int `json:"maxNoteBytes"`    // Encrypted content per note
+	MaxNotesPerUser int `json:"maxNotesPerUser"` // Live (not deleted) notes
+	MaxPushItems    int `json:"maxPushItems"`    // Notes and collections in a single push
+}
+
+// defaultSyncLimits apply to limits that aren't configured
+var defaultSyncLimits = SyncLimits{
+	MaxNoteBytes:    1 << 20,
+	MaxNotesPerUser: 10000,
+	MaxPushItems:    500,
+}
+
+// RuntimeSettings is a snapshot of the reloadable configuration
+type RuntimeSettings struct {
+	LogLevel              string               `json:"logLevel"`
+	RateLimits            map[string]RateLimit `json:"rateLimits"`
+	FeatureFlags          map[string]bool      `json:"featureFlags"`
+	SLOTargets            map[string]SLOTarget `json:"sloTargets"`
+	SLOAlertWebhook       string               `json:"-"` // Contains a secret token
+	RealtimeDebounce      time.Duration        `json:"realtimeDebounce"`
+	RealtimeMaxDelay      time.Duration        `json:"realtimeMaxDelay"`
+	ClientErrorSampleRate float64              `json:"clientErrorSampleRate"`
+	SyncLimits            SyncLimits           `json:"syncLimits"`
+	LoadedAt              time.Time            `json:"loadedAt"`
+}
+
+// RuntimeConfig holds the current reloadable configuration
+type RuntimeConfig struct {
+	mu       sync.RWMutex
+	settings RuntimeSettings
+}
+
+// Config is the process-wide runtime configuration
+var Config = &RuntimeConfig{settings: RuntimeSettings{
+	LogLevel:              LogLevelInfo,
+	RateLimits:            map[string]RateLimit{},
+	FeatureFlags:          map[string]bool{},
+	SLOTargets:            map[string]SLOTarget{},
+	RealtimeDebounce:      defaultRealtimeDebounce,
+	RealtimeMaxDelay:      defaultRealtimeMaxDelay,
+	ClientErrorSampleRate: 1,
+	SyncLimits:            defaultSyncLimits,
+}}
+
+// Reload re-reads the .env file (if present) and environment, replacing the current settings.
+// Invalid values are rejected as a whole so a typo can't half-apply a config change.
+//
+//	LOG_LEVEL=debug|info|warn|error
+//	RATE_LIMITS=ai=30/1m,sync=120/1m
+//	FEATURE_FLAGS=flag_a,flag_b
+//	SLO_TARGETS=default=99.5%/1s,/api/chat=99%/30s
+//	SLO_ALERT_WEBHOOK_URL=https://hooks.slack.com/services/...
+//	REALTIME_DEBOUNCE=500ms
+//	REALTIME_MAX_DELAY=5s
+//	CLIENT_ERROR_SAMPLE_RATE=0.25
+//	SYNC_MAX_NOTE_BYTES=1048576
+//	SYNC_MAX_NOTES_PER_USER=10000
+//	SYNC_MAX_PUSH_ITEMS=500
+func (c *RuntimeConfig) Reload() (RuntimeSettings, error) {
+	if _, err := os.Stat(".env"); err == nil {
+		if err := godotenv.Overload(); err != nil {
+			return RuntimeSettings{}, fmt.Errorf("failed to read .env: %w", err)
+		}
+	}
+
+	settings := RuntimeSettings{
+		LogLevel:        strings.ToLower(strings.TrimSpace(os.Getenv("LOG_LEVEL"))),
+		RateLimits:      map[string]RateLimit{},
+		FeatureFlags:    map[string]bool{},
+		SLOTargets:      map[string]SLOTarget{},
+		SLOAlertWebhook: strings.TrimSpace(os.Getenv("SLO_ALERT_WEBHOOK_URL")),
+		LoadedAt:        time.Now(),
+	}
+	if settings.LogLevel == "" {
+		settings.LogLevel = LogLevelInfo
+	}
+	if _, ok := logLevelSeverity[settings.LogLevel]; !ok {
+		return RuntimeSettings{}, fmt.Errorf("invalid LOG_LEVEL %q", settings.LogLevel)
+	}
+
+	for _, entry := range splitList(os.Getenv("RATE_LIMITS")) {
+		group, limit, err := parseRateLimit(entry)
+		if err != nil {
+			return RuntimeSettings{}, err
+		}
+		settings.RateLimits[group] = limit
+	}
+
+	for _, flag := range splitList(os.Getenv("FEATURE_FLAGS")) {
+		settings.FeatureFlags[flag] = true
+	}
+
+	for _, entry := range splitList(os.Getenv("SLO_TARGETS")) {
+		route, target, err := parseSLOTarget(entry)
+		if err != nil {
+			return RuntimeSettings{}, err
+		}
+		settings.SLOTargets[route] = target
+	}
+
+	var err error
+	if settings.RealtimeDebounce, err = parseDurationEnv("REALTIME_DEBOUNCE", defaultRealtimeDebounce); err != nil {
+		return RuntimeSettings{}, err
+	}
+	if settings.RealtimeMaxDelay, err = parseDurationEnv("REALTIME_MAX_DELAY", defaultRealtimeMaxDelay); err != nil {
+		return RuntimeSettings{}, err
+	}
+	if settings.RealtimeMaxDelay < settings.RealtimeDebounce {
+		return RuntimeSettings{}, fmt.Errorf("REALTIME_MAX_DELAY must be at least REALTIME_DEBOUNCE")
+	}
+
+	settings.ClientErrorSampleRate = 1
+	if value := strings.TrimSpace(os.Getenv("CLIENT_ERROR_SAMPLE_RATE")); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return RuntimeSettings{}, fmt.Errorf("invalid CLIENT_ERROR_SAMPLE_RATE %q (expected 0-1)", value)
+		}
+		settings.ClientErrorSampleRate = rate
+	}
+
+	if settings.SyncLimits.MaxNoteBytes, err = parsePositiveIntEnv("SYNC_MAX_NOTE_BYTES", defaultSyncLimits.MaxNoteBytes); err != nil {
+		return RuntimeSettings{}, err
+	}
+	if settings.SyncLimits.MaxNotesPerUser, err = parsePositiveIntEnv("SYNC_MAX_NOTES_PER_USER", defaultSyncLimits.MaxNotesPerUser); err != nil {
+		return RuntimeSettings{}, err
+	}
+	if settings.SyncLimits.MaxPushItems, err = parsePositiveIntEnv("SYNC_MAX_PUSH_ITEMS", defaultSyncLimits.MaxPushItems); err != nil {
+		return RuntimeSettings{}, err
+	}
+
+	c.mu.Lock()
+	c.settings = settings
+	c.mu.Unlock()
+	return settings, nil
+}
+
+// Snapshot returns a copy of the current settings
+func (c *RuntimeConfig) Snapshot() RuntimeSettings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snapshot := c.settings
+	snapshot.RateLimits = make(map[string]RateLimit, len(c.settings.RateLimits))
+	for group, limit := range c.settings.RateLimits {
+		snapshot.RateLimits[group] = limit
+	}
+	snapshot.FeatureFlags = make(map[string]bool, len(c.settings.FeatureFlags))
+	for flag, enabled := range c.settings.FeatureFlags {
+		snapshot.FeatureFlags[flag] = enabled
+	}
+	snapshot.SLOTargets = make(map[string]SLOTarget, len(c.settings.SLOTargets))
+	for route, target := range c.settings.SLOTargets {
+		snapshot.SLOTargets[route] = target
+	}
+	return snapshot
+}
+
+// FeatureEnabled reports whether a feature flag is turned on
+func (c *RuntimeConfig) FeatureEnabled(flag string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.settings.FeatureFlags[flag]
+}
+
+// RateLimitFor returns the configured rate limit for a route group
+func (c *RuntimeConfig) RateLimitFor(group string) (RateLimit, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	limit, ok := c.settings.RateLimits[group]
+	return limit, ok
+}
+
+// SLOTargetFor returns the SLO of a route pattern ("POST /api/chat"), falling back to the target for
+// its path alone ("/api/chat", covering every method), then to the default target
+func (c *RuntimeConfig) SLOTargetFor(route string) (SLOTarget, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if target, ok := c.settings.SLOTargets[route]; ok {
+		return target, true
+	}
+	if _, path, ok := strings.Cut(route, " "); ok {
+		if target, ok := c.settings.SLOTargets[path]; ok {
+			return target, true
+		}
+	}
+	target, ok := c.settings.SLOTargets[defaultSLORoute]
+	return target, ok
+}
+
+// SLOAlertWebhook returns the webhook URL SLO alerts are posted to, if configured
+func (c *RuntimeConfig) SLOAlertWebhook() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.settings.SLOAlertWebhook
+}
+
+// RealtimeBatching returns how long change notifications wait for a burst to settle, and the
+// longest a batch may be held back while changes keep arriving
+func (c *RuntimeConfig) RealtimeBatching() (debounce, maxDelay time.Duration) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.settings.RealtimeDebounce, c.settings.RealtimeMaxDelay
+}
+
+// ClientErrorSampleRate returns the fraction of new client error reports that are stored
+func (c *RuntimeConfig) ClientErrorSampleRate() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.settings.ClientErrorSampleRate
+}
+
+// SyncLimits returns the limits enforced on sync pushes
+func (c *RuntimeConfig) SyncLimits() SyncLimits {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.settings.SyncLimits
+}
+
+// LogEnabled reports whether messages at the given level should be logged
+func (c *RuntimeConfig) LogEnabled(level string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return logLevelSeverity[level] >= logLevelSeverity[c.settings.LogLevel]
+}
+
+// parseRateLimit parses "group=requests/window", e.g. "ai=30/1m"
+func parseRateLimit(entry string) (string, RateLimit, error) {
+	group, spec, ok := strings.Cut(entry, "=")
+	requests, window, ok2 := strings.Cut(spec, "/")
+	if !ok || !ok2 || strings.TrimSpace(group) == "" {
+		return "", RateLimit{}, fmt.Errorf("invalid rate limit %q (expected group=requests/window)", entry)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n <= 0 {
+		return "", RateLimit{}, fmt.Errorf("invalid request count in rate limit %q", entry)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil || d <= 0 {
+		return "", RateLimit{}, fmt.Errorf("invalid window in rate limit %q", entry)
+	}
+	return strings.TrimSpace(group), RateLimit{Requests: n, Window: d}, nil
+}
+
+// parseSLOTarget parses "route=successRate%/p95", e.g. "/api/chat=99%/30s"
+func parseSLOTarget(entry string) (string, SLOTarget, error) {
+	route, spec, ok := strings.Cut(entry, "=")
+	rate, latency, ok2 := strings.Cut(spec, "/")
+	if !ok || !ok2 || strings.TrimSpace(route) == "" {
+		return "", SLOTarget{}, fmt.Errorf("invalid SLO target %q (expected route=rate%%/p95)", entry)
+	}
+
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(rate), "%"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return "", SLOTarget{}, fmt.Errorf("invalid success rate in SLO target %q", entry)
+	}
+	p95, err := time.ParseDuration(strings.TrimSpace(latency))
+	if err != nil || p95 <= 0 {
+		return "", SLOTarget{}, fmt.Errorf("invalid p95 latency in SLO target %q", entry)
+	}
+	return strings.TrimSpace(route), SLOTarget{SuccessRate: percent / 100, P95Latency: p95}, nil
+}
+
+// parseDurationEnv reads a positive duration from an environment variable, using fallback if it's unset
+func parseDurationEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return d, nil
+}
+
+// parsePositiveIntEnv reads a positive integer from an environment variable, using fallback if it's unset
+func parsePositiveIntEnv(name string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
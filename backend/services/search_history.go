@@ -0,0 +1,133 @@
+// Per-user search history and search box suggestions
+package services
+
+import (
+	"backend/models"
+	"context"
+	"log"
+	"strings"
+)
+
+const (
+	// maxSearchHistory is how many distinct recent searches are kept per user
+	maxSearchHistory = 50
+	// maxSearchHistoryQueryLength is the longest query recorded (longer ones aren't worth suggesting)
+	maxSearchHistoryQueryLength = 200
+)
+
+// RecordSearch adds a query to the user's recent searches, or moves it to the top if they searched for
+// it before, and drops searches beyond the most recent maxSearchHistory
+func (d *Database) RecordSearch(ctx context.Context, userID, query string) (err error) {
+	query = strings.TrimSpace(query)
+	if query == "" || len(query) > maxSearchHistoryQueryLength {
+		return nil
+	}
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back search history: %v", rbErr)
+			}
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO search_history (user_id, query_key, query, searched_at)
+		VALUES ($1, lower($2), $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, query_key) DO UPDATE SET query = EXCLUDED.query, searched_at = EXCLUDED.searched_at
+	`, userID, query); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM search_history
+		WHERE user_id = $1 AND query_key NOT IN (
+			SELECT query_key FROM search_history WHERE user_id = $1 ORDER BY searched_at DESC LIMIT $2
+		)
+	`, userID, maxSearchHistory); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecentSearches returns up to limit of the user's recent searches, most recent first
+func (d *Database) RecentSearches(ctx context.Context, userID string, limit int) ([]models.RecentSearch, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT query, searched_at FROM search_history
+		WHERE user_id = $1
+		ORDER BY searched_at DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	searches := []models.RecentSearch{}
+	for rows.Next() {
+		var search models.RecentSearch
+		if err := rows.Scan(&search.Query, &search.SearchedAt); err != nil {
+			return nil, err
+		}
+		searches = append(searches, search)
+	}
+	return searches, rows.Err()
+}
+
+// ClearSearches deletes the user's search history
+func (d *Database) ClearSearches(ctx context.Context, userID string) error {
+	_, err := d.DB.ExecContext(ctx, `DELETE FROM search_history WHERE user_id = $1`, userID)
+	return err
+}
+
+// SearchSuggestions returns up to limit of the user's recent searches, then tags, then titles of live
+// notes matching a LIKE pattern (case-insensitively), without repeating a text
+func (d *Database) SearchSuggestions(ctx context.Context, userID, pattern string, limit int) ([]models.SearchSuggestion, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT text, kind FROM (
+			(SELECT query AS text, 'query' AS kind, 0 AS grp, searched_at AS recency FROM search_history
+			 WHERE user_id = $1 AND query ILIKE $2
+			 ORDER BY searched_at DESC LIMIT $3)
+			UNION ALL
+			(SELECT t, 'tag', 1, MAX(n.updated_at) FROM notes n, unnest(n.tags) t
+			 WHERE n.user_id = $1 AND n.deleted_at IS NULL AND t ILIKE $2
+			 GROUP BY t ORDER BY MAX(n.updated_at) DESC LIMIT $3)
+			UNION ALL
+			(SELECT title, 'title', 2, updated_at FROM notes
+			 WHERE user_id = $1 AND deleted_at IS NULL AND title ILIKE $2
+			 ORDER BY updated_at DESC LIMIT $3)
+		) s
+		ORDER BY grp, recency DESC
+	`, userID, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	suggestions := []models.SearchSuggestion{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var suggestion models.SearchSuggestion
+		if err := rows.Scan(&suggestion.Text, &suggestion.Kind); err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(strings.TrimSpace(suggestion.Text))
+		if key == "" || seen[key] || len(suggestions) == limit {
+			continue
+		}
+		seen[key] = true
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, rows.Err()
+}
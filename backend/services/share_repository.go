@@ -0,0 +1,305 @@
+// Share link storage: links to notes, their views, and view analytics
+package services
+
+import (
+	"backend/models"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// Share link errors
+var (
+	ErrShareNoteNotFound = errors.New("note not found")       // The note doesn't exist or belongs to another user
+	ErrShareLinkNotFound = errors.New("share link not found") // The link doesn't exist (or isn't the user's)
+)
+
+// shareLinkColumns are the columns read by scanShareLink
+const shareLinkColumns = `id, note_id, password_hash IS NOT NULL, expires_at, view_count, max_views, last_viewed_at, created_at`
+
+// NewShareLink is a share link to store. Only the token's hash is kept.
+type NewShareLink struct {
+	ID           string
+	UserID       string
+	NoteID       string
+	TokenHash    string
+	Content      []byte // The note encrypted under the link's key
+	IV           []byte
+	PasswordHash *string
+	ExpiresAt    *time.Time
+	MaxViews     *int
+	DeviceID     string // Recorded on the note's timeline
+}
+
+// SharedNoteLink is a share link looked up by its token, with what's needed to decide whether to serve it
+type SharedNoteLink struct {
+	ID           string
+	Note         models.SharedNote // Title and SharedAt; the content is in Content and IV
+	Content      []byte
+	IV           []byte
+	PasswordHash string // Empty if the link has no password
+	ExpiresAt    *time.Time
+	Revoked      bool
+	NoteDeleted  bool
+	Limited      bool // The link has a view limit
+	LimitReached bool
+}
+
+// HasNote reports whether the user has the note, including in the trash
+func (d *Database) HasNote(ctx context.Context, userID, noteID string) (bool, error) {
+	var exists bool
+	err := d.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM notes WHERE id = $1 AND user_id = $2)
+	`, noteID, userID).Scan(&exists)
+	return exists, err
+}
+
+// ShareLinks returns the note's live links, newest first
+func (d *Database) ShareLinks(ctx context.Context, userID, noteID string) ([]models.ShareLink, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT `+shareLinkColumns+`
+		FROM share_links
+		WHERE note_id = $1 AND user_id = $2 AND revoked_at IS NULL
+		ORDER BY created_at DESC, id
+	`, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	links := []models.ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// ShareLink returns one of the note's links, revoked or not, or ErrShareLinkNotFound
+func (d *Database) ShareLink(ctx context.Context, userID, noteID, shareID string) (models.ShareLink, error) {
+	link, err := scanShareLink(d.DB.QueryRowContext(ctx, `
+		SELECT `+shareLinkColumns+` FROM share_links WHERE id = $1 AND note_id = $2 AND user_id = $3
+	`, shareID, noteID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return link, ErrShareLinkNotFound
+	}
+	return link, err
+}
+
+// CreateShareLink stores a link to a live note of the user, snapshotting its title, and records it on
+// the note's timeline. It returns ErrShareNoteNotFound unless the note is the user's and live.
+func (d *Database) CreateShareLink(ctx context.Context, share NewShareLink) (link models.ShareLink, err error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back share link: %v", rbErr)
+			}
+		}
+	}()
+
+	link, err = scanShareLink(tx.QueryRowContext(ctx, `
+		INSERT INTO share_links (id, user_id, note_id, token_hash, title, content_encrypted, content_iv,
+		                         password_hash, expires_at, max_views)
+		SELECT $1, user_id, id, $4, title, $5, $6, $7, $8, $9
+		FROM notes
+		WHERE id = $3 AND user_id = $2 AND deleted_at IS NULL
+		RETURNING `+shareLinkColumns,
+		share.ID, share.UserID, share.NoteID, share.TokenHash, share.Content, share.IV, share.PasswordHash,
+		share.ExpiresAt, share.MaxViews))
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrShareNoteNotFound
+		return link, err
+	}
+	if err != nil {
+		return link, err
+	}
+	if err = RecordNoteEvent(ctx, tx, share.UserID, share.NoteID, models.NoteEventShared, share.DeviceID, ""); err != nil {
+		return link, err
+	}
+
+	err = tx.Commit()
+	return link, err
+}
+
+// RevokeShareLinks revokes one of the note's live links, or all of them if shareID is empty, and returns
+// how many were revoked. Revoking any records the unshare on the note's timeline.
+func (d *Database) RevokeShareLinks(ctx context.Context, userID, noteID, shareID, deviceID string) (int64, error) {
+	result, err := d.DB.ExecContext(ctx, `
+		UPDATE share_links SET revoked_at = CURRENT_TIMESTAMP
+		WHERE note_id = $1 AND user_id = $2 AND revoked_at IS NULL AND ($3 = '' OR id = $3)
+	`, noteID, userID, shareID)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if revoked > 0 {
+		if err := RecordNoteEvent(ctx, d.DB, userID, noteID, models.NoteEventUnshared, deviceID, ""); err != nil {
+			log.Printf("Error recording unshare of note %s: %v", noteID, err)
+		}
+	}
+	return revoked, nil
+}
+
+// SharedNoteByToken looks up a link by its token's hash, or returns ErrShareLinkNotFound
+func (d *Database) SharedNoteByToken(ctx context.Context, tokenHash string) (SharedNoteLink, error) {
+	var shared SharedNoteLink
+	var passwordHash sql.NullString
+	var expiresAt, revokedAt sql.NullTime
+	err := d.DB.QueryRowContext(ctx, `
+		SELECT s.id, s.title, s.content_encrypted, s.content_iv, s.password_hash, s.expires_at, s.revoked_at,
+		       s.max_views IS NOT NULL, s.view_count >= COALESCE(s.max_views, s.view_count + 1), s.created_at,
+		       n.deleted_at IS NOT NULL
+		FROM share_links s
+		JOIN notes n ON n.id = s.note_id
+		WHERE s.token_hash = $1
+	`, tokenHash).Scan(&shared.ID, &shared.Note.Title, &shared.Content, &shared.IV, &passwordHash, &expiresAt,
+		&revokedAt, &shared.Limited, &shared.LimitReached, &shared.Note.SharedAt, &shared.NoteDeleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return shared, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return shared, err
+	}
+	shared.PasswordHash = passwordHash.String
+	shared.Revoked = revokedAt.Valid
+	if expiresAt.Valid {
+		shared.ExpiresAt = &expiresAt.Time
+	}
+	return shared, nil
+}
+
+// CountShareView counts a view of a link along with its referring host, unless the link's view limit has
+// been reached, in which case it returns false
+func (d *Database) CountShareView(ctx context.Context, shareID, referrerHost string) (counted bool, err error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil || !counted {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back share link view: %v", rbErr)
+			}
+		}
+	}()
+
+	// The limit is checked in the update itself, so concurrent viewers can't exceed it
+	result, err := tx.ExecContext(ctx, `
+		UPDATE share_links SET view_count = view_count + 1, last_viewed_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (max_views IS NULL OR view_count < max_views)
+	`, shareID)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil || updated == 0 {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO share_link_views (share_link_id, referrer_host) VALUES ($1, $2)
+	`, shareID, referrerHost); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ShareViewsByDay returns a link's views in [since, end) by UTC day (YYYY-MM-DD); days without views
+// are left out
+func (d *Database) ShareViewsByDay(ctx context.Context, shareID string, since, end time.Time) (map[string]int, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT to_char(viewed_at AT TIME ZONE 'UTC', 'YYYY-MM-DD'), COUNT(*)
+		FROM share_link_views
+		WHERE share_link_id = $1 AND viewed_at >= $2 AND viewed_at < $3
+		GROUP BY 1
+	`, shareID, since, end)
+	if err != nil {
+		return nil, err
+	}
+	viewsByDay := map[string]int{}
+	err = scanCounts(rows, func(day string, views int) {
+		viewsByDay[day] = views
+	})
+	return viewsByDay, err
+}
+
+// ShareReferrers returns the limit sites that referred the most views of a link in [since, end), most
+// views first
+func (d *Database) ShareReferrers(ctx context.Context, shareID string, since, end time.Time, limit int) ([]models.ShareLinkReferrers, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT referrer_host, COUNT(*)
+		FROM share_link_views
+		WHERE share_link_id = $1 AND viewed_at >= $2 AND viewed_at < $3
+		GROUP BY referrer_host
+		ORDER BY COUNT(*) DESC, referrer_host
+		LIMIT $4
+	`, shareID, since, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	referrers := []models.ShareLinkReferrers{}
+	err = scanCounts(rows, func(host string, views int) {
+		referrers = append(referrers, models.ShareLinkReferrers{Host: host, Views: views})
+	})
+	return referrers, err
+}
+
+// Helper functions
+
+// scanCounts reads (key, count) rows, passing each to add, and closes them
+func scanCounts(rows *sql.Rows, add func(key string, count int)) error {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		var key string
+		var count int
+		if err := rows.Scan(&key, &count); err != nil {
+			return err
+		}
+		add(key, count)
+	}
+	return rows.Err()
+}
+
+func scanShareLink(row RowScanner) (models.ShareLink, error) {
+	var link models.ShareLink
+	var expiresAt, lastViewedAt sql.NullTime
+	var maxViews sql.NullInt64
+	if err := row.Scan(&link.ID, &link.NoteID, &link.HasPassword, &expiresAt, &link.ViewCount, &maxViews,
+		&lastViewedAt, &link.CreatedAt); err != nil {
+		return link, err
+	}
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	if maxViews.Valid {
+		limit := int(maxViews.Int64)
+		link.MaxViews = &limit
+	}
+	if lastViewedAt.Valid {
+		link.LastViewedAt = &lastViewedAt.Time
+	}
+	return link, nil
+}
@@ -0,0 +1,207 @@
+// Service-level objective monitoring with webhook alerts
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sloWindow is the rolling window SLOs are evaluated over
+	sloWindow = 5 * time.Minute
+	// sloEvaluateInterval is how often SLOs are evaluated
+	sloEvaluateInterval = 30 * time.Second
+	// sloMinRequests is the traffic needed before a route is judged, so one failure can't page anyone
+	sloMinRequests = 20
+	// sloAlertTimeout bounds posting an alert to the webhook
+	sloAlertTimeout = 10 * time.Second
+)
+
+// SLOStatus is the current SLO state of a route
+type SLOStatus struct {
+	RouteStats
+	Target    SLOTarget  `json:"target"`
+	Violating bool       `json:"violating"`
+	Reasons   []string   `json:"reasons,omitempty"`
+	Since     *time.Time `json:"since,omitempty"` // When the current violation started
+}
+
+// sloAlert is the webhook payload; "text" makes it render directly in Slack-compatible webhooks
+type sloAlert struct {
+	Text        string    `json:"text"`
+	Status      string    `json:"status"` // "violated" or "resolved"
+	Route       string    `json:"route"`
+	Region      string    `json:"region,omitempty"`
+	Requests    int       `json:"requests"`
+	SuccessRate float64   `json:"successRate"`
+	P95Ms       int64     `json:"p95Ms"`
+	Target      SLOTarget `json:"target"`
+	At          time.Time `json:"at"`
+}
+
+// SLOMonitor periodically evaluates route metrics against SLO targets and alerts on violations
+type SLOMonitor struct {
+	metrics *RequestMetrics
+	client  *http.Client
+
+	mu         sync.Mutex
+	violations map[string]time.Time // Route -> violation start
+	statuses   []SLOStatus
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSLOMonitor creates a new SLOMonitor and starts evaluating
+func NewSLOMonitor(metrics *RequestMetrics) *SLOMonitor {
+	m := &SLOMonitor{
+		metrics:    metrics,
+		client:     &http.Client{Timeout: sloAlertTimeout},
+		violations: make(map[string]time.Time),
+		done:       make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go m.loop()
+	return m
+}
+
+// Close stops evaluating
+func (m *SLOMonitor) Close() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+// Statuses returns the result of the latest evaluation
+func (m *SLOMonitor) Statuses() []SLOStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SLOStatus(nil), m.statuses...)
+}
+
+func (m *SLOMonitor) loop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(sloEvaluateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.evaluate()
+		}
+	}
+}
+
+// evaluate checks every route against its target and alerts on violation and recovery transitions
+func (m *SLOMonitor) evaluate() {
+	now := time.Now()
+	var statuses []SLOStatus
+	var alerts []sloAlert
+
+	m.mu.Lock()
+	for _, stats := range m.metrics.Stats(sloWindow) {
+		target, ok := Config.SLOTargetFor(stats.Route)
+		if !ok || stats.Requests < sloMinRequests {
+			continue
+		}
+
+		status := SLOStatus{RouteStats: stats, Target: target}
+		if stats.SuccessRate < target.SuccessRate {
+			status.Reasons = append(status.Reasons, fmt.Sprintf("success rate %.2f%% below %.2f%%", stats.SuccessRate*100, target.SuccessRate*100))
+		}
+		if stats.P95Latency > target.P95Latency {
+			status.Reasons = append(status.Reasons, fmt.Sprintf("p95 latency %s above %s", stats.P95Latency.Round(time.Millisecond), target.P95Latency))
+		}
+		status.Violating = len(status.Reasons) > 0
+
+		since, wasViolating := m.violations[stats.Route]
+		switch {
+		case status.Violating && !wasViolating:
+			since = now
+			m.violations[stats.Route] = since
+			alerts = append(alerts, newSLOAlert("violated", status, now))
+		case !status.Violating && wasViolating:
+			delete(m.violations, stats.Route)
+			alerts = append(alerts, newSLOAlert("resolved", status, now))
+		}
+		if status.Violating {
+			status.Since = &since
+		}
+		statuses = append(statuses, status)
+	}
+	m.statuses = statuses
+	m.mu.Unlock()
+
+	for _, alert := range alerts {
+		Warnf("SLO %s: %s", alert.Status, alert.Text)
+		m.sendAlert(alert)
+	}
+}
+
+func newSLOAlert(state string, status SLOStatus, at time.Time) sloAlert {
+	route := status.Route
+	if status.Region != "" {
+		route += " in " + status.Region
+	}
+	text := fmt.Sprintf("SLO resolved for %s", route)
+	if state == "violated" {
+		text = fmt.Sprintf("SLO violated for %s: %s", route, strings.Join(status.Reasons, ", "))
+	}
+	return sloAlert{
+		Text:        text,
+		Status:      state,
+		Route:       status.Route,
+		Region:      status.Region,
+		Requests:    status.Requests,
+		SuccessRate: status.SuccessRate,
+		P95Ms:       status.P95Latency.Milliseconds(),
+		Target:      status.Target,
+		At:          at,
+	}
+}
+
+// sendAlert posts an alert to the configured webhook (no-op if none is configured)
+func (m *SLOMonitor) sendAlert(alert sloAlert) {
+	url := Config.SLOAlertWebhook()
+	if url == "" {
+		return
+	}
+
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Error encoding SLO alert: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sloAlertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("Error creating SLO alert request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		log.Printf("Error sending SLO alert for %s: %v", alert.Route, err)
+		return
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Error closing SLO alert response: %v", err)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		log.Printf("SLO alert webhook returned %d for %s", resp.StatusCode, alert.Route)
+	}
+}
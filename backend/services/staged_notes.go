@@ -0,0 +1,129 @@
+// Staged notes inbox for server-originated plaintext content
+package services
+
+import (
+	"backend/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// StagedNoteTTL is how long plaintext staged notes are kept before they're discarded
+	StagedNoteTTL = 30 * 24 * time.Hour
+	// StagedClaimTTL is how long a client's claim on a staged note lasts
+	StagedClaimTTL = 10 * time.Minute
+)
+
+// StageNotes stores notes in the user's staged notes inbox in a single transaction and returns their IDs.
+// Notes whose content was staged for the user before (or earlier in the same batch) are reported as
+// duplicates and, depending on onDuplicate (models.DuplicatesSkip or models.DuplicatesFlag), left out
+// or staged with their duplicate flag set.
+func (d *Database) StageNotes(ctx context.Context, userID, source, sourceRef string, notes []models.ImportedNote, onDuplicate string) (ids []string, duplicates []models.DuplicateNote, err error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back staged notes: %v", rbErr)
+			}
+		}
+	}()
+
+	query := `
+		INSERT INTO staged_notes (id, user_id, source, source_ref, title, content, collection_name, tags, original_date,
+		                          content_hash, is_duplicate, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + $12 * INTERVAL '1 second')
+	`
+	ids = make([]string, 0, len(notes))
+	duplicates = []models.DuplicateNote{}
+	for _, note := range notes {
+		hash := ContentHash(userID, note.Content)
+		var firstSeenAt *time.Time
+		if hash != "" {
+			if firstSeenAt, err = recordContentHash(ctx, tx, userID, hash, source); err != nil {
+				return nil, nil, err
+			}
+		}
+		if firstSeenAt != nil && onDuplicate != models.DuplicatesFlag {
+			duplicates = append(duplicates, models.DuplicateNote{SourcePath: note.SourcePath, Title: note.Title, FirstSeenAt: *firstSeenAt})
+			continue
+		}
+
+		idBytes := make([]byte, 12)
+		if _, err := rand.Read(idBytes); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate staged note ID: %w", err)
+		}
+		id := "stg_" + hex.EncodeToString(idBytes)
+
+		tags := note.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		if _, err = tx.ExecContext(ctx, query, id, userID, source, nullableString(sourceRef), note.Title, note.Content,
+			nullableString(note.Collection), tags, note.Date, nullableString(hash), firstSeenAt != nil, int(StagedNoteTTL.Seconds())); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		if firstSeenAt != nil {
+			duplicates = append(duplicates, models.DuplicateNote{SourcePath: note.SourcePath, Title: note.Title, StagedNoteID: id, FirstSeenAt: *firstSeenAt})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return ids, duplicates, nil
+}
+
+// recordContentHash adds a content hash to the user's staged content, returning when it was first
+// seen if it was already there (nil if it's new)
+func recordContentHash(ctx context.Context, tx *sql.Tx, userID, hash, source string) (*time.Time, error) {
+	var inserted string
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO staged_content_hashes (user_id, content_hash, source)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, content_hash) DO NOTHING
+		RETURNING content_hash
+	`, userID, hash, source).Scan(&inserted)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var firstSeenAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT first_seen_at FROM staged_content_hashes WHERE user_id = $1 AND content_hash = $2
+	`, userID, hash).Scan(&firstSeenAt)
+	return &firstSeenAt, err
+}
+
+// ContentHash returns the user-salted hash duplicates are detected by, or "" for blank content.
+// Content is normalized first so line endings, a BOM, and trailing whitespace don't defeat detection.
+func ContentHash(userID, content string) string {
+	content = strings.TrimPrefix(content, "\ufeff")
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	normalized := strings.TrimSpace(strings.Join(lines, "\n"))
+	if normalized == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(userID + "\x00" + normalized))
+	return hex.EncodeToString(sum[:])
+}
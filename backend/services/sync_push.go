@@ -0,0 +1,692 @@
+// Atomic application of sync pushes
+package services
+
+import (
+	"backend/models"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// SyncPush is the outcome of applying a push
+type SyncPush struct {
+	Results       []models.SyncItemResult
+	Conflicts     []models.SyncConflict
+	NoteIDs       []string           // Notes created or changed, including conflict copies
+	CollectionIDs []string           // Collections created or changed
+	Embed         []*models.SyncNote // Notes whose embeddingText should be indexed
+}
+
+// MarkRolledBack marks every result that wasn't rejected as rolled back
+func MarkRolledBack(results []models.SyncItemResult) {
+	for i := range results {
+		if results[i].Status != models.SyncItemRejected {
+			results[i].Status, results[i].Reason = models.SyncItemRejected, models.SyncRejectRolledBack
+		}
+	}
+}
+
+// Errors returned by ApplyPush when it rejected items without failing
+var (
+	ErrPushForbidden = errors.New("push contains items owned by another user")
+	ErrPushConflicts = errors.New("push contains conflicting notes") // Under the reject policy
+)
+
+// ApplyPush applies a validated, normalized push in a single transaction: either every item is applied or none is.
+// On error, the results mark the items that were rejected and every other item as rolled back. A note
+// locked by a destructive operation fails the push with a NoteLockedError. Items
+// owned by another user and (under the reject policy) conflicts are all collected before rolling back,
+// returning ErrPushForbidden or ErrPushConflicts. Items are written in batches (see pushBatches), a few
+// multi-row statements each, rather than one item at a time.
+func (d *Database) ApplyPush(ctx context.Context, userID, deviceID, policy string, req *models.SyncRequest) (push SyncPush, err error) {
+	push.Results = make([]models.SyncItemResult, 0, len(req.Collections)+len(req.Notes))
+	for i := range req.Collections {
+		push.Results = append(push.Results, models.SyncItemResult{Type: "collection", ID: req.Collections[i].ID, Status: models.SyncItemAccepted})
+	}
+	for i := range req.Notes {
+		push.Results = append(push.Results, models.SyncItemResult{Type: "note", ID: req.Notes[i].ID, Status: models.SyncItemAccepted})
+	}
+	reject := func(index int, reason, message string) {
+		push.Results[index].Status = models.SyncItemRejected
+		push.Results[index].Reason, push.Results[index].Error = reason, message
+	}
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		MarkRolledBack(push.Results)
+		return push, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			log.Printf("Error rolling back sync push: %v", rbErr)
+		}
+		MarkRolledBack(push.Results)
+		if !errors.Is(err, ErrPushForbidden) && !errors.Is(err, ErrPushConflicts) {
+			push.Conflicts = nil
+		}
+		push.NoteIDs, push.CollectionIDs, push.Embed = nil, nil, nil
+	}()
+
+	forbidden := false
+	rejectNotOwned := func(index int, itemType string) {
+		reject(index, models.SyncRejectForbidden, "The "+itemType+" belongs to another account")
+		forbidden = true
+	}
+	rejectFailed := func(indexes []int, offset int) {
+		for _, i := range indexes {
+			reject(offset+i, models.SyncRejectFailed, "Failed to apply change")
+		}
+	}
+
+	// Collections first, so notes can be filed into collections created by the same push. A collection
+	// with a parent starts a new batch, as parents are checked against what has been applied so far.
+	collectionBatches := pushBatches(len(req.Collections), func(i int) string { return req.Collections[i].ID }, func(i int) bool {
+		return req.Collections[i].ParentID != nil && *req.Collections[i].ParentID != ""
+	})
+	for _, batch := range collectionBatches {
+		colls := make([]*models.SyncCollection, 0, len(batch))
+		for _, i := range batch {
+			colls = append(colls, &req.Collections[i])
+		}
+		var notOwned map[string]bool
+		if notOwned, err = d.upsertCollections(ctx, tx, userID, colls); err != nil {
+			rejectFailed(batch, 0)
+			return push, fmt.Errorf("upserting %d collections: %w", len(colls), err)
+		}
+		for _, i := range batch {
+			if notOwned[req.Collections[i].ID] {
+				rejectNotOwned(i, "collection")
+				continue
+			}
+			push.CollectionIDs = append(push.CollectionIDs, req.Collections[i].ID)
+		}
+	}
+
+	rejectedConflicts := false
+	offset := len(req.Collections)
+	for _, batch := range pushBatches(len(req.Notes), func(i int) string { return req.Notes[i].ID }, nil) {
+		var deletes []string
+		var deleteIndexes, upsertIndexes []int
+		for _, i := range batch {
+			if req.Notes[i].DeletedAt != nil {
+				deletes, deleteIndexes = append(deletes, req.Notes[i].ID), append(deleteIndexes, i)
+			} else {
+				upsertIndexes = append(upsertIndexes, i)
+			}
+		}
+
+		// Soft deletes
+		if len(deletes) > 0 {
+			var notOwned map[string]bool
+			if notOwned, err = d.deleteNotes(ctx, tx, userID, deviceID, deletes); err != nil {
+				rejectFailed(deleteIndexes, offset)
+				return push, fmt.Errorf("deleting %d notes: %w", len(deletes), err)
+			}
+			for _, i := range deleteIndexes {
+				if notOwned[req.Notes[i].ID] {
+					rejectNotOwned(offset+i, "note")
+					continue
+				}
+				push.NoteIDs = append(push.NoteIDs, req.Notes[i].ID)
+			}
+		}
+		if len(upsertIndexes) == 0 {
+			continue
+		}
+
+		upserts := make([]*models.SyncNote, 0, len(upsertIndexes))
+		for _, i := range upsertIndexes {
+			upserts = append(upserts, &req.Notes[i])
+		}
+		var stale map[string]sql.NullString
+		if stale, err = staleNotes(ctx, tx, userID, upserts); err != nil {
+			rejectFailed(upsertIndexes, offset)
+			return push, fmt.Errorf("checking %d notes for conflicts: %w", len(upserts), err)
+		}
+
+		writes := make([]*models.SyncNote, 0, len(upserts))
+		writeIndexes := make([]int, 0, len(upsertIndexes))
+		for j, note := range upserts {
+			index := offset + upsertIndexes[j]
+			if storedDevice, ok := stale[note.ID]; ok {
+				var conflict *models.SyncConflict
+				if conflict, err = d.resolveConflict(ctx, tx, userID, deviceID, note, storedDevice, policy); err != nil {
+					reject(index, models.SyncRejectFailed, "Failed to apply change")
+					return push, fmt.Errorf("resolving conflict for note %s: %w", note.ID, err)
+				}
+				if conflict != nil {
+					push.Conflicts = append(push.Conflicts, *conflict)
+					if policy == models.ConflictReject {
+						// Keep checking the rest so the client can merge every conflict at once
+						reject(index, models.SyncRejectConflict, "Note changed on the server since baseUpdatedAt")
+						rejectedConflicts = true
+						continue
+					}
+					if conflict.CopyID != "" {
+						push.NoteIDs = append(push.NoteIDs, conflict.CopyID)
+					}
+				}
+			}
+			writes, writeIndexes = append(writes, note), append(writeIndexes, upsertIndexes[j])
+		}
+		if len(writes) == 0 {
+			continue
+		}
+
+		var notOwned map[string]bool
+		if notOwned, err = d.upsertNotes(ctx, tx, userID, deviceID, writes); err != nil {
+			rejectFailed(writeIndexes, offset)
+			return push, fmt.Errorf("upserting %d notes: %w", len(writes), err)
+		}
+		for j, note := range writes {
+			if notOwned[note.ID] {
+				rejectNotOwned(offset+writeIndexes[j], "note")
+				continue
+			}
+			if note.EmbeddingText != nil {
+				push.Embed = append(push.Embed, note)
+			}
+			push.NoteIDs = append(push.NoteIDs, note.ID)
+		}
+	}
+
+	if forbidden {
+		err = ErrPushForbidden
+		return push, err
+	}
+	if rejectedConflicts {
+		err = ErrPushConflicts
+		return push, err
+	}
+
+	// The handler checked note locks before the push, but one may have been taken since. Checking again
+	// now that this transaction holds the notes' rows closes the gap: a holder that locks a note after
+	// this point waits for the push to commit before it can touch the note.
+	if err = checkNoteLocks(ctx, tx, userID, req.Notes); err != nil {
+		return push, err
+	}
+	err = tx.Commit()
+	return push, err
+}
+
+// checkNoteLocks returns a NoteLockedError if any of the pushed notes is locked
+func checkNoteLocks(ctx context.Context, tx *sql.Tx, userID string, notes []models.SyncNote) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	noteIDs := make([]string, 0, len(notes))
+	for i := range notes {
+		noteIDs = append(noteIDs, notes[i].ID)
+	}
+	locks, err := activeNoteLocks(ctx, tx, userID, noteIDs)
+	if err != nil {
+		return fmt.Errorf("checking note locks: %w", err)
+	}
+	if len(locks) > 0 {
+		return &NoteLockedError{Locks: locks}
+	}
+	return nil
+}
+
+// upsertCollections writes a batch of pushed collections (each with a distinct ID) in one statement,
+// returning the IDs that belong to another user and so were left unchanged
+func (d *Database) upsertCollections(ctx context.Context, tx *sql.Tx, userID string, colls []*models.SyncCollection) (map[string]bool, error) {
+	args := make([]interface{}, 0, 1+len(colls)*len(collectionUpsertTypes))
+	args = append(args, userID)
+	for _, coll := range colls {
+		// A parent that's unknown or would create a cycle is dropped rather than failing the collection
+		if coll.ParentID != nil && *coll.ParentID != "" {
+			err := ValidateCollectionParent(ctx, tx, userID, coll.ID, *coll.ParentID)
+			if errors.Is(err, ErrCollectionNotFound) || errors.Is(err, ErrCollectionCycle) {
+				log.Printf("Ignoring invalid parent for collection %s: %v", coll.ID, err)
+				coll.ParentID = nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		args = append(args, coll.ID, coll.Name, coll.Icon, coll.Color, coll.Description, coll.SortIndex, coll.Cover,
+			coll.ParentID, coll.CreatedAt, coll.UpdatedAt)
+	}
+
+	// Appearance fields and parents a client doesn't send keep their stored values. Collections that
+	// exist under another user are neither updated nor inserted.
+	written, err := queryStrings(ctx, tx, `
+		WITH pushed (id, name, icon, color, description, sort_index, cover, parent_id, created_at, updated_at) AS (
+			VALUES `+valuesRows(len(colls), 2, collectionUpsertTypes)+`
+		),
+		updated AS (
+			UPDATE collections c SET
+				name = p.name,
+				icon = p.icon,
+				color = CASE WHEN p.color IS NULL THEN c.color ELSE NULLIF(p.color, '') END,
+				description = CASE WHEN p.description IS NULL THEN c.description ELSE NULLIF(p.description, '') END,
+				sort_index = COALESCE(p.sort_index, c.sort_index),
+				cover = CASE WHEN p.cover IS NULL THEN c.cover ELSE NULLIF(p.cover, '') END,
+				parent_id = CASE WHEN p.parent_id IS NULL THEN c.parent_id ELSE NULLIF(p.parent_id, '') END,
+				updated_at = p.updated_at
+			FROM pushed p
+			WHERE c.id = p.id AND c.user_id = $1
+			RETURNING c.id
+		),
+		inserted AS (
+			INSERT INTO collections (id, user_id, name, icon, color, description, sort_index, cover, parent_id, created_at, updated_at)
+			SELECT p.id, $1, p.name, p.icon, NULLIF(p.color, ''), NULLIF(p.description, ''), COALESCE(p.sort_index, 0),
+			       NULLIF(p.cover, ''), NULLIF(p.parent_id, ''), p.created_at, p.updated_at
+			FROM pushed p
+			WHERE NOT EXISTS (SELECT 1 FROM collections c WHERE c.id = p.id)
+			RETURNING id
+		)
+		SELECT id FROM updated
+		UNION ALL
+		SELECT id FROM inserted
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(colls))
+	for _, coll := range colls {
+		ids = append(ids, coll.ID)
+	}
+	return notWritten(ids, written), nil
+}
+
+// upsertNotes writes a batch of pushed notes (each with a distinct ID) with their revisions, timeline
+// events, and collections in a handful of statements, returning the IDs that belong to another user and
+// so were left unchanged
+func (d *Database) upsertNotes(ctx context.Context, tx *sql.Tx, userID, deviceID string, notes []*models.SyncNote) (map[string]bool, error) {
+	args := make([]interface{}, 0, 2+len(notes)*len(noteUpsertTypes))
+	args = append(args, userID, deviceID)
+	ids := make([]string, 0, len(notes))
+	titles := make([]string, 0, len(notes))
+	contents := make([][]byte, 0, len(notes))
+	for _, note := range notes {
+		// ContentEncrypted and ContentIV are base64 strings from frontend
+		// Decode them to []byte for database storage
+		contentEncrypted, err := base64.StdEncoding.DecodeString(note.ContentEncrypted)
+		if err != nil {
+			return nil, err
+		}
+		contentIV, err := base64.StdEncoding.DecodeString(note.ContentIV)
+		if err != nil {
+			return nil, err
+		}
+
+		// The source page is replaced as a whole; "" clears it
+		var sourceEncrypted, sourceIV []byte
+		var sourceURLHash string
+		if note.SourceEncrypted != nil && *note.SourceEncrypted != "" {
+			if sourceEncrypted, err = base64.StdEncoding.DecodeString(*note.SourceEncrypted); err != nil {
+				return nil, err
+			}
+			if sourceIV, err = base64.StdEncoding.DecodeString(*note.SourceIV); err != nil {
+				return nil, err
+			}
+			if note.SourceURLHash != nil {
+				sourceURLHash = *note.SourceURLHash
+			}
+		}
+
+		args = append(args, note.ID, note.Title, contentEncrypted, contentIV, note.Domain, note.Language, note.Date,
+			note.IsPinned, note.IsArchived, note.Tags, note.ReminderAt, note.CreatedAt, note.UpdatedAt,
+			note.SourceEncrypted != nil, sourceEncrypted, sourceIV, sourceURLHash, note.AIExcluded)
+		ids, titles, contents = append(ids, note.ID), append(titles, note.Title), append(contents, contentEncrypted)
+	}
+
+	// Keep the versions being replaced, so they can be restored
+	if err := SaveNoteRevisions(ctx, tx, userID, ids, titles, contents, false); err != nil {
+		return nil, err
+	}
+
+	// Upsert the notes (clients that don't report a language, metadata, or source keep the stored values).
+	// previous is read before the writes, so saving a deleted note again is told apart from an update.
+	// Notes that exist under another user are neither updated nor inserted; one inserted concurrently
+	// fails the push, to be retried.
+	rows, err := tx.QueryContext(ctx, `
+		WITH pushed (id, title, content_encrypted, content_iv, domain, language, date, is_pinned, is_archived, tags,
+		             reminder_at, created_at, updated_at, set_source, source_encrypted, source_iv, source_url_hash,
+		             ai_excluded) AS (
+			VALUES `+valuesRows(len(notes), 3, noteUpsertTypes)+`
+		),
+		previous AS (
+			SELECT n.id, n.deleted_at FROM notes n JOIN pushed p ON p.id = n.id WHERE n.user_id = $1
+		),
+		updated AS (
+			UPDATE notes n SET
+				title = p.title,
+				content_encrypted = p.content_encrypted,
+				content_iv = p.content_iv,
+				domain = p.domain,
+				language = COALESCE(p.language, n.language),
+				date = p.date,
+				is_pinned = p.is_pinned,
+				is_archived = COALESCE(p.is_archived, n.is_archived),
+				tags = COALESCE(p.tags, n.tags),
+				reminder_at = COALESCE(p.reminder_at, n.reminder_at),
+				device_id = NULLIF($2, ''),
+				source_encrypted = CASE WHEN p.set_source THEN p.source_encrypted ELSE n.source_encrypted END,
+				source_iv = CASE WHEN p.set_source THEN p.source_iv ELSE n.source_iv END,
+				source_url_hash = CASE WHEN p.set_source THEN NULLIF(p.source_url_hash, '') ELSE n.source_url_hash END,
+				ai_excluded = COALESCE(p.ai_excluded, n.ai_excluded),
+				updated_at = p.updated_at,
+				deleted_at = NULL
+			FROM pushed p
+			WHERE n.id = p.id AND n.user_id = $1
+			RETURNING n.id
+		),
+		inserted AS (
+			INSERT INTO notes (id, user_id, title, content_encrypted, content_iv, domain, language, date, is_pinned,
+			                   is_archived, tags, reminder_at, device_id, created_at, updated_at, deleted_at,
+			                   source_encrypted, source_iv, source_url_hash, ai_excluded)
+			SELECT p.id, $1, p.title, p.content_encrypted, p.content_iv, p.domain, p.language, p.date, p.is_pinned,
+			       COALESCE(p.is_archived, FALSE), COALESCE(p.tags, '{}'), p.reminder_at, NULLIF($2, ''), p.created_at,
+			       p.updated_at, NULL, p.source_encrypted, p.source_iv, NULLIF(p.source_url_hash, ''),
+			       COALESCE(p.ai_excluded, FALSE)
+			FROM pushed p
+			WHERE NOT EXISTS (SELECT 1 FROM notes n WHERE n.id = p.id)
+			RETURNING id
+		)
+		SELECT u.id, FALSE, pr.deleted_at IS NOT NULL FROM updated u JOIN previous pr ON pr.id = u.id
+		UNION ALL
+		SELECT id, TRUE, FALSE FROM inserted
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	events := map[string]string{}
+	err = func() error {
+		defer func() {
+			if err := rows.Close(); err != nil {
+				log.Printf("Error closing rows: %v", err)
+			}
+		}()
+		for rows.Next() {
+			var id string
+			var inserted, restored bool
+			if err := rows.Scan(&id, &inserted, &restored); err != nil {
+				return err
+			}
+			events[id] = models.NoteEventUpdated
+			if inserted {
+				events[id] = models.NoteEventCreated
+			} else if restored {
+				events[id] = models.NoteEventRestored
+			}
+		}
+		return rows.Err()
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	// Timeline events and collections, in push order
+	var written, eventTypes, linkedNotes, linkedCollections []string
+	for _, note := range notes {
+		eventType, ok := events[note.ID]
+		if !ok {
+			continue
+		}
+		written, eventTypes = append(written, note.ID), append(eventTypes, eventType)
+		for _, collectionID := range note.CollectionIDs {
+			linkedNotes, linkedCollections = append(linkedNotes, note.ID), append(linkedCollections, collectionID)
+		}
+	}
+	if len(written) == 0 {
+		return notWritten(ids, written), nil
+	}
+	if err = RecordNoteEvents(ctx, tx, userID, deviceID, written, eventTypes); err != nil {
+		return nil, err
+	}
+
+	// Replace the notes' collections. Unknown collection IDs and other users' collections are skipped
+	// (a failed insert would abort the whole push's transaction).
+	if _, err = tx.ExecContext(ctx, `DELETE FROM note_collections WHERE note_id = ANY($1)`, written); err != nil {
+		return nil, err
+	}
+	if len(linkedNotes) > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO note_collections (note_id, collection_id)
+			SELECT l.note_id, c.id
+			FROM unnest($1::text[], $2::text[]) AS l(note_id, collection_id)
+			JOIN collections c ON c.id = l.collection_id AND c.user_id = $3
+			ON CONFLICT DO NOTHING
+		`, linkedNotes, linkedCollections, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Notes excluded from AI features drop their embeddings; the indexer won't compute new ones
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM note_embeddings e USING notes n
+		WHERE e.note_id = n.id AND n.id = ANY($1) AND n.ai_excluded
+	`, written); err != nil {
+		return nil, err
+	}
+
+	return notWritten(ids, written), nil
+}
+
+// staleNotes returns the pushed notes whose stored version was updated after the version their edit was
+// based on, with the device that stored it. Notes pushed without baseUpdatedAt are never stale.
+func staleNotes(ctx context.Context, tx *sql.Tx, userID string, notes []*models.SyncNote) (map[string]sql.NullString, error) {
+	var ids []string
+	var bases []time.Time
+	for _, note := range notes {
+		if note.BaseUpdatedAt != nil {
+			ids, bases = append(ids, note.ID), append(bases, *note.BaseUpdatedAt)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT n.id, n.device_id
+		FROM notes n
+		JOIN unnest($2::text[], $3::timestamptz[]) AS b(id, base_updated_at) ON b.id = n.id
+		WHERE n.user_id = $1 AND n.deleted_at IS NULL AND n.updated_at > b.base_updated_at
+	`, userID, ids, bases)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	stale := map[string]sql.NullString{}
+	for rows.Next() {
+		var id string
+		var device sql.NullString
+		if err := rows.Scan(&id, &device); err != nil {
+			return nil, err
+		}
+		stale[id] = device
+	}
+	return stale, rows.Err()
+}
+
+// resolveConflict handles a stale note (see staleNotes), whose push would overwrite changes its client
+// hadn't seen. The conflict carries both versions, and under the keep_both policy the stored version is
+// first copied into a new "(conflicted copy from <device>)" note. Returns nil if the note's own device
+// stored the newer version.
+func (d *Database) resolveConflict(ctx context.Context, tx *sql.Tx, userID, deviceID string, note *models.SyncNote, storedDevice sql.NullString, policy string) (*models.SyncConflict, error) {
+	// A device re-pushing over its own newer write hasn't lost anything
+	if deviceID != "" && storedDevice.String == deviceID {
+		return nil, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+noteColumns(nil)+` FROM notes n WHERE n.id = $1 AND n.user_id = $2`, note.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := d.scanNotes(ctx, rows, nil)
+	if err != nil {
+		return nil, err
+	}
+	pushed := *note
+	pushed.UserID, pushed.BaseUpdatedAt, pushed.EmbeddingText = userID, nil, nil
+
+	conflict := &models.SyncConflict{NoteID: note.ID, Client: &pushed}
+	if len(stored) > 0 {
+		conflict.Server = &stored[0]
+	}
+	if policy != models.ConflictKeepBoth {
+		if err = RecordNoteEvent(ctx, tx, userID, note.ID, models.NoteEventConflict, deviceID, ""); err != nil {
+			return nil, err
+		}
+		return conflict, nil
+	}
+
+	copyID, err := NewNoteID()
+	if err != nil {
+		return nil, err
+	}
+	device := storedDevice.String
+	if device == "" {
+		device = "another device"
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO notes (id, user_id, title, content_encrypted, content_iv, domain, language, date, is_pinned,
+		                   is_archived, tags, reminder_at, device_id, conflict_of, created_at, updated_at,
+		                   source_encrypted, source_iv, source_url_hash, ai_excluded)
+		SELECT $3, user_id, title || ' (conflicted copy from ' || $4 || ')', content_encrypted, content_iv, domain,
+		       language, date, FALSE, is_archived, tags, reminder_at, device_id, id, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP,
+		       source_encrypted, source_iv, source_url_hash, ai_excluded
+		FROM notes
+		WHERE id = $1 AND user_id = $2
+	`, note.ID, userID, copyID, device); err != nil {
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO note_collections (note_id, collection_id)
+		SELECT $2, collection_id FROM note_collections WHERE note_id = $1
+	`, note.ID, copyID); err != nil {
+		return nil, err
+	}
+	if err = RecordNoteEvent(ctx, tx, userID, copyID, models.NoteEventConflictCopy, storedDevice.String, note.ID); err != nil {
+		return nil, err
+	}
+	if err = RecordNoteEvent(ctx, tx, userID, note.ID, models.NoteEventConflict, deviceID, copyID); err != nil {
+		return nil, err
+	}
+
+	conflict.CopyID = copyID
+	return conflict, nil
+}
+
+// deleteNotes soft-deletes notes, returning the IDs that belong to another user and so weren't deleted.
+// Deleting a note the server has never seen is a no-op.
+func (d *Database) deleteNotes(ctx context.Context, tx *sql.Tx, userID, deviceID string, noteIDs []string) (map[string]bool, error) {
+	deleted, err := queryStrings(ctx, tx, `
+		UPDATE notes SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ANY($1) AND user_id = $2
+		RETURNING id
+	`, noteIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(deleted) > 0 {
+		eventTypes := make([]string, len(deleted))
+		for i := range eventTypes {
+			eventTypes[i] = models.NoteEventDeleted
+		}
+		if err = RecordNoteEvents(ctx, tx, userID, deviceID, deleted, eventTypes); err != nil {
+			return nil, err
+		}
+	}
+	if len(deleted) == len(noteIDs) {
+		return nil, nil
+	}
+
+	others, err := queryStrings(ctx, tx, `SELECT id FROM notes WHERE id = ANY($1) AND user_id <> $2`, noteIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	notOwned := make(map[string]bool, len(others))
+	for _, id := range others {
+		notOwned[id] = true
+	}
+	return notOwned, nil
+}
+
+// syncBatchSize caps the items written by one multi-row statement, keeping it well under Postgres's
+// limit of 65535 bind parameters
+const syncBatchSize = 500
+
+// Column types of the VALUES lists written by upsertCollections and upsertNotes (VALUES doesn't infer
+// parameter types from how its rows are used)
+var (
+	collectionUpsertTypes = []string{"text", "text", "text", "text", "text", "integer", "text", "text", "timestamptz", "timestamptz"}
+	noteUpsertTypes       = []string{
+		"text", "text", "bytea", "bytea", "text", "text", "date", "boolean", "boolean", "text[]",
+		"timestamptz", "timestamptz", "timestamptz", "boolean", "bytea", "bytea", "text", "boolean",
+	}
+)
+
+// pushBatches splits n pushed items into runs of consecutive items, in push order, that each contain an
+// ID at most once (so no statement writes a row twice) and at most syncBatchSize items. startsBatch, if
+// set, makes an item start a new run.
+func pushBatches(n int, id func(int) string, startsBatch func(int) bool) [][]int {
+	var batches [][]int
+	var batch []int
+	seen := map[string]bool{}
+	for i := 0; i < n; i++ {
+		if len(batch) > 0 && (len(batch) == syncBatchSize || seen[id(i)] || (startsBatch != nil && startsBatch(i))) {
+			batches = append(batches, batch)
+			batch, seen = nil, map[string]bool{}
+		}
+		batch = append(batch, i)
+		seen[id(i)] = true
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// valuesRows returns rows rows of a VALUES list, each with a placeholder per type cast to that type,
+// numbered from first
+func valuesRows(rows, first int, types []string) string {
+	var b strings.Builder
+	n := first
+	for row := 0; row < rows; row++ {
+		if row > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for i, t := range types {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "$%d::%s", n, t)
+			n++
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// notWritten returns the IDs missing from written
+func notWritten(ids, written []string) map[string]bool {
+	missing := map[string]bool{}
+	for _, id := range ids {
+		missing[id] = true
+	}
+	for _, id := range written {
+		delete(missing, id)
+	}
+	return missing
+}
@@ -0,0 +1,109 @@
+// Background purging of notes that have been in the trash longer than the retention period
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// trashPurgeInterval is how often expired trash is purged
+	trashPurgeInterval = time.Hour
+	// trashPurgeBatchSize is how many notes one purge statement deletes, so no run holds locks for long
+	trashPurgeBatchSize = 500
+	// trashPurgeTimeout bounds a single purge statement
+	trashPurgeTimeout = 30 * time.Second
+)
+
+// TrashPurger permanently deletes notes soft-deleted longer ago than the retention period, along
+// with their revisions, timeline, and embeddings
+type TrashPurger struct {
+	db        *Database
+	retention time.Duration
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTrashPurger creates a TrashPurger and starts purging, or returns nil (deleted notes are kept)
+// if retention isn't positive. Closing a nil *TrashPurger is a no-op.
+func NewTrashPurger(db *Database, retention time.Duration) *TrashPurger {
+	if retention <= 0 {
+		return nil
+	}
+	p := &TrashPurger{db: db, retention: retention, done: make(chan struct{})}
+	p.wg.Add(1)
+	go p.loop()
+	return p
+}
+
+// Close stops purging
+func (p *TrashPurger) Close() {
+	if p == nil {
+		return
+	}
+	close(p.done)
+	p.wg.Wait()
+}
+
+func (p *TrashPurger) loop() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	p.purge()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.purge()
+		}
+	}
+}
+
+// purge deletes expired notes in batches until none are left. Notes locked by an operation in
+// progress are left for the next run.
+func (p *TrashPurger) purge() {
+	cutoff := time.Now().Add(-p.retention)
+	var purged int64
+	for {
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), trashPurgeTimeout)
+		result, err := p.db.DB.ExecContext(ctx, `
+			DELETE FROM notes
+			WHERE id IN (
+				SELECT n.id FROM notes n
+				WHERE n.deleted_at < $1
+				  AND NOT EXISTS (SELECT 1 FROM note_locks l WHERE l.note_id = n.id AND l.expires_at >= CURRENT_TIMESTAMP)
+				LIMIT $2
+			)
+		`, cutoff, trashPurgeBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("Error purging trash: %v", err)
+			return
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			log.Printf("Error purging trash: %v", err)
+			return
+		}
+		purged += deleted
+		if deleted < trashPurgeBatchSize {
+			break
+		}
+	}
+	if purged > 0 {
+		log.Printf("Purged %d note(s) deleted before %s", purged, cutoff.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,179 @@
+// Ephemeral users for the anonymous trial mode
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// TrialFeatureFlag is the FEATURE_FLAGS entry that turns the trial mode on
+const TrialFeatureFlag = "anonymous_trials"
+
+// Trial user and token prefixes, so they're recognizable in logs and can't be mistaken for Clerk IDs
+const (
+	TrialUserIDPrefix = "trial_"
+	trialTokenPrefix  = "jtt_"
+)
+
+const (
+	// trialPurgeInterval is how often expired trial users are deleted
+	trialPurgeInterval = 10 * time.Minute
+	// trialPurgeBatchSize is how many trial users one purge statement deletes
+	trialPurgeBatchSize = 100
+	// trialPurgeTimeout bounds a single purge statement
+	trialPurgeTimeout = 30 * time.Second
+)
+
+// ErrTrialNotFound is returned for unknown or expired trial tokens
+var ErrTrialNotFound = errors.New("trial session not found or expired")
+
+// TrialUsers issues ephemeral trial users, authenticates their bearer tokens, and deletes them (with
+// everything they own) once they expire
+type TrialUsers struct {
+	db  *Database
+	ttl time.Duration
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTrialUsers creates a TrialUsers issuing trials that last ttl, and starts purging expired ones
+func NewTrialUsers(db *Database, ttl time.Duration) *TrialUsers {
+	t := &TrialUsers{db: db, ttl: ttl, done: make(chan struct{})}
+	t.wg.Add(1)
+	go t.loop()
+	return t
+}
+
+// Close stops purging
+func (t *TrialUsers) Close() {
+	close(t.done)
+	t.wg.Wait()
+}
+
+// Create creates a trial user, returning its ID and bearer token. Only a hash of the token is stored.
+func (t *TrialUsers) Create(ctx context.Context) (userID, token string, expiresAt time.Time, err error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", time.Time{}, fmt.Errorf("failed to generate trial user ID: %w", err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", time.Time{}, fmt.Errorf("failed to generate trial token: %w", err)
+	}
+	userID = TrialUserIDPrefix + hex.EncodeToString(idBytes)
+	token = trialTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	expiresAt = time.Now().Add(t.ttl)
+
+	tx, err := t.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back trial user: %v", rbErr)
+			}
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `INSERT INTO users (id) VALUES ($1)`, userID); err != nil {
+		return "", "", time.Time{}, err
+	}
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO trial_users (user_id, token_hash, expires_at) VALUES ($1, $2, $3)
+	`, userID, hashTrialToken(token), expiresAt); err != nil {
+		return "", "", time.Time{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return "", "", time.Time{}, err
+	}
+	return userID, token, expiresAt, nil
+}
+
+// Authenticate returns the trial user a token belongs to and when it expires, or ErrTrialNotFound
+func (t *TrialUsers) Authenticate(ctx context.Context, token string) (userID string, expiresAt time.Time, err error) {
+	err = t.db.DB.QueryRowContext(ctx, `
+		SELECT user_id, expires_at FROM trial_users WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP
+	`, hashTrialToken(token)).Scan(&userID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", time.Time{}, ErrTrialNotFound
+	}
+	return userID, expiresAt, err
+}
+
+// Delete deletes a trial user and everything it owns
+func (t *TrialUsers) Delete(ctx context.Context, userID string) error {
+	_, err := t.db.DB.ExecContext(ctx, `
+		DELETE FROM users WHERE id = $1 AND EXISTS (SELECT 1 FROM trial_users WHERE user_id = $1)
+	`, userID)
+	return err
+}
+
+// hashTrialToken returns the hash trial tokens are stored and looked up by
+func hashTrialToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func (t *TrialUsers) loop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(trialPurgeInterval)
+	defer ticker.Stop()
+
+	t.purge()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			t.purge()
+		}
+	}
+}
+
+// purge deletes expired trial users in batches until none are left
+func (t *TrialUsers) purge() {
+	var purged int64
+	for {
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), trialPurgeTimeout)
+		result, err := t.db.DB.ExecContext(ctx, `
+			DELETE FROM users
+			WHERE id IN (SELECT user_id FROM trial_users WHERE expires_at <= CURRENT_TIMESTAMP LIMIT $1)
+		`, trialPurgeBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("Error purging trial users: %v", err)
+			return
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			log.Printf("Error purging trial users: %v", err)
+			return
+		}
+		purged += deleted
+		if deleted < trialPurgeBatchSize {
+			break
+		}
+	}
+	if purged > 0 {
+		log.Printf("Purged %d expired trial user(s)", purged)
+	}
+}
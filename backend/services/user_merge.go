@@ -0,0 +1,377 @@
+// Moving all of one user's data to another, for Clerk account merges
+package services
+
+import (
+	"backend/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrMergeUserNotFound is returned when the source or target of a merge has no user record
+var ErrMergeUserNotFound = errors.New("user not found")
+
+// UserMergeSealers are the sealers of the secrets bound to a user ID, which a merge opens and seals
+// again for the target. A nil sealer (the feature is disabled) drops the source's secrets of that kind.
+type UserMergeSealers struct {
+	Escrow       *Sealer
+	ProviderKeys *Sealer
+	Chat         *Sealer
+}
+
+// UserMergeResult is the outcome of MergeUsers
+type UserMergeResult struct {
+	Report        *models.UserMergeReport
+	NoteIDs       []string // Notes now owned by the target, to publish to its devices
+	CollectionIDs []string
+}
+
+// mergeTable is a table of per-user rows that a merge moves to the target. conflict is the condition
+// (on source row s and target row t) under which the target already has the row; the source's is then
+// dropped. Rows of touched tables get a new updated_at, so the target's devices pull them.
+type mergeTable struct {
+	name     string
+	conflict string
+	touch    bool
+}
+
+// mergeTables are moved in order, after notes and collections and the sealed secrets
+var mergeTables = []mergeTable{
+	{name: "note_events"},
+	{name: "note_revisions"},
+	{name: "note_links"},
+	{name: "note_embeddings"},
+	{name: "note_locks"},
+	{name: "signing_keys"},
+	{name: "capture_inbox_tokens"},
+	{name: "jobs"},
+	{name: "staged_notes"},
+	{name: "capture_rules", conflict: "t.domain = s.domain"},
+	{name: "collection_overviews"},
+	{name: "share_links"},
+	{name: "search_history", conflict: "t.query_key = s.query_key"},
+	{name: "chat_sessions"}, // Messages belong to the session
+	{name: "encryption_metadata", conflict: "TRUE"},
+	{name: "key_escrow", conflict: "TRUE"},
+	{name: "escrow_recovery_requests"},
+	{name: "provider_keys", conflict: "t.provider = s.provider"},
+	{name: "provider_key_events"},
+	{name: "client_settings", conflict: "t.key = s.key", touch: true},
+	{name: "client_errors", conflict: "t.fingerprint = s.fingerprint"},
+	{name: "sync_devices", conflict: "t.device_id = s.device_id"},
+}
+
+// mergedSuffix is appended to the names of moved collections that clash with one of the target's
+const mergedSuffix = " (merged)"
+
+// MergeUsers moves all of the source user's data to the target in one transaction. Where both users
+// have the same singular row (a setting, a device, a stored provider key) the target's is kept, and
+// moved collections whose names clash with the target's are renamed. Content hashes of imports are
+// salted with the user ID and are dropped. A dry run reports the same counts and rolls back.
+//
+// The merge is refused, with the reasons in the report's Blockers, if both users have set up
+// end-to-end encryption (their notes can't be read with one key) or the source has notes locked
+// by an operation in progress. The source's user record is kept, now empty, along with its
+// per-user settings; telemetry counters are anonymous and stay where they are.
+func (d *Database) MergeUsers(ctx context.Context, sourceID, targetID string, sealers UserMergeSealers, dryRun bool) (result *UserMergeResult, err error) {
+	if sourceID == targetID {
+		return nil, errors.New("source and target must be different users")
+	}
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Error rolling back user merge: %v", rbErr)
+			}
+		}
+	}()
+
+	// Lock both users so two merges of the same accounts can't interleave
+	var found int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (SELECT id FROM users WHERE id IN ($1, $2) ORDER BY id FOR UPDATE) u
+	`, sourceID, targetID).Scan(&found); err != nil {
+		return nil, err
+	}
+	if found != 2 {
+		return nil, ErrMergeUserNotFound
+	}
+
+	report := &models.UserMergeReport{
+		SourceUserID:       sourceID,
+		TargetUserID:       targetID,
+		DryRun:             dryRun,
+		Moved:              map[string]int64{},
+		Dropped:            map[string]int64{},
+		RenamedCollections: []models.RenamedCollection{},
+		Blockers:           []string{},
+	}
+	result = &UserMergeResult{Report: report}
+
+	if report.Blockers, err = mergeBlockers(ctx, tx, sourceID, targetID); err != nil {
+		return nil, err
+	}
+	if len(report.Blockers) > 0 {
+		return result, nil
+	}
+
+	// Sealed secrets are opened with the source's ID, so they're sealed again before they move
+	sealed := []struct {
+		table  string
+		sealer *Sealer
+		query  string
+		update string
+	}{
+		{"key_escrow", sealers.Escrow,
+			`SELECT key_version::text, sealed_secret FROM key_escrow WHERE user_id = $1`,
+			`UPDATE key_escrow SET sealed_secret = $2 WHERE user_id = $3 AND key_version = $1::integer`},
+		{"provider_keys", sealers.ProviderKeys,
+			`SELECT provider, sealed_key FROM provider_keys WHERE user_id = $1`,
+			`UPDATE provider_keys SET sealed_key = $2 WHERE user_id = $3 AND provider = $1`},
+		{"chat_sessions", sealers.Chat,
+			`SELECT id, title_sealed FROM chat_sessions WHERE user_id = $1 AND title_sealed IS NOT NULL`,
+			`UPDATE chat_sessions SET title_sealed = $2 WHERE user_id = $3 AND id = $1`},
+		{"chat_messages", sealers.Chat,
+			`SELECT m.id::text, m.content_sealed FROM chat_messages m JOIN chat_sessions s ON s.id = m.session_id WHERE s.user_id = $1`,
+			`UPDATE chat_messages SET content_sealed = $2
+			 WHERE id = $1::bigint AND session_id IN (SELECT id FROM chat_sessions WHERE user_id = $3)`},
+	}
+	for _, s := range sealed {
+		if s.sealer != nil {
+			if err := resealRows(ctx, tx, s.sealer, sourceID, targetID, s.query, s.update); err != nil {
+				return nil, fmt.Errorf("failed to reseal %s: %w", s.table, err)
+			}
+			continue
+		}
+		if s.table == "chat_messages" {
+			continue // Dropped with their sessions
+		}
+		// Without the key the secrets can't be opened, so they can't move either
+		//nolint:gosec // table is one of the constants above, not user input
+		dropped, err := rowsAffected(tx.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE user_id = $1`, sourceID))
+		if err != nil {
+			return nil, err
+		}
+		if dropped > 0 {
+			report.Dropped[s.table] = dropped
+		}
+	}
+
+	if err := mergeCollections(ctx, tx, sourceID, targetID, result); err != nil {
+		return nil, err
+	}
+
+	// Changing the owner bumps updated_at (by trigger), so the target's devices pull every moved note
+	noteIDs, err := queryStrings(ctx, tx, `UPDATE notes SET user_id = $2 WHERE user_id = $1 RETURNING id`, sourceID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	result.NoteIDs = noteIDs
+	if len(noteIDs) > 0 {
+		report.Moved["notes"] = int64(len(noteIDs))
+	}
+
+	for _, table := range mergeTables {
+		if err := mergeTableRows(ctx, tx, table, sourceID, targetID, report); err != nil {
+			return nil, fmt.Errorf("failed to merge %s: %w", table.name, err)
+		}
+	}
+
+	dropped, err := rowsAffected(tx.ExecContext(ctx, `DELETE FROM staged_content_hashes WHERE user_id = $1`, sourceID))
+	if err != nil {
+		return nil, err
+	}
+	if dropped > 0 {
+		report.Dropped["staged_content_hashes"] = dropped
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	committed = true
+	return result, nil
+}
+
+// Helper functions
+
+// mergeBlockers returns the reasons the source can't be merged into the target, if any
+func mergeBlockers(ctx context.Context, tx *sql.Tx, sourceID, targetID string) ([]string, error) {
+	var bothEncrypted, locked bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM encryption_metadata WHERE user_id IN ($1, $2)) = 2,
+		       EXISTS (SELECT 1 FROM note_locks WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP)
+	`, sourceID, targetID).Scan(&bothEncrypted, &locked); err != nil {
+		return nil, err
+	}
+
+	blockers := []string{}
+	if bothEncrypted {
+		blockers = append(blockers, "both users have set up end-to-end encryption, so their notes are encrypted with different keys")
+	}
+	if locked {
+		blockers = append(blockers, "the source has notes locked by an operation in progress")
+	}
+	return blockers, nil
+}
+
+// mergeCollections moves the source's collections to the target, renaming those whose names clash
+// with one of the target's live collections under the same parent
+func mergeCollections(ctx context.Context, tx *sql.Tx, sourceID, targetID string, result *UserMergeResult) error {
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE collections s SET name = left(s.name, $3) || $4
+		WHERE s.user_id = $1 AND s.deleted_at IS NULL
+		  AND EXISTS (
+			SELECT 1 FROM collections t
+			WHERE t.user_id = $2 AND t.deleted_at IS NULL
+			  AND COALESCE(t.parent_id, '') = COALESCE(s.parent_id, '') AND t.name = s.name
+		  )
+		RETURNING s.id, s.name
+	`, sourceID, targetID, 255-len(mergedSuffix), mergedSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		var renamed models.RenamedCollection
+		if err := rows.Scan(&renamed.CollectionID, &renamed.Name); err != nil {
+			return err
+		}
+		result.Report.RenamedCollections = append(result.Report.RenamedCollections, renamed)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	collectionIDs, err := queryStrings(ctx, tx, `UPDATE collections SET user_id = $2 WHERE user_id = $1 RETURNING id`, sourceID, targetID)
+	if err != nil {
+		return err
+	}
+	result.CollectionIDs = collectionIDs
+	if len(collectionIDs) > 0 {
+		result.Report.Moved["collections"] = int64(len(collectionIDs))
+	}
+	return nil
+}
+
+// mergeTableRows drops the source's rows of table that the target already has and moves the rest
+func mergeTableRows(ctx context.Context, tx *sql.Tx, table mergeTable, sourceID, targetID string, report *models.UserMergeReport) error {
+	if table.conflict != "" {
+		//nolint:gosec // table names and conditions are constants, not user input
+		dropped, err := rowsAffected(tx.ExecContext(ctx, fmt.Sprintf(`
+			DELETE FROM %[1]s s
+			WHERE s.user_id = $1 AND EXISTS (SELECT 1 FROM %[1]s t WHERE t.user_id = $2 AND %[2]s)
+		`, table.name, table.conflict), sourceID, targetID))
+		if err != nil {
+			return err
+		}
+		if dropped > 0 {
+			report.Dropped[table.name] += dropped
+		}
+	}
+
+	set := "user_id = $2"
+	if table.touch {
+		set += ", updated_at = CURRENT_TIMESTAMP"
+	}
+	//nolint:gosec // table names are constants, not user input
+	moved, err := rowsAffected(tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s WHERE user_id = $1`, table.name, set), sourceID, targetID))
+	if err != nil {
+		return err
+	}
+	if moved > 0 {
+		report.Moved[table.name] += moved
+	}
+	return nil
+}
+
+// resealRows opens each (key, sealed) row that query returns for the source ($1) with the source's ID,
+// seals it again for the target, and writes it back with update ($1 key, $2 sealed, $3 source)
+func resealRows(ctx context.Context, tx *sql.Tx, sealer *Sealer, sourceID, targetID, query, update string) error {
+	type sealedRow struct {
+		key    string
+		sealed []byte
+	}
+
+	// Read every row first; the connection can't run the updates while rows are open
+	rows, err := tx.QueryContext(ctx, query, sourceID)
+	if err != nil {
+		return err
+	}
+	var sealedRows []sealedRow
+	for rows.Next() {
+		var row sealedRow
+		if err := rows.Scan(&row.key, &row.sealed); err != nil {
+			if closeErr := rows.Close(); closeErr != nil {
+				log.Printf("Error closing rows: %v", closeErr)
+			}
+			return err
+		}
+		sealedRows = append(sealedRows, row)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, row := range sealedRows {
+		secret, err := sealer.Open(sourceID, row.sealed)
+		if err != nil {
+			return err
+		}
+		resealed, err := sealer.Seal(targetID, secret)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, update, row.key, resealed, sourceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowsAffected returns the rows affected by a statement run with ExecContext
+func rowsAffected(result sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// queryStrings returns the single string column of every row query returns
+func queryStrings(ctx context.Context, q Queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,346 @@
+// Nightly maintenance of semantic search embeddings and their pgvector index
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// vectorMaintenanceCheckInterval is how often the maintainer checks whether its window has opened
+	vectorMaintenanceCheckInterval = 10 * time.Minute
+	// vectorMaintenanceBatchSize is how many embeddings one prune or staleness statement touches
+	vectorMaintenanceBatchSize = 1000
+	// vectorMaintenanceTimeout bounds a single prune or staleness statement
+	vectorMaintenanceTimeout = 30 * time.Second
+	// vectorIndexBuildTimeout bounds building the approximate index
+	vectorIndexBuildTimeout = 2 * time.Hour
+	// vectorMaintenanceLockID is the advisory lock key that keeps two replicas from maintaining at once
+	vectorMaintenanceLockID = 7436922
+	// vectorMaintenanceTask names the maintenance_runs row
+	vectorMaintenanceTask = "vector_maintenance"
+	// vectorIndexName is the approximate nearest-neighbour index over note embeddings
+	vectorIndexName = "idx_note_embeddings_ann"
+)
+
+// vectorIndexParams are the build parameters each index method accepts
+var vectorIndexParams = map[string][]string{
+	"hnsw":    {"m", "ef_construction"},
+	"ivfflat": {"lists"},
+}
+
+// VectorIndexSpec describes the approximate nearest-neighbour index built over note embeddings. The
+// zero value means no index: searches scan each user's embeddings exactly.
+type VectorIndexSpec struct {
+	Method string         // hnsw or ivfflat
+	Params map[string]int // e.g. m and ef_construction for hnsw, lists for ivfflat
+}
+
+// ParseVectorIndexSpec parses a VECTOR_INDEX value such as "hnsw:m=16,ef_construction=64" or
+// "ivfflat:lists=100". Empty or "none" means no index.
+func ParseVectorIndexSpec(value string) (VectorIndexSpec, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "none" {
+		return VectorIndexSpec{}, nil
+	}
+
+	method, rawParams, _ := strings.Cut(value, ":")
+	method = strings.ToLower(strings.TrimSpace(method))
+	allowed, ok := vectorIndexParams[method]
+	if !ok {
+		return VectorIndexSpec{}, fmt.Errorf("unknown index method %q, expected hnsw or ivfflat", method)
+	}
+
+	spec := VectorIndexSpec{Method: method, Params: map[string]int{}}
+	for _, pair := range strings.Split(rawParams, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, rawValue, ok := strings.Cut(pair, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || !slices.Contains(allowed, name) {
+			return VectorIndexSpec{}, fmt.Errorf("invalid %s parameter %q, expected one of %s", method, pair, strings.Join(allowed, ", "))
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(rawValue))
+		if err != nil || n <= 0 {
+			return VectorIndexSpec{}, fmt.Errorf("%s must be a positive integer", name)
+		}
+		spec.Params[name] = n
+	}
+	return spec, nil
+}
+
+// createStatement returns the statement building the index under the given name
+func (s VectorIndexSpec) createStatement(name string) string {
+	statement := fmt.Sprintf("CREATE INDEX CONCURRENTLY %s ON note_embeddings USING %s (embedding vector_cosine_ops)", name, s.Method)
+	if len(s.Params) == 0 {
+		return statement
+	}
+	names := make([]string, 0, len(s.Params))
+	for param := range s.Params {
+		names = append(names, param)
+	}
+	sort.Strings(names)
+	with := make([]string, len(names))
+	for i, param := range names {
+		with[i] = fmt.Sprintf("%s = %d", param, s.Params[param])
+	}
+	return statement + " WITH (" + strings.Join(with, ", ") + ")"
+}
+
+// MaintenanceWindow is a daily UTC time range, which may wrap past midnight
+type MaintenanceWindow struct {
+	Start time.Duration // Since midnight
+	End   time.Duration
+}
+
+// DefaultMaintenanceWindow applies when VECTOR_MAINTENANCE_WINDOW isn't set
+var DefaultMaintenanceWindow = MaintenanceWindow{Start: 3 * time.Hour, End: 5 * time.Hour}
+
+// ParseMaintenanceWindow parses a window such as "03:00-05:00" (UTC)
+func ParseMaintenanceWindow(value string) (MaintenanceWindow, error) {
+	rawStart, rawEnd, ok := strings.Cut(value, "-")
+	if !ok {
+		return MaintenanceWindow{}, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", value)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(rawStart))
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("invalid window start %q, expected HH:MM", rawStart)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(rawEnd))
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("invalid window end %q, expected HH:MM", rawEnd)
+	}
+	if start.Equal(end) {
+		return MaintenanceWindow{}, fmt.Errorf("window %q is empty", value)
+	}
+	sinceMidnight := func(t time.Time) time.Duration {
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return MaintenanceWindow{Start: sinceMidnight(start), End: sinceMidnight(end)}, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	offset := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Length returns how long the window stays open
+func (w MaintenanceWindow) Length() time.Duration {
+	if w.Start < w.End {
+		return w.End - w.Start
+	}
+	return 24*time.Hour - w.Start + w.End
+}
+
+// VectorMaintainer runs once per daily window: it prunes embeddings no search can return, flags
+// embeddings whose note changed since they were computed, and rebuilds the approximate index
+type VectorMaintainer struct {
+	db     *Database
+	index  VectorIndexSpec
+	window MaintenanceWindow
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewVectorMaintainer creates a VectorMaintainer and starts waiting for its window
+func NewVectorMaintainer(db *Database, index VectorIndexSpec, window MaintenanceWindow) *VectorMaintainer {
+	m := &VectorMaintainer{db: db, index: index, window: window, done: make(chan struct{})}
+	m.wg.Add(1)
+	go m.loop()
+	return m
+}
+
+// Close stops maintenance, waiting for a run in progress to reach a stopping point
+func (m *VectorMaintainer) Close() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+func (m *VectorMaintainer) loop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(vectorMaintenanceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if m.window.Contains(time.Now()) {
+			if err := m.run(); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Error maintaining note embeddings: %v", err)
+			}
+		}
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run maintains the embeddings unless another replica holds the lock or the task already ran in this
+// window. Closing the maintainer cancels a run in progress; an index build it interrupts is dropped and
+// redone next time.
+func (m *VectorMaintainer) run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// The lock is held by the session, which Neon's pooler doesn't keep, and so is the concurrent build
+	conn, err := m.db.Direct.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("Error closing connection: %v", err)
+		}
+	}()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, vectorMaintenanceLockID).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to take maintenance lock: %w", err)
+	}
+	if !locked {
+		return nil
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, vectorMaintenanceLockID); err != nil {
+			log.Printf("Error releasing maintenance lock: %v", err)
+		}
+	}()
+
+	var finishedAt time.Time
+	err = conn.QueryRowContext(ctx, `SELECT finished_at FROM maintenance_runs WHERE task = $1`, vectorMaintenanceTask).Scan(&finishedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil && time.Since(finishedAt) < m.window.Length() {
+		return nil
+	}
+
+	// Notes in the trash keep their embeddings, so searches including the trash find them by meaning;
+	// purging a note deletes its embedding with it
+	started := time.Now()
+	pruned, err := m.batched(ctx, conn, `
+		DELETE FROM note_embeddings
+		WHERE note_id IN (
+			SELECT e.note_id FROM note_embeddings e
+			JOIN notes n ON n.id = e.note_id
+			WHERE n.ai_excluded OR e.model <> $1
+			LIMIT $2
+		)
+	`, EmbeddingModel)
+	if err != nil {
+		return fmt.Errorf("failed to prune embeddings: %w", err)
+	}
+
+	flagged, err := m.batched(ctx, conn, `
+		UPDATE note_embeddings e SET stale = TRUE
+		WHERE e.note_id IN (
+			SELECT e2.note_id FROM note_embeddings e2
+			JOIN notes n ON n.id = e2.note_id
+			WHERE NOT e2.stale AND e2.model = $1 AND e2.content_hash IS DISTINCT FROM sha256(n.content_encrypted)
+			LIMIT $2
+		)
+	`, EmbeddingModel)
+	if err != nil {
+		return fmt.Errorf("failed to flag stale embeddings: %w", err)
+	}
+
+	if err := m.rebuildIndex(ctx, conn); err != nil {
+		return fmt.Errorf("failed to rebuild %s: %w", vectorIndexName, err)
+	}
+
+	if _, err := conn.ExecContext(ctx, `
+		INSERT INTO maintenance_runs (task, finished_at) VALUES ($1, CURRENT_TIMESTAMP)
+		ON CONFLICT (task) DO UPDATE SET finished_at = EXCLUDED.finished_at
+	`, vectorMaintenanceTask); err != nil {
+		return err
+	}
+
+	index := "no index"
+	if m.index.Method != "" {
+		index = m.index.Method + " index rebuilt"
+	}
+	log.Printf("Maintained note embeddings in %s: %d pruned, %d flagged stale, %s",
+		time.Since(started).Round(time.Second), pruned, flagged, index)
+	return nil
+}
+
+// batched runs a statement taking (arg, batch size) until it changes fewer rows than a batch, and
+// returns how many rows it changed in total
+func (m *VectorMaintainer) batched(ctx context.Context, conn *sql.Conn, query string, arg interface{}) (int64, error) {
+	var total int64
+	for {
+		stmtCtx, cancel := context.WithTimeout(ctx, vectorMaintenanceTimeout)
+		changed, err := rowsAffected(conn.ExecContext(stmtCtx, query, arg, vectorMaintenanceBatchSize))
+		cancel()
+		if err != nil {
+			return total, err
+		}
+		total += changed
+		if changed < vectorMaintenanceBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// rebuildIndex builds a fresh approximate index next to the current one and swaps it in, so searches
+// never run without it; with no index configured, any existing one is dropped. Building concurrently
+// keeps embeddings writable throughout.
+func (m *VectorMaintainer) rebuildIndex(ctx context.Context, conn *sql.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, vectorIndexBuildTimeout)
+	defer cancel()
+
+	building := vectorIndexName + "_new"
+	statements := []string{"DROP INDEX CONCURRENTLY IF EXISTS " + building}
+	if m.index.Method == "" {
+		statements = append(statements, "DROP INDEX CONCURRENTLY IF EXISTS "+vectorIndexName)
+	} else {
+		statements = append(statements,
+			m.index.createStatement(building),
+			"DROP INDEX CONCURRENTLY IF EXISTS "+vectorIndexName,
+			"ALTER INDEX "+building+" RENAME TO "+vectorIndexName,
+		)
+	}
+
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StaleEmbeddingNoteIDs returns up to limit of the user's live notes whose embedding was flagged stale,
+// most recently updated first
+func (d *Database) StaleEmbeddingNoteIDs(ctx context.Context, userID string, limit int) ([]string, error) {
+	return queryStrings(ctx, d.DB, `
+		SELECT e.note_id FROM note_embeddings e
+		JOIN notes n ON n.id = e.note_id
+		WHERE e.user_id = $1 AND e.stale AND n.deleted_at IS NULL
+		ORDER BY n.updated_at DESC, e.note_id
+		LIMIT $2
+	`, userID, limit)
+}
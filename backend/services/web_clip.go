@@ -0,0 +1,680 @@
+// Server-side fetching of web pages and extraction of their main content as markdown, for the web clipper
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// clipTimeout bounds fetching a page, redirects included
+	clipTimeout = 15 * time.Second
+	// maxClipPageSize is the largest page body fetched
+	maxClipPageSize = 5 << 20
+	// maxClipRedirects is how many redirects a fetch follows
+	maxClipRedirects = 5
+	// clipUserAgent identifies the clipper to the sites it fetches
+	clipUserAgent = "Mozilla/5.0 (compatible; JottinClipper/1.0)"
+	// minArticleLength is how much text an <article> or <main> element needs to be taken as the content
+	minArticleLength = 250
+)
+
+var (
+	// ErrClipBlockedAddress is returned for pages on loopback, private, or otherwise internal addresses
+	ErrClipBlockedAddress = errors.New("URL points to a private or reserved address")
+	// ErrClipUnsupportedType is returned for pages that aren't HTML or plain text
+	ErrClipUnsupportedType = errors.New("page is not HTML or plain text")
+	// ErrClipTooLarge is returned for pages over maxClipPageSize
+	ErrClipTooLarge = fmt.Errorf("page exceeds %dMB limit", maxClipPageSize>>20)
+	// ErrClipNoContent is returned when a page has no readable text
+	ErrClipNoContent = errors.New("page has no readable content")
+)
+
+// ClipFetchError is returned when a page's site answers with an error status
+type ClipFetchError struct {
+	StatusCode int
+}
+
+func (e *ClipFetchError) Error() string {
+	return "page returned HTTP " + strconv.Itoa(e.StatusCode)
+}
+
+// ClippedPage is the main content of a fetched page
+type ClippedPage struct {
+	URL     string // After redirects
+	Title   string
+	Content string // Markdown
+}
+
+// reservedPrefixes are ranges pages may not be fetched from beyond those netip.Addr reports as
+// loopback, private, link-local, multicast, or unspecified
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "This" network
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // Reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, which can reach IPv4 internal addresses
+}
+
+// clipClient fetches pages for the clipper. Addresses are checked when connecting rather than when
+// resolving, so a host name can't resolve to a public address for the check and an internal one for
+// the connection; proxies are bypassed, since they'd connect on the client's behalf.
+var clipClient = &http.Client{
+	Timeout: clipTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: rejectReservedAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          20,
+		IdleConnTimeout:       30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxClipRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxClipRedirects)
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// ClipPage fetches an http or https page and extracts its title and main content as markdown,
+// dropping navigation, ads, comments, and other boilerplate. Plain text pages are returned as-is.
+func ClipPage(ctx context.Context, pageURL *url.URL) (*ClippedPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", clipUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.1")
+
+	resp, err := clipClient.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrClipBlockedAddress) {
+			return nil, ErrClipBlockedAddress
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &ClipFetchError{StatusCode: resp.StatusCode}
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/html"
+	}
+	isHTML := mediaType == "text/html" || mediaType == "application/xhtml+xml"
+	if !isHTML && mediaType != "text/plain" && mediaType != "text/markdown" {
+		return nil, ErrClipUnsupportedType
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxClipPageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxClipPageSize {
+		return nil, ErrClipTooLarge
+	}
+
+	// Pages are converted to UTF-8 from their declared or detected encoding
+	utf8Body, err := charset.NewReader(bytes.NewReader(body), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ClippedPage{URL: resp.Request.URL.String()}
+	if isHTML {
+		doc, err := html.Parse(utf8Body)
+		if err != nil {
+			return nil, err
+		}
+		page.Title, page.Content = extractArticle(doc, resp.Request.URL)
+	} else {
+		text, err := io.ReadAll(utf8Body)
+		if err != nil {
+			return nil, err
+		}
+		page.Title = strings.TrimSuffix(path.Base(resp.Request.URL.Path), path.Ext(resp.Request.URL.Path))
+		page.Content = strings.TrimSpace(string(text))
+	}
+	if page.Content == "" {
+		return nil, ErrClipNoContent
+	}
+	return page, nil
+}
+
+// Helper functions
+
+// rejectReservedAddress refuses connections to addresses that aren't on the public internet
+func rejectReservedAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return ErrClipBlockedAddress
+	}
+	addr := addrPort.Addr().Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return ErrClipBlockedAddress
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return ErrClipBlockedAddress
+		}
+	}
+	return nil
+}
+
+// removedTags are elements never part of a page's content
+var removedTags = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true, atom.Iframe: true,
+	atom.Object: true, atom.Embed: true, atom.Svg: true, atom.Canvas: true, atom.Form: true,
+	atom.Button: true, atom.Input: true, atom.Select: true, atom.Textarea: true, atom.Nav: true,
+	atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Dialog: true, atom.Link: true,
+	atom.Meta: true,
+}
+
+// Class names and IDs of boilerplate containers, unless they also look like content
+var (
+	unlikelyContent = regexp.MustCompile(`(?i)\b(ad|ads|advert\w*|banner|breadcrumbs?|comments?|cookie\w*|disqus|footer|masthead|menu|modal|nav\w*|newsletter|outbrain|pagination|popup|promo\w*|related|share|sharing|sidebar|social|sponsor\w*|subscribe|taboola|tags|toolbar|widget)\b`)
+	likelyContent   = regexp.MustCompile(`(?i)\b(article|body|content|entry|main|post|story|text)\b`)
+)
+
+// extractArticle returns a page's title and the markdown of its main content: its <article> or
+// <main> element if it has one with enough text, otherwise the container whose paragraphs score
+// highest, readability-style, by length and commas, discounted by the share of text in links
+func extractArticle(doc *html.Node, base *url.URL) (title, content string) {
+	title = pageTitle(doc)
+
+	body := findFirst(doc, atom.Body)
+	if body == nil {
+		body = doc
+	}
+	pruneBoilerplate(body)
+
+	root := body
+	if article := longestOf(body, isArticleElement); article != nil && len(textContent(article)) >= minArticleLength {
+		root = article
+	} else if best := bestScoringContainer(body); best != nil {
+		root = best
+	}
+
+	md := &markdownWriter{base: base}
+	md.children(root)
+	return title, md.result()
+}
+
+// pageTitle returns the title from the page's Open Graph or Twitter metadata, its <title> with any
+// " | Site name" suffix removed, or its first heading
+func pageTitle(doc *html.Node) string {
+	var metaTitle, siteName string
+	walk(doc, func(n *html.Node) bool {
+		if n.DataAtom != atom.Meta {
+			return true
+		}
+		name := attr(n, "property")
+		if name == "" {
+			name = attr(n, "name")
+		}
+		switch name {
+		case "og:title", "twitter:title":
+			if metaTitle == "" {
+				metaTitle = collapseSpace(attr(n, "content"))
+			}
+		case "og:site_name":
+			siteName = collapseSpace(attr(n, "content"))
+		}
+		return true
+	})
+	if metaTitle != "" {
+		return metaTitle
+	}
+
+	if titleNode := findFirst(doc, atom.Title); titleNode != nil {
+		title := collapseSpace(textContent(titleNode))
+		for _, separator := range []string{" | ", " - ", " – ", " — ", " :: "} {
+			if siteName != "" && strings.HasSuffix(title, separator+siteName) {
+				title = strings.TrimSuffix(title, separator+siteName)
+			}
+		}
+		if title != "" {
+			return title
+		}
+	}
+	if h1 := findFirst(doc, atom.H1); h1 != nil {
+		return collapseSpace(textContent(h1))
+	}
+	return ""
+}
+
+// pruneBoilerplate removes elements that are never content, hidden elements, and containers whose
+// class or ID marks them as boilerplate
+func pruneBoilerplate(root *html.Node) {
+	var remove []*html.Node
+	walk(root, func(n *html.Node) bool {
+		if n.Type == html.CommentNode {
+			remove = append(remove, n)
+			return false
+		}
+		if n.Type != html.ElementNode || n == root {
+			return true
+		}
+		if removedTags[n.DataAtom] || isHidden(n) || attr(n, "role") == "navigation" || attr(n, "role") == "complementary" {
+			remove = append(remove, n)
+			return false
+		}
+		// Only containers are judged by name: an inline element named "share" inside a paragraph is content
+		if n.DataAtom == atom.Div || n.DataAtom == atom.Section || n.DataAtom == atom.Ul {
+			names := attr(n, "class") + " " + attr(n, "id")
+			if unlikelyContent.MatchString(names) && !likelyContent.MatchString(names) && n.DataAtom != atom.Body {
+				remove = append(remove, n)
+				return false
+			}
+		}
+		return true
+	})
+	for _, n := range remove {
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// isHidden reports whether an element is hidden from readers
+func isHidden(n *html.Node) bool {
+	if _, ok := attrValue(n, "hidden"); ok || attr(n, "aria-hidden") == "true" {
+		return true
+	}
+	style := strings.ReplaceAll(strings.ToLower(attr(n, "style")), " ", "")
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+// isArticleElement reports whether an element marks up a page's main content
+func isArticleElement(n *html.Node) bool {
+	return n.DataAtom == atom.Article || n.DataAtom == atom.Main || attr(n, "role") == "main"
+}
+
+// bestScoringContainer scores each paragraph-like element's parent and grandparent by the paragraph's
+// length and commas, and returns the container with the best score after discounting links, or nil
+// if the page has no paragraphs
+func bestScoringContainer(root *html.Node) *html.Node {
+	scores := map[*html.Node]float64{}
+	walk(root, func(n *html.Node) bool {
+		if n.DataAtom != atom.P && n.DataAtom != atom.Pre && n.DataAtom != atom.Td && n.DataAtom != atom.Blockquote {
+			return true
+		}
+		text := collapseSpace(textContent(n))
+		if len(text) < 25 {
+			return false
+		}
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+		if parent := n.Parent; parent != nil && parent.Type == html.ElementNode {
+			scores[parent] += score
+			if grandparent := parent.Parent; grandparent != nil && grandparent.Type == html.ElementNode {
+				scores[grandparent] += score / 2
+			}
+		}
+		return false
+	})
+
+	var best *html.Node
+	var bestScore float64
+	for n, score := range scores {
+		score *= 1 - linkDensity(n)
+		if best == nil || score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	return best
+}
+
+// linkDensity returns the share of an element's text inside links
+func linkDensity(n *html.Node) float64 {
+	total := len(textContent(n))
+	if total == 0 {
+		return 0
+	}
+	var linked int
+	walk(n, func(c *html.Node) bool {
+		if c.DataAtom == atom.A {
+			linked += len(textContent(c))
+			return false
+		}
+		return true
+	})
+	return float64(linked) / float64(total)
+}
+
+// markdownWriter renders HTML content as markdown
+type markdownWriter struct {
+	b    strings.Builder
+	base *url.URL // Relative links and images are resolved against it
+}
+
+// blankLines matches the runs of blank lines result collapses
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// result returns the markdown written, with trailing spaces and runs of blank lines removed
+func (m *markdownWriter) result() string {
+	lines := strings.Split(m.b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// atLineStart reports whether the next text written starts a line
+func (m *markdownWriter) atLineStart() bool {
+	s := m.b.String()
+	return s == "" || strings.HasSuffix(s, "\n")
+}
+
+// paragraph ends the current block with a blank line
+func (m *markdownWriter) paragraph() {
+	s := m.b.String()
+	if s != "" && !strings.HasSuffix(s, "\n\n") {
+		if strings.HasSuffix(s, "\n") {
+			m.b.WriteString("\n")
+		} else {
+			m.b.WriteString("\n\n")
+		}
+	}
+}
+
+// children renders a node's children
+func (m *markdownWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		m.node(c)
+	}
+}
+
+// sub renders a node's children on their own and returns the trimmed markdown
+func (m *markdownWriter) sub(n *html.Node) string {
+	inner := &markdownWriter{base: m.base}
+	inner.children(n)
+	return inner.result()
+}
+
+// node renders a node
+func (m *markdownWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		text := collapseSpace(n.Data)
+		if text == "" {
+			if strings.TrimSpace(n.Data) == "" && n.Data != "" && !m.atLineStart() {
+				m.b.WriteString(" ")
+			}
+			return
+		}
+		if n.Data[0] == ' ' || n.Data[0] == '\n' || n.Data[0] == '\t' {
+			if !m.atLineStart() {
+				m.b.WriteString(" ")
+			}
+		}
+		m.b.WriteString(text)
+		if last := n.Data[len(n.Data)-1]; last == ' ' || last == '\n' || last == '\t' {
+			m.b.WriteString(" ")
+		}
+		return
+	case html.ElementNode:
+	default:
+		m.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		if text := collapseSpace(m.sub(n)); text != "" {
+			m.paragraph()
+			level := int(n.Data[1] - '0')
+			m.b.WriteString(strings.Repeat("#", level) + " " + text)
+			m.paragraph()
+		}
+	case atom.Br:
+		m.b.WriteString("\n")
+	case atom.Hr:
+		m.paragraph()
+		m.b.WriteString("---")
+		m.paragraph()
+	case atom.Pre:
+		if code := strings.Trim(textContent(n), "\n"); code != "" {
+			m.paragraph()
+			m.b.WriteString("```\n" + code + "\n```")
+			m.paragraph()
+		}
+	case atom.Code, atom.Kbd, atom.Samp:
+		if code := collapseSpace(textContent(n)); code != "" {
+			m.b.WriteString("`" + code + "`")
+		}
+	case atom.Strong, atom.B:
+		m.wrap(n, "**")
+	case atom.Em, atom.I:
+		m.wrap(n, "_")
+	case atom.Del, atom.S:
+		m.wrap(n, "~~")
+	case atom.A:
+		text := collapseSpace(m.sub(n))
+		href := m.resolve(attr(n, "href"))
+		switch {
+		case text == "":
+		case href == "":
+			m.b.WriteString(text)
+		default:
+			m.b.WriteString("[" + text + "](" + href + ")")
+		}
+	case atom.Img:
+		src := attr(n, "src")
+		if src == "" || strings.HasPrefix(src, "data:") {
+			src = attr(n, "data-src") // Lazy-loaded images
+		}
+		if src = m.resolve(src); src != "" {
+			m.b.WriteString("![" + collapseSpace(attr(n, "alt")) + "](" + src + ")")
+		}
+	case atom.Ul, atom.Ol:
+		m.list(n)
+	case atom.Blockquote:
+		if quote := m.sub(n); quote != "" {
+			m.paragraph()
+			m.b.WriteString("> " + strings.ReplaceAll(quote, "\n", "\n> "))
+			m.paragraph()
+		}
+	case atom.Table:
+		m.table(n)
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Figure, atom.Figcaption,
+		atom.Dl, atom.Dt, atom.Dd, atom.Details, atom.Summary, atom.Address, atom.Center:
+		m.paragraph()
+		m.children(n)
+		m.paragraph()
+	case atom.Li:
+		// Items outside a list
+		m.paragraph()
+		m.b.WriteString("- " + m.sub(n))
+		m.paragraph()
+	default:
+		m.children(n)
+	}
+}
+
+// wrap renders an inline element's content between markers
+func (m *markdownWriter) wrap(n *html.Node, marker string) {
+	if text := m.sub(n); text != "" {
+		m.b.WriteString(marker + text + marker)
+	}
+}
+
+// list renders a list's items, indenting their continuation lines and nested lists under them
+func (m *markdownWriter) list(n *html.Node) {
+	m.paragraph()
+	number := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		number = start
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom != atom.Li {
+			continue
+		}
+		item := m.sub(c)
+		if item == "" {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		indent := strings.Repeat(" ", len(marker))
+		item = blankLines.ReplaceAllString(strings.ReplaceAll(item, "\n\n", "\n"), "\n")
+		m.b.WriteString(marker + strings.ReplaceAll(item, "\n", "\n"+indent) + "\n")
+	}
+	m.paragraph()
+}
+
+// table renders a table's rows as a markdown table, the first row as its header
+func (m *markdownWriter) table(n *html.Node) {
+	var rows [][]string
+	walk(n, func(c *html.Node) bool {
+		if c.DataAtom == atom.Table && c != n {
+			return false // Nested tables are flattened into their cell
+		}
+		if c.DataAtom != atom.Tr {
+			return true
+		}
+		var cells []string
+		for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+				text := collapseSpace(strings.ReplaceAll(m.sub(cell), "\n", " "))
+				cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+			}
+		}
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+		}
+		return false
+	})
+	if len(rows) == 0 {
+		return
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	m.paragraph()
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		m.b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			m.b.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+		}
+	}
+	m.paragraph()
+}
+
+// resolve returns a link or image URL made absolute against the page, or "" if it isn't http or https
+func (m *markdownWriter) resolve(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return ""
+	}
+	resolved, err := m.base.Parse(ref)
+	if err != nil || (resolved.Scheme != "http" && resolved.Scheme != "https") {
+		return ""
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(resolved.String(), "(", "%28"), ")", "%29")
+}
+
+// walk calls fn for n and its descendants in document order, skipping the descendants of nodes for
+// which fn returns false. fn may not modify the tree.
+func walk(n *html.Node, fn func(*html.Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+// findFirst returns the first element of a type under n, or nil
+func findFirst(n *html.Node, a atom.Atom) *html.Node {
+	var found *html.Node
+	walk(n, func(c *html.Node) bool {
+		if found == nil && c.Type == html.ElementNode && c.DataAtom == a {
+			found = c
+		}
+		return found == nil
+	})
+	return found
+}
+
+// longestOf returns the element matching match with the most text under n, or nil
+func longestOf(n *html.Node, match func(*html.Node) bool) *html.Node {
+	var longest *html.Node
+	var longestLength int
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.ElementNode && match(c) {
+			if length := len(textContent(c)); longest == nil || length > longestLength {
+				longest, longestLength = c, length
+			}
+		}
+		return true
+	})
+	return longest
+}
+
+// textContent returns the text under a node
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+		return true
+	})
+	return b.String()
+}
+
+// collapseSpace trims s and collapses its runs of whitespace into single spaces
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// attr returns an element's attribute, or "" if it isn't set
+func attr(n *html.Node, key string) string {
+	value, _ := attrValue(n, key)
+	return value
+}
+
+// attrValue returns an element's attribute and whether it's set
+func attrValue(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
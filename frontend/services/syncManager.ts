@@ -6,6 +6,7 @@ import {
   markdownToNote,
   getFilenameForNote,
 } from '../utils/markdownConverter';
+import { toNoteDay, fromNoteDay } from '../utils/notes';
 import { apiClient } from './apiClient';
 import { encryptionService } from './encryption';
 import { authService } from './authService';
@@ -363,7 +364,7 @@ export class SyncManager {
             contentEncrypted: encrypted,
             contentIV: iv,
             domain: note.domain,
-            date: new Date(note.date),
+            date: toNoteDay(note.date),
             isPinned: note.isPinned || false,
             collectionIds:
               note.collectionIds ||
@@ -434,7 +435,7 @@ export class SyncManager {
               title: remoteNote.title,
               content: decryptedContent,
               domain: remoteNote.domain,
-              date: new Date(remoteNote.date).toISOString(),
+              date: fromNoteDay(remoteNote.date),
               isPinned: remoteNote.isPinned,
               collectionIds: remoteNote.collectionIds || [],
             };
@@ -510,7 +511,7 @@ export class SyncManager {
         contentEncrypted: encrypted,
         contentIV: iv,
         domain: note.domain,
-        date: new Date(note.date),
+        date: toNoteDay(note.date),
         isPinned: note.isPinned || false,
         collectionIds:
           note.collectionIds || (note.collectionId ? [note.collectionId] : []),
@@ -548,7 +549,7 @@ export class SyncManager {
         contentEncrypted: '',
         contentIV: '',
         domain: note.domain,
-        date: new Date(note.date),
+        date: toNoteDay(note.date),
         isPinned: false,
         collectionIds: [],
         createdAt: new Date(),
@@ -34,3 +34,25 @@ export const isNoteEmpty = (note: Note): boolean => {
   const hasNoContent = !note.content.trim();
   return (hasDefaultTitle || hasNoTitle) && hasNoContent;
 };
+
+/**
+ * Get the local calendar day of a note's date as YYYY-MM-DD, the format sync sends
+ */
+export const toNoteDay = (date: string): string => {
+  const d = new Date(date);
+  const month = String(d.getMonth() + 1).padStart(2, '0');
+  const day = String(d.getDate()).padStart(2, '0');
+  return `${d.getFullYear()}-${month}-${day}`;
+};
+
+/**
+ * Turn a synced YYYY-MM-DD day into local midnight of that day
+ */
+export const fromNoteDay = (day: string): string => {
+  const match = /^(\d{4})-(\d{2})-(\d{2})$/.exec(day);
+  if (!match) {
+    return new Date(day).toISOString();
+  }
+  const [, year, month, date] = match;
+  return new Date(Number(year), Number(month) - 1, Number(date)).toISOString();
+};